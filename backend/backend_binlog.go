@@ -0,0 +1,82 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+//StartBinlogDump dumps the binlog events from pos of file as a slave of
+//serverId, the events are read by ReadBinlogEvent. The master sends a
+//heartbeat event if there is no event in heartbeat, so the read timeout
+//of c must be longer than heartbeat.
+func (c *Conn) StartBinlogDump(serverId uint32, file string, pos uint32, heartbeat time.Duration) error {
+	c.binlogChecksum = false
+	//binlog_checksum is not in mysql 5.5
+	r, err := c.exec("select @@global.binlog_checksum")
+	if err == nil && r.Resultset != nil && 0 < r.RowNumber() {
+		alg, _ := r.GetString(0, 0)
+		if len(alg) != 0 && !strings.EqualFold(alg, "NONE") {
+			//the master refuses the slave which does not know the checksum
+			if _, err = c.exec("set @master_binlog_checksum = @@global.binlog_checksum"); err != nil {
+				return err
+			}
+			c.binlogChecksum = true
+		}
+	}
+	if 0 < heartbeat {
+		if _, err = c.exec(fmt.Sprintf("set @master_heartbeat_period = %d", heartbeat.Nanoseconds())); err != nil {
+			return err
+		}
+	}
+
+	c.pkg.Sequence = 0
+	data := make([]byte, 4, 15+len(file))
+	data = append(data, mysql.COM_BINLOG_DUMP)
+	data = append(data, mysql.Uint32ToBytes(pos)...)
+	data = append(data, 0, 0) //flags
+	data = append(data, mysql.Uint32ToBytes(serverId)...)
+	data = append(data, file...)
+	return c.writePacket(data)
+}
+
+//ReadBinlogEvent returns the next event of the binlog dump, which is the
+//event header and body without checksum
+func (c *Conn) ReadBinlogEvent() ([]byte, error) {
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	switch data[0] {
+	case mysql.OK_HEADER:
+		data = data[1:]
+		if c.binlogChecksum {
+			if len(data) < mysql.BinlogEventHeaderSize+4 {
+				return nil, fmt.Errorf("invalid binlog event length %d", len(data))
+			}
+			data = data[:len(data)-4]
+		}
+		return data, nil
+	case mysql.ERR_HEADER:
+		return nil, c.handleErrorPacket(data)
+	case mysql.EOF_HEADER:
+		return nil, fmt.Errorf("binlog dump is closed by %s", c.addr)
+	}
+	return nil, fmt.Errorf("invalid binlog packet header %x", data[0])
+}
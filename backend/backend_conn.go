@@ -16,10 +16,17 @@ package backend
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -30,6 +37,29 @@ var (
 	pingPeriod = int64(time.Second * 16)
 )
 
+const (
+	DefaultConnectTimeout  = 3 * time.Second
+	DefaultWriteTimeout    = 30 * time.Second
+	DefaultKeepAlivePeriod = 30 * time.Second
+)
+
+//the timeouts of a conn to mysql server, 0 means no timeout. Read is the
+//max time to wait for a packet, so it limits the time of a query too.
+type Timeouts struct {
+	Connect   time.Duration //dial and handshake
+	Read      time.Duration
+	Write     time.Duration
+	KeepAlive time.Duration //the period of tcp keepalive, 0 means the os default
+}
+
+//the auth more data of caching_sha2_password
+const (
+	cachingSha2MoreData         = 0x01
+	cachingSha2PublicKeyRequest = 0x02
+	cachingSha2FastAuthOK       = 0x03
+	cachingSha2FullAuth         = 0x04
+)
+
 //proxy <-> mysql server
 type Conn struct {
 	conn net.Conn
@@ -51,6 +81,35 @@ type Conn struct {
 
 	pushTimestamp int64
 	pkgErr        error
+
+	connectionId uint32 //the thread id in mysql, used by KILL QUERY
+
+	timeouts  Timeouts
+	transport Transport
+	//the charset used by Connect, utf8 if it is empty
+	defaultCharset   string
+	defaultCollation mysql.CollationId
+
+	binlogChecksum bool //the binlog events end with crc32, see StartBinlogDump
+
+	vars map[string]string //the session variables set by SetVariables
+
+	attrs []mysql.QueryAttribute //set by SetQueryAttributes
+
+	info ServerInfo //got from the initial handshake
+}
+
+func (c *Conn) SetTimeouts(timeouts Timeouts) {
+	c.timeouts = timeouts
+}
+
+func (c *Conn) SetTransport(transport Transport) {
+	c.transport = transport
+}
+
+func (c *Conn) SetDefaultCharset(charset string, collation mysql.CollationId) {
+	c.defaultCharset = charset
+	c.defaultCollation = collation
 }
 
 func (c *Conn) Connect(addr string, user string, password string, db string) error {
@@ -59,9 +118,13 @@ func (c *Conn) Connect(addr string, user string, password string, db string) err
 	c.password = password
 	c.db = db
 
-	//use utf8
-	c.collation = mysql.DEFAULT_COLLATION_ID
-	c.charset = mysql.DEFAULT_CHARSET
+	//use utf8 unless the default charset is set
+	c.collation = c.defaultCollation
+	c.charset = c.defaultCharset
+	if len(c.charset) == 0 {
+		c.collation = mysql.DEFAULT_COLLATION_ID
+		c.charset = mysql.DEFAULT_CHARSET
+	}
 
 	return c.ReConnect()
 }
@@ -76,23 +139,38 @@ func (c *Conn) ReConnect() error {
 		n = "unix"
 	}
 
-	netConn, err := net.Dial(n, c.addr)
+	netConn, err := c.transport.dial(n, c.addr, c.timeouts.Connect)
 	if err != nil {
 		return err
 	}
 
-	tcpConn := netConn.(*net.TCPConn)
+	//the unix socket and the ssh tunnel are not tcp
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
+		//SetNoDelay controls whether the operating system should delay packet transmission
+		// in hopes of sending fewer packets (Nagle's algorithm).
+		// The default is true (no delay),
+		// meaning that data is sent as soon as possible after a Write.
+		//I set this option false.
+		tcpConn.SetNoDelay(false)
+		tcpConn.SetKeepAlive(true)
+		if 0 < c.timeouts.KeepAlive {
+			tcpConn.SetKeepAlivePeriod(c.timeouts.KeepAlive)
+		}
+	}
+	c.conn = netConn
+	c.pkg = mysql.NewPacketIO(netConn)
+	if c.transport.Recorder != nil {
+		c.pkg.Recorder = c.transport.Recorder.NewConn("backend "+c.addr, true)
+	}
 
-	//SetNoDelay controls whether the operating system should delay packet transmission
-	// in hopes of sending fewer packets (Nagle's algorithm).
-	// The default is true (no delay),
-	// meaning that data is sent as soon as possible after a Write.
-	//I set this option false.
-	tcpConn.SetNoDelay(false)
-	tcpConn.SetKeepAlive(true)
-	c.conn = tcpConn
-	c.pkg = mysql.NewPacketIO(tcpConn)
+	//a black-holed server must not hang the handshake
+	if 0 < c.timeouts.Connect {
+		c.conn.SetDeadline(time.Now().Add(c.timeouts.Connect))
+	}
 
+	//the salt of the last connection is dropped, such as a retry with
+	//the secondary password
+	c.salt = nil
 	if err := c.readInitialHandshake(); err != nil {
 		c.conn.Close()
 		return err
@@ -104,11 +182,13 @@ func (c *Conn) ReConnect() error {
 		return err
 	}
 
-	if _, err := c.readOK(); err != nil {
+	if err := c.readAuthResult(); err != nil {
 		c.conn.Close()
 
 		return err
 	}
+	c.conn.SetDeadline(time.Time{})
+	c.vars = nil
 
 	//we must always use autocommit
 	if !c.IsAutoCommit() {
@@ -133,13 +213,25 @@ func (c *Conn) Close() error {
 	return nil
 }
 
+//IsBroken tells whether the last read or write of the conn failed, such
+//as the backend is gone
+func (c *Conn) IsBroken() bool {
+	return c.pkgErr != nil
+}
+
 func (c *Conn) readPacket() ([]byte, error) {
+	if 0 < c.timeouts.Read && c.conn != nil {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeouts.Read))
+	}
 	d, err := c.pkg.ReadPacket()
 	c.pkgErr = err
 	return d, err
 }
 
 func (c *Conn) writePacket(data []byte) error {
+	if 0 < c.timeouts.Write && c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeouts.Write))
+	}
 	err := c.pkg.WritePacket(data)
 	c.pkgErr = err
 	return err
@@ -151,18 +243,24 @@ func (c *Conn) readInitialHandshake() error {
 		return err
 	}
 
+	//such as ER_CON_COUNT_ERROR if the server has too many connections
 	if data[0] == mysql.ERR_HEADER {
-		return errors.New("read initial handshake error")
+		return c.handleErrorPacket(data)
 	}
 
 	if data[0] < mysql.MinProtocolVersion {
 		return fmt.Errorf("invalid protocol version %d, must >= 10", data[0])
 	}
 
-	//skip mysql version and connection id
 	//mysql version end with 0x00
+	end := 1 + bytes.IndexByte(data[1:], 0x00)
+	c.info = ServerInfo{Version: string(data[1:end])}
+	c.info.parseVersion()
+	pos := end + 1
+
 	//connection id length is 4
-	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	c.connectionId = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
 
 	c.salt = append(c.salt, data[pos:pos+8]...)
 
@@ -175,8 +273,7 @@ func (c *Conn) readInitialHandshake() error {
 	pos += 2
 
 	if len(data) > pos {
-		//skip server charset
-		//c.charset = data[pos]
+		c.info.Collation = mysql.CollationId(data[pos])
 		pos += 1
 
 		c.status = binary.LittleEndian.Uint16(data[pos : pos+2])
@@ -195,17 +292,52 @@ func (c *Conn) readInitialHandshake() error {
 		// mysql-proxy also use 12
 		// which is not documented but seems to work.
 		c.salt = append(c.salt, data[pos:pos+12]...)
+		pos += 12 + 1
+
+		//the name of auth plugin end with 0x00
+		if c.capability&mysql.CLIENT_PLUGIN_AUTH != 0 && pos < len(data) {
+			plugin := data[pos:]
+			if end := bytes.IndexByte(plugin, 0x00); end != -1 {
+				plugin = plugin[:end]
+			}
+			c.info.AuthPlugin = string(plugin)
+		}
 	}
+	c.info.Capability = c.capability
 
 	return nil
 }
 
+//the auth plugin used, caching_sha2_password if it is the default of
+//server, otherwise mysql_native_password
+func (c *Conn) authPlugin() string {
+	if c.info.AuthPlugin == mysql.CACHING_SHA2_AUTH_NAME {
+		return mysql.CACHING_SHA2_AUTH_NAME
+	}
+	return mysql.AUTH_NAME
+}
+
+func (c *Conn) authResponse(plugin string) []byte {
+	if plugin == mysql.CACHING_SHA2_AUTH_NAME {
+		return mysql.CalcCachingSha2Password(c.salt, []byte(c.password))
+	}
+	return mysql.CalcPassword(c.salt, []byte(c.password))
+}
+
 func (c *Conn) writeAuthHandshake() error {
 	// Adjust client capability flags based on server support
 	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
-		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG
+		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG |
+		mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_DEPRECATE_EOF | mysql.CLIENT_SESSION_TRACK |
+		mysql.CLIENT_QUERY_ATTRIBUTES
 
 	capability &= c.capability
+	if c.transport.TLS != nil {
+		if c.capability&mysql.CLIENT_SSL == 0 {
+			return errors.New("server does not support ssl")
+		}
+		capability |= mysql.CLIENT_SSL
+	}
 
 	//packet length
 	//capbility 4
@@ -218,7 +350,8 @@ func (c *Conn) writeAuthHandshake() error {
 	length += len(c.user) + 1
 
 	//we only support secure connection
-	auth := mysql.CalcPassword(c.salt, []byte(c.password))
+	plugin := c.authPlugin()
+	auth := c.authResponse(plugin)
 
 	length += 1 + len(auth)
 
@@ -228,6 +361,10 @@ func (c *Conn) writeAuthHandshake() error {
 		length += len(c.db) + 1
 	}
 
+	if capability&mysql.CLIENT_PLUGIN_AUTH != 0 {
+		length += len(plugin) + 1
+	}
+
 	c.capability = capability
 
 	data := make([]byte, length+4)
@@ -263,13 +400,140 @@ func (c *Conn) writeAuthHandshake() error {
 
 	// db [null terminated string]
 	if len(c.db) > 0 {
-		pos += copy(data[pos:], c.db)
+		pos += copy(data[pos:], c.db) + 1
 		//data[pos] = 0x00
 	}
 
+	// auth plugin [null terminated string]
+	if capability&mysql.CLIENT_PLUGIN_AUTH != 0 {
+		copy(data[pos:], plugin)
+	}
+
+	if c.transport.TLS != nil {
+		if err := c.startTLS(data[:4+4+4+1+23]); err != nil {
+			return err
+		}
+	}
 	return c.writePacket(data)
 }
 
+//send the ssl request, which is the head of the handshake response, and
+//switch the conn to tls. The handshake response follows over tls.
+func (c *Conn) startTLS(sslRequest []byte) error {
+	if err := c.writePacket(sslRequest); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, tlsConfigFor(c.transport.TLS, c.addr))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	sequence := c.pkg.Sequence
+	recorder := c.pkg.Recorder
+	c.conn = tlsConn
+	c.pkg = mysql.NewPacketIO(tlsConn)
+	c.pkg.Sequence = sequence
+	c.pkg.Recorder = recorder
+	return nil
+}
+
+//read the result of auth. The server may switch the auth plugin of the
+//user, or ask for the full authentication of caching_sha2_password if
+//the password is not cached in the server.
+func (c *Conn) readAuthResult() error {
+	plugin := c.authPlugin()
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+
+		switch data[0] {
+		case mysql.OK_HEADER:
+			_, err = c.handleOKPacket(data)
+			return err
+		case mysql.ERR_HEADER:
+			return c.handleErrorPacket(data)
+		case mysql.EOF_HEADER:
+			//auth switch request: plugin name [0x00] salt [0x00]
+			end := bytes.IndexByte(data[1:], 0x00)
+			if end == -1 {
+				return errors.New("invalid auth switch request")
+			}
+			plugin = string(data[1 : 1+end])
+			if plugin != mysql.AUTH_NAME && plugin != mysql.CACHING_SHA2_AUTH_NAME {
+				return fmt.Errorf("auth plugin %s is not supported", plugin)
+			}
+			c.salt = bytes.TrimSuffix(data[2+end:], []byte{0x00})
+			if err = c.writeAuthData(c.authResponse(plugin)); err != nil {
+				return err
+			}
+		case cachingSha2MoreData:
+			if plugin != mysql.CACHING_SHA2_AUTH_NAME || len(data) != 2 {
+				return errors.New("invalid auth more data")
+			}
+			switch data[1] {
+			case cachingSha2FastAuthOK:
+				//the ok packet follows
+			case cachingSha2FullAuth:
+				if err = c.writeCachingSha2FullAuth(); err != nil {
+					return err
+				}
+			default:
+				return errors.New("invalid auth more data")
+			}
+		default:
+			return errors.New("invalid ok packet")
+		}
+	}
+}
+
+func (c *Conn) writeAuthData(auth []byte) error {
+	data := make([]byte, 4, 4+len(auth))
+	data = append(data, auth...)
+	return c.writePacket(data)
+}
+
+//the password is sent in clear text over tls, or encrypted by the rsa
+//public key of server
+func (c *Conn) writeCachingSha2FullAuth() error {
+	if c.transport.TLS != nil {
+		return c.writeAuthData(append([]byte(c.password), 0))
+	}
+	if err := c.writeAuthData([]byte{cachingSha2PublicKeyRequest}); err != nil {
+		return err
+	}
+	data, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if data[0] == mysql.ERR_HEADER {
+		return c.handleErrorPacket(data)
+	}
+	block, _ := pem.Decode(data[1:])
+	if data[0] != cachingSha2MoreData || block == nil {
+		return errors.New("invalid rsa public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("invalid rsa public key")
+	}
+
+	//XOR(password + 0x00, salt)
+	plain := append([]byte(c.password), 0x00)
+	for i := range plain {
+		plain[i] ^= c.salt[i%len(c.salt)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, plain, nil)
+	if err != nil {
+		return err
+	}
+	return c.writeAuthData(enc)
+}
+
 func (c *Conn) writeCommand(command byte) error {
 	c.pkg.Sequence = 0
 
@@ -301,15 +565,27 @@ func (c *Conn) writeCommandStr(command byte, arg string) error {
 
 	length := len(arg) + 1
 
-	data := make([]byte, length+4)
+	data := make([]byte, 5, length+4)
 
 	data[4] = command
 
-	copy(data[5:], arg)
+	//the query attributes precede the query
+	if command == mysql.COM_QUERY && c.capability&mysql.CLIENT_QUERY_ATTRIBUTES != 0 {
+		data = mysql.AppendQueryAttributes(data, c.attrs)
+	}
+
+	data = append(data, arg...)
 
 	return c.writePacket(data)
 }
 
+//SetQueryAttributes sets the query attributes sent with the queries and
+//the prepared statements executed by the conn, until they are set again.
+//They are dropped if the server does not support query attributes.
+func (c *Conn) SetQueryAttributes(attrs []mysql.QueryAttribute) {
+	c.attrs = attrs
+}
+
 func (c *Conn) writeCommandUint32(command byte, arg uint32) error {
 	c.pkg.Sequence = 0
 
@@ -435,6 +711,10 @@ func (c *Conn) SetCharset(charset string, collation mysql.CollationId) error {
 	if collation == 0 {
 		collation = mysql.CollationNames[mysql.Charsets[charset]]
 	}
+	//the old server without utf8mb4 uses utf8
+	if charset == "utf8mb4" && !c.info.SupportUtf8mb4() {
+		charset, collation = mysql.DEFAULT_CHARSET, mysql.DEFAULT_COLLATION_ID
+	}
 
 	if c.charset == charset && c.collation == collation {
 		return nil
@@ -459,6 +739,54 @@ func (c *Conn) SetCharset(charset string, collation mysql.CollationId) error {
 	}
 }
 
+//SetVariables sets the session variables of the conn to vars, name ->
+//value such as time_zone -> '+08:00' or @v -> 1. The variables set before
+//but not in vars are reset, the user variables to NULL and the others to
+//DEFAULT. The variables already set to the same values are skipped.
+func (c *Conn) SetVariables(vars map[string]string) error {
+	var items []string
+	for name, value := range vars {
+		if c.vars[name] != value {
+			items = append(items, c.info.variableName(name)+" = "+value)
+		}
+	}
+	for name := range c.vars {
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		if strings.HasPrefix(name, "@") {
+			items = append(items, name+" = NULL")
+		} else {
+			items = append(items, c.info.variableName(name)+" = DEFAULT")
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	sort.Strings(items)
+	if _, err := c.exec("SET " + strings.Join(items, ", ")); err != nil {
+		return err
+	}
+
+	c.vars = make(map[string]string, len(vars))
+	for name, value := range vars {
+		c.vars[name] = value
+	}
+	return nil
+}
+
+//ServerInfo is the version and capabilities of server got from the
+//initial handshake of the conn
+func (c *Conn) ServerInfo() ServerInfo {
+	return c.info
+}
+
+//HasVariables return true if the conn has the session variables set by
+//SetVariables
+func (c *Conn) HasVariables() bool {
+	return len(c.vars) != 0
+}
+
 func (c *Conn) FieldList(table string, wildcard string) ([]*mysql.Field, error) {
 	if err := c.writeCommandStrStr(mysql.COM_FIELD_LIST, table, wildcard); err != nil {
 		return nil, err
@@ -501,6 +829,78 @@ func (c *Conn) exec(query string) (*mysql.Result, error) {
 	return c.readResult(false)
 }
 
+//ExecuteRows executes query and calls fn with each row of the resultset,
+//the rows are not kept in memory, such as a dump of a big table. If fn
+//returns an error, such as the client is too slow to read, the rows left
+//are not fetched and the conn is broken, so it is closed when released.
+func (c *Conn) ExecuteRows(query string, fn func(fields []*mysql.Field, row []interface{}) error) error {
+	var fields []*mysql.Field
+	return c.ExecuteRowDatas(query, func(f []*mysql.Field) error {
+		fields = f
+		return nil
+	}, func(data mysql.RowData) error {
+		row, err := data.ParseText(fields)
+		if err != nil {
+			return err
+		}
+		return fn(fields, row)
+	})
+}
+
+//ExecuteRowDatas is ExecuteRows with the rows in the text protocol as they
+//are read, fieldsFn is called once the fields are read, even if there is
+//no row. It is not called for a query without resultset.
+func (c *Conn) ExecuteRowDatas(query string, fieldsFn func(fields []*mysql.Field) error,
+	rowFn func(row mysql.RowData) error) error {
+	if err := c.writeCommandStr(mysql.COM_QUERY, query); err != nil {
+		return err
+	}
+	data, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	switch data[0] {
+	case mysql.OK_HEADER:
+		_, err = c.handleOKPacket(data)
+		return err
+	case mysql.ERR_HEADER:
+		return c.handleErrorPacket(data)
+	}
+
+	count, _, n := mysql.LengthEncodedInt(data)
+	if n-len(data) != 0 {
+		return mysql.ErrMalformPacket
+	}
+	result := &mysql.Result{Resultset: &mysql.Resultset{}}
+	result.Fields = make([]*mysql.Field, count)
+	result.FieldNames = make(map[string]int, count)
+	if err = c.readResultColumns(result); err != nil {
+		return err
+	}
+	if err = fieldsFn(result.Fields); err != nil {
+		c.pkgErr = mysql.ErrBadConn
+		return err
+	}
+
+	for {
+		data, err = c.readPacket()
+		if err != nil {
+			return err
+		}
+		if c.isEOFPacket(data) {
+			_, err = c.handleEOFPacket(data)
+			return err
+		}
+		if data[0] == mysql.ERR_HEADER {
+			return c.handleErrorPacket(data)
+		}
+		if err = rowFn(mysql.RowData(data)); err != nil {
+			c.pkgErr = mysql.ErrBadConn
+			return err
+		}
+	}
+}
+
 func (c *Conn) readResultset(data []byte, binary bool) (*mysql.Result, error) {
 	result := &mysql.Result{
 		Status:       0,
@@ -536,16 +936,20 @@ func (c *Conn) readResultColumns(result *mysql.Result) (err error) {
 	var data []byte
 
 	for {
+		//the columns end without EOF packet
+		if c.deprecateEOF() && i == len(result.Fields) {
+			return
+		}
+
 		data, err = c.readPacket()
 		if err != nil {
 			return
 		}
 
 		// EOF Packet
-		if c.isEOFPacket(data) {
+		if !c.deprecateEOF() && c.isEOFPacket(data) {
 			if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-				//result.Warnings = binary.LittleEndian.Uint16(data[1:])
-				//todo add strict_mode, warning will be treat as error
+				result.Warnings = binary.LittleEndian.Uint16(data[1:])
 				result.Status = binary.LittleEndian.Uint16(data[3:])
 				c.status = result.Status
 			}
@@ -556,6 +960,9 @@ func (c *Conn) readResultColumns(result *mysql.Result) (err error) {
 
 			return
 		}
+		if i == len(result.Fields) {
+			return mysql.ErrMalformPacket
+		}
 
 		result.Fields[i], err = mysql.FieldData(data).Parse()
 		if err != nil {
@@ -580,15 +987,19 @@ func (c *Conn) readResultRows(result *mysql.Result, isBinary bool) (err error) {
 
 		// EOF Packet
 		if c.isEOFPacket(data) {
-			if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-				//result.Warnings = binary.LittleEndian.Uint16(data[1:])
-				//todo add strict_mode, warning will be treat as error
-				result.Status = binary.LittleEndian.Uint16(data[3:])
-				c.status = result.Status
+			var r *mysql.Result
+			if r, err = c.handleEOFPacket(data); err != nil {
+				return
 			}
-
+			result.Warnings = r.Warnings
+			result.Status = r.Status
 			break
 		}
+		//the error in the rows, such as the query is killed, a row never
+		//starts with 0xff
+		if data[0] == mysql.ERR_HEADER {
+			return c.handleErrorPacket(data)
+		}
 
 		result.RowDatas = append(result.RowDatas, data)
 	}
@@ -606,28 +1017,55 @@ func (c *Conn) readResultRows(result *mysql.Result, isBinary bool) (err error) {
 	return nil
 }
 
-func (c *Conn) readUntilEOF() (err error) {
-	var data []byte
-
-	for {
-		data, err = c.readPacket()
-
-		if err != nil {
-			return
-		}
-
-		// EOF Packet
-		if c.isEOFPacket(data) {
-			return
+//read the count definitions of params or columns of a prepared statement,
+//which end with an EOF packet unless CLIENT_DEPRECATE_EOF is negotiated
+func (c *Conn) readDefinitions(count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := c.readPacket(); err != nil {
+			return err
 		}
 	}
-	return
+	if c.deprecateEOF() {
+		return nil
+	}
+	data, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if !c.isEOFPacket(data) {
+		return mysql.ErrMalformPacket
+	}
+	return nil
+}
+
+//with CLIENT_DEPRECATE_EOF, the rows end with an OK packet of 0xfe header
+//instead of EOF, a row never starts with 0xfe unless it is a packet of
+//16M bytes or more
+func (c *Conn) deprecateEOF() bool {
+	return c.capability&mysql.CLIENT_DEPRECATE_EOF != 0
 }
 
 func (c *Conn) isEOFPacket(data []byte) bool {
+	if c.deprecateEOF() {
+		return data[0] == mysql.EOF_HEADER && len(data) < mysql.MaxPayloadLen
+	}
 	return data[0] == mysql.EOF_HEADER && len(data) <= 5
 }
 
+//the warnings and status of the packet ending the rows
+func (c *Conn) handleEOFPacket(data []byte) (*mysql.Result, error) {
+	if c.deprecateEOF() {
+		return c.handleOKPacket(data)
+	}
+	r := new(mysql.Result)
+	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 && 5 <= len(data) {
+		r.Warnings = binary.LittleEndian.Uint16(data[1:])
+		r.Status = binary.LittleEndian.Uint16(data[3:])
+		c.status = r.Status
+	}
+	return r, nil
+}
+
 func (c *Conn) handleOKPacket(data []byte) (*mysql.Result, error) {
 	var n int
 	var pos int = 1
@@ -644,16 +1082,28 @@ func (c *Conn) handleOKPacket(data []byte) (*mysql.Result, error) {
 		c.status = r.Status
 		pos += 2
 
-		//todo:strict_mode, check warnings as error
-		//Warnings := binary.LittleEndian.Uint16(data[pos:])
-		//pos += 2
+		r.Warnings = binary.LittleEndian.Uint16(data[pos:])
+		pos += 2
 	} else if c.capability&mysql.CLIENT_TRANSACTIONS > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		c.status = r.Status
 		pos += 2
 	}
 
-	//info
+	//with CLIENT_SESSION_TRACK, the info is a length encoded string, and
+	//the session state changes follow, which are tracked by kingshard
+	//itself and skipped
+	if c.capability&mysql.CLIENT_SESSION_TRACK != 0 {
+		if pos < len(data) {
+			info, _, _, err := mysql.LengthEnodedString(data[pos:])
+			if err != nil {
+				return nil, err
+			}
+			r.Info = string(info)
+		}
+	} else if pos < len(data) {
+		r.Info = string(data[pos:])
+	}
 	return r, nil
 }
 
@@ -16,9 +16,13 @@ package backend
 
 import (
 	"fmt"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/flike/kingshard/config"
 	. "github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
 )
 
 func newTestConn() *Conn {
@@ -177,3 +181,108 @@ func TestConn_SetAutoCommit(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConn_ConnectTimeout(t *testing.T) {
+	//the server accepts the conn but never sends the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			co, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer co.Close()
+		}
+	}()
+
+	c := new(Conn)
+	c.SetTimeouts(Timeouts{Connect: 100 * time.Millisecond})
+	start := time.Now()
+	err = c.Connect(l.Addr().String(), "root", "", "")
+	if err == nil {
+		t.Fatal("connect must time out")
+	}
+	if time.Second < time.Since(start) {
+		t.Fatal(time.Since(start))
+	}
+}
+
+//the server ending the columns without EOF and the rows with OK, and
+//sending the info of OK in length encoded string
+func TestConn_DeprecateEOF(t *testing.T) {
+	s, err := mysqltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Capability = CLIENT_DEPRECATE_EOF | CLIENT_SESSION_TRACK
+	s.Handle(`select`, &mysqltest.Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{1, "a"}, {2, "b"}},
+	})
+	s.Handle(`update`, &mysqltest.Response{AffectedRows: 2, Info: "Rows matched: 2"})
+
+	c := new(Conn)
+	if err = c.Connect(s.Addr(), "root", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.capability&CLIENT_DEPRECATE_EOF == 0 || c.capability&CLIENT_SESSION_TRACK == 0 {
+		t.Fatalf("%x", c.capability)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Execute("select id, name from t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Fields) != 2 || r.RowNumber() != 2 {
+			t.Fatal(r.Fields, r.Values)
+		}
+		if name, _ := r.GetString(1, 1); name != "b" {
+			t.Fatal(name)
+		}
+	}
+	r, err := c.Execute("update t set name = 'c'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 2 || r.Info != "Rows matched: 2" {
+		t.Fatal(r)
+	}
+
+	var rows int
+	err = c.ExecuteRows("select id, name from t", func(fields []*Field, row []interface{}) error {
+		rows++
+		return nil
+	})
+	if err != nil || rows != 2 {
+		t.Fatal(err, rows)
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	timeouts := ParseTimeouts(config.NodeConfig{})
+	expect := Timeouts{
+		Connect:   DefaultConnectTimeout,
+		Write:     DefaultWriteTimeout,
+		KeepAlive: DefaultKeepAlivePeriod,
+	}
+	if timeouts != expect {
+		t.Fatal(timeouts)
+	}
+
+	timeouts = ParseTimeouts(config.NodeConfig{ConnectTimeout: 1, ReadTimeout: 60, WriteTimeout: -1})
+	expect = Timeouts{
+		Connect:   time.Second,
+		Read:      time.Minute,
+		KeepAlive: DefaultKeepAlivePeriod,
+	}
+	if timeouts != expect {
+		t.Fatal(timeouts)
+	}
+}
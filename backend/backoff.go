@@ -0,0 +1,98 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	//the backoff after a db rejects a new conn with too many connections,
+	//doubled for each rejection in a row up to MaxConnBackoff
+	MinConnBackoff = time.Second
+	MaxConnBackoff = 30 * time.Second
+)
+
+//connBackoff lowers the pool target of a db which rejects a new conn with
+//ER_CON_COUNT_ERROR to the conns in use. Until the backoff expires, the
+//conns in the pool are reused but no new conn is opened, so the clients
+//get ErrBackendBusy at once instead of all hitting the full backend.
+type connBackoff struct {
+	sync.Mutex
+
+	target  int //the max conns in use during the backoff
+	until   time.Time
+	backoff time.Duration
+	rejects int64 //the total rejections by the db
+}
+
+//a rejection when inUse conns are used, return the backoff
+func (b *connBackoff) reject(inUse int, now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	b.backoff *= 2
+	if b.backoff < MinConnBackoff {
+		b.backoff = MinConnBackoff
+	}
+	if MaxConnBackoff < b.backoff {
+		b.backoff = MaxConnBackoff
+	}
+	b.target = inUse
+	b.until = now.Add(b.backoff)
+	b.rejects++
+	return b.backoff
+}
+
+//a new conn is opened after the backoff, the backoff is reset
+func (b *connBackoff) accept(now time.Time) {
+	b.Lock()
+	if !b.until.IsZero() && !now.Before(b.until) {
+		b.backoff = 0
+		b.until = time.Time{}
+	}
+	b.Unlock()
+}
+
+//a new conn can not be opened if inUse conns reach the target during
+//the backoff
+func (b *connBackoff) isLimited(inUse int, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	return now.Before(b.until) && b.target <= inUse
+}
+
+func isConnCountError(err error) bool {
+	e, ok := err.(*mysql.SqlError)
+	return ok && e.Code == mysql.ER_CON_COUNT_ERROR
+}
+
+//ConnRejects returns the new conns rejected by the db for too many
+//connections since start
+func (db *DB) ConnRejects() int64 {
+	db.backoff.Lock()
+	defer db.backoff.Unlock()
+	return db.backoff.rejects
+}
+
+//IsBackingOff returns true if no new conn is opened to the db for its
+//too many connections
+func (db *DB) IsBackingOff() bool {
+	db.backoff.Lock()
+	defer db.backoff.Unlock()
+	return time.Now().Before(db.backoff.until)
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestConnBackoff(t *testing.T) {
+	var b connBackoff
+	now := time.Now()
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if backoff := b.reject(3, now); backoff != want {
+			t.Fatal(backoff, want)
+		}
+	}
+	if !b.isLimited(3, now) || b.isLimited(2, now) {
+		t.Fatal(b.target)
+	}
+	//a conn opened during the backoff does not reset it
+	b.accept(now)
+	if !b.isLimited(3, now) {
+		t.Fatal(b.until)
+	}
+	now = now.Add(MaxConnBackoff)
+	if b.isLimited(3, now) {
+		t.Fatal(b.until)
+	}
+	b.accept(now)
+	if backoff := b.reject(3, now); backoff != MinConnBackoff || b.rejects != 4 {
+		t.Fatal(backoff, b.rejects)
+	}
+	for i := 0; i < 10; i++ {
+		b.reject(3, now)
+	}
+	if b.backoff != MaxConnBackoff {
+		t.Fatal(b.backoff)
+	}
+}
+
+func TestDBTooManyConnections(t *testing.T) {
+	s, err := mysqltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	//the check conn and 5 conns in pool
+	db, err := Open(s.Addr(), "root", "", "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s.SetMaxConns(s.ConnCount())
+
+	var conns []*BackendConn
+	for i := 0; i < db.InitConnNum; i++ {
+		co, err := db.GetConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, co)
+	}
+	if _, err = db.GetConn(); err != errors.ErrBackendBusy {
+		t.Fatal(err)
+	}
+	if db.ConnRejects() != 1 || !db.IsBackingOff() {
+		t.Fatal(db.ConnRejects())
+	}
+	//no new conn is tried during the backoff
+	if _, err = db.GetConn(); err != errors.ErrBackendBusy || db.ConnRejects() != 1 {
+		t.Fatal(err, db.ConnRejects())
+	}
+
+	//the conns in pool are reused
+	conns[0].Close()
+	co, err := db.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns[0] = co
+	for _, co := range conns {
+		co.Close()
+	}
+}
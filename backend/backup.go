@@ -0,0 +1,130 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+)
+
+const Backup = "backup"
+
+//backupStr(127.0.0.1:3308,192.168.0.13:3306), the backups are never
+//used for normal reads, see GetBackupConn
+func (n *Node) ParseBackup(backupStr string) error {
+	backupStr = strings.Trim(backupStr, SlaveSplit+" ")
+	if len(backupStr) == 0 {
+		return nil
+	}
+
+	addrs := strings.Split(backupStr, SlaveSplit)
+	n.Backup = make([]*DB, 0, len(addrs))
+	for _, addr := range addrs {
+		n.Backup = append(n.Backup, n.openOrDownDB(strings.TrimSpace(addr)))
+	}
+	return nil
+}
+
+func (n *Node) HasBackup() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return len(n.Backup) != 0
+}
+
+//GetBackupConn return a conn of the next backup which is up, it is used for
+//reads when the master and all the slaves are down, or the backup hint.
+func (n *Node) GetBackupConn() (*BackendConn, error) {
+	n.Lock()
+	count := len(n.Backup)
+	if count == 0 {
+		n.Unlock()
+		return nil, errors.ErrNoBackupDB
+	}
+	var db *DB
+	for i := 0; i < count; i++ {
+		n.LastBackupIndex = (n.LastBackupIndex + 1) % count
+		b := n.Backup[n.LastBackupIndex]
+		if b == nil {
+			continue
+		}
+		if state := atomic.LoadInt32(&(b.state)); state != Down && state != ManualDown {
+			db = b
+			break
+		}
+	}
+	n.Unlock()
+
+	if db == nil {
+		return nil, errors.ErrNoBackupDB
+	}
+	return db.GetConn()
+}
+
+func (n *Node) checkBackup() {
+	n.RLock()
+	if n.Backup == nil {
+		n.RUnlock()
+		return
+	}
+	backups := make([]*DB, len(n.Backup))
+	copy(backups, n.Backup)
+	n.RUnlock()
+
+	for i, db := range backups {
+		if db == nil {
+			continue
+		}
+		if err := db.Ping(); err != nil {
+			golog.Error("Node", "checkBackup", "Ping", 0, "db.Addr", db.Addr(), "error", err.Error())
+		} else {
+			if atomic.LoadInt32(&(db.state)) == Down {
+				golog.Info("Node", "checkBackup", "Backup up", 0, "db.Addr", db.Addr())
+				n.upBackup(i, db.addr)
+			}
+			db.SetLastPing()
+			if atomic.LoadInt32(&(db.state)) != ManualDown {
+				atomic.StoreInt32(&(db.state), Up)
+			}
+			continue
+		}
+
+		if int64(n.DownAfterNoAlive) > 0 && time.Now().Unix()-db.GetLastPing() > int64(n.DownAfterNoAlive/time.Second) {
+			golog.Info("Node", "checkBackup", "Backup down", 0,
+				"db.Addr", db.Addr(),
+				"backup_down_time", int64(n.DownAfterNoAlive/time.Second))
+			db.Close()
+			atomic.StoreInt32(&(db.state), Down)
+		}
+	}
+}
+
+//reopen the backup at index i which is closed when it is down
+func (n *Node) upBackup(i int, addr string) {
+	db, err := n.UpDB(addr)
+	if err != nil {
+		golog.Error("Node", "upBackup", err.Error(), 0)
+		return
+	}
+
+	n.Lock()
+	if i < len(n.Backup) && n.Backup[i].addr == addr {
+		n.Backup[i] = db
+	}
+	n.Unlock()
+}
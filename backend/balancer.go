@@ -90,13 +90,21 @@ func (n *Node) GetNextSlave() (*DB, error) {
 		return n.Slave[index], nil
 	}
 
-	n.LastSlaveIndex = n.LastSlaveIndex % queueLen
-	index = n.RoundRobinQ[n.LastSlaveIndex]
-	if len(n.Slave) <= index {
-		return nil, errors.ErrNoDatabase
+	//a degraded slave is skipped with the probability of 1-health,
+	//so its effective weight is weight*health
+	var db *DB
+	for i := 0; i < queueLen; i++ {
+		n.LastSlaveIndex = n.LastSlaveIndex % queueLen
+		index = n.RoundRobinQ[n.LastSlaveIndex]
+		if len(n.Slave) <= index {
+			return nil, errors.ErrNoDatabase
+		}
+		db = n.Slave[index]
+		n.LastSlaveIndex++
+		n.LastSlaveIndex = n.LastSlaveIndex % queueLen
+		if db == nil || db.acceptRead() {
+			break
+		}
 	}
-	db := n.Slave[index]
-	n.LastSlaveIndex++
-	n.LastSlaveIndex = n.LastSlaveIndex % queueLen
 	return db, nil
 }
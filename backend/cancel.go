@@ -0,0 +1,71 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"golang.org/x/net/context"
+)
+
+//ExecuteContext executes the sql like Execute, and kills the query by
+//KILL QUERY from another conn once ctx is done, such as the client is
+//gone. The conn is closed when released after a kill, so the kill can
+//not hit the next query of the conn.
+func (p *BackendConn) ExecuteContext(ctx context.Context, command string, args ...interface{}) (*mysql.Result, error) {
+	if ctx == nil || ctx.Done() == nil {
+		return p.Execute(command, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	killed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.db.killQuery(p.Conn.connectionId)
+			killed <- true
+		case <-done:
+			killed <- false
+		}
+	}()
+	r, err := p.Execute(command, args...)
+	close(done)
+	if <-killed {
+		p.Conn.pkgErr = errors.ErrBadConn
+		return nil, ctx.Err()
+	}
+	return r, err
+}
+
+//kill the query running in the conn of id by a new conn
+func (db *DB) killQuery(id uint32) error {
+	co, err := db.newConn()
+	if err == nil {
+		_, err = co.exec(fmt.Sprintf("KILL QUERY %d", id))
+		co.Close()
+	}
+	if err != nil {
+		golog.Error("DB", "killQuery", err.Error(), 0, "addr", db.addr, "id", id)
+		return err
+	}
+	golog.Warn("DB", "killQuery", "query killed", 0, "addr", db.addr, "id", id)
+	return nil
+}
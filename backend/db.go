@@ -15,11 +15,13 @@
 package backend
 
 import (
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/mysql"
 )
 
@@ -43,39 +45,65 @@ type DB struct {
 	db       string
 	state    int32
 
+	//tried if password is denied, see connect
+	secondaryPassword string
+	timeouts          Timeouts
+	transport         Transport
+	schemaRewrite     map[string]string
+	//the charset of the conns, reset when they are reused
+	charset   string
+	collation mysql.CollationId
+
 	maxConnNum  int
 	InitConnNum int
 	idleConns   chan *Conn
 	cacheConns  chan *Conn
 	checkConn   *Conn
 	lastPing    int64
+
+	health dbHealth //the health of reads, used by balancer
+	//1 if the slave is down in other instances of the cluster, it is not
+	//read though it is up here
+	clusterDown int32
+	//the replication lag in nanoseconds measured by the heartbeat of
+	//kingshard, -1 if unknown
+	lag int64
+	//no new conn is opened during the backoff after too many connections
+	backoff connBackoff
+
+	info *ServerInfo //the server of the last conn, nil if never connected
+}
+
+//the options of the conns to a db
+type Options struct {
+	//used if the password is denied, so the password of backend can be
+	//changed without restart
+	SecondaryPassword string
+	Timeouts          Timeouts
+	Transport         Transport
+	//the databases renamed in the db, see NodeConfig.SchemaRewrite
+	SchemaRewrite map[string]string
+	//the charset of the conns, utf8 if it is empty
+	Charset   string
+	Collation mysql.CollationId
 }
 
 func Open(addr string, user string, password string, dbName string, maxConnNum int) (*DB, error) {
+	return OpenWithOptions(addr, user, password, dbName, maxConnNum, Options{})
+}
+
+func OpenWithOptions(addr string, user string, password string, dbName string,
+	maxConnNum int, opts Options) (*DB, error) {
 	var err error
-	db := new(DB)
-	db.addr = addr
-	db.user = user
-	db.password = password
-	db.db = dbName
+	db := newDB(addr, user, password, dbName, maxConnNum, opts)
 
-	if 0 < maxConnNum {
-		db.maxConnNum = maxConnNum
-		if db.maxConnNum < 16 {
-			db.InitConnNum = db.maxConnNum
-		} else {
-			db.InitConnNum = db.maxConnNum / 4
-		}
-	} else {
-		db.maxConnNum = DefaultMaxConnNum
-		db.InitConnNum = InitConnCount
-	}
 	//check connection
 	db.checkConn, err = db.newConn()
 	if err != nil {
 		db.Close()
 		return nil, err
 	}
+	db.probeGTIDMode(db.checkConn)
 
 	db.idleConns = make(chan *Conn, db.maxConnNum)
 	db.cacheConns = make(chan *Conn, db.maxConnNum)
@@ -100,6 +128,38 @@ func Open(addr string, user string, password string, dbName string, maxConnNum i
 	return db, nil
 }
 
+//newDB returns a db without conns
+func newDB(addr string, user string, password string, dbName string,
+	maxConnNum int, opts Options) *DB {
+	db := new(DB)
+	db.addr = addr
+	db.user = user
+	db.password = password
+	db.secondaryPassword = opts.SecondaryPassword
+	db.timeouts = opts.Timeouts
+	db.transport = opts.Transport
+	db.schemaRewrite = opts.SchemaRewrite
+	db.charset, db.collation = opts.Charset, opts.Collation
+	if len(db.charset) == 0 {
+		db.charset, db.collation = mysql.DEFAULT_CHARSET, mysql.DEFAULT_COLLATION_ID
+	}
+	db.db = dbName
+	db.lag = -1
+
+	if 0 < maxConnNum {
+		db.maxConnNum = maxConnNum
+		if db.maxConnNum < 16 {
+			db.InitConnNum = db.maxConnNum
+		} else {
+			db.InitConnNum = db.maxConnNum / 4
+		}
+	} else {
+		db.maxConnNum = DefaultMaxConnNum
+		db.InitConnNum = InitConnCount
+	}
+	return db
+}
+
 func (db *DB) Addr() string {
 	return db.addr
 }
@@ -123,6 +183,16 @@ func (db *DB) IdleConnCount() int {
 	return len(db.cacheConns)
 }
 
+//InUseConnCount returns the conns got from the pool and not released
+func (db *DB) InUseConnCount() int {
+	db.RLock()
+	defer db.RUnlock()
+	if db.cacheConns == nil {
+		return 0
+	}
+	return db.maxConnNum - len(db.cacheConns) - len(db.idleConns)
+}
+
 func (db *DB) Close() error {
 	db.Lock()
 	idleChannel := db.idleConns
@@ -174,6 +244,7 @@ func (db *DB) Ping() error {
 			db.checkConn = nil
 			return err
 		}
+		db.probeGTIDMode(db.checkConn)
 	}
 	err = db.checkConn.Ping()
 	if err != nil {
@@ -184,16 +255,105 @@ func (db *DB) Ping() error {
 	return nil
 }
 
+func (db *DB) closeCheckConn() {
+	if db.checkConn != nil {
+		db.checkConn.Close()
+		db.checkConn = nil
+	}
+}
+
+//Dial opens a new conn out of the pool, such as for a diagnosis, the
+//caller closes it
+func (db *DB) Dial() (*Conn, error) {
+	return db.newConn()
+}
+
 func (db *DB) newConn() (*Conn, error) {
 	co := new(Conn)
 
-	if err := co.Connect(db.addr, db.user, db.password, db.db); err != nil {
+	if err := db.connect(co); err != nil {
 		return nil, err
 	}
 
 	return co, nil
 }
 
+func (db *DB) getPasswords() (string, string) {
+	db.RLock()
+	defer db.RUnlock()
+	return db.password, db.secondaryPassword
+}
+
+//connect with the password, and with the secondary password if the
+//password is denied. The passwords are swapped if the secondary one
+//succeeds, so the following connections use the new password first.
+func (db *DB) connect(co *Conn) error {
+	co.SetTimeouts(db.timeouts)
+	co.SetTransport(db.transport)
+	co.SetDefaultCharset(db.charset, db.collation)
+	password, secondaryPassword := db.getPasswords()
+	err := co.Connect(db.addr, db.user, password, db.db)
+	if err == nil {
+		db.setServerInfo(co.ServerInfo())
+	}
+	if err == nil || len(secondaryPassword) == 0 || !isAccessDenied(err) {
+		return err
+	}
+	if err = co.Connect(db.addr, db.user, secondaryPassword, db.db); err != nil {
+		return err
+	}
+	db.setServerInfo(co.ServerInfo())
+
+	db.Lock()
+	if db.password == password {
+		db.password, db.secondaryPassword = secondaryPassword, password
+	}
+	db.Unlock()
+	golog.Warn("DB", "connect", "switch to the secondary password", 0,
+		"addr", db.addr, "user", db.user)
+	return nil
+}
+
+//ServerInfo is the version and capabilities of the server, false if it
+//is never connected
+func (db *DB) ServerInfo() (ServerInfo, bool) {
+	db.RLock()
+	defer db.RUnlock()
+	if db.info == nil {
+		return ServerInfo{}, false
+	}
+	return *db.info, true
+}
+
+//the server may be replaced by another version, such as an upgrade
+func (db *DB) setServerInfo(info ServerInfo) {
+	db.Lock()
+	if db.info != nil {
+		info.GTIDMode = db.info.GTIDMode
+	}
+	db.info = &info
+	db.Unlock()
+}
+
+//gtid_mode is ON or ON_PERMISSIVE if gtid is used, the server older than
+//5.6 has no gtid_mode
+func (db *DB) probeGTIDMode(co *Conn) {
+	var mode string
+	if r, err := co.Execute("select @@global.gtid_mode"); err == nil && r.Resultset != nil {
+		mode, _ = r.GetString(0, 0)
+	}
+	db.Lock()
+	if db.info != nil {
+		db.info.GTIDMode = strings.HasPrefix(strings.ToUpper(mode), "ON")
+	}
+	db.Unlock()
+}
+
+func isAccessDenied(err error) bool {
+	e, ok := err.(*mysql.SqlError)
+	return ok && e.Code == mysql.ER_ACCESS_DENIED_ERROR
+}
+
 func (db *DB) closeConn(co *Conn) error {
 	if co != nil {
 		co.Close()
@@ -230,9 +390,9 @@ func (db *DB) tryReuse(co *Conn) error {
 	}
 
 	//connection may be set names early
-	//we must use default utf8
-	if co.GetCharset() != mysql.DEFAULT_CHARSET {
-		err = co.SetCharset(mysql.DEFAULT_CHARSET, mysql.DEFAULT_COLLATION_ID)
+	//we must use the default charset of db
+	if co.GetCharset() != db.charset {
+		err = co.SetCharset(db.charset, db.collation)
 		if err != nil {
 			return err
 		}
@@ -290,11 +450,24 @@ func (db *DB) GetConnFromIdle(cacheConns, idleConns chan *Conn) (*Conn, error) {
 	var err error
 	select {
 	case co = <-idleConns:
-		err = co.Connect(db.addr, db.user, db.password, db.db)
+		//the conn taken is counted in use
+		if db.backoff.isLimited(db.InUseConnCount()-1, time.Now()) {
+			db.closeConn(co)
+			return nil, errors.ErrBackendBusy
+		}
+		err = db.connect(co)
 		if err != nil {
 			db.closeConn(co)
+			if isConnCountError(err) {
+				inUse := db.InUseConnCount()
+				backoff := db.backoff.reject(inUse, time.Now())
+				golog.Error("DB", "GetConnFromIdle", "too many connections, back off", 0,
+					"addr", db.addr, "in_use", inUse, "backoff", backoff.String())
+				return nil, errors.ErrBackendBusy
+			}
 			return nil, err
 		}
+		db.backoff.accept(time.Now())
 		return co, nil
 	case co = <-cacheConns:
 		if co == nil {
@@ -337,25 +510,72 @@ func (db *DB) PushConn(co *Conn, err error) {
 type BackendConn struct {
 	*Conn
 	db *DB
+
+	release func() //called once when the conn is closed
 }
 
 func (p *BackendConn) Close() {
 	if p != nil && p.Conn != nil {
+		//the conn in pool has no session variable or query attribute of
+		//the client
+		p.Conn.SetQueryAttributes(nil)
+		if p.Conn.pkgErr == nil && p.Conn.HasVariables() {
+			if err := p.Conn.SetVariables(nil); err != nil {
+				p.Conn.pkgErr = err
+			}
+		}
 		if p.Conn.pkgErr != nil {
 			p.db.closeConn(p.Conn)
 		} else {
 			p.db.PushConn(p.Conn, nil)
 		}
 		p.Conn = nil
+		if p.release != nil {
+			p.release()
+			p.release = nil
+		}
 	}
 }
 
+//Discard closes the conn instead of pushing it back to the pool, such as
+//a conn to the master which becomes read only
+func (p *BackendConn) Discard() {
+	if p != nil && p.Conn != nil {
+		p.Conn.pkgErr = errors.ErrBadConn
+		p.Close()
+	}
+}
+
+//SetRelease set the func called when the conn is closed, such as
+//releasing the quota of the user
+func (p *BackendConn) SetRelease(release func()) {
+	p.release = release
+}
+
+//UseDB uses the database renamed by the schema rewrite of db, if any
+func (p *BackendConn) UseDB(dbName string) error {
+	return p.Conn.UseDB(p.RewriteDB(dbName))
+}
+
+//RewriteDB return the name of database dbName in the db
+func (p *BackendConn) RewriteDB(dbName string) string {
+	if to, ok := p.db.schemaRewrite[dbName]; ok {
+		return to
+	}
+	return dbName
+}
+
+//SchemaRewrite return the databases renamed in the db, nil if none
+func (p *BackendConn) SchemaRewrite() map[string]string {
+	return p.db.schemaRewrite
+}
+
 func (db *DB) GetConn() (*BackendConn, error) {
 	c, err := db.PopConn()
 	if err != nil {
 		return nil, err
 	}
-	return &BackendConn{c, db}, nil
+	return &BackendConn{Conn: c, db: db}, nil
 }
 
 func (db *DB) SetLastPing() {
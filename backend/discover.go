@@ -0,0 +1,192 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	SlaveHostsSql        = "SHOW SLAVE HOSTS"
+	ReplicationStatusSql = "SELECT SERVICE_STATE FROM performance_schema.replication_connection_status"
+)
+
+//discoverSlaves keep the slave list current with the replicas of master,
+//a replica is found by SHOW SLAVE HOSTS of master, and used only if its
+//replication is running. The slaves in config are pinned and never removed,
+//and the hosts in discovery_exclude are never added.
+func (n *Node) discoverSlaves() {
+	if !n.Cfg.SlaveDiscovery {
+		return
+	}
+
+	hosts, err := n.getSlaveHosts()
+	if err != nil {
+		golog.Error("Node", "discoverSlaves", err.Error(), 0, "node", n.Cfg.Name)
+		return
+	}
+	found := make([]string, 0, len(hosts))
+	for _, addr := range hosts {
+		if isExcluded(n.Cfg.DiscoveryExclude, addr) {
+			continue
+		}
+		if !n.isReplicationRunning(addr) {
+			golog.Warn("Node", "discoverSlaves", "replication is not running", 0,
+				"node", n.Cfg.Name, "addr", addr)
+			continue
+		}
+		found = append(found, addr)
+	}
+
+	if n.discovered == nil {
+		n.discovered = make(map[string]bool)
+	}
+	add, del := diffSlaves(n.slaveAddrs(), n.discovered, found)
+	for _, addr := range add {
+		if err := n.AddSlave(addr); err != nil {
+			golog.Error("Node", "discoverSlaves", err.Error(), 0, "node", n.Cfg.Name, "addr", addr)
+			continue
+		}
+		n.discovered[addr] = true
+		golog.Info("Node", "discoverSlaves", "add slave", 0, "node", n.Cfg.Name, "addr", addr)
+	}
+	for _, addr := range del {
+		if err := n.DeleteSlave(addr); err != nil && err != errors.ErrSlaveNotExist {
+			golog.Error("Node", "discoverSlaves", err.Error(), 0, "node", n.Cfg.Name, "addr", addr)
+			continue
+		}
+		delete(n.discovered, addr)
+		golog.Info("Node", "discoverSlaves", "delete slave", 0, "node", n.Cfg.Name, "addr", addr)
+	}
+}
+
+func (n *Node) slaveAddrs() []string {
+	n.RLock()
+	defer n.RUnlock()
+	addrs := make([]string, 0, len(n.Slave))
+	for _, db := range n.Slave {
+		if db != nil {
+			addrs = append(addrs, db.addr)
+		}
+	}
+	return addrs
+}
+
+func (n *Node) getSlaveHosts() ([]string, error) {
+	co, err := n.GetMasterConn()
+	if err != nil {
+		return nil, err
+	}
+	defer co.Close()
+
+	r, err := co.Execute(SlaveHostsSql)
+	if err != nil {
+		return nil, err
+	}
+	return parseSlaveHosts(r.Resultset)
+}
+
+//the replica is used only if its replication connection is on, mysql
+//before 5.7 has no such table, then the replica is trusted.
+func (n *Node) isReplicationRunning(addr string) bool {
+	co := new(Conn)
+	co.SetTimeouts(n.Timeouts)
+	co.SetTransport(n.Transport)
+	err := co.Connect(addr, n.Cfg.User, n.Cfg.Password, "")
+	if err != nil && len(n.Cfg.SecondaryPassword) != 0 && isAccessDenied(err) {
+		err = co.Connect(addr, n.Cfg.User, n.Cfg.SecondaryPassword, "")
+	}
+	if err != nil {
+		golog.Error("Node", "isReplicationRunning", err.Error(), 0, "addr", addr)
+		return false
+	}
+	defer co.Close()
+
+	r, err := co.Execute(ReplicationStatusSql)
+	if err != nil {
+		return true
+	}
+	for i := 0; i < r.RowNumber(); i++ {
+		if state, _ := r.GetStringByName(i, "SERVICE_STATE"); state == "ON" {
+			return true
+		}
+	}
+	return false
+}
+
+//the host:port of the rows of SHOW SLAVE HOSTS, the replica without
+//report_host has an empty Host and is ignored
+func parseSlaveHosts(r *mysql.Resultset) ([]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	hosts := make([]string, 0, r.RowNumber())
+	for i := 0; i < r.RowNumber(); i++ {
+		host, err := r.GetStringByName(i, "Host")
+		if err != nil {
+			return nil, err
+		}
+		port, err := r.GetUintByName(i, "Port")
+		if err != nil {
+			return nil, err
+		}
+		if len(host) == 0 {
+			continue
+		}
+		hosts = append(hosts, net.JoinHostPort(host, strconv.FormatUint(port, 10)))
+	}
+	return hosts, nil
+}
+
+//exclude is a list of host or host:port separated by comma
+func isExcluded(exclude string, addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	for _, v := range strings.Split(exclude, SlaveSplit) {
+		v = strings.TrimSpace(v)
+		if len(v) != 0 && (v == addr || v == host) {
+			return true
+		}
+	}
+	return false
+}
+
+//the slaves found and not in current are added, and the slaves added by
+//discovery but not found any more are deleted
+func diffSlaves(current []string, discovered map[string]bool, found []string) ([]string, []string) {
+	currentSet := make(map[string]bool, len(current))
+	for _, addr := range current {
+		currentSet[addr] = true
+	}
+	foundSet := make(map[string]bool, len(found))
+	var add, del []string
+	for _, addr := range found {
+		foundSet[addr] = true
+		if !currentSet[addr] {
+			add = append(add, addr)
+		}
+	}
+	for _, addr := range current {
+		if discovered[addr] && !foundSet[addr] {
+			del = append(del, addr)
+		}
+	}
+	return add, del
+}
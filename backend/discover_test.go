@@ -0,0 +1,116 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestParseSlaveHosts(t *testing.T) {
+	r := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, 4),
+		FieldNames: map[string]int{"Server_id": 0, "Host": 1, "Port": 2, "Master_id": 3},
+		Values: [][]interface{}{
+			{int64(2), []byte("192.168.0.12"), int64(3306), int64(1)},
+			{int64(3), []byte(""), int64(3306), int64(1)},
+			{int64(4), []byte("db3"), int64(3307), int64(1)},
+		},
+	}
+	hosts, err := parseSlaveHosts(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"192.168.0.12:3306", "db3:3307"}) {
+		t.Fatal(hosts)
+	}
+}
+
+func TestDiffSlaves(t *testing.T) {
+	if !isExcluded("192.168.0.12, db3:3307", "192.168.0.12:3306") ||
+		!isExcluded("192.168.0.12,db3:3307", "db3:3307") ||
+		isExcluded("db3:3307", "db3:3306") || isExcluded("", "db3:3306") {
+		t.Fatal("exclude")
+	}
+
+	//the pinned slave a is never deleted
+	current := []string{"a:3306", "b:3306", "c:3306"}
+	discovered := map[string]bool{"b:3306": true, "c:3306": true}
+	add, del := diffSlaves(current, discovered, []string{"c:3306", "d:3306"})
+	if !reflect.DeepEqual(add, []string{"d:3306"}) || !reflect.DeepEqual(del, []string{"b:3306"}) {
+		t.Fatal(add, del)
+	}
+}
+
+func TestWaitMasterChange(t *testing.T) {
+	node := new(Node)
+	node.Master = &DB{addr: "127.0.0.1:3306", state: Up}
+	if node.WaitMasterChange("127.0.0.1:3306", 0) {
+		t.Fatal("the master is not changed")
+	}
+
+	go func() {
+		time.Sleep(2 * MasterPollInterval)
+		node.Master = &DB{addr: "127.0.0.1:3307", state: Up}
+	}()
+	start := time.Now()
+	if !node.WaitMasterChange("127.0.0.1:3306", time.Second) {
+		t.Fatal("the master is changed")
+	}
+	if time.Second <= time.Since(start) {
+		t.Fatal("wait until timeout")
+	}
+}
+
+func TestSwitchMaster(t *testing.T) {
+	node := new(Node)
+	master := &DB{addr: "127.0.0.1:3306", state: Up}
+	node.Master = master
+	if old, err := node.SwitchMaster("127.0.0.1:3306"); err != nil || old != "127.0.0.1:3306" {
+		t.Fatal(old, err)
+	}
+
+	//the master is kept if the new master can not be connected
+	if _, err := node.SwitchMaster("127.0.0.1:1"); err == nil {
+		t.Fatal("switch to a master which is down")
+	}
+	if node.Master != master {
+		t.Fatal("the master is changed")
+	}
+}
+
+func TestParseDownBackends(t *testing.T) {
+	node := new(Node)
+	node.Cfg.Name = "node1"
+	if err := node.ParseMaster("127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.ParseSlave("127.0.0.1:2@2,127.0.0.1:3"); err != nil {
+		t.Fatal(err)
+	}
+	if node.Master.Addr() != "127.0.0.1:1" || node.Master.State() != "down" {
+		t.Fatal(node.Master.Addr(), node.Master.State())
+	}
+	if len(node.Slave) != 2 || node.Slave[1].State() != "down" {
+		t.Fatal(node.Slave)
+	}
+	if _, err := node.GetMasterConn(); err != errors.ErrMasterDown {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	HealthSampleWeight   = 0.1  //the weight of a new sample in the error rate and latency
+	HealthBaselineWeight = 0.01 //the weight of a new sample in the baseline latency
+	HealthHalfLife       = 10   //seconds, the degradation halves every HealthHalfLife
+	MinHealth            = 0.05 //a degraded slave still gets a few reads to recover
+	SlowLatencyRatio     = 2    //the latency is degraded if it is SlowLatencyRatio times of baseline
+)
+
+//dbHealth tracks the error rate and latency of a db, the effective weight
+//of a slave in balancer is its weight multiplied by the health.
+type dbHealth struct {
+	sync.Mutex
+
+	errRate  float64 //the moving average of the rate of failed sqls
+	latency  float64 //the moving average of latency in ms
+	baseline float64 //the slow moving average of latency in ms
+	lastTime int64   //unix nano of the last sample
+}
+
+//the degradation fades out without new samples, so a slave which gets few
+//reads because of its low weight can still recover.
+func (h *dbHealth) recover(now int64) {
+	if h.lastTime == 0 || now <= h.lastTime {
+		return
+	}
+	k := math.Pow(0.5, float64(now-h.lastTime)/float64(HealthHalfLife*time.Second))
+	h.errRate *= k
+	if h.baseline < h.latency {
+		h.latency = h.baseline + (h.latency-h.baseline)*k
+	}
+	h.lastTime = now
+}
+
+func (h *dbHealth) record(latency float64, failed bool, now int64) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.recover(now)
+	h.lastTime = now
+	if failed {
+		h.errRate += (1 - h.errRate) * HealthSampleWeight
+		return
+	}
+	h.errRate -= h.errRate * HealthSampleWeight
+	if h.baseline == 0 {
+		h.baseline = latency
+		h.latency = latency
+		return
+	}
+	h.latency += (latency - h.latency) * HealthSampleWeight
+	h.baseline += (latency - h.baseline) * HealthBaselineWeight
+}
+
+//the health in [MinHealth, 1], 1 means the db is healthy
+func (h *dbHealth) health(now int64) float64 {
+	h.Lock()
+	defer h.Unlock()
+
+	h.recover(now)
+	health := 1 - h.errRate
+	if 0 < h.baseline && h.baseline*SlowLatencyRatio < h.latency {
+		health *= h.baseline * SlowLatencyRatio / h.latency
+	}
+	if health < MinHealth {
+		return MinHealth
+	}
+	return health
+}
+
+//Health return the health of db in [MinHealth, 1], it decreases when the
+//error rate or latency of db degrades, and restores gradually.
+func (db *DB) Health() float64 {
+	return db.health.health(time.Now().UnixNano())
+}
+
+//SetLag sets the replication lag of db measured by the heartbeat, a
+//negative lag means unknown
+func (db *DB) SetLag(lag time.Duration) {
+	if lag < 0 {
+		lag = -1
+	}
+	atomic.StoreInt64(&db.lag, int64(lag))
+}
+
+//Lag returns the replication lag of db, negative if unknown
+func (db *DB) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&db.lag))
+}
+
+//a degraded db is skipped with the probability of 1-health
+func (db *DB) acceptRead() bool {
+	if atomic.LoadInt32(&db.clusterDown) == 1 {
+		return false
+	}
+	health := db.Health()
+	return 1 <= health || rand.Float64() < health
+}
+
+//Execute the sql and record the latency and error in the health of db,
+//the errors of mysql server such as syntax error are not failures of db.
+func (p *BackendConn) Execute(command string, args ...interface{}) (*mysql.Result, error) {
+	start := time.Now().UnixNano()
+	r, err := p.Conn.Execute(command, args...)
+	now := time.Now().UnixNano()
+
+	_, isSqlErr := err.(*mysql.SqlError)
+	p.db.health.record(float64(now-start)/float64(time.Millisecond), err != nil && !isSqlErr, now)
+	return r, err
+}
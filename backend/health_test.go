@@ -0,0 +1,120 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDBHealth(t *testing.T) {
+	var h dbHealth
+	now := time.Now().UnixNano()
+	for i := 0; i < 100; i++ {
+		h.record(1, false, now)
+	}
+	if v := h.health(now); v != 1 {
+		t.Fatal(v)
+	}
+
+	//the errors decrease the health
+	for i := 0; i < 10; i++ {
+		h.record(1, true, now)
+	}
+	errHealth := h.health(now)
+	if 0.7 < errHealth || errHealth < MinHealth {
+		t.Fatal(errHealth)
+	}
+
+	//and it restores gradually
+	now += int64(HealthHalfLife * time.Second)
+	if v := h.health(now); v <= errHealth || 1 <= v {
+		t.Fatal(v)
+	}
+	now += int64(10 * HealthHalfLife * time.Second)
+	if v := h.health(now); v < 0.99 {
+		t.Fatal(v)
+	}
+
+	//the latency much higher than baseline decreases the health
+	for i := 0; i < 20; i++ {
+		h.record(20, false, now)
+	}
+	if v := h.health(now); 0.6 < v {
+		t.Fatal(v)
+	}
+
+	for i := 0; i < 1000; i++ {
+		h.record(0, true, now)
+	}
+	if v := h.health(now); v != MinHealth {
+		t.Fatal(v)
+	}
+}
+
+func TestBalancerHealth(t *testing.T) {
+	n := new(Node)
+	n.Slave = []*DB{new(DB), new(DB)}
+	n.SlaveWeights = []int{1, 1}
+	n.InitBalancer()
+
+	now := time.Now().UnixNano()
+	for i := 0; i < 1000; i++ {
+		n.Slave[1].health.record(0, true, now)
+	}
+
+	counts := make(map[*DB]int)
+	for i := 0; i < 1000; i++ {
+		db, err := n.GetNextSlave()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[db]++
+	}
+	if counts[n.Slave[1]] > 100 || counts[n.Slave[0]] < 900 {
+		t.Fatal(counts[n.Slave[0]], counts[n.Slave[1]])
+	}
+}
+
+func TestBalancerLag(t *testing.T) {
+	n := new(Node)
+	n.Slave = []*DB{newDB("a", "", "", "", 0, Options{}), newDB("b", "", "", "", 0, Options{})}
+	n.SlaveWeights = []int{1, 1}
+	n.InitBalancer()
+
+	//the lags are unknown before the first heartbeat
+	if db := n.getFreshSlave(time.Second); db != nil {
+		t.Fatal(db.Addr())
+	}
+
+	n.Slave[0].SetLag(2 * time.Second)
+	n.Slave[1].SetLag(100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if db := n.getFreshSlave(time.Second); db != n.Slave[1] {
+			t.Fatal(db)
+		}
+	}
+	if db := n.getFreshSlave(3 * time.Second); db == nil {
+		t.Fatal("no slave")
+	}
+
+	n.Slave[1].SetLag(-time.Second)
+	if lag := n.Slave[1].Lag(); lag != -1 {
+		t.Fatal(lag)
+	}
+	if db := n.getFreshSlave(time.Second); db != nil {
+		t.Fatal(db.Addr())
+	}
+}
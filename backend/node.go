@@ -24,6 +24,7 @@ import (
 	"github.com/flike/kingshard/config"
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
 )
 
 const (
@@ -31,6 +32,9 @@ const (
 	Slave       = "slave"
 	SlaveSplit  = ","
 	WeightSplit = "@"
+
+	//the interval to check whether the master is changed by a failover
+	MasterPollInterval = 100 * time.Millisecond
 )
 
 type Node struct {
@@ -44,17 +48,58 @@ type Node struct {
 	RoundRobinQ    []int
 	SlaveWeights   []int
 
+	//the backups are used for reads only if the master and slaves are down
+	Backup          []*DB
+	LastBackupIndex int
+
+	discovered map[string]bool //the slaves added by discovery
+
 	DownAfterNoAlive time.Duration
+	Timeouts         Timeouts
+	Transport        Transport
+	//the charset of the conns, utf8 if it is empty
+	Charset   string
+	Collation mysql.CollationId
+
+	closed int32 //1 if the node is closed, see Close
 }
 
 func (n *Node) CheckNode() {
 	//to do
 	//1 check connection alive
-	for {
+	for atomic.LoadInt32(&n.closed) == 0 {
 		n.checkMaster()
 		n.checkSlave()
+		n.checkBackup()
+		n.discoverSlaves()
 		time.Sleep(16 * time.Second)
 	}
+	//the conns to check the dbs are only used by CheckNode
+	for _, db := range n.dbs() {
+		db.closeCheckConn()
+	}
+}
+
+//Close stops CheckNode and closes the pools of all the dbs, such as the
+//node removed or changed by a reload
+func (n *Node) Close() {
+	atomic.StoreInt32(&n.closed, 1)
+	for _, db := range n.dbs() {
+		db.Close()
+	}
+}
+
+//the master, slaves and backups
+func (n *Node) dbs() []*DB {
+	n.RLock()
+	defer n.RUnlock()
+	dbs := make([]*DB, 0, 1+len(n.Slave)+len(n.Backup))
+	for _, db := range append(append([]*DB{n.Master}, n.Slave...), n.Backup...) {
+		if db != nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
 }
 
 func (n *Node) String() string {
@@ -84,13 +129,80 @@ func (n *Node) GetSlaveConn() (*BackendConn, error) {
 	if db == nil {
 		return nil, errors.ErrNoSlaveDB
 	}
-	if atomic.LoadInt32(&(db.state)) == Down {
+	if atomic.LoadInt32(&(db.state)) == Down || atomic.LoadInt32(&db.clusterDown) == 1 {
 		return nil, errors.ErrSlaveDown
 	}
 
+	co, err := db.GetConn()
+	if err == errors.ErrBackendBusy {
+		return n.getOtherSlaveConn(db)
+	}
+	return co, err
+}
+
+//the reads go to the other slaves if a slave has too many connections,
+//ErrBackendBusy if all of them are busy
+func (n *Node) getOtherSlaveConn(busy *DB) (*BackendConn, error) {
+	var slaves []*DB
+	n.Lock()
+	for i := 0; i < len(n.RoundRobinQ); i++ {
+		db, err := n.GetNextSlave()
+		if err != nil {
+			break
+		}
+		if db == nil || db == busy || atomic.LoadInt32(&(db.state)) == Down ||
+			atomic.LoadInt32(&db.clusterDown) == 1 || db.IsBackingOff() {
+			continue
+		}
+		tried := false
+		for _, s := range slaves {
+			tried = tried || s == db
+		}
+		if !tried {
+			slaves = append(slaves, db)
+		}
+	}
+	n.Unlock()
+
+	for _, db := range slaves {
+		if co, err := db.GetConn(); err == nil {
+			return co, nil
+		}
+	}
+	return nil, errors.ErrBackendBusy
+}
+
+//GetFreshSlaveConn returns a conn of a slave whose lag is known and within
+//maxLag, the slaves are tried in the order of balancer
+func (n *Node) GetFreshSlaveConn(maxLag time.Duration) (*BackendConn, error) {
+	n.Lock()
+	db := n.getFreshSlave(maxLag)
+	n.Unlock()
+	if db == nil {
+		return nil, errors.ErrNoFreshSlave
+	}
+
 	return db.GetConn()
 }
 
+//with the lock of n held, nil if no slave is within maxLag
+func (n *Node) getFreshSlave(maxLag time.Duration) *DB {
+	for i := 0; i < len(n.RoundRobinQ); i++ {
+		db, err := n.GetNextSlave()
+		if err != nil {
+			return nil
+		}
+		if db == nil || atomic.LoadInt32(&(db.state)) == Down ||
+			atomic.LoadInt32(&db.clusterDown) == 1 {
+			continue
+		}
+		if lag := db.Lag(); 0 <= lag && lag <= maxLag {
+			return db
+		}
+	}
+	return nil
+}
+
 func (n *Node) checkMaster() {
 	db := n.Master
 	if db == nil {
@@ -227,11 +339,60 @@ func (n *Node) DeleteSlave(addr string) error {
 	return nil
 }
 
+//the timeouts in seconds of config, 0 means the default and a negative
+//value means no timeout
+func ParseTimeouts(cfg config.NodeConfig) Timeouts {
+	timeout := func(seconds int, defaultTimeout time.Duration) time.Duration {
+		switch {
+		case seconds == 0:
+			return defaultTimeout
+		case seconds < 0:
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return Timeouts{
+		Connect:   timeout(cfg.ConnectTimeout, DefaultConnectTimeout),
+		Read:      timeout(cfg.ReadTimeout, 0),
+		Write:     timeout(cfg.WriteTimeout, DefaultWriteTimeout),
+		KeepAlive: timeout(cfg.KeepAlivePeriod, DefaultKeepAlivePeriod),
+	}
+}
+
+func (n *Node) options() Options {
+	return Options{
+		SecondaryPassword: n.Cfg.SecondaryPassword,
+		Timeouts:          n.Timeouts,
+		Transport:         n.Transport,
+		SchemaRewrite:     n.Cfg.SchemaRewrite,
+		Charset:           n.Charset,
+		Collation:         n.Collation,
+	}
+}
+
 func (n *Node) OpenDB(addr string) (*DB, error) {
-	db, err := Open(addr, n.Cfg.User, n.Cfg.Password, "", n.Cfg.MaxConnNum)
+	db, err := OpenWithOptions(addr, n.Cfg.User, n.Cfg.Password, "", n.Cfg.MaxConnNum, n.options())
 	return db, err
 }
 
+//openOrDownDB is used at startup, the db which can not be connected is
+//marked down instead of failing the startup, such as the backends started
+//after kingshard in containers. It is up by CheckNode once it can be
+//connected.
+func (n *Node) openOrDownDB(addr string) *DB {
+	db, err := n.OpenDB(addr)
+	if err == nil {
+		return db
+	}
+	golog.Error("Node", "openOrDownDB", "db is down", 0,
+		"node", n.Cfg.Name,
+		"addr", addr,
+		"error", err.Error())
+	db = newDB(addr, n.Cfg.User, n.Cfg.Password, "", n.Cfg.MaxConnNum, n.options())
+	atomic.StoreInt32(&(db.state), Down)
+	return db
+}
+
 func (n *Node) UpDB(addr string) (*DB, error) {
 	db, err := n.OpenDB(addr)
 
@@ -248,6 +409,23 @@ func (n *Node) UpDB(addr string) (*DB, error) {
 	return db, nil
 }
 
+//WaitMasterChange waits at most wait for the master to be changed from
+//addr by a failover, such as admin up master. It returns true if the
+//master is changed.
+func (n *Node) WaitMasterChange(addr string, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		db := n.Master
+		if db != nil && db.Addr() != addr && atomic.LoadInt32(&(db.state)) != Down {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(MasterPollInterval)
+	}
+}
+
 func (n *Node) UpMaster(addr string) error {
 	db, err := n.UpDB(addr)
 	if err != nil {
@@ -257,6 +435,31 @@ func (n *Node) UpMaster(addr string) error {
 	return err
 }
 
+//SwitchMaster replaces the master with addr, such as the new master
+//promoted by an external failover tool. The master is not changed if addr
+//can not be connected. The pool of old master is closed, and the conns in
+//use are closed once they are released. It returns the addr of old master.
+func (n *Node) SwitchMaster(addr string) (string, error) {
+	if old := n.Master; old != nil && old.Addr() == addr && atomic.LoadInt32(&(old.state)) == Up {
+		return addr, nil
+	}
+	db, err := n.UpDB(addr)
+	if err != nil {
+		golog.Error("Node", "SwitchMaster", err.Error(), 0, "addr", addr)
+		return "", err
+	}
+
+	n.Lock()
+	old := n.Master
+	n.Master = db
+	n.Unlock()
+	if old == nil {
+		return "", nil
+	}
+	old.Close()
+	return old.Addr(), nil
+}
+
 func (n *Node) UpSlave(addr string) error {
 	db, err := n.UpDB(addr)
 	if err != nil {
@@ -309,19 +512,48 @@ func (n *Node) DownSlave(addr string, state int32) error {
 	return nil
 }
 
+//the addrs of the slaves which are down here, by the check or manually
+func (n *Node) DownSlaves() []string {
+	n.RLock()
+	defer n.RUnlock()
+	var addrs []string
+	for _, slave := range n.Slave {
+		if state := atomic.LoadInt32(&(slave.state)); state == Down || state == ManualDown {
+			addrs = append(addrs, slave.addr)
+		}
+	}
+	return addrs
+}
+
+//SetClusterDown marks the slaves in addrs down in other instances of the
+//cluster, and the others not. The slaves marked are not read.
+func (n *Node) SetClusterDown(addrs map[string]bool) {
+	n.RLock()
+	defer n.RUnlock()
+	for _, slave := range n.Slave {
+		var down int32
+		if addrs[slave.addr] {
+			down = 1
+		}
+		if atomic.SwapInt32(&slave.clusterDown, down) != down {
+			golog.Info("Node", "SetClusterDown", "slave state in cluster changed", 0,
+				"db.Addr", slave.addr,
+				"down", down)
+		}
+	}
+}
+
 func (n *Node) ParseMaster(masterStr string) error {
-	var err error
 	if len(masterStr) == 0 {
 		return errors.ErrNoMasterDB
 	}
 
-	n.Master, err = n.OpenDB(masterStr)
-	return err
+	n.Master = n.openOrDownDB(masterStr)
+	return nil
 }
 
 //slaveStr(127.0.0.1:3306@2,192.168.0.12:3306@3)
 func (n *Node) ParseSlave(slaveStr string) error {
-	var db *DB
 	var weight int
 	var err error
 
@@ -346,10 +578,7 @@ func (n *Node) ParseSlave(slaveStr string) error {
 			weight = 1
 		}
 		n.SlaveWeights = append(n.SlaveWeights, weight)
-		if db, err = n.OpenDB(addrAndWeight[0]); err != nil {
-			return err
-		}
-		n.Slave = append(n.Slave, db)
+		n.Slave = append(n.Slave, n.openOrDownDB(addrAndWeight[0]))
 	}
 	n.InitBalancer()
 	return nil
@@ -0,0 +1,110 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+//ServerInfo is the version and capabilities of a mysql server, got from
+//the initial handshake. GTIDMode is probed by the health check.
+type ServerInfo struct {
+	Version             string //such as 8.0.32-log
+	Major, Minor, Patch int
+	Capability          uint32
+	Collation           mysql.CollationId //the default collation of server
+	AuthPlugin          string            //the default auth plugin of server
+	GTIDMode            bool
+}
+
+//parse the numbers of Version, they are 0 if Version is unknown
+func (i *ServerInfo) parseVersion() {
+	i.Major, i.Minor, i.Patch = 0, 0, 0
+	nums := strings.SplitN(strings.SplitN(i.Version, "-", 2)[0], ".", 3)
+	if len(nums) != 3 {
+		return
+	}
+	var err error
+	var v [3]int
+	for j, num := range nums {
+		if v[j], err = strconv.Atoi(num); err != nil {
+			return
+		}
+	}
+	i.Major, i.Minor, i.Patch = v[0], v[1], v[2]
+}
+
+func (i *ServerInfo) known() bool {
+	return i.Major != 0
+}
+
+func (i *ServerInfo) compare(major, minor, patch int) int {
+	for _, d := range []int{i.Major - major, i.Minor - minor, i.Patch - patch} {
+		if d != 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+//AtLeast returns true if the version is known and not older than
+//major.minor.patch
+func (i *ServerInfo) AtLeast(major, minor, patch int) bool {
+	return i.known() && 0 <= i.compare(major, minor, patch)
+}
+
+//Before returns true if the version is known and older than
+//major.minor.patch
+func (i *ServerInfo) Before(major, minor, patch int) bool {
+	return i.known() && i.compare(major, minor, patch) < 0
+}
+
+//MajorVersion is such as 5.7 or 8.0, empty if the version is unknown
+func (i *ServerInfo) MajorVersion() string {
+	if !i.known() {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d", i.Major, i.Minor)
+}
+
+//utf8mb4 is supported since mysql 5.5.3
+func (i *ServerInfo) SupportUtf8mb4() bool {
+	return !i.Before(5, 5, 3)
+}
+
+func (i *ServerInfo) SupportSessionTrack() bool {
+	return i.Capability&mysql.CLIENT_SESSION_TRACK != 0
+}
+
+//the name of variable in the server, tx_isolation and tx_read_only are
+//renamed to transaction_isolation and transaction_read_only in 5.7.20,
+//and the old names are removed in 8.0
+func (i *ServerInfo) variableName(name string) string {
+	switch name {
+	case "tx_isolation", "tx_read_only":
+		if i.AtLeast(8, 0, 0) {
+			return "transaction_" + name[len("tx_"):]
+		}
+	case "transaction_isolation", "transaction_read_only":
+		if i.Before(5, 7, 20) {
+			return "tx_" + name[len("transaction_"):]
+		}
+	}
+	return name
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestServerInfoVersion(t *testing.T) {
+	info := ServerInfo{Version: "5.7.19-log"}
+	info.parseVersion()
+	if info.MajorVersion() != "5.7" || !info.AtLeast(5, 7, 19) || !info.Before(5, 7, 20) {
+		t.Fatal(info)
+	}
+	if v := info.variableName("transaction_isolation"); v != "tx_isolation" {
+		t.Fatal(v)
+	}
+
+	info = ServerInfo{Version: "8.0.32"}
+	info.parseVersion()
+	if v := info.variableName("tx_read_only"); v != "transaction_read_only" {
+		t.Fatal(v)
+	}
+
+	//the unknown version is neither old nor new
+	info = ServerInfo{Version: "unknown"}
+	info.parseVersion()
+	if info.AtLeast(5, 0, 0) || info.Before(9, 0, 0) || !info.SupportUtf8mb4() {
+		t.Fatal(info)
+	}
+}
+
+func TestServerInfoHandshake(t *testing.T) {
+	s, err := mysqltest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Version = "8.0.32"
+	s.Password = "secret"
+	s.AuthPlugin = mysql.CACHING_SHA2_AUTH_NAME
+	s.Handle(`gtid_mode`, &mysqltest.Response{
+		Names: []string{"@@global.gtid_mode"},
+		Rows:  [][]interface{}{{"ON"}},
+	})
+
+	db, err := Open(s.Addr(), "root", "secret", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := db.ServerInfo()
+	if !ok || info.MajorVersion() != "8.0" || info.AuthPlugin != mysql.CACHING_SHA2_AUTH_NAME || !info.GTIDMode {
+		t.Fatal(info)
+	}
+
+	//the user of another plugin is switched by the server
+	s.AuthPlugin = mysql.AUTH_NAME
+	co, err := db.newConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	co.Close()
+}
@@ -66,11 +66,20 @@ func (s *Stmt) write(args ...interface{}) error {
 		return fmt.Errorf("argument mismatch, need %d but got %d", s.params, len(args))
 	}
 
+	//the params are followed by the query attributes, and each type is
+	//followed by the name with CLIENT_QUERY_ATTRIBUTES
+	withNames := s.conn.capability&mysql.CLIENT_QUERY_ATTRIBUTES != 0
+	var attrs []mysql.QueryAttribute
+	if withNames {
+		attrs = s.conn.attrs
+	}
+	count := paramsNum + len(attrs)
+
 	paramTypes := make([]byte, paramsNum<<1)
 	paramValues := make([][]byte, paramsNum)
 
 	//NULL-bitmap, length: (num-params+7)
-	nullBitmap := make([]byte, (paramsNum+7)>>3)
+	nullBitmap := make([]byte, (count+7)>>3)
 
 	var length int = int(1 + 4 + 1 + 4 + ((paramsNum + 7) >> 3) + 1 + (paramsNum << 1))
 
@@ -148,18 +157,35 @@ func (s *Stmt) write(args ...interface{}) error {
 		length += len(paramValues[i])
 	}
 
+	for i := range attrs {
+		if attrs[i].Value == nil {
+			nullBitmap[(paramsNum+i)/8] |= (1 << (uint(paramsNum+i) % 8))
+		}
+		newParamBoundFlag = 1
+		length += len(attrs[i].Name) + 3 + len(attrs[i].Value)
+	}
+
 	data := make([]byte, 4, 4+length)
 
 	data = append(data, mysql.COM_STMT_EXECUTE)
 	data = append(data, byte(s.id), byte(s.id>>8), byte(s.id>>16), byte(s.id>>24))
 
-	//flag: CURSOR_TYPE_NO_CURSOR
-	data = append(data, 0x00)
+	//flag: CURSOR_TYPE_NO_CURSOR, and the count of params is sent with
+	//the query attributes
+	if len(attrs) != 0 {
+		data = append(data, mysql.PARAMETER_COUNT_AVAILABLE)
+	} else {
+		data = append(data, 0x00)
+	}
 
 	//iteration-count, always 1
 	data = append(data, 1, 0, 0, 0)
 
-	if s.params > 0 {
+	if len(attrs) != 0 {
+		data = append(data, mysql.PutLengthEncodedInt(uint64(count))...)
+	}
+
+	if count > 0 {
 		data = append(data, nullBitmap...)
 
 		//new-params-bound-flag
@@ -167,12 +193,23 @@ func (s *Stmt) write(args ...interface{}) error {
 
 		if newParamBoundFlag == 1 {
 			//type of each parameter, length: num-params * 2
-			data = append(data, paramTypes...)
+			for i := 0; i < paramsNum; i++ {
+				data = append(data, paramTypes[i<<1], paramTypes[(i<<1)+1])
+				if withNames {
+					data = append(data, 0)
+				}
+			}
+			for i := range attrs {
+				data = mysql.AppendParamType(data, &attrs[i], true)
+			}
 
 			//value of each parameter
 			for _, v := range paramValues {
 				data = append(data, v...)
 			}
+			for i := range attrs {
+				data = append(data, attrs[i].Value...)
+			}
 		}
 	}
 
@@ -218,13 +255,13 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 	//warnings = binary.LittleEndian.Uint16(data[pos:])
 
 	if s.params > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if err := s.conn.readDefinitions(s.params); err != nil {
 			return nil, err
 		}
 	}
 
 	if s.columns > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if err := s.conn.readDefinitions(s.columns); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,326 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the ssh client run by SSHTunnel
+var SSHCommand = "ssh"
+
+//the seconds the shared ssh connection to a jump host stays after its
+//last tunnel is closed
+const SSHControlPersist = 60
+
+//Transport is the way the conns reach the mysql servers of a node, the
+//zero value means the plain tcp or unix socket
+type Transport struct {
+	TLS    *tls.Config //the conns are over tls if it is not nil
+	Tunnel *SSHTunnel  //the conns are tunneled by ssh if it is not nil
+
+	//the packets of the conns are recorded if it is not nil
+	Recorder *mysql.Recorder
+}
+
+func ParseTransport(cfg config.NodeConfig) (Transport, error) {
+	var t Transport
+	var err error
+	if cfg.TLS.Enable {
+		if t.TLS, err = newTLSConfig(cfg.TLS); err != nil {
+			return t, fmt.Errorf("tls of node %s: %v", cfg.Name, err)
+		}
+	}
+	if len(cfg.SSHTunnel.Addr) != 0 {
+		if t.Tunnel, err = NewSSHTunnel(cfg.SSHTunnel); err != nil {
+			return t, fmt.Errorf("ssh_tunnel of node %s: %v", cfg.Name, err)
+		}
+	}
+	return t, nil
+}
+
+func newTLSConfig(cfg config.NodeTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if len(cfg.CA) != 0 {
+		data, err := ioutil.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no cert in ca %s", cfg.CA)
+		}
+	}
+	if len(cfg.Cert) != 0 || len(cfg.Key) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+//the tls config to the server addr, the server name is the host of addr
+//if it is not set
+func tlsConfigFor(cfg *tls.Config, addr string) *tls.Config {
+	if len(cfg.ServerName) != 0 || cfg.InsecureSkipVerify {
+		return cfg
+	}
+	serverName := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		serverName = host
+	}
+	//copy the fields set by newTLSConfig, a tls.Config in use can not be
+	//copied as a value
+	return &tls.Config{
+		RootCAs:      cfg.RootCAs,
+		Certificates: cfg.Certificates,
+		ServerName:   serverName,
+	}
+}
+
+func (t Transport) dial(network string, addr string, timeout time.Duration) (net.Conn, error) {
+	if t.Tunnel != nil {
+		if network != "tcp" {
+			return nil, fmt.Errorf("%s can not be tunneled by ssh", addr)
+		}
+		return t.Tunnel.Dial(addr, timeout)
+	}
+	return net.DialTimeout(network, addr, timeout)
+}
+
+//SSHTunnel forwards the conns by the ssh client of system with ssh -W
+//through a jump host. The tunnels to the same jump host share one ssh
+//connection by the ControlMaster of ssh.
+type SSHTunnel struct {
+	user           string
+	host           string
+	port           string
+	keyFile        string
+	knownHostsFile string
+}
+
+func NewSSHTunnel(cfg config.SSHTunnelConfig) (*SSHTunnel, error) {
+	t := new(SSHTunnel)
+	addr := cfg.Addr
+	if i := strings.LastIndex(addr, "@"); i != -1 {
+		t.user, addr = addr[:i], addr[i+1:]
+	}
+	t.host = addr
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		t.host, t.port = host, port
+	}
+	if len(t.host) == 0 || strings.HasPrefix(t.host, "-") {
+		return nil, fmt.Errorf("invalid ssh addr %s", cfg.Addr)
+	}
+	t.keyFile = cfg.KeyFile
+	t.knownHostsFile = cfg.KnownHostsFile
+	return t, nil
+}
+
+//the args of ssh to forward the stdio to addr
+func (t *SSHTunnel) args(addr string, timeout time.Duration) []string {
+	args := []string{
+		"-W", addr,
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(os.TempDir(), "kingshard-ssh-%C"),
+		"-o", fmt.Sprintf("ControlPersist=%d", SSHControlPersist),
+	}
+	if 0 < timeout {
+		seconds := int((timeout + time.Second - 1) / time.Second)
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", seconds))
+	}
+	if len(t.port) != 0 {
+		args = append(args, "-p", t.port)
+	}
+	if len(t.user) != 0 {
+		args = append(args, "-l", t.user)
+	}
+	if len(t.keyFile) != 0 {
+		args = append(args, "-i", t.keyFile)
+	}
+	if len(t.knownHostsFile) != 0 {
+		args = append(args, "-o", "UserKnownHostsFile="+t.knownHostsFile)
+	}
+	return append(args, t.host)
+}
+
+//Dial runs ssh -W addr, and returns its stdin and stdout as the conn.
+//The errors of ssh are logged when it exits.
+func (t *SSHTunnel) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	c := &tunnelConn{r: stdoutR, w: stdinW, addr: addr}
+	c.cmd = exec.Command(SSHCommand, t.args(addr, timeout)...)
+	c.cmd.Stdin = stdinR
+	c.cmd.Stdout = stdoutW
+	c.cmd.Stderr = &c.stderr
+	err = c.cmd.Start()
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	go func() {
+		err := c.cmd.Wait()
+		c.Lock()
+		closed := c.closed
+		c.Unlock()
+		if err != nil && !closed {
+			golog.Error("SSHTunnel", "Dial", err.Error(), 0,
+				"jump_host", t.host,
+				"addr", addr,
+				"stderr", strings.TrimSpace(c.stderr.String()))
+		}
+	}()
+	return c, nil
+}
+
+//the stdio of ssh -W as a conn. The pipes do not support deadlines, a
+//read or write not done before its deadline closes the conn, which is
+//unblocked as ssh is killed. A backend conn timed out is broken anyway.
+type tunnelConn struct {
+	sync.Mutex
+
+	r      *os.File
+	w      *os.File
+	addr   string
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	closed bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+//the error of the read or write timed out, as the net.Error of net.Conn
+type tunnelTimeoutError struct{}
+
+func (tunnelTimeoutError) Error() string   { return "ssh tunnel i/o timeout" }
+func (tunnelTimeoutError) Timeout() bool   { return true }
+func (tunnelTimeoutError) Temporary() bool { return true }
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "ssh" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	c.Lock()
+	deadline := c.readDeadline
+	c.Unlock()
+	return c.withDeadline(deadline, func() (int, error) { return c.r.Read(b) })
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	c.Lock()
+	deadline := c.writeDeadline
+	c.Unlock()
+	return c.withDeadline(deadline, func() (int, error) { return c.w.Write(b) })
+}
+
+//do f, the conn is closed if f is not done before deadline
+func (c *tunnelConn) withDeadline(deadline time.Time, f func() (int, error)) (int, error) {
+	if deadline.IsZero() {
+		return f()
+	}
+	d := deadline.Sub(time.Now())
+	if d <= 0 {
+		c.Close()
+		return 0, tunnelTimeoutError{}
+	}
+	timer := time.AfterFunc(d, func() { c.Close() })
+	n, err := f()
+	if !timer.Stop() {
+		err = tunnelTimeoutError{}
+	}
+	return n, err
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return tunnelAddr("ssh") }
+func (c *tunnelConn) RemoteAddr() net.Addr { return tunnelAddr(c.addr) }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.Unlock()
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.Lock()
+	c.readDeadline = t
+	c.Unlock()
+	return nil
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	c.Lock()
+	c.writeDeadline = t
+	c.Unlock()
+	return nil
+}
+
+//Close stops ssh, the shared ssh connection is kept by ControlPersist
+func (c *tunnelConn) Close() error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.Unlock()
+
+	c.w.Close()
+	c.r.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	return nil
+}
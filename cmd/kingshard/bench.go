@@ -0,0 +1,310 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the workloads of kingshard bench
+const (
+	BenchPoint  = "point"  //select a row by the shard key
+	BenchRange  = "range"  //select the rows of a key range
+	BenchInsert = "insert" //insert several rows in a statement
+	BenchTx     = "tx"     //select a row for update in a transaction
+)
+
+var benchWorkloads = []string{BenchPoint, BenchRange, BenchInsert, BenchTx}
+
+type BenchOptions struct {
+	Addr     string
+	User     string
+	Password string
+	DB       string
+	Table    string
+	Key      string
+
+	Concurrency int
+	Duration    time.Duration
+	//the keys of point, range and tx are in [1, Keys], the inserted keys
+	//are greater than Keys
+	Keys      int64
+	RangeSize int64
+	InsertRow int
+}
+
+type BenchReport struct {
+	Workload  string
+	Ops       int64
+	Errors    int64
+	Elapsed   time.Duration
+	Latencies []time.Duration //sorted
+	LastError error
+}
+
+func (r *BenchReport) QPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+//the latency of percentile p in [0, 100]
+func (r *BenchReport) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if len(r.Latencies) <= i {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r *BenchReport) Avg() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range r.Latencies {
+		total += d
+	}
+	return total / time.Duration(len(r.Latencies))
+}
+
+type Bench struct {
+	opts BenchOptions
+	//the next key to insert
+	insertKey int64
+}
+
+func NewBench(opts BenchOptions) *Bench {
+	return &Bench{opts: opts, insertKey: opts.Keys}
+}
+
+//the sqls of an operation of workload, the sqls of tx are sent in order
+func (b *Bench) sqls(workload string, r *rand.Rand) []string {
+	o := &b.opts
+	key := r.Int63n(o.Keys) + 1
+	switch workload {
+	case BenchPoint:
+		return []string{fmt.Sprintf("select * from %s where %s = %d", o.Table, o.Key, key)}
+	case BenchRange:
+		return []string{fmt.Sprintf("select * from %s where %s >= %d and %s < %d",
+			o.Table, o.Key, key, o.Key, key+o.RangeSize)}
+	case BenchInsert:
+		values := make([]string, o.InsertRow)
+		for i := range values {
+			values[i] = fmt.Sprintf("(%d)", atomic.AddInt64(&b.insertKey, 1))
+		}
+		return []string{fmt.Sprintf("insert into %s(%s) values %s",
+			o.Table, o.Key, strings.Join(values, ","))}
+	case BenchTx:
+		return []string{
+			"begin",
+			fmt.Sprintf("select * from %s where %s = %d for update", o.Table, o.Key, key),
+			"commit",
+		}
+	}
+	return nil
+}
+
+//run the workload by Concurrency clients for Duration
+func (b *Bench) Run(workload string) (*BenchReport, error) {
+	if b.sqls(workload, rand.New(rand.NewSource(0))) == nil {
+		return nil, fmt.Errorf("invalid workload %s", workload)
+	}
+	conns := make([]*backend.Conn, b.opts.Concurrency)
+	for i := range conns {
+		conns[i] = new(backend.Conn)
+		if err := conns[i].Connect(b.opts.Addr, b.opts.User, b.opts.Password, b.opts.DB); err != nil {
+			for _, c := range conns[:i] {
+				c.Close()
+			}
+			return nil, err
+		}
+	}
+
+	report := &BenchReport{Workload: workload}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(b.opts.Duration)
+	for i, c := range conns {
+		wg.Add(1)
+		go func(seed int64, c *backend.Conn) {
+			defer wg.Done()
+			defer c.Close()
+			r := rand.New(rand.NewSource(seed))
+			var latencies []time.Duration
+			var ops, errs int64
+			var lastErr error
+			for time.Now().Before(deadline) {
+				begin := time.Now()
+				if err := b.execute(c, b.sqls(workload, r)); err != nil {
+					errs++
+					lastErr = err
+					continue
+				}
+				ops++
+				latencies = append(latencies, time.Since(begin))
+			}
+			lock.Lock()
+			report.Ops += ops
+			report.Errors += errs
+			report.Latencies = append(report.Latencies, latencies...)
+			if lastErr != nil {
+				report.LastError = lastErr
+			}
+			lock.Unlock()
+		}(start.UnixNano()+int64(i), c)
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	sort.Sort(durations(report.Latencies))
+	return report, nil
+}
+
+//execute the sqls, the conn is rolled back or reconnected if failed
+func (b *Bench) execute(c *backend.Conn, sqls []string) error {
+	for _, sql := range sqls {
+		if _, err := c.Execute(sql); err != nil {
+			if c.IsInTransaction() {
+				c.Rollback()
+			}
+			//the conn is broken if it is not an error of mysql
+			if _, ok := err.(*mysql.SqlError); !ok {
+				c.ReConnect()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func writeBenchReports(w io.Writer, reports []*BenchReport) {
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "workload\tops\terrors\tqps\tavg_ms\tp50_ms\tp95_ms\tp99_ms\tmax_ms")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\n",
+			r.Workload, r.Ops, r.Errors, r.QPS(), ms(r.Avg()),
+			ms(r.Percentile(50)), ms(r.Percentile(95)), ms(r.Percentile(99)),
+			ms(r.Percentile(100)))
+	}
+	tw.Flush()
+	for _, r := range reports {
+		if r.LastError != nil {
+			fmt.Fprintf(w, "%s last error: %v\n", r.Workload, r.LastError)
+		}
+	}
+}
+
+//kingshard bench -config ks.yaml -workload point,insert, the workloads
+//are run against the kingshard of config, on the first sharding table or
+//the table of -table, whose shard key is an integer.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	configFile := fs.String("config", "/etc/ks.yaml", "kingshard config file")
+	addr := fs.String("addr", "", "the addr of kingshard, the addr of config if empty")
+	table := fs.String("table", "", "the sharding table, the first one of config if empty")
+	workloads := fs.String("workload", strings.Join(benchWorkloads, ","), "the workloads in order: "+strings.Join(benchWorkloads, ","))
+	concurrency := fs.Int("c", 16, "the concurrent clients")
+	duration := fs.Duration("d", 10*time.Second, "the duration of each workload")
+	keys := fs.Int64("keys", 100000, "the keys in [1, keys] are selected, the inserted keys are greater")
+	rangeSize := fs.Int64("range", 100, "the keys selected by a range query")
+	insertRows := fs.Int("rows", 10, "the rows inserted by a statement")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.ParseConfigFile(*configFile)
+	if err != nil {
+		fmt.Printf("parse config file error:%v\n", err.Error())
+		return 1
+	}
+	var rule *config.ShardConfig
+	for i, r := range cfg.Schema.ShardRule {
+		if len(*table) == 0 || strings.EqualFold(r.Table, *table) {
+			rule = &cfg.Schema.ShardRule[i]
+			break
+		}
+	}
+	if rule == nil {
+		fmt.Printf("no sharding table %s in config\n", *table)
+		return 1
+	}
+	if *concurrency <= 0 || *keys <= 0 || *rangeSize <= 0 || *insertRows <= 0 {
+		fmt.Println("c, keys, range and rows must be positive")
+		return 2
+	}
+
+	opts := BenchOptions{
+		Addr:        cfg.Addr,
+		User:        cfg.User,
+		Password:    cfg.Password,
+		DB:          rule.DB,
+		Table:       rule.Table,
+		Key:         rule.Key,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Keys:        *keys,
+		RangeSize:   *rangeSize,
+		InsertRow:   *insertRows,
+	}
+	if len(*addr) != 0 {
+		opts.Addr = *addr
+	}
+
+	fmt.Printf("bench %s.%s on %s, %d clients, %v each workload\n",
+		opts.DB, opts.Table, opts.Addr, opts.Concurrency, opts.Duration)
+	b := NewBench(opts)
+	var reports []*BenchReport
+	for _, workload := range strings.Split(*workloads, ",") {
+		report, err := b.Run(strings.TrimSpace(workload))
+		if err != nil {
+			fmt.Printf("bench %s error:%v\n", workload, err)
+			return 1
+		}
+		reports = append(reports, report)
+	}
+	writeBenchReports(os.Stdout, reports)
+	return 0
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql/mysqltest"
+	"github.com/flike/kingshard/proxy/server"
+	"golang.org/x/net/context"
+)
+
+func TestBenchReport(t *testing.T) {
+	r := &BenchReport{Ops: 100, Elapsed: 2 * time.Second}
+	for i := 1; i <= 100; i++ {
+		r.Latencies = append(r.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	if r.QPS() != 50 {
+		t.Fatal(r.QPS())
+	}
+	if r.Percentile(50) != 50*time.Millisecond || r.Percentile(99) != 99*time.Millisecond ||
+		r.Percentile(100) != 100*time.Millisecond || r.Percentile(0) != time.Millisecond {
+		t.Fatal(r.Percentile(50), r.Percentile(99), r.Percentile(100))
+	}
+	if r.Avg() != 50500*time.Microsecond {
+		t.Fatal(r.Avg())
+	}
+	if (&BenchReport{}).Percentile(99) != 0 {
+		t.Fatal("no latency")
+	}
+}
+
+func TestBenchSqls(t *testing.T) {
+	b := NewBench(BenchOptions{Table: "t", Key: "id", Keys: 10, RangeSize: 5, InsertRow: 2})
+	r := rand.New(rand.NewSource(1))
+	sqls := b.sqls(BenchInsert, r)
+	if len(sqls) != 1 || sqls[0] != "insert into t(id) values (11),(12)" {
+		t.Fatal(sqls)
+	}
+	sqls = b.sqls(BenchTx, r)
+	if len(sqls) != 3 || sqls[0] != "begin" || !strings.HasSuffix(sqls[1], "for update") {
+		t.Fatal(sqls)
+	}
+	if b.sqls("unknown", r) != nil {
+		t.Fatal("unknown workload")
+	}
+}
+
+var benchConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [2,2]
+`
+
+//bench the kingshard with fake mysql servers as backends
+func TestBenchRun(t *testing.T) {
+	var addrs []interface{}
+	for i := 0; i < 2; i++ {
+		b, err := mysqltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+		addrs = append(addrs, b.Addr())
+	}
+	cfg, err := config.ParseConfigData([]byte(fmt.Sprintf(benchConfig, addrs...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svr.RunContext(ctx)
+
+	b := NewBench(BenchOptions{
+		Addr:        svr.Addr().String(),
+		User:        "root",
+		DB:          "kingshard",
+		Table:       "t",
+		Key:         "id",
+		Concurrency: 2,
+		Duration:    100 * time.Millisecond,
+		Keys:        100,
+		RangeSize:   10,
+		InsertRow:   3,
+	})
+	var reports []*BenchReport
+	for _, workload := range benchWorkloads {
+		r, err := b.Run(workload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Ops == 0 || r.Errors != 0 {
+			t.Fatal(workload, r.Ops, r.Errors, r.LastError)
+		}
+		reports = append(reports, r)
+	}
+	if _, err = b.Run("unknown"); err == nil {
+		t.Fatal("unknown workload")
+	}
+
+	var buf bytes.Buffer
+	writeBenchReports(&buf, reports)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 5 ||
+		!strings.HasPrefix(lines[1], "point") {
+		t.Fatal(buf.String())
+	}
+}
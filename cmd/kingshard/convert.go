@@ -0,0 +1,955 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the middlewares whose configs are converted by kingshard convert
+const (
+	FromMyCat  = "mycat"  //schema.xml and rule.xml
+	FromAtlas  = "atlas"  //the cnf of instance, such as test.cnf
+	FromVitess = "vitess" //the vschema of a keyspace in json
+)
+
+//Conversion is a kingshard config converted from another middleware,
+//Notes are the constructs not converted and the manual steps of the
+//migration, such as the renames of the tables into the sub tables
+type Conversion struct {
+	Cfg   config.Config
+	Notes []string
+}
+
+func newConversion() *Conversion {
+	conv := new(Conversion)
+	conv.Cfg.Addr = "0.0.0.0:9696"
+	conv.Cfg.User = "root"
+	conv.Cfg.LogLevel = "error"
+	return conv
+}
+
+func (conv *Conversion) notef(format string, args ...interface{}) {
+	conv.Notes = append(conv.Notes, fmt.Sprintf(format, args...))
+}
+
+//the sub tables of a table sharded on nodes with the locations, the
+//physical tables of the middleware must be renamed or split into them
+func (conv *Conversion) noteRenames(db string, table string, from []string, locations []int, split bool) {
+	tables := 0
+	for _, location := range locations {
+		tables += location
+	}
+	var renames []string
+	for i := 0; i < tables && i < len(from); i++ {
+		renames = append(renames, fmt.Sprintf("%s -> %s_%04d", from[i], table, i))
+	}
+	action := "rename the physical tables to the sub tables"
+	if split {
+		action = "move the rows of the physical tables into the sub tables"
+	}
+	conv.notef("table %s.%s: %s, %s", db, table, action, strings.Join(renames, ", "))
+}
+
+func (conv *Conversion) addNode(node config.NodeConfig) {
+	conv.Cfg.Nodes = append(conv.Cfg.Nodes, node)
+	conv.Cfg.Schema.Nodes = append(conv.Cfg.Schema.Nodes, node.Name)
+}
+
+//the schema of mycat, dataNode and database can be a range such as
+//dn$0-2, which is expanded to dn0,dn1,dn2
+type mycatSchemaXml struct {
+	Schemas   []mycatSchema   `xml:"schema"`
+	DataNodes []mycatDataNode `xml:"dataNode"`
+	DataHosts []mycatDataHost `xml:"dataHost"`
+}
+
+type mycatSchema struct {
+	Name     string       `xml:"name,attr"`
+	DataNode string       `xml:"dataNode,attr"`
+	Tables   []mycatTable `xml:"table"`
+}
+
+type mycatTable struct {
+	Name        string       `xml:"name,attr"`
+	DataNode    string       `xml:"dataNode,attr"`
+	Rule        string       `xml:"rule,attr"`
+	Type        string       `xml:"type,attr"`
+	ChildTables []mycatTable `xml:"childTable"`
+}
+
+type mycatDataNode struct {
+	Name     string `xml:"name,attr"`
+	DataHost string `xml:"dataHost,attr"`
+	Database string `xml:"database,attr"`
+}
+
+type mycatDataHost struct {
+	Name       string      `xml:"name,attr"`
+	MaxCon     int         `xml:"maxCon,attr"`
+	WriteHosts []mycatHost `xml:"writeHost"`
+}
+
+type mycatHost struct {
+	Url       string      `xml:"url,attr"`
+	User      string      `xml:"user,attr"`
+	Password  string      `xml:"password,attr"`
+	Weight    string      `xml:"weight,attr"`
+	ReadHosts []mycatHost `xml:"readHost"`
+}
+
+type mycatRuleXml struct {
+	TableRules []struct {
+		Name string `xml:"name,attr"`
+		Rule struct {
+			Columns   string `xml:"columns"`
+			Algorithm string `xml:"algorithm"`
+		} `xml:"rule"`
+	} `xml:"tableRule"`
+	Functions []struct {
+		Name       string `xml:"name,attr"`
+		Class      string `xml:"class,attr"`
+		Properties []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"property"`
+	} `xml:"function"`
+}
+
+//the function of a table rule of mycat
+type mycatFunction struct {
+	column     string
+	class      string //the class name without package
+	properties map[string]string
+}
+
+var mycatRangeRegexp = regexp.MustCompile(`^(.*)\$(\d+)-(\d+)$`)
+
+//expand the names separated by comma, dn$0-2 is dn0,dn1,dn2
+func expandMyCatNames(names string) ([]string, error) {
+	var expanded []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		m := mycatRangeRegexp.FindStringSubmatch(name)
+		if m == nil {
+			expanded = append(expanded, name)
+			continue
+		}
+		start, _ := strconv.Atoi(m[2])
+		end, _ := strconv.Atoi(m[3])
+		if end < start {
+			return nil, fmt.Errorf("invalid range %s", name)
+		}
+		for i := start; i <= end; i++ {
+			expanded = append(expanded, m[1]+strconv.Itoa(i))
+		}
+	}
+	return expanded, nil
+}
+
+//ConvertMyCat converts schema.xml and rule.xml of mycat, each dataNode is
+//a node whose database is renamed from the schema by schema_rewrite. The
+//mapFiles of the functions are read in mapDir.
+func ConvertMyCat(schemaXml io.Reader, ruleXml io.Reader, mapDir string) (*Conversion, error) {
+	var schema mycatSchemaXml
+	if err := xml.NewDecoder(schemaXml).Decode(&schema); err != nil {
+		return nil, fmt.Errorf("parse schema.xml error:%v", err)
+	}
+	var rules mycatRuleXml
+	if ruleXml != nil {
+		if err := xml.NewDecoder(ruleXml).Decode(&rules); err != nil {
+			return nil, fmt.Errorf("parse rule.xml error:%v", err)
+		}
+	}
+	if len(schema.Schemas) == 0 {
+		return nil, fmt.Errorf("no schema in schema.xml")
+	}
+
+	functions := make(map[string]*mycatFunction)
+	for _, f := range rules.Functions {
+		fn := &mycatFunction{
+			class:      f.Class[strings.LastIndex(f.Class, ".")+1:],
+			properties: make(map[string]string),
+		}
+		for _, p := range f.Properties {
+			fn.properties[p.Name] = strings.TrimSpace(p.Value)
+		}
+		functions[f.Name] = fn
+	}
+	tableRules := make(map[string]*mycatFunction)
+	for _, r := range rules.TableRules {
+		f, ok := functions[strings.TrimSpace(r.Rule.Algorithm)]
+		if !ok {
+			return nil, fmt.Errorf("no function %s of tableRule %s", r.Rule.Algorithm, r.Name)
+		}
+		fn := *f
+		fn.column = strings.ToLower(strings.TrimSpace(r.Rule.Columns))
+		tableRules[r.Name] = &fn
+	}
+
+	conv := newConversion()
+	hosts := make(map[string]mycatDataHost)
+	for _, h := range schema.DataHosts {
+		hosts[h.Name] = h
+	}
+	//dataNode -> database
+	databases := make(map[string]string)
+	for _, dn := range schema.DataNodes {
+		names, err := expandMyCatNames(dn.Name)
+		if err != nil {
+			return nil, err
+		}
+		dbs, err := expandMyCatNames(dn.Database)
+		if err != nil {
+			return nil, err
+		}
+		if len(dbs) == 1 {
+			for len(dbs) < len(names) {
+				dbs = append(dbs, dbs[0])
+			}
+		}
+		if len(dbs) != len(names) {
+			return nil, fmt.Errorf("the databases of dataNode %s do not match", dn.Name)
+		}
+		host, ok := hosts[dn.DataHost]
+		if !ok || len(host.WriteHosts) == 0 {
+			return nil, fmt.Errorf("no writeHost of dataHost %s", dn.DataHost)
+		}
+		for i, name := range names {
+			databases[name] = dbs[i]
+			conv.addNode(conv.convertMyCatHost(name, host))
+		}
+	}
+
+	for i, s := range schema.Schemas {
+		if len(s.DataNode) != 0 {
+			if _, ok := databases[s.DataNode]; !ok {
+				return nil, fmt.Errorf("no dataNode %s of schema %s", s.DataNode, s.Name)
+			}
+			if len(conv.Cfg.Schema.Default) == 0 {
+				conv.Cfg.Schema.Default = s.DataNode
+			} else if s.DataNode != conv.Cfg.Schema.Default {
+				conv.notef("schema %s: the default node is %s, the unsharded tables of %s must be configured as single tables",
+					s.Name, conv.Cfg.Schema.Default, s.DataNode)
+			}
+		}
+		if i == len(schema.Schemas)-1 && len(conv.Cfg.Schema.Default) == 0 && 0 < len(conv.Cfg.Nodes) {
+			conv.Cfg.Schema.Default = conv.Cfg.Nodes[0].Name
+			conv.notef("no schema has a dataNode, %s is the default node", conv.Cfg.Schema.Default)
+		}
+	}
+	for _, s := range schema.Schemas {
+		for _, t := range s.Tables {
+			if err := conv.convertMyCatTable(s.Name, t, databases, tableRules, mapDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	conv.addSchemaRewrites(schema.Schemas, databases)
+	return conv, nil
+}
+
+//the first writeHost is the master, its readHosts are the slaves, and the
+//other writeHosts are the backups
+func (conv *Conversion) convertMyCatHost(name string, host mycatDataHost) config.NodeConfig {
+	master := host.WriteHosts[0]
+	node := config.NodeConfig{
+		Name:       name,
+		MaxConnNum: host.MaxCon,
+		User:       master.User,
+		Password:   master.Password,
+		Master:     master.Url,
+	}
+	var slaves, backups []string
+	for _, r := range master.ReadHosts {
+		slave := r.Url
+		if len(r.Weight) != 0 {
+			slave += "@" + r.Weight
+		}
+		slaves = append(slaves, slave)
+		if r.User != master.User || r.Password != master.Password {
+			conv.notef("dataHost %s: readHost %s has another user or password, all the mysql of a node use %s",
+				host.Name, r.Url, master.User)
+		}
+	}
+	for _, w := range host.WriteHosts[1:] {
+		backups = append(backups, w.Url)
+		slaves = append(slaves, hostUrls(w.ReadHosts)...)
+	}
+	if 1 < len(host.WriteHosts) {
+		conv.notef("dataHost %s: the writeHosts except %s are backups, the switch of master is not automatic",
+			host.Name, master.Url)
+	}
+	node.Slave = strings.Join(slaves, ",")
+	node.Backup = strings.Join(backups, ",")
+	return node
+}
+
+func hostUrls(hosts []mycatHost) []string {
+	var urls []string
+	for _, h := range hosts {
+		urls = append(urls, h.Url)
+	}
+	return urls
+}
+
+func (conv *Conversion) convertMyCatTable(db string, t mycatTable, databases map[string]string,
+	tableRules map[string]*mycatFunction, mapDir string) error {
+	names, err := expandMyCatNames(t.Name)
+	if err != nil {
+		return err
+	}
+	nodes, err := expandMyCatNames(t.DataNode)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if _, ok := databases[node]; !ok {
+			return fmt.Errorf("no dataNode %s of table %s", node, t.Name)
+		}
+	}
+	for _, child := range t.ChildTables {
+		conv.notef("table %s.%s: the childTable %s is not supported, it is routed as an unsharded table",
+			db, t.Name, child.Name)
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch {
+		case len(nodes) == 0:
+		case strings.EqualFold(t.Type, "global"):
+			conv.notef("table %s.%s: the global table is converted to a single table on %s, the writes are not sent to %s",
+				db, name, nodes[0], strings.Join(nodes[1:], ","))
+			conv.addSingleTable(db, name, nodes[0])
+		case len(nodes) == 1:
+			conv.addSingleTable(db, name, nodes[0])
+		default:
+			fn, ok := tableRules[t.Rule]
+			if !ok {
+				return fmt.Errorf("no tableRule %s of table %s", t.Rule, t.Name)
+			}
+			if err := conv.convertMyCatRule(db, name, nodes, databases, fn, mapDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (conv *Conversion) addSingleTable(db string, table string, node string) {
+	if node == conv.Cfg.Schema.Default {
+		return
+	}
+	conv.Cfg.Schema.ShardRule = append(conv.Cfg.Schema.ShardRule, config.ShardConfig{
+		DB:    db,
+		Table: table,
+		Type:  router.SingleRuleType,
+		Node:  node,
+	})
+}
+
+//PartitionByMod is the same as hash with a sub table on each node, and
+//AutoPartitionByLong as range if the ranges are of the same size
+func (conv *Conversion) convertMyCatRule(db string, table string, nodes []string,
+	databases map[string]string, fn *mycatFunction, mapDir string) error {
+	from := make([]string, len(nodes))
+	for i, node := range nodes {
+		from[i] = fmt.Sprintf("%s.%s.%s", node, databases[node], table)
+	}
+	shard := config.ShardConfig{
+		DB:    db,
+		Table: table,
+		Key:   fn.column,
+	}
+	switch fn.class {
+	case "PartitionByMod":
+		count, err := strconv.Atoi(fn.properties["count"])
+		if err != nil || count <= 0 || len(nodes) < count {
+			conv.notef("table %s.%s: invalid count %s of PartitionByMod, the table is not converted",
+				db, table, fn.properties["count"])
+			return nil
+		}
+		shard.Type = router.HashRuleType
+		shard.Nodes = nodes[:count]
+		for i := 0; i < count; i++ {
+			shard.Locations = append(shard.Locations, 1)
+		}
+	case "AutoPartitionByLong":
+		ranges, err := readMyCatRanges(filepath.Join(mapDir, fn.properties["mapFile"]))
+		if err != nil {
+			return err
+		}
+		limit, locations, ok := mycatRangeLocations(ranges, len(nodes))
+		if !ok {
+			conv.notef("table %s.%s: the ranges of %s are not of the same size from 0, the table is not converted",
+				db, table, fn.properties["mapFile"])
+			return nil
+		}
+		if len(fn.properties["defaultNode"]) != 0 && fn.properties["defaultNode"] != "-1" {
+			conv.notef("table %s.%s: the keys out of the ranges are errors instead of going to the defaultNode",
+				db, table)
+		}
+		conv.notef("table %s.%s: a key equal to the end of a range, such as %d, goes to the next sub table",
+			db, table, limit)
+		shard.Type = router.RangeRuleType
+		shard.Nodes = nodes[:len(locations)]
+		shard.Locations = locations
+		shard.TableRowLimit = int(limit)
+		//a sub table of each range in the node of mycat
+		from = nil
+		for _, r := range ranges {
+			node := nodes[r.node]
+			from = append(from, fmt.Sprintf("%s.%s.%s[%d-%d]", node, databases[node], table, r.start, r.end))
+		}
+	default:
+		conv.notef("table %s.%s: the function %s is not supported, the table is not converted",
+			db, table, fn.class)
+		return nil
+	}
+	conv.Cfg.Schema.ShardRule = append(conv.Cfg.Schema.ShardRule, shard)
+	conv.noteRenames(db, table, from, shard.Locations, shard.Type == router.RangeRuleType)
+	return nil
+}
+
+type mycatRange struct {
+	start int64
+	end   int64
+	node  int
+}
+
+type mycatRangeList []mycatRange
+
+func (l mycatRangeList) Len() int           { return len(l) }
+func (l mycatRangeList) Less(i, j int) bool { return l[i].start < l[j].start }
+func (l mycatRangeList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+//the lines of mapFile are start-end=node, K is 1000 and M is 10000
+func readMyCatRanges(path string) ([]mycatRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ranges []mycatRange
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		var r mycatRange
+		kv := strings.SplitN(line, "=", 2)
+		bounds := strings.SplitN(kv[0], "-", 2)
+		if len(kv) != 2 || len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range %s in %s", line, path)
+		}
+		if r.start, err = parseMyCatNum(bounds[0]); err == nil {
+			if r.end, err = parseMyCatNum(bounds[1]); err == nil {
+				r.node, err = strconv.Atoi(strings.TrimSpace(kv[1]))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %s in %s", line, path)
+		}
+		ranges = append(ranges, r)
+	}
+	sort.Sort(mycatRangeList(ranges))
+	return ranges, scanner.Err()
+}
+
+func parseMyCatNum(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1000
+	case strings.HasSuffix(s, "M"):
+		unit = 10000
+	}
+	n, err := strconv.ParseInt(strings.TrimRight(s, "KM"), 10, 64)
+	return n * unit, err
+}
+
+//the ranges of the same size from 0 are sub tables of a range rule, the
+//ranges of a node must be contiguous
+func mycatRangeLocations(ranges []mycatRange, nodes int) (int64, []int, bool) {
+	if len(ranges) == 0 || ranges[0].start != 0 {
+		return 0, nil, false
+	}
+	limit := ranges[0].end - ranges[0].start
+	var locations []int
+	for i, r := range ranges {
+		if limit <= 0 || r.end-r.start != limit || r.start != int64(i)*limit ||
+			r.node < len(locations)-1 || len(locations) < r.node || nodes <= r.node {
+			return 0, nil, false
+		}
+		if r.node == len(locations) {
+			locations = append(locations, 0)
+		}
+		locations[r.node]++
+	}
+	return limit, locations, true
+}
+
+//the database of the schema in each dataNode, renamed if they differ
+func (conv *Conversion) addSchemaRewrites(schemas []mycatSchema, databases map[string]string) {
+	for i := range conv.Cfg.Nodes {
+		node := &conv.Cfg.Nodes[i]
+		for _, s := range schemas {
+			if database := databases[node.Name]; database != s.Name {
+				if node.SchemaRewrite == nil {
+					node.SchemaRewrite = make(map[string]string)
+				}
+				node.SchemaRewrite[s.Name] = database
+			}
+		}
+		if 1 < len(schemas) && node.SchemaRewrite != nil {
+			conv.notef("node %s: all the schemas are renamed to the database %s",
+				node.Name, databases[node.Name])
+		}
+	}
+}
+
+//ConvertAtlas converts the cnf of atlas, the backends are a node, and the
+//tables are sharded in it by tables = db.table.key.count
+func ConvertAtlas(cnf io.Reader) (*Conversion, error) {
+	conv := newConversion()
+	node := config.NodeConfig{Name: "node1", User: "root"}
+	var tables []string
+	scanner := bufio.NewScanner(cnf)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "proxy-address":
+			conv.Cfg.Addr = value
+		case "proxy-backend-addresses":
+			masters := splitTrim(value)
+			if len(masters) != 0 {
+				node.Master = masters[0]
+			}
+			if 1 < len(masters) {
+				node.Backup = strings.Join(masters[1:], ",")
+				conv.notef("proxy-backend-addresses: %s are backups, only %s is written",
+					node.Backup, node.Master)
+			}
+		case "proxy-read-only-backend-addresses":
+			node.Slave = strings.Join(splitTrim(value), ",")
+		case "pwds":
+			users := splitTrim(value)
+			if 0 < len(users) {
+				user := strings.SplitN(users[0], ":", 2)
+				node.User = user[0]
+				conv.Cfg.User = user[0]
+				conv.notef("pwds: the passwords are encrypted, set the password of node1 and kingshard for %s", user[0])
+			}
+			if 1 < len(users) {
+				conv.notef("pwds: only the user %s is converted, add the other users to users", node.User)
+			}
+		case "charset":
+			conv.Cfg.Charset = value
+		case "client-ips":
+			conv.Cfg.AllowIps = strings.Join(splitTrim(value), ",")
+		case "log-level":
+			conv.Cfg.LogLevel = atlasLogLevel(value)
+		case "tables":
+			tables = splitTrim(value)
+		case "sql-log", "sql-log-slow":
+			conv.notef("%s: use log_sql and slow_log_time instead", key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(node.Master) == 0 {
+		return nil, fmt.Errorf("no proxy-backend-addresses")
+	}
+	conv.addNode(node)
+	conv.Cfg.Schema.Default = node.Name
+
+	for _, table := range tables {
+		//db.table.key.count
+		parts := strings.Split(table, ".")
+		count := 0
+		if len(parts) == 4 {
+			count, _ = strconv.Atoi(parts[3])
+		}
+		if count <= 0 {
+			return nil, fmt.Errorf("invalid table %s", table)
+		}
+		shard := config.ShardConfig{
+			DB:        parts[0],
+			Table:     strings.ToLower(parts[1]),
+			Key:       strings.ToLower(parts[2]),
+			Type:      router.HashRuleType,
+			Nodes:     []string{node.Name},
+			Locations: []int{count},
+		}
+		conv.Cfg.Schema.ShardRule = append(conv.Cfg.Schema.ShardRule, shard)
+		from := make([]string, count)
+		for i := range from {
+			from[i] = fmt.Sprintf("%s_%d", parts[1], i)
+		}
+		conv.noteRenames(shard.DB, shard.Table, from, shard.Locations, false)
+	}
+	return conv, nil
+}
+
+func splitTrim(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func atlasLogLevel(level string) string {
+	switch level {
+	case "debug":
+		return "debug"
+	case "message", "info":
+		return "info"
+	case "warning":
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+type vitessVSchema struct {
+	Sharded  bool `json:"sharded"`
+	Vindexes map[string]struct {
+		Type string `json:"type"`
+	} `json:"vindexes"`
+	Tables map[string]struct {
+		Type           string `json:"type"`
+		ColumnVindexes []struct {
+			Column  string   `json:"column"`
+			Columns []string `json:"columns"`
+			Name    string   `json:"name"`
+		} `json:"column_vindexes"`
+		AutoIncrement *struct {
+			Column string `json:"column"`
+		} `json:"auto_increment"`
+	} `json:"tables"`
+}
+
+//ConvertVitess converts the vschema of keyspace db, the shards are nodes
+//node1 to nodeN whose masters are to be set. The tables are sharded by
+//the column of their primary vindex.
+func ConvertVitess(vschema io.Reader, db string, nodeCount int) (*Conversion, error) {
+	var vs vitessVSchema
+	if err := json.NewDecoder(vschema).Decode(&vs); err != nil {
+		return nil, fmt.Errorf("parse vschema error:%v", err)
+	}
+	if nodeCount <= 0 {
+		return nil, fmt.Errorf("invalid node count %d", nodeCount)
+	}
+	conv := newConversion()
+	var nodes []string
+	for i := 1; i <= nodeCount; i++ {
+		node := config.NodeConfig{Name: fmt.Sprintf("node%d", i), User: "root"}
+		conv.addNode(node)
+		nodes = append(nodes, node.Name)
+	}
+	conv.Cfg.Schema.Default = nodes[0]
+	conv.notef("the vschema has no address of shards, set the master, user and password of %s",
+		strings.Join(nodes, ","))
+	if !vs.Sharded {
+		return conv, nil
+	}
+
+	var tables []string
+	for table := range vs.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	resharded := false
+	for _, table := range tables {
+		t := vs.Tables[table]
+		if t.Type == "reference" || t.Type == "sequence" {
+			conv.notef("table %s.%s: the %s table is on the default node %s", db, table, t.Type, nodes[0])
+			continue
+		}
+		if t.AutoIncrement != nil {
+			conv.notef("table %s.%s: the auto_increment of %s by sequence is not supported", db, table, t.AutoIncrement.Column)
+		}
+		if len(t.ColumnVindexes) == 0 {
+			conv.notef("table %s.%s: no primary vindex, the table is on the default node %s", db, table, nodes[0])
+			continue
+		}
+		primary := t.ColumnVindexes[0]
+		column := primary.Column
+		if len(column) == 0 && len(primary.Columns) != 0 {
+			column = primary.Columns[0]
+			if 1 < len(primary.Columns) {
+				conv.notef("table %s.%s: the table is sharded by the first column %s of the multi-column vindex",
+					db, table, column)
+			}
+		}
+		for _, v := range t.ColumnVindexes[1:] {
+			conv.notef("table %s.%s: the secondary vindex %s is not supported, the selects by it are sent to all the sub tables",
+				db, table, v.Name)
+		}
+		shard := config.ShardConfig{
+			DB:    db,
+			Table: strings.ToLower(table),
+			Key:   strings.ToLower(column),
+			Type:  router.HashRuleType,
+			Nodes: nodes,
+		}
+		for range nodes {
+			shard.Locations = append(shard.Locations, 1)
+		}
+		switch vs.Vindexes[primary.Name].Type {
+		case "hash", "numeric", "xxhash", "reverse_bits":
+		case "unicode_loose_md5", "unicode_loose_xxhash", "binary_md5":
+			shard.KeyType = router.StringKeyType
+		default:
+			conv.notef("table %s.%s: the vindex %s of type %s is not supported, the table is not converted",
+				db, table, primary.Name, vs.Vindexes[primary.Name].Type)
+			continue
+		}
+		conv.Cfg.Schema.ShardRule = append(conv.Cfg.Schema.ShardRule, shard)
+		from := make([]string, len(nodes))
+		for i, node := range nodes {
+			from[i] = fmt.Sprintf("%s.%s.%s", node, db, table)
+		}
+		conv.noteRenames(db, shard.Table, from, shard.Locations, false)
+		resharded = true
+	}
+	if resharded {
+		conv.notef("the keyspace ids of vindexes are not the sub tables of kingshard, the rows must be resharded, such as by kingshard import")
+	}
+	return conv, nil
+}
+
+var yamlPlainRegexp = regexp.MustCompile(`^[A-Za-z0-9_./][A-Za-z0-9_./:@,-]*$`)
+
+//the scalar of yaml, quoted unless it is a plain string, the numbers and
+//booleans are quoted too
+func yamlScalar(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil && yamlPlainRegexp.MatchString(s) {
+		switch strings.ToLower(s) {
+		case "y", "n", "yes", "no", "on", "off", "true", "false", "null":
+		default:
+			return s
+		}
+	}
+	return strconv.Quote(s)
+}
+
+func yamlList(values []string) string {
+	for i, v := range values {
+		values[i] = yamlScalar(v)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+//write the fields of cfg set by the conversions, in the layout of ks.yaml
+func writeConvertedConfig(w io.Writer, cfg *config.Config, from string) {
+	fmt.Fprintf(w, "# converted from %s by kingshard convert\n", from)
+	fmt.Fprintf(w, "addr : %s\n", yamlScalar(cfg.Addr))
+	fmt.Fprintf(w, "user : %s\n", yamlScalar(cfg.User))
+	fmt.Fprintf(w, "password : %s\n", yamlScalar(cfg.Password))
+	fmt.Fprintf(w, "log_level : %s\n", yamlScalar(cfg.LogLevel))
+	if len(cfg.AllowIps) != 0 {
+		fmt.Fprintf(w, "allow_ips : %s\n", yamlScalar(cfg.AllowIps))
+	}
+	if len(cfg.Charset) != 0 {
+		fmt.Fprintf(w, "proxy_charset : %s\n", yamlScalar(cfg.Charset))
+	}
+
+	fmt.Fprintf(w, "\nnodes :\n")
+	for _, n := range cfg.Nodes {
+		fmt.Fprintf(w, "-\n")
+		fmt.Fprintf(w, "    name : %s\n", yamlScalar(n.Name))
+		if 0 < n.MaxConnNum {
+			fmt.Fprintf(w, "    max_conns_limit : %d\n", n.MaxConnNum)
+		}
+		fmt.Fprintf(w, "    user : %s\n", yamlScalar(n.User))
+		fmt.Fprintf(w, "    password : %s\n", yamlScalar(n.Password))
+		fmt.Fprintf(w, "    master : %s\n", yamlScalar(n.Master))
+		if len(n.Slave) != 0 {
+			fmt.Fprintf(w, "    slave : %s\n", yamlScalar(n.Slave))
+		}
+		if len(n.Backup) != 0 {
+			fmt.Fprintf(w, "    backup : %s\n", yamlScalar(n.Backup))
+		}
+		if len(n.SchemaRewrite) != 0 {
+			fmt.Fprintf(w, "    schema_rewrite :\n")
+			var dbs []string
+			for db := range n.SchemaRewrite {
+				dbs = append(dbs, db)
+			}
+			sort.Strings(dbs)
+			for _, db := range dbs {
+				fmt.Fprintf(w, "        %s : %s\n", yamlScalar(db), yamlScalar(n.SchemaRewrite[db]))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nschema :\n")
+	fmt.Fprintf(w, "    nodes : %s\n", yamlList(append([]string(nil), cfg.Schema.Nodes...)))
+	fmt.Fprintf(w, "    default : %s\n", yamlScalar(cfg.Schema.Default))
+	if len(cfg.Schema.ShardRule) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "    shard :\n")
+	for _, s := range cfg.Schema.ShardRule {
+		fmt.Fprintf(w, "    -\n")
+		fmt.Fprintf(w, "        db : %s\n", yamlScalar(s.DB))
+		fmt.Fprintf(w, "        table : %s\n", yamlScalar(s.Table))
+		fmt.Fprintf(w, "        type : %s\n", s.Type)
+		if s.Type == router.SingleRuleType {
+			fmt.Fprintf(w, "        node : %s\n", yamlScalar(s.Node))
+			continue
+		}
+		fmt.Fprintf(w, "        key : %s\n", yamlScalar(s.Key))
+		if len(s.KeyType) != 0 {
+			fmt.Fprintf(w, "        key_type : %s\n", s.KeyType)
+		}
+		fmt.Fprintf(w, "        nodes : %s\n", yamlList(append([]string(nil), s.Nodes...)))
+		var locations []string
+		for _, l := range s.Locations {
+			locations = append(locations, strconv.Itoa(l))
+		}
+		fmt.Fprintf(w, "        locations : [%s]\n", strings.Join(locations, ","))
+		if 0 < s.TableRowLimit {
+			fmt.Fprintf(w, "        table_row_limit : %d\n", s.TableRowLimit)
+		}
+	}
+}
+
+func writeConvertReport(w io.Writer, conv *Conversion) {
+	if len(conv.Notes) == 0 {
+		fmt.Fprintln(w, "converted without notes")
+		return
+	}
+	fmt.Fprintf(w, "%d notes:\n", len(conv.Notes))
+	for i, note := range conv.Notes {
+		fmt.Fprintf(w, "%d. %s\n", i+1, note)
+	}
+}
+
+//kingshard convert -from mycat -schema schema.xml -rule rule.xml, the
+//sharding config of mycat, atlas or vitess is converted into a kingshard
+//config, and the constructs not converted and the manual steps are
+//reported.
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := fs.String("from", FromMyCat, "the middleware: "+FromMyCat+","+FromAtlas+","+FromVitess)
+	schemaFile := fs.String("schema", "", "schema.xml of mycat, the cnf of atlas or the vschema of vitess")
+	ruleFile := fs.String("rule", "", "rule.xml of mycat, in the dir of schema if empty")
+	db := fs.String("db", "", "the keyspace of vitess")
+	nodeCount := fs.Int("nodes", 2, "the shards of vitess")
+	out := fs.String("out", "-", "the kingshard config file, - is stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*schemaFile) == 0 {
+		fmt.Println("need schema")
+		return 2
+	}
+	f, err := os.Open(*schemaFile)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	defer f.Close()
+
+	var conv *Conversion
+	switch *from {
+	case FromMyCat:
+		dir := filepath.Dir(*schemaFile)
+		if len(*ruleFile) == 0 {
+			*ruleFile = filepath.Join(dir, "rule.xml")
+		}
+		var rule *os.File
+		if rule, err = os.Open(*ruleFile); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		defer rule.Close()
+		conv, err = ConvertMyCat(f, rule, filepath.Dir(*ruleFile))
+	case FromAtlas:
+		conv, err = ConvertAtlas(f)
+	case FromVitess:
+		if len(*db) == 0 {
+			fmt.Println("need db")
+			return 2
+		}
+		conv, err = ConvertVitess(f, *db, *nodeCount)
+	default:
+		fmt.Printf("invalid from %s\n", *from)
+		return 2
+	}
+	if err == nil {
+		_, err = router.NewRouter(&conv.Cfg.Schema)
+	}
+	if err != nil {
+		fmt.Printf("convert error:%v\n", err)
+		return 2
+	}
+
+	if *out == "-" {
+		writeConvertedConfig(os.Stdout, &conv.Cfg, *from)
+		fmt.Println()
+	} else {
+		var buf bytes.Buffer
+		writeConvertedConfig(&buf, &conv.Cfg, *from)
+		if err = ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+	writeConvertReport(os.Stderr, conv)
+	if len(conv.Notes) != 0 {
+		return 1
+	}
+	return 0
+}
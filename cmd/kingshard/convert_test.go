@@ -0,0 +1,229 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+var mycatSchemaData = `<?xml version="1.0"?>
+<!DOCTYPE mycat:schema SYSTEM "schema.dtd">
+<mycat:schema xmlns:mycat="http://io.mycat/">
+	<schema name="TESTDB" checkSQLschema="false" sqlMaxLimit="100" dataNode="dn1">
+		<table name="orders" dataNode="dn$1-3" rule="mod-long" />
+		<table name="users" dataNode="dn1,dn2" rule="auto-sharding-long" />
+		<table name="company" dataNode="dn1,dn2,dn3" type="global" />
+		<table name="logs" dataNode="dn2" />
+		<table name="customer" dataNode="dn1,dn2" rule="sharding-by-intfile">
+			<childTable name="address" joinKey="customer_id" parentKey="id" />
+		</table>
+	</schema>
+	<dataNode name="dn$1-3" dataHost="localhost1" database="db$1-3" />
+	<dataHost name="localhost1" maxCon="1000" minCon="10" balance="1">
+		<heartbeat>select user()</heartbeat>
+		<writeHost host="hostM1" url="127.0.0.1:3306" user="root" password="123456">
+			<readHost host="hostS1" url="127.0.0.1:3307" user="root" password="123456" />
+		</writeHost>
+	</dataHost>
+</mycat:schema>
+`
+
+var mycatRuleData = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE mycat:rule SYSTEM "rule.dtd">
+<mycat:rule xmlns:mycat="http://io.mycat/">
+	<tableRule name="mod-long">
+		<rule><columns>id</columns><algorithm>mod-long</algorithm></rule>
+	</tableRule>
+	<tableRule name="auto-sharding-long">
+		<rule><columns>uid</columns><algorithm>rang-long</algorithm></rule>
+	</tableRule>
+	<tableRule name="sharding-by-intfile">
+		<rule><columns>sharding_id</columns><algorithm>hash-int</algorithm></rule>
+	</tableRule>
+	<function name="mod-long" class="io.mycat.route.function.PartitionByMod">
+		<property name="count">3</property>
+	</function>
+	<function name="rang-long" class="io.mycat.route.function.AutoPartitionByLong">
+		<property name="mapFile">autopartition-long.txt</property>
+	</function>
+	<function name="hash-int" class="io.mycat.route.function.PartitionByFileMap">
+		<property name="mapFile">partition-hash-int.txt</property>
+	</function>
+</mycat:rule>
+`
+
+//the converted config is parsed again, and routes the keys to the nodes
+//of mycat
+func TestConvertMyCat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mapFile := "# range start-end ,data node index\n0-500M=0\n500M-1000M=0\n1000M-1500M=1\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "autopartition-long.txt"), []byte(mapFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := ConvertMyCat(strings.NewReader(mycatSchemaData), strings.NewReader(mycatRuleData), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeConvertedConfig(&buf, &conv.Cfg, FromMyCat)
+	cfg, err := config.ParseConfigData(buf.Bytes())
+	if err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(cfg.Nodes) != 3 || cfg.Nodes[1].Master != "127.0.0.1:3306" || cfg.Nodes[1].Slave != "127.0.0.1:3307" ||
+		cfg.Nodes[1].Password != "123456" || cfg.Nodes[1].SchemaRewrite["TESTDB"] != "db2" || cfg.Schema.Default != "dn1" {
+		t.Fatal(buf.String())
+	}
+	r, err := router.NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		table string
+		key   string
+		node  string
+		sub   string
+	}{
+		{"orders", "7", "dn2", "orders_0001"},
+		{"orders", "9", "dn1", "orders_0000"},
+		{"users", "4999999", "dn1", "users_0000"},
+		{"users", "5000001", "dn1", "users_0001"},
+		{"users", "12345678", "dn2", "users_0002"},
+		{"logs", "1", "dn2", "logs"},
+		{"company", "1", "dn1", "company"},
+		{"customer", "1", "dn1", "customer"},
+	}
+	for _, tt := range tests {
+		loc, err := r.Locate("TESTDB", tt.table, tt.key)
+		if err != nil {
+			t.Fatal(tt.table, err)
+		}
+		if loc.Node != tt.node || loc.SubTable != tt.sub {
+			t.Fatal(tt.table, tt.key, loc)
+		}
+	}
+
+	expected := []string{
+		"dn1.db1.orders -> orders_0000, dn2.db2.orders -> orders_0001, dn3.db3.orders -> orders_0002",
+		"a key equal to the end of a range, such as 5000000",
+		"global table is converted to a single table on dn1",
+		"childTable address is not supported",
+		"the function PartitionByFileMap is not supported",
+	}
+	report := strings.Join(conv.Notes, "\n")
+	for _, s := range expected {
+		if !strings.Contains(report, s) {
+			t.Fatal(s, report)
+		}
+	}
+}
+
+var atlasCnf = `[mysql-proxy]
+admin-username = user
+proxy-backend-addresses = 127.0.0.1:3306
+proxy-read-only-backend-addresses = 127.0.0.1:3307@1, 127.0.0.1:3308@2
+pwds = buser:tPcRz3wXnbY=
+log-level = message
+proxy-address = 0.0.0.0:1234
+charset = utf8
+tables = person.mt.id.3
+`
+
+func TestConvertAtlas(t *testing.T) {
+	conv, err := ConvertAtlas(strings.NewReader(atlasCnf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeConvertedConfig(&buf, &conv.Cfg, FromAtlas)
+	cfg, err := config.ParseConfigData(buf.Bytes())
+	if err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if cfg.Addr != "0.0.0.0:1234" || cfg.LogLevel != "info" || cfg.Charset != "utf8" ||
+		cfg.Nodes[0].User != "buser" || cfg.Nodes[0].Slave != "127.0.0.1:3307@1,127.0.0.1:3308@2" {
+		t.Fatal(buf.String())
+	}
+	r, err := router.NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc, err := r.Locate("person", "mt", "5"); err != nil || loc.SubTable != "mt_0002" {
+		t.Fatal(loc, err)
+	}
+	if report := strings.Join(conv.Notes, "\n"); !strings.Contains(report, "the passwords are encrypted") ||
+		!strings.Contains(report, "mt_2 -> mt_0002") {
+		t.Fatal(report)
+	}
+}
+
+var vitessVSchemaJson = `{
+	"sharded": true,
+	"vindexes": {
+		"hash": {"type": "hash"},
+		"md5": {"type": "unicode_loose_md5"},
+		"name_lookup": {"type": "consistent_lookup", "params": {"table": "name_idx"}}
+	},
+	"tables": {
+		"customer": {
+			"column_vindexes": [{"column": "customer_id", "name": "hash"}, {"column": "name", "name": "name_lookup"}],
+			"auto_increment": {"column": "customer_id", "sequence": "customer_seq"}
+		},
+		"tag": {"column_vindexes": [{"column": "name", "name": "md5"}]},
+		"country": {"type": "reference"}
+	}
+}`
+
+func TestConvertVitess(t *testing.T) {
+	conv, err := ConvertVitess(strings.NewReader(vitessVSchemaJson), "commerce", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeConvertedConfig(&buf, &conv.Cfg, FromVitess)
+	cfg, err := config.ParseConfigData(buf.Bytes())
+	if err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(cfg.Schema.ShardRule) != 2 || cfg.Schema.ShardRule[1].KeyType != router.StringKeyType {
+		t.Fatal(buf.String())
+	}
+	r, err := router.NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc, err := r.Locate("commerce", "customer", "6"); err != nil || loc.Node != "node3" {
+		t.Fatal(loc, err)
+	}
+	report := strings.Join(conv.Notes, "\n")
+	for _, s := range []string{"secondary vindex name_lookup", "the reference table", "must be resharded", "auto_increment"} {
+		if !strings.Contains(report, s) {
+			t.Fatal(s, report)
+		}
+	}
+}
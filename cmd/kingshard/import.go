@@ -0,0 +1,575 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the formats of kingshard import
+const (
+	ImportSql = "sql" //the INSERT statements, such as a mysqldump file
+	ImportCsv = "csv" //a row per line, \N is NULL
+)
+
+//the NULL of csv, the same as mysqldump --tab
+const csvNull = `\N`
+
+type ImportOptions struct {
+	DB     string
+	Table  string
+	Format string
+	//the columns of the rows, the header of csv or the columns of the
+	//INSERT statements are used if empty
+	Columns     []string
+	Charset     string
+	BatchRows   int //the rows of an INSERT statement
+	Concurrency int //the conns of each node
+}
+
+//Importer routes the rows of a logical table by the shard key, and loads
+//them into the sub tables by batched INSERT statements sent to the
+//masters, the nodes are loaded in parallel. The rows loaded before an
+//error are kept, there is no transaction across the batches.
+type Importer struct {
+	opts   ImportOptions
+	router *router.Router
+	rule   *router.Rule
+	nodes  map[string]*config.NodeConfig
+	//node -> the tls and ssh tunnel of its conns
+	transports map[string]backend.Transport
+
+	//the key index of the columns of an INSERT statement or csv
+	keyIndexs map[string]int
+	//node:sub_table and columns -> the rows not sent yet
+	batches map[string]*importBatch
+	//node -> the batches to send
+	queues map[string]chan *importBatch
+	wg     sync.WaitGroup
+
+	lock sync.Mutex
+	err  error
+	//node:sub_table -> the rows loaded
+	Rows map[string]int64
+	//the INSERT statements of other tables, which are skipped
+	Skipped int64
+}
+
+type importBatch struct {
+	dest    string //node:sub_table
+	node    string
+	table   string //db.sub_table of the backend
+	columns string
+	rows    []string
+}
+
+func (b *importBatch) sql() string {
+	return fmt.Sprintf("INSERT INTO %s %sVALUES %s", b.table, b.columns, strings.Join(b.rows, ","))
+}
+
+func NewImporter(cfg *config.Config, opts ImportOptions) (*Importer, error) {
+	r, err := router.NewRouter(&cfg.Schema)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case ImportSql, ImportCsv:
+	default:
+		return nil, fmt.Errorf("invalid format %s", opts.Format)
+	}
+	if opts.BatchRows <= 0 || opts.Concurrency <= 0 {
+		return nil, fmt.Errorf("batch rows and concurrency must be positive")
+	}
+	rule := r.GetRule(opts.DB, opts.Table)
+	if rule.NoRewrite {
+		return nil, fmt.Errorf("table %s is no_rewrite, the rows can not be routed", opts.Table)
+	}
+
+	imp := &Importer{
+		opts:       opts,
+		router:     r,
+		rule:       rule,
+		nodes:      make(map[string]*config.NodeConfig),
+		transports: make(map[string]backend.Transport),
+		keyIndexs:  make(map[string]int),
+		batches:    make(map[string]*importBatch),
+		queues:     make(map[string]chan *importBatch),
+		Rows:       make(map[string]int64),
+	}
+	for i, node := range cfg.Nodes {
+		imp.nodes[node.Name] = &cfg.Nodes[i]
+		if imp.transports[node.Name], err = backend.ParseTransport(node); err != nil {
+			return nil, err
+		}
+	}
+	return imp, nil
+}
+
+//Import loads the rows of r, it returns the first error of reading,
+//routing or loading
+func (imp *Importer) Import(r io.Reader) error {
+	if err := imp.start(); err != nil {
+		return err
+	}
+	var err error
+	if imp.opts.Format == ImportCsv {
+		err = imp.readCsv(r)
+	} else {
+		err = readStatements(r, imp.addStatement)
+	}
+	if err == nil {
+		for _, b := range imp.batches {
+			imp.send(b)
+		}
+	}
+	for _, queue := range imp.queues {
+		close(queue)
+	}
+	imp.wg.Wait()
+	if err != nil {
+		return err
+	}
+	return imp.error()
+}
+
+//connect the masters of the nodes, and start Concurrency loaders for
+//each node
+func (imp *Importer) start() error {
+	var conns []*backend.Conn
+	for _, name := range imp.rule.Nodes {
+		if name == router.BlackholeNode {
+			continue
+		}
+		node, ok := imp.nodes[name]
+		if !ok {
+			return fmt.Errorf("no node %s in config", name)
+		}
+		queue := make(chan *importBatch, imp.opts.Concurrency)
+		for i := 0; i < imp.opts.Concurrency; i++ {
+			c := new(backend.Conn)
+			c.SetTransport(imp.transports[name])
+			err := c.Connect(node.Master, node.User, node.Password, "")
+			if err == nil && len(imp.opts.Charset) != 0 {
+				err = c.SetCharset(imp.opts.Charset, 0)
+			}
+			if err != nil {
+				c.Close()
+				for _, c := range conns {
+					c.Close()
+				}
+				return fmt.Errorf("connect node %s error:%v", name, err)
+			}
+			conns = append(conns, c)
+		}
+		imp.queues[name] = queue
+	}
+
+	i := 0
+	for _, name := range imp.rule.Nodes {
+		queue, ok := imp.queues[name]
+		if !ok {
+			continue
+		}
+		for j := 0; j < imp.opts.Concurrency; j++ {
+			imp.wg.Add(1)
+			go imp.load(conns[i], queue)
+			i++
+		}
+	}
+	return nil
+}
+
+//execute the batches of queue, the batches after an error are dropped
+func (imp *Importer) load(c *backend.Conn, queue chan *importBatch) {
+	defer imp.wg.Done()
+	defer c.Close()
+	for b := range queue {
+		if imp.error() != nil {
+			continue
+		}
+		if _, err := c.Execute(b.sql()); err != nil {
+			imp.setError(fmt.Errorf("load %s error:%v", b.dest, err))
+			continue
+		}
+		imp.lock.Lock()
+		imp.Rows[b.dest] += int64(len(b.rows))
+		imp.lock.Unlock()
+	}
+}
+
+func (imp *Importer) error() error {
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+	return imp.err
+}
+
+func (imp *Importer) setError(err error) {
+	imp.lock.Lock()
+	if imp.err == nil {
+		imp.err = err
+	}
+	imp.lock.Unlock()
+}
+
+//add a row to the batch of its sub table, key is the value of shard key
+//and row is the values in parentheses
+func (imp *Importer) addRow(columns string, key string, row string) error {
+	if err := imp.error(); err != nil {
+		return err
+	}
+	loc, err := imp.router.Locate(imp.rule.DB, imp.opts.Table, key)
+	if err != nil {
+		return err
+	}
+	if loc.Node == router.BlackholeNode {
+		return fmt.Errorf("key %s is in the decommissioned sub table %s", key, loc.SubTable)
+	}
+	dest := loc.Node + ":" + loc.SubTable
+	b, ok := imp.batches[dest+columns]
+	if !ok {
+		db := loc.DB
+		if rewrite, ok := imp.nodes[loc.Node].SchemaRewrite[db]; ok {
+			db = rewrite
+		}
+		b = &importBatch{
+			dest:    dest,
+			node:    loc.Node,
+			table:   fmt.Sprintf("`%s`.`%s`", db, loc.SubTable),
+			columns: columns,
+		}
+		imp.batches[dest+columns] = b
+	}
+	b.rows = append(b.rows, row)
+	if imp.opts.BatchRows <= len(b.rows) {
+		imp.send(b)
+	}
+	return nil
+}
+
+func (imp *Importer) send(b *importBatch) {
+	if len(b.rows) == 0 {
+		return
+	}
+	imp.queues[b.node] <- &importBatch{
+		dest:    b.dest,
+		node:    b.node,
+		table:   b.table,
+		columns: b.columns,
+		rows:    b.rows,
+	}
+	b.rows = nil
+}
+
+//the index of shard key in columns, -1 if the table is not sharded
+func (imp *Importer) keyIndex(columns []string) (int, error) {
+	if imp.rule.Type == router.DefaultRuleType {
+		return -1, nil
+	}
+	for i, column := range columns {
+		if strings.ToLower(strings.Trim(column, "`")) == imp.rule.Key {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no shard key %s in columns %s", imp.rule.Key, strings.Join(columns, ","))
+}
+
+//the columns of the INSERT statements without columns, which are the
+//columns of Columns or the table
+func (imp *Importer) tableColumns() ([]string, error) {
+	if len(imp.opts.Columns) != 0 || imp.rule.Type == router.DefaultRuleType {
+		return imp.opts.Columns, nil
+	}
+	tableIndex := imp.rule.FirstSubTable()
+	name := imp.rule.Nodes[imp.rule.TableToNode[tableIndex]]
+	node := imp.nodes[name]
+	db := imp.rule.DB
+	if rewrite, ok := node.SchemaRewrite[db]; ok {
+		db = rewrite
+	}
+	c := new(backend.Conn)
+	c.SetTransport(imp.transports[name])
+	if err := c.Connect(node.Master, node.User, node.Password, ""); err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	r, err := c.Execute(fmt.Sprintf("SELECT * FROM `%s`.`%s_%04d` LIMIT 0", db, imp.rule.Table, tableIndex))
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		columns[i] = string(f.Name)
+	}
+	imp.opts.Columns = columns
+	return columns, nil
+}
+
+//add the rows of an INSERT statement of the table, the other statements
+//are ignored
+func (imp *Importer) addStatement(sql string) error {
+	if !strings.HasPrefix(strings.ToUpper(sql), "INSERT") {
+		return nil
+	}
+	stmt, err := sqlparser.Parse(strings.TrimSuffix(sql, ";"))
+	if err != nil {
+		return err
+	}
+	insert, ok := stmt.(*sqlparser.Insert)
+	if !ok {
+		return nil
+	}
+	if !strings.EqualFold(strings.Trim(string(insert.Table.Name), "`"), imp.opts.Table) {
+		imp.Skipped++
+		return nil
+	}
+	values, ok := insert.Rows.(sqlparser.Values)
+	if !ok || insert.OnDup != nil {
+		return fmt.Errorf("only INSERT ... VALUES is supported")
+	}
+
+	var columns string
+	var names []string
+	for _, c := range insert.Columns {
+		names = append(names, sqlparser.String(c))
+	}
+	if len(names) != 0 {
+		columns = columnList(names)
+	}
+	keyIndex, ok := imp.keyIndexs[columns]
+	if !ok {
+		if len(names) == 0 {
+			if names, err = imp.tableColumns(); err != nil {
+				return err
+			}
+		}
+		if keyIndex, err = imp.keyIndex(names); err != nil {
+			return err
+		}
+		imp.keyIndexs[columns] = keyIndex
+	}
+
+	for _, tuple := range values {
+		row, ok := tuple.(sqlparser.ValTuple)
+		if !ok {
+			return fmt.Errorf("invalid row %s", sqlparser.String(tuple))
+		}
+		var key string
+		if 0 <= keyIndex {
+			if len(row) <= keyIndex {
+				return fmt.Errorf("no shard key in row %s", sqlparser.String(row))
+			}
+			switch v := row[keyIndex].(type) {
+			case sqlparser.StrVal:
+				key = string(v)
+			case sqlparser.NumVal:
+				key = string(v)
+			default:
+				return fmt.Errorf("invalid shard key in row %s", sqlparser.String(row))
+			}
+		}
+		if err = imp.addRow(columns, key, sqlparser.String(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//add the rows of csv, the first line is the header of columns if
+//Columns is empty
+func (imp *Importer) readCsv(r io.Reader) error {
+	cr := csv.NewReader(r)
+	columns := imp.opts.Columns
+	if len(columns) == 0 {
+		header, err := cr.Read()
+		if err != nil {
+			return fmt.Errorf("read csv header error:%v", err)
+		}
+		columns = header
+	}
+	keyIndex, err := imp.keyIndex(columns)
+	if err != nil {
+		return err
+	}
+	list := columnList(columns)
+
+	cr.FieldsPerRecord = len(columns)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var key string
+		if 0 <= keyIndex {
+			if key = record[keyIndex]; key == csvNull {
+				return fmt.Errorf("NULL shard key in line %v", record)
+			}
+		}
+		values := make([]string, len(record))
+		for i, v := range record {
+			if v == csvNull {
+				values[i] = "NULL"
+			} else {
+				values[i] = "'" + mysql.Escape(v) + "'"
+			}
+		}
+		if err = imp.addRow(list, key, "("+strings.Join(values, ",")+")"); err != nil {
+			return err
+		}
+	}
+}
+
+//the quoted columns in parentheses, such as (`id`,`name`)
+func columnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "`" + strings.Trim(column, "`") + "`"
+	}
+	return "(" + strings.Join(quoted, ",") + ") "
+}
+
+//read the statements of a sql file, a statement ends with ; at the end of
+//a line, the comment lines out of statements are skipped
+func readStatements(r io.Reader, fn func(sql string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var stmt []string
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(stmt) == 0 && (len(line) == 0 || strings.HasPrefix(line, "--") ||
+			strings.HasPrefix(line, "#")) {
+			continue
+		}
+		stmt = append(stmt, line)
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+		if err := fn(strings.Join(stmt, "\n")); err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		stmt = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(stmt) != 0 {
+		return fn(strings.Join(stmt, "\n"))
+	}
+	return nil
+}
+
+func writeImportReport(w io.Writer, imp *Importer) {
+	var dests []string
+	var total int64
+	for dest, rows := range imp.Rows {
+		dests = append(dests, dest)
+		total += rows
+	}
+	sort.Strings(dests)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "sub_table\trows")
+	for _, dest := range dests {
+		fmt.Fprintf(tw, "%s\t%d\n", dest, imp.Rows[dest])
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d rows imported", total)
+	if 0 < imp.Skipped {
+		fmt.Fprintf(w, ", %d statements of other tables skipped", imp.Skipped)
+	}
+	fmt.Fprintln(w)
+}
+
+//kingshard import -config ks.yaml -table orders -file orders.sql, the
+//rows of a logical table in INSERT statements or csv are routed by the
+//shard key of config, and loaded into the sub tables of the nodes.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configFile := fs.String("config", "/etc/ks.yaml", "kingshard config file")
+	db := fs.String("db", "", "the db of table, the db of the first sharding table if empty")
+	table := fs.String("table", "", "the logical table")
+	file := fs.String("file", "-", "the file of rows, - is stdin")
+	format := fs.String("format", ImportSql, "the format of file: "+ImportSql+","+ImportCsv)
+	columns := fs.String("columns", "", "the columns of rows separated by comma, the header of csv or the columns of INSERT if empty")
+	charset := fs.String("charset", "utf8mb4", "the charset of the conns to the nodes")
+	batchRows := fs.Int("rows", 500, "the rows of an INSERT statement")
+	concurrency := fs.Int("c", 4, "the conns of each node")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*table) == 0 {
+		fmt.Println("need table")
+		return 2
+	}
+
+	cfg, err := config.ParseConfigFile(*configFile)
+	if err != nil {
+		fmt.Printf("parse config file error:%v\n", err.Error())
+		return 2
+	}
+	if len(*db) == 0 && 0 < len(cfg.Schema.ShardRule) {
+		*db = cfg.Schema.ShardRule[0].DB
+	}
+	opts := ImportOptions{
+		DB:          *db,
+		Table:       *table,
+		Format:      *format,
+		Charset:     *charset,
+		BatchRows:   *batchRows,
+		Concurrency: *concurrency,
+	}
+	if len(*columns) != 0 {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+	imp, err := NewImporter(cfg, opts)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+	err = imp.Import(r)
+	writeImportReport(os.Stdout, imp)
+	if err != nil {
+		fmt.Printf("import error:%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func hasQuery(queries []string, sql string) bool {
+	for _, q := range queries {
+		if q == sql {
+			return true
+		}
+	}
+	return false
+}
+
+var importSql = `-- dump of t
+DROP TABLE IF EXISTS ` + "`t`" + `;
+CREATE TABLE ` + "`t`" + ` (
+  ` + "`id`" + ` int NOT NULL
+) ENGINE=InnoDB;
+INSERT INTO ` + "`t` (`id`,`name`)" + ` VALUES (1,'a'),(2,'b'),(5,'c;'),(6,NULL);
+INSERT INTO ` + "`other`" + ` VALUES (1);
+INSERT INTO ` + "`t`" + ` VALUES (9,'d');
+`
+
+//the sub tables 0 and 1 are on node1, 2 and 3 on node2
+func TestImport(t *testing.T) {
+	var backends []*mysqltest.Server
+	var addrs []interface{}
+	for i := 0; i < 2; i++ {
+		b, err := mysqltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		backends = append(backends, b)
+		addrs = append(addrs, b.Addr())
+	}
+	backends[0].Handle("^SELECT \\* FROM `kingshard`.`t_0000` LIMIT 0$", &mysqltest.Response{
+		Names: []string{"id", "name"},
+	})
+	cfg, err := config.ParseConfigData([]byte(fmt.Sprintf(benchConfig, addrs...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ImportOptions{DB: "kingshard", Table: "t", Format: ImportSql, BatchRows: 2, Concurrency: 2}
+	imp, err := NewImporter(cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = imp.Import(strings.NewReader(importSql)); err != nil {
+		t.Fatal(err)
+	}
+	if !hasQuery(backends[0].Queries(), "INSERT INTO `kingshard`.`t_0001` (`id`,`name`) VALUES (1, 'a'),(5, 'c;')") ||
+		!hasQuery(backends[0].Queries(), "INSERT INTO `kingshard`.`t_0001` VALUES (9, 'd')") ||
+		!hasQuery(backends[1].Queries(), "INSERT INTO `kingshard`.`t_0002` (`id`,`name`) VALUES (2, 'b'),(6, null)") {
+		t.Fatal(backends[0].Queries(), backends[1].Queries())
+	}
+	if imp.Rows["node1:t_0001"] != 3 || imp.Rows["node2:t_0002"] != 2 || imp.Skipped != 1 {
+		t.Fatal(imp.Rows, imp.Skipped)
+	}
+	var buf bytes.Buffer
+	writeImportReport(&buf, imp)
+	if !strings.Contains(buf.String(), "5 rows imported, 1 statements of other tables skipped") {
+		t.Fatal(buf.String())
+	}
+
+	//csv with the header of columns
+	opts.Format = ImportCsv
+	if imp, err = NewImporter(cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = imp.Import(strings.NewReader("name,id\n\"x,y\",3\n\\N,4\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !hasQuery(backends[1].Queries(), "INSERT INTO `kingshard`.`t_0003` (`name`,`id`) VALUES ('x,y','3')") ||
+		!hasQuery(backends[0].Queries(), "INSERT INTO `kingshard`.`t_0000` (`name`,`id`) VALUES (NULL,'4')") {
+		t.Fatal(backends[0].Queries(), backends[1].Queries())
+	}
+
+	//the errors of routing and loading
+	if imp, err = NewImporter(cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = imp.Import(strings.NewReader("name\nx\n")); err == nil {
+		t.Fatal("no shard key expected")
+	}
+	backends[1].Handle("^INSERT", &mysqltest.Response{Err: mysql.NewError(mysql.ER_RECORD_FILE_FULL, "table is full")})
+	if imp, err = NewImporter(cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = imp.Import(strings.NewReader("id\n2\n4\n")); err == nil ||
+		!strings.Contains(err.Error(), "node2:t_0002") {
+		t.Fatal(err)
+	}
+	if imp.Rows["node1:t_0000"] != 1 {
+		t.Fatal(imp.Rows)
+	}
+}
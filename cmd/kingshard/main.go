@@ -51,6 +51,27 @@ const banner string = `
 `
 
 func main() {
+	//kingshard bench generates the workloads against a running kingshard
+	if 1 < len(os.Args) && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	//kingshard import loads the rows of a logical table into the shards
+	if 1 < len(os.Args) && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+	//kingshard routediff compares the routes of two configs
+	if 1 < len(os.Args) && os.Args[1] == "routediff" {
+		os.Exit(runRouteDiff(os.Args[2:]))
+	}
+	//kingshard replay replays the client conns recorded by protocol_record
+	if 1 < len(os.Args) && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	//kingshard convert converts the config of other middlewares
+	if 1 < len(os.Args) && os.Args[1] == "convert" {
+		os.Exit(runConvert(os.Args[2:]))
+	}
+
 	fmt.Print(banner)
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Parse()
@@ -94,6 +115,7 @@ func main() {
 	} else {
 		setLogLevel(cfg.LogLevel)
 	}
+	golog.SetSampleLimit(cfg.LogSampleLimit)
 
 	var svr *server.Server
 	var apiSvr *web.ApiServer
@@ -119,6 +141,7 @@ func main() {
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 		syscall.SIGPIPE,
+		syscall.SIGHUP,
 	)
 
 	go func() {
@@ -131,6 +154,8 @@ func main() {
 				svr.Close()
 			} else if sig == syscall.SIGPIPE {
 				golog.Info("main", "main", "Ignore broken pipe signal", 0)
+			} else if sig == syscall.SIGHUP {
+				reloadConfig(svr)
 			}
 		}
 	}()
@@ -138,6 +163,18 @@ func main() {
 	svr.Run()
 }
 
+//SIGHUP reloads the nodes, schema and rules of config file
+func reloadConfig(svr *server.Server) {
+	cfg, err := config.ParseConfigFile(*configFile)
+	if err != nil {
+		golog.Error("main", "reloadConfig", err.Error(), 0)
+		return
+	}
+	if err = svr.Reload(cfg); err != nil {
+		golog.Error("main", "reloadConfig", err.Error(), 0)
+	}
+}
+
 func setLogLevel(level string) {
 	switch strings.ToLower(level) {
 	case "debug":
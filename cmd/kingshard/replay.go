@@ -0,0 +1,106 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+//ReplayResult is the result of replaying a conn recorded
+type ReplayResult struct {
+	Label   string
+	Packets int
+	Err     error
+}
+
+//replay the client conns recorded by kingshard whose label contains
+//filter against addr one by one, each in timeout
+func replayConns(conns []*mysql.RecordedConn, addr string, filter string, timeout time.Duration) []ReplayResult {
+	var results []ReplayResult
+	for _, rc := range conns {
+		//the conns to backend are recorded with the client side local
+		if rc.Client || !strings.Contains(rc.Label, filter) {
+			continue
+		}
+		r := ReplayResult{Label: rc.Label, Packets: len(rc.Packets)}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(timeout))
+			err = rc.Replay(conn, true)
+			conn.Close()
+		}
+		r.Err = err
+		results = append(results, r)
+	}
+	return results
+}
+
+func writeReplayResults(w io.Writer, results []ReplayResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", r.Label, r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s (%d packets)\n", r.Label, r.Packets)
+	}
+	fmt.Fprintf(w, "\n%d of %d conns failed\n", failed, len(results))
+	return failed
+}
+
+//kingshard replay -file record -addr 127.0.0.1:9696, the client conns
+//recorded by protocol_record are replayed against a kingshard, and the
+//packets different from the records are reported. It exits 1 if any
+//conn fails.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := fs.String("file", "", "the file recorded by protocol_record")
+	addr := fs.String("addr", "127.0.0.1:9696", "the addr of kingshard")
+	filter := fs.String("conn", "", "only the conns whose label contains it, such as the client ip")
+	timeout := fs.Int("timeout", 10, "the max seconds to replay a conn")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*file) == 0 {
+		fmt.Println("need file")
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	conns, err := mysql.ReadRecordedConns(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	results := replayConns(conns, *addr, *filter, time.Duration(*timeout)*time.Second)
+	if 0 < writeReplayResults(os.Stdout, results) {
+		return 1
+	}
+	return 0
+}
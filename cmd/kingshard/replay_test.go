@@ -0,0 +1,113 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+	"github.com/flike/kingshard/proxy/server"
+	"golang.org/x/net/context"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kingshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "record")
+
+	var backends []*mysqltest.Server
+	var addrs []interface{}
+	for i := 0; i < 2; i++ {
+		b, err := mysqltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+		backends = append(backends, b)
+		addrs = append(addrs, b.Addr())
+	}
+	cfgData := strings.Replace(fmt.Sprintf(benchConfig, addrs...), "password :\n",
+		"password :\nprotocol_record : "+file+"\n", 1)
+	cfg, err := config.ParseConfigData([]byte(cfgData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svr.RunContext(ctx)
+
+	c := new(backend.Conn)
+	if err := c.Connect(svr.Addr().String(), "root", "", "kingshard"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("select id from t where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the packets are recorded before the response is written, the record
+	//is read before the client is closed, so the quit is not recorded by
+	//the server while it is read
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns, err := mysql.ReadRecordedConns(f)
+	f.Close()
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := replayConns(conns, svr.Addr().String(), "client", 5*time.Second)
+	var buf bytes.Buffer
+	if len(results) != 1 || writeReplayResults(&buf, results) != 0 {
+		t.Fatal(buf.String())
+	}
+
+	//the result changed is told
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{2}},
+		})
+	}
+	results = replayConns(conns, svr.Addr().String(), "", 5*time.Second)
+	buf.Reset()
+	if len(results) != 1 || writeReplayResults(&buf, results) != 1 ||
+		!strings.Contains(buf.String(), "FAIL client 127.0.0.1:") {
+		t.Fatal(buf.String())
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//RouteChange is a key or statement whose destinations are changed by the
+//new rules, a destination is node:sub_table, or the node if the table is
+//not sharded, or the error of routing
+type RouteChange struct {
+	Input string
+	Old   []string
+	New   []string
+}
+
+//RouteDiff replays the keys and statements through the routers of two
+//configs, and records those routed differently
+type RouteDiff struct {
+	old *router.Router
+	new *router.Router
+	db  string
+
+	Total   int
+	Changes []RouteChange
+	//the count of changes for each pair of old and new destinations
+	Moves map[string]int
+}
+
+func NewRouteDiff(oldCfg *config.Config, newCfg *config.Config, db string) (*RouteDiff, error) {
+	d := &RouteDiff{db: db, Moves: make(map[string]int)}
+	var err error
+	if d.old, err = router.NewRouter(&oldCfg.Schema); err != nil {
+		return nil, fmt.Errorf("old config: %v", err)
+	}
+	if d.new, err = router.NewRouter(&newCfg.Schema); err != nil {
+		return nil, fmt.Errorf("new config: %v", err)
+	}
+	return d, nil
+}
+
+//AddKey replays a line such as "orders 12345" or "kingshard.orders 12345"
+func (d *RouteDiff) AddKey(line string) error {
+	args := strings.Fields(line)
+	if len(args) != 2 {
+		return fmt.Errorf("invalid key line %s, need table and key", line)
+	}
+	locate := func(r *router.Router) []string {
+		loc, err := r.Locate(d.db, args[0], args[1])
+		if err != nil {
+			return []string{"error: " + err.Error()}
+		}
+		if loc.TableIndex < 0 {
+			return []string{loc.Node}
+		}
+		return []string{loc.Node + ":" + loc.SubTable}
+	}
+	d.add(line, locate(d.old), locate(d.new))
+	return nil
+}
+
+//AddSql replays a sql, or a line of the sql log of kingshard
+func (d *RouteDiff) AddSql(line string) error {
+	sql := extractLogSql(line)
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	d.add(sql, planDestinations(d.old, d.db, stmt), planDestinations(d.new, d.db, stmt))
+	return nil
+}
+
+func (d *RouteDiff) add(input string, from []string, to []string) {
+	d.Total++
+	if strings.Join(from, ",") == strings.Join(to, ",") {
+		return
+	}
+	d.Changes = append(d.Changes, RouteChange{Input: input, Old: from, New: to})
+	d.Moves[strings.Join(from, ",")+" -> "+strings.Join(to, ",")]++
+}
+
+//the sorted destinations of stmt
+func planDestinations(r *router.Router, db string, stmt sqlparser.Statement) []string {
+	plan, err := r.BuildPlan(db, stmt)
+	if err != nil {
+		return []string{"error: " + err.Error()}
+	}
+	var dests []string
+	for nodeName := range plan.RewrittenSqls {
+		tables := plan.GetSubTables(nodeName)
+		if len(tables) == 0 {
+			dests = append(dests, nodeName)
+		}
+		for _, table := range tables {
+			dests = append(dests, nodeName+":"+table)
+		}
+	}
+	sort.Strings(dests)
+	return dests
+}
+
+//a line of the sql log is like
+//2016/11/07 15:04:05 - OK - 0.2ms - 127.0.0.1:5042->127.0.0.1:3307:select ...
+//the sql is after the addr of backend, other lines are the sqls themselves
+func extractLogSql(line string) string {
+	i := strings.Index(line, "->")
+	if i == -1 || !strings.Contains(line[:i], " - ") {
+		return strings.TrimSpace(line)
+	}
+	addr := line[i+2:]
+	if j := strings.Index(addr, ":"); j != -1 {
+		if k := strings.Index(addr[j+1:], ":"); k != -1 {
+			return strings.TrimSpace(addr[j+1+k+1:])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+//write the first limit changes and the summary
+func writeRouteDiff(w io.Writer, d *RouteDiff, limit int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if 0 < len(d.Changes) {
+		fmt.Fprintln(tw, "input\told\tnew")
+	}
+	for i, c := range d.Changes {
+		if i == limit {
+			fmt.Fprintf(tw, "... %d more\t\t\n", len(d.Changes)-limit)
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Input, strings.Join(c.Old, ","), strings.Join(c.New, ","))
+	}
+	tw.Flush()
+
+	var moves []string
+	for move := range d.Moves {
+		moves = append(moves, move)
+	}
+	sort.Strings(moves)
+	if 0 < len(moves) {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "move\tcount")
+		for _, move := range moves {
+			fmt.Fprintf(tw, "%s\t%d\n", move, d.Moves[move])
+		}
+		tw.Flush()
+	}
+
+	var percent float64
+	if 0 < d.Total {
+		percent = float64(len(d.Changes)) * 100 / float64(d.Total)
+	}
+	fmt.Fprintf(w, "\n%d of %d changed (%.2f%%)\n", len(d.Changes), d.Total, percent)
+}
+
+//replay the lines of file, - is the stdin
+func replayFile(file string, add func(string) error) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := add(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+//kingshard routediff -old ks.yaml -new ks_new.yaml -keys keys.txt -sql sql.log,
+//the keys and sqls are routed by the rules of both configs, and those
+//routed differently are reported. It exits 1 if any is changed.
+func runRouteDiff(args []string) int {
+	fs := flag.NewFlagSet("routediff", flag.ContinueOnError)
+	oldFile := fs.String("old", "/etc/ks.yaml", "the config of current rules")
+	newFile := fs.String("new", "", "the config of new rules")
+	keysFile := fs.String("keys", "", "the file of keys, a line is a table and a key such as: orders 12345, - is stdin")
+	sqlFile := fs.String("sql", "", "the file of sqls, a line is a sql or a line of the sql log, - is stdin")
+	db := fs.String("db", "", "the db of the tables without db, the db of the first sharding table if empty")
+	limit := fs.Int("limit", 100, "the changes printed at most")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*newFile) == 0 || (len(*keysFile) == 0 && len(*sqlFile) == 0) {
+		fmt.Println("need new, and keys or sql")
+		return 2
+	}
+
+	oldCfg, err := config.ParseConfigFile(*oldFile)
+	if err != nil {
+		fmt.Printf("parse config file error:%v\n", err.Error())
+		return 2
+	}
+	newCfg, err := config.ParseConfigFile(*newFile)
+	if err != nil {
+		fmt.Printf("parse config file error:%v\n", err.Error())
+		return 2
+	}
+	if len(*db) == 0 && 0 < len(oldCfg.Schema.ShardRule) {
+		*db = oldCfg.Schema.ShardRule[0].DB
+	}
+	d, err := NewRouteDiff(oldCfg, newCfg, *db)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if len(*keysFile) != 0 {
+		if err := replayFile(*keysFile, d.AddKey); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+	if len(*sqlFile) != 0 {
+		if err := replayFile(*sqlFile, d.AddSql); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+
+	writeRouteDiff(os.Stdout, d, *limit)
+	if 0 < len(d.Changes) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+var routeDiffConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : 127.0.0.1:1
+-
+    name : node2
+    user : root
+    master : 127.0.0.1:2
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [%s]
+`
+
+func TestRouteDiff(t *testing.T) {
+	oldCfg, err := config.ParseConfigData([]byte(fmt.Sprintf(routeDiffConfig, "2,2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCfg, err := config.ParseConfigData([]byte(fmt.Sprintf(routeDiffConfig, "1,3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewRouteDiff(oldCfg, newCfg, "kingshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//only the sub table 1 is moved from node1 to node2
+	for i := 0; i < 8; i++ {
+		if err := d.AddKey(fmt.Sprintf("t %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Total != 8 || len(d.Changes) != 2 || d.Changes[0].Input != "t 1" {
+		t.Fatal(d.Total, d.Changes)
+	}
+	if d.Moves["node1:t_0001 -> node2:t_0001"] != 2 {
+		t.Fatal(d.Moves)
+	}
+	if err := d.AddKey("t"); err == nil {
+		t.Fatal("no key")
+	}
+
+	sqls := []string{
+		"select * from t where id = 2",
+		"2016/11/07 15:04:05 - OK - 0.2ms - 127.0.0.1:5042->127.0.0.1:3307:select * from t where id in (1, 2)",
+		"select * from other",
+	}
+	for _, sql := range sqls {
+		if err := d.AddSql(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Total != 11 || len(d.Changes) != 3 {
+		t.Fatal(d.Total, d.Changes)
+	}
+	c := d.Changes[2]
+	if c.Input != "select * from t where id in (1, 2)" ||
+		strings.Join(c.Old, ",") != "node1:t_0001,node2:t_0002" ||
+		strings.Join(c.New, ",") != "node2:t_0001,node2:t_0002" {
+		t.Fatal(c)
+	}
+
+	var buf bytes.Buffer
+	writeRouteDiff(&buf, d, 1)
+	out := buf.String()
+	if !strings.Contains(out, "... 2 more") || !strings.Contains(out, "3 of 11 changed (27.27%)") {
+		t.Fatal(out)
+	}
+}
@@ -23,23 +23,142 @@ import (
 
 //整个config文件对应的结构
 type Config struct {
+	//the version of the config layout, the older layouts are upgraded
+	//when loaded, see upgrade.go
+	Version int `yaml:"version"`
+	//the deprecated fields upgraded when loaded, not in the config file
+	Warnings []string `yaml:"-"`
+
 	Addr     string `yaml:"addr"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
+	//the old or new password accepted besides Password during a rotation,
+	//until SecondaryPasswordExpire such as 2016-05-01 00:00:00 if set
+	SecondaryPassword       string `yaml:"secondary_password"`
+	SecondaryPasswordExpire string `yaml:"secondary_password_expire"`
 
 	WebAddr     string `yaml:"web_addr"`
 	WebUser     string `yaml:"web_user"`
 	WebPassword string `yaml:"web_password"`
+	//the criteria of /readyz, /healthz and /readyz need no auth
+	Readiness ReadinessConfig `yaml:"readiness"`
 
 	LogPath     string       `yaml:"log_path"`
 	LogLevel    string       `yaml:"log_level"`
 	LogSql      string       `yaml:"log_sql"`
+	LogSqlScrub bool         `yaml:"log_sql_scrub"`
 	SlowLogTime int          `yaml:"slow_log_time"`
 	AllowIps    string       `yaml:"allow_ips"`
 	BlsFile     string       `yaml:"blacklist_sql_file"`
 	Charset     string       `yaml:"proxy_charset"`
 	Nodes       []NodeConfig `yaml:"nodes"`
 
+	//the policy of the sql which can not be parsed: reject, default or master
+	ParseFailPolicy string `yaml:"parse_fail_policy"`
+	//the response of the statements not supported by class: set, statement or
+	//prepare -> reject, ignore or forward
+	UnsupportPolicy map[string]string `yaml:"unsupport_policy"`
+	//the session variables set by clients besides the ones tracked by
+	//kingshard, and the ones rejected
+	SessionVariables SessionVariablesConfig `yaml:"session_variables"`
+	//the max count of sqls whose plans are cached in a session, 0 means no cache
+	PlanCacheSize int `yaml:"plan_cache_size"`
+	//the max lines of a repetitive warn, error or error sql per minute, 0 means no limit
+	LogSampleLimit int `yaml:"log_sample_limit"`
+	//return the results of the healthy shards with warnings if some shards
+	//of a select fail, instead of an error
+	PartialResult bool `yaml:"partial_result"`
+	//the number of listeners sharing Addr by SO_REUSEPORT, each has its
+	//own accept loop. 0 or 1 means a single listener, linux 3.9+ only
+	Acceptors int `yaml:"acceptors"`
+	//the max seconds for a client to complete the handshake and auth,
+	//0 means 10 and a negative value means no limit
+	HandshakeTimeout int `yaml:"handshake_timeout"`
+	//the max client conns in handshake at the same time, the others are
+	//dropped, 0 means 1024 and a negative value means no limit
+	MaxHandshakeConns int `yaml:"max_handshake_conns"`
+	//the max seconds a write to a client can stall, such as a slow client
+	//reading a huge result, the client is disconnected so it can not hold
+	//the backend conns. 0 means 60 and a negative value means no limit
+	ClientWriteTimeout int `yaml:"client_write_timeout"`
+	//the seconds a client conn is idle before the first tcp keepalive probe,
+	//so a client whose host is down is found while its statement runs in
+	//backend, and the statement is killed. 0 means 15 and a negative value
+	//disables the keepalive of client conns
+	ClientKeepAlive int `yaml:"client_keepalive"`
+	//the seconds between the keepalive probes, 0 means client_keepalive
+	ClientKeepAliveInterval int `yaml:"client_keepalive_interval"`
+	//the unanswered probes before the client is dropped, 0 means the
+	//default of os. The interval and count are supported on linux only
+	ClientKeepAliveCount int `yaml:"client_keepalive_count"`
+	//the max milliseconds to wait for a new master before retrying once a
+	//write denied by a read only master, when a failover just happened.
+	//0 means 1000 and a negative value means no retry
+	ReadOnlyRetryWait int `yaml:"read_only_retry_wait"`
+	//the seconds between the checks of ddl drift among the sub tables of
+	//sharding tables, the drifts are logged. 0 means no scheduled check
+	DDLCheckInterval int `yaml:"ddl_check_interval"`
+	//the table of the masters written by admin check node, it is created if
+	//not exists and the database must exist. Empty means kingshard.heartbeat
+	HeartbeatTable string `yaml:"heartbeat_table"`
+	//the milliseconds between the heartbeats written into the masters to
+	//measure the lag of slaves for the bounded reads, the heartbeats are
+	//written into heartbeat_table. 0 means no measure and the bounded reads
+	//go to the masters
+	LagCheckInterval int `yaml:"lag_check_interval"`
+	//the max seconds of a transaction idle between its statements, it is
+	//rolled back to release the locks in backend and the next statement of
+	//the session gets an error. 0 means no limit, a prepared xa branch is
+	//never rolled back
+	IdleInTxTimeout int `yaml:"idle_in_tx_timeout"`
+	//the policy of a transaction whose backend conn is lost: error or
+	//replay, error by default. The transaction is rolled back and the
+	//statement gets a distinct error. replay runs the selects of a read
+	//only transaction again in a new conn of its node, on a slave if the
+	//master is down, and goes on. A xa transaction is never replayed
+	TxFailoverPolicy string `yaml:"tx_failover_policy"`
+	//allow the faults injected by the admin commands, such as the delays
+	//and errors of backends, for the resilience tests in staging
+	FaultInjection bool `yaml:"fault_injection"`
+	//the milliseconds after a write of a session during which the reads of
+	//the session are sent to the master, so it reads its own writes in
+	//spite of the replication lag. 0 means no window
+	ReadAfterWrite int `yaml:"read_after_write"`
+	//the file the packets of the client and backend conns are appended to,
+	//with the auth data zeroed, for the protocol tests and the replay by
+	//kingshard replay. The queries and rows are recorded as they are, it
+	//is for debugging only. Empty means no recording
+	ProtocolRecord string `yaml:"protocol_record"`
+	//the file the sqls of the multi-node writes out of transaction are
+	//journaled to before execution, the sqls not acked are retried on
+	//restart. Empty means no journal
+	WriteJournal string `yaml:"write_journal"`
+	//the directory the per-minute stats of the nodes, sharding tables and
+	//fingerprints are written to, a file per day. Empty means no history
+	StatsHistory string `yaml:"stats_history"`
+	//the days of the stats history kept, 7 by default
+	StatsHistoryDays int `yaml:"stats_history_days"`
+	//the proxy users besides User
+	Users []UserConfig `yaml:"users"`
+	//the external authentication of the users not in config
+	Auth AuthConfig `yaml:"auth"`
+
+	HotKey       HotKeyConfig        `yaml:"hot_key"`
+	ShardSplit   ShardSplitConfig    `yaml:"shard_split"`
+	DateShard    DateShardConfig     `yaml:"date_shard"`
+	CDC          CDCConfig           `yaml:"cdc"`
+	ConnLimit    ConnLimitConfig     `yaml:"conn_limit"`
+	Cluster      ClusterConfig       `yaml:"cluster"`
+	ExecStrategy ExecStrategyConfig  `yaml:"exec_strategy"`
+	RewriteRules []RewriteRuleConfig `yaml:"rewrite_rules"`
+	MockRules    []MockRuleConfig    `yaml:"mock_rules"`
+	//the max rows of the selects of users and tables
+	SelectLimits []SelectLimitConfig `yaml:"select_limits"`
+	//the columns masked in the results of the selects of users
+	ColumnMasks []ColumnMaskConfig `yaml:"column_masks"`
+	//the rows of the tables which users can read and change
+	RowFilters []RowFilterConfig `yaml:"row_filters"`
+
 	Schema SchemaConfig `yaml:"schema"`
 }
 
@@ -51,9 +170,251 @@ type NodeConfig struct {
 
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
+	//the password tried if Password is denied by the backend, the two
+	//are swapped once it succeeds, so the password can be rotated
+	SecondaryPassword string `yaml:"secondary_password"`
+
+	//the timeouts in seconds of the conns to mysql, 0 means the default:
+	//3 for connect, no timeout for read, 30 for write and 30 for the tcp
+	//keepalive period. A negative value means no timeout.
+	ConnectTimeout  int `yaml:"connect_timeout"`
+	ReadTimeout     int `yaml:"read_timeout"`
+	WriteTimeout    int `yaml:"write_timeout"`
+	KeepAlivePeriod int `yaml:"keepalive_period"`
 
 	Master string `yaml:"master"`
 	Slave  string `yaml:"slave"`
+	//the backup replicas, used for reads only if the master and all
+	//the slaves are down, or by the /*backup*/ hint
+	Backup string `yaml:"backup"`
+
+	//discover the slaves by SHOW SLAVE HOSTS of master, the slaves in
+	//Slave are pinned and the hosts in DiscoveryExclude are never used
+	SlaveDiscovery   bool   `yaml:"slave_discovery"`
+	DiscoveryExclude string `yaml:"discovery_exclude"`
+
+	//the databases renamed in this node, such as app: app_v2, so the node
+	//uses app_v2 for the client database app during a blue/green migration
+	SchemaRewrite map[string]string `yaml:"schema_rewrite"`
+
+	//the conns to the dbs of the node are over tls, and tunneled by ssh
+	//through a jump host, for the networks not trusted
+	TLS       NodeTLSConfig   `yaml:"tls"`
+	SSHTunnel SSHTunnelConfig `yaml:"ssh_tunnel"`
+}
+
+//the tls of the conns to mysql, negotiated by the ssl request of mysql
+type NodeTLSConfig struct {
+	Enable bool `yaml:"enable"`
+	//the pem file of the ca to verify the server, empty means the system roots
+	CA string `yaml:"ca"`
+	//the pem files of the client cert and key, empty means no client cert
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	//the name in the cert of server, empty means the host of db addr
+	ServerName string `yaml:"server_name"`
+	//the cert of server is not verified, only for tests
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+//the conns to mysql are forwarded by the jump host with ssh -W, the ssh
+//client of system is used, so its config such as ~/.ssh/config applies
+type SSHTunnelConfig struct {
+	//user@host:port of the jump host, empty means no tunnel
+	Addr string `yaml:"addr"`
+	//the private key, empty means the default keys of ssh
+	KeyFile string `yaml:"key_file"`
+	//the known hosts to check the host key of jump host, empty means the
+	//default of ssh. The unknown host keys are rejected
+	KnownHostsFile string `yaml:"known_hosts_file"`
+}
+
+//a proxy user, MaxBackendConns is the max backend conns the user can use
+//in each node, 0 means no limit
+type UserConfig struct {
+	User            string `yaml:"user"`
+	Password        string `yaml:"password"`
+	MaxBackendConns int    `yaml:"max_backend_conns"`
+	Tenant          string `yaml:"tenant"` //the default tenant of the user
+	//the read_after_write of the user in milliseconds, 0 means the global
+	//one and a negative value means no window
+	ReadAfterWrite int `yaml:"read_after_write"`
+	//the default consistency of the reads of the user: strong, eventual or
+	//bounded(500ms), empty means eventual
+	Consistency string `yaml:"consistency"`
+
+	SecondaryPassword       string `yaml:"secondary_password"`
+	SecondaryPasswordExpire string `yaml:"secondary_password_expire"`
+}
+
+//the external authentication of proxy users, Type is ldap or webhook
+type AuthConfig struct {
+	Type string `yaml:"type"`
+	//ldap://host:389 or ldaps://host:636 for ldap, the url for webhook
+	Addr string `yaml:"addr"`
+	//the dn to bind for ldap, {user} is the user name
+	BindDN string `yaml:"bind_dn"`
+	//the timeout of a request in seconds
+	Timeout int `yaml:"timeout"`
+	//the seconds a successful authentication is cached, 0 means no cache
+	CacheTTL int `yaml:"cache_ttl"`
+	//the external authentication gets the password of client in clear
+	//text by mysql_clear_password, and the client conns have no tls, so
+	//it must be allowed explicitly
+	AllowClearPassword bool `yaml:"allow_clear_password"`
+}
+
+//the session variables in Allow are tracked like the built-in ones and set
+//in every backend conn of the session, the ones in Deny are rejected
+type SessionVariablesConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+//the proxy is ready if it is running and online, and the masters of
+//Nodes are reachable, Nodes is the default node of schema if empty
+type ReadinessConfig struct {
+	Nodes []string `yaml:"nodes"`
+	//require a reachable slave in each node too
+	Slave bool `yaml:"slave"`
+}
+
+//hot key detection, a shard key is hot if it is queried more than
+//Threshold times in the last Window seconds
+type HotKeyConfig struct {
+	Window    int `yaml:"window"`
+	Threshold int `yaml:"threshold"`
+	//max read qps of a hot key, 0 means no throttling
+	ReadLimit int `yaml:"read_limit"`
+}
+
+//the sub tables of date rules are created before their periods come, and
+//dropped out of retain_shards
+type DateShardConfig struct {
+	//the seconds between the checks of the date sub tables, 0 means no
+	//scheduled check
+	CheckInterval int `yaml:"check_interval"`
+	//the count of the next periods whose sub tables are created ahead, 0
+	//means 1
+	Precreate int `yaml:"precreate"`
+}
+
+//the last sub table of range rules is split into a new sub table, once
+//its rows exceed Threshold of table_row_limit
+type ShardSplitConfig struct {
+	//the seconds between the checks of row counts, 0 means no scheduled check
+	CheckInterval int `yaml:"check_interval"`
+	//0 means 0.8
+	Threshold float64 `yaml:"threshold"`
+	//create the new sub table and add it to the rule, otherwise the split
+	//is only proposed in the log
+	Auto bool `yaml:"auto"`
+}
+
+//the change stream of the sharding tables from the binlogs of the node
+//masters, the changes of a sub table are published to the subject of
+//its logical table, such as kingshard.db.orders
+type CDCConfig struct {
+	//nats://[user:password@]host:4222, empty means no change stream
+	NatsAddr string `yaml:"nats_addr"`
+	//the prefix of subjects, empty means kingshard
+	Subject string `yaml:"subject"`
+	//the server id of the first node as a slave, the others use the ids
+	//after it in the order of nodes. 0 means 1001
+	ServerId uint32 `yaml:"server_id"`
+	//the logical tables such as db.orders, empty means all the sharding tables
+	Tables []string `yaml:"tables"`
+}
+
+//the rate limit of the new conns of each client ip, an ip connecting more
+//than MaxConns times in Window seconds is banned for BanTime seconds
+type ConnLimitConfig struct {
+	Window   int `yaml:"window"`
+	MaxConns int `yaml:"max_conns"`
+	//0 means the conns beyond MaxConns in the window are rejected only
+	BanTime int `yaml:"ban_time"`
+}
+
+//the instances of a cluster share the down slaves, the rules and the new
+//conns of client ips by etcd
+type ClusterConfig struct {
+	//the addrs of the etcd v3 gateway such as http://127.0.0.1:2379,
+	//separated by comma. Empty means no cluster
+	EtcdAddrs string `yaml:"etcd_addrs"`
+	//the prefix of the keys in etcd, empty means /kingshard
+	Prefix string `yaml:"prefix"`
+	//the name of the instance in the cluster, empty means the host name and addr
+	Name string `yaml:"name"`
+	//the seconds between the syncs with etcd, an instance not synced in
+	//3 intervals is ignored by the others. 0 means 3
+	Interval int `yaml:"interval"`
+}
+
+//the thresholds to choose the execution strategy of a select by the past
+//stats of its fingerprint, they are overridden by the hints
+type ExecStrategyConfig struct {
+	//the sqls of the nodes are executed one by one instead of in parallel
+	//if their past times add up to no more than SerialMaxTime milliseconds.
+	//0 means only the select to a single node is serial
+	SerialMaxTime int `yaml:"serial_max_time"`
+	//the rows of a select returning StreamMinRows rows or more on average
+	//are written to client as they are read from the shards, instead of
+	//being buffered. 0 means no streaming without the hint
+	StreamMinRows int `yaml:"stream_min_rows"`
+}
+
+//sql rewrite rule, a sql matches the rule if it has the same
+//fingerprint as Fingerprint, or matches the regexp Pattern.
+//Rewrite is the template to expand, $1 is the first submatch of
+//Pattern and $0 is the whole sql for a fingerprint rule.
+type RewriteRuleConfig struct {
+	Name        string `yaml:"name"`
+	Fingerprint string `yaml:"fingerprint"`
+	Pattern     string `yaml:"pattern"`
+	Rewrite     string `yaml:"rewrite"`
+}
+
+//mock rule, a sql matching the rule is answered by kingshard with
+//Columns and Rows, or an OK packet if Columns is empty.
+type MockRuleConfig struct {
+	Name        string     `yaml:"name"`
+	Fingerprint string     `yaml:"fingerprint"`
+	Pattern     string     `yaml:"pattern"`
+	Columns     []string   `yaml:"columns"`
+	Rows        [][]string `yaml:"rows"`
+}
+
+//the selects sent by User and reading DB.Table are limited to MaxRows rows,
+//a LIMIT is added if they have none or tightened if it is greater. An
+//empty User, DB or Table matches all, the smallest MaxRows of the matched
+//limits is used.
+type SelectLimitConfig struct {
+	User    string `yaml:"user"`
+	DB      string `yaml:"db"`
+	Table   string `yaml:"table"`
+	MaxRows int    `yaml:"max_rows"`
+}
+
+//the Column of DB.Table is masked in the results of the selects of User,
+//empty User, DB or Table matches all. Mask is keep(m,n), which keeps the
+//first m and last n characters and replaces the others with *, full
+//which replaces the value with ****, or null.
+type ColumnMaskConfig struct {
+	User   string `yaml:"user"`
+	DB     string `yaml:"db"`
+	Table  string `yaml:"table"`
+	Column string `yaml:"column"`
+	Mask   string `yaml:"mask"`
+}
+
+//Filter is appended to the where of the selects, updates and deletes of
+//DB.Table by User, such as region = 'EU'. Empty User or DB matches all,
+//Table must be a sharded table.
+type RowFilterConfig struct {
+	User   string `yaml:"user"`
+	DB     string `yaml:"db"`
+	Table  string `yaml:"table"`
+	Filter string `yaml:"filter"`
 }
 
 //schema对应的结构体
@@ -61,6 +422,20 @@ type SchemaConfig struct {
 	Nodes     []string      `yaml:"nodes"`
 	Default   string        `yaml:"default"` //default node
 	ShardRule []ShardConfig `yaml:"shard"`   //route rule
+
+	//same as lower_case_table_names of mysql, table and database names
+	//are compared case-insensitively if it is 1 or 2
+	LowerCaseTableNames int `yaml:"lower_case_table_names"`
+
+	Tenancy TenancyConfig `yaml:"tenancy"`
+}
+
+//multi-tenant routing, the tables of each tenant are in its own schema,
+//the tenant tables in db are routed to the schema of the session tenant
+type TenancyConfig struct {
+	DB     string   `yaml:"db"`     //the logical database of tenant tables
+	Schema string   `yaml:"schema"` //the schema of a tenant, {id} is the tenant id
+	Tables []string `yaml:"tables"`
 }
 
 //range,hash or date
@@ -69,17 +444,58 @@ type ShardConfig struct {
 	Table         string   `yaml:"table"`
 	Key           string   `yaml:"key"`
 	Nodes         []string `yaml:"nodes"`
+	Node          string   `yaml:"node"` //the node of a table of type single
 	Locations     []int    `yaml:"locations"`
 	Type          string   `yaml:"type"`
 	TableRowLimit int      `yaml:"table_row_limit"`
 	DateRange     []string `yaml:"date_range"`
+	//the sqls of the table are sent to the default node without parse
+	NoRewrite bool `yaml:"no_rewrite"`
+	//the sub table of the rows whose shard key is NULL
+	NullKeyTable *int `yaml:"null_key_table"`
+	//the collation of the shard key column of a hash table, the string
+	//keys are converted to utf8 and folded as the collation compares them
+	//before hashing. Empty means the keys are hashed by their bytes
+	KeyCollation string `yaml:"key_collation"`
+	//the data type of shard key: int, string, date or uuid
+	KeyType string `yaml:"key_type"`
+	//the binary(16) uuids of key are stored by uuid_to_bin(uuid, 1)
+	UUIDSwapFlag bool `yaml:"uuid_swap_flag"`
+	//the expression of key which derives the value routed, such as
+	//substr(order_no, 1, 6) or user_id % 1000, empty means the value of key
+	KeyExpr string `yaml:"key_expr"`
+	//the operations allowed on the table, such as [select, insert] for an
+	//archive table, empty means all
+	Operations []string `yaml:"operations"`
+	//the select without the condition of shard key only scans the latest
+	//recent_shards sub tables of a date table, 0 means all
+	RecentShards int `yaml:"recent_shards"`
+	//the sub tables of a date table before the latest retain_shards ones
+	//are dropped by the date shard check, 0 means never drop
+	RetainShards int `yaml:"retain_shards"`
+	//the writes of the sub tables on the node blackhole are dropped or
+	//rejected, drop by default
+	BlackholePolicy string `yaml:"blackhole_policy"`
+	//the default consistency of the selects of the table, which overrides
+	//the one of user, empty means the one of user
+	Consistency string `yaml:"consistency"`
+	//the predicate appended to the where of the select, update and delete
+	//of the table, such as deleted_at is null, unless the sql has the hint
+	///*with_deleted*/. Empty means none
+	SoftDelete string `yaml:"soft_delete"`
 }
 
 func ParseConfigData(data []byte) (*Config, error) {
+	data, warnings, err := upgradeConfig(data)
+	if err != nil {
+		return nil, err
+	}
 	var cfg Config
 	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
 		return nil, err
 	}
+	cfg.Version = CurrentConfigVersion
+	cfg.Warnings = warnings
 	return &cfg, nil
 }
 
@@ -157,3 +157,83 @@ schema :
 		t.Fatal("Top Config not equal.")
 	}
 }
+
+func TestUpgradeConfig(t *testing.T) {
+	var legacy = []byte(`
+addr : 0.0.0.0:9696
+nodes :
+-
+  name : node1
+  idle_conns : 16
+  master : 127.0.0.1:3306
+-
+  name : node2
+  idle_conns : 8
+  max_conns_limit : 32
+  master : 127.0.0.1:3307
+
+schemas :
+-
+  db : kingshard
+  nodes : [node1, node2]
+  rules :
+    default : node1
+    shard :
+    -
+      table : test_shard_hash
+      key : id
+      nodes : [node1, node2]
+      type : hash
+      locations : [4, 4]
+-
+  db : kingshard_log
+  nodes : [node2]
+  rules :
+    default : node1
+`)
+	cfg, err := ParseConfigData(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentConfigVersion || len(cfg.Warnings) != 3 {
+		t.Fatal(cfg.Version, cfg.Warnings)
+	}
+	if cfg.Nodes[0].MaxConnNum != 16 || cfg.Nodes[1].MaxConnNum != 32 {
+		t.Fatal(cfg.Nodes)
+	}
+	schema := cfg.Schema
+	if !reflect.DeepEqual(schema.Nodes, []string{"node1", "node2"}) || schema.Default != "node1" ||
+		len(schema.ShardRule) != 1 || schema.ShardRule[0].DB != "kingshard" ||
+		schema.ShardRule[0].Table != "test_shard_hash" {
+		t.Fatal(schema)
+	}
+
+	//the tables not sharded of the schemas can not be on different nodes
+	var conflict = []byte(`
+schemas :
+-
+  db : kingshard
+  nodes : [node1]
+  rules :
+    default : node1
+-
+  db : kingshard_log
+  nodes : [node2]
+  rules :
+    default : node2
+`)
+	if _, err = ParseConfigData(conflict); err == nil {
+		t.Fatal("expect error of the different default nodes")
+	}
+
+	//the current layout is not changed
+	cfg, err = ParseConfigData([]byte("addr : 0.0.0.0:9696\n"))
+	if err != nil || cfg.Addr != "0.0.0.0:9696" || len(cfg.Warnings) != 0 ||
+		cfg.Version != CurrentConfigVersion {
+		t.Fatal(cfg, err)
+	}
+
+	if _, err = ParseConfigData([]byte(fmt.Sprintf("version : %d\n", CurrentConfigVersion+1))); err == nil {
+		t.Fatal("expect error of the newer version")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+//the version of the config layout, a config without version is of the
+//layout it looks like: 1 if it has schemas, or CurrentConfigVersion
+const (
+	LegacyConfigVersion  = 1 //schemas with rules, and idle_conns of nodes
+	CurrentConfigVersion = 2 //schema with shard, and max_conns_limit of nodes
+)
+
+//an upgrade of the config from the version before to the next one, it
+//returns the warnings of the deprecated fields upgraded
+type configUpgrade func(m map[interface{}]interface{}) ([]string, error)
+
+//configUpgrades[i] upgrades the version i+1 to i+2
+var configUpgrades = []configUpgrade{
+	upgradeLegacySchemas,
+}
+
+//upgradeConfig returns the config data in the current layout, the older
+//layouts are upgraded step by step, with a warning for each deprecated
+//field instead of an error. The data of the current layout is returned as
+//it is.
+func upgradeConfig(data []byte) ([]byte, []string, error) {
+	var m map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, nil, err
+	}
+	if m == nil {
+		return data, nil, nil
+	}
+
+	version := CurrentConfigVersion
+	if v, ok := m["version"]; ok {
+		n, ok := v.(int)
+		if !ok || n <= 0 {
+			return nil, nil, fmt.Errorf("invalid config version %v", v)
+		}
+		version = n
+	} else if _, ok := m["schemas"]; ok {
+		version = LegacyConfigVersion
+	}
+	if CurrentConfigVersion < version {
+		return nil, nil, fmt.Errorf("config version %d is newer than %d supported",
+			version, CurrentConfigVersion)
+	}
+	if version == CurrentConfigVersion {
+		return data, nil, nil
+	}
+
+	var warnings []string
+	for ; version < CurrentConfigVersion; version++ {
+		w, err := configUpgrades[version-1](m)
+		if err != nil {
+			return nil, nil, fmt.Errorf("upgrade config version %d: %s", version, err.Error())
+		}
+		warnings = append(warnings, w...)
+	}
+	m["version"] = CurrentConfigVersion
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, warnings, nil
+}
+
+//the version 1 has a list of schemas, each of a db with its nodes and
+//rules, such as
+//
+//	schemas:
+//	- db: kingshard
+//	  nodes: [node1, node2]
+//	  rules:
+//	    default: node1
+//	    shard: [...]
+//
+//They are merged into the schema, whose shard rules have the db of their
+//schema, so the schemas must have the same default node. The idle_conns of
+//nodes is max_conns_limit.
+func upgradeLegacySchemas(m map[interface{}]interface{}) ([]string, error) {
+	var warnings []string
+	nodes, _ := m["nodes"].([]interface{})
+	for _, v := range nodes {
+		node, ok := v.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		idle, ok := node["idle_conns"]
+		if !ok {
+			continue
+		}
+		if _, ok = node["max_conns_limit"]; !ok {
+			node["max_conns_limit"] = idle
+		}
+		delete(node, "idle_conns")
+		warnings = append(warnings, fmt.Sprintf(
+			"idle_conns of node %v is deprecated, use max_conns_limit", node["name"]))
+	}
+
+	v, ok := m["schemas"]
+	if !ok {
+		return warnings, nil
+	}
+	delete(m, "schemas")
+	if _, ok = m["schema"]; ok {
+		return append(warnings, "schemas is deprecated and ignored, since schema is set"), nil
+	}
+	schemas, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schemas is not a list")
+	}
+
+	var schemaNodes, shard []interface{}
+	var defaultNode interface{}
+	seen := make(map[interface{}]bool)
+	for _, v := range schemas {
+		s, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema %v is not a map", v)
+		}
+		db := s["db"]
+		names, _ := s["nodes"].([]interface{})
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				schemaNodes = append(schemaNodes, name)
+			}
+		}
+
+		rules, _ := s["rules"].(map[interface{}]interface{})
+		if d, ok := rules["default"]; ok {
+			if defaultNode == nil {
+				defaultNode = d
+			} else if d != defaultNode {
+				//the tables not sharded of one db would be moved to another node
+				return nil, fmt.Errorf("the default node %v of db %v is not %v of the other dbs, "+
+					"move the tables not sharded to one node first", d, db, defaultNode)
+			}
+		}
+		rs, _ := rules["shard"].([]interface{})
+		for _, r := range rs {
+			rule, ok := r.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("shard rule %v of db %v is not a map", r, db)
+			}
+			if _, ok := rule["db"]; !ok && db != nil {
+				rule["db"] = db
+			}
+			shard = append(shard, rule)
+		}
+	}
+
+	schema := map[interface{}]interface{}{"nodes": schemaNodes}
+	if defaultNode != nil {
+		schema["default"] = defaultNode
+	}
+	if len(shard) != 0 {
+		schema["shard"] = shard
+	}
+	m["schema"] = schema
+	return append(warnings, "schemas is deprecated, use schema with the db of each shard rule"), nil
+}
@@ -25,6 +25,9 @@ var (
 	ErrNoMasterDB    = errors.New("no master database")
 	ErrNoSlaveDB     = errors.New("no slave database")
 	ErrNoDatabase    = errors.New("no database")
+	ErrNoBackupDB    = errors.New("no backup database")
+	ErrNoFreshSlave  = errors.New("no slave within the lag")
+	ErrBackendBusy   = errors.New("backend has too many connections, retry later")
 
 	ErrMasterDown    = errors.New("master is down")
 	ErrSlaveDown     = errors.New("slave is down")
@@ -70,4 +73,15 @@ var (
 	ErrBlackSqlExist    = errors.New("black sql has exist")
 	ErrBlackSqlNotExist = errors.New("black sql has not exist")
 	ErrSQLNULL          = errors.New("sql is null")
+	ErrHotKeyThrottled  = errors.New("hot key is throttled")
+	ErrNullShardKey     = errors.New("shard key is null and no null_key_table")
+	ErrUserConnQuota    = errors.New("backend connections of user exceed quota")
+	ErrNoTenant         = errors.New("tenant table is used without tenant")
+	ErrNotPinnedShard   = errors.New("sql is not routed to the pinned shard")
+	ErrIPBanned         = errors.New("ip is banned for too many connections")
+	ErrIdleTxKilled     = errors.New("transaction is rolled back for idle in transaction timeout")
+	ErrIPNotBanned      = errors.New("ip is not banned")
+	ErrFaultDisabled    = errors.New("fault injection is disabled")
+	ErrFaultNotExist    = errors.New("fault has not exist")
+	ErrSchemaDrift      = errors.New("schema drift between shards")
 )
@@ -21,6 +21,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,28 +44,43 @@ const (
 var LevelName [6]string = [6]string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
 
 const (
-	LogSqlOn       = "on"
-	LogSqlOff      = "off"
-	TimeFormat     = "2006/01/02 15:04:05"
-	maxBufPoolSize = 16
+	LogSqlOn     = "on"
+	LogSqlOff    = "off"
+	TimeFormat   = "2006/01/02 15:04:05"
+	maxBufSize   = 64 * 1024 //the larger buffer is not reused
+	maxBatchSize = 64 * 1024 //the max bytes written to handler at a time
+	ringSize     = 8192      //the max count of log lines waiting for flush
+)
+
+//the policy when the log lines waiting for flush are full
+const (
+	OverflowDrop  = iota //drop the log line and count it
+	OverflowBlock        //wait until the flusher writes some lines
 )
 
 type Logger struct {
-	sync.Mutex
+	//atomic counters, keep them first for the alignment on 32-bit platform
+	written int64
+	dropped int64
+
+	closed   int32
+	overflow int32
 
 	level int
 	flag  int
 
 	handler Handler
 
-	quit chan struct{}
-	msg  chan []byte
-
-	bufs [][]byte
+	quit   chan struct{}
+	notify chan struct{}
+	ring   *ringBuffer
 
-	wg sync.WaitGroup
+	bufs sync.Pool
 
-	closed bool
+	//the pushes hold the read lock, so no line is pushed after Close
+	//tells the flusher to quit
+	closeLock sync.RWMutex
+	wg        sync.WaitGroup
 }
 
 //new a logger with specified handler and flag
@@ -77,11 +93,10 @@ func New(handler Handler, flag int) *Logger {
 	l.flag = flag
 
 	l.quit = make(chan struct{})
-	l.closed = false
 
-	l.msg = make(chan []byte, 1024)
-
-	l.bufs = make([][]byte, 0, 16)
+	l.notify = make(chan struct{}, 1)
+	l.ring = newRingBuffer(ringSize)
+	l.overflow = OverflowDrop
 
 	l.wg.Add(1)
 	go l.run()
@@ -105,49 +120,91 @@ func Close() {
 	std.Close()
 }
 
+//run is the background flusher, it writes the log lines in batch
 func (l *Logger) run() {
 	defer l.wg.Done()
+	batch := make([]byte, 0, maxBatchSize)
 	for {
+		batch = l.fillBatch(batch[0:0])
+		if len(batch) != 0 {
+			l.handler.Write(batch)
+			continue
+		}
+
 		select {
-		case msg := <-l.msg:
-			l.handler.Write(msg)
-			l.putBuf(msg)
+		case <-l.notify:
 		case <-l.quit:
-			if len(l.msg) == 0 {
-				return
+			for {
+				batch = l.fillBatch(batch[0:0])
+				if len(batch) == 0 {
+					return
+				}
+				l.handler.Write(batch)
 			}
 		}
 	}
 }
 
-func (l *Logger) popBuf() []byte {
-	l.Lock()
-	var buf []byte
-	if len(l.bufs) == 0 {
-		buf = make([]byte, 0, 1024)
-	} else {
-		buf = l.bufs[len(l.bufs)-1]
-		l.bufs = l.bufs[0 : len(l.bufs)-1]
+//pop the log lines into batch until the ring is empty or batch is full
+func (l *Logger) fillBatch(batch []byte) []byte {
+	for len(batch) < maxBatchSize {
+		msg, ok := l.ring.pop()
+		if !ok {
+			break
+		}
+		batch = append(batch, msg...)
+		atomic.AddInt64(&l.written, 1)
+		l.putBuf(msg)
+	}
+	return batch
+}
+
+//push the log line to the flusher, according to the overflow policy
+//if there are too many lines waiting for flush
+func (l *Logger) push(buf []byte) {
+	l.closeLock.RLock()
+	defer l.closeLock.RUnlock()
+	//the lines after Close are never flushed
+	if atomic.LoadInt32(&l.closed) != 0 {
+		atomic.AddInt64(&l.dropped, 1)
+		l.putBuf(buf)
+		return
+	}
+	for !l.ring.push(buf) {
+		if atomic.LoadInt32(&l.overflow) != OverflowBlock {
+			atomic.AddInt64(&l.dropped, 1)
+			l.putBuf(buf)
+			return
+		}
+		runtime.Gosched()
 	}
-	l.Unlock()
 
-	return buf
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Logger) popBuf() []byte {
+	if buf, ok := l.bufs.Get().([]byte); ok {
+		return buf
+	}
+	return make([]byte, 0, 1024)
 }
 
 func (l *Logger) putBuf(buf []byte) {
-	l.Lock()
-	if len(l.bufs) < maxBufPoolSize {
-		buf = buf[0:0]
-		l.bufs = append(l.bufs, buf)
+	if cap(buf) <= maxBufSize {
+		l.bufs.Put(buf[0:0])
 	}
-	l.Unlock()
 }
 
 func (l *Logger) Close() {
-	if l.closed {
+	l.closeLock.Lock()
+	ok := atomic.CompareAndSwapInt32(&l.closed, 0, 1)
+	l.closeLock.Unlock()
+	if !ok {
 		return
 	}
-	l.closed = true
 
 	close(l.quit)
 	l.wg.Wait()
@@ -165,6 +222,26 @@ func (l *Logger) Level() int {
 	return l.level
 }
 
+//set the overflow policy: OverflowDrop or OverflowBlock
+func (l *Logger) SetOverflowPolicy(policy int) {
+	atomic.StoreInt32(&l.overflow, int32(policy))
+}
+
+//the count of log lines written to handler
+func (l *Logger) Written() int64 {
+	return atomic.LoadInt64(&l.written)
+}
+
+//the count of log lines dropped because of overflow
+func (l *Logger) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+//the count of log lines waiting for flush
+func (l *Logger) Buffered() int {
+	return l.ring.len()
+}
+
 //a low interface, maybe you can use it for your special log format
 //but it may be not exported later......
 func (l *Logger) Output(callDepth int, level int, format string, v ...interface{}) {
@@ -214,7 +291,7 @@ func (l *Logger) Output(callDepth int, level int, format string, v ...interface{
 		buf = append(buf, '\n')
 	}
 
-	l.msg <- buf
+	l.push(buf)
 }
 
 func SetLevel(level int) {
@@ -282,7 +359,7 @@ func OutputSql(state string, format string, v ...interface{}) {
 		buf = append(buf, '\n')
 	}
 
-	l.msg <- buf
+	l.push(buf)
 }
 
 func output(level int, module string, method string, msg string, reqId uint32, args ...interface{}) {
@@ -290,6 +367,16 @@ func output(level int, module string, method string, msg string, reqId uint32, a
 		return
 	}
 
+	//the repetitive warns and errors are sampled by module, method and msg
+	var suppressed int64
+	if level == LevelWarn || level == LevelError {
+		var ok bool
+		ok, suppressed = GlobalSampler.Allow(module+":"+method+":"+msg, time.Now().Unix())
+		if !ok {
+			return
+		}
+	}
+
 	num := len(args) / 2
 	var argsBuff bytes.Buffer
 	for i := 0; i < num; i++ {
@@ -304,6 +391,9 @@ func output(level int, module string, method string, msg string, reqId uint32, a
 
 	content := fmt.Sprintf(`[%s] "%s" "%s" "%s" conn_id=%d`,
 		module, method, msg, argsBuff.String(), reqId)
+	if 0 < suppressed {
+		content += fmt.Sprintf(" suppressed=%d", suppressed)
+	}
 
 	GlobalSysLogger.Output(3, level, content)
 }
@@ -16,6 +16,8 @@ package golog
 
 import (
 	"os"
+	"runtime"
+	"sync"
 	"testing"
 )
 
@@ -71,3 +73,132 @@ func TestRotatingFileLog(t *testing.T) {
 
 	//os.RemoveAll(path)
 }
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(5)
+	if len(r.slots) != 8 {
+		t.Fatal(len(r.slots))
+	}
+	for i := 0; i < 8; i++ {
+		if !r.push([]byte{byte(i)}) {
+			t.Fatal("push", i)
+		}
+	}
+	if r.push([]byte{8}) || r.len() != 8 {
+		t.Fatal("ring should be full", r.len())
+	}
+	for i := 0; i < 8; i++ {
+		if buf, ok := r.pop(); !ok || buf[0] != byte(i) {
+			t.Fatal("pop", i, buf)
+		}
+	}
+	if _, ok := r.pop(); ok {
+		t.Fatal("ring should be empty")
+	}
+
+	//many producers and one consumer
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				for !r.push([]byte{1}) {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	count := 0
+	for count < 4000 {
+		if _, ok := r.pop(); ok {
+			count++
+		} else {
+			runtime.Gosched()
+		}
+	}
+	wg.Wait()
+	if r.len() != 0 {
+		t.Fatal(r.len())
+	}
+}
+
+type blockHandler struct {
+	release chan struct{}
+	lines   int
+}
+
+func (h *blockHandler) Write(b []byte) (int, error) {
+	<-h.release
+	for _, c := range b {
+		if c == '\n' {
+			h.lines++
+		}
+	}
+	return len(b), nil
+}
+
+func (h *blockHandler) Close() error {
+	return nil
+}
+
+func TestLogOverflow(t *testing.T) {
+	h := &blockHandler{release: make(chan struct{})}
+	l := New(h, 0)
+
+	total := ringSize * 2
+	for i := 0; i < total; i++ {
+		l.Output(1, LevelInfo, "line %d", i)
+	}
+	if l.Dropped() == 0 {
+		t.Fatal("the lines should be dropped when the flusher is blocked")
+	}
+
+	close(h.release)
+	l.Close()
+	if l.Written()+l.Dropped() != int64(total) || int64(h.lines) != l.Written() {
+		t.Fatal(l.Written(), l.Dropped(), h.lines)
+	}
+}
+
+func TestLogAfterClose(t *testing.T) {
+	h := &blockHandler{release: make(chan struct{})}
+	close(h.release)
+	l := New(h, 0)
+	l.SetOverflowPolicy(OverflowBlock)
+	l.Output(1, LevelInfo, "before close")
+	l.Close()
+
+	//the lines after Close are dropped, though the ring has space
+	l.Output(1, LevelInfo, "after close")
+	if l.Written() != 1 || l.Dropped() != 1 || h.lines != 1 || l.Buffered() != 0 {
+		t.Fatal(l.Written(), l.Dropped(), h.lines, l.Buffered())
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(2)
+	var now int64 = 1000
+	for i := 0; i < 5; i++ {
+		ok, suppressed := s.Allow("a", now)
+		if ok != (i < 2) || suppressed != 0 {
+			t.Fatal(i, ok, suppressed)
+		}
+	}
+	if ok, _ := s.Allow("b", now); !ok {
+		t.Fatal("another class should not be limited")
+	}
+
+	//the suppressed count of the last window is reported
+	ok, suppressed := s.Allow("a", now+SampleWindow)
+	if !ok || suppressed != 3 {
+		t.Fatal(ok, suppressed)
+	}
+
+	s.SetLimit(0)
+	for i := 0; i < 5; i++ {
+		if ok, _ := s.Allow("a", now); !ok {
+			t.Fatal("no limit")
+		}
+	}
+}
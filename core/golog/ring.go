@@ -0,0 +1,96 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package golog
+
+import (
+	"sync/atomic"
+)
+
+type ringSlot struct {
+	seq uint64
+	buf []byte
+}
+
+//ringBuffer is a bounded lock-free queue of log lines, many goroutines
+//push and the flusher pops. The seq of a slot tells whether the slot is
+//ready to push or pop at a position, so no lock is needed.
+type ringBuffer struct {
+	head  uint64 //the next position to push
+	tail  uint64 //the next position to pop
+	mask  uint64
+	slots []ringSlot
+}
+
+//the size is rounded up to a power of 2
+func newRingBuffer(size int) *ringBuffer {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	r := new(ringBuffer)
+	r.mask = uint64(n - 1)
+	r.slots = make([]ringSlot, n)
+	for i := range r.slots {
+		r.slots[i].seq = uint64(i)
+	}
+	return r
+}
+
+//push return false if the ring is full
+func (r *ringBuffer) push(buf []byte) bool {
+	for {
+		pos := atomic.LoadUint64(&r.head)
+		slot := &r.slots[pos&r.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		if seq == pos {
+			if atomic.CompareAndSwapUint64(&r.head, pos, pos+1) {
+				slot.buf = buf
+				atomic.StoreUint64(&slot.seq, pos+1)
+				return true
+			}
+		} else if seq < pos {
+			return false
+		}
+	}
+}
+
+//pop return false if the ring is empty
+func (r *ringBuffer) pop() ([]byte, bool) {
+	for {
+		pos := atomic.LoadUint64(&r.tail)
+		slot := &r.slots[pos&r.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		if seq == pos+1 {
+			if atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				buf := slot.buf
+				slot.buf = nil
+				atomic.StoreUint64(&slot.seq, pos+r.mask+1)
+				return buf, true
+			}
+		} else if seq < pos+1 {
+			return nil, false
+		}
+	}
+}
+
+func (r *ringBuffer) len() int {
+	head := atomic.LoadUint64(&r.head)
+	tail := atomic.LoadUint64(&r.tail)
+	if head < tail {
+		return 0
+	}
+	return int(head - tail)
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package golog
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	SampleWindow     = 60    //seconds
+	maxSampleClasses = 10000 //the max count of log classes tracked
+)
+
+type sampleEntry struct {
+	start      int64
+	count      int64
+	suppressed int64
+}
+
+//Sampler limits the lines of a log class, such as an error of the same
+//sql fingerprint, to at most limit lines per SampleWindow seconds.
+type Sampler struct {
+	sync.Mutex
+
+	limit   int64
+	entries map[string]*sampleEntry
+}
+
+//limit is the max lines of a class in a window, 0 means no limit
+func NewSampler(limit int) *Sampler {
+	s := new(Sampler)
+	s.limit = int64(limit)
+	s.entries = make(map[string]*sampleEntry)
+	return s
+}
+
+func (s *Sampler) SetLimit(limit int) {
+	s.Lock()
+	s.limit = int64(limit)
+	s.entries = make(map[string]*sampleEntry)
+	s.Unlock()
+}
+
+//Allow return whether a line of class should be logged now, and the count
+//of lines suppressed in the last window, which should be logged with it.
+func (s *Sampler) Allow(class string, now int64) (bool, int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.limit <= 0 {
+		return true, 0
+	}
+
+	var suppressed int64
+	e, ok := s.entries[class]
+	if !ok {
+		if maxSampleClasses <= len(s.entries) {
+			s.evict(now)
+			if maxSampleClasses <= len(s.entries) {
+				return true, 0
+			}
+		}
+		e = &sampleEntry{start: now}
+		s.entries[class] = e
+	} else if SampleWindow <= now-e.start {
+		suppressed = e.suppressed
+		e.start = now
+		e.count = 0
+		e.suppressed = 0
+	}
+
+	e.count++
+	if s.limit < e.count {
+		e.suppressed++
+		return false, 0
+	}
+	return true, suppressed
+}
+
+//remove the classes not logged in the last window, their suppressed
+//lines are lost
+func (s *Sampler) evict(now int64) {
+	for class, e := range s.entries {
+		if SampleWindow <= now-e.start {
+			delete(s.entries, class)
+		}
+	}
+}
+
+//the sampler of warn and error logs, and the error sql logs
+var GlobalSampler *Sampler = NewSampler(0)
+
+//SetSampleLimit set the max lines of a warn or error class per minute
+func SetSampleLimit(limit int) {
+	GlobalSampler.SetLimit(limit)
+}
+
+//OutputSqlSampled output the sql log, at most the sample limit lines of
+//a class per minute, class is usually the fingerprint of sql
+func OutputSqlSampled(state string, class string, format string, v ...interface{}) {
+	ok, suppressed := GlobalSampler.Allow("sql:"+class, time.Now().Unix())
+	if !ok {
+		return
+	}
+	if 0 < suppressed {
+		format += " - suppressed %d"
+		v = append(v, suppressed)
+	}
+	OutputSql(state, format, v...)
+}
@@ -0,0 +1,575 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+//the types of binlog events, see
+//https://dev.mysql.com/doc/internals/en/binlog-event-type.html
+const (
+	QUERY_EVENT              byte = 2
+	ROTATE_EVENT             byte = 4
+	FORMAT_DESCRIPTION_EVENT byte = 15
+	XID_EVENT                byte = 16
+	TABLE_MAP_EVENT          byte = 19
+	WRITE_ROWS_EVENTv1       byte = 23
+	UPDATE_ROWS_EVENTv1      byte = 24
+	DELETE_ROWS_EVENTv1      byte = 25
+	HEARTBEAT_EVENT          byte = 27
+	WRITE_ROWS_EVENTv2       byte = 30
+	UPDATE_ROWS_EVENTv2      byte = 31
+	DELETE_ROWS_EVENTv2      byte = 32
+)
+
+//the column types used in binlog only
+const (
+	MYSQL_TYPE_TIMESTAMP2 byte = 17
+	MYSQL_TYPE_DATETIME2  byte = 18
+	MYSQL_TYPE_TIME2      byte = 19
+	MYSQL_TYPE_JSON       byte = 245
+)
+
+const BinlogEventHeaderSize = 19
+
+type BinlogEventHeader struct {
+	Timestamp uint32
+	EventType byte
+	ServerId  uint32
+	EventSize uint32
+	LogPos    uint32 //the position of the next event
+	Flags     uint16
+}
+
+func ParseBinlogEventHeader(data []byte) (*BinlogEventHeader, error) {
+	if len(data) < BinlogEventHeaderSize {
+		return nil, fmt.Errorf("invalid binlog event header length %d", len(data))
+	}
+	h := new(BinlogEventHeader)
+	h.Timestamp = binary.LittleEndian.Uint32(data[0:])
+	h.EventType = data[4]
+	h.ServerId = binary.LittleEndian.Uint32(data[5:])
+	h.EventSize = binary.LittleEndian.Uint32(data[9:])
+	h.LogPos = binary.LittleEndian.Uint32(data[13:])
+	h.Flags = binary.LittleEndian.Uint16(data[17:])
+	return h, nil
+}
+
+//RotateEvent switches the binlog to NextFile from Position
+type RotateEvent struct {
+	Position uint64
+	NextFile string
+}
+
+func ParseRotateEvent(body []byte) (*RotateEvent, error) {
+	if len(body) < 8 {
+		return nil, fmt.Errorf("invalid rotate event length %d", len(body))
+	}
+	return &RotateEvent{
+		Position: binary.LittleEndian.Uint64(body),
+		NextFile: string(body[8:]),
+	}, nil
+}
+
+//TableMapEvent is the definition of the table of the following rows
+//events. The binlog has no unsigned flag of the columns, Unsigned is set
+//by the caller before the rows events are parsed.
+type TableMapEvent struct {
+	TableId     uint64
+	Schema      string
+	Table       string
+	ColumnTypes []byte
+	ColumnMeta  []uint16
+	Unsigned    []bool
+}
+
+func ParseTableMapEvent(body []byte) (*TableMapEvent, error) {
+	e := new(TableMapEvent)
+	if len(body) < 9 {
+		return nil, fmt.Errorf("invalid table map event length %d", len(body))
+	}
+	e.TableId = readUint48(body)
+	pos := 8
+
+	var err error
+	if e.Schema, pos, err = readBinlogName(body, pos); err != nil {
+		return nil, err
+	}
+	if e.Table, pos, err = readBinlogName(body, pos); err != nil {
+		return nil, err
+	}
+
+	count, _, n := LengthEncodedInt(body[pos:])
+	pos += n
+	if len(body) < pos+int(count) {
+		return nil, fmt.Errorf("invalid table map event of %s.%s", e.Schema, e.Table)
+	}
+	e.ColumnTypes = body[pos : pos+int(count)]
+	pos += int(count)
+
+	metaSize, _, n := LengthEncodedInt(body[pos:])
+	pos += n
+	if len(body) < pos+int(metaSize) {
+		return nil, fmt.Errorf("invalid table map event of %s.%s", e.Schema, e.Table)
+	}
+	if e.ColumnMeta, err = parseColumnMeta(e.ColumnTypes, body[pos:pos+int(metaSize)]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+//the name with its length before and 0 after
+func readBinlogName(body []byte, pos int) (string, int, error) {
+	if len(body) <= pos {
+		return "", pos, fmt.Errorf("invalid table map event")
+	}
+	n := int(body[pos])
+	if len(body) < pos+n+2 {
+		return "", pos, fmt.Errorf("invalid table map event")
+	}
+	return string(body[pos+1 : pos+1+n]), pos + n + 2, nil
+}
+
+func parseColumnMeta(types []byte, data []byte) ([]uint16, error) {
+	meta := make([]uint16, len(types))
+	pos := 0
+	for i, t := range types {
+		size := 0
+		switch t {
+		case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_BLOB, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON,
+			MYSQL_TYPE_TIMESTAMP2, MYSQL_TYPE_DATETIME2, MYSQL_TYPE_TIME2:
+			size = 1
+		case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_BIT:
+			size = 2
+		case MYSQL_TYPE_STRING, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_NEWDECIMAL:
+			size = -2 //big endian
+		}
+		switch {
+		case size == 0:
+			continue
+		case size < 0:
+			size = -size
+			if len(data) < pos+size {
+				return nil, fmt.Errorf("invalid column meta")
+			}
+			meta[i] = uint16(data[pos])<<8 | uint16(data[pos+1])
+		default:
+			if len(data) < pos+size {
+				return nil, fmt.Errorf("invalid column meta")
+			}
+			meta[i] = uint16(data[pos])
+			if size == 2 {
+				meta[i] |= uint16(data[pos+1]) << 8
+			}
+		}
+		pos += size
+	}
+	return meta, nil
+}
+
+//RowsEvent is the rows of a write, update or delete rows event, the rows
+//of update are the pairs of the row before and after. The value of a
+//column not in the row image is nil, as well as NULL.
+type RowsEvent struct {
+	TableId   uint64
+	EventType byte
+	Rows      [][]interface{}
+}
+
+func IsRowsEvent(eventType byte) bool {
+	switch eventType {
+	case WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1,
+		WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2:
+		return true
+	}
+	return false
+}
+
+//RowsEventTableId returns the table id of a rows event, so its table map
+//can be found before parsing
+func RowsEventTableId(body []byte) (uint64, error) {
+	if len(body) < 8 {
+		return 0, fmt.Errorf("invalid rows event length %d", len(body))
+	}
+	return readUint48(body), nil
+}
+
+func ParseRowsEvent(eventType byte, body []byte, table *TableMapEvent) (*RowsEvent, error) {
+	e := new(RowsEvent)
+	e.EventType = eventType
+	var err error
+	if e.TableId, err = RowsEventTableId(body); err != nil {
+		return nil, err
+	}
+	pos := 8
+	if eventType == WRITE_ROWS_EVENTv2 || eventType == UPDATE_ROWS_EVENTv2 || eventType == DELETE_ROWS_EVENTv2 {
+		if len(body) < pos+2 {
+			return nil, fmt.Errorf("invalid rows event length %d", len(body))
+		}
+		//the length of extra data includes itself
+		pos += int(binary.LittleEndian.Uint16(body[pos:]))
+	}
+	if len(body) <= pos {
+		return nil, fmt.Errorf("invalid rows event length %d", len(body))
+	}
+
+	count, _, n := LengthEncodedInt(body[pos:])
+	pos += n
+	if int(count) != len(table.ColumnTypes) {
+		return nil, fmt.Errorf("rows event has %d columns, but table map of %s.%s has %d",
+			count, table.Schema, table.Table, len(table.ColumnTypes))
+	}
+	bitmapSize := (int(count) + 7) / 8
+	if len(body) < pos+bitmapSize {
+		return nil, fmt.Errorf("invalid rows event length %d", len(body))
+	}
+	present := body[pos : pos+bitmapSize]
+	pos += bitmapSize
+	presentAfter := present
+	update := eventType == UPDATE_ROWS_EVENTv1 || eventType == UPDATE_ROWS_EVENTv2
+	if update {
+		if len(body) < pos+bitmapSize {
+			return nil, fmt.Errorf("invalid rows event length %d", len(body))
+		}
+		presentAfter = body[pos : pos+bitmapSize]
+		pos += bitmapSize
+	}
+
+	for pos < len(body) {
+		row, n, err := decodeRow(body[pos:], table, present)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		e.Rows = append(e.Rows, row)
+		if update {
+			row, n, err = decodeRow(body[pos:], table, presentAfter)
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+			e.Rows = append(e.Rows, row)
+		}
+	}
+	return e, nil
+}
+
+func bitSet(bitmap []byte, i int) bool {
+	return bitmap[i/8]&(1<<uint(i%8)) != 0
+}
+
+func decodeRow(data []byte, table *TableMapEvent, present []byte) ([]interface{}, int, error) {
+	presentCount := 0
+	for i := range table.ColumnTypes {
+		if bitSet(present, i) {
+			presentCount++
+		}
+	}
+	nullSize := (presentCount + 7) / 8
+	if len(data) < nullSize {
+		return nil, 0, fmt.Errorf("invalid row of %s.%s", table.Schema, table.Table)
+	}
+	nulls := data[:nullSize]
+	pos := nullSize
+
+	row := make([]interface{}, len(table.ColumnTypes))
+	index := 0
+	for i, t := range table.ColumnTypes {
+		if !bitSet(present, i) {
+			continue
+		}
+		isNull := bitSet(nulls, index)
+		index++
+		if isNull {
+			continue
+		}
+		unsigned := i < len(table.Unsigned) && table.Unsigned[i]
+		v, n, err := decodeBinlogValue(data[pos:], t, table.ColumnMeta[i], unsigned)
+		if err != nil {
+			return nil, 0, fmt.Errorf("column %d of %s.%s: %s", i, table.Schema, table.Table, err.Error())
+		}
+		row[i] = v
+		pos += n
+	}
+	return row, pos, nil
+}
+
+func readUint48(data []byte) uint64 {
+	return uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 |
+		uint64(data[3])<<24 | uint64(data[4])<<32 | uint64(data[5])<<40
+}
+
+//read the little endian uint of n bytes
+func readUintLE(data []byte, n int) uint64 {
+	var v uint64
+	for i := n - 1; 0 <= i; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+//read the big endian uint of n bytes
+func readUintBE(data []byte, n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+//the value of a column in rows event, integers are int64 or uint64,
+//decimals, dates and times are string, and blobs are []byte
+func decodeBinlogValue(data []byte, t byte, meta uint16, unsigned bool) (interface{}, int, error) {
+	//the real type and length of string, enum and set are in meta
+	length := int(meta)
+	if t == MYSQL_TYPE_STRING && 256 <= meta {
+		b0, b1 := byte(meta>>8), int(meta&0xff)
+		if b0&0x30 != 0x30 {
+			length = b1 | int((b0&0x30)^0x30)<<4
+			t = b0 | 0x30
+		} else {
+			length = b1
+			t = b0
+		}
+	}
+
+	size := 0
+	switch t {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_YEAR:
+		size = 1
+	case MYSQL_TYPE_SHORT:
+		size = 2
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_DATE, MYSQL_TYPE_TIME:
+		size = 3
+	case MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT, MYSQL_TYPE_TIMESTAMP:
+		size = 4
+	case MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_DATETIME:
+		size = 8
+	case MYSQL_TYPE_TIMESTAMP2:
+		size = 4 + (int(meta)+1)/2
+	case MYSQL_TYPE_DATETIME2:
+		size = 5 + (int(meta)+1)/2
+	case MYSQL_TYPE_TIME2:
+		size = 3 + (int(meta)+1)/2
+	case MYSQL_TYPE_NEWDECIMAL:
+		size = decimalBinSize(int(meta>>8), int(meta&0xff))
+	case MYSQL_TYPE_BIT:
+		size = (int(meta>>8)*8 + int(meta&0xff) + 7) / 8
+	case MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+		size = length & 0xff
+	}
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("invalid value of type %d", t)
+	}
+
+	switch t {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24, MYSQL_TYPE_LONG, MYSQL_TYPE_LONGLONG:
+		v := readUintLE(data, size)
+		if unsigned {
+			return v, size, nil
+		}
+		//sign extension
+		shift := uint(64 - size*8)
+		return int64(v<<shift) >> shift, size, nil
+	case MYSQL_TYPE_YEAR:
+		if data[0] == 0 {
+			return int64(0), size, nil
+		}
+		return int64(data[0]) + 1900, size, nil
+	case MYSQL_TYPE_FLOAT:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), size, nil
+	case MYSQL_TYPE_DOUBLE:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), size, nil
+	case MYSQL_TYPE_NEWDECIMAL:
+		return decodeBinlogDecimal(data[:size], int(meta>>8), int(meta&0xff)), size, nil
+	case MYSQL_TYPE_BIT:
+		return readUintBE(data, size), size, nil
+	case MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+		//the index of enum and the bitmap of set
+		return readUintLE(data, size), size, nil
+	case MYSQL_TYPE_DATE:
+		v := readUintLE(data, 3)
+		return fmt.Sprintf("%04d-%02d-%02d", v>>9, (v>>5)&15, v&31), size, nil
+	case MYSQL_TYPE_TIME:
+		v := int64(readUintLE(data, 3)<<40) >> 40
+		sign := ""
+		if v < 0 {
+			sign, v = "-", -v
+		}
+		return fmt.Sprintf("%s%02d:%02d:%02d", sign, v/10000, v%10000/100, v%100), size, nil
+	case MYSQL_TYPE_DATETIME:
+		v := binary.LittleEndian.Uint64(data)
+		ymd, hms := v/1000000, v%1000000
+		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d",
+			ymd/10000, ymd%10000/100, ymd%100, hms/10000, hms%10000/100, hms%100), size, nil
+	case MYSQL_TYPE_TIMESTAMP:
+		return formatTimestamp(int64(binary.LittleEndian.Uint32(data)), ""), size, nil
+	case MYSQL_TYPE_TIMESTAMP2:
+		sec := int64(readUintBE(data, 4))
+		return formatTimestamp(sec, formatFrac(data[4:size], int(meta))), size, nil
+	case MYSQL_TYPE_DATETIME2:
+		v := int64(readUintBE(data, 5)) - 0x8000000000
+		ymd, hms := v>>17, v%(1<<17)
+		ym := ymd >> 5
+		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d%s",
+			ym/13, ym%13, ymd%(1<<5), hms>>12, (hms>>6)%(1<<6), hms%(1<<6),
+			formatFrac(data[5:size], int(meta))), size, nil
+	case MYSQL_TYPE_TIME2:
+		return decodeTime2(data[:size], int(meta)), size, nil
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING:
+		prefix := 1
+		if 256 <= length {
+			prefix = 2
+		}
+		return readBinlogBytes(data, prefix, true)
+	case MYSQL_TYPE_BLOB, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
+		return readBinlogBytes(data, int(meta), false)
+	}
+	return nil, 0, fmt.Errorf("unsupported type %d", t)
+}
+
+//the bytes with the length in prefix bytes before
+func readBinlogBytes(data []byte, prefix int, str bool) (interface{}, int, error) {
+	if prefix < 1 || 4 < prefix || len(data) < prefix {
+		return nil, 0, fmt.Errorf("invalid length")
+	}
+	n := int(readUintLE(data, prefix))
+	if len(data) < prefix+n {
+		return nil, 0, fmt.Errorf("invalid length %d", n)
+	}
+	b := data[prefix : prefix+n]
+	if str {
+		return string(b), prefix + n, nil
+	}
+	return append([]byte(nil), b...), prefix + n, nil
+}
+
+func formatTimestamp(sec int64, frac string) string {
+	if sec == 0 {
+		return "0000-00-00 00:00:00" + frac
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04:05") + frac
+}
+
+//the fractional seconds of fsp digits, stored in (fsp+1)/2 bytes
+func formatFrac(data []byte, fsp int) string {
+	if fsp == 0 || len(data) == 0 {
+		return ""
+	}
+	v := readUintBE(data, len(data))
+	//the bytes hold 2, 4 or 6 digits
+	for i := len(data) * 2; fsp < i; i-- {
+		v /= 10
+	}
+	return fmt.Sprintf(".%0*d", fsp, v)
+}
+
+func decodeTime2(data []byte, fsp int) string {
+	var tmp int64
+	intPart := int64(readUintBE(data, 3)) - 0x800000
+	switch (fsp + 1) / 2 {
+	case 0:
+		tmp = intPart << 24
+	case 1:
+		frac := int64(data[3])
+		if intPart < 0 && 0 < frac {
+			intPart++
+			frac -= 0x100
+		}
+		tmp = intPart<<24 + frac*10000
+	case 2:
+		frac := int64(readUintBE(data[3:], 2))
+		if intPart < 0 && 0 < frac {
+			intPart++
+			frac -= 0x10000
+		}
+		tmp = intPart<<24 + frac*100
+	default:
+		tmp = int64(readUintBE(data, 6)) - 0x800000000000
+	}
+
+	sign := ""
+	if tmp < 0 {
+		sign, tmp = "-", -tmp
+	}
+	hms, micro := tmp>>24, tmp%(1<<24)
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, (hms>>12)%(1<<10), (hms>>6)%(1<<6), hms%(1<<6))
+	if 0 < fsp {
+		for i := 6; fsp < i; i-- {
+			micro /= 10
+		}
+		s += fmt.Sprintf(".%0*d", fsp, micro)
+	}
+	return s
+}
+
+//the bytes of the leftover digits of a decimal, the others are stored
+//in 4 bytes for every 9 digits
+var decimalLeftoverBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+func decimalBinSize(precision int, scale int) int {
+	integral := precision - scale
+	return integral/9*4 + decimalLeftoverBytes[integral%9] +
+		scale/9*4 + decimalLeftoverBytes[scale%9]
+}
+
+func decodeBinlogDecimal(data []byte, precision int, scale int) string {
+	buf := append([]byte(nil), data...)
+	//the sign bit is 1 for positive, and the negative is stored inverted
+	negative := buf[0]&0x80 == 0
+	buf[0] ^= 0x80
+	if negative {
+		for i := range buf {
+			buf[i] = ^buf[i]
+		}
+	}
+
+	integral := precision - scale
+	var digits []string
+	pos := 0
+	if n := decimalLeftoverBytes[integral%9]; 0 < n {
+		digits = append(digits, fmt.Sprintf("%0*d", integral%9, readUintBE(buf[pos:], n)))
+		pos += n
+	}
+	for i := 0; i < integral/9; i++ {
+		digits = append(digits, fmt.Sprintf("%09d", readUintBE(buf[pos:], 4)))
+		pos += 4
+	}
+	s := strings.TrimLeft(strings.Join(digits, ""), "0")
+	if len(s) == 0 {
+		s = "0"
+	}
+	if negative {
+		s = "-" + s
+	}
+	if scale == 0 {
+		return s
+	}
+
+	digits = digits[:0]
+	for i := 0; i < scale/9; i++ {
+		digits = append(digits, fmt.Sprintf("%09d", readUintBE(buf[pos:], 4)))
+		pos += 4
+	}
+	if n := decimalLeftoverBytes[scale%9]; 0 < n {
+		digits = append(digits, fmt.Sprintf("%0*d", scale%9, readUintBE(buf[pos:], n)))
+	}
+	return s + "." + strings.Join(digits, "")
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBinlogValue(t *testing.T) {
+	tests := []struct {
+		data     string
+		t        byte
+		meta     uint16
+		unsigned bool
+		expect   interface{}
+	}{
+		{"ff", MYSQL_TYPE_TINY, 0, false, int64(-1)},
+		{"ff", MYSQL_TYPE_TINY, 0, true, uint64(255)},
+		{"feffff", MYSQL_TYPE_INT24, 0, false, int64(-2)},
+		{"0100000000000080", MYSQL_TYPE_LONGLONG, 0, true, uint64(1<<63 + 1)},
+		{"74", MYSQL_TYPE_YEAR, 0, false, int64(2016)},
+		{"000000000000f83f", MYSQL_TYPE_DOUBLE, 8, false, 1.5},
+		//DECIMAL(14,4), see decimal2bin of mysql
+		{"810dfb38d204d2", MYSQL_TYPE_NEWDECIMAL, 14<<8 | 4, false, "1234567890.1234"},
+		{"7ef204c72dfb2d", MYSQL_TYPE_NEWDECIMAL, 14<<8 | 4, false, "-1234567890.1234"},
+		{"800000", MYSQL_TYPE_NEWDECIMAL, 5<<8 | 2, false, "0.00"},
+		{"a1c00f", MYSQL_TYPE_DATE, 0, false, "2016-05-01"},
+		{"999942c8b8", MYSQL_TYPE_DATETIME2, 0, false, "2016-05-01 12:34:56"},
+		{"999942c8b804e2", MYSQL_TYPE_DATETIME2, 3, false, "2016-05-01 12:34:56.125"},
+		{"80c8b8", MYSQL_TYPE_TIME2, 0, false, "12:34:56"},
+		{"7fffff", MYSQL_TYPE_TIME2, 0, false, "-00:00:01"},
+		{"03616263", MYSQL_TYPE_VARCHAR, 60, false, "abc"},
+		{"0300616263", MYSQL_TYPE_VARCHAR, 300, false, "abc"},
+		//CHAR(10) in utf8
+		{"026869", MYSQL_TYPE_STRING, uint16(MYSQL_TYPE_STRING)<<8 | 30, false, "hi"},
+		//ENUM with 1 byte index
+		{"02", MYSQL_TYPE_STRING, uint16(MYSQL_TYPE_ENUM)<<8 | 1, false, uint64(2)},
+		{"0200abcd", MYSQL_TYPE_BLOB, 2, false, []byte{0xab, 0xcd}},
+		{"0105", MYSQL_TYPE_BIT, 1<<8 | 1, false, uint64(0x105)},
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		v, n, err := decodeBinlogValue(data, test.t, test.meta, test.unsigned)
+		if err != nil {
+			t.Fatal(test.data, err)
+		}
+		if n != len(data) || !reflect.DeepEqual(v, test.expect) {
+			t.Fatal(test.data, n, v)
+		}
+	}
+
+	if _, _, err := decodeBinlogValue([]byte{1}, MYSQL_TYPE_LONG, 0, false); err == nil {
+		t.Fatal("the value is too short")
+	}
+}
+
+func TestParseRowsEvent(t *testing.T) {
+	//id int unsigned, name varchar(20), score bigint
+	table := []byte{1, 0, 0, 0, 0, 0, 1, 0, 2, 'd', 'b', 0, 3, 't', '_', '1', 0}
+	table = append(table, 3, MYSQL_TYPE_LONG, MYSQL_TYPE_VARCHAR, MYSQL_TYPE_LONGLONG)
+	table = append(table, 2, 60, 0) //the max length of varchar
+	table = append(table, 0x06)     //null bitmap
+	tm, err := ParseTableMapEvent(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm.TableId != 1 || tm.Schema != "db" || tm.Table != "t_1" ||
+		!reflect.DeepEqual(tm.ColumnMeta, []uint16{0, 60, 0}) {
+		t.Fatal(tm)
+	}
+	tm.Unsigned = []bool{true, false, false}
+
+	rows := []byte{1, 0, 0, 0, 0, 0, 1, 0, 2, 0, 3, 0x07, 0x07}
+	//before: 4294967295, 'a', NULL
+	rows = append(rows, 0x04, 0xff, 0xff, 0xff, 0xff, 1, 'a')
+	//after: 1, NULL, -1
+	rows = append(rows, 0x02, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	e, err := ParseRowsEvent(UPDATE_ROWS_EVENTv2, rows, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]interface{}{
+		{uint64(4294967295), "a", nil},
+		{uint64(1), nil, int64(-1)},
+	}
+	if !reflect.DeepEqual(e.Rows, expect) {
+		t.Fatal(e.Rows)
+	}
+
+	//the column count differs from the table map
+	rows[10] = 2
+	if _, err = ParseRowsEvent(UPDATE_ROWS_EVENTv2, rows, tm); err == nil {
+		t.Fatal("the column count differs")
+	}
+}
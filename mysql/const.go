@@ -41,8 +41,25 @@ const (
 	SERVER_STATUS_METADATA_CHANGED     uint16 = 0x0400
 	SERVER_QUERY_WAS_SLOW              uint16 = 0x0800
 	SERVER_PS_OUT_PARAMS               uint16 = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    uint16 = 0x2000
+	SERVER_SESSION_STATE_CHANGED       uint16 = 0x4000
 )
 
+//the types of the session state changes in the ok packet, with
+//CLIENT_SESSION_TRACK
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES byte = iota
+	SESSION_TRACK_SCHEMA
+	SESSION_TRACK_STATE_CHANGE
+	SESSION_TRACK_GTIDS
+	SESSION_TRACK_TRANSACTION_CHARACTERISTICS
+	SESSION_TRACK_TRANSACTION_STATE
+)
+
+//the flag of COM_STMT_EXECUTE, the parameter count is sent with
+//CLIENT_QUERY_ATTRIBUTES
+const PARAMETER_COUNT_AVAILABLE byte = 0x08
+
 const (
 	COM_SLEEP byte = iota
 	COM_QUIT
@@ -101,6 +118,12 @@ const (
 	CLIENT_PLUGIN_AUTH
 	CLIENT_CONNECT_ATTRS
 	CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+	CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS
+	CLIENT_SESSION_TRACK
+	CLIENT_DEPRECATE_EOF
+	CLIENT_OPTIONAL_RESULTSET_METADATA
+	CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	CLIENT_QUERY_ATTRIBUTES
 )
 
 //https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::ColumnType
@@ -157,6 +180,8 @@ const (
 
 const (
 	AUTH_NAME = "mysql_native_password"
+	//the default auth plugin since mysql 8.0
+	CACHING_SHA2_AUTH_NAME = "caching_sha2_password"
 )
 
 var (
@@ -176,6 +201,7 @@ var (
 	TK_ID_TRANSACTION = 13
 	TK_ID_SHOW        = 14
 	TK_ID_TRUNCATE    = 15
+	TK_ID_EXPLAIN     = 16
 
 	PARSE_TOKEN_MAP = map[string]int{
 		"insert":      TK_ID_INSERT,
@@ -193,6 +219,9 @@ var (
 		"transaction": TK_ID_TRANSACTION,
 		"show":        TK_ID_SHOW,
 		"truncate":    TK_ID_TRUNCATE,
+		"explain":     TK_ID_EXPLAIN,
+		"desc":        TK_ID_EXPLAIN,
+		"describe":    TK_ID_EXPLAIN,
 	}
 	// '*'
 	COMMENT_PREFIX uint8 = 42
@@ -207,6 +236,15 @@ var (
 	TK_STR_TRANSACTION    = "transaction"
 	TK_STR_LAST_INSERT_ID = "last_insert_id()"
 	TK_STR_MASTER_HINT    = "*master*"
+	TK_STR_RAW_HINT       = "*raw*"
+	TK_STR_JOIN           = "join"
+	TK_STR_UPDATE         = "update"
+	TK_STR_TABLE          = "table"
+	TK_STR_CREATE         = "create"
+	TK_STR_TRUNCATE       = "truncate"
+	TK_STR_EXPLAIN        = "explain"
+	TK_STR_DESC           = "desc"
+	TK_STR_DESCRIBE       = "describe"
 	//show
 	TK_STR_COLUMNS = "columns"
 	TK_STR_FIELDS  = "fields"
@@ -229,5 +267,11 @@ var (
 		"autocommit":           struct{}{},
 		"@@autocommit":         struct{}{},
 		"@@session.autocommit": struct{}{},
+
+		//the session variables of kingshard
+		"kingshard_tenant": struct{}{},
+		"ks_shard":         struct{}{},
+		"ks_node":          struct{}{},
+		"ks_dry_run":       struct{}{},
 	}
 )
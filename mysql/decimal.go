@@ -0,0 +1,101 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+//Decimal is the exact value of a DECIMAL column in text, such as -12.50,
+//so the values can be added and compared without the loss of float64.
+type Decimal string
+
+var bigTen = big.NewInt(10)
+
+//NewDecimal returns the decimal of the unscaled value v and scale, such
+//as 1250 and 2 for 12.50
+func NewDecimal(v *big.Int, scale int) Decimal {
+	digits := new(big.Int).Abs(v).String()
+	if 0 < scale {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if v.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return Decimal(digits)
+}
+
+//Unscaled returns the unscaled value and the scale of d
+func (d Decimal) Unscaled() (*big.Int, int, error) {
+	s := strings.TrimSpace(string(d))
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		scale = len(s) - i - 1
+		s = s[:i] + s[i+1:]
+	}
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid decimal %s", string(d))
+	}
+	return v, scale, nil
+}
+
+//Rescale multiplies the unscaled value v of scale from by the power of 10,
+//so it has the larger scale to
+func Rescale(v *big.Int, from int, to int) *big.Int {
+	if to <= from {
+		return v
+	}
+	n := new(big.Int).Exp(bigTen, big.NewInt(int64(to-from)), nil)
+	return n.Mul(n, v)
+}
+
+//Add returns d + d2, with the larger scale of them
+func (d Decimal) Add(d2 Decimal) (Decimal, error) {
+	v1, s1, err := d.Unscaled()
+	if err != nil {
+		return "", err
+	}
+	v2, s2, err := d2.Unscaled()
+	if err != nil {
+		return "", err
+	}
+	scale := s1
+	if scale < s2 {
+		scale = s2
+	}
+	v := new(big.Int).Add(Rescale(v1, s1, scale), Rescale(v2, s2, scale))
+	return NewDecimal(v, scale), nil
+}
+
+//Cmp compares d and d2 by value, and by text if any of them is invalid
+func (d Decimal) Cmp(d2 Decimal) int {
+	v1, s1, err1 := d.Unscaled()
+	v2, s2, err2 := d2.Unscaled()
+	if err1 != nil || err2 != nil {
+		return strings.Compare(string(d), string(d2))
+	}
+	return Rescale(v1, s1, s2).Cmp(Rescale(v2, s2, s1))
+}
+
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(string(d)), 64)
+}
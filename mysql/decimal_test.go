@@ -0,0 +1,52 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		d1, d2, sum Decimal
+	}{
+		{"1.50", "2.25", "3.75"},
+		{"0.1", "0.2", "0.3"},
+		{"-1.005", "1", "-0.005"},
+		{"99999999999999999999.99", "0.01", "100000000000000000000.00"},
+		{"18446744073709551615", "1", "18446744073709551616"},
+	}
+	for _, test := range tests {
+		sum, err := test.d1.Add(test.d2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != test.sum {
+			t.Fatalf("%s + %s expect %s, got %s", test.d1, test.d2, test.sum, sum)
+		}
+	}
+
+	if _, err := Decimal("1e5").Add("1"); err == nil {
+		t.Fatal("invalid decimal")
+	}
+	if NewDecimal(big.NewInt(-5), 3) != "-0.005" || NewDecimal(big.NewInt(0), 2) != "0.00" {
+		t.Fatal(NewDecimal(big.NewInt(-5), 3), NewDecimal(big.NewInt(0), 2))
+	}
+
+	if Decimal("10.0").Cmp("9.99") != 1 || Decimal("-1").Cmp("1") != -1 || Decimal("1.0").Cmp("1") != 0 {
+		t.Fatal("compare decimals by value")
+	}
+}
@@ -0,0 +1,691 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package mysqltest provides fake mysql servers with scripted responses,
+// so the routing, failover and merge of kingshard can be tested without
+// real mysql instances.
+//
+//	s, err := mysqltest.NewServer()
+//	defer s.Close()
+//	s.Handle(`^select id from t`, &mysqltest.Response{
+//		Names: []string{"id"},
+//		Rows:  [][]interface{}{{1}, {2}},
+//	})
+//
+// The queries without a matched response succeed with an ok packet.
+package mysqltest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+const DefaultUser = "root"
+
+// Response is the canned response of the queries matched. It is an error
+// packet if Err is set, a resultset if Names is set, otherwise an ok
+// packet with AffectedRows, InsertId, Warnings and Info.
+type Response struct {
+	Err          *mysql.SqlError
+	Names        []string
+	Rows         [][]interface{}
+	AffectedRows uint64
+	InsertId     uint64
+	Warnings     uint16
+	Info         string
+	//the response is delayed, such as a slow query
+	Delay time.Duration
+}
+
+type handler struct {
+	pattern *regexp.Regexp
+	resp    *Response
+}
+
+// Server is a fake mysql server listening on a random local port. The
+// user is root and the password is empty by default.
+type Server struct {
+	User     string
+	Password string
+	//the version in handshake, mysql.ServerVersion by default
+	Version string
+	//the default auth plugin of server, mysql_native_password without
+	//the plugin auth capability if empty. caching_sha2_password is
+	//checked by the fast authentication.
+	AuthPlugin string
+	//the clients must use ssl if it is set, see NewTLSServer
+	TLSConfig *tls.Config
+	//the capabilities advertised besides the defaults, CLIENT_DEPRECATE_EOF,
+	//CLIENT_SESSION_TRACK and CLIENT_QUERY_ATTRIBUTES are supported
+	Capability uint32
+
+	l net.Listener
+
+	sync.Mutex
+	handlers     []handler
+	queries      []string
+	queryAttrs   [][]mysql.QueryAttribute
+	initDBs      []string
+	conns        map[uint32]net.Conn
+	connectionId uint32
+	down         bool
+	closed       bool
+	maxConns     int
+	binlog       [][]byte
+}
+
+// NewServer starts a fake mysql server on 127.0.0.1
+func NewServer() (*Server, error) {
+	return NewTLSServer(nil)
+}
+
+// NewTLSServer starts a fake mysql server on 127.0.0.1 which requires the
+// clients to use ssl with tlsConfig, ssl is not supported if it is nil
+func NewTLSServer(tlsConfig *tls.Config) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		User:      DefaultUser,
+		Version:   mysql.ServerVersion,
+		TLSConfig: tlsConfig,
+		l:         l,
+		conns:     make(map[uint32]net.Conn),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Addr is the address to connect, such as 127.0.0.1:3306
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Handle responds resp to the queries matching pattern, which is a case
+// insensitive regexp. The latest handler wins if several ones match.
+func (s *Server) Handle(pattern string, resp *Response) {
+	re := regexp.MustCompile("(?i)" + pattern)
+	s.Lock()
+	s.handlers = append(s.handlers, handler{re, resp})
+	s.Unlock()
+}
+
+// Queries returns the queries received in order, with the sqls prepared
+func (s *Server) Queries() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+// QueryAttributes returns the query attributes of the queries received in
+// order, nil for a query without attribute
+func (s *Server) QueryAttributes() [][]mysql.QueryAttribute {
+	s.Lock()
+	defer s.Unlock()
+	return append([][]mysql.QueryAttribute(nil), s.queryAttrs...)
+}
+
+// InitDBs returns the databases of COM_INIT_DB received in order
+func (s *Server) InitDBs() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.initDBs...)
+}
+
+// ClearQueries drops the queries received
+func (s *Server) ClearQueries() {
+	s.Lock()
+	s.queries = nil
+	s.queryAttrs = nil
+	s.Unlock()
+}
+
+// SetDown closes all the connections and refuses the new ones if down is
+// true, such as a crashed mysql, until SetDown(false).
+func (s *Server) SetDown(down bool) {
+	s.Lock()
+	s.down = down
+	if down {
+		s.closeConns()
+	}
+	s.Unlock()
+}
+
+// SetMaxConns rejects the new connections with ER_CON_COUNT_ERROR before
+// the handshake while n connections are open, such as a mysql reaching
+// max_connections. 0 means no limit.
+func (s *Server) SetMaxConns(n int) {
+	s.Lock()
+	s.maxConns = n
+	s.Unlock()
+}
+
+// AddBinlogEvent appends an event with header to the binlog, the binlog
+// dumps send all the events from the first one regardless of the position
+// requested, and wait for the new ones.
+func (s *Server) AddBinlogEvent(event []byte) {
+	s.Lock()
+	s.binlog = append(s.binlog, event)
+	s.Unlock()
+}
+
+// BinlogEvent returns the binlog event of body with the header, logPos is
+// the position of the next event
+func BinlogEvent(eventType byte, logPos uint32, body []byte) []byte {
+	data := make([]byte, mysql.BinlogEventHeaderSize, mysql.BinlogEventHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(data[0:], uint32(time.Now().Unix()))
+	data[4] = eventType
+	binary.LittleEndian.PutUint32(data[5:], 1) //server id
+	binary.LittleEndian.PutUint32(data[9:], uint32(len(data)+len(body)))
+	binary.LittleEndian.PutUint32(data[13:], logPos)
+	return append(data, body...)
+}
+
+// ConnCount is the number of connections opened
+func (s *Server) ConnCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) Close() error {
+	s.Lock()
+	s.closed = true
+	s.closeConns()
+	s.Unlock()
+	return s.l.Close()
+}
+
+func (s *Server) closeConns() {
+	for id, c := range s.conns {
+		c.Close()
+		delete(s.conns, id)
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.Lock()
+		if s.down || s.closed {
+			s.Unlock()
+			c.Close()
+			continue
+		}
+		if 0 < s.maxConns && s.maxConns <= len(s.conns) {
+			s.Unlock()
+			rejectConn(c, mysql.ER_CON_COUNT_ERROR)
+			continue
+		}
+		s.connectionId++
+		id := s.connectionId
+		s.conns[id] = c
+		s.Unlock()
+
+		go s.onConn(id, c)
+	}
+}
+
+// write the error packet of code before the handshake and close c
+func rejectConn(c net.Conn, code uint16) {
+	data := make([]byte, 4, 64)
+	data = append(data, mysql.ERR_HEADER, byte(code), byte(code>>8))
+	data = append(data, mysql.MySQLErrName[code]...)
+	mysql.NewPacketIO(c).WritePacket(data)
+	c.Close()
+}
+
+func (s *Server) onConn(id uint32, c net.Conn) {
+	conn := &conn{
+		server:       s,
+		c:            c,
+		pkg:          mysql.NewPacketIO(c),
+		connectionId: id,
+		status:       mysql.SERVER_STATUS_AUTOCOMMIT,
+	}
+	defer func() {
+		c.Close()
+		s.Lock()
+		delete(s.conns, id)
+		s.Unlock()
+	}()
+
+	if err := conn.handshake(); err != nil {
+		return
+	}
+	for {
+		conn.pkg.Sequence = 0
+		data, err := conn.pkg.ReadPacket()
+		if err != nil {
+			return
+		}
+		if err = conn.dispatch(data); err != nil {
+			return
+		}
+	}
+}
+
+// the response of the latest handler matching sql, nil if no one matches
+func (s *Server) match(sql string, attrs []mysql.QueryAttribute) *Response {
+	s.Lock()
+	defer s.Unlock()
+	s.queries = append(s.queries, sql)
+	s.queryAttrs = append(s.queryAttrs, attrs)
+	for i := len(s.handlers) - 1; 0 <= i; i-- {
+		if s.handlers[i].pattern.MatchString(sql) {
+			return s.handlers[i].resp
+		}
+	}
+	return nil
+}
+
+//the conn whose data read ahead is read first
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+type conn struct {
+	server       *Server
+	c            net.Conn
+	pkg          *mysql.PacketIO
+	connectionId uint32
+	salt         []byte
+	status       uint16
+	capability   uint32 //the extra capabilities negotiated
+	stmtId       uint32
+}
+
+func (c *conn) writePacket(data []byte) error {
+	return c.pkg.WritePacket(data)
+}
+
+func (c *conn) handshake() error {
+	salt, err := mysql.RandomBuf(20)
+	if err != nil {
+		return err
+	}
+	c.salt = salt
+	capability := mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_LONG_FLAG |
+		mysql.CLIENT_CONNECT_WITH_DB | mysql.CLIENT_PROTOCOL_41 |
+		mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION
+	if len(c.server.AuthPlugin) != 0 {
+		capability |= mysql.CLIENT_PLUGIN_AUTH
+	}
+	if c.server.TLSConfig != nil {
+		capability |= mysql.CLIENT_SSL
+	}
+	capability |= c.server.Capability
+
+	data := make([]byte, 4, 128)
+	data = append(data, 10)
+	data = append(data, c.server.Version...)
+	data = append(data, 0)
+	data = append(data, mysql.Uint32ToBytes(c.connectionId)...)
+	data = append(data, c.salt[0:8]...)
+	data = append(data, 0)
+	data = append(data, byte(capability), byte(capability>>8))
+	data = append(data, uint8(mysql.DEFAULT_COLLATION_ID))
+	data = append(data, byte(c.status), byte(c.status>>8))
+	data = append(data, byte(capability>>16), byte(capability>>24))
+	data = append(data, 0x15)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, c.salt[8:]...)
+	data = append(data, 0)
+	if len(c.server.AuthPlugin) != 0 {
+		data = append(data, c.server.AuthPlugin...)
+		data = append(data, 0)
+	}
+	if err = c.writePacket(data); err != nil {
+		return err
+	}
+
+	data, err = c.pkg.ReadPacket()
+	if err != nil {
+		return err
+	}
+	//capability 4, max packet size 4, charset 1, reserved 23
+	pos := 4 + 4 + 1 + 23
+	if c.server.TLSConfig != nil {
+		//the ssl request, the handshake response follows over tls
+		if len(data) != pos || binary.LittleEndian.Uint32(data[:4])&mysql.CLIENT_SSL == 0 {
+			return c.writeError(mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "ssl is required"))
+		}
+		buffered := append([]byte(nil), c.pkg.Buffered()...)
+		tlsConn := tls.Server(&bufferedConn{c.c, io.MultiReader(bytes.NewReader(buffered), c.c)},
+			c.server.TLSConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			return err
+		}
+		sequence := c.pkg.Sequence
+		c.c = tlsConn
+		c.pkg = mysql.NewPacketIO(tlsConn)
+		c.pkg.Sequence = sequence
+		if data, err = c.pkg.ReadPacket(); err != nil {
+			return err
+		}
+	}
+	if len(data) <= pos {
+		return c.writeError(mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "bad handshake"))
+	}
+	c.capability = binary.LittleEndian.Uint32(data[:4]) & c.server.Capability
+	user := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
+	pos += len(user) + 1
+	var auth []byte
+	if pos < len(data) {
+		n := int(data[pos])
+		if len(data) < pos+1+n {
+			return c.writeError(mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "bad handshake"))
+		}
+		auth = data[pos+1 : pos+1+n]
+		pos += 1 + n
+	}
+
+	plugin := mysql.AUTH_NAME
+	if len(c.server.AuthPlugin) != 0 {
+		clientCapability := binary.LittleEndian.Uint32(data[:4])
+		if clientCapability&mysql.CLIENT_CONNECT_WITH_DB != 0 && pos < len(data) {
+			pos += bytes.IndexByte(data[pos:], 0) + 1
+		}
+		if clientCapability&mysql.CLIENT_PLUGIN_AUTH != 0 && pos < len(data) {
+			plugin = string(bytes.TrimRight(data[pos:], "\x00"))
+		}
+		//the client is asked to use the plugin of server
+		if plugin != c.server.AuthPlugin {
+			plugin = c.server.AuthPlugin
+			data = make([]byte, 4, 64)
+			data = append(data, mysql.EOF_HEADER)
+			data = append(data, plugin...)
+			data = append(data, 0)
+			data = append(data, c.salt...)
+			data = append(data, 0)
+			if err = c.writePacket(data); err != nil {
+				return err
+			}
+			if auth, err = c.pkg.ReadPacket(); err != nil {
+				return err
+			}
+		}
+	}
+
+	expected := mysql.CalcPassword(c.salt, []byte(c.server.Password))
+	if plugin == mysql.CACHING_SHA2_AUTH_NAME {
+		expected = mysql.CalcCachingSha2Password(c.salt, []byte(c.server.Password))
+	}
+	if user != c.server.User || !bytes.Equal(auth, expected) {
+		err = mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, user, "127.0.0.1", "Yes")
+		c.writeError(err)
+		return err
+	}
+	if plugin == mysql.CACHING_SHA2_AUTH_NAME {
+		//fast authentication succeeds
+		if err = c.writePacket([]byte{0, 0, 0, 0, 0x01, 0x03}); err != nil {
+			return err
+		}
+	}
+	return c.writeOK(nil)
+}
+
+func (c *conn) dispatch(data []byte) error {
+	cmd := data[0]
+	data = data[1:]
+	switch cmd {
+	case mysql.COM_QUIT:
+		return fmt.Errorf("quit")
+	case mysql.COM_PING:
+		return c.writeOK(nil)
+	case mysql.COM_INIT_DB:
+		c.server.Lock()
+		c.server.initDBs = append(c.server.initDBs, string(data))
+		c.server.Unlock()
+		return c.writeOK(nil)
+	case mysql.COM_QUERY:
+		var attrs []mysql.QueryAttribute
+		if c.capability&mysql.CLIENT_QUERY_ATTRIBUTES != 0 {
+			var err error
+			if attrs, data, err = mysql.ReadQueryAttributes(data); err != nil {
+				return c.writeError(err)
+			}
+		}
+		return c.handleQuery(string(data), attrs)
+	case mysql.COM_FIELD_LIST:
+		return c.writeEOF()
+	case mysql.COM_STMT_PREPARE:
+		return c.handlePrepare(string(data))
+	case mysql.COM_STMT_EXECUTE:
+		return c.writeOK(nil)
+	case mysql.COM_STMT_CLOSE, mysql.COM_STMT_SEND_LONG_DATA:
+		//no response
+		return nil
+	case mysql.COM_BINLOG_DUMP:
+		return c.dumpBinlog()
+	default:
+		return c.writeError(mysql.NewDefaultError(mysql.ER_UNKNOWN_COM_ERROR))
+	}
+}
+
+func (c *conn) handleQuery(sql string, attrs []mysql.QueryAttribute) error {
+	resp := c.server.match(sql, attrs)
+	if resp != nil && 0 < resp.Delay {
+		time.Sleep(resp.Delay)
+	}
+	if resp != nil && resp.Err != nil {
+		return c.writeError(resp.Err)
+	}
+	c.updateStatus(sql)
+	if resp == nil {
+		return c.writeOK(nil)
+	}
+	if resp.Names == nil {
+		return c.writeOK(resp)
+	}
+	return c.writeResultset(resp)
+}
+
+// the statement has a param for each ?, and no column, it is executed
+// with an ok packet
+func (c *conn) handlePrepare(sql string) error {
+	c.server.Lock()
+	c.server.queries = append(c.server.queries, sql)
+	c.server.queryAttrs = append(c.server.queryAttrs, nil)
+	c.server.Unlock()
+	params := strings.Count(sql, "?")
+	c.stmtId++
+	data := make([]byte, 4, 16)
+	data = append(data, mysql.OK_HEADER)
+	data = append(data, mysql.Uint32ToBytes(c.stmtId)...)
+	data = append(data, 0, 0, byte(params), byte(params>>8), 0, 0, 0)
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+	if params == 0 {
+		return nil
+	}
+	for i := 0; i < params; i++ {
+		field := &mysql.Field{Name: []byte("?")}
+		setFieldType(field, "")
+		if err := c.writePacket(append(data[:4], field.Dump()...)); err != nil {
+			return err
+		}
+	}
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF != 0 {
+		return nil
+	}
+	return c.writeEOF()
+}
+
+// send the binlog events until the conn is closed
+func (c *conn) dumpBinlog() error {
+	sent := 0
+	for {
+		c.server.Lock()
+		events := c.server.binlog[sent:]
+		closed := c.server.closed || c.server.down
+		c.server.Unlock()
+		if closed {
+			return fmt.Errorf("closed")
+		}
+		for _, event := range events {
+			data := make([]byte, 5, 5+len(event))
+			data[4] = mysql.OK_HEADER
+			if err := c.writePacket(append(data, event...)); err != nil {
+				return err
+			}
+			sent++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// track the transaction and autocommit status used by kingshard
+func (c *conn) updateStatus(sql string) {
+	sql = strings.ToLower(strings.Join(strings.Fields(sql), " "))
+	switch {
+	case sql == "begin" || strings.HasPrefix(sql, "start transaction"):
+		c.status |= mysql.SERVER_STATUS_IN_TRANS
+	case sql == "commit" || sql == "rollback":
+		c.status &= ^mysql.SERVER_STATUS_IN_TRANS
+	case sql == "set autocommit = 1" || sql == "set autocommit=1":
+		c.status |= mysql.SERVER_STATUS_AUTOCOMMIT
+	case sql == "set autocommit = 0" || sql == "set autocommit=0":
+		c.status &= ^mysql.SERVER_STATUS_AUTOCOMMIT
+	}
+}
+
+func (c *conn) writeOK(r *Response) error {
+	data := make([]byte, 4, 32)
+	data = append(data, mysql.OK_HEADER)
+	if r == nil {
+		r = &Response{}
+	}
+	data = append(data, mysql.PutLengthEncodedInt(r.AffectedRows)...)
+	data = append(data, mysql.PutLengthEncodedInt(r.InsertId)...)
+	data = append(data, byte(c.status), byte(c.status>>8))
+	data = append(data, byte(r.Warnings), byte(r.Warnings>>8))
+	if c.capability&mysql.CLIENT_SESSION_TRACK != 0 {
+		if len(r.Info) != 0 {
+			data = append(data, mysql.PutLengthEncodedString([]byte(r.Info))...)
+		}
+	} else {
+		data = append(data, r.Info...)
+	}
+	return c.writePacket(data)
+}
+
+func (c *conn) writeError(e error) error {
+	m, ok := e.(*mysql.SqlError)
+	if !ok {
+		m = mysql.NewError(mysql.ER_UNKNOWN_ERROR, e.Error())
+	}
+	data := make([]byte, 4, 16+len(m.Message))
+	data = append(data, mysql.ERR_HEADER)
+	data = append(data, byte(m.Code), byte(m.Code>>8))
+	data = append(data, '#')
+	data = append(data, m.State...)
+	data = append(data, m.Message...)
+	return c.writePacket(data)
+}
+
+func (c *conn) writeEOF() error {
+	data := make([]byte, 4, 9)
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF != 0 {
+		//an ok packet with the eof header
+		data = append(data, mysql.EOF_HEADER, 0, 0, byte(c.status), byte(c.status>>8), 0, 0)
+		return c.writePacket(data)
+	}
+	data = append(data, mysql.EOF_HEADER, 0, 0, byte(c.status), byte(c.status>>8))
+	return c.writePacket(data)
+}
+
+func (c *conn) writeResultset(r *Response) error {
+	data := make([]byte, 4, 512)
+	data = append(data, mysql.PutLengthEncodedInt(uint64(len(r.Names)))...)
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+	for i, name := range r.Names {
+		field := &mysql.Field{Name: []byte(name)}
+		if 0 < len(r.Rows) && i < len(r.Rows[0]) {
+			setFieldType(field, r.Rows[0][i])
+		} else {
+			setFieldType(field, "")
+		}
+		data = append(data[:4], field.Dump()...)
+		if err := c.writePacket(data); err != nil {
+			return err
+		}
+	}
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF == 0 {
+		if err := c.writeEOF(); err != nil {
+			return err
+		}
+	}
+
+	for i, row := range r.Rows {
+		if len(row) != len(r.Names) {
+			return fmt.Errorf("row %d has %d column not equal %d", i, len(row), len(r.Names))
+		}
+		data = data[:4]
+		for _, value := range row {
+			if value == nil {
+				data = append(data, 0xfb)
+				continue
+			}
+			data = append(data, mysql.PutLengthEncodedString([]byte(fmt.Sprint(value)))...)
+		}
+		if err := c.writePacket(data); err != nil {
+			return err
+		}
+	}
+	return c.writeEOF()
+}
+
+// the column type is decided by the value of the first row
+func setFieldType(field *mysql.Field, value interface{}) {
+	switch value.(type) {
+	case int8, int16, int32, int64, int:
+		field.Charset = 63
+		field.Type = mysql.MYSQL_TYPE_LONGLONG
+		field.Flag = mysql.BINARY_FLAG
+	case uint8, uint16, uint32, uint64, uint:
+		field.Charset = 63
+		field.Type = mysql.MYSQL_TYPE_LONGLONG
+		field.Flag = mysql.BINARY_FLAG | mysql.UNSIGNED_FLAG
+	case float32, float64:
+		field.Charset = 63
+		field.Type = mysql.MYSQL_TYPE_DOUBLE
+		field.Flag = mysql.BINARY_FLAG
+	default:
+		field.Charset = 33
+		field.Type = mysql.MYSQL_TYPE_VAR_STRING
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysqltest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestServer(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle(`^select`, &Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{1, "a"}, {2, nil}},
+	})
+	s.Handle(`^select \* from t1`, &Response{
+		Err: mysql.NewDefaultError(mysql.ER_NO_SUCH_TABLE, "db", "t1"),
+	})
+	s.Handle(`^insert`, &Response{AffectedRows: 2, InsertId: 10})
+
+	c := new(backend.Conn)
+	if err = c.Connect(s.Addr(), DefaultUser, "", "db"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r, err := c.Execute("select id, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := r.GetInt(1, 0); id != 2 || r.RowNumber() != 2 {
+		t.Fatal(r.Values)
+	}
+	if null, _ := r.IsNull(1, 1); !null {
+		t.Fatal(r.Values)
+	}
+	if _, err = c.Execute("select * from t1"); err == nil ||
+		err.(*mysql.SqlError).Code != mysql.ER_NO_SUCH_TABLE {
+		t.Fatal(err)
+	}
+	if r, err = c.Execute("insert into t values(1)"); err != nil ||
+		r.AffectedRows != 2 || r.InsertId != 10 {
+		t.Fatal(r, err)
+	}
+	if err = c.Begin(); err != nil || !c.IsInTransaction() {
+		t.Fatal(err)
+	}
+	if err = c.Commit(); err != nil || c.IsInTransaction() {
+		t.Fatal(err)
+	}
+	expect := []string{"select id, name from t", "select * from t1",
+		"insert into t values(1)", "begin", "commit"}
+	if !reflect.DeepEqual(s.Queries(), expect) {
+		t.Fatal(s.Queries())
+	}
+
+	//the connections are closed and refused when the server is down
+	s.SetDown(true)
+	if err = c.Ping(); err == nil {
+		t.Fatal("the server is down")
+	}
+	if err = c.ReConnect(); err == nil {
+		t.Fatal("the server is down")
+	}
+	s.SetDown(false)
+	if err = c.ReConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Password = "secret"
+
+	c := new(backend.Conn)
+	err = c.Connect(s.Addr(), DefaultUser, "wrong", "")
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_ACCESS_DENIED_ERROR {
+		t.Fatal(err)
+	}
+	if err = c.Connect(s.Addr(), DefaultUser, "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
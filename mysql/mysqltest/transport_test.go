@@ -0,0 +1,239 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysqltest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+)
+
+//a self signed cert of 127.0.0.1 for both server and client, written into
+//dir as cert.pem and key.pem
+func newTestCert(t *testing.T, dir string) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kingshard test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err = ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+//the conn negotiates tls with the client cert by the ssl request
+func TestServerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kingshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert := newTestCert(t, dir)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	if cert.Leaf == nil {
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		pool.AddCert(leaf)
+	}
+
+	s, err := NewTLSServer(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	//ssl is required by the server
+	c := new(backend.Conn)
+	if err = c.Connect(s.Addr(), DefaultUser, "", "db"); err == nil {
+		t.Fatal("connected without ssl")
+	}
+
+	//the client cert is required by the server
+	transport, err := backend.ParseTransport(config.NodeConfig{
+		TLS: config.NodeTLSConfig{Enable: true, CA: filepath.Join(dir, "cert.pem")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTransport(transport)
+	if err = c.Connect(s.Addr(), DefaultUser, "", "db"); err == nil {
+		t.Fatal("connected without client cert")
+	}
+
+	transport, err = backend.ParseTransport(config.NodeConfig{
+		TLS: config.NodeTLSConfig{
+			Enable: true,
+			CA:     filepath.Join(dir, "cert.pem"),
+			Cert:   filepath.Join(dir, "cert.pem"),
+			Key:    filepath.Join(dir, "key.pem"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTransport(transport)
+	if err = c.Connect(s.Addr(), DefaultUser, "", "db"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//run by the fake ssh of TestSSHTunnel, it forwards the stdio to the addr
+//of -W, and writes its args into the file KS_SSH_ARGS
+func TestSSHHelperProcess(t *testing.T) {
+	if len(os.Getenv("KS_SSH_ARGS")) == 0 {
+		return
+	}
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	ioutil.WriteFile(os.Getenv("KS_SSH_ARGS"), []byte(strings.Join(args, " ")), 0600)
+	var addr string
+	for i, arg := range args {
+		if arg == "-W" && i+1 < len(args) {
+			addr = args[i+1]
+		}
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		os.Exit(1)
+	}
+	go io.Copy(conn, os.Stdin)
+	io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+//the conn is forwarded by ssh -W through the jump host
+func TestSSHTunnel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kingshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fakeSSH := filepath.Join(dir, "ssh")
+	script := "#!/bin/sh\nexec " + os.Args[0] + " -test.run=TestSSHHelperProcess -- \"$@\"\n"
+	if err = ioutil.WriteFile(fakeSSH, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	argsFile := filepath.Join(dir, "args")
+	os.Setenv("KS_SSH_ARGS", argsFile)
+	defer os.Unsetenv("KS_SSH_ARGS")
+	old := backend.SSHCommand
+	backend.SSHCommand = fakeSSH
+	defer func() { backend.SSHCommand = old }()
+
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	transport, err := backend.ParseTransport(config.NodeConfig{
+		SSHTunnel: config.SSHTunnelConfig{Addr: "ks@jump:2222", KeyFile: "/etc/ks/id_rsa"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(backend.Conn)
+	c.SetTransport(transport)
+	c.SetTimeouts(backend.Timeouts{Connect: 3 * time.Second, Read: 3 * time.Second})
+	if err = c.Connect(s.Addr(), DefaultUser, "", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the read past its deadline fails instead of hanging
+	s.Handle(`^select sleep`, &Response{Names: []string{"sleep"}, Delay: 10 * time.Second})
+	c.SetTimeouts(backend.Timeouts{Read: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err = c.Execute("select sleep(10)"); err == nil {
+		t.Fatal("the read is not timed out")
+	}
+	if 5*time.Second < time.Since(start) {
+		t.Fatal("the read timed out late", time.Since(start))
+	}
+	c.Close()
+
+	args, err := ioutil.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{"-W " + s.Addr(), "-p 2222", "-l ks", "-i /etc/ks/id_rsa", "ConnectTimeout=3"} {
+		if !strings.Contains(string(args), arg) {
+			t.Fatal(string(args))
+		}
+	}
+	if !strings.HasSuffix(string(args), " jump") {
+		t.Fatal(string(args))
+	}
+
+	if _, err = backend.ParseTransport(config.NodeConfig{
+		SSHTunnel: config.SSHTunnelConfig{Addr: "ks@-oProxyCommand=x"},
+	}); err == nil {
+		t.Fatal("invalid ssh addr")
+	}
+}
@@ -19,31 +19,82 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
 const (
 	defaultReaderSize = 8 * 1024
+
+	//the packets batched are written once they exceed BatchFlushSize, so
+	//a huge result is not copied into a single buffer
+	BatchFlushSize = 64 * 1024
 )
 
 type PacketIO struct {
-	rb *bufio.Reader
-	wb io.Writer
+	rb   *bufio.Reader
+	conn net.Conn
+
+	//the max time a write can stall, such as the peer does not read, 0
+	//means no limit. A write timed out breaks the conn, the writes after
+	//it fail at once.
+	WriteTimeout time.Duration
+	writeErr     error
 
 	Sequence uint8
+
+	//the packets are recorded if it is not nil, see record.go
+	Recorder *ConnRecorder
 }
 
 func NewPacketIO(conn net.Conn) *PacketIO {
 	p := new(PacketIO)
 
 	p.rb = bufio.NewReaderSize(conn, defaultReaderSize)
-	p.wb = conn
+	p.conn = conn
 
 	p.Sequence = 0
 
 	return p
 }
 
+//write data to the conn in WriteTimeout
+func (p *PacketIO) write(data []byte) error {
+	if p.writeErr != nil {
+		return p.writeErr
+	}
+	if 0 < p.WriteTimeout {
+		p.conn.SetWriteDeadline(time.Now().Add(p.WriteTimeout))
+	}
+	if n, err := p.conn.Write(data); err != nil || n != len(data) {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			p.writeErr = ErrBadConn
+		}
+		return ErrBadConn
+	}
+	return nil
+}
+
+//Buffered returns the data read from the conn but not in a packet read,
+//such as the tls handshake following an ssl request
+func (p *PacketIO) Buffered() []byte {
+	data, _ := p.rb.Peek(p.rb.Buffered())
+	return data
+}
+
+//Peek blocks until there is data to read or an error, such as the conn
+//is closed by peer. The data is kept for ReadPacket.
+func (p *PacketIO) Peek() error {
+	_, err := p.rb.Peek(1)
+	return err
+}
+
 func (p *PacketIO) ReadPacket() ([]byte, error) {
+	return p.readPacket(false)
+}
+
+//the packet following a packet of MaxPayloadLen is continued, it is
+//empty if the payload is a multiple of MaxPayloadLen
+func (p *PacketIO) readPacket(continued bool) ([]byte, error) {
 	header := []byte{0, 0, 0, 0}
 
 	if _, err := io.ReadFull(p.rb, header); err != nil {
@@ -51,7 +102,7 @@ func (p *PacketIO) ReadPacket() ([]byte, error) {
 	}
 
 	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
-	if length < 1 {
+	if length < 1 && !continued {
 		return nil, fmt.Errorf("invalid payload length %d", length)
 	}
 
@@ -67,12 +118,15 @@ func (p *PacketIO) ReadPacket() ([]byte, error) {
 	if _, err := io.ReadFull(p.rb, data); err != nil {
 		return nil, ErrBadConn
 	} else {
+		if p.Recorder != nil {
+			p.Recorder.record(false, header, data)
+		}
 		if length < MaxPayloadLen {
 			return data, nil
 		}
 
 		var buf []byte
-		buf, err = p.ReadPacket()
+		buf, err = p.readPacket(true)
 		if err != nil {
 			return nil, ErrBadConn
 		} else {
@@ -93,15 +147,15 @@ func (p *PacketIO) WritePacket(data []byte) error {
 
 		data[3] = p.Sequence
 
-		if n, err := p.wb.Write(data[:4+MaxPayloadLen]); err != nil {
-			return ErrBadConn
-		} else if n != (4 + MaxPayloadLen) {
-			return ErrBadConn
-		} else {
-			p.Sequence++
-			length -= MaxPayloadLen
-			data = data[MaxPayloadLen:]
+		if p.Recorder != nil {
+			p.Recorder.record(true, data[:4], data[4:4+MaxPayloadLen])
+		}
+		if err := p.write(data[:4+MaxPayloadLen]); err != nil {
+			return err
 		}
+		p.Sequence++
+		length -= MaxPayloadLen
+		data = data[MaxPayloadLen:]
 	}
 
 	data[0] = byte(length)
@@ -109,26 +163,24 @@ func (p *PacketIO) WritePacket(data []byte) error {
 	data[2] = byte(length >> 16)
 	data[3] = p.Sequence
 
-	if n, err := p.wb.Write(data); err != nil {
-		return ErrBadConn
-	} else if n != len(data) {
-		return ErrBadConn
-	} else {
-		p.Sequence++
-		return nil
+	if p.Recorder != nil {
+		p.Recorder.record(true, data[:4], data[4:])
 	}
+	if err := p.write(data); err != nil {
+		return err
+	}
+	p.Sequence++
+	return nil
 }
 
+//WritePacketBatch appends data to total, and writes total if direct or
+//it exceeds BatchFlushSize. The total returned is the packets not written.
 func (p *PacketIO) WritePacketBatch(total, data []byte, direct bool) ([]byte, error) {
 	if data == nil {
 		//only flush the buffer
 		if direct == true {
-			n, err := p.wb.Write(total)
-			if err != nil {
-				return nil, ErrBadConn
-			}
-			if n != len(total) {
-				return nil, ErrBadConn
+			if err := p.write(total); err != nil {
+				return nil, err
 			}
 		}
 		return total, nil
@@ -142,6 +194,9 @@ func (p *PacketIO) WritePacketBatch(total, data []byte, direct bool) ([]byte, er
 		data[2] = 0xff
 
 		data[3] = p.Sequence
+		if p.Recorder != nil {
+			p.Recorder.record(true, data[:4], data[4:4+MaxPayloadLen])
+		}
 		total = append(total, data[:4+MaxPayloadLen]...)
 
 		p.Sequence++
@@ -154,14 +209,18 @@ func (p *PacketIO) WritePacketBatch(total, data []byte, direct bool) ([]byte, er
 	data[2] = byte(length >> 16)
 	data[3] = p.Sequence
 
+	if p.Recorder != nil {
+		p.Recorder.record(true, data[:4], data[4:])
+	}
 	total = append(total, data...)
 	p.Sequence++
 
-	if direct {
-		if n, err := p.wb.Write(total); err != nil {
-			return nil, ErrBadConn
-		} else if n != len(total) {
-			return nil, ErrBadConn
+	if direct || BatchFlushSize <= len(total) {
+		if err := p.write(total); err != nil {
+			return nil, err
+		}
+		if !direct {
+			return total[:0], nil
 		}
 	}
 	return total, nil
@@ -0,0 +1,78 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketIOBatchFlush(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	read := make(chan int64)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, client)
+		read <- n
+	}()
+
+	p := NewPacketIO(server)
+	var total []byte
+	var err error
+	data := make([]byte, 4+1000)
+	for i := 0; i < 100; i++ {
+		if total, err = p.WritePacketBatch(total, data, false); err != nil {
+			t.Fatal(err)
+		}
+		//the batch is written once it exceeds BatchFlushSize
+		if BatchFlushSize <= len(total) {
+			t.Fatal(len(total))
+		}
+	}
+	if _, err = p.WritePacketBatch(total, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if n := <-read; n != 100*int64(len(data)) {
+		t.Fatal(n)
+	}
+}
+
+func TestPacketIOWriteTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	//the client does not read
+	p := NewPacketIO(server)
+	p.WriteTimeout = 50 * time.Millisecond
+	start := time.Now()
+	if err := p.WritePacket(make([]byte, 4+10)); err != ErrBadConn {
+		t.Fatal(err)
+	}
+	if time.Second < time.Since(start) {
+		t.Fatal(time.Since(start))
+	}
+
+	//the conn is broken after a timeout, even if the client reads now
+	go io.Copy(ioutil.Discard, client)
+	if _, err := p.WritePacketBatch(nil, make([]byte, 4+10), true); err != ErrBadConn {
+		t.Fatal(err)
+	}
+}
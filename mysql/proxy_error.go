@@ -0,0 +1,215 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"github.com/flike/kingshard/core/errors"
+)
+
+//the error codes of the errors generated by kingshard, in the range
+//9000-9199 which is not used by mysql server. see kingshard_error_code.md
+const (
+	ER_KS_ERROR_FIRST uint16 = 9000
+
+	//the backend is not available
+	ER_KS_NO_MASTER_CONN  uint16 = 9001
+	ER_KS_NO_SLAVE_CONN   uint16 = 9002
+	ER_KS_NO_DEFAULT_NODE uint16 = 9003
+	ER_KS_NO_MASTER_DB    uint16 = 9004
+	ER_KS_NO_SLAVE_DB     uint16 = 9005
+	ER_KS_NO_DATABASE     uint16 = 9006
+	ER_KS_MASTER_DOWN     uint16 = 9007
+	ER_KS_SLAVE_DOWN      uint16 = 9008
+	ER_KS_DATABASE_CLOSE  uint16 = 9009
+	ER_KS_CONN_IS_NIL     uint16 = 9010
+	ER_KS_BAD_CONN        uint16 = 9011
+	ER_KS_NO_BACKUP_DB    uint16 = 9012
+	ER_KS_TX_CONN_LOST    uint16 = 9013
+	ER_KS_BACKEND_BUSY    uint16 = 9014
+
+	//the command is invalid or not supported
+	ER_KS_ADDRESS_NULL     uint16 = 9020
+	ER_KS_INVALID_ARGUMENT uint16 = 9021
+	ER_KS_INVALID_CHARSET  uint16 = 9022
+	ER_KS_CMD_UNSUPPORT    uint16 = 9023
+	ER_KS_SQL_NULL         uint16 = 9024
+
+	//the sql can not be routed
+	ER_KS_LOCATIONS_COUNT    uint16 = 9040
+	ER_KS_NO_CRITERIA        uint16 = 9041
+	ER_KS_NO_ROUTE_NODE      uint16 = 9042
+	ER_KS_RESULT_NIL         uint16 = 9043
+	ER_KS_SUM_COLUMN_TYPE    uint16 = 9044
+	ER_KS_SELECT_IN_INSERT   uint16 = 9045
+	ER_KS_INSERT_IN_MULTI    uint16 = 9046
+	ER_KS_UPDATE_IN_MULTI    uint16 = 9047
+	ER_KS_DELETE_IN_MULTI    uint16 = 9048
+	ER_KS_REPLACE_IN_MULTI   uint16 = 9049
+	ER_KS_EXEC_IN_MULTI      uint16 = 9050
+	ER_KS_TRANS_IN_MULTI     uint16 = 9051
+	ER_KS_NO_PLAN            uint16 = 9052
+	ER_KS_NO_PLAN_RULE       uint16 = 9053
+	ER_KS_UPDATE_KEY         uint16 = 9054
+	ER_KS_STMT_CONVERT       uint16 = 9055
+	ER_KS_EXPR_CONVERT       uint16 = 9056
+	ER_KS_CONN_NOT_EQUAL     uint16 = 9057
+	ER_KS_KEY_OUT_OF_RANGE   uint16 = 9058
+	ER_KS_MULTI_SHARD        uint16 = 9059
+	ER_KS_IR_NO_COLUMNS      uint16 = 9060
+	ER_KS_IR_NO_SHARDING_KEY uint16 = 9061
+	ER_KS_COLS_LEN_NOT_MATCH uint16 = 9062
+	ER_KS_DATE_ILLEGAL       uint16 = 9063
+	ER_KS_DATE_RANGE_ILLEGAL uint16 = 9064
+	ER_KS_DATE_RANGE_COUNT   uint16 = 9065
+	ER_KS_NULL_SHARD_KEY     uint16 = 9066
+	ER_KS_KEY_TYPE_MISMATCH  uint16 = 9067
+	ER_KS_NO_TENANT          uint16 = 9068
+	ER_KS_NOT_PINNED_SHARD   uint16 = 9069
+	ER_KS_SCHEMA_DRIFT       uint16 = 9070
+	ER_KS_VERSION_MISMATCH   uint16 = 9071
+
+	//the sql is rejected by the policy of kingshard
+	ER_KS_BLACKLIST_SQL     uint16 = 9080
+	ER_KS_HOT_KEY_THROTTLED uint16 = 9081
+	ER_KS_USER_CONN_QUOTA   uint16 = 9082
+	ER_KS_IP_BANNED         uint16 = 9083
+	ER_KS_IDLE_TX_KILLED    uint16 = 9084
+	ER_KS_OPERATION_DENIED  uint16 = 9085
+	ER_KS_BLACKHOLE_TABLE   uint16 = 9086
+	ER_KS_SET_DENIED        uint16 = 9087
+	ER_KS_SELECT_LIMITED    uint16 = 9088
+	ER_KS_COLUMN_MASKED     uint16 = 9089
+
+	//the admin command fails
+	ER_KS_SLAVE_EXIST         uint16 = 9100
+	ER_KS_SLAVE_NOT_EXIST     uint16 = 9101
+	ER_KS_BLACK_SQL_EXIST     uint16 = 9102
+	ER_KS_BLACK_SQL_NOT_EXIST uint16 = 9103
+	ER_KS_IP_NOT_BANNED       uint16 = 9104
+	ER_KS_FAULT_DISABLED      uint16 = 9105
+	ER_KS_FAULT_NOT_EXIST     uint16 = 9106
+
+	ER_KS_ERROR_LAST uint16 = 9199
+)
+
+//the SQLSTATE classes of kingshard errors, KS is an implementation-defined class
+const (
+	KS_STATE_BACKEND  = "KS001"
+	KS_STATE_COMMAND  = "KS002"
+	KS_STATE_ROUTE    = "KS003"
+	KS_STATE_REJECTED = "KS004"
+	KS_STATE_ADMIN    = "KS005"
+)
+
+//ProxyErrorCodes maps the errors of kingshard to their error codes
+var ProxyErrorCodes = map[error]uint16{
+	errors.ErrNoMasterConn:  ER_KS_NO_MASTER_CONN,
+	errors.ErrNoSlaveConn:   ER_KS_NO_SLAVE_CONN,
+	errors.ErrNoDefaultNode: ER_KS_NO_DEFAULT_NODE,
+	errors.ErrNoMasterDB:    ER_KS_NO_MASTER_DB,
+	errors.ErrNoSlaveDB:     ER_KS_NO_SLAVE_DB,
+	errors.ErrNoDatabase:    ER_KS_NO_DATABASE,
+	errors.ErrMasterDown:    ER_KS_MASTER_DOWN,
+	errors.ErrSlaveDown:     ER_KS_SLAVE_DOWN,
+	errors.ErrDatabaseClose: ER_KS_DATABASE_CLOSE,
+	errors.ErrConnIsNil:     ER_KS_CONN_IS_NIL,
+	errors.ErrBadConn:       ER_KS_BAD_CONN,
+	ErrBadConn:              ER_KS_BAD_CONN,
+	errors.ErrNoBackupDB:    ER_KS_NO_BACKUP_DB,
+	errors.ErrBackendBusy:   ER_KS_BACKEND_BUSY,
+
+	errors.ErrAddressNull:     ER_KS_ADDRESS_NULL,
+	errors.ErrInvalidArgument: ER_KS_INVALID_ARGUMENT,
+	errors.ErrInvalidCharset:  ER_KS_INVALID_CHARSET,
+	errors.ErrCmdUnsupport:    ER_KS_CMD_UNSUPPORT,
+	errors.ErrSQLNULL:         ER_KS_SQL_NULL,
+
+	errors.ErrLocationsCount:   ER_KS_LOCATIONS_COUNT,
+	errors.ErrNoCriteria:       ER_KS_NO_CRITERIA,
+	errors.ErrNoRouteNode:      ER_KS_NO_ROUTE_NODE,
+	errors.ErrResultNil:        ER_KS_RESULT_NIL,
+	errors.ErrSumColumnType:    ER_KS_SUM_COLUMN_TYPE,
+	errors.ErrSelectInInsert:   ER_KS_SELECT_IN_INSERT,
+	errors.ErrInsertInMulti:    ER_KS_INSERT_IN_MULTI,
+	errors.ErrUpdateInMulti:    ER_KS_UPDATE_IN_MULTI,
+	errors.ErrDeleteInMulti:    ER_KS_DELETE_IN_MULTI,
+	errors.ErrReplaceInMulti:   ER_KS_REPLACE_IN_MULTI,
+	errors.ErrExecInMulti:      ER_KS_EXEC_IN_MULTI,
+	errors.ErrTransInMulti:     ER_KS_TRANS_IN_MULTI,
+	errors.ErrNoPlan:           ER_KS_NO_PLAN,
+	errors.ErrNoPlanRule:       ER_KS_NO_PLAN_RULE,
+	errors.ErrUpdateKey:        ER_KS_UPDATE_KEY,
+	errors.ErrStmtConvert:      ER_KS_STMT_CONVERT,
+	errors.ErrExprConvert:      ER_KS_EXPR_CONVERT,
+	errors.ErrConnNotEqual:     ER_KS_CONN_NOT_EQUAL,
+	errors.ErrKeyOutOfRange:    ER_KS_KEY_OUT_OF_RANGE,
+	errors.ErrMultiShard:       ER_KS_MULTI_SHARD,
+	errors.ErrIRNoColumns:      ER_KS_IR_NO_COLUMNS,
+	errors.ErrIRNoShardingKey:  ER_KS_IR_NO_SHARDING_KEY,
+	errors.ErrColsLenNotMatch:  ER_KS_COLS_LEN_NOT_MATCH,
+	errors.ErrDateIllegal:      ER_KS_DATE_ILLEGAL,
+	errors.ErrDateRangeIllegal: ER_KS_DATE_RANGE_ILLEGAL,
+	errors.ErrDateRangeCount:   ER_KS_DATE_RANGE_COUNT,
+	errors.ErrNullShardKey:     ER_KS_NULL_SHARD_KEY,
+	errors.ErrNoTenant:         ER_KS_NO_TENANT,
+	errors.ErrNotPinnedShard:   ER_KS_NOT_PINNED_SHARD,
+	errors.ErrSchemaDrift:      ER_KS_SCHEMA_DRIFT,
+
+	errors.ErrHotKeyThrottled: ER_KS_HOT_KEY_THROTTLED,
+	errors.ErrUserConnQuota:   ER_KS_USER_CONN_QUOTA,
+	errors.ErrIPBanned:        ER_KS_IP_BANNED,
+	errors.ErrIdleTxKilled:    ER_KS_IDLE_TX_KILLED,
+
+	errors.ErrSlaveExist:       ER_KS_SLAVE_EXIST,
+	errors.ErrSlaveNotExist:    ER_KS_SLAVE_NOT_EXIST,
+	errors.ErrBlackSqlExist:    ER_KS_BLACK_SQL_EXIST,
+	errors.ErrBlackSqlNotExist: ER_KS_BLACK_SQL_NOT_EXIST,
+	errors.ErrIPNotBanned:      ER_KS_IP_NOT_BANNED,
+	errors.ErrFaultDisabled:    ER_KS_FAULT_DISABLED,
+	errors.ErrFaultNotExist:    ER_KS_FAULT_NOT_EXIST,
+}
+
+//the SQLSTATE of a kingshard error code
+func proxyErrorState(code uint16) string {
+	switch {
+	case code < ER_KS_ADDRESS_NULL:
+		return KS_STATE_BACKEND
+	case code < ER_KS_LOCATIONS_COUNT:
+		return KS_STATE_COMMAND
+	case code < ER_KS_BLACKLIST_SQL:
+		return KS_STATE_ROUTE
+	case code < ER_KS_SLAVE_EXIST:
+		return KS_STATE_REJECTED
+	}
+	return KS_STATE_ADMIN
+}
+
+func init() {
+	for code := ER_KS_ERROR_FIRST; code <= ER_KS_ERROR_LAST; code++ {
+		MySQLState[code] = proxyErrorState(code)
+	}
+}
+
+//NewProxyError convert err to the SqlError sent to client, the errors of
+//kingshard have their own codes, and the others are ER_UNKNOWN_ERROR.
+func NewProxyError(err error) *SqlError {
+	if e, ok := err.(*SqlError); ok {
+		return e
+	}
+	if code, ok := ProxyErrorCodes[err]; ok {
+		return NewError(code, err.Error())
+	}
+	return NewError(ER_UNKNOWN_ERROR, err.Error())
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/flike/kingshard/core/errors"
+)
+
+func TestProxyError(t *testing.T) {
+	e := NewProxyError(errors.ErrNoCriteria)
+	if e.Code != ER_KS_NO_CRITERIA || e.State != KS_STATE_ROUTE || e.Message != errors.ErrNoCriteria.Error() {
+		t.Fatal(e)
+	}
+	e = NewProxyError(errors.ErrHotKeyThrottled)
+	if e.Code != ER_KS_HOT_KEY_THROTTLED || e.State != KS_STATE_REJECTED {
+		t.Fatal(e)
+	}
+	e = NewError(ER_KS_BLACKLIST_SQL, "sql in blacklist.")
+	if e.State != KS_STATE_REJECTED {
+		t.Fatal(e)
+	}
+
+	//the codes are distinct and in the range of kingshard
+	codes := make(map[uint16]error)
+	for err, code := range ProxyErrorCodes {
+		if code <= ER_KS_ERROR_FIRST || ER_KS_ERROR_LAST < code {
+			t.Fatal(err, code)
+		}
+		if other, ok := codes[code]; ok && other.Error() != err.Error() {
+			t.Fatal(err, other, code)
+		}
+		codes[code] = err
+	}
+
+	if e = NewProxyError(fmt.Errorf("other")); e.Code != ER_UNKNOWN_ERROR {
+		t.Fatal(e)
+	}
+	backend := NewDefaultError(ER_NO_DB_ERROR)
+	if NewProxyError(backend) != backend {
+		t.Fatal("the mysql error should be kept")
+	}
+}
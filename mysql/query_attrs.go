@@ -0,0 +1,249 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+//QueryAttribute is an attribute of a query, sent with COM_QUERY or
+//COM_STMT_EXECUTE by the clients with CLIENT_QUERY_ATTRIBUTES, such as
+//the trace id of the query. The params of COM_STMT_EXECUTE are in the
+//same format without name.
+type QueryAttribute struct {
+	Name  string
+	Type  byte
+	Flag  byte   //0x80 if unsigned
+	Value []byte //in the binary protocol, nil if NULL
+}
+
+//String returns the value in text, empty if NULL
+func (a *QueryAttribute) String() string {
+	v := a.Value
+	if v == nil {
+		return ""
+	}
+	unsigned := a.Flag&0x80 != 0
+	switch a.Type {
+	case MYSQL_TYPE_TINY:
+		if unsigned {
+			return strconv.FormatUint(uint64(v[0]), 10)
+		}
+		return strconv.FormatInt(int64(int8(v[0])), 10)
+	case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+		if unsigned {
+			return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(v)), 10)
+		}
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(v))), 10)
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG:
+		if unsigned {
+			return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(v)), 10)
+		}
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(v))), 10)
+	case MYSQL_TYPE_LONGLONG:
+		if unsigned {
+			return strconv.FormatUint(binary.LittleEndian.Uint64(v), 10)
+		}
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(v)), 10)
+	case MYSQL_TYPE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(v))), 'g', -1, 32)
+	case MYSQL_TYPE_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(v)), 'g', -1, 64)
+	}
+	s, _, _, _ := LengthEnodedString(v)
+	return string(s)
+}
+
+//ReadQueryAttributes reads the query attributes before the sql of
+//COM_QUERY and returns them with the sql: parameter_count,
+//parameter_set_count, and if there is any attribute, null_bitmap,
+//new_params_bind_flag, the types and names, and the values
+func ReadQueryAttributes(data []byte) ([]QueryAttribute, []byte, error) {
+	count, pos, err := ReadLengthEncodedInt(data, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	//parameter_set_count, always 1
+	_, n, err := ReadLengthEncodedInt(data, pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	pos += n
+	if count == 0 {
+		return nil, data[pos:], nil
+	}
+	if uint64(len(data)) < count {
+		return nil, nil, ErrMalformPacket
+	}
+
+	nullBitmapLen := (int(count) + 7) >> 3
+	if len(data) < pos+nullBitmapLen+1 {
+		return nil, nil, ErrMalformPacket
+	}
+	nullBitmap := data[pos : pos+nullBitmapLen]
+	pos += nullBitmapLen
+	//new_params_bind_flag, always 1
+	if data[pos] != 1 {
+		return nil, nil, ErrMalformPacket
+	}
+	pos++
+
+	attrs, n, err := ReadParams(data[pos:], int(count), nullBitmap, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attrs, data[pos+n:], nil
+}
+
+//ReadParams reads count params of COM_STMT_EXECUTE or query attributes
+//after new_params_bind_flag: the type of each param, followed by its name
+//if withNames, and the values of the params not NULL in nullBitmap. It
+//returns the params and the number of bytes read.
+func ReadParams(data []byte, count int, nullBitmap []byte, withNames bool) ([]QueryAttribute, int, error) {
+	if len(nullBitmap) < (count+7)>>3 {
+		return nil, 0, ErrMalformPacket
+	}
+	params := make([]QueryAttribute, count)
+	pos := 0
+	for i := range params {
+		if len(data) < pos+2 {
+			return nil, 0, ErrMalformPacket
+		}
+		params[i].Type = data[pos]
+		params[i].Flag = data[pos+1]
+		pos += 2
+		if !withNames {
+			continue
+		}
+		n, err := skipLengthEncodedString(data, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, _, _ := LengthEncodedInt(data[pos:])
+		params[i].Name = string(data[pos+n-int(name) : pos+n])
+		pos += n
+	}
+
+	for i := range params {
+		if nullBitmap[i>>3]&(1<<(uint(i)%8)) > 0 || params[i].Type == MYSQL_TYPE_NULL {
+			continue
+		}
+		n, err := binaryValueLen(data, pos, params[i].Type)
+		if err != nil {
+			return nil, 0, err
+		}
+		params[i].Value = data[pos : pos+n]
+		pos += n
+	}
+	return params, pos, nil
+}
+
+//AppendQueryAttributes appends the query attributes before the sql of
+//COM_QUERY, for the server with CLIENT_QUERY_ATTRIBUTES
+func AppendQueryAttributes(data []byte, attrs []QueryAttribute) []byte {
+	data = append(data, PutLengthEncodedInt(uint64(len(attrs)))...)
+	//parameter_set_count, always 1
+	data = append(data, 1)
+	if len(attrs) == 0 {
+		return data
+	}
+
+	nullBitmap := make([]byte, (len(attrs)+7)>>3)
+	for i := range attrs {
+		if attrs[i].Value == nil {
+			nullBitmap[i>>3] |= 1 << (uint(i) % 8)
+		}
+	}
+	data = append(data, nullBitmap...)
+	//new_params_bind_flag, always 1
+	data = append(data, 1)
+	for i := range attrs {
+		data = AppendParamType(data, &attrs[i], true)
+	}
+	for i := range attrs {
+		data = append(data, attrs[i].Value...)
+	}
+	return data
+}
+
+//AppendParamType appends the type of param, followed by its name if
+//withNames
+func AppendParamType(data []byte, param *QueryAttribute, withNames bool) []byte {
+	data = append(data, param.Type, param.Flag)
+	if withNames {
+		data = append(data, PutLengthEncodedString([]byte(param.Name))...)
+	}
+	return data
+}
+
+//the length of the value at pos in the binary protocol
+func binaryValueLen(data []byte, pos int, tp byte) (int, error) {
+	var n int
+	switch tp {
+	case MYSQL_TYPE_NULL:
+		return 0, nil
+	case MYSQL_TYPE_TINY:
+		n = 1
+	case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+		n = 2
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT:
+		n = 4
+	case MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE:
+		n = 8
+	default:
+		//the strings, decimals and the temporal types are length encoded
+		return skipLengthEncodedString(data, pos)
+	}
+	if len(data) < pos+n {
+		return 0, ErrMalformPacket
+	}
+	return n, nil
+}
+
+//ReadLengthEncodedInt returns the length encoded int at pos and its
+//length, a truncated packet is malformed
+func ReadLengthEncodedInt(data []byte, pos int) (uint64, int, error) {
+	if len(data) <= pos {
+		return 0, 0, ErrMalformPacket
+	}
+	size := 1
+	switch data[pos] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	}
+	if len(data) < pos+size {
+		return 0, 0, ErrMalformPacket
+	}
+	num, _, n := LengthEncodedInt(data[pos:])
+	return num, n, nil
+}
+
+//the length of the length encoded string at pos
+func skipLengthEncodedString(data []byte, pos int) (int, error) {
+	num, n, err := ReadLengthEncodedInt(data, pos)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(data)-pos-n) < num {
+		return 0, ErrMalformPacket
+	}
+	return n + int(num), nil
+}
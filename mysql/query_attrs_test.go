@@ -0,0 +1,54 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQueryAttributes(t *testing.T) {
+	attrs := []QueryAttribute{
+		{Name: "trace", Type: MYSQL_TYPE_VAR_STRING, Value: PutLengthEncodedString([]byte("abc"))},
+		{Name: "n", Type: MYSQL_TYPE_NULL},
+		{Name: "i", Type: MYSQL_TYPE_LONG, Value: Uint32ToBytes(uint32(0xffffffff))},
+		{Name: "u", Type: MYSQL_TYPE_TINY, Flag: 0x80, Value: []byte{0xff}},
+		{Name: "d", Type: MYSQL_TYPE_DOUBLE, Value: []byte{0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+	}
+	data := AppendQueryAttributes(nil, attrs)
+	data = append(data, "select 1"...)
+	read, sql, err := ReadQueryAttributes(data)
+	if err != nil || string(sql) != "select 1" || len(read) != len(attrs) {
+		t.Fatal(err, string(sql), read)
+	}
+	expect := []string{"abc", "", "-1", "255", "1.5"}
+	for i := range read {
+		if read[i].Name != attrs[i].Name || read[i].Type != attrs[i].Type ||
+			!bytes.Equal(read[i].Value, attrs[i].Value) || read[i].String() != expect[i] {
+			t.Fatal(i, read[i], read[i].String())
+		}
+	}
+
+	//no attribute
+	if read, sql, err = ReadQueryAttributes([]byte("\x00\x01select 1")); err != nil || read != nil || string(sql) != "select 1" {
+		t.Fatal(err, read, string(sql))
+	}
+	//truncated
+	for i := 0; i < len(data)-len("select 1"); i++ {
+		if _, _, err = ReadQueryAttributes(data[:i]); err == nil {
+			t.Fatal(i)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//the size of the ssl request, which is the head of the handshake response
+const sslRequestSize = 4 + 4 + 1 + 23
+
+//Recorder records the packets of conns in lines, for the protocol tests
+//and the replay:
+//
+//	<conn> open <c|s> <label>     the local side of conn is client or server
+//	<conn> <c|s> <packet in hex>  a packet sent by client or server
+//
+//The packets are recorded with their headers, a big packet is split into
+//packets as on the wire. The auth data of the clients is zeroed, the
+//other data, such as the queries and rows, are recorded as they are.
+type Recorder struct {
+	sync.Mutex
+
+	w     io.Writer
+	conns int
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+//NewConn returns the recorder of a conn, client tells the local side of
+//the conn is the client, such as a conn to the backend.
+func (r *Recorder) NewConn(label string, client bool) *ConnRecorder {
+	r.Lock()
+	r.conns++
+	c := &ConnRecorder{r: r, id: r.conns, client: client}
+	r.writeLine(c.id, "open", fmt.Sprintf("%s %s", side(client), label))
+	r.Unlock()
+	return c
+}
+
+//Close closes the writer if it is a closer, the packets after it are not
+//recorded
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	w := r.w
+	r.w = nil
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//with the lock held
+func (r *Recorder) writeLine(id int, kind string, data string) {
+	if r.w != nil {
+		fmt.Fprintf(r.w, "%d %s %s\n", id, kind, data)
+	}
+}
+
+func side(client bool) string {
+	if client {
+		return "c"
+	}
+	return "s"
+}
+
+//ConnRecorder records the packets of a conn, it is used by one PacketIO
+type ConnRecorder struct {
+	r      *Recorder
+	id     int
+	client bool
+	phase  authPhase
+}
+
+//record a packet sent or received by the local side
+func (c *ConnRecorder) record(sent bool, header []byte, payload []byte) {
+	fromClient := sent == c.client
+	packet := make([]byte, 0, len(header)+len(payload))
+	packet = append(append(packet, header...), payload...)
+	switch c.phase.next(fromClient, header[3], payload) {
+	case handshakeResponsePacket:
+		zeroHandshakeAuth(packet[4:])
+	case authDataPacket:
+		zeroBytes(packet[4:])
+	case changeUserPacket:
+		zeroChangeUserAuth(packet[4:])
+	}
+	c.r.Lock()
+	c.r.writeLine(c.id, side(fromClient), hex.EncodeToString(packet))
+	c.r.Unlock()
+}
+
+//the kinds of packets told by authPhase
+const (
+	commandPacket           = iota //compared in the replay
+	authPacket                     //in the auth phase, not compared in the replay
+	sslRequestPacket               //the tls follows it, which is not replayed
+	handshakeResponsePacket        //the auth response in it is zeroed
+	authDataPacket                 //zeroed, such as the auth switch response
+	changeUserPacket               //the auth response in it is zeroed
+)
+
+//authPhase tells the packets of the auth phase of a conn, their auth data
+//is zeroed in the records, and they are not compared in the replay as the
+//salt, connection id and auth data change in every conn. The auth phase
+//ends by the OK or ERR of server, and starts again by COM_CHANGE_USER.
+type authPhase struct {
+	done      bool
+	responded bool //the client has sent the handshake response
+}
+
+func (a *authPhase) next(fromClient bool, sequence uint8, payload []byte) int {
+	if a.done {
+		if fromClient && sequence == 0 && 0 < len(payload) && payload[0] == COM_CHANGE_USER {
+			a.done = false
+			return changeUserPacket
+		}
+		return commandPacket
+	}
+	switch {
+	case !fromClient:
+		if 0 < len(payload) && (payload[0] == OK_HEADER || payload[0] == ERR_HEADER) {
+			a.done = true
+		}
+		return authPacket
+	case a.responded:
+		return authDataPacket
+	case len(payload) == sslRequestSize:
+		return sslRequestPacket
+	default:
+		a.responded = true
+		return handshakeResponsePacket
+	}
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+//zero the auth response after the user name, whose length is encoded by
+//the capability of client
+func zeroHandshakeAuth(payload []byte) {
+	if len(payload) < sslRequestSize {
+		return
+	}
+	capability := binary.LittleEndian.Uint32(payload)
+	pos := sslRequestSize
+	end := bytes.IndexByte(payload[pos:], 0)
+	if end == -1 {
+		return
+	}
+	pos += end + 1
+	if len(payload) <= pos {
+		return
+	}
+	var n int
+	switch {
+	case capability&CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		num, _, size := LengthEncodedInt(payload[pos:])
+		pos += size
+		n = int(num)
+	case capability&CLIENT_SECURE_CONNECTION != 0:
+		n = int(payload[pos])
+		pos++
+	default:
+		if n = bytes.IndexByte(payload[pos:], 0); n == -1 {
+			n = len(payload) - pos
+		}
+	}
+	if len(payload) < pos+n {
+		n = len(payload) - pos
+	}
+	zeroBytes(payload[pos : pos+n])
+}
+
+//COM_CHANGE_USER, user[00], auth length, auth response ...
+func zeroChangeUserAuth(payload []byte) {
+	end := bytes.IndexByte(payload[1:], 0)
+	if end == -1 {
+		return
+	}
+	pos := 1 + end + 1
+	if len(payload) <= pos {
+		return
+	}
+	n := int(payload[pos])
+	pos++
+	if len(payload) < pos+n {
+		n = len(payload) - pos
+	}
+	zeroBytes(payload[pos : pos+n])
+}
+
+//RecordedConn is the packets of a conn read from the records
+type RecordedConn struct {
+	Label   string
+	Client  bool //the local side of the conn was the client
+	Packets []RecordedPacket
+}
+
+type RecordedPacket struct {
+	FromClient bool
+	Data       []byte //the header and payload
+}
+
+//ReadRecordedConns reads the conns recorded by Recorder, in the order
+//they were opened
+func ReadRecordedConns(r io.Reader) ([]*RecordedConn, error) {
+	var conns []*RecordedConn
+	ids := make(map[int]*RecordedConn)
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimSpace(line); len(line) != 0 {
+			if e := readRecordLine(line, ids, &conns); e != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, e)
+			}
+		}
+		if err == io.EOF {
+			return conns, nil
+		}
+	}
+}
+
+func readRecordLine(line string, ids map[int]*RecordedConn, conns *[]*RecordedConn) error {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid record %.64s", line)
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return err
+	}
+	if fields[1] == "open" {
+		label := strings.SplitN(fields[2], " ", 2)
+		c := &RecordedConn{Client: label[0] == "c"}
+		if 1 < len(label) {
+			c.Label = label[1]
+		}
+		ids[id] = c
+		*conns = append(*conns, c)
+		return nil
+	}
+	c, ok := ids[id]
+	if !ok {
+		return fmt.Errorf("conn %d is not opened", id)
+	}
+	data, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 {
+		return fmt.Errorf("packet of %d bytes", len(data))
+	}
+	c.Packets = append(c.Packets, RecordedPacket{FromClient: fields[1] == "c", Data: data})
+	return nil
+}
+
+//Replay sends the packets recorded of the side asClient to conn, and
+//compares the packets of the other side read from conn with the records.
+//The packets of the auth phase are read but not compared, and the auth
+//data zeroed is sent as it is, so the user replaying a client should have
+//no password. The conns over tls can not be replayed.
+func (c *RecordedConn) Replay(conn net.Conn, asClient bool) error {
+	var phase authPhase
+	r := bufio.NewReaderSize(conn, defaultReaderSize)
+	for i, p := range c.Packets {
+		kind := phase.next(p.FromClient, p.Data[3], p.Data[4:])
+		if kind == sslRequestPacket {
+			return fmt.Errorf("conn %s is over tls", c.Label)
+		}
+		if p.FromClient == asClient {
+			if _, err := conn.Write(p.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := readRawPacket(r)
+		if err != nil {
+			return fmt.Errorf("packet %d of %s: %v", i, c.Label, err)
+		}
+		if kind == commandPacket && !bytes.Equal(data, p.Data) {
+			return fmt.Errorf("packet %d of %s: expect %s, got %s",
+				i, c.Label, hexHead(p.Data), hexHead(data))
+		}
+	}
+	return nil
+}
+
+//the header and payload of a packet, the packets of a big packet are
+//read one by one as they are recorded
+func readRawPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	data := make([]byte, 4+length)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+//the head of data in hex for the errors
+func hexHead(data []byte) string {
+	const max = 64
+	if len(data) <= max {
+		return hex.EncodeToString(data)
+	}
+	return fmt.Sprintf("%s...(%d bytes)", hex.EncodeToString(data[:max]), len(data))
+}
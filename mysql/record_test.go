@@ -0,0 +1,156 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+//a handshake with an auth switch and a query, recorded by both sides
+func recordExchange(t *testing.T) []*RecordedConn {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sp := NewPacketIO(server)
+	sp.Recorder = r.NewConn("server", false)
+	cp := NewPacketIO(client)
+	cp.Recorder = r.NewConn("client", true)
+
+	done := make(chan error, 1)
+	go func() {
+		packets := [][]byte{
+			append([]byte{10}, "5.7.0\x00salt"...),
+			append([]byte{0xfe}, AUTH_NAME+"\x00salt\x00"...),
+			{OK_HEADER, 0, 0, 2, 0, 0, 0},
+			{3, 'a', 'b', 'c'},
+		}
+		for i, p := range packets {
+			if i == 3 {
+				sp.Sequence = 0
+			}
+			if i != 0 {
+				if _, err := sp.ReadPacket(); err != nil {
+					done <- err
+					return
+				}
+			}
+			if err := sp.WritePacket(append(make([]byte, 4), p...)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	response := make([]byte, 4+4+4+1+23)
+	capability := CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_CONNECT_WITH_DB
+	response[4] = byte(capability)
+	response[5] = byte(capability >> 8)
+	response = append(response, "root\x00"...)
+	response = append(response, 20)
+	response = append(response, bytes.Repeat([]byte{0x11}, 20)...)
+	response = append(response, "kingshard\x00"...)
+	packets := [][]byte{response, append(make([]byte, 4), bytes.Repeat([]byte{0x22}, 20)...),
+		append([]byte{0, 0, 0, 0, COM_QUERY}, "select 1"...)}
+	for i, p := range packets {
+		if _, err := cp.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			cp.Sequence = 0
+		}
+		if err := cp.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cp.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	conns, err := ReadRecordedConns(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conns
+}
+
+func TestRecorder(t *testing.T) {
+	conns := recordExchange(t)
+	if len(conns) != 2 || conns[0].Label != "server" || conns[0].Client ||
+		conns[1].Label != "client" || !conns[1].Client {
+		t.Fatal(conns)
+	}
+	//greeting, response, auth switch, switch response, OK, query, row
+	packets := conns[0].Packets
+	if len(packets) != 7 || len(conns[1].Packets) != 7 {
+		t.Fatal(len(packets), len(conns[1].Packets))
+	}
+	for i, p := range packets {
+		if p.FromClient != (i%2 == 1) || !bytes.Equal(p.Data, conns[1].Packets[i].Data) {
+			t.Fatal(i, p)
+		}
+	}
+
+	//the auth data are zeroed, the others are kept
+	auth := append([]byte("root\x00\x14"), make([]byte, 20)...)
+	if !bytes.Contains(packets[1].Data, append(auth, "kingshard\x00"...)) {
+		t.Fatalf("%x", packets[1].Data)
+	}
+	if !bytes.Equal(packets[3].Data[4:], make([]byte, 20)) {
+		t.Fatalf("%x", packets[3].Data)
+	}
+	if !bytes.Equal(packets[5].Data[4:], append([]byte{COM_QUERY}, "select 1"...)) ||
+		!bytes.Equal(packets[6].Data, []byte{4, 0, 0, 1, 3, 'a', 'b', 'c'}) {
+		t.Fatal(packets[5], packets[6])
+	}
+}
+
+func TestReplay(t *testing.T) {
+	conns := recordExchange(t)
+	replay := func(client *RecordedConn, server *RecordedConn) error {
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		go server.Replay(s, false)
+		return client.Replay(c, true)
+	}
+
+	if err := replay(conns[1], conns[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	//the packets of auth phase are not compared
+	changed := &RecordedConn{Label: "changed", Client: true}
+	changed.Packets = append(changed.Packets, conns[1].Packets...)
+	changed.Packets[0] = RecordedPacket{Data: append([]byte(nil), conns[1].Packets[0].Data...)}
+	changed.Packets[0].Data[5] = '8'
+	if err := replay(changed, conns[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	changed.Packets[6] = RecordedPacket{Data: []byte{4, 0, 0, 1, 3, 'a', 'b', 'd'}}
+	err := replay(changed, conns[0])
+	if err == nil || !strings.Contains(err.Error(), "packet 6 of changed") {
+		t.Fatal(err)
+	}
+}
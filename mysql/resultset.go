@@ -62,8 +62,10 @@ func (p RowData) ParseText(f []*Field) ([]interface{}, error) {
 				} else {
 					data[i], err = strconv.ParseInt(string(v), 10, 64)
 				}
-			case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_NEWDECIMAL:
+			case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
 				data[i], err = strconv.ParseFloat(string(v), 64)
+			case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+				data[i] = Decimal(v)
 			case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING,
 				MYSQL_TYPE_STRING, MYSQL_TYPE_DATETIME,
 				MYSQL_TYPE_DATE, MYSQL_TYPE_TIME, MYSQL_TYPE_TIMESTAMP:
@@ -156,7 +158,21 @@ func (p RowData) ParseBinary(f []*Field) ([]interface{}, error) {
 			pos += 8
 			continue
 
-		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_VARCHAR,
+		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+			v, isNull, n, err = LengthEnodedString(p[pos:])
+			pos += n
+			if err != nil {
+				return nil, err
+			}
+
+			if !isNull {
+				data[i] = Decimal(v)
+			} else {
+				data[i] = nil
+			}
+			continue
+
+		case MYSQL_TYPE_VARCHAR,
 			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
 			MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
 			MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY:
@@ -241,6 +257,10 @@ type Result struct {
 	InsertId     uint64
 	AffectedRows uint64
 
+	Warnings uint16
+	//the info of ok packet, such as Records: 3  Duplicates: 1  Warnings: 0
+	Info string
+
 	*Resultset
 }
 
@@ -318,6 +338,9 @@ func (r *Resultset) GetUint(row, column int) (uint64, error) {
 		return uint64(v), nil
 	case float64:
 		return uint64(v), nil
+	case Decimal:
+		f, err := v.Float64()
+		return uint64(f), err
 	case string:
 		return strconv.ParseUint(v, 10, 64)
 	case []byte:
@@ -358,6 +381,9 @@ func (r *Resultset) GetInt(row, column int) (int64, error) {
 		return v, nil
 	case float64:
 		return int64(v), nil
+	case Decimal:
+		f, err := v.Float64()
+		return int64(f), err
 	case string:
 		return strconv.ParseInt(v, 10, 64)
 	case []byte:
@@ -382,6 +408,8 @@ func (r *Resultset) GetFloat(row, column int) (float64, error) {
 		return float64(v), nil
 	case int64:
 		return float64(v), nil
+	case Decimal:
+		return v.Float64()
 	case string:
 		return strconv.ParseFloat(v, 64)
 	case []byte:
@@ -418,6 +446,8 @@ func (r *Resultset) GetString(row, column int) (string, error) {
 		return strconv.FormatUint(v, 10), nil
 	case float64:
 		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case Decimal:
+		return string(v), nil
 	case nil:
 		return "", nil
 	default:
@@ -105,6 +105,9 @@ func cmpValue(v1 interface{}, v2 interface{}) int {
 	case []byte:
 		s := v2.([]byte)
 		return bytes.Compare(v, s)
+	case Decimal:
+		s := v2.(Decimal)
+		return v.Cmp(s)
 	case int64:
 		s := v2.(int64)
 		if v < s {
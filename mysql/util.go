@@ -16,6 +16,7 @@ package mysql
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -60,6 +61,27 @@ func CalcPassword(scramble, password []byte) []byte {
 	return scramble
 }
 
+//the auth response of caching_sha2_password:
+//XOR(SHA256(password), SHA256(SHA256(SHA256(password)), scramble))
+func CalcCachingSha2Password(scramble, password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+
+	stage1 := sha256.Sum256(password)
+	stage2 := sha256.Sum256(stage1[:])
+
+	crypt := sha256.New()
+	crypt.Write(stage2[:])
+	crypt.Write(scramble)
+	token := crypt.Sum(nil)
+
+	for i := range token {
+		token[i] ^= stage1[i]
+	}
+	return token
+}
+
 // seed must be in the range of ascii
 func RandomBuf(size int) ([]byte, error) {
 	buf := make([]byte, size)
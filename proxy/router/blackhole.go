@@ -0,0 +1,101 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the sub tables on the node blackhole are decommissioned, the selects of
+//them return nothing and the writes are dropped or rejected
+const BlackholeNode = "blackhole"
+
+var (
+	BlackholeDrop   = "drop"
+	BlackholeReject = "reject"
+)
+
+//IsBlackhole return true if the sub table is on the blackhole node
+func (r *Rule) IsBlackhole(tableIndex int) bool {
+	nodeIndex, ok := r.TableToNode[tableIndex]
+	return ok && r.Nodes[nodeIndex] == BlackholeNode
+}
+
+//FirstSubTable return the first sub table which is not on the blackhole
+//node, or the first sub table if all are
+func (r *Rule) FirstSubTable() int {
+	for _, tableIndex := range r.SubTableIndexs {
+		if !r.IsBlackhole(tableIndex) {
+			return tableIndex
+		}
+	}
+	return r.SubTableIndexs[0]
+}
+
+func parseBlackhole(r *Rule, cfg *config.ShardConfig) error {
+	policy := strings.ToLower(cfg.BlackholePolicy)
+	switch policy {
+	case "", BlackholeDrop:
+	case BlackholeReject:
+		r.BlackholeReject = true
+	default:
+		return fmt.Errorf("invalid blackhole_policy %s of table %s", cfg.BlackholePolicy, cfg.Table)
+	}
+
+	if !includeNode(r.Nodes, BlackholeNode) {
+		if len(policy) != 0 {
+			return fmt.Errorf("blackhole_policy of table %s has no %s node", cfg.Table, BlackholeNode)
+		}
+		return nil
+	}
+	//the rows of a hash sub table are not decommissioned together
+	if r.Type == HashRuleType {
+		return fmt.Errorf("%s node of table %s is not supported by hash shard", BlackholeNode, cfg.Table)
+	}
+	return nil
+}
+
+//dropBlackhole removes the sub tables on the blackhole node from the route
+//of plan, Blackhole is set if no sub table is left. op is the operation of
+//plan. With blackhole_policy reject, the insert or replace of a row in
+//them fails, and so does the other write which is only routed to them.
+func (plan *Plan) dropBlackhole(op string) error {
+	rule := plan.Rule
+	var indexs []int
+	var dropped []int
+	for _, tableIndex := range plan.RouteTableIndexs {
+		if rule.IsBlackhole(tableIndex) {
+			dropped = append(dropped, tableIndex)
+		} else {
+			indexs = append(indexs, tableIndex)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	rows := op == "insert" || op == "replace"
+	if op != "select" && rule.BlackholeReject && (rows || len(indexs) == 0) {
+		return mysql.NewError(mysql.ER_KS_BLACKHOLE_TABLE,
+			fmt.Sprintf("sub table %s_%04d is decommissioned", rule.Table, dropped[0]))
+	}
+	plan.RouteTableIndexs = indexs
+	plan.RouteNodeIndexs = plan.TindexsToNindexs(indexs)
+	plan.Blackhole = len(indexs) == 0
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flike/kingshard/config"
+)
+
+//the characters of 0x80-0x9F in mysql latin1, which is cp1252 actually,
+//0 means the byte is undefined and kept as U+0080-U+009F.
+var latin1High = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+//convert the string in charset to utf8, so a string shard key is hashed
+//by the same bytes whatever the client charset is. The utf8 compatible
+//charsets and the charsets not supported are kept as is.
+func toUtf8(charset string, s string) string {
+	switch strings.ToLower(charset) {
+	case "latin1":
+		return latin1ToUtf8(s)
+	}
+	return s
+}
+
+func latin1ToUtf8(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	runes := make([]rune, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := rune(s[i])
+		if 0x80 <= c && c < 0xA0 && latin1High[c-0x80] != 0 {
+			c = latin1High[c-0x80]
+		}
+		runes = append(runes, c)
+	}
+	return string(runes)
+}
+
+//Collation folds a string shard key to the form compared by a mysql
+//collation, so the keys equal in the collation are hashed to the same sub
+//table. Only what the collation folds is folded.
+type Collation struct {
+	Name string
+
+	padSpace bool //the trailing spaces are ignored
+	fold     func(r rune) string
+}
+
+//the collations supported by key_collation, the others are refused since
+//their keys can not be folded as mysql does
+var collations = map[string]*Collation{
+	"binary":             {Name: "binary"},
+	"utf8_bin":           {Name: "utf8_bin", padSpace: true},
+	"utf8mb3_bin":        {Name: "utf8mb3_bin", padSpace: true},
+	"utf8mb4_bin":        {Name: "utf8mb4_bin", padSpace: true},
+	"utf8mb4_0900_bin":   {Name: "utf8mb4_0900_bin"},
+	"utf8mb4_0900_as_cs": {Name: "utf8mb4_0900_as_cs"},
+	"utf8_general_ci":    {Name: "utf8_general_ci", padSpace: true, fold: foldGeneral},
+	"utf8mb3_general_ci": {Name: "utf8mb3_general_ci", padSpace: true, fold: foldGeneral},
+	"utf8mb4_general_ci": {Name: "utf8mb4_general_ci", padSpace: true, fold: foldGeneral},
+	"utf8mb4_0900_ai_ci": {Name: "utf8mb4_0900_ai_ci", fold: foldAccent},
+}
+
+//parse the key_collation of the table, the string keys of a table without
+//key_collation are hashed by their bytes in the charset of client
+func parseKeyCollation(r *Rule, cfg *config.ShardConfig) error {
+	if len(cfg.KeyCollation) == 0 {
+		return nil
+	}
+	if r.Type != HashRuleType {
+		return fmt.Errorf("key_collation of table %s is only supported by %s shard",
+			cfg.Table, HashRuleType)
+	}
+	c, ok := collations[strings.ToLower(cfg.KeyCollation)]
+	if !ok {
+		return fmt.Errorf("key_collation %s of table %s is not supported",
+			cfg.KeyCollation, cfg.Table)
+	}
+	r.KeyCollation = c.Name
+	r.collation = c
+	return nil
+}
+
+//Fold returns the form of key compared by the collation, key is in utf8
+func (c *Collation) Fold(key string) string {
+	if c.padSpace {
+		key = strings.TrimRight(key, " ")
+	}
+	if c.fold == nil {
+		return key
+	}
+	buf := make([]byte, 0, len(key))
+	for _, r := range key {
+		buf = append(buf, c.fold(r)...)
+	}
+	return string(buf)
+}
+
+//the weights of 0xC0-0xFF in utf8_general_ci, the accents of latin
+//letters are ignored but Æ, Ð, Ø and Þ are letters of their own
+const generalLatin1 = "AAAAAA\u00c6CEEEEIIII\u00d0NOOOOO\u00d7\u00d8UUUUY\u00deS" +
+	"AAAAAA\u00c6CEEEEIIII\u00d0NOOOOO\u00f7\u00d8UUUUY\u00deY"
+
+//the weights of 0x100-0x17F in utf8_general_ci
+const generalLatinA = "AAAAAACCCCCCCCDD\u0110\u0110EEEEEEEEEEGGGGGGGGHH\u0126\u0126" +
+	"IIIIIIIIII\u0132\u0132JJKK\u0138LLLLLL\u013f\u013f\u0141\u0141NNNNNN\u0149" +
+	"\u014a\u014aOOOOOO\u0152\u0152RRRRRRSSSSSSSSTTTT\u0166\u0166" +
+	"UUUUUUUUUUUUWWYYYZZZZZZS"
+
+var (
+	generalLatin1Weights = []rune(generalLatin1)
+	generalLatinAWeights = []rune(generalLatinA)
+)
+
+//utf8_general_ci compares the characters by the weights of their upper
+//case without accents, each character has one weight, so ß equals s and
+//not ss. The characters out of the BMP are equal in utf8mb4_general_ci.
+func foldGeneral(r rune) string {
+	switch {
+	case 0xC0 <= r && r <= 0xFF:
+		r = generalLatin1Weights[r-0xC0]
+	case 0x100 <= r && r <= 0x17F:
+		r = generalLatinAWeights[r-0x100]
+	case 0xFFFF < r:
+		r = utf8.RuneError
+	default:
+		r = unicode.ToUpper(r)
+	}
+	return string(r)
+}
+
+//the expansions of utf8mb4_0900_ai_ci, which is accent and case
+//insensitive by the unicode collation algorithm
+var accentExpansions = map[rune]string{
+	0xC6: "ae", 0xE6: "ae", 0xDF: "ss", 0x152: "oe", 0x153: "oe",
+	0x131: "\u0131", 0x132: "ij", 0x133: "ij", 0x149: "\u02bcn", 0x17F: "s",
+	0xD8: "o", 0xF8: "o", 0x110: "d", 0x111: "d", 0x126: "h", 0x127: "h",
+	0x13F: "l\u00b7", 0x140: "l\u00b7", 0x141: "l", 0x142: "l", 0x166: "t", 0x167: "t",
+}
+
+//utf8mb4_0900_ai_ci ignores the accents and case, the accents are folded
+//for the latin letters of latin1 and latin extended-a, the others are
+//only folded to lower case
+func foldAccent(r rune) string {
+	if s, ok := accentExpansions[r]; ok {
+		return s
+	}
+	switch {
+	case 0xC0 <= r && r <= 0xFF && r != 0xD7 && r != 0xF7:
+		r = generalLatin1Weights[r-0xC0]
+	case 0x100 <= r && r <= 0x17F:
+		r = generalLatinAWeights[r-0x100]
+	}
+	return string(unicode.ToLower(r))
+}
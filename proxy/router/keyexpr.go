@@ -0,0 +1,292 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//parse the key_expr of the table, which derives the value routed from
+//the value of shard key, such as substr(order_no, 1, 6) or user_id % 1000.
+//It can only refer to the shard key column.
+func parseKeyExpr(r *Rule, cfg *config.ShardConfig) error {
+	if len(cfg.KeyExpr) == 0 {
+		return nil
+	}
+	if cfg.NoRewrite || r.Type == DefaultRuleType {
+		return fmt.Errorf("key_expr of table %s is not allowed for no_rewrite or default table", cfg.Table)
+	}
+	if r.KeyType == UUIDKeyType {
+		return fmt.Errorf("key_expr of table %s is not allowed for key_type %s", cfg.Table, UUIDKeyType)
+	}
+	stmt, err := sqlparser.Parse("select " + cfg.KeyExpr + " from t")
+	if err != nil {
+		return fmt.Errorf("invalid key_expr %s of table %s", cfg.KeyExpr, cfg.Table)
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || len(sel.SelectExprs) != 1 {
+		return fmt.Errorf("invalid key_expr %s of table %s", cfg.KeyExpr, cfg.Table)
+	}
+	e, ok := sel.SelectExprs[0].(*sqlparser.NonStarExpr)
+	if !ok || len(e.As) != 0 {
+		return fmt.Errorf("invalid key_expr %s of table %s", cfg.KeyExpr, cfg.Table)
+	}
+	referred, err := checkKeyExpr(e.Expr, r.Key)
+	if err != nil {
+		return fmt.Errorf("invalid key_expr %s of table %s: %s", cfg.KeyExpr, cfg.Table, err.Error())
+	}
+	if !referred {
+		return fmt.Errorf("key_expr %s of table %s does not refer to shard key %s",
+			cfg.KeyExpr, cfg.Table, r.Key)
+	}
+	r.KeyExpr = cfg.KeyExpr
+	r.keyExpr = e.Expr
+	return nil
+}
+
+//check expr only has the supported operators and functions, and the
+//columns of it are key. It returns true if key is referred.
+func checkKeyExpr(expr sqlparser.Expr, key string) (bool, error) {
+	switch node := expr.(type) {
+	case sqlparser.NumVal, sqlparser.StrVal:
+		return false, nil
+	case *sqlparser.ColName:
+		if len(node.Qualifier) != 0 || strings.ToLower(string(node.Name)) != key {
+			return false, fmt.Errorf("column %s is not shard key", sqlparser.String(node))
+		}
+		return true, nil
+	case sqlparser.ValTuple:
+		if len(node) != 1 {
+			return false, fmt.Errorf("tuple is not supported")
+		}
+		return checkKeyExpr(node[0], key)
+	case *sqlparser.UnaryExpr:
+		if node.Operator != sqlparser.AST_UMINUS && node.Operator != sqlparser.AST_UPLUS {
+			return false, fmt.Errorf("operator %c is not supported", node.Operator)
+		}
+		return checkKeyExpr(node.Expr, key)
+	case *sqlparser.BinaryExpr:
+		switch node.Operator {
+		case sqlparser.AST_PLUS, sqlparser.AST_MINUS, sqlparser.AST_MULT, sqlparser.AST_MOD:
+		default:
+			return false, fmt.Errorf("operator %c is not supported", node.Operator)
+		}
+		return checkKeyExprs([]sqlparser.Expr{node.Left, node.Right}, key)
+	case *sqlparser.FuncExpr:
+		name := strings.ToLower(string(node.Name))
+		var min, max int
+		switch name {
+		case "substr", "substring":
+			min, max = 2, 3
+		case "mod":
+			min, max = 2, 2
+		default:
+			return false, fmt.Errorf("function %s is not supported", name)
+		}
+		if node.Distinct || len(node.Exprs) < min || max < len(node.Exprs) {
+			return false, fmt.Errorf("invalid arguments of function %s", name)
+		}
+		args, err := funcArgs(node)
+		if err != nil {
+			return false, err
+		}
+		return checkKeyExprs(args, key)
+	}
+	return false, fmt.Errorf("%s is not supported", sqlparser.String(expr))
+}
+
+func checkKeyExprs(exprs []sqlparser.Expr, key string) (bool, error) {
+	referred := false
+	for _, e := range exprs {
+		ok, err := checkKeyExpr(e, key)
+		if err != nil {
+			return false, err
+		}
+		referred = referred || ok
+	}
+	return referred, nil
+}
+
+func funcArgs(node *sqlparser.FuncExpr) ([]sqlparser.Expr, error) {
+	args := make([]sqlparser.Expr, 0, len(node.Exprs))
+	for _, e := range node.Exprs {
+		arg, ok := e.(*sqlparser.NonStarExpr)
+		if !ok {
+			return nil, fmt.Errorf("invalid arguments of function %s", node.Name)
+		}
+		args = append(args, arg.Expr)
+	}
+	return args, nil
+}
+
+//deriveKey returns the value routed of the shard key value, which is value
+//itself if the rule has no key_expr
+func (r *Rule) deriveKey(value interface{}) (interface{}, error) {
+	if r.keyExpr == nil {
+		return value, nil
+	}
+	derived, err := evalKeyExpr(r.keyExpr, value)
+	if err != nil {
+		return nil, mysql.NewError(mysql.ER_KS_KEY_TYPE_MISMATCH,
+			fmt.Sprintf("key_expr %s of table %s with the value %v: %s",
+				r.KeyExpr, r.Table, value, err.Error()))
+	}
+	return derived, nil
+}
+
+//evaluate expr checked by checkKeyExpr, the shard key column is value.
+//The arithmetic is of int64 and the functions of strings are of characters
+//as mysql.
+func evalKeyExpr(expr sqlparser.Expr, value interface{}) (interface{}, error) {
+	switch node := expr.(type) {
+	case sqlparser.NumVal:
+		return getNumValue(string(node)), nil
+	case sqlparser.StrVal:
+		return string(node), nil
+	case *sqlparser.ColName:
+		return value, nil
+	case sqlparser.ValTuple:
+		return evalKeyExpr(node[0], value)
+	case *sqlparser.UnaryExpr:
+		v, err := evalKeyInt(node.Expr, value)
+		if err != nil {
+			return nil, err
+		}
+		if node.Operator == sqlparser.AST_UMINUS {
+			return -v, nil
+		}
+		return v, nil
+	case *sqlparser.BinaryExpr:
+		return evalKeyArith(node.Operator, node.Left, node.Right, value)
+	case *sqlparser.FuncExpr:
+		args, err := funcArgs(node)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(string(node.Name)) {
+		case "mod":
+			return evalKeyArith(sqlparser.AST_MOD, args[0], args[1], value)
+		case "substr", "substring":
+			return evalSubstr(args, value)
+		}
+	}
+	return nil, fmt.Errorf("%s is not supported", sqlparser.String(expr))
+}
+
+func evalKeyArith(op byte, left, right sqlparser.Expr, value interface{}) (interface{}, error) {
+	l, err := evalKeyInt(left, value)
+	if err != nil {
+		return nil, err
+	}
+	r, err := evalKeyInt(right, value)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case sqlparser.AST_PLUS:
+		return l + r, nil
+	case sqlparser.AST_MINUS:
+		return l - r, nil
+	case sqlparser.AST_MULT:
+		return l * r, nil
+	case sqlparser.AST_MOD:
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		//the sign of result is the one of dividend as mysql
+		return l % r, nil
+	}
+	return nil, fmt.Errorf("operator %c is not supported", op)
+}
+
+//substr(str, pos) or substr(str, pos, len), pos starts from 1, and the
+//negative pos counts from the end of str
+func evalSubstr(args []sqlparser.Expr, value interface{}) (interface{}, error) {
+	s, err := evalKeyString(args[0], value)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := evalKeyInt(args[1], value)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	size := int64(len(runes))
+	n := size
+	if len(args) == 3 {
+		if n, err = evalKeyInt(args[2], value); err != nil {
+			return nil, err
+		}
+	}
+	var start int64
+	switch {
+	case 0 < pos:
+		start = pos - 1
+	case pos < 0:
+		start = size + pos
+	default:
+		return "", nil
+	}
+	if start < 0 || size <= start || n <= 0 {
+		return "", nil
+	}
+	if size-start < n {
+		n = size - start
+	}
+	return string(runes[start : start+n]), nil
+}
+
+func evalKeyInt(expr sqlparser.Expr, value interface{}) (int64, error) {
+	v, err := evalKeyExpr(expr, value)
+	if err != nil {
+		return 0, err
+	}
+	switch val := v.(type) {
+	case int:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case uint64:
+		if val <= math.MaxInt64 {
+			return int64(val), nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not an integer", v)
+}
+
+func evalKeyString(expr sqlparser.Expr, value interface{}) (string, error) {
+	v, err := evalKeyExpr(expr, value)
+	if err != nil {
+		return "", err
+	}
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case []byte:
+		return string(val), nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Location is where the rows of a key are stored, SubTable is the table
+//itself and TableIndex is -1 if the table is not sharded
+type Location struct {
+	DB         string
+	Table      string
+	Node       string
+	SubTable   string
+	TableIndex int
+}
+
+//Locate returns the location of key in db.table, key is a value from
+//users such as 12345 or 2016-05-01, which is used as an int if it is a
+//number and the key type of rule is not string
+func (r *Router) Locate(db string, table string, key string) (loc *Location, err error) {
+	if i := strings.Index(table, "."); i != -1 {
+		db, table = table[:i], table[i+1:]
+	}
+	rule := r.GetRule(db, table)
+	loc = &Location{
+		DB:         rule.DB,
+		Table:      r.normalizeName(table),
+		TableIndex: -1,
+	}
+	if rule.Type == DefaultRuleType {
+		loc.Node = rule.Nodes[0]
+		loc.SubTable = loc.Table
+		return loc, nil
+	}
+	loc.Table = rule.Table
+
+	var value interface{} = key
+	if rule.KeyType != StringKeyType {
+		if v, err := strconv.ParseInt(key, 10, 64); err == nil {
+			value = v
+		}
+	}
+	defer handleError(&err)
+	tableIndex, err := rule.findKeyTableIndex(value)
+	if err != nil {
+		return nil, err
+	}
+	nodeIndex, ok := rule.TableToNode[tableIndex]
+	if !ok {
+		return nil, fmt.Errorf("no sub table of key %s in %s", key, rule.Table)
+	}
+	loc.Node = rule.Nodes[nodeIndex]
+	loc.SubTable = fmt.Sprintf("%s_%04d", rule.Table, tableIndex)
+	loc.TableIndex = tableIndex
+	return loc, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TableWildcard    = "%" //log_% matches log_2016, log_2017 and so on
+	TableRegexPrefix = "~" //~^log_[0-9]+$ is a regular expression
+
+	//the max count of table names whose rules of pattern are cached
+	MaxMatchedTables = 65536
+)
+
+//the rules whose table is a pattern, a table matching a pattern uses a
+//copy of the rule named after the table, so its sub tables are the table
+//name with suffix such as log_2016_0001
+type patternRules struct {
+	sync.RWMutex
+	rules   map[string][]*Rule //db -> the rules of pattern, in config order
+	matched map[string]*Rule   //db.table -> the rule matched, nil if none
+}
+
+func isTablePattern(table string) bool {
+	return strings.Contains(table, TableWildcard) || strings.HasPrefix(table, TableRegexPrefix)
+}
+
+//compile the table pattern, % matches any characters and the others are
+//compared literally, or a regular expression after ~
+func compileTablePattern(table string, ignoreCase bool) (*regexp.Regexp, error) {
+	var expr string
+	if strings.HasPrefix(table, TableRegexPrefix) {
+		expr = table[len(TableRegexPrefix):]
+	} else {
+		parts := strings.Split(table, TableWildcard)
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		expr = "^" + strings.Join(parts, ".*") + "$"
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid table pattern %s: %s", table, err.Error())
+	}
+	return re, nil
+}
+
+func (p *patternRules) add(rule *Rule) error {
+	if p.rules == nil {
+		p.rules = make(map[string][]*Rule)
+		p.matched = make(map[string]*Rule)
+	}
+	for _, r := range p.rules[rule.DB] {
+		if r.Table == rule.Table {
+			return fmt.Errorf("table %s rule in %s duplicate", rule.Table, rule.DB)
+		}
+	}
+	p.rules[rule.DB] = append(p.rules[rule.DB], rule)
+	return nil
+}
+
+//the rule of the first pattern matching table, nil if none. The result
+//is cached, so a table is matched against the patterns only once.
+func (p *patternRules) match(db, table string) *Rule {
+	if len(p.rules[db]) == 0 {
+		return nil
+	}
+	key := db + "." + table
+	p.RLock()
+	rule, ok := p.matched[key]
+	p.RUnlock()
+	if ok {
+		return rule
+	}
+
+	for _, r := range p.rules[db] {
+		if r.pattern.MatchString(table) {
+			rule = new(Rule)
+			*rule = *r
+			rule.Table = table
+			rule.pattern = nil
+			break
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	//the rule matched by other session is used, so a table has one rule
+	if r, ok := p.matched[key]; ok {
+		return r
+	}
+	if len(p.matched) < MaxMatchedTables {
+		p.matched[key] = rule
+	}
+	return rule
+}
+
+//the rule of pattern of a sub table such as log_2016_0001, nil if none
+func (p *patternRules) subTableRule(table string) *Rule {
+	i := strings.LastIndex(table, "_")
+	if len(p.rules) == 0 || i < 0 || len(table)-i-1 != 4 {
+		return nil
+	}
+	tableIndex, err := strconv.Atoi(table[i+1:])
+	if err != nil {
+		return nil
+	}
+	for db := range p.rules {
+		rule := p.match(db, table[:i])
+		if rule == nil {
+			continue
+		}
+		if _, ok := rule.TableToNode[tableIndex]; ok {
+			return rule
+		}
+	}
+	return nil
+}
@@ -15,6 +15,8 @@
 package router
 
 import (
+	"fmt"
+	"math"
 	"sort"
 	"strconv"
 
@@ -22,6 +24,7 @@ import (
 
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
 	"github.com/flike/kingshard/sqlparser"
 )
 
@@ -46,6 +49,46 @@ type Plan struct {
 	RouteTableIndexs    []int
 	RouteNodeIndexs     []int
 	RewrittenSqls       map[string][]string
+
+	//the shard key values in =, in and insert values, used to find hot keys
+	KeyValues []interface{}
+
+	//the alias of the shard table, used to resolve the column qualifier
+	TableAlias string
+
+	//the unqualified columns of soft delete, qualified by the sub table
+	//name in the rewritten joins
+	softDeleteColumns []*sqlparser.ColName
+
+	//the charset of the strings in sql, empty means utf8
+	Charset string
+
+	//all the sub tables routed are on the blackhole node, the plan has
+	//no sqls
+	Blackhole bool
+
+	//the having and limit of select are not sent to the sub tables, they
+	//are applied by kingshard to the merged result, see isPostHaving
+	PostHaving bool
+
+	//the plan depends on the time it is built, such as the recent sub
+	//tables, it can not be reused by the plan cache
+	Uncacheable bool
+}
+
+//return the sub tables of the RewrittenSqls[nodeName] in order,
+//nil if the sqls are not sent to the sub tables
+func (plan *Plan) GetSubTables(nodeName string) []string {
+	if plan.Rule == nil || len(plan.RouteTableIndexs) == 0 {
+		return nil
+	}
+	tables := make([]string, 0, len(plan.RouteTableIndexs))
+	for _, tableIndex := range plan.RouteTableIndexs {
+		if plan.Rule.Nodes[plan.Rule.TableToNode[tableIndex]] == nodeName {
+			tables = append(tables, fmt.Sprintf("%s_%04d", plan.Rule.Table, tableIndex))
+		}
+	}
+	return tables
 }
 
 func (plan *Plan) rewriteWhereIn(tableIndex int) (sqlparser.ValExpr, error) {
@@ -58,11 +101,52 @@ func (plan *Plan) rewriteWhereIn(tableIndex int) (sqlparser.ValExpr, error) {
 	return oldright, nil
 }
 
+//the column qualified by nothing, or by the shard table without alias,
+//or by its alias belongs to the shard table. The table is hidden by its
+//alias as in mysql.
+func (plan *Plan) isShardTableColumn(qualifier []byte) bool {
+	if len(qualifier) == 0 {
+		return true
+	}
+	if len(plan.TableAlias) == 0 {
+		return plan.Rule.IsTable(string(qualifier))
+	}
+	return plan.isTableAlias(qualifier)
+}
+
+func (plan *Plan) isTableAlias(qualifier []byte) bool {
+	if plan.Rule.ignoreCase {
+		return strings.EqualFold(string(qualifier), plan.TableAlias)
+	}
+	return string(qualifier) == plan.TableAlias
+}
+
+//the shard table hidden by its alias can not qualify the columns of
+//expr, as in mysql, otherwise the qualifier stripped by routing makes
+//the sql valid. clause is the clause of expr in the error.
+func (plan *Plan) checkHiddenTable(expr sqlparser.Expr, clause string) error {
+	if len(plan.TableAlias) == 0 {
+		return nil
+	}
+	var err error
+	visitColumns(expr, func(col *sqlparser.ColName) {
+		if err == nil && plan.Rule.IsTable(string(col.Qualifier)) && !plan.isTableAlias(col.Qualifier) {
+			err = mysql.NewDefaultError(mysql.ER_BAD_FIELD_ERROR, sqlparser.String(col), clause)
+		}
+	})
+	return err
+}
+
 func (plan *Plan) notList(l []int) []int {
 	return differentList(plan.Rule.SubTableIndexs, l)
 }
 
 func (plan *Plan) getTableIndexs(expr sqlparser.BoolExpr) ([]int, error) {
+	//the value derived by key_expr or the uuid is not in the order of
+	//shard key, only the equal conditions are routed as hash
+	if plan.Rule.keyExpr != nil || plan.Rule.KeyType == UUIDKeyType {
+		return plan.getHashShardTableIndex(expr)
+	}
 	switch plan.Rule.Type {
 	case HashRuleType:
 		return plan.getHashShardTableIndex(expr)
@@ -97,12 +181,6 @@ func (plan *Plan) getHashShardTableIndex(expr sqlparser.BoolExpr) ([]int, error)
 			return plan.Rule.SubTableIndexs, nil
 		case "in":
 			return plan.getTableIndexsByTuple(criteria.Right)
-		case "not in":
-			l, err := plan.getTableIndexsByTuple(criteria.Right)
-			if err != nil {
-				return nil, err
-			}
-			return plan.notList(l), nil
 		}
 	case *sqlparser.RangeCond: //between ... and ...
 		return plan.Rule.SubTableIndexs, nil
@@ -168,8 +246,6 @@ func (plan *Plan) getRangeShardTableIndex(expr sqlparser.BoolExpr) ([]int, error
 			}
 		case "in":
 			return plan.getTableIndexsByTuple(criteria.Right)
-		case "not in":
-			return plan.Rule.SubTableIndexs, nil
 		}
 	case *sqlparser.RangeCond:
 		var start, last int
@@ -252,12 +328,6 @@ func (plan *Plan) getDateShardTableIndex(expr sqlparser.BoolExpr) ([]int, error)
 			}
 		case "in":
 			return plan.getTableIndexsByTuple(criteria.Right)
-		case "not in":
-			l, err := plan.getTableIndexsByTuple(criteria.Right)
-			if err != nil {
-				return nil, err
-			}
-			return plan.notList(l), nil
 		}
 	case *sqlparser.RangeCond:
 		var start, last int
@@ -275,8 +345,12 @@ func (plan *Plan) getDateShardTableIndex(expr sqlparser.BoolExpr) ([]int, error)
 		if criteria.Operator == "between" { //对应between ...and ...
 			return makeBetweenList(start, last, plan.Rule.SubTableIndexs), nil
 		} else { //对应not between ....and
+			//the boundary tables may have the dates not between
 			l := makeBetweenList(start, last, plan.Rule.SubTableIndexs)
-			return plan.notList(l), nil
+			if len(l) <= 2 {
+				return plan.Rule.SubTableIndexs, nil
+			}
+			return plan.notList(l[1 : len(l)-1]), nil
 		}
 	default:
 		return plan.Rule.SubTableIndexs, nil
@@ -330,7 +404,7 @@ func (plan *Plan) checkValuesType(vals sqlparser.Values) sqlparser.Values {
 		switch tuple := vals[i].(type) {
 		case sqlparser.ValTuple:
 			result := plan.getValueType(tuple[0])
-			if result != VALUE_NODE {
+			if _, ok := tuple[0].(*sqlparser.NullVal); !ok && result != VALUE_NODE {
 				panic(sqlparser.NewParserError("insert is too complex"))
 			}
 		default:
@@ -345,10 +419,10 @@ func (plan *Plan) getValueType(valExpr sqlparser.ValExpr) int {
 	switch node := valExpr.(type) {
 	case *sqlparser.ColName:
 		//remove table name
-		if string(node.Qualifier) == plan.Rule.Table {
+		if plan.Rule.IsTable(string(node.Qualifier)) {
 			node.Qualifier = nil
 		}
-		if strings.ToLower(string(node.Name)) == plan.Rule.Key {
+		if plan.isKeyColumn(node) {
 			return EID_NODE //表示这是分片id对应的node
 		}
 	case sqlparser.ValTuple:
@@ -358,12 +432,128 @@ func (plan *Plan) getValueType(valExpr sqlparser.ValExpr) int {
 			}
 		}
 		return LIST_NODE //列表节点
-	case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg: //普通的值节点，字符串值，绑定变量参数
+	case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg, *sqlparser.CharsetStrVal: //普通的值节点，字符串值，绑定变量参数
 		return VALUE_NODE
+	case *sqlparser.FuncExpr:
+		if plan.Rule.KeyType == UUIDKeyType && isUUIDToBin(node) {
+			return VALUE_NODE
+		}
 	}
 	return OTHER_NODE
 }
 
+//the column is the shard key of the shard table
+func (plan *Plan) isKeyColumn(col *sqlparser.ColName) bool {
+	return plan.isShardTableColumn(col.Qualifier) &&
+		strings.ToLower(string(col.Name)) == plan.Rule.Key
+}
+
+//split the expr by and, the result are the conditions must be all true
+func splitAndExpr(conds []sqlparser.BoolExpr, expr sqlparser.BoolExpr) []sqlparser.BoolExpr {
+	switch node := expr.(type) {
+	case *sqlparser.AndExpr:
+		conds = splitAndExpr(conds, node.Left)
+		return splitAndExpr(conds, node.Right)
+	case *sqlparser.ParenBoolExpr:
+		return splitAndExpr(conds, node.Expr)
+	}
+	return append(conds, expr)
+}
+
+//propagate the value of the shard key through the column equalities,
+//e.g. a.id = b.id and b.id = 7 derives a.id = 7 when a.id is the shard
+//key. The derived conditions are only used to route, not in the sql.
+func (plan *Plan) propagateKeyEquality(expr sqlparser.BoolExpr) sqlparser.BoolExpr {
+	conds := splitAndExpr(nil, expr)
+
+	//the columns equal to the shard key
+	keyCols := make(map[string]bool)
+	var pairs [][2]*sqlparser.ColName
+	for _, cond := range conds {
+		c, ok := cond.(*sqlparser.ComparisonExpr)
+		if !ok || c.Operator != "=" {
+			continue
+		}
+		left, ok1 := c.Left.(*sqlparser.ColName)
+		right, ok2 := c.Right.(*sqlparser.ColName)
+		if !ok1 || !ok2 {
+			continue
+		}
+		pairs = append(pairs, [2]*sqlparser.ColName{left, right})
+		if plan.isKeyColumn(left) {
+			keyCols[columnKey(left)] = true
+		}
+		if plan.isKeyColumn(right) {
+			keyCols[columnKey(right)] = true
+		}
+	}
+	if len(keyCols) == 0 {
+		return expr
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pair := range pairs {
+			left, right := columnKey(pair[0]), columnKey(pair[1])
+			if keyCols[left] != keyCols[right] {
+				keyCols[left] = true
+				keyCols[right] = true
+				changed = true
+			}
+		}
+	}
+
+	for _, cond := range conds {
+		c, ok := cond.(*sqlparser.ComparisonExpr)
+		if !ok || c.Operator != "=" {
+			continue
+		}
+		col, value := c.Left, c.Right
+		if _, ok := col.(*sqlparser.ColName); !ok {
+			col, value = c.Right, c.Left
+		}
+		colName, ok := col.(*sqlparser.ColName)
+		if !ok || plan.isKeyColumn(colName) || !keyCols[columnKey(colName)] {
+			continue
+		}
+		if plan.getValueType(value) != VALUE_NODE {
+			continue
+		}
+		expr = &sqlparser.AndExpr{
+			Left: expr,
+			Right: &sqlparser.ComparisonExpr{
+				Operator: "=",
+				Left:     &sqlparser.ColName{Name: []byte(plan.Rule.Key)},
+				Right:    value,
+			},
+		}
+	}
+	return expr
+}
+
+func columnKey(col *sqlparser.ColName) string {
+	return strings.ToLower(sqlparser.String(col))
+}
+
+//one of the exprs is the shard key and the other is NULL
+func (plan *Plan) isNullKey(left, right sqlparser.ValExpr) bool {
+	if _, ok := right.(*sqlparser.NullVal); ok {
+		return plan.getValueType(left) == EID_NODE
+	}
+	if _, ok := left.(*sqlparser.NullVal); ok {
+		return plan.getValueType(right) == EID_NODE
+	}
+	return false
+}
+
+//the rows whose shard key is NULL are in the null_key_table,
+//or in any sub table if there is no null_key_table.
+func (plan *Plan) getNullKeyTableIndexs() []int {
+	if plan.Rule.NullKeyTable < 0 {
+		return plan.Rule.SubTableIndexs
+	}
+	return []int{plan.Rule.NullKeyTable}
+}
+
 func (plan *Plan) getTableIndexByBoolExpr(node sqlparser.BoolExpr) ([]int, error) {
 	switch node := node.(type) {
 	case *sqlparser.AndExpr:
@@ -394,18 +584,34 @@ func (plan *Plan) getTableIndexByBoolExpr(node sqlparser.BoolExpr) ([]int, error
 			left := plan.getValueType(node.Left)
 			right := plan.getValueType(node.Right)
 			if (left == EID_NODE && right == VALUE_NODE) || (left == VALUE_NODE && right == EID_NODE) {
+				if node.Operator == "=" {
+					if left == EID_NODE {
+						plan.addKeyValue(node.Right)
+					} else {
+						plan.addKeyValue(node.Left)
+					}
+				}
 				return plan.getTableIndexs(node)
 			}
-		case sqlparser.StringIn(node.Operator, "in", "not in"):
+		case node.Operator == "in":
 			left := plan.getValueType(node.Left)
 			right := plan.getValueType(node.Right)
 			if left == EID_NODE && right == LIST_NODE {
-				if strings.EqualFold(node.Operator, "in") { //only deal with in expr, it's impossible to process not in here.
-					plan.InRightToReplace = node
+				plan.InRightToReplace = node
+				for _, v := range node.Right.(sqlparser.ValTuple) {
+					plan.addKeyValue(v)
 				}
 				return plan.getTableIndexs(node)
 			}
 		}
+		//key <=> null
+		if node.Operator == "<=>" && plan.isNullKey(node.Left, node.Right) {
+			return plan.getNullKeyTableIndexs(), nil
+		}
+	case *sqlparser.NullCheck:
+		if node.Operator == sqlparser.AST_IS_NULL && plan.getValueType(node.Expr) == EID_NODE {
+			return plan.getNullKeyTableIndexs(), nil
+		}
 	case *sqlparser.RangeCond:
 		left := plan.getValueType(node.Left)
 		from := plan.getValueType(node.From)
@@ -460,10 +666,20 @@ func (plan *Plan) getInsertTableIndex(vals sqlparser.Values) ([]int, error) {
 			return nil, errors.ErrColsLenNotMatch
 		}
 
-		tableIndex, err := plan.getTableIndexByValue(valueExpression[plan.KeyIndex])
-		if err != nil {
-			return nil, err
+		var tableIndex int
+		var err error
+		if _, ok := valueExpression[plan.KeyIndex].(*sqlparser.NullVal); ok {
+			if plan.Rule.NullKeyTable < 0 {
+				return nil, errors.ErrNullShardKey
+			}
+			tableIndex = plan.Rule.NullKeyTable
+		} else {
+			tableIndex, err = plan.getTableIndexByValue(valueExpression[plan.KeyIndex])
+			if err != nil {
+				return nil, err
+			}
 		}
+		plan.addKeyValue(valueExpression[plan.KeyIndex])
 
 		tableIndexs = append(tableIndexs, tableIndex)
 		//get the rows insert into this table
@@ -483,6 +699,10 @@ func (plan *Plan) GetIRKeyIndex(cols sqlparser.Columns) error {
 		return errors.ErrNoPlanRule
 	}
 	plan.KeyIndex = -1
+	//the table is not sharded
+	if plan.Rule.Type == DefaultRuleType {
+		return nil
+	}
 	for i, _ := range cols {
 		colname := string(cols[i].(*sqlparser.NonStarExpr).Expr.(*sqlparser.ColName).Name)
 
@@ -498,8 +718,42 @@ func (plan *Plan) GetIRKeyIndex(cols sqlparser.Columns) error {
 }
 
 func (plan *Plan) getTableIndexByValue(valExpr sqlparser.ValExpr) (int, error) {
+	return plan.Rule.findKeyTableIndex(plan.getKeyValue(valExpr))
+}
+
+func (plan *Plan) addKeyValue(valExpr sqlparser.ValExpr) {
+	switch valExpr.(type) {
+	case sqlparser.StrVal, sqlparser.NumVal, *sqlparser.CharsetStrVal:
+		plan.KeyValues = append(plan.KeyValues, plan.getKeyValue(valExpr))
+	}
+}
+
+//the value of shard key in valExpr
+func (plan *Plan) getKeyValue(valExpr sqlparser.ValExpr) interface{} {
+	if plan.Rule.KeyType == UUIDKeyType {
+		return plan.getUUIDValue(valExpr)
+	}
 	value := plan.getBoundValue(valExpr)
-	return plan.Rule.FindTableIndex(value)
+	//the string key is compared by its collation in utf8, whatever the
+	//charset of client is
+	if s, ok := value.(string); ok && plan.Rule.collation != nil {
+		value = toUtf8(plan.getCharset(valExpr), s)
+	}
+	return value
+}
+
+//the charset of the string in valExpr, the introducer such as _latin1
+//overrides the charset of connection
+func (plan *Plan) getCharset(valExpr sqlparser.ValExpr) string {
+	switch node := valExpr.(type) {
+	case sqlparser.ValTuple:
+		if len(node) == 1 {
+			return plan.getCharset(node[0])
+		}
+	case *sqlparser.CharsetStrVal:
+		return strings.TrimPrefix(string(node.Charset), "_")
+	}
+	return plan.Charset
 }
 
 func (plan *Plan) adjustShardIndex(valExpr sqlparser.ValExpr, index int) int {
@@ -530,16 +784,68 @@ func (plan *Plan) getBoundValue(valExpr sqlparser.ValExpr) interface{} {
 		return plan.getBoundValue(node[0])
 	case sqlparser.StrVal:
 		return string(node)
+	case *sqlparser.CharsetStrVal:
+		return string(node.Val)
 	case sqlparser.NumVal:
-		val, err := strconv.ParseInt(string(node), 10, 64)
+		return getNumValue(string(node))
+	case sqlparser.ValArg:
+		panic("Unexpected token")
+	}
+	panic("Unexpected token")
+}
+
+//get the value of number literal. The hexadecimal and bit literals are
+//integers as in numeric context, the float equal to an integer is the
+//integer, and the other float is kept as string.
+func getNumValue(num string) interface{} {
+	s := strings.ToLower(num)
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+
+	base := 0
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		base, s = 16, s[2:]
+	case strings.HasPrefix(s, "x'"):
+		base, s = 16, s[2:len(s)-1]
+	case strings.HasPrefix(s, "0b"):
+		base, s = 2, s[2:]
+	case strings.HasPrefix(s, "b'"):
+		base, s = 2, s[2:len(s)-1]
+	}
+	if base != 0 {
+		val, err := strconv.ParseUint(s, base, 64)
 		if err != nil {
 			panic(sqlparser.NewParserError("%s", err.Error()))
 		}
+		if math.MaxInt64 < val {
+			if neg {
+				panic(sqlparser.NewParserError("%s out of range", num))
+			}
+			return val
+		}
+		if neg {
+			return -int64(val)
+		}
+		return int64(val)
+	}
+
+	if val, err := strconv.ParseInt(num, 10, 64); err == nil {
 		return val
-	case sqlparser.ValArg:
-		panic("Unexpected token")
 	}
-	panic("Unexpected token")
+	if val, err := strconv.ParseUint(num, 10, 64); err == nil {
+		return val
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		panic(sqlparser.NewParserError("%s", err.Error()))
+	}
+	if f == math.Trunc(f) && math.MinInt64 <= f && f < math.MaxInt64 {
+		return int64(f)
+	}
+	return num
 }
 
 /*2,5 ==> [2,3,4]*/
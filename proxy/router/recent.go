@@ -0,0 +1,148 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the select with this hint scans all the sub tables of a date table with
+//recent_shards, such as select /*all_shards*/ * from log
+const AllShardsHint = "/*all_shards*/"
+
+//IsDateShard return true if the sub tables are by year, month or day
+func (r *Rule) IsDateShard() bool {
+	switch r.Type {
+	case DateYearRuleType, DateMonthRuleType, DateDayRuleType:
+		return true
+	}
+	return false
+}
+
+//PeriodIndex return the sub table index of the period n periods after
+//the period of now, such as the next month of a date_month rule if n is 1
+func (r *Rule) PeriodIndex(now time.Time, n int) (int, error) {
+	switch r.Type {
+	case DateYearRuleType:
+		now = now.AddDate(n, 0, 0)
+	case DateMonthRuleType:
+		//from the first day, so Jan 31 is not moved to Mar
+		now = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, n, 0)
+	case DateDayRuleType:
+		now = now.AddDate(0, 0, n)
+	default:
+		return 0, fmt.Errorf("table %s is not date shard", r.Table)
+	}
+	return r.Shard.FindForKey(now.Unix())
+}
+
+//parse recent_shards and retain_shards of a date rule
+func parseDateShardCounts(r *Rule, cfg *config.ShardConfig) error {
+	counts := []struct {
+		name  string
+		value int
+		count *int
+	}{
+		{"recent_shards", cfg.RecentShards, &r.RecentShards},
+		{"retain_shards", cfg.RetainShards, &r.RetainShards},
+	}
+	for _, c := range counts {
+		if c.value == 0 {
+			continue
+		}
+		if !r.IsDateShard() {
+			return fmt.Errorf("%s of table %s is only for date shard", c.name, cfg.Table)
+		}
+		if c.value < 0 {
+			return fmt.Errorf("invalid %s %d of table %s", c.name, c.value, cfg.Table)
+		}
+		*c.count = c.value
+	}
+	return nil
+}
+
+//the latest RecentShards sub tables not after now, or all the sub tables
+//if they are all after now
+func (r *Rule) recentTableIndexs(now time.Time) []int {
+	current, err := r.Shard.FindForKey(now.Unix())
+	if err != nil {
+		return r.SubTableIndexs
+	}
+	var indexs []int
+	for _, index := range r.SubTableIndexs {
+		if index <= current {
+			indexs = append(indexs, index)
+		}
+	}
+	if len(indexs) == 0 {
+		return r.SubTableIndexs
+	}
+	sort.Ints(indexs)
+	if r.RecentShards < len(indexs) {
+		indexs = indexs[len(indexs)-r.RecentShards:]
+	}
+	return indexs
+}
+
+//limit the select without the condition of the shard key to the recent
+//sub tables, unless it has the hint of all shards. The plan is not cached,
+//since the recent sub tables change with the time.
+func (plan *Plan) limitRecentShards(stmt *sqlparser.Select, criteria sqlparser.BoolExpr) {
+	if plan.Rule.RecentShards == 0 || hasComment(stmt.Comments, AllShardsHint) {
+		return
+	}
+	if criteria != nil && plan.hasKeyCondition(criteria) {
+		return
+	}
+	plan.RouteTableIndexs = plan.Rule.recentTableIndexs(time.Now())
+	plan.Uncacheable = true
+	plan.RouteNodeIndexs = plan.TindexsToNindexs(plan.RouteTableIndexs)
+}
+
+//return true if the shard key is in a condition of expr
+func (plan *Plan) hasKeyCondition(expr sqlparser.BoolExpr) bool {
+	switch node := expr.(type) {
+	case *sqlparser.AndExpr:
+		return plan.hasKeyCondition(node.Left) || plan.hasKeyCondition(node.Right)
+	case *sqlparser.OrExpr:
+		return plan.hasKeyCondition(node.Left) || plan.hasKeyCondition(node.Right)
+	case *sqlparser.NotExpr:
+		return plan.hasKeyCondition(node.Expr)
+	case *sqlparser.ParenBoolExpr:
+		return plan.hasKeyCondition(node.Expr)
+	case *sqlparser.ComparisonExpr:
+		return plan.getValueType(node.Left) == EID_NODE || plan.getValueType(node.Right) == EID_NODE
+	case *sqlparser.RangeCond:
+		return plan.getValueType(node.Left) == EID_NODE
+	case *sqlparser.NullCheck:
+		return plan.getValueType(node.Expr) == EID_NODE
+	}
+	return false
+}
+
+func hasComment(comments sqlparser.Comments, comment string) bool {
+	for _, c := range comments {
+		if strings.EqualFold(string(c), comment) {
+			return true
+		}
+	}
+	return false
+}
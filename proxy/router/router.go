@@ -16,11 +16,15 @@ package router
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flike/kingshard/config"
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
 	"github.com/flike/kingshard/sqlparser"
 )
 
@@ -31,11 +35,24 @@ var (
 	DateYearRuleType  = "date_year"
 	DateMonthRuleType = "date_month"
 	DateDayRuleType   = "date_day"
+	SingleRuleType    = "single"    //not sharded, on a node other than the default
+	FederatedRuleType = "federated" //not sharded, the same table on each node, read only
 	MinMonthDaysCount = 28
 	MaxMonthDaysCount = 31
 	MonthsCount       = 12
 )
 
+//the operations which can be allowed on a table
+var Operations = []string{"select", "insert", "update", "delete", "replace", "truncate"}
+
+//the data type of shard key
+var (
+	IntKeyType    = "int"
+	StringKeyType = "string"
+	DateKeyType   = "date"
+	UUIDKeyType   = "uuid"
+)
+
 type Rule struct {
 	DB    string
 	Table string
@@ -46,13 +63,49 @@ type Rule struct {
 	SubTableIndexs []int       //SubTableIndexs store all the index of sharding sub-table
 	TableToNode    map[int]int //key is table index, and value is node index
 	Shard          Shard
+	NoRewrite      bool     //send the sql to the default node without parse
+	NullKeyTable   int      //the sub table of NULL shard key, -1 means none
+	KeyType        string   //the data type of shard key, empty means any
+	Operations     []string //the operations allowed, such as select, empty means all
+	RecentShards   int      //the count of sub tables scanned without date, 0 means all
+	RetainShards   int      //the count of sub tables kept by the date shard check, 0 means all
+	//the writes of the sub tables on the blackhole node are rejected
+	//instead of dropped
+	BlackholeReject bool
+	//the default consistency of the selects, empty means the one of user
+	Consistency string
+	//the predicate appended to the where of select, update and delete,
+	//empty means none
+	SoftDelete string
+	//the expression of shard key which derives the value routed, such as
+	//substr(order_no, 1, 6), empty means the value of shard key
+	KeyExpr string
+	//the binary uuids of the key are in the order of uuid_to_bin(uuid, 1)
+	UUIDSwapFlag bool
+	//the collation of the string key, empty means the key is hashed by
+	//its bytes in the charset of client
+	KeyCollation string
+
+	keyExpr    sqlparser.Expr //the parsed KeyExpr, nil if none
+	collation  *Collation     //the collation of KeyCollation, nil if none
+	ignoreCase bool           //compare the table name case-insensitively
+	pattern    *regexp.Regexp //the pattern of Table, nil if Table is a name
 }
 
 type Router struct {
 	//map[db]map[table_name]*Rule
-	Rules       map[string]map[string]*Rule
-	DefaultRule *Rule
-	Nodes       []string //just for human saw
+	Rules        map[string]map[string]*Rule
+	DefaultRule  *Rule
+	Nodes        []string //just for human saw
+	HasNoRewrite bool     //some tables are no_rewrite
+
+	//table and database names are compared in lower case if it is not 0
+	LowerCaseTableNames int
+
+	Tenancy *Tenancy //nil if tenancy is not set
+
+	subTables map[string]*Rule //sub table such as orders_0007 -> rule
+	patterns  patternRules     //the rules whose table is a pattern such as log_%
 }
 
 func NewDefaultRule(node string) *Rule {
@@ -65,6 +118,14 @@ func NewDefaultRule(node string) *Rule {
 	return r
 }
 
+//IsTable return true if name is the table of the rule
+func (r *Rule) IsTable(name string) bool {
+	if r.ignoreCase {
+		return strings.EqualFold(name, r.Table)
+	}
+	return name == r.Table
+}
+
 func (r *Rule) FindNode(key interface{}) (string, error) {
 	tableIndex, err := r.Shard.FindForKey(key)
 	if err != nil {
@@ -86,6 +147,65 @@ func (r *Rule) FindTableIndex(key interface{}) (int, error) {
 	return r.Shard.FindForKey(key)
 }
 
+//the table index of a value of shard key, which is checked by the key
+//type, normalized and derived by the key expression before routing
+func (r *Rule) findKeyTableIndex(value interface{}) (int, error) {
+	if err := r.checkKeyValue(value); err != nil {
+		return -1, err
+	}
+	value, err := r.normalizeKey(value)
+	if err != nil {
+		return -1, err
+	}
+	if value, err = r.deriveKey(value); err != nil {
+		return -1, err
+	}
+	return r.FindTableIndex(value)
+}
+
+//check the value is of the shard key type, so that a value such as
+//"123abc" is not hashed differently from the stored value of the column
+func (r *Rule) checkKeyValue(value interface{}) error {
+	switch r.KeyType {
+	case IntKeyType:
+		switch val := value.(type) {
+		case int, int64, uint64:
+			return nil
+		case string:
+			if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return nil
+			}
+			if _, err := strconv.ParseUint(val, 10, 64); err == nil {
+				return nil
+			}
+		}
+	case StringKeyType:
+		if _, ok := value.(string); ok {
+			return nil
+		}
+	case DateKeyType:
+		switch val := value.(type) {
+		case int, int64, uint64:
+			return nil
+		case string:
+			for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+				if _, err := time.Parse(layout, val); err == nil {
+					return nil
+				}
+			}
+		}
+	case UUIDKeyType:
+		if _, err := parseUUID(value, r.UUIDSwapFlag); err == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return mysql.NewError(mysql.ER_KS_KEY_TYPE_MISMATCH,
+		fmt.Sprintf("shard key %s of table %s is %s, the value %v does not match",
+			r.Key, r.Table, r.KeyType, value))
+}
+
 //UpdateExprs is the expression after set
 func (r *Rule) checkUpdateExprs(exprs sqlparser.UpdateExprs) error {
 	if r.Type == DefaultRuleType {
@@ -109,26 +229,76 @@ func NewRouter(schemaConfig *config.SchemaConfig) (*Router, error) {
 			schemaConfig.Default)
 	}
 
+	if schemaConfig.LowerCaseTableNames < 0 || 2 < schemaConfig.LowerCaseTableNames {
+		return nil, fmt.Errorf("invalid lower_case_table_names %d",
+			schemaConfig.LowerCaseTableNames)
+	}
+
 	rt := new(Router)
 	rt.Nodes = schemaConfig.Nodes //对应schema中的nodes
+	rt.LowerCaseTableNames = schemaConfig.LowerCaseTableNames
 	rt.Rules = make(map[string]map[string]*Rule)
+	rt.subTables = make(map[string]*Rule)
 	rt.DefaultRule = NewDefaultRule(schemaConfig.Default)
+	if err := rt.parseTenancy(&schemaConfig.Tenancy); err != nil {
+		return nil, err
+	}
+
+	if includeNode(rt.Nodes, BlackholeNode) {
+		return nil, fmt.Errorf("node name %s is reserved", BlackholeNode)
+	}
 
 	for _, shard := range schemaConfig.ShardRule {
 		for _, node := range shard.Nodes {
-			if !includeNode(rt.Nodes, node) {
+			if node != BlackholeNode && !includeNode(rt.Nodes, node) {
 				return nil, fmt.Errorf("shard table[%s] node[%s] not in the schema.nodes list:[%s]",
 					shard.Table, node, strings.Join(shard.Nodes, ","))
 			}
 		}
-		rule, err := parseRule(&shard)
+		if len(shard.Operations) != 0 && (shard.NoRewrite || shard.Type == SingleRuleType || shard.Type == FederatedRuleType) {
+			return nil, fmt.Errorf("operations of table[%s] is only for the sharded table", shard.Table)
+		}
+		if len(shard.Node) != 0 && !includeNode(rt.Nodes, shard.Node) {
+			return nil, fmt.Errorf("single table[%s] node[%s] not in the schema.nodes list",
+				shard.Table, shard.Node)
+		}
+		var rule *Rule
+		var err error
+		if shard.NoRewrite {
+			rule, err = parseNoRewriteRule(&shard, schemaConfig.Default)
+			rt.HasNoRewrite = true
+		} else if shard.Type == SingleRuleType {
+			rule, err = parseSingleRule(&shard)
+		} else if shard.Type == FederatedRuleType {
+			rule, err = parseFederatedRule(&shard)
+		} else {
+			rule, err = parseRule(&shard)
+		}
 		if err != nil {
 			return nil, err
 		}
+		rule.DB = rt.normalizeName(rule.DB)
+		rule.Table = rt.normalizeName(rule.Table)
+		rule.ignoreCase = rt.LowerCaseTableNames != 0
+		rule.Consistency = shard.Consistency
+		if err = parseSoftDelete(rule, &shard); err != nil {
+			return nil, err
+		}
 
-		if rule.Type == DefaultRuleType {
+		if rule.Type == DefaultRuleType && !rule.NoRewrite && shard.Type != SingleRuleType {
 			return nil, fmt.Errorf("[default-rule] duplicate, must only one")
 		}
+		if isTablePattern(rule.Table) {
+			//the regular expression is not lowered by lower_case_table_names
+			rule.Table = strings.Trim(shard.Table, "`")
+			if rule.pattern, err = compileTablePattern(rule.Table, rule.ignoreCase); err != nil {
+				return nil, err
+			}
+			if err = rt.patterns.add(rule); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		//if the database exist in rules
 		if _, ok := rt.Rules[rule.DB]; ok {
 			if _, ok := rt.Rules[rule.DB][rule.Table]; ok {
@@ -141,17 +311,32 @@ func NewRouter(schemaConfig *config.SchemaConfig) (*Router, error) {
 			rt.Rules[rule.DB] = m
 			rt.Rules[rule.DB][rule.Table] = rule
 		}
+		for _, tableIndex := range rule.SubTableIndexs {
+			rt.subTables[fmt.Sprintf("%s_%04d", rule.Table, tableIndex)] = rule
+		}
 	}
 	return rt, nil
 }
 
+//normalize the table or database name by quoting and lower_case_table_names
+func (r *Router) normalizeName(name string) string {
+	name = strings.Trim(name, "`")
+	if r.LowerCaseTableNames != 0 {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
 func (r *Router) GetRule(db, table string) *Rule {
 	arry := strings.Split(table, ".")
 	if len(arry) == 2 {
-		table = strings.Trim(arry[1], "`")
-		db = strings.Trim(arry[0], "`")
+		table = arry[1]
+		db = arry[0]
 	}
-	rule := r.Rules[db][table]
+	//the tables in the schema of a tenant use the rules of logical database
+	db = r.logicalDB(r.normalizeName(db))
+	table = r.normalizeName(table)
+	rule := r.findRule(db, table)
 	if rule == nil {
 		//set the database of default rule
 		r.DefaultRule.DB = db
@@ -161,6 +346,15 @@ func (r *Router) GetRule(db, table string) *Rule {
 	}
 }
 
+//the rule of the normalized db.table, the rule of the same name takes
+//precedence over the patterns, nil if none
+func (r *Router) findRule(db, table string) *Rule {
+	if rule := r.Rules[db][table]; rule != nil {
+		return rule
+	}
+	return r.patterns.match(db, table)
+}
+
 func parseRule(cfg *config.ShardConfig) (*Rule, error) {
 	r := new(Rule)
 	r.DB = cfg.DB
@@ -169,6 +363,7 @@ func parseRule(cfg *config.ShardConfig) (*Rule, error) {
 	r.Type = cfg.Type
 	r.Nodes = cfg.Nodes //将ruleconfig中的nodes赋值给rule
 	r.TableToNode = make(map[int]int, 0)
+	r.NullKeyTable = -1
 
 	switch r.Type {
 	case HashRuleType, RangeRuleType:
@@ -227,17 +422,165 @@ func parseRule(cfg *config.ShardConfig) (*Rule, error) {
 		}
 	}
 
+	if err := parseKeyType(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseUUIDSwapFlag(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseKeyExpr(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseKeyCollation(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseOperations(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseDateShardCounts(r, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseBlackhole(r, cfg); err != nil {
+		return nil, err
+	}
+
 	if err := parseShard(r, cfg); err != nil {
 		return nil, err
 	}
 
+	if cfg.NullKeyTable != nil {
+		if _, ok := r.TableToNode[*cfg.NullKeyTable]; !ok {
+			return nil, fmt.Errorf("null_key_table %d of table %s is not a sub table",
+				*cfg.NullKeyTable, cfg.Table)
+		}
+		r.NullKeyTable = *cfg.NullKeyTable
+	}
+
+	return r, nil
+}
+
+//IsNoRewriteTable return true if the table is configured no_rewrite
+func (r *Router) IsNoRewriteTable(db, table string) bool {
+	rule := r.findRule(r.normalizeName(db), r.normalizeName(table))
+	return rule != nil && rule.NoRewrite
+}
+
+//a no_rewrite table is not sharded, and its sqls go to the default node
+func parseNoRewriteRule(cfg *config.ShardConfig, defaultNode string) (*Rule, error) {
+	if len(cfg.Type) != 0 && cfg.Type != DefaultRuleType {
+		return nil, fmt.Errorf("no_rewrite table %s can not be sharded", cfg.Table)
+	}
+	r := NewDefaultRule(defaultNode)
+	r.DB = cfg.DB
+	r.Table = cfg.Table
+	r.NoRewrite = true
+	return r, nil
+}
+
+//a single table is not sharded like the tables of default rule, but its
+//sqls go to its own node instead of the default node
+func parseSingleRule(cfg *config.ShardConfig) (*Rule, error) {
+	if len(cfg.Node) == 0 {
+		return nil, fmt.Errorf("single table %s has no node", cfg.Table)
+	}
+	r := NewDefaultRule(cfg.Node)
+	r.DB = cfg.DB
+	r.Table = cfg.Table
 	return r, nil
 }
 
+//a federated table is not sharded, the same table is on each of its
+//nodes, such as the regional clusters of the same schema. Its selects are
+//sent to all the nodes and merged, and its writes are rejected.
+func parseFederatedRule(cfg *config.ShardConfig) (*Rule, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, fmt.Errorf("federated table %s has no nodes", cfg.Table)
+	}
+	if includeNode(cfg.Nodes, BlackholeNode) {
+		return nil, fmt.Errorf("federated table %s can not be on node %s", cfg.Table, BlackholeNode)
+	}
+	r := new(Rule)
+	r.DB = cfg.DB
+	r.Table = cfg.Table
+	r.Type = FederatedRuleType
+	r.Nodes = cfg.Nodes
+	r.Shard = new(DefaultShard)
+	r.NullKeyTable = -1
+	r.Operations = []string{"select"}
+	return r, nil
+}
+
+//IsUnsharded return true if db.table is not sharded, such as the tables
+//of default rule and the single tables
+func (r *Router) IsUnsharded(db, table string) bool {
+	return r.GetRule(db, table).Type == DefaultRuleType
+}
+
+//SingleTableNode return the node of db.table if it is a single table,
+//or "" if not
+func (r *Router) SingleTableNode(db, table string) string {
+	rule := r.GetRule(db, table)
+	if rule == r.DefaultRule || rule.Type != DefaultRuleType || rule.NoRewrite {
+		return ""
+	}
+	return rule.Nodes[0]
+}
+
+func parseOperations(r *Rule, cfg *config.ShardConfig) error {
+	for _, op := range cfg.Operations {
+		op = strings.ToLower(op)
+		if !includeNode(Operations, op) {
+			return fmt.Errorf("invalid operation %s of table %s", op, cfg.Table)
+		}
+		r.Operations = append(r.Operations, op)
+	}
+	return nil
+}
+
+//checkOperation return an error if op is not allowed on the table
+func (r *Rule) checkOperation(op string) error {
+	if len(r.Operations) == 0 || includeNode(r.Operations, op) {
+		return nil
+	}
+	return mysql.NewError(mysql.ER_KS_OPERATION_DENIED,
+		fmt.Sprintf("%s on table %s is not allowed, only %s",
+			op, r.Table, strings.Join(r.Operations, ",")))
+}
+
+func parseKeyType(r *Rule, cfg *config.ShardConfig) error {
+	r.KeyType = strings.ToLower(cfg.KeyType)
+	switch r.KeyType {
+	case "", IntKeyType:
+		return nil
+	case StringKeyType:
+		if r.Type == HashRuleType {
+			return nil
+		}
+	case DateKeyType, UUIDKeyType:
+		if r.Type == HashRuleType || r.Type == DateDayRuleType ||
+			r.Type == DateMonthRuleType || r.Type == DateYearRuleType {
+			return nil
+		}
+	default:
+		return fmt.Errorf("invalid key_type %s of table %s", cfg.KeyType, cfg.Table)
+	}
+	return fmt.Errorf("key_type %s of table %s is not supported by %s shard",
+		cfg.KeyType, cfg.Table, r.Type)
+}
+
 func parseShard(r *Rule, cfg *config.ShardConfig) error {
 	switch r.Type {
 	case HashRuleType:
-		r.Shard = &HashShard{ShardNum: len(r.TableToNode)}
+		r.Shard = &HashShard{
+			ShardNum:  len(r.TableToNode),
+			Collation: r.collation,
+		}
 	case RangeRuleType:
 		rs, err := ParseNumSharding(cfg.Locations, cfg.TableRowLimit)
 		if err != nil {
@@ -271,39 +614,106 @@ func includeNode(nodes []string, node string) bool {
 	return false
 }
 
+func isInnerJoin(join string) bool {
+	switch join {
+	case sqlparser.AST_JOIN, sqlparser.AST_STRAIGHT_JOIN, sqlparser.AST_CROSS_JOIN:
+		return true
+	}
+	return false
+}
+
 //build a router plan
 func (r *Router) BuildPlan(db string, statement sqlparser.Statement) (*Plan, error) {
+	return r.BuildPlanWithCharset(db, "", statement)
+}
+
+//build a router plan, the strings in statement are in the charset
+func (r *Router) BuildPlanWithCharset(db, charset string, statement sqlparser.Statement) (*Plan, error) {
 	//因为实现Statement接口的方法都是指针类型，所以type对应类型也是指针类型
 	switch stmt := statement.(type) {
 	case *sqlparser.Insert:
-		return r.buildInsertPlan(db, stmt)
+		return r.buildInsertPlan(db, charset, stmt)
 	case *sqlparser.Replace:
-		return r.buildReplacePlan(db, stmt)
+		return r.buildReplacePlan(db, charset, stmt)
 	case *sqlparser.Select:
-		return r.buildSelectPlan(db, stmt)
+		return r.buildSelectPlan(db, charset, stmt)
 	case *sqlparser.Update:
-		return r.buildUpdatePlan(db, stmt)
+		return r.buildUpdatePlan(db, charset, stmt)
 	case *sqlparser.Delete:
-		return r.buildDeletePlan(db, stmt)
+		return r.buildDeletePlan(db, charset, stmt)
 	case *sqlparser.Truncate:
-		return r.buildTruncatePlan(db, stmt)
+		return r.buildTruncatePlan(db, charset, stmt)
 	}
 	return nil, errors.ErrNoPlan
 }
 
-func (r *Router) buildSelectPlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+//PinPlan return a copy of plan which only has the sqls of the sub table
+//tableIndex, the sessions pinned to a shard use it. It fails if the sql is
+//routed to other sub tables by the shard key. The plan of a table which is
+//not sharded is returned unchanged.
+func (r *Router) PinPlan(plan *Plan, statement sqlparser.Statement, tableIndex int) (*Plan, error) {
+	if plan.Rule.Type == DefaultRuleType || plan.Rule.NoRewrite || plan.Blackhole {
+		return plan, nil
+	}
+	if _, ok := plan.Rule.TableToNode[tableIndex]; !ok {
+		return nil, fmt.Errorf("table %s has no shard %d", plan.Rule.Table, tableIndex)
+	}
+	switch statement.(type) {
+	case *sqlparser.Insert, *sqlparser.Replace:
+		//all the rows must be in the pinned shard
+		if len(plan.RouteTableIndexs) != 1 || plan.RouteTableIndexs[0] != tableIndex {
+			return nil, errors.ErrNotPinnedShard
+		}
+		return plan, nil
+	}
+	if len(interList(plan.RouteTableIndexs, []int{tableIndex})) == 0 {
+		return nil, errors.ErrNotPinnedShard
+	}
+
+	p := *plan
+	p.RouteTableIndexs = []int{tableIndex}
+	p.RouteNodeIndexs = p.TindexsToNindexs(p.RouteTableIndexs)
+	var err error
+	switch stmt := statement.(type) {
+	case *sqlparser.Select:
+		err = r.generateSelectSql(&p, stmt)
+	case *sqlparser.Update:
+		err = r.generateUpdateSql(&p, stmt)
+	case *sqlparser.Delete:
+		err = r.generateDeleteSql(&p, stmt)
+	case *sqlparser.Truncate:
+		err = r.generateTruncateSql(&p, stmt)
+	default:
+		return nil, errors.ErrNoPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *Router) buildSelectPlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	var where *sqlparser.Where
+	var on sqlparser.BoolExpr
 	var err error
 	var tableName string
 
 	stmt := statement.(*sqlparser.Select)
+	var joinOn sqlparser.BoolExpr
 	switch v := (stmt.From[0]).(type) {
 	case *sqlparser.AliasedTableExpr:
 		tableName = sqlparser.String(v.Expr)
+		plan.TableAlias = string(v.As)
 	case *sqlparser.JoinTableExpr:
 		if ate, ok := (v.LeftExpr).(*sqlparser.AliasedTableExpr); ok {
 			tableName = sqlparser.String(ate.Expr)
+			plan.TableAlias = string(ate.As)
+			joinOn = v.On
+			//the on clause of inner join filters the shard table as where
+			if v.On != nil && isInnerJoin(v.Join) {
+				on = v.On
+			}
 		} else {
 			tableName = sqlparser.String(v)
 		}
@@ -312,10 +722,43 @@ func (r *Router) buildSelectPlan(db string, statement sqlparser.Statement) (*Pla
 	}
 
 	plan.Rule = r.GetRule(db, tableName) //根据表名获得分表规则
+	if err = plan.Rule.checkOperation("select"); err != nil {
+		return nil, err
+	}
+	if stmt.Where != nil {
+		if err = plan.checkHiddenTable(stmt.Where.Expr, "where clause"); err != nil {
+			return nil, err
+		}
+	}
+	if joinOn != nil {
+		if err = plan.checkHiddenTable(joinOn, "on clause"); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Where = plan.addSoftDelete(stmt.Comments, stmt.Where)
+	if plan.Rule.Type == FederatedRuleType {
+		r.generateFederatedSelectSql(plan, stmt)
+		return plan, nil
+	}
 	where = stmt.Where
 
+	var criteria sqlparser.BoolExpr
 	if where != nil {
-		plan.Criteria = where.Expr //路由条件
+		criteria = where.Expr
+	}
+	if on != nil {
+		if criteria != nil {
+			criteria = &sqlparser.AndExpr{Left: criteria, Right: on}
+		} else {
+			criteria = on
+		}
+	}
+
+	if criteria != nil {
+		plan.Criteria = criteria //路由条件
+		if plan.Rule.Type != DefaultRuleType {
+			plan.Criteria = plan.propagateKeyEquality(criteria)
+		}
 		err = plan.calRouteIndexs()
 		if err != nil {
 			golog.Error("Route", "BuildSelectPlan", err.Error(), 0)
@@ -326,6 +769,13 @@ func (r *Router) buildSelectPlan(db string, statement sqlparser.Statement) (*Pla
 		plan.RouteTableIndexs = plan.Rule.SubTableIndexs
 		plan.RouteNodeIndexs = makeList(0, len(plan.Rule.Nodes))
 	}
+	plan.limitRecentShards(stmt, criteria)
+	if err = plan.dropBlackhole("select"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	if plan.Rule.Type != DefaultRuleType && len(plan.RouteTableIndexs) == 0 {
 		golog.Error("Route", "BuildSelectPlan", errors.ErrNoCriteria.Error(), 0)
@@ -339,8 +789,8 @@ func (r *Router) buildSelectPlan(db string, statement sqlparser.Statement) (*Pla
 	return plan, nil
 }
 
-func (r *Router) buildInsertPlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+func (r *Router) buildInsertPlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	plan.Rows = make(map[int]sqlparser.Values)
 	stmt := statement.(*sqlparser.Insert)
 	if _, ok := stmt.Rows.(sqlparser.SelectStatement); ok {
@@ -353,6 +803,15 @@ func (r *Router) buildInsertPlan(db string, statement sqlparser.Statement) (*Pla
 
 	//根据sql语句的表，获得对应的分片规则
 	plan.Rule = r.GetRule(db, sqlparser.String(stmt.Table))
+	if err := plan.Rule.checkOperation("insert"); err != nil {
+		return nil, err
+	}
+	//insert on duplicate key update changes the rows existing
+	if stmt.OnDup != nil {
+		if err := plan.Rule.checkOperation("update"); err != nil {
+			return nil, err
+		}
+	}
 
 	err := plan.GetIRKeyIndex(stmt.Columns)
 	if err != nil {
@@ -373,6 +832,12 @@ func (r *Router) buildInsertPlan(db string, statement sqlparser.Statement) (*Pla
 		golog.Error("Route", "BuildInsertPlan", err.Error(), 0)
 		return nil, err
 	}
+	if err = plan.dropBlackhole("insert"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	err = r.generateInsertSql(plan, stmt)
 	if err != nil {
@@ -381,12 +846,16 @@ func (r *Router) buildInsertPlan(db string, statement sqlparser.Statement) (*Pla
 	return plan, nil
 }
 
-func (r *Router) buildUpdatePlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+func (r *Router) buildUpdatePlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	var where *sqlparser.Where
 
 	stmt := statement.(*sqlparser.Update)
 	plan.Rule = r.GetRule(db, sqlparser.String(stmt.Table))
+	if err := plan.Rule.checkOperation("update"); err != nil {
+		return nil, err
+	}
+	stmt.Where = plan.addSoftDelete(stmt.Comments, stmt.Where)
 	err := plan.Rule.checkUpdateExprs(stmt.Exprs)
 	if err != nil {
 		return nil, err
@@ -405,6 +874,12 @@ func (r *Router) buildUpdatePlan(db string, statement sqlparser.Statement) (*Pla
 		plan.RouteTableIndexs = plan.Rule.SubTableIndexs
 		plan.RouteNodeIndexs = makeList(0, len(plan.Rule.Nodes))
 	}
+	if err = plan.dropBlackhole("update"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	if plan.Rule.Type != DefaultRuleType && len(plan.RouteTableIndexs) == 0 {
 		golog.Error("Route", "BuildUpdatePlan", errors.ErrNoCriteria.Error(), 0)
@@ -418,13 +893,17 @@ func (r *Router) buildUpdatePlan(db string, statement sqlparser.Statement) (*Pla
 	return plan, nil
 }
 
-func (r *Router) buildDeletePlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+func (r *Router) buildDeletePlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	var where *sqlparser.Where
 	var err error
 
 	stmt := statement.(*sqlparser.Delete)
 	plan.Rule = r.GetRule(db, sqlparser.String(stmt.Table))
+	if err = plan.Rule.checkOperation("delete"); err != nil {
+		return nil, err
+	}
+	stmt.Where = plan.addSoftDelete(stmt.Comments, stmt.Where)
 	where = stmt.Where
 
 	if where != nil {
@@ -439,6 +918,12 @@ func (r *Router) buildDeletePlan(db string, statement sqlparser.Statement) (*Pla
 		plan.RouteTableIndexs = plan.Rule.SubTableIndexs
 		plan.RouteNodeIndexs = makeList(0, len(plan.Rule.Nodes))
 	}
+	if err = plan.dropBlackhole("delete"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	if plan.Rule.Type != DefaultRuleType && len(plan.RouteTableIndexs) == 0 {
 		golog.Error("Route", "BuildDeletePlan", errors.ErrNoCriteria.Error(), 0)
@@ -452,15 +937,24 @@ func (r *Router) buildDeletePlan(db string, statement sqlparser.Statement) (*Pla
 	return plan, nil
 }
 
-func (r *Router) buildTruncatePlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+func (r *Router) buildTruncatePlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	var err error
 
 	stmt := statement.(*sqlparser.Truncate)
 	plan.Rule = r.GetRule(db, sqlparser.String(stmt.Table))
+	if err = plan.Rule.checkOperation("truncate"); err != nil {
+		return nil, err
+	}
 	//send to all nodes and all tables
 	plan.RouteTableIndexs = plan.Rule.SubTableIndexs
 	plan.RouteNodeIndexs = makeList(0, len(plan.Rule.Nodes))
+	if err = plan.dropBlackhole("truncate"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	if plan.Rule.Type != DefaultRuleType && len(plan.RouteTableIndexs) == 0 {
 		golog.Error("Route", "buildTruncatePlan", errors.ErrNoCriteria.Error(), 0)
@@ -474,8 +968,8 @@ func (r *Router) buildTruncatePlan(db string, statement sqlparser.Statement) (*P
 	return plan, nil
 }
 
-func (r *Router) buildReplacePlan(db string, statement sqlparser.Statement) (*Plan, error) {
-	plan := &Plan{}
+func (r *Router) buildReplacePlan(db, charset string, statement sqlparser.Statement) (*Plan, error) {
+	plan := &Plan{Charset: charset}
 	plan.Rows = make(map[int]sqlparser.Values)
 
 	stmt := statement.(*sqlparser.Replace)
@@ -488,6 +982,9 @@ func (r *Router) buildReplacePlan(db string, statement sqlparser.Statement) (*Pl
 	}
 
 	plan.Rule = r.GetRule(db, sqlparser.String(stmt.Table))
+	if err := plan.Rule.checkOperation("replace"); err != nil {
+		return nil, err
+	}
 
 	err := plan.GetIRKeyIndex(stmt.Columns)
 	if err != nil {
@@ -501,6 +998,12 @@ func (r *Router) buildReplacePlan(db string, statement sqlparser.Statement) (*Pl
 		golog.Error("Route", "BuildReplacePlan", err.Error(), 0)
 		return nil, err
 	}
+	if err = plan.dropBlackhole("replace"); err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return plan, nil
+	}
 
 	err = r.generateReplaceSql(plan, stmt)
 	if err != nil {
@@ -523,11 +1026,10 @@ func (r *Router) rewriteSelectSql(plan *Plan, node *sqlparser.Select, tableIndex
 		switch v := expr.(type) {
 		case *sqlparser.StarExpr:
 			//for shardTable.*,need replace table into shardTable_xxxx.
-			if string(v.TableName) == plan.Rule.Table {
-				fmt.Fprintf(buf, "%s%s_%04d.*",
+			if plan.Rule.IsTable(string(v.TableName)) {
+				fmt.Fprintf(buf, "%s%s.*",
 					prefix,
-					plan.Rule.Table,
-					tableIndex,
+					subTableName(plan.Rule.Table, tableIndex),
 				)
 			} else {
 				buf.Fprintf("%s%v", prefix, expr)
@@ -536,11 +1038,10 @@ func (r *Router) rewriteSelectSql(plan *Plan, node *sqlparser.Select, tableIndex
 			//rewrite shardTable.column as a
 			//into shardTable_xxxx.column as a
 			if colName, ok := v.Expr.(*sqlparser.ColName); ok {
-				if string(colName.Qualifier) == plan.Rule.Table {
-					fmt.Fprintf(buf, "%s%s_%04d.%s",
+				if plan.Rule.IsTable(string(colName.Qualifier)) {
+					fmt.Fprintf(buf, "%s%s.%s",
 						prefix,
-						plan.Rule.Table,
-						tableIndex,
+						subTableName(plan.Rule.Table, tableIndex),
 						string(colName.Name),
 					)
 				} else {
@@ -565,50 +1066,45 @@ func (r *Router) rewriteSelectSql(plan *Plan, node *sqlparser.Select, tableIndex
 			buf.Fprintf("%s%v", prefix, n)
 		}
 	}
+	//rewrite where in, also in the on clause
+	oldright, err := plan.rewriteWhereIn(tableIndex)
+
 	buf.Fprintf(" from ")
 	switch v := (node.From[0]).(type) {
 	case *sqlparser.AliasedTableExpr:
 		if len(v.As) != 0 {
-			fmt.Fprintf(buf, "%s_%04d as %s",
-				sqlparser.String(v.Expr),
-				tableIndex,
+			fmt.Fprintf(buf, "%s as %s",
+				subTableName(sqlparser.String(v.Expr), tableIndex),
 				string(v.As),
 			)
 		} else {
-			fmt.Fprintf(buf, "%s_%04d",
-				sqlparser.String(v.Expr),
-				tableIndex,
-			)
+			buf.WriteString(subTableName(sqlparser.String(v.Expr), tableIndex))
+		}
+		if v.Hints != nil {
+			buf.Fprintf("%v", v.Hints)
 		}
 	case *sqlparser.JoinTableExpr:
 		if ate, ok := (v.LeftExpr).(*sqlparser.AliasedTableExpr); ok {
 			if len(ate.As) != 0 {
-				fmt.Fprintf(buf, "%s_%04d as %s",
-					sqlparser.String(ate.Expr),
-					tableIndex,
+				fmt.Fprintf(buf, "%s as %s",
+					subTableName(sqlparser.String(ate.Expr), tableIndex),
 					string(ate.As),
 				)
 			} else {
-				fmt.Fprintf(buf, "%s_%04d",
-					sqlparser.String(ate.Expr),
-					tableIndex,
-				)
+				buf.WriteString(subTableName(sqlparser.String(ate.Expr), tableIndex))
+			}
+			if ate.Hints != nil {
+				buf.Fprintf("%v", ate.Hints)
 			}
 		} else {
-			fmt.Fprintf(buf, "%s_%04d",
-				sqlparser.String(v.LeftExpr),
-				tableIndex,
-			)
+			buf.WriteString(subTableName(sqlparser.String(v.LeftExpr), tableIndex))
 		}
 		buf.Fprintf(" %s %v", v.Join, v.RightExpr)
 		if v.On != nil {
 			buf.Fprintf(" on %v", v.On)
 		}
 	default:
-		fmt.Fprintf(buf, "%s_%04d",
-			sqlparser.String(node.From[0]),
-			tableIndex,
-		)
+		buf.WriteString(subTableName(sqlparser.String(node.From[0]), tableIndex))
 	}
 	//append other tables
 	prefix = ", "
@@ -621,17 +1117,31 @@ func (r *Router) rewriteSelectSql(plan *Plan, node *sqlparser.Select, tableIndex
 		//do not change limit
 		newLimit = node.Limit
 	}
-	//rewrite where
-	oldright, err := plan.rewriteWhereIn(tableIndex)
-
+	//the soft delete columns may be ambiguous in the joins
+	isJoin := len(node.From) > 1
+	if _, ok := node.From[0].(*sqlparser.JoinTableExpr); ok {
+		isJoin = true
+	}
+	if isJoin {
+		plan.qualifySoftDelete(subTableName(plan.Rule.Table, tableIndex))
+	}
+	having := node.Having
+	if plan.PostHaving {
+		//the limit is applied after the having filter by kingshard
+		having = nil
+		newLimit = nil
+	}
 	buf.Fprintf("%v%v%v%v%v%s",
 		node.Where,
 		node.GroupBy,
-		node.Having,
+		having,
 		node.OrderBy,
 		newLimit,
 		node.Lock,
 	)
+	if isJoin {
+		plan.qualifySoftDelete("")
+	}
 	//restore old right
 	if oldright != nil {
 		plan.InRightToReplace.Right = oldright
@@ -639,6 +1149,40 @@ func (r *Router) rewriteSelectSql(plan *Plan, node *sqlparser.Select, tableIndex
 	return buf.String()
 }
 
+//the name of the sub table tableIndex of table, or table itself if
+//tableIndex is negative, such as a federated table
+func subTableName(table string, tableIndex int) string {
+	if tableIndex < 0 {
+		return table
+	}
+	return fmt.Sprintf("%s_%04d", table, tableIndex)
+}
+
+//the having of an aggregate select routed to more than one sub table is
+//filtered by kingshard after the groups are merged, as each sub table has
+//only a part of a group
+func isPostHaving(node *sqlparser.Select, tableCount int) bool {
+	if node.Having == nil || tableCount < 2 {
+		return false
+	}
+	if len(node.GroupBy) != 0 {
+		return true
+	}
+	for _, expr := range node.SelectExprs {
+		e, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			continue
+		}
+		if f, ok := e.Expr.(*sqlparser.FuncExpr); ok {
+			switch strings.ToLower(string(f.Name)) {
+			case "sum", "count", "max", "min":
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (r *Router) generateSelectSql(plan *Plan, stmt sqlparser.Statement) error {
 	sqls := make(map[string][]string)
 	node, ok := stmt.(*sqlparser.Select)
@@ -651,14 +1195,15 @@ func (r *Router) generateSelectSql(plan *Plan, stmt sqlparser.Statement) error {
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
+		plan.PostHaving = isPostHaving(node, tableCount)
 		for i := 0; i < tableCount; i++ {
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 			selectSql := r.rewriteSelectSql(plan, node, tableIndex)
 			if _, ok := sqls[nodeName]; ok == false {
 				sqls[nodeName] = make([]string, 0, tableCount)
@@ -670,6 +1215,18 @@ func (r *Router) generateSelectSql(plan *Plan, stmt sqlparser.Statement) error {
 	return nil
 }
 
+//the select of a federated table is sent to all its nodes without the
+//table renamed, the limit and having are rewritten for the merge
+func (r *Router) generateFederatedSelectSql(plan *Plan, stmt *sqlparser.Select) {
+	plan.RouteNodeIndexs = makeList(0, len(plan.Rule.Nodes))
+	plan.PostHaving = isPostHaving(stmt, len(plan.Rule.Nodes))
+	sqls := make(map[string][]string, len(plan.Rule.Nodes))
+	for _, nodeName := range plan.Rule.Nodes {
+		sqls[nodeName] = []string{r.rewriteSelectSql(plan, stmt, -1)}
+	}
+	plan.RewrittenSqls = sqls
+}
+
 func (r *Router) generateInsertSql(plan *Plan, stmt sqlparser.Statement) error {
 	sqls := make(map[string][]string)
 	node, ok := stmt.(*sqlparser.Insert)
@@ -682,7 +1239,7 @@ func (r *Router) generateInsertSql(plan *Plan, stmt sqlparser.Statement) error {
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
@@ -690,9 +1247,13 @@ func (r *Router) generateInsertSql(plan *Plan, stmt sqlparser.Statement) error {
 			buf := sqlparser.NewTrackedBuffer(nil)
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 
-			buf.Fprintf("insert %v%s into %v", node.Comments, node.Ignore, node.Table)
+			buf.Fprintf("insert %v", node.Comments)
+			if node.Ignore != "" {
+				buf.Fprintf("%s ", node.Ignore)
+			}
+			buf.Fprintf("into %v", node.Table)
 			fmt.Fprintf(buf, "_%04d", plan.RouteTableIndexs[i])
 			buf.Fprintf("%v %v%v",
 				node.Columns,
@@ -722,7 +1283,7 @@ func (r *Router) generateUpdateSql(plan *Plan, stmt sqlparser.Statement) error {
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
@@ -741,7 +1302,7 @@ func (r *Router) generateUpdateSql(plan *Plan, stmt sqlparser.Statement) error {
 			)
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 			if _, ok := sqls[nodeName]; ok == false {
 				sqls[nodeName] = make([]string, 0, tableCount)
 			}
@@ -765,7 +1326,7 @@ func (r *Router) generateDeleteSql(plan *Plan, stmt sqlparser.Statement) error {
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
@@ -783,7 +1344,7 @@ func (r *Router) generateDeleteSql(plan *Plan, stmt sqlparser.Statement) error {
 			)
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 			if _, ok := sqls[nodeName]; ok == false {
 				sqls[nodeName] = make([]string, 0, tableCount)
 			}
@@ -807,14 +1368,14 @@ func (r *Router) generateReplaceSql(plan *Plan, stmt sqlparser.Statement) error
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
 		for i := 0; i < tableCount; i++ {
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 
 			buf := sqlparser.NewTrackedBuffer(nil)
 			buf.Fprintf("replace %vinto %v",
@@ -850,7 +1411,7 @@ func (r *Router) generateTruncateSql(plan *Plan, stmt sqlparser.Statement) error
 	if len(plan.RouteTableIndexs) == 0 {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		stmt.Format(buf)
-		nodeName := r.Nodes[0]
+		nodeName := plan.Rule.Nodes[0]
 		sqls[nodeName] = []string{buf.String()}
 	} else {
 		tableCount := len(plan.RouteTableIndexs)
@@ -864,7 +1425,7 @@ func (r *Router) generateTruncateSql(plan *Plan, stmt sqlparser.Statement) error
 			fmt.Fprintf(buf, "_%04d", plan.RouteTableIndexs[i])
 			tableIndex := plan.RouteTableIndexs[i]
 			nodeIndex := plan.Rule.TableToNode[tableIndex]
-			nodeName := r.Nodes[nodeIndex]
+			nodeName := plan.Rule.Nodes[nodeIndex]
 			if _, ok := sqls[nodeName]; ok == false {
 				sqls[nodeName] = make([]string, 0, tableCount)
 			}
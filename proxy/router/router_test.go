@@ -16,11 +16,16 @@ package router
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
 	"github.com/flike/kingshard/sqlparser"
 )
 
@@ -236,6 +241,436 @@ schema:
 
 }
 
+func TestNoRewriteRule(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: test_raw
+      no_rewrite: true
+    -
+      db: kingshard
+      table: test_shard_hash
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rt.HasNoRewrite {
+		t.Fatal("router should have no_rewrite tables")
+	}
+	if !rt.IsNoRewriteTable("kingshard", "test_raw") || rt.IsNoRewriteTable("kingshard", "test_shard_hash") {
+		t.Fatal("no_rewrite table parse not correct.")
+	}
+
+	rule := rt.GetRule("kingshard", "test_raw")
+	if rule.Type != DefaultRuleType || rule.Nodes[0] != "node1" {
+		t.Fatal(rule.Type, rule.Nodes)
+	}
+
+	cfg.Schema.ShardRule[1].NoRewrite = true
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("sharding table can not be no_rewrite")
+	}
+}
+
+func TestSingleRule(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2, node3]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: sessions
+      type: single
+      node: node3
+    -
+      db: kingshard
+      table: test_shard_hash
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := rt.SingleTableNode("kingshard", "sessions"); n != "node3" {
+		t.Fatal(n)
+	}
+	for _, table := range []string{"test_shard_hash", "test_unsharded"} {
+		if n := rt.SingleTableNode("kingshard", table); n != "" {
+			t.Fatal(table, n)
+		}
+	}
+
+	for _, sql := range []string{
+		"select * from sessions where id = 1",
+		"insert into sessions(id) values (1)",
+		"update sessions set a = 1 where id = 1",
+		"delete from sessions where id = 1",
+	} {
+		stmt, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.RewrittenSqls) != 1 || len(plan.RewrittenSqls["node3"]) != 1 {
+			t.Fatal(sql, plan.RewrittenSqls)
+		}
+	}
+
+	cfg.Schema.ShardRule[0].Node = "node4"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("the node of single table must be in schema")
+	}
+	cfg.Schema.ShardRule[0].Node = ""
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("single table must have a node")
+	}
+}
+
+func TestLowerCaseTableNames(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: Orders
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.GetRule("kingshard", "`Orders`") == rt.DefaultRule ||
+		rt.GetRule("kingshard", "`kingshard`.`Orders`") == rt.DefaultRule {
+		t.Fatal("backquoted table name should match")
+	}
+	if rt.GetRule("kingshard", "orders") != rt.DefaultRule {
+		t.Fatal("table name is case-sensitive if lower_case_table_names is 0")
+	}
+
+	cfg.Schema.LowerCaseTableNames = 1
+	rt, err = NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"orders", "ORDERS", "`Orders`", "KingShard.`ORDERS`"} {
+		if rt.GetRule("kingshard", table) == rt.DefaultRule {
+			t.Fatal(table, "should match the rule")
+		}
+	}
+
+	stmt, err := sqlparser.Parse("select ORDERS.* from `ORDERS` where Orders.id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := rt.BuildPlan("kingshard", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqls := plan.RewrittenSqls["node1"]
+	if len(sqls) != 1 || sqls[0] != "select orders_0001.* from ORDERS_0001 where id = 1" {
+		t.Fatal(plan.RewrittenSqls)
+	}
+
+	cfg.Schema.LowerCaseTableNames = 3
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("lower_case_table_names must be 0, 1 or 2")
+	}
+}
+
+func TestNullKeyPlan(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: test_null
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+      null_key_table: 3
+    -
+      db: kingshard
+      table: test_hash
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := []int{0, 1, 2, 3}
+	tests := []struct {
+		sql    string
+		tables []int
+	}{
+		{"select * from test_null where id is null", []int{3}},
+		{"select * from test_null where id <=> null", []int{3}},
+		{"select * from test_null where null <=> id and a = 1", []int{3}},
+		{"select * from test_null where id <=> 2", []int{2}},
+		{"select * from test_null where id is not null", all},
+		{"select * from test_null where id != 2", all},
+		{"select * from test_null where id <> 2", all},
+		{"select * from test_null where id not in (1, 2)", all},
+		{"select * from test_hash where id is null", all},
+		{"insert into test_null (id, a) values (null, 1)", []int{3}},
+	}
+	for _, test := range tests {
+		stmt, err := sqlparser.Parse(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(test.sql, err)
+		}
+		if !isListEqual(plan.RouteTableIndexs, test.tables) {
+			t.Fatalf("sql %s, expect %v, got %v", test.sql, test.tables, plan.RouteTableIndexs)
+		}
+	}
+
+	stmt, err := sqlparser.Parse("insert into test_hash (a, id) values (1, null)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.BuildPlan("kingshard", stmt); err != errors.ErrNullShardKey {
+		t.Fatal(err)
+	}
+
+	*cfg.Schema.ShardRule[0].NullKeyTable = 4
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("null_key_table must be a sub table")
+	}
+}
+
+func TestKeyTypePlan(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: test_int
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+      key_type: int
+    -
+      db: kingshard
+      table: test_str
+      key: name
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+      key_type: string
+    -
+      db: kingshard
+      table: test_date
+      key: ctime
+      nodes: [node1, node2]
+      type: date_month
+      date_range: [201603-201605,201609-201612]
+      key_type: date
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql string
+		ok  bool
+	}{
+		{"select * from test_int where id = 123", true},
+		{"select * from test_int where id = '123'", true},
+		{"select * from test_int where id in (1, '2', 0x03)", true},
+		{"select * from test_int where id = '123abc'", false},
+		{"select * from test_int where id = 1.5", false},
+		{"insert into test_int (id) values ('12a')", false},
+		{"select * from test_str where name = 'abc'", true},
+		{"select * from test_str where name = 123", false},
+		{"select * from test_date where ctime = '2016-03-01'", true},
+		{"select * from test_date where ctime = '2016-03-01 12:00:00'", true},
+		{"select * from test_date where ctime > '2016-13-01'", false},
+		{"select * from test_date where ctime = 'abc'", false},
+	}
+	for _, test := range tests {
+		stmt, err := sqlparser.Parse(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rt.BuildPlan("kingshard", stmt)
+		if (err == nil) != test.ok {
+			t.Fatalf("sql %s, expect ok %v, got %v", test.sql, test.ok, err)
+		}
+	}
+
+	cfg.Schema.ShardRule[1].Type = "range"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("range shard can not have string key")
+	}
+	cfg.Schema.ShardRule[1].KeyType = "blob"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("invalid key_type")
+	}
+}
+
+func TestStringKeyPlan(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: test_ci
+      key: name
+      nodes: [node1, node2]
+      locations: [4,4]
+      type: hash
+      key_collation: utf8mb4_general_ci
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := func(charset, sql string) []int {
+		stmt, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlanWithCharset("kingshard", charset, stmt)
+		if err != nil {
+			t.Fatal(sql, err)
+		}
+		return plan.RouteTableIndexs
+	}
+
+	tests := []struct {
+		charset string
+		sql     string
+		expect  string
+	}{
+		{"", "select * from test_ci where name = 'ABC'", "select * from test_ci where name = 'abc'"},
+		{"utf8", "insert into test_ci (name) values ('Abc')", "select * from test_ci where name = 'abc'"},
+		{"latin1", "select * from test_ci where name = 'caf\xe9'", "select * from test_ci where name = 'café'"},
+		{"latin1", "select * from test_ci where name = '\x80'", "select * from test_ci where name = '€'"},
+		{"utf8", "select * from test_ci where name = _latin1'CAF\xc9'", "select * from test_ci where name = 'café'"},
+		{"utf8", "select * from test_ci where name = 'abc  '", "select * from test_ci where name = 'abc'"},
+		{"utf8", "select * from test_ci where name = 'Straße'", "select * from test_ci where name = 'STRASE'"},
+	}
+	for _, test := range tests {
+		tables := route(test.charset, test.sql)
+		expect := route("utf8mb4", test.expect)
+		if len(tables) != 1 || !isListEqual(tables, expect) {
+			t.Fatalf("sql %s, expect %v, got %v", test.sql, expect, tables)
+		}
+	}
+}
+
+func TestKeyCollation(t *testing.T) {
+	tests := []struct {
+		collation string
+		key       string
+		expect    string
+	}{
+		{"binary", "Abc ", "Abc "},
+		{"utf8mb4_bin", "Abc  ", "Abc"},
+		{"utf8mb4_0900_bin", "Abc ", "Abc "},
+		{"utf8mb4_general_ci", "Abc ", "ABC"},
+		{"utf8mb4_general_ci", "Ångström", "ANGSTROM"},
+		{"utf8mb4_general_ci", "Straße", "STRASE"},
+		{"utf8mb4_general_ci", "Œuvre", "\u0152UVRE"},
+		{"utf8mb4_general_ci", "a\U0001F600", "A\uFFFD"},
+		{"utf8mb4_0900_ai_ci", "Straße ", "strasse "},
+		{"utf8mb4_0900_ai_ci", "Ångström", "angstrom"},
+		{"utf8mb4_0900_ai_ci", "Œuvre Æ", "oeuvre ae"},
+		{"utf8mb4_0900_ai_ci", "Łódź", "lodz"},
+	}
+	for _, test := range tests {
+		c := collations[test.collation]
+		if got := c.Fold(test.key); got != test.expect {
+			t.Fatalf("%s of %q: expect %q, got %q", test.collation, test.key, test.expect, got)
+		}
+	}
+
+	//the charset of client is not converted without key_collation
+	r := &Rule{Type: HashRuleType, KeyType: StringKeyType, Shard: &HashShard{ShardNum: 4}}
+	plan := &Plan{Rule: r, Charset: "latin1"}
+	if v := plan.getKeyValue(sqlparser.StrVal("caf\xe9")); v != "caf\xe9" {
+		t.Fatalf("%q", v)
+	}
+	r.collation = collations["utf8mb4_general_ci"]
+	if v := plan.getKeyValue(sqlparser.StrVal("caf\xe9")); v != "café" {
+		t.Fatalf("%q", v)
+	}
+
+	for _, cfg := range []config.ShardConfig{
+		{Table: "t", Type: HashRuleType, KeyCollation: "latin1_swedish_ci"},
+		{Table: "t", Type: RangeRuleType, KeyCollation: "utf8mb4_bin"},
+	} {
+		if err := parseKeyCollation(&Rule{Type: cfg.Type}, &cfg); err == nil {
+			t.Fatal(cfg.KeyCollation, "must fail")
+		}
+	}
+}
+
 func newTestDBRule() *Router {
 	var s = `
 schema :
@@ -366,9 +801,9 @@ func TestWhereInPartitionByTableIndex(t *testing.T) {
 		[]int{0, 1, 2},
 	)
 
-	// ensure no impact for not in
+	// not in can not exclude any sub table
 	sql = "select * from test1 where id not in (0,1,2,3,4,5,6,7)"
-	checkPlan(t, sql, []int{8, 9, 10, 11}, []int{2})
+	checkPlan(t, sql, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, []int{0, 1, 2})
 
 }
 
@@ -469,7 +904,7 @@ func TestSelectPlan(t *testing.T) {
 	checkPlan(t, sql, t1, []int{0, 1, 2})
 
 	sql = "select * from test1 where id not in (5, 6)"
-	checkPlan(t, sql, []int{0, 1, 2, 3, 4, 7, 8, 9, 10, 11}, []int{0, 1, 2})
+	checkPlan(t, sql, t1, []int{0, 1, 2})
 
 	sql = "select * from test1 where id in (5, 6) or (id in (5, 6, 7,8) and id in (1,5,7))"
 	checkPlan(t, sql, []int{5, 6, 7}, []int{1})
@@ -508,27 +943,985 @@ func TestSelectPlan(t *testing.T) {
 	checkPlan(t, sql, makeList(0, 12), []int{0, 1, 2})
 }
 
-func TestValueSharding(t *testing.T) {
+func TestTableAliasPlan(t *testing.T) {
 	var sql string
+	t1 := makeList(0, 12)
 
-	sql = "insert into test1 (id) values (5)"
+	sql = "select * from test1 o where o.id = 5"
 	checkPlan(t, sql, []int{5}, []int{1})
 
-	sql = "insert into test2 (id) values (10000)"
-	checkPlan(t, sql, []int{1}, []int{0})
+	sql = "select o.* from test1 as o where o.id in (5, 8)"
+	checkPlan(t, sql, []int{5, 8}, []int{1, 2})
 
-	sql = "insert into test2 (id) values (20000)"
-	checkPlan(t, sql, []int{2}, []int{0})
+	sql = "select * from test1 o join t2 on o.id = t2.id where o.id = 5"
+	checkPlan(t, sql, []int{5}, []int{1})
 
-	sql = "update test1 set a =10 where id =12"
-	checkPlan(t, sql, []int{0}, []int{0})
+	//t2.id is not the shard key of test1
+	sql = "select * from test1 o join t2 on o.uid = t2.id where t2.id = 5"
+	checkPlan(t, sql, t1, []int{0, 1, 2})
 
-	sql = "update test2 set a =10 where id < 30000 and 10000< id"
-	checkPlan(t, sql, []int{1, 2}, []int{0})
+	sql = "select * from test1 o where x.id = 5"
+	checkPlan(t, sql, t1, []int{0, 1, 2})
 
-	sql = "delete from test2 where id < 30000 and 10000< id"
-	checkPlan(t, sql, []int{1, 2}, []int{0})
+	sql = "select * from test1 test1 where test1.id = 5"
+	checkPlan(t, sql, []int{5}, []int{1})
 
-	sql = "replace into test1(id) values(5)"
+	//the table is hidden by its alias as in mysql
+	r := newTestRouter()
+	for _, sql := range []string{
+		"select * from test1 o where test1.id = 5",
+		"select * from test1 as o join t2 on test1.id = t2.id",
+		"select * from test1 o left join t2 on test1.id = t2.id where o.id = 5",
+	} {
+		stmt, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.BuildPlan("kingshard", stmt)
+		if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_BAD_FIELD_ERROR {
+			t.Fatal(sql, err)
+		}
+	}
+}
+
+func TestJoinOnPlan(t *testing.T) {
+	var sql string
+	t1 := makeList(0, 12)
+
+	sql = "select * from test1 o join t2 on o.id = 5 and o.uid = t2.uid"
+	checkPlan(t, sql, []int{5}, []int{1})
+
+	sql = "select * from test1 o join t2 on o.id in (5, 8) where t2.a = 1"
+	checkPlan(t, sql, []int{5, 8}, []int{1, 2})
+
+	//the on clause of left join does not filter the shard table
+	sql = "select * from test1 o left join t2 on o.id = 5"
+	checkPlan(t, sql, t1, []int{0, 1, 2})
+
+	sql = "select * from test1 a, t2 b where a.id = b.id and b.id = 7"
+	checkPlan(t, sql, []int{7}, []int{1})
+
+	sql = "select * from test1 a join t2 b on a.id = b.id where b.id = 5"
 	checkPlan(t, sql, []int{5}, []int{1})
+
+	sql = "select * from test1 a join t2 b on a.id = b.id and b.id = c.id where c.id = 8"
+	checkPlan(t, sql, []int{8}, []int{2})
+
+	//can not propagate through or
+	sql = "select * from test1 a join t2 b on a.id = b.id where b.id = 5 or b.id = 8"
+	checkPlan(t, sql, t1, []int{0, 1, 2})
+}
+
+func TestLiteralPlan(t *testing.T) {
+	var sql string
+
+	//the literals equal to 5
+	for _, v := range []string{"5", "0x05", "X'05'", "b'101'", "0b101", "5.0", "5e0", "0.5e1", "'5'", "_utf8mb4'5'", "- -5"} {
+		sql = "select * from test1 where id = " + v
+		checkPlan(t, sql, []int{5}, []int{1})
+	}
+
+	sql = "select * from test1 where id in (0x05, 8e0)"
+	checkPlan(t, sql, []int{5, 8}, []int{1, 2})
+
+	sql = "insert into test1 (id, a) values (0x05, _binary'a'), (8.0, 1)"
+	checkPlan(t, sql, []int{5, 8}, []int{1, 2})
+}
+
+func TestValueSharding(t *testing.T) {
+	var sql string
+
+	sql = "insert into test1 (id) values (5)"
+	checkPlan(t, sql, []int{5}, []int{1})
+
+	sql = "insert into test2 (id) values (10000)"
+	checkPlan(t, sql, []int{1}, []int{0})
+
+	sql = "insert into test2 (id) values (20000)"
+	checkPlan(t, sql, []int{2}, []int{0})
+
+	sql = "update test1 set a =10 where id =12"
+	checkPlan(t, sql, []int{0}, []int{0})
+
+	sql = "update test2 set a =10 where id < 30000 and 10000< id"
+	checkPlan(t, sql, []int{1, 2}, []int{0})
+
+	sql = "delete from test2 where id < 30000 and 10000< id"
+	checkPlan(t, sql, []int{1, 2}, []int{0})
+
+	sql = "replace into test1(id) values(5)"
+	checkPlan(t, sql, []int{5}, []int{1})
+}
+
+func TestCommentRewrite(t *testing.T) {
+	r := newTestDBRule()
+	tests := []struct {
+		sql    string
+		expect string
+	}{
+		{"select /*+ MAX_EXECUTION_TIME(1000) */ /*! STRAIGHT_JOIN */ a from test1 where id = 1",
+			"select /*+ MAX_EXECUTION_TIME(1000) */ /*! STRAIGHT_JOIN */ a from test1_0001 where id = 1"},
+		{"select distinct /*! SQL_NO_CACHE */ a from test1 as t force index(idx) where id = 1",
+			"select distinct /*! SQL_NO_CACHE */ a from test1_0001 as t force index (idx) where id = 1"},
+		{"select a from test1 use index(i1) join t2 on test1.id = t2.id where test1.id = 1",
+			"select a from test1_0001 use index (i1) join t2 on id = t2.id where id = 1"},
+		{"insert /*+ SET_VAR(foreign_key_checks=OFF) */ into test1 (id) values (1)",
+			"insert /*+ SET_VAR(foreign_key_checks=OFF) */ into test1_0001(id) values (1)"},
+		{"insert /*+ SET_VAR(foreign_key_checks=OFF) */ ignore into test1 (id) values (1)",
+			"insert /*+ SET_VAR(foreign_key_checks=OFF) */ ignore into test1_0001(id) values (1)"},
+		{"update /*+ NO_INDEX_MERGE(test1) */ test1 set a = 1 where id = 1",
+			"update /*+ NO_INDEX_MERGE(test1) */ test1_0001 set a = 1 where id = 1"},
+		{"delete /*+ BKA(test1) */ from test1 where id = 1",
+			"delete /*+ BKA(test1) */ from test1_0001 where id = 1"},
+		{"replace /*! LOW_PRIORITY */ into test1 (id) values (1)",
+			"replace /*! LOW_PRIORITY */ into test1_0001(id) values (1)"},
+	}
+	for _, test := range tests {
+		stmt, err := sqlparser.Parse(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := r.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sqls := plan.RewrittenSqls["node2"]
+		if len(sqls) != 1 || sqls[0] != test.expect {
+			t.Fatalf("sql %s, expect %s, got %v", test.sql, test.expect, plan.RewrittenSqls)
+		}
+	}
+
+	stmt, err := sqlparser.Parse("truncate /*+ x */ table test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := r.BuildPlan("kingshard", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqls := plan.RewrittenSqls["node1"]; len(sqls) != 1 || sqls[0] != "truncate /*+ x */ table test1_0000" {
+		t.Fatal(plan.RewrittenSqls)
+	}
+}
+
+func TestTenancy(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  tenancy:
+    db: kingshard
+    schema: tenant_{id}
+    tables: [orders, users]
+  shard:
+    -
+      db: kingshard
+      table: orders
+      key: id
+      nodes: [node1, node2]
+      locations: [4,4]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql    string
+		expect string
+	}{
+		{"select * from orders where id = 1",
+			"select * from tenant_42.orders where id = 1"},
+		{"select * from `orders` o, kingshard.users as u where o.id = u.id",
+			"select * from tenant_42.`orders` o, tenant_42.users as u where o.id = u.id"},
+		{"select * from orders join items on orders.id = items.id",
+			"select * from tenant_42.orders join items on orders.id = items.id"},
+		{"/*tenant:7*/ update users set name = 'a' where id in (select id from orders)",
+			"/*tenant:7*/ update tenant_7.users set name = 'a' where id in (select id from tenant_7.orders)"},
+		{"insert into users (id) values (1) on duplicate key update orders = 1",
+			"insert into tenant_42.users (id) values (1) on duplicate key update orders = 1"},
+		{"delete from other.orders", "delete from other.orders"},
+		{"select * from items", "select * from items"},
+	}
+	for _, test := range tests {
+		sql, err := rt.RewriteTenantSql(test.sql, "kingshard", "42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != test.expect {
+			t.Fatalf("sql %s, expect %s, got %s", test.sql, test.expect, sql)
+		}
+	}
+
+	if sql, _ := rt.RewriteTenantSql("select * from orders", "other", "42"); sql != "select * from orders" {
+		t.Fatal(sql)
+	}
+	if _, err := rt.RewriteTenantSql("select * from orders", "kingshard", ""); err != errors.ErrNoTenant {
+		t.Fatal(err)
+	}
+	if _, err := rt.RewriteTenantSql("/*tenant:1;drop*/ select * from orders", "kingshard", ""); err == nil {
+		t.Fatal("invalid tenant must fail")
+	}
+
+	//the sharded tenant table uses the rule of logical database
+	sql, _ := rt.RewriteTenantSql("select * from orders where id = 5", "kingshard", "42")
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := rt.BuildPlan("kingshard", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqls := plan.RewrittenSqls["node2"]
+	if len(sqls) != 1 || sqls[0] != "select * from tenant_42.orders_0005 where id = 5" {
+		t.Fatal(plan.RewrittenSqls)
+	}
+}
+
+func TestSubTables(t *testing.T) {
+	r := newTestRouter()
+	if rule := r.SubTableRule("test1_0011"); rule == nil || rule.Table != "test1" {
+		t.Fatal("test1_0011 is a sub table of test1")
+	}
+	if r.SubTableRule("test1_0012") != nil || r.SubTableRule("test1") != nil {
+		t.Fatal("not a sub table")
+	}
+
+	s := r.StripSubTables("Table 'kingshard.test1_0003' doesn't exist, test_shard_month_201604 test1_00030")
+	if s != "Table 'kingshard.test1' doesn't exist, test_shard_month test1_00030" {
+		t.Fatal(s)
+	}
+
+	tests := []struct {
+		sql    string
+		expect string
+		node   string
+	}{
+		{"show create table test1", "show create table test1_0000", "node1"},
+		{"explain select test2.id from `kingshard`.`test2` join test1 on test2.id = test1.id where name = 'test1'",
+			"explain select test2_0000.id from `kingshard`.`test2_0000` join test1_0000 on test2_0000.id = test1_0000.id where name = 'test1'",
+			"node1"},
+		{"show create table test_shard_year", "show create table test_shard_year_2012", "node2"},
+		{"explain select * from other.test1", "explain select * from other.test1", ""},
+		{"explain select * from test3", "explain select * from test3", ""},
+	}
+	for _, test := range tests {
+		sql, node := r.ReplaceFirstSubTables(test.sql, "kingshard")
+		if sql != test.expect || node != test.node {
+			t.Fatalf("%s: expect %s %s, got %s %s", test.sql, test.expect, test.node, sql, node)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	r := newTestRouter()
+	tests := []struct {
+		table  string
+		key    string
+		expect Location
+	}{
+		{"test1", "13", Location{"kingshard", "test1", "node1", "test1_0001", 1}},
+		{"test2", "25000", Location{"kingshard", "test2", "node1", "test2_0002", 2}},
+		{"test2", "45000", Location{"kingshard", "test2", "node2", "test2_0004", 4}},
+		{"test_shard_year", "2016-05-01", Location{"kingshard", "test_shard_year", "node3", "test_shard_year_2016", 2016}},
+		{"kingshard.t", "1", Location{"kingshard", "t", "node1", "t", -1}},
+	}
+	for _, test := range tests {
+		loc, err := r.Locate("kingshard", test.table, test.key)
+		if err != nil {
+			t.Fatal(test.table, err)
+		}
+		if *loc != test.expect {
+			t.Fatal(test.table, *loc)
+		}
+	}
+
+	for _, key := range []string{"abc", "200000"} {
+		if _, err := r.Locate("kingshard", "test2", key); err == nil {
+			t.Fatal("invalid key", key)
+		}
+	}
+	if _, err := r.Locate("kingshard", "test_shard_year", "2020-01-01"); err == nil {
+		t.Fatal("no sub table of 2020")
+	}
+}
+
+func TestRewriteSchemas(t *testing.T) {
+	dbs := map[string]string{"app": "app_v2"}
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"select * from app.t", "select * from app_v2.t"},
+		{"select app.t.c from `app`.`t` join t2 on t.id = t2.id", "select app_v2.t.c from `app_v2`.`t` join t2 on t.id = t2.id"},
+		{"select 'app.t' from app", "select 'app.t' from app"},
+		{"select * from other.app", "select * from other.app"},
+	}
+	for _, tt := range tests {
+		if sql := RewriteSchemas(tt.sql, dbs); sql != tt.expected {
+			t.Fatal(tt.sql, sql)
+		}
+	}
+}
+
+func TestTablePattern(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: log_%
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+    -
+      db: kingshard
+      table: log_archive
+      type: single
+      node: node2
+    -
+      db: kingshard
+      table: ~^event_[0-9]{6}$
+      key: id
+      nodes: [node1, node2]
+      locations: [1,1]
+      type: hash
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := rt.GetRule("kingshard", "log_2016")
+	if rule.Type != HashRuleType || rule.Table != "log_2016" {
+		t.Fatal(rule.Type, rule.Table)
+	}
+	if rt.GetRule("kingshard", "log_2016") != rule {
+		t.Fatal("the rule of a table must be cached")
+	}
+	//the rule of the same name takes precedence over the pattern
+	if n := rt.SingleTableNode("kingshard", "log_archive"); n != "node2" {
+		t.Fatal(n)
+	}
+	if rule := rt.GetRule("kingshard", "event_201601"); rule.Type != HashRuleType {
+		t.Fatal(rule.Type)
+	}
+	for _, table := range []string{"event_2016", "logs", "test"} {
+		if rule := rt.GetRule("kingshard", table); rule != rt.DefaultRule {
+			t.Fatal(table, rule.Table)
+		}
+	}
+	if rule := rt.SubTableRule("log_2016_0003"); rule == nil || rule.Table != "log_2016" {
+		t.Fatal(rule)
+	}
+	if rule := rt.SubTableRule("log_2016_0004"); rule != nil {
+		t.Fatal(rule.Table)
+	}
+
+	stmt, err := sqlparser.Parse("select * from log_2016 where id = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := rt.BuildPlan("kingshard", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqls := plan.RewrittenSqls["node2"]; len(sqls) != 1 ||
+		sqls[0] != "select * from log_2016_0002 where id = 2" {
+		t.Fatal(plan.RewrittenSqls)
+	}
+
+	cfg.Schema.ShardRule[2].Table = "~event_[0-9"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("invalid regular expression must err")
+	}
+}
+
+func TestRuleOperations(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: archive
+      key: id
+      nodes: [node1, node2]
+      locations: [2,2]
+      type: hash
+      operations: [select, INSERT]
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"select * from archive where id = 1", true},
+		{"insert into archive (id, a) values (1, 2)", true},
+		{"insert into archive (id, a) values (1, 2) on duplicate key update a = 3", false},
+		{"update archive set a = 1 where id = 1", false},
+		{"delete from archive where id = 1", false},
+		{"replace into archive (id, a) values (1, 2)", false},
+		{"truncate table archive", false},
+		{"delete from test where id = 1", true},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rt.BuildPlan("kingshard", stmt)
+		if tt.allowed && err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if !tt.allowed {
+			e, ok := err.(*mysql.SqlError)
+			if !ok || e.Code != mysql.ER_KS_OPERATION_DENIED {
+				t.Fatal(tt.sql, err)
+			}
+		}
+	}
+
+	cfg.Schema.ShardRule[0].Operations = []string{"select", "merge"}
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("invalid operation must err")
+	}
+}
+
+func TestRecentShards(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: log
+      key: ctime
+      nodes: [node1, node2]
+      date_range: [2000-2009,2010-2099]
+      type: date_year
+      recent_shards: 2
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := time.Now().Year()
+	recent := []int{year - 1, year}
+	tests := []struct {
+		sql    string
+		expect []int
+	}{
+		{"select * from log", recent},
+		{"select * from log where a = 1 order by id desc limit 10", recent},
+		{"select * from log where ctime = '2005-01-02'", []int{2005}},
+		{"select * from log where ctime >= '2090-01-01' or a = 1", rt.GetRule("kingshard", "log").SubTableIndexs},
+		{"select /*all_shards*/ * from log where a = 1", rt.GetRule("kingshard", "log").SubTableIndexs},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if !reflect.DeepEqual(plan.RouteTableIndexs, tt.expect) {
+			t.Fatal(tt.sql, plan.RouteTableIndexs)
+		}
+		//the recent sub tables change with the time
+		if plan.Uncacheable != reflect.DeepEqual(tt.expect, recent) {
+			t.Fatal(tt.sql, plan.Uncacheable)
+		}
+	}
+
+	cfg.Schema.ShardRule[0].Type = HashRuleType
+	cfg.Schema.ShardRule[0].Locations = []int{1, 1}
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("recent_shards of hash shard must err")
+	}
+}
+
+func TestBlackhole(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2, node3]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: log
+      key: ctime
+      nodes: [blackhole, node3]
+      date_range: [2000-2009,2010-2099]
+      type: date_year
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	newRouter := func(policy string) *Router {
+		cfg.Schema.ShardRule[0].BlackholePolicy = policy
+		rt, err := NewRouter(&cfg.Schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rt
+	}
+
+	tests := []struct {
+		policy string
+		sql    string
+		expect []int //nil means all the sub tables routed are blackhole
+		code   uint16
+	}{
+		{"", "select * from log where ctime = '2005-01-02'", nil, 0},
+		{"", "select * from log where ctime >= '2098-01-01'", []int{2098, 2099}, 0},
+		{"", "insert into log(id, ctime) values(1, '2005-01-01')", nil, 0},
+		{"", "insert into log(id, ctime) values(1, '2005-01-01'), (2, '2015-01-01')", []int{2015}, 0},
+		{"", "delete from log where ctime < '2008-01-01'", nil, 0},
+		{"reject", "select * from log where ctime = '2005-01-02'", nil, 0},
+		{"reject", "insert into log(id, ctime) values(1, '2005-01-01'), (2, '2015-01-01')", nil, mysql.ER_KS_BLACKHOLE_TABLE},
+		{"reject", "delete from log where ctime < '2008-01-01'", nil, mysql.ER_KS_BLACKHOLE_TABLE},
+		{"reject", "delete from log where ctime >= '2009-01-01' and ctime < '2011-01-01'", []int{2010, 2011}, 0},
+	}
+	for _, tt := range tests {
+		rt := newRouter(tt.policy)
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if tt.code != 0 {
+			if e, ok := err.(*mysql.SqlError); !ok || e.Code != tt.code {
+				t.Fatal(tt.sql, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if tt.expect == nil {
+			if !plan.Blackhole || len(plan.RewrittenSqls) != 0 {
+				t.Fatal(tt.sql, plan.RewrittenSqls)
+			}
+			continue
+		}
+		if plan.Blackhole || !reflect.DeepEqual(plan.RouteTableIndexs, tt.expect) {
+			t.Fatal(tt.sql, plan.RouteTableIndexs)
+		}
+		if _, ok := plan.RewrittenSqls["node3"]; !ok || len(plan.RewrittenSqls) != 1 {
+			t.Fatal(tt.sql, plan.RewrittenSqls)
+		}
+	}
+
+	if newRouter("").GetRule("kingshard", "log").FirstSubTable() != 2010 {
+		t.Fatal("the first sub table must not be blackhole")
+	}
+
+	cfg.Schema.ShardRule[0].BlackholePolicy = "ignore"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("invalid blackhole_policy must err")
+	}
+	cfg.Schema.ShardRule[0].BlackholePolicy = ""
+	cfg.Schema.ShardRule[0].Type = HashRuleType
+	cfg.Schema.ShardRule[0].Locations = []int{1, 1}
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("blackhole of hash shard must err")
+	}
+	cfg.Schema.Nodes = append(cfg.Schema.Nodes, BlackholeNode)
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("node named blackhole must err")
+	}
+}
+
+func TestPostHaving(t *testing.T) {
+	r := newTestRouter()
+	tests := []struct {
+		sql    string
+		post   bool
+		expect string //the sql of test1_0005
+	}{
+		{"select uid, sum(a) from test1 where id = 5 group by uid having sum(a) > 10 limit 2", false,
+			"select uid, sum(a),uid from test1_0005 where id = 5 group by uid having sum(a) > 10 limit 2"},
+		{"select uid, sum(a) from test1 where id in (5, 8) group by uid having sum(a) > 10 limit 2", true,
+			"select uid, sum(a),uid from test1_0005 where id in (5) group by uid"},
+		{"select count(*) from test1 where id in (5, 8) having count(*) > 1", true,
+			"select count(*) from test1_0005 where id in (5)"},
+		{"select id from test1 where id in (5, 8) having id > 1", false,
+			"select id from test1_0005 where id in (5) having id > 1"},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := r.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if plan.PostHaving != tt.post || plan.RewrittenSqls["node2"][0] != tt.expect {
+			t.Fatal(tt.sql, plan.PostHaving, plan.RewrittenSqls)
+		}
+	}
+}
+
+func TestFederatedRule(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2, node3]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: orders
+      type: federated
+      nodes: [node2, node3]
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := sqlparser.Parse("select region, count(*) from orders where a = 1 group by region limit 10, 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := rt.BuildPlan("kingshard", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "select region, count(*),region from orders where a = 1 group by region limit 15"
+	for _, node := range []string{"node2", "node3"} {
+		if sqls := plan.RewrittenSqls[node]; len(sqls) != 1 || sqls[0] != expected {
+			t.Fatal(node, sqls)
+		}
+	}
+	if len(plan.RewrittenSqls) != 2 {
+		t.Fatal(plan.RewrittenSqls)
+	}
+
+	for _, sql := range []string{
+		"insert into orders(id) values (1)",
+		"update orders set a = 1 where id = 1",
+		"delete from orders where id = 1",
+		"replace into orders(id) values (1)",
+		"truncate table orders",
+	} {
+		stmt, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rt.BuildPlan("kingshard", stmt)
+		if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_OPERATION_DENIED {
+			t.Fatal(sql, err)
+		}
+	}
+
+	cfg.Schema.ShardRule[0].Nodes = nil
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("federated table must have nodes")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: orders
+      key: id
+      nodes: [node1, node2]
+      type: hash
+      locations: [1, 1]
+      soft_delete: deleted_at is null
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql      string
+		node     string
+		expected string
+	}{
+		{"select * from orders where id = 1", "node2",
+			"select * from orders_0001 where (id = 1) and (deleted_at is null)"},
+		{"select * from orders", "node1",
+			"select * from orders_0000 where (deleted_at is null)"},
+		{"select o.id from orders as o join users as u on o.user_id = u.id where o.id = 2", "node1",
+			"select o.id from orders_0000 as o join users as u on o.user_id = u.id where (o.id = 2) and (o.deleted_at is null)"},
+		{"select orders.id from orders join users on user_id = users.id where id = 2", "node1",
+			"select orders_0000.id from orders_0000 join users on user_id = users.id where (id = 2) and (orders_0000.deleted_at is null)"},
+		{"select * from orders, users where id = 3", "node2",
+			"select * from orders_0001, users where (id = 3) and (orders_0001.deleted_at is null)"},
+		{"select /*with_deleted*/ * from orders where id = 1", "node2",
+			"select /*with_deleted*/ * from orders_0001 where id = 1"},
+		{"update orders set a = 1 where id = 1", "node2",
+			"update orders_0001 set a = 1 where (id = 1) and (deleted_at is null)"},
+		{"delete from orders where id = 2 or id = 4", "node1",
+			"delete from orders_0000 where (id = 2 or id = 4) and (deleted_at is null)"},
+		{"delete /*with_deleted*/ from orders where id = 2", "node1",
+			"delete /*with_deleted*/ from orders_0000 where id = 2"},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if sqls := plan.RewrittenSqls[tt.node]; len(sqls) != 1 || sqls[0] != tt.expected {
+			t.Fatal(tt.sql, plan.RewrittenSqls)
+		}
+	}
+
+	cfg.Schema.ShardRule[0].SoftDelete = "deleted_at is"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("expect error of invalid soft_delete")
+	}
+	cfg.Schema.ShardRule[0].SoftDelete = "deleted_at is null"
+	cfg.Schema.ShardRule[0].Type = SingleRuleType
+	cfg.Schema.ShardRule[0].Node = "node1"
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("single table can not have soft_delete")
+	}
+}
+
+func TestKeyExpr(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: orders
+      key: order_no
+      key_expr: substr(order_no, 1, 3) % 4
+      nodes: [node1, node2]
+      type: hash
+      locations: [2, 2]
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql      string
+		expected map[string][]string
+	}{
+		{"insert into orders(order_no, a) values ('1010001', 1), ('1030002', 2)", map[string][]string{
+			"node1": {"insert into orders_0001(order_no, a) values ('1010001', 1)"},
+			"node2": {"insert into orders_0003(order_no, a) values ('1030002', 2)"},
+		}},
+		{"select * from orders where order_no = '1020005'", map[string][]string{
+			"node2": {"select * from orders_0002 where order_no = '1020005'"},
+		}},
+		{"select * from orders where order_no in ('1000001', '1040002')", map[string][]string{
+			"node1": {"select * from orders_0000 where order_no in ('1000001', '1040002')"},
+		}},
+		{"select * from orders where order_no > '1000001'", map[string][]string{
+			"node1": {"select * from orders_0000 where order_no > '1000001'",
+				"select * from orders_0001 where order_no > '1000001'"},
+			"node2": {"select * from orders_0002 where order_no > '1000001'",
+				"select * from orders_0003 where order_no > '1000001'"},
+		}},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		if !reflect.DeepEqual(plan.RewrittenSqls, tt.expected) {
+			t.Fatal(tt.sql, plan.RewrittenSqls)
+		}
+	}
+
+	stmt, _ := sqlparser.Parse("select * from orders where order_no = 'abc'")
+	if _, err = rt.BuildPlan("kingshard", stmt); err == nil {
+		t.Fatal("expect error of the value not an integer")
+	}
+
+	loc, err := rt.Locate("kingshard", "orders", "1070001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.SubTable != "orders_0003" || loc.Node != "node2" {
+		t.Fatal(loc)
+	}
+
+	for _, expr := range []string{"substr(order_no, 1, 3", "substr(id, 1, 3)",
+		"md5(order_no)", "order_no / 10", "100 % 4"} {
+		cfg.Schema.ShardRule[0].KeyExpr = expr
+		if _, err := NewRouter(&cfg.Schema); err == nil {
+			t.Fatal("expect error of invalid key_expr", expr)
+		}
+	}
+}
+
+func TestEvalKeyExpr(t *testing.T) {
+	tests := []struct {
+		expr     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"user_id % 1000", int64(123456), int64(456)},
+		{"mod(user_id, 1000)", "-123456", int64(-456)},
+		{"(user_id + 1) * 2", uint64(10), int64(22)},
+		{"substr(user_id, 3)", "ab中文cd", "中文cd"},
+		{"substring(user_id, -2, 1)", "abcd", "c"},
+		{"substr(user_id, 0, 2)", "abcd", ""},
+		{"substr(user_id, 1, 6)", int64(20160501123), "201605"},
+		{"substr(user_id, -10)", "abcd", ""},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse("select " + tt.expr + " from t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expr := stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.NonStarExpr).Expr
+		if _, err := checkKeyExpr(expr, "user_id"); err != nil {
+			t.Fatal(tt.expr, err)
+		}
+		v, err := evalKeyExpr(expr, tt.value)
+		if err != nil {
+			t.Fatal(tt.expr, err)
+		}
+		if v != tt.expected {
+			t.Fatal(tt.expr, v)
+		}
+	}
+}
+
+func TestUUIDKey(t *testing.T) {
+	var s = `
+schema:
+  nodes: [node1, node2]
+  default: node1
+  shard:
+    -
+      db: kingshard
+      table: orders
+      key: id
+      key_type: uuid
+      nodes: [node1, node2]
+      type: hash
+      locations: [2, 2]
+    -
+      db: kingshard
+      table: events
+      key: id
+      key_type: uuid
+      uuid_swap_flag: true
+      nodes: [node1, node2]
+      type: date_month
+      date_range: [201601-201604, 201605-201608]
+`
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter(&cfg.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql      string
+		node     string
+		subTable string
+	}{
+		{"select * from orders where id = '8cbc2000-1a94-11e6-9564-5b8c656024db'", "node2", "orders_0003"},
+		{"select * from orders where id = '{8CBC2000-1A94-11E6-9564-5B8C656024DB}'", "node2", "orders_0003"},
+		{"select * from orders where id = '8cbc20001a9411e695645b8c656024db'", "node2", "orders_0003"},
+		{"select * from orders where id = x'8cbc20001a9411e695645b8c656024db'", "node2", "orders_0003"},
+		{"select * from orders where id = uuid_to_bin('8cbc2000-1a94-11e6-9564-5b8c656024db')", "node2", "orders_0003"},
+		{"insert into orders(id, a) values (0x8CBC20001A9411E695645B8C656024DB, 1)", "node2", "orders_0003"},
+		{"select * from orders where id = '6CCD780C-BABA-4026-9564-5B8C656024DB'", "node2", "orders_0002"},
+		{"select * from events where id = x'11e61a948cbc200095645b8c656024db'", "node2", "events_201605"},
+		{"insert into events(id, a) values (uuid_to_bin('8cbc2000-1a94-11e6-9564-5b8c656024db', 1), 1)", "node2", "events_201605"},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := rt.BuildPlan("kingshard", stmt)
+		if err != nil {
+			t.Fatal(tt.sql, err)
+		}
+		sqls := plan.RewrittenSqls[tt.node]
+		if len(plan.RewrittenSqls) != 1 || len(sqls) != 1 || !strings.Contains(sqls[0], tt.subTable) {
+			t.Fatal(tt.sql, plan.RewrittenSqls)
+		}
+	}
+
+	for _, sql := range []string{
+		"select * from orders where id = '8cbc2000-1a94'",
+		"select * from events where id = '6ccd780c-baba-4026-9564-5b8c656024db'",
+	} {
+		stmt, _ := sqlparser.Parse(sql)
+		if _, err = rt.BuildPlan("kingshard", stmt); err == nil {
+			t.Fatal("expect error of invalid uuid", sql)
+		}
+	}
+
+	cfg.Schema.ShardRule[0].KeyType = ""
+	cfg.Schema.ShardRule[0].UUIDSwapFlag = true
+	if _, err := NewRouter(&cfg.Schema); err == nil {
+		t.Fatal("uuid_swap_flag is only for uuid key")
+	}
 }
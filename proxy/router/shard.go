@@ -128,10 +128,14 @@ type RangeShard interface {
 }
 
 type HashShard struct {
-	ShardNum int
+	ShardNum  int
+	Collation *Collation //the string key is folded by the collation if not nil
 }
 
 func (s *HashShard) FindForKey(key interface{}) (int, error) {
+	if str, ok := key.(string); ok && s.Collation != nil {
+		key = s.Collation.Fold(str)
+	}
 	h := HashValue(key)
 
 	return int(h % uint64(s.ShardNum)), nil
@@ -0,0 +1,156 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the select, update and delete with this hint are not filtered by the
+//soft_delete of the table, such as select /*with_deleted*/ * from orders
+const WithDeletedHint = "/*with_deleted*/"
+
+//parse the soft_delete predicate of the table, the tables whose sqls are
+//not parsed can not have it
+func parseSoftDelete(r *Rule, cfg *config.ShardConfig) error {
+	if len(cfg.SoftDelete) == 0 {
+		return nil
+	}
+	if cfg.NoRewrite || cfg.Type == SingleRuleType {
+		return fmt.Errorf("soft_delete of table %s is not allowed for no_rewrite or single table", cfg.Table)
+	}
+	if _, err := parseSoftDeleteExpr(cfg.SoftDelete); err != nil {
+		return fmt.Errorf("invalid soft_delete %s of table %s", cfg.SoftDelete, cfg.Table)
+	}
+	r.SoftDelete = cfg.SoftDelete
+	return nil
+}
+
+func parseSoftDeleteExpr(predicate string) (sqlparser.BoolExpr, error) {
+	stmt, err := sqlparser.Parse("select 1 from t where " + predicate)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || sel.Where == nil {
+		return nil, fmt.Errorf("invalid predicate %s", predicate)
+	}
+	return sel.Where.Expr, nil
+}
+
+//append the soft delete predicate of the table to where, unless the
+//statement has the hint. The columns of the predicate are qualified by
+//the alias of the table if it has one, else they are kept in the plan
+//and qualified by the sub table name in the rewritten joins.
+func (plan *Plan) addSoftDelete(comments sqlparser.Comments, where *sqlparser.Where) *sqlparser.Where {
+	if len(plan.Rule.SoftDelete) == 0 || hasComment(comments, WithDeletedHint) {
+		return where
+	}
+	expr, err := ParsePredicate(plan.Rule.SoftDelete, plan.TableAlias)
+	if err != nil {
+		return where
+	}
+	if len(plan.TableAlias) == 0 {
+		visitColumns(expr, func(col *sqlparser.ColName) {
+			if len(col.Qualifier) == 0 {
+				plan.softDeleteColumns = append(plan.softDeleteColumns, col)
+			}
+		})
+	}
+	return AndWhere(where, expr)
+}
+
+//qualify the unqualified columns of soft delete by qualifier, the
+//qualifier is reset by an empty one
+func (plan *Plan) qualifySoftDelete(qualifier string) {
+	for _, col := range plan.softDeleteColumns {
+		col.Qualifier = []byte(qualifier)
+	}
+}
+
+//ParsePredicate parses a where expression, its columns without qualifier
+//are qualified by qualifier if it is not empty. It is parsed for each
+//statement, since the columns may be qualified.
+func ParsePredicate(predicate string, qualifier string) (sqlparser.BoolExpr, error) {
+	expr, err := parseSoftDeleteExpr(predicate)
+	if err != nil {
+		return nil, err
+	}
+	if len(qualifier) != 0 {
+		visitColumns(expr, func(col *sqlparser.ColName) {
+			if len(col.Qualifier) == 0 {
+				col.Qualifier = []byte(qualifier)
+			}
+		})
+	}
+	return expr, nil
+}
+
+//AndWhere returns (where) and (expr), or where expr if where is nil
+func AndWhere(where *sqlparser.Where, expr sqlparser.BoolExpr) *sqlparser.Where {
+	expr = &sqlparser.ParenBoolExpr{Expr: expr}
+	if where == nil {
+		return sqlparser.NewWhere(sqlparser.AST_WHERE, expr)
+	}
+	return sqlparser.NewWhere(sqlparser.AST_WHERE, &sqlparser.AndExpr{
+		Left:  &sqlparser.ParenBoolExpr{Expr: where.Expr},
+		Right: expr,
+	})
+}
+
+//call visit for each column in expr
+func visitColumns(expr sqlparser.Expr, visit func(col *sqlparser.ColName)) {
+	switch node := expr.(type) {
+	case *sqlparser.AndExpr:
+		visitColumns(node.Left, visit)
+		visitColumns(node.Right, visit)
+	case *sqlparser.OrExpr:
+		visitColumns(node.Left, visit)
+		visitColumns(node.Right, visit)
+	case *sqlparser.NotExpr:
+		visitColumns(node.Expr, visit)
+	case *sqlparser.ParenBoolExpr:
+		visitColumns(node.Expr, visit)
+	case *sqlparser.ComparisonExpr:
+		visitColumns(node.Left, visit)
+		visitColumns(node.Right, visit)
+	case *sqlparser.RangeCond:
+		visitColumns(node.Left, visit)
+		visitColumns(node.From, visit)
+		visitColumns(node.To, visit)
+	case *sqlparser.NullCheck:
+		visitColumns(node.Expr, visit)
+	case *sqlparser.BinaryExpr:
+		visitColumns(node.Left, visit)
+		visitColumns(node.Right, visit)
+	case *sqlparser.UnaryExpr:
+		visitColumns(node.Expr, visit)
+	case sqlparser.ValTuple:
+		for _, v := range node {
+			visitColumns(v, visit)
+		}
+	case *sqlparser.FuncExpr:
+		for _, e := range node.Exprs {
+			if v, ok := e.(*sqlparser.NonStarExpr); ok {
+				visitColumns(v.Expr, visit)
+			}
+		}
+	case *sqlparser.ColName:
+		visit(node)
+	}
+}
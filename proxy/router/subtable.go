@@ -0,0 +1,142 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/flike/kingshard/sqlparser"
+)
+
+var identifierRegexp = regexp.MustCompile(`[0-9A-Za-z_$]+`)
+
+//SubTableRule returns the rule of a sub table such as orders_0007, nil if
+//table is not a sub table
+func (r *Router) SubTableRule(table string) *Rule {
+	table = r.normalizeName(table)
+	if rule := r.subTables[table]; rule != nil {
+		return rule
+	}
+	return r.patterns.subTableRule(table)
+}
+
+//StripSubTables replaces the sub tables in s with their logical tables, so
+//the sub tables in the results and errors of backend do not leak to client
+func (r *Router) StripSubTables(s string) string {
+	if len(r.subTables) == 0 && len(r.patterns.rules) == 0 {
+		return s
+	}
+	return identifierRegexp.ReplaceAllStringFunc(s, func(name string) string {
+		if rule := r.SubTableRule(name); rule != nil {
+			return rule.Table
+		}
+		return name
+	})
+}
+
+//the sharding rule of db.table, nil if table is not sharded
+func (r *Router) shardingRule(db string, table string) *Rule {
+	rule := r.findRule(r.normalizeName(db), r.normalizeName(table))
+	if rule == nil || rule.Type == DefaultRuleType || len(rule.SubTableIndexs) == 0 {
+		return nil
+	}
+	return rule
+}
+
+//ReplaceFirstSubTables replaces the sharding tables in sql with their
+//first sub tables, a table name without database is in db. It returns the
+//node of the first sub table of the first sharding table, or "" if sql has
+//no sharding table. It is used by the statements sent to one sub table
+//for the definition of a sharding table, such as show create table and
+//explain.
+func (r *Router) ReplaceFirstSubTables(sql string, db string) (string, string) {
+	tokens := scanSqlTokens(sql)
+	var nodeName string
+	buf := make([]byte, 0, len(sql)+16)
+	last := 0
+	for i, tk := range tokens {
+		if tk.typ != sqlparser.ID {
+			continue
+		}
+		//the database of db.table, or the table of table.column
+		if i+2 < len(tokens) && tokens[i+1].typ == '.' && tokens[i+2].typ == sqlparser.ID &&
+			r.shardingRule(tk.val, tokens[i+2].val) != nil {
+			continue
+		}
+		tableDB := db
+		if 1 < i && tokens[i-1].typ == '.' && tokens[i-2].typ == sqlparser.ID {
+			tableDB = tokens[i-2].val
+		}
+		rule := r.shardingRule(tableDB, tk.val)
+		if rule == nil {
+			continue
+		}
+
+		tableIndex := rule.FirstSubTable()
+		if len(nodeName) == 0 {
+			nodeName = rule.Nodes[rule.TableToNode[tableIndex]]
+		}
+		subTable := fmt.Sprintf("%s_%04d", rule.Table, tableIndex)
+		if sql[tk.start] == '`' {
+			subTable = "`" + subTable + "`"
+		}
+		buf = append(buf, sql[last:tk.start]...)
+		buf = append(buf, subTable...)
+		last = tk.end
+	}
+	if len(nodeName) == 0 {
+		return sql, ""
+	}
+	buf = append(buf, sql[last:]...)
+	return string(buf), nodeName
+}
+
+//RewriteSchemas replaces the databases of the qualified names in sql by
+//dbs, such as app.orders to app_v2.orders if dbs maps app to app_v2. It
+//is used by the nodes whose databases are renamed by schema_rewrite.
+func RewriteSchemas(sql string, dbs map[string]string) string {
+	if len(dbs) == 0 {
+		return sql
+	}
+	tokens := scanSqlTokens(sql)
+	var buf []byte
+	last := 0
+	for i, tk := range tokens {
+		if tk.typ != sqlparser.ID || len(tokens) <= i+2 ||
+			tokens[i+1].typ != '.' || tokens[i+2].typ != sqlparser.ID {
+			continue
+		}
+		//the table of db.table.column
+		if 0 < i && tokens[i-1].typ == '.' {
+			continue
+		}
+		to, ok := dbs[tk.val]
+		if !ok {
+			continue
+		}
+		if sql[tk.start] == '`' {
+			to = "`" + to + "`"
+		}
+		buf = append(buf, sql[last:tk.start]...)
+		buf = append(buf, to...)
+		last = tk.end
+	}
+	if buf == nil {
+		return sql
+	}
+	buf = append(buf, sql[last:]...)
+	return string(buf)
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+const (
+	TenantIdPattern = "{id}"
+	//the hint to set the tenant of a sql, such as /*tenant:42*/
+	TenantHintPrefix = "/*tenant:"
+)
+
+//Tenancy routes the tenant tables of the logical database to the schema
+//of each tenant, such as tenant_42.orders.
+type Tenancy struct {
+	DB     string
+	Tables map[string]bool
+
+	//the schema of tenant is prefix + id + suffix
+	prefix string
+	suffix string
+}
+
+func (r *Router) parseTenancy(cfg *config.TenancyConfig) error {
+	if len(cfg.DB) == 0 {
+		return nil
+	}
+	//the schema of tenant is recognized by its prefix and suffix
+	if strings.Count(cfg.Schema, TenantIdPattern) != 1 || cfg.Schema == TenantIdPattern {
+		return fmt.Errorf("tenancy schema %s must have one %s and a prefix or suffix",
+			cfg.Schema, TenantIdPattern)
+	}
+	if len(cfg.Tables) == 0 {
+		return fmt.Errorf("tenancy of db %s has no tables", cfg.DB)
+	}
+
+	t := new(Tenancy)
+	t.DB = r.normalizeName(cfg.DB)
+	t.Tables = make(map[string]bool, len(cfg.Tables))
+	for _, table := range cfg.Tables {
+		t.Tables[r.normalizeName(table)] = true
+	}
+	i := strings.Index(cfg.Schema, TenantIdPattern)
+	t.prefix = r.normalizeName(cfg.Schema[:i])
+	t.suffix = r.normalizeName(cfg.Schema[i+len(TenantIdPattern):])
+	r.Tenancy = t
+	return nil
+}
+
+//the schema of the tenant
+func (t *Tenancy) Schema(tenant string) string {
+	return t.prefix + tenant + t.suffix
+}
+
+//the logical database of db if it is the schema of a tenant
+func (r *Router) logicalDB(db string) string {
+	t := r.Tenancy
+	if t == nil || len(db) <= len(t.prefix)+len(t.suffix) {
+		return db
+	}
+	if strings.HasPrefix(db, t.prefix) && strings.HasSuffix(db, t.suffix) {
+		return t.DB
+	}
+	return db
+}
+
+func (r *Router) isTenantTable(db string, table string) bool {
+	return r.normalizeName(db) == r.Tenancy.DB &&
+		r.Tenancy.Tables[r.normalizeName(table)]
+}
+
+//the tenant id is put into sql, so only letters, digits and '_' are allowed
+func IsValidTenant(tenant string) bool {
+	if len(tenant) == 0 {
+		return false
+	}
+	for _, c := range tenant {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//the tenant of /*tenant:42*/
+func parseTenantHint(comment string) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(comment), TenantHintPrefix) ||
+		!strings.HasSuffix(comment, "*/") {
+		return "", false
+	}
+	return strings.TrimSpace(comment[len(TenantHintPrefix) : len(comment)-2]), true
+}
+
+type sqlToken struct {
+	typ        int
+	val        string
+	start, end int //the offsets of the token in sql
+}
+
+func scanSqlTokens(sql string) []sqlToken {
+	tkn := sqlparser.NewStringTokenizer(sql)
+	var tokens []sqlToken
+	for {
+		typ, val := tkn.Scan()
+		if typ == 0 || typ == sqlparser.LEX_ERROR {
+			return tokens
+		}
+		end := tkn.Position - 1
+		start := end - len(val)
+		if typ == sqlparser.ID && 0 < end && sql[end-1] == '`' {
+			start -= 2
+		}
+		tokens = append(tokens, sqlToken{typ: typ, val: string(val), start: start, end: end})
+	}
+}
+
+//RewriteTenantSql qualify the tenant tables in sql with the schema of the
+//tenant, a table name without database is in db. The /*tenant:id*/ hint
+//in sql overrides tenant. The sql is unchanged if it has no tenant table.
+func (r *Router) RewriteTenantSql(sql string, db string, tenant string) (string, error) {
+	if r.Tenancy == nil {
+		return sql, nil
+	}
+
+	tokens := scanSqlTokens(sql)
+	for _, tk := range tokens {
+		if tk.typ != sqlparser.COMMENT {
+			continue
+		}
+		if hinted, ok := parseTenantHint(tk.val); ok {
+			tenant = hinted
+		}
+	}
+
+	//the ranges of sql replaced by the schema of tenant
+	type edit struct {
+		start, end int
+	}
+	var edits []edit
+	var inList bool //the tables after FROM or UPDATE are separated by comma
+	for i := 0; i < len(tokens); i++ {
+		//the columns after ON DUPLICATE KEY UPDATE
+		if tokens[i].typ == sqlparser.UPDATE && 0 < i && tokens[i-1].typ == sqlparser.KEY {
+			continue
+		}
+		switch tokens[i].typ {
+		case sqlparser.FROM, sqlparser.JOIN, sqlparser.STRAIGHT_JOIN, sqlparser.UPDATE:
+			inList = tokens[i].typ != sqlparser.JOIN && tokens[i].typ != sqlparser.STRAIGHT_JOIN
+		case sqlparser.INTO, sqlparser.TABLE:
+			inList = false
+		default:
+			continue
+		}
+
+		for i+1 < len(tokens) && tokens[i+1].typ == sqlparser.ID {
+			i++
+			name := tokens[i]
+			if i+2 < len(tokens) && tokens[i+1].typ == '.' && tokens[i+2].typ == sqlparser.ID {
+				//db.table
+				if r.isTenantTable(name.val, tokens[i+2].val) {
+					edits = append(edits, edit{name.start, name.end})
+				}
+				i += 2
+			} else if r.isTenantTable(db, name.val) {
+				edits = append(edits, edit{name.start, name.start})
+			}
+
+			//skip the alias
+			if i+1 < len(tokens) && tokens[i+1].typ == sqlparser.AS {
+				i++
+			}
+			if i+1 < len(tokens) && tokens[i+1].typ == sqlparser.ID {
+				i++
+			}
+			if !inList || len(tokens) <= i+1 || tokens[i+1].typ != ',' {
+				break
+			}
+			i++
+		}
+	}
+	if len(edits) == 0 {
+		return sql, nil
+	}
+	if len(tenant) == 0 {
+		return "", errors.ErrNoTenant
+	}
+	if !IsValidTenant(tenant) {
+		return "", fmt.Errorf("invalid tenant %s", tenant)
+	}
+
+	schema := r.Tenancy.Schema(tenant)
+	buf := make([]byte, 0, len(sql)+len(edits)*(len(schema)+1))
+	last := 0
+	for _, e := range edits {
+		buf = append(buf, sql[last:e.start]...)
+		buf = append(buf, schema...)
+		if e.start == e.end {
+			buf = append(buf, '.')
+		}
+		last = e.end
+	}
+	buf = append(buf, sql[last:]...)
+	return string(buf), nil
+}
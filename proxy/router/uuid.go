@@ -0,0 +1,177 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package router
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the 100ns intervals between the uuid epoch 1582-10-15 and the unix epoch
+const uuidEpochOffset = 0x01B21DD213814000
+
+//parse the uuid_swap_flag of the table, which is only for the uuid key
+func parseUUIDSwapFlag(r *Rule, cfg *config.ShardConfig) error {
+	if cfg.UUIDSwapFlag && r.KeyType != UUIDKeyType {
+		return fmt.Errorf("uuid_swap_flag of table %s is only for key_type %s", cfg.Table, UUIDKeyType)
+	}
+	r.UUIDSwapFlag = cfg.UUIDSwapFlag
+	return nil
+}
+
+//parseUUID returns the 16 bytes of uuid in the order of its text. value is
+//the text with or without dashes, such as 6ccd780c-baba-1026-9564-5b8c656024db,
+//or the 16 bytes of a binary(16) column, which are in the order of
+//uuid_to_bin(uuid, 1) if swapped.
+func parseUUID(value interface{}, swapped bool) ([]byte, error) {
+	var s string
+	switch val := value.(type) {
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		return nil, fmt.Errorf("invalid uuid %v", value)
+	}
+
+	if len(s) == 16 {
+		if !swapped {
+			return []byte(s), nil
+		}
+		//uuid_to_bin(uuid, 1) moves the time-high part to the front and
+		//the time-low part after the time-mid part
+		b := make([]byte, 0, 16)
+		b = append(b, s[4:8]...)
+		b = append(b, s[2:4]...)
+		b = append(b, s[0:2]...)
+		return append(b, s[8:]...), nil
+	}
+
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	if len(s) == 36 {
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return nil, fmt.Errorf("invalid uuid %s", s)
+		}
+		s = strings.Replace(s, "-", "", -1)
+	}
+	if len(s) != 32 {
+		return nil, fmt.Errorf("invalid uuid %s", s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uuid %s", s)
+	}
+	return b, nil
+}
+
+//format uuid in lower case with dashes
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+//the unix time in seconds of the timestamp of a version 1 uuid
+func uuidTime(b []byte) (int64, error) {
+	if b[6]>>4 != 1 {
+		return 0, fmt.Errorf("uuid %s is not version 1", formatUUID(b))
+	}
+	ts := uint64(b[6]&0x0f)<<56 | uint64(b[7])<<48 |
+		uint64(b[4])<<40 | uint64(b[5])<<32 |
+		uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	return (int64(ts) - uuidEpochOffset) / 10000000, nil
+}
+
+//normalizeKey returns the value routed of a uuid key, which is the text
+//in lower case with dashes, so the different forms of a uuid are routed
+//to the same sub table. For the date shards, it is the unix time of the
+//version 1 uuid. The other keys are not changed.
+func (r *Rule) normalizeKey(value interface{}) (interface{}, error) {
+	if r.KeyType != UUIDKeyType {
+		return value, nil
+	}
+	b, err := parseUUID(value, r.UUIDSwapFlag)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Type {
+	case DateYearRuleType, DateMonthRuleType, DateDayRuleType:
+		return uuidTime(b)
+	}
+	return formatUUID(b), nil
+}
+
+//the value of a uuid key in the text of lower case with dashes, so the
+//hot keys of the different forms are the same. The value which is not a
+//uuid is returned as it is.
+func (plan *Plan) getUUIDValue(valExpr sqlparser.ValExpr) interface{} {
+	value := plan.getUUIDLiteral(valExpr)
+	if b, err := parseUUID(value, plan.Rule.UUIDSwapFlag); err == nil {
+		return formatUUID(b)
+	}
+	return value
+}
+
+//the value of a uuid key can also be a hexadecimal literal of binary(16),
+//such as x'11e6bf0b6ccd780c95645b8c656024db', or uuid_to_bin('...')
+func (plan *Plan) getUUIDLiteral(valExpr sqlparser.ValExpr) interface{} {
+	switch node := valExpr.(type) {
+	case sqlparser.NumVal:
+		s := strings.ToLower(string(node))
+		switch {
+		case strings.HasPrefix(s, "0x"):
+			s = s[2:]
+		case strings.HasPrefix(s, "x'"):
+			s = s[2 : len(s)-1]
+		default:
+			return getNumValue(string(node))
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			panic(sqlparser.NewParserError("invalid hexadecimal literal %s", string(node)))
+		}
+		return b
+	case *sqlparser.FuncExpr:
+		if !isUUIDToBin(node) {
+			break
+		}
+		//the text of uuid is in the original order, whatever the swap flag
+		arg := node.Exprs[0].(*sqlparser.NonStarExpr).Expr
+		return plan.getBoundValue(arg.(sqlparser.ValExpr))
+	}
+	return plan.getBoundValue(valExpr)
+}
+
+//uuid_to_bin('...') or uuid_to_bin('...', 1) with a uuid literal
+func isUUIDToBin(node *sqlparser.FuncExpr) bool {
+	if strings.ToLower(string(node.Name)) != "uuid_to_bin" ||
+		len(node.Exprs) < 1 || 2 < len(node.Exprs) {
+		return false
+	}
+	arg, ok := node.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return false
+	}
+	switch arg.Expr.(type) {
+	case sqlparser.StrVal, *sqlparser.CharsetStrVal:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	AuthLDAP    = "ldap"
+	AuthWebhook = "webhook"
+
+	//the password is sent in clear text for the external authentication
+	ClearPasswordPlugin = "mysql_clear_password"
+
+	DefaultAuthTimeout = 5 //seconds
+
+	//the format of secondary_password_expire, in local time
+	PasswordExpireFormat = "2006-01-02 15:04:05"
+)
+
+//Authenticator validates the user and password of a client, the users in
+//config are checked by kingshard itself, and the others by Authenticator.
+type Authenticator interface {
+	Name() string
+	Authenticate(user string, password string) error
+}
+
+func NewAuthenticator(cfg *config.AuthConfig) (Authenticator, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if cfg.Timeout <= 0 {
+		timeout = DefaultAuthTimeout * time.Second
+	}
+
+	var auth Authenticator
+	var err error
+	if len(cfg.Type) != 0 && !cfg.AllowClearPassword {
+		return nil, fmt.Errorf("auth %s gets the passwords of clients in clear text by %s without tls, "+
+			"set allow_clear_password to accept it", cfg.Type, ClearPasswordPlugin)
+	}
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case AuthLDAP:
+		auth, err = NewLDAPAuthenticator(cfg.Addr, cfg.BindDN, timeout)
+	case AuthWebhook:
+		auth, err = NewWebhookAuthenticator(cfg.Addr, timeout)
+	default:
+		return nil, fmt.Errorf("invalid auth type %s", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if 0 < cfg.CacheTTL {
+		auth = NewCachedAuthenticator(auth, time.Duration(cfg.CacheTTL)*time.Second)
+	}
+	golog.Warn("server", "NewAuthenticator", "INSECURE: the passwords of the users authenticated by "+
+		cfg.Type+" are sent by the clients in clear text, the client conns have no tls", 0,
+		"plugin", ClearPasswordPlugin)
+	return auth, nil
+}
+
+//CachedAuthenticator caches the successful authentications for ttl, so a
+//client reconnecting frequently does not overload the external service.
+//Only the hash of password is kept.
+type CachedAuthenticator struct {
+	sync.Mutex
+
+	auth    Authenticator
+	ttl     time.Duration
+	expires map[string]time.Time //user and the hash of password -> expire time
+}
+
+func NewCachedAuthenticator(auth Authenticator, ttl time.Duration) *CachedAuthenticator {
+	a := new(CachedAuthenticator)
+	a.auth = auth
+	a.ttl = ttl
+	a.expires = make(map[string]time.Time)
+	return a
+}
+
+func (a *CachedAuthenticator) Name() string {
+	return a.auth.Name()
+}
+
+func (a *CachedAuthenticator) Authenticate(user string, password string) error {
+	key := fmt.Sprintf("%s:%x", user, sha1.Sum([]byte(password)))
+	now := time.Now()
+	a.Lock()
+	expire, ok := a.expires[key]
+	a.Unlock()
+	if ok && now.Before(expire) {
+		return nil
+	}
+
+	if err := a.auth.Authenticate(user, password); err != nil {
+		a.Lock()
+		delete(a.expires, key)
+		a.Unlock()
+		return err
+	}
+
+	a.Lock()
+	//drop the expired entries, so the cache does not grow without limit
+	for k, v := range a.expires {
+		if !now.Before(v) {
+			delete(a.expires, k)
+		}
+	}
+	a.expires[key] = now.Add(a.ttl)
+	a.Unlock()
+	return nil
+}
+
+//WebhookAuthenticator posts the user and password in json to url, the
+//authentication succeeds if the response status is 200.
+type WebhookAuthenticator struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookAuthenticator(url string, timeout time.Duration) (*WebhookAuthenticator, error) {
+	if len(url) == 0 {
+		return nil, fmt.Errorf("webhook auth has no addr")
+	}
+	a := new(WebhookAuthenticator)
+	a.url = url
+	a.client = &http.Client{Timeout: timeout}
+	return a, nil
+}
+
+func (a *WebhookAuthenticator) Name() string {
+	return AuthWebhook
+}
+
+func (a *WebhookAuthenticator) Authenticate(user string, password string) error {
+	body, err := json.Marshal(map[string]string{"user": user, "password": password})
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook returns %s", resp.Status)
+	}
+	return nil
+}
+
+func checkPasswordExpires(cfg *config.Config) error {
+	expires := []string{cfg.SecondaryPasswordExpire}
+	for _, u := range cfg.Users {
+		expires = append(expires, u.SecondaryPasswordExpire)
+	}
+	for _, expire := range expires {
+		if len(expire) == 0 {
+			continue
+		}
+		if _, err := time.ParseInLocation(PasswordExpireFormat, expire, time.Local); err != nil {
+			return fmt.Errorf("invalid secondary_password_expire %s", expire)
+		}
+	}
+	return nil
+}
+
+//the secondary password never expires if expire is empty
+func isPasswordExpired(expire string, now time.Time) bool {
+	if len(expire) == 0 {
+		return false
+	}
+	t, err := time.ParseInLocation(PasswordExpireFormat, expire, time.Local)
+	return err != nil || !now.Before(t)
+}
+
+//authenticate the client, the users in config are checked by the auth
+//response of mysql_native_password, with the password or the secondary
+//password during a rotation. The other users are checked by the external
+//authenticator, the password in clear text is got by switching to
+//mysql_clear_password. plugin is the auth plugin of client.
+func (c *ClientConn) authenticate(auth []byte, plugin string) error {
+	passwords, ok := c.proxy.getPasswords(c.user)
+	if ok || c.state.authenticator == nil {
+		//the client using other plugin is asked to use mysql_native_password
+		if ok && len(plugin) != 0 && plugin != mysql.AUTH_NAME {
+			var err error
+			if auth, err = c.switchAuth(mysql.AUTH_NAME); err != nil {
+				return err
+			}
+		}
+		for i, password := range passwords {
+			if bytes.Equal(auth, mysql.CalcPassword(c.salt, []byte(password))) {
+				//the clients still using the secondary password are audited,
+				//so it can be dropped once no client uses it
+				if i == 0 {
+					c.auditAuth(mysql.AUTH_NAME, nil)
+				} else {
+					c.auditAuth(mysql.AUTH_NAME+"(secondary)", nil)
+				}
+				return nil
+			}
+		}
+		golog.Error("ClientConn", "readHandshakeResponse", "error", 0,
+			"client_user", c.user,
+			"config_set_user", c.state.cfg.User)
+		c.auditAuth(mysql.AUTH_NAME, fmt.Errorf("wrong password"))
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, c.user, c.c.RemoteAddr().String(), "Yes")
+	}
+
+	method := c.state.authenticator.Name()
+	if c.capability&mysql.CLIENT_PLUGIN_AUTH == 0 {
+		c.auditAuth(method, fmt.Errorf("client does not support %s", ClearPasswordPlugin))
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, c.user, c.c.RemoteAddr().String(), "Yes")
+	}
+	clearPassword, err := c.switchAuth(ClearPasswordPlugin)
+	if err != nil {
+		return err
+	}
+	if err = c.state.authenticator.Authenticate(c.user, string(clearPassword)); err != nil {
+		c.auditAuth(method, err)
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, c.user, c.c.RemoteAddr().String(), "Yes")
+	}
+	c.auditAuth(method, nil)
+	return nil
+}
+
+//send the auth switch request to plugin and read the auth response of
+//client, which is the password in clear text for mysql_clear_password
+func (c *ClientConn) switchAuth(plugin string) ([]byte, error) {
+	data := make([]byte, 4, 4+len(plugin)+len(c.salt)+3)
+	data = append(data, mysql.EOF_HEADER)
+	data = append(data, plugin...)
+	data = append(data, 0)
+	data = append(data, c.salt...)
+	data = append(data, 0)
+	if err := c.writePacket(data); err != nil {
+		return nil, err
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if plugin == ClearPasswordPlugin {
+		data = bytes.TrimRight(data, "\x00")
+	}
+	return data, nil
+}
+
+//the authentications are written into the sql log for audit
+func (c *ClientConn) auditAuth(method string, err error) {
+	if err != nil {
+		golog.OutputSql("AuthFail", "%s->%s:user=%s method=%s error=%s",
+			c.c.RemoteAddr(), c.proxy.addr, c.user, method, err.Error())
+		return
+	}
+	golog.OutputSql("AuthOK", "%s->%s:user=%s method=%s",
+		c.c.RemoteAddr(), c.proxy.addr, c.user, method)
+}
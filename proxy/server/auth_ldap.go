@@ -0,0 +1,225 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	LDAPUserPattern = "{user}"
+
+	//the tags of ber encoding used by ldap bind
+	berInteger         = 0x02
+	berOctetString     = 0x04
+	berEnumerated      = 0x0a
+	berSequence        = 0x30
+	ldapBindRequest    = 0x60
+	ldapBindResponse   = 0x61
+	ldapSimplePassword = 0x80
+
+	ldapVersion = 3
+	ldapSuccess = 0
+)
+
+//LDAPAuthenticator authenticates a user by the simple bind of ldap with the
+//dn of user, such as uid={user},ou=people,dc=example,dc=com.
+type LDAPAuthenticator struct {
+	addr    string
+	useTLS  bool
+	bindDN  string
+	timeout time.Duration
+}
+
+func NewLDAPAuthenticator(addr string, bindDN string, timeout time.Duration) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	a := new(LDAPAuthenticator)
+	var defaultPort string
+	switch u.Scheme {
+	case "ldap":
+		defaultPort = "389"
+	case "ldaps":
+		defaultPort = "636"
+		a.useTLS = true
+	default:
+		return nil, fmt.Errorf("invalid ldap addr %s", addr)
+	}
+	a.addr = u.Host
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		a.addr = net.JoinHostPort(u.Host, defaultPort)
+	}
+	if !strings.Contains(bindDN, LDAPUserPattern) {
+		return nil, fmt.Errorf("ldap bind_dn %s has no %s", bindDN, LDAPUserPattern)
+	}
+	a.bindDN = bindDN
+	a.timeout = timeout
+	return a, nil
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return AuthLDAP
+}
+
+func (a *LDAPAuthenticator) Authenticate(user string, password string) error {
+	//the bind with empty password is an unauthenticated bind, which
+	//always succeeds
+	if len(password) == 0 {
+		return fmt.Errorf("empty password")
+	}
+
+	dialer := &net.Dialer{Timeout: a.timeout}
+	var conn net.Conn
+	var err error
+	if a.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", a.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(a.timeout))
+
+	dn := strings.Replace(a.bindDN, LDAPUserPattern, escapeDN(user), -1)
+	if _, err = conn.Write(newBindRequest(1, dn, password)); err != nil {
+		return err
+	}
+	tag, content, err := readBer(conn)
+	if err != nil {
+		return err
+	}
+	if tag != berSequence {
+		return fmt.Errorf("invalid ldap response tag %x", tag)
+	}
+	return parseBindResponse(content)
+}
+
+//escape the special characters of a dn value, see RFC 4514
+func escapeDN(value string) string {
+	buf := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) != -1,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			buf = append(buf, '\\', c)
+		case c < 0x20:
+			buf = append(buf, fmt.Sprintf("\\%02x", c)...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf)
+}
+
+func appendBer(buf []byte, tag byte, content []byte) []byte {
+	buf = append(buf, tag)
+	n := len(content)
+	switch {
+	case n < 0x80:
+		buf = append(buf, byte(n))
+	case n < 0x100:
+		buf = append(buf, 0x81, byte(n))
+	case n < 0x10000:
+		buf = append(buf, 0x82, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, content...)
+}
+
+//BindRequest ::= [APPLICATION 0] SEQUENCE {
+//    version INTEGER, name LDAPDN, authentication [0] OCTET STRING }
+func newBindRequest(messageId byte, dn string, password string) []byte {
+	var bind []byte
+	bind = appendBer(bind, berInteger, []byte{ldapVersion})
+	bind = appendBer(bind, berOctetString, []byte(dn))
+	bind = appendBer(bind, ldapSimplePassword, []byte(password))
+
+	var msg []byte
+	msg = appendBer(msg, berInteger, []byte{messageId})
+	msg = appendBer(msg, ldapBindRequest, bind)
+	return appendBer(nil, berSequence, msg)
+}
+
+func readBer(r io.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(header[1])
+	if 0x80 <= n {
+		size := n & 0x7f
+		if size == 0 || 3 < size {
+			return 0, nil, fmt.Errorf("invalid ber length")
+		}
+		lenBuf := make([]byte, size)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return 0, nil, err
+		}
+		n = 0
+		for _, b := range lenBuf {
+			n = n<<8 | int(b)
+		}
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return header[0], content, nil
+}
+
+//LDAPMessage ::= SEQUENCE { messageID INTEGER, BindResponse }
+//BindResponse ::= [APPLICATION 1] SEQUENCE {
+//    resultCode ENUMERATED, matchedDN LDAPDN, diagnosticMessage LDAPString }
+func parseBindResponse(msg []byte) error {
+	r := strings.NewReader(string(msg))
+	if tag, _, err := readBer(r); err != nil || tag != berInteger {
+		return fmt.Errorf("invalid ldap message id")
+	}
+	tag, resp, err := readBer(r)
+	if err != nil || tag != ldapBindResponse {
+		return fmt.Errorf("invalid ldap bind response")
+	}
+
+	r = strings.NewReader(string(resp))
+	tag, code, err := readBer(r)
+	if err != nil || tag != berEnumerated || len(code) == 0 {
+		return fmt.Errorf("invalid ldap result code")
+	}
+	resultCode := 0
+	for _, b := range code {
+		resultCode = resultCode<<8 | int(b)
+	}
+	if resultCode == ldapSuccess {
+		return nil
+	}
+	var message []byte
+	if _, _, err = readBer(r); err == nil {
+		_, message, _ = readBer(r)
+	}
+	return fmt.Errorf("ldap bind error %d %s", resultCode, message)
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+)
+
+type countAuthenticator struct {
+	calls int
+}
+
+func (a *countAuthenticator) Name() string {
+	return "count"
+}
+
+func (a *countAuthenticator) Authenticate(user string, password string) error {
+	a.calls++
+	if password != "secret" {
+		return fmt.Errorf("wrong password")
+	}
+	return nil
+}
+
+func TestCachedAuthenticator(t *testing.T) {
+	count := new(countAuthenticator)
+	a := NewCachedAuthenticator(count, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := a.Authenticate("alice", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count.calls != 1 {
+		t.Fatal(count.calls)
+	}
+	//the failures are not cached
+	for i := 0; i < 2; i++ {
+		if err := a.Authenticate("alice", "wrong"); err == nil {
+			t.Fatal("wrong password must fail")
+		}
+	}
+	if count.calls != 3 {
+		t.Fatal(count.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := a.Authenticate("alice", "secret"); err != nil || count.calls != 4 {
+		t.Fatal(err, count.calls)
+	}
+}
+
+func TestWebhookAuthenticator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req["user"] != "alice" || req["password"] != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	a, err := NewAuthenticator(&config.AuthConfig{Type: AuthWebhook, Addr: ts.URL, AllowClearPassword: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Authenticate("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Authenticate("alice", "wrong"); err == nil {
+		t.Fatal("wrong password must fail")
+	}
+}
+
+//a ldap server which accepts the bind of dn with password secret
+func serveLDAP(t *testing.T, l net.Listener, dn string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		tag, msg, err := readBer(conn)
+		if err != nil || tag != berSequence {
+			t.Error("invalid bind request")
+			conn.Close()
+			continue
+		}
+		resultCode := byte(49) //invalidCredentials
+		if string(newBindRequest(1, dn, "secret")) == string(appendBer(nil, tag, msg)) {
+			resultCode = ldapSuccess
+		}
+
+		var resp []byte
+		resp = appendBer(resp, berEnumerated, []byte{resultCode})
+		resp = appendBer(resp, berOctetString, nil)
+		resp = appendBer(resp, berOctetString, []byte("bind result"))
+		var out []byte
+		out = appendBer(out, berInteger, []byte{1})
+		out = appendBer(out, ldapBindResponse, resp)
+		conn.Write(appendBer(nil, berSequence, out))
+		conn.Close()
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveLDAP(t, l, `uid=a\,b,ou=people,dc=example,dc=com`)
+
+	a, err := NewAuthenticator(&config.AuthConfig{
+		Type:               AuthLDAP,
+		Addr:               "ldap://" + l.Addr().String(),
+		BindDN:             "uid={user},ou=people,dc=example,dc=com",
+		AllowClearPassword: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Authenticate("a,b", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Authenticate("a,b", "wrong"); err == nil {
+		t.Fatal("wrong password must fail")
+	}
+	if err = a.Authenticate("a,b", ""); err == nil {
+		t.Fatal("empty password must fail")
+	}
+
+	if _, err = NewAuthenticator(&config.AuthConfig{Type: AuthLDAP, Addr: "http://x", BindDN: "uid={user}", AllowClearPassword: true}); err == nil {
+		t.Fatal("invalid ldap addr")
+	}
+	if _, err = NewAuthenticator(&config.AuthConfig{Type: "pam", AllowClearPassword: true}); err == nil {
+		t.Fatal("invalid auth type")
+	}
+	//the clear password must be allowed explicitly
+	if _, err = NewAuthenticator(&config.AuthConfig{
+		Type:   AuthLDAP,
+		Addr:   "ldap://" + l.Addr().String(),
+		BindDN: "uid={user},ou=people,dc=example,dc=com",
+	}); err == nil {
+		t.Fatal("the clear password is not allowed")
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"alice":   "alice",
+		"a,b=c":   `a\,b\=c`,
+		" #a ":    `\ #a\ `,
+		"#a":      `\#a`,
+		"a\x00b":  `a\00b`,
+		`a"b\c+d`: `a\"b\\c\+d`,
+	}
+	for value, expect := range tests {
+		if got := escapeDN(value); got != expect {
+			t.Fatalf("%q expect %s, got %s", value, expect, got)
+		}
+	}
+}
+
+func TestSecondaryPassword(t *testing.T) {
+	cfg := &config.Config{
+		User:              "root",
+		Password:          "new",
+		SecondaryPassword: "old",
+		Users: []config.UserConfig{
+			{User: "a", Password: "a2", SecondaryPassword: "a1", SecondaryPasswordExpire: "2000-01-01 00:00:00"},
+			{User: "b", Password: "b2", SecondaryPassword: "b1", SecondaryPasswordExpire: "2999-01-01 00:00:00"},
+		},
+	}
+	if err := checkPasswordExpires(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := newStateServer(cfg)
+
+	tests := map[string][]string{
+		"root": {"new", "old"},
+		"a":    {"a2"}, //the secondary password expired
+		"b":    {"b2", "b1"},
+	}
+	for user, expect := range tests {
+		passwords, ok := s.getPasswords(user)
+		if !ok || fmt.Sprint(passwords) != fmt.Sprint(expect) {
+			t.Fatalf("%s expect %v, got %v", user, expect, passwords)
+		}
+	}
+
+	cfg.Users[0].SecondaryPasswordExpire = "tomorrow"
+	if err := checkPasswordExpires(cfg); err == nil {
+		t.Fatal("invalid secondary_password_expire")
+	}
+}
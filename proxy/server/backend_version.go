@@ -0,0 +1,125 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the period of checking the versions of the masters in a rule
+const VersionCheckInterval = time.Minute
+
+//the version of a db, got by the health check
+type DBVersion struct {
+	Node    string
+	Addr    string
+	Type    string //master, slave or backup
+	Info    backend.ServerInfo
+	Unknown bool //the db is never connected
+}
+
+//the versions of all the dbs in the order of node names
+func (s *Server) DBVersions() []DBVersion {
+	schema := s.GetSchema()
+	names := make([]string, 0, len(schema.nodes))
+	for name := range schema.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var versions []DBVersion
+	add := func(node string, typ string, db *backend.DB) {
+		if db == nil {
+			return
+		}
+		info, ok := db.ServerInfo()
+		versions = append(versions, DBVersion{
+			Node:    node,
+			Addr:    db.Addr(),
+			Type:    typ,
+			Info:    info,
+			Unknown: !ok,
+		})
+	}
+	for _, name := range names {
+		n := schema.nodes[name]
+		add(name, "master", n.Master)
+		for _, db := range n.Slave {
+			add(name, "slave", db)
+		}
+		for _, db := range n.Backup {
+			add(name, "backup", db)
+		}
+	}
+	return versions
+}
+
+//the warnings of the rules whose nodes run different major versions, the
+//sqls of such a rule may behave differently by shard
+func (s *Server) VersionMismatches() []string {
+	schema := s.GetSchema()
+	majors := make(map[string]string)
+	for name, n := range schema.nodes {
+		if info, ok := n.Master.ServerInfo(); ok {
+			majors[name] = info.MajorVersion()
+		}
+	}
+
+	var rules []*router.Rule
+	for _, tables := range schema.rule.Rules {
+		for _, r := range tables {
+			rules = append(rules, r)
+		}
+	}
+	var warnings []string
+	for _, r := range rules {
+		var nodes []string
+		versions := make(map[string]bool)
+		for _, node := range r.Nodes {
+			if v := majors[node]; len(v) != 0 {
+				nodes = append(nodes, node+" "+v)
+				versions[v] = true
+			}
+		}
+		if 1 < len(versions) {
+			warnings = append(warnings, fmt.Sprintf("table %s.%s runs different versions: %s",
+				r.DB, r.Table, strings.Join(nodes, ", ")))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+//the warnings are logged once they change
+func (s *Server) checkVersionsLoop(interval time.Duration) {
+	var last string
+	for s.isRunning() {
+		time.Sleep(interval)
+		warnings := s.VersionMismatches()
+		if current := strings.Join(warnings, "\n"); current != last {
+			last = current
+			for _, w := range warnings {
+				golog.Warn("Server", "checkVersions", w, 0)
+			}
+		}
+	}
+}
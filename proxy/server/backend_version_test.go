@@ -0,0 +1,50 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionMismatches(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	versions := s.DBVersions()
+	if len(versions) != 2 || versions[0].Node != "node1" || versions[1].Info.MajorVersion() != "5.6" {
+		t.Fatal(versions)
+	}
+	if warnings := s.VersionMismatches(); len(warnings) != 0 {
+		t.Fatal(warnings)
+	}
+
+	//node2 is upgraded to 8.0
+	backends[1].Version = "8.0.32"
+	backends[1].SetDown(true)
+	master := s.GetSchema().nodes["node2"].Master
+	if err := master.Ping(); err == nil {
+		t.Fatal("ping error expected")
+	}
+	backends[1].SetDown(false)
+	if err := master.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	warnings := s.VersionMismatches()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "kingshard.t") ||
+		!strings.Contains(warnings[0], "node1 5.6, node2 8.0") {
+		t.Fatal(warnings)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"golang.org/x/net/context"
+)
+
+//watchClient sets the context of the statement being executed, which is
+//canceled if the client disconnects before the statement finishes, so the
+//queries in backend are killed instead of running for nobody. The client
+//is watched by peeking its conn, the data peeked such as a pipelined
+//command is kept for readPacket. The returned func stops the watch, and
+//must be called before reading the client again.
+func (c *ClientConn) watchClient() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.ctx = ctx
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		err := c.pkg.Peek()
+		select {
+		case <-done:
+		default:
+			if err != nil {
+				golog.Warn("ClientConn", "watchClient", "client is gone, cancel the statement",
+					c.connectionId, "error", err.Error())
+				cancel()
+			}
+		}
+		close(stopped)
+	}()
+
+	return func() {
+		close(done)
+		//wake up the peek
+		c.c.SetReadDeadline(time.Now())
+		<-stopped
+		c.c.SetReadDeadline(time.Time{})
+		cancel()
+		c.ctx = context.Background()
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func newWatchedConn(t *testing.T) (*ClientConn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	co, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ClientConn{c: co, pkg: mysql.NewPacketIO(co)}
+	return c, client
+}
+
+func TestWatchClient(t *testing.T) {
+	c, client := newWatchedConn(t)
+	defer c.c.Close()
+
+	stop := c.watchClient()
+	time.Sleep(10 * time.Millisecond)
+	if c.ctx.Err() != nil {
+		t.Fatal("the client is alive")
+	}
+	stop()
+
+	//the command pipelined during the statement is kept
+	stop = c.watchClient()
+	ctx := c.ctx
+	client.Write([]byte{1, 0, 0, 0, mysql.COM_PING})
+	time.Sleep(10 * time.Millisecond)
+	stop()
+	if ctx.Err() == nil || c.ctx.Err() != nil {
+		t.Fatal("the context is canceled once the statement finishes")
+	}
+	data, err := c.readPacket()
+	if err != nil || !bytes.Equal(data, []byte{mysql.COM_PING}) {
+		t.Fatal(data, err)
+	}
+
+	//the statement is canceled once the client disconnects
+	stop = c.watchClient()
+	client.Close()
+	select {
+	case <-c.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the context is not canceled")
+	}
+	stop()
+}
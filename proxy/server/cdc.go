@@ -0,0 +1,416 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const (
+	DefaultCDCSubject  = "kingshard"
+	DefaultCDCServerId = 1001
+
+	//the master sends a heartbeat if there is no event in cdcHeartbeat,
+	//the stream is reconnected if nothing is read in 3 heartbeats
+	cdcHeartbeat     = 10 * time.Second
+	cdcRetryInterval = 3 * time.Second
+)
+
+//the types of change events
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+//ChangeEvent is a row changed in a sub table, it is published in json to
+//the subject of its logical table. Before is nil for insert, and After is
+//nil for delete.
+type ChangeEvent struct {
+	DB       string                 `json:"db"`
+	Table    string                 `json:"table"`
+	SubTable string                 `json:"sub_table"`
+	Node     string                 `json:"node"`
+	Type     string                 `json:"type"`
+	Time     int64                  `json:"time"`   //the unix time of the binlog event
+	Binlog   string                 `json:"binlog"` //file:pos of the binlog event
+	Before   map[string]interface{} `json:"before,omitempty"`
+	After    map[string]interface{} `json:"after,omitempty"`
+}
+
+//ChangePublisher publishes the change events, the built-in one is nats,
+//a service embedding kingshard can set others such as kafka by
+//SetChangePublisher
+type ChangePublisher interface {
+	Publish(subject string, data []byte) error
+	Close() error
+}
+
+//SetChangePublisher publishes the change stream to p instead of the nats
+//of config, it must be called before Run
+func (s *Server) SetChangePublisher(p ChangePublisher) {
+	s.cdcPublisher = p
+}
+
+//the columns of a sub table from information_schema.columns
+type cdcColumn struct {
+	Name     string
+	Unsigned bool
+	Text     bool //text and json columns are published as string
+}
+
+//binlogStream tails the binlog of the master of a node, and publishes
+//the changes of the sharding tables
+type binlogStream struct {
+	s        *Server
+	node     string
+	serverId uint32
+
+	sync.Mutex
+	addr    string //the master which file and pos belong to
+	file    string //the position to resume, after the last transaction
+	pos     uint32
+	lastErr string
+	events  int64 //the change events published
+
+	tables  map[uint64]*mysql.TableMapEvent
+	columns map[string][]cdcColumn //db.sub_table -> columns
+}
+
+//CDCStatus is the status of the change stream of a node
+type CDCStatus struct {
+	Node   string
+	Addr   string
+	Binlog string //the position to resume
+	Events int64
+	Error  string //the last error
+}
+
+func (s *Server) startCDC() {
+	cfg := s.getState().cfg
+	serverId := cfg.CDC.ServerId
+	if serverId == 0 {
+		serverId = DefaultCDCServerId
+	}
+	for i, n := range cfg.Nodes {
+		b := &binlogStream{
+			s:        s,
+			node:     n.Name,
+			serverId: serverId + uint32(i),
+		}
+		s.cdcStreams = append(s.cdcStreams, b)
+		go b.run()
+	}
+}
+
+//GetCDCStatus returns the status of the change streams of the nodes
+func (s *Server) GetCDCStatus() []CDCStatus {
+	status := make([]CDCStatus, 0, len(s.cdcStreams))
+	for _, b := range s.cdcStreams {
+		b.Lock()
+		status = append(status, CDCStatus{
+			Node:   b.node,
+			Addr:   b.addr,
+			Binlog: fmt.Sprintf("%s:%d", b.file, b.pos),
+			Events: atomic.LoadInt64(&b.events),
+			Error:  b.lastErr,
+		})
+		b.Unlock()
+	}
+	return status
+}
+
+func (s *Server) cdcSubject(rule *router.Rule) string {
+	prefix := s.getState().cfg.CDC.Subject
+	if len(prefix) == 0 {
+		prefix = DefaultCDCSubject
+	}
+	return fmt.Sprintf("%s.%s.%s", prefix, rule.DB, rule.Table)
+}
+
+//whether the changes of the logical table of rule are published
+func (s *Server) isCDCTable(rule *router.Rule) bool {
+	cfg := s.getState().cfg
+	if len(cfg.CDC.Tables) == 0 {
+		return true
+	}
+	for _, t := range cfg.CDC.Tables {
+		if strings.EqualFold(t, rule.DB+"."+rule.Table) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *binlogStream) run() {
+	for b.s.isRunning() {
+		err := b.stream()
+		if !b.s.isRunning() {
+			return
+		}
+		b.Lock()
+		b.lastErr = err.Error()
+		b.Unlock()
+		golog.Error("Server", "cdc", err.Error(), 0, "node", b.node)
+		time.Sleep(cdcRetryInterval)
+	}
+}
+
+//dump the binlog from the position to resume until an error, the events
+//after the position are published again, so a change may be published
+//more than once
+func (b *binlogStream) stream() error {
+	n := b.s.GetNode(b.node)
+	if n == nil || n.Master == nil {
+		return fmt.Errorf("node %s has no master", b.node)
+	}
+	addr := n.Master.Addr()
+	b.Lock()
+	if addr != b.addr {
+		//the position of the old master is invalid in the new one
+		if len(b.addr) != 0 {
+			golog.Warn("Server", "cdc", "master changed, dump from its current position", 0,
+				"node", b.node, "old", b.addr, "new", addr)
+		}
+		b.addr, b.file, b.pos = addr, "", 0
+	}
+	b.Unlock()
+
+	conn := new(backend.Conn)
+	conn.SetTimeouts(backend.Timeouts{
+		Connect: backend.DefaultConnectTimeout,
+		Read:    3 * cdcHeartbeat,
+		Write:   backend.DefaultWriteTimeout,
+	})
+	conn.SetTransport(n.Transport)
+	if err := conn.Connect(addr, n.Cfg.User, n.Cfg.Password, ""); err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(b.file) == 0 {
+		r, err := conn.Execute("show master status")
+		if err != nil {
+			return err
+		}
+		if r.Resultset == nil || r.RowNumber() == 0 {
+			return fmt.Errorf("binlog is disabled in %s", addr)
+		}
+		file, _ := r.GetString(0, 0)
+		pos, _ := r.GetUint(0, 1)
+		b.setPosition(file, uint32(pos))
+	}
+	if err := conn.StartBinlogDump(b.serverId, b.file, b.pos, cdcHeartbeat); err != nil {
+		return err
+	}
+	golog.Info("Server", "cdc", "binlog dump started", 0,
+		"node", b.node, "addr", addr, "binlog", fmt.Sprintf("%s:%d", b.file, b.pos))
+
+	b.tables = make(map[uint64]*mysql.TableMapEvent)
+	b.columns = make(map[string][]cdcColumn)
+	file := b.file
+	for {
+		data, err := conn.ReadBinlogEvent()
+		if err != nil {
+			return err
+		}
+		h, err := mysql.ParseBinlogEventHeader(data)
+		if err != nil {
+			return err
+		}
+		body := data[mysql.BinlogEventHeaderSize:]
+		switch {
+		case h.EventType == mysql.ROTATE_EVENT:
+			e, err := mysql.ParseRotateEvent(body)
+			if err != nil {
+				return err
+			}
+			file = e.NextFile
+			b.setPosition(file, uint32(e.Position))
+		case h.EventType == mysql.TABLE_MAP_EVENT:
+			e, err := mysql.ParseTableMapEvent(body)
+			if err != nil {
+				return err
+			}
+			b.tables[e.TableId] = e
+		case mysql.IsRowsEvent(h.EventType):
+			if err = b.publishRows(h, body, file); err != nil {
+				return err
+			}
+		case h.EventType == mysql.XID_EVENT:
+			b.setPosition(file, h.LogPos)
+		case h.EventType == mysql.QUERY_EVENT:
+			//the columns may be changed by ddl
+			if query := queryOfEvent(body); !strings.EqualFold(query, "BEGIN") {
+				b.columns = make(map[string][]cdcColumn)
+				b.setPosition(file, h.LogPos)
+			}
+		}
+	}
+}
+
+func (b *binlogStream) setPosition(file string, pos uint32) {
+	b.Lock()
+	b.file, b.pos = file, pos
+	b.Unlock()
+}
+
+//the sql of a query event, after the thread id, exec time, length of db,
+//error code, status vars and db
+func queryOfEvent(body []byte) string {
+	if len(body) < 13 {
+		return ""
+	}
+	dbLen := int(body[8])
+	statusLen := int(body[11]) | int(body[12])<<8
+	pos := 13 + statusLen + dbLen + 1
+	if len(body) < pos {
+		return ""
+	}
+	return string(body[pos:])
+}
+
+func (b *binlogStream) publishRows(h *mysql.BinlogEventHeader, body []byte, file string) error {
+	tableId, err := mysql.RowsEventTableId(body)
+	if err != nil {
+		return err
+	}
+	table := b.tables[tableId]
+	if table == nil {
+		return fmt.Errorf("no table map of table id %d", tableId)
+	}
+	schema := b.s.GetSchema()
+	rule := schema.rule.SubTableRule(table.Table)
+	if rule == nil || schema.rule.GetRule(table.Schema, rule.Table) != rule || !b.s.isCDCTable(rule) {
+		return nil
+	}
+
+	columns, err := b.getColumns(schema, table)
+	if err != nil {
+		return err
+	}
+	e, err := mysql.ParseRowsEvent(h.EventType, body, table)
+	if err != nil {
+		return err
+	}
+
+	event := ChangeEvent{
+		DB:       table.Schema,
+		Table:    rule.Table,
+		SubTable: table.Table,
+		Node:     b.node,
+		Time:     int64(h.Timestamp),
+		Binlog:   fmt.Sprintf("%s:%d", file, h.LogPos-h.EventSize),
+	}
+	step := 1
+	switch h.EventType {
+	case mysql.WRITE_ROWS_EVENTv1, mysql.WRITE_ROWS_EVENTv2:
+		event.Type = ChangeInsert
+	case mysql.UPDATE_ROWS_EVENTv1, mysql.UPDATE_ROWS_EVENTv2:
+		event.Type = ChangeUpdate
+		step = 2
+	default:
+		event.Type = ChangeDelete
+	}
+
+	subject := b.s.cdcSubject(rule)
+	for i := 0; i+step <= len(e.Rows); i += step {
+		switch event.Type {
+		case ChangeInsert:
+			event.After = cdcRow(columns, e.Rows[i])
+		case ChangeUpdate:
+			event.Before = cdcRow(columns, e.Rows[i])
+			event.After = cdcRow(columns, e.Rows[i+1])
+		case ChangeDelete:
+			event.Before = cdcRow(columns, e.Rows[i])
+		}
+		data, err := json.Marshal(&event)
+		if err != nil {
+			return err
+		}
+		if err = b.s.cdcPublisher.Publish(subject, data); err != nil {
+			return err
+		}
+		atomic.AddInt64(&b.events, 1)
+	}
+	return nil
+}
+
+func cdcRow(columns []cdcColumn, values []interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(columns))
+	for i, c := range columns {
+		v := values[i]
+		if b, ok := v.([]byte); ok && c.Text {
+			v = string(b)
+		}
+		row[c.Name] = v
+	}
+	return row
+}
+
+//the columns of table in the master of node, the unsigned flags are set
+//in table. They are got again if the count differs from the table map.
+func (b *binlogStream) getColumns(schema *Schema, table *mysql.TableMapEvent) ([]cdcColumn, error) {
+	key := table.Schema + "." + table.Table
+	columns, ok := b.columns[key]
+	if !ok || len(columns) != len(table.ColumnTypes) {
+		n := schema.nodes[b.node]
+		if n == nil {
+			return nil, fmt.Errorf("invalid node %s", b.node)
+		}
+		conn, err := n.GetMasterConn()
+		if err != nil {
+			return nil, err
+		}
+		r, err := conn.Execute(fmt.Sprintf("select column_name, column_type from information_schema.columns"+
+			" where table_schema = '%s' and table_name = '%s' order by ordinal_position",
+			mysql.Escape(table.Schema), mysql.Escape(table.Table)))
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		columns = nil
+		for i := 0; r.Resultset != nil && i < r.RowNumber(); i++ {
+			name, _ := r.GetString(i, 0)
+			columnType, _ := r.GetString(i, 1)
+			columnType = strings.ToLower(columnType)
+			columns = append(columns, cdcColumn{
+				Name:     name,
+				Unsigned: strings.Contains(columnType, "unsigned"),
+				Text:     strings.Contains(columnType, "text") || columnType == "json",
+			})
+		}
+		if len(columns) != len(table.ColumnTypes) {
+			return nil, fmt.Errorf("%s has %d columns, but binlog has %d", key, len(columns), len(table.ColumnTypes))
+		}
+		b.columns[key] = columns
+	}
+
+	table.Unsigned = make([]bool, len(columns))
+	for i, c := range columns {
+		table.Unsigned[i] = c.Unsigned
+	}
+	return columns, nil
+}
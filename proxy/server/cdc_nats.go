@@ -0,0 +1,197 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+)
+
+const DefaultNatsTimeout = 5 * time.Second
+
+//NatsPublisher publishes messages by the text protocol of nats, see
+//https://docs.nats.io/reference/reference-protocols/nats-protocol. It
+//connects on the first publish, and again after an error. TLS is not
+//supported.
+type NatsPublisher struct {
+	sync.Mutex
+
+	addr     string
+	user     string
+	password string
+	timeout  time.Duration
+
+	conn   net.Conn
+	closed bool
+}
+
+func NewNatsPublisher(addr string, timeout time.Duration) (*NatsPublisher, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid nats addr %s", addr)
+	}
+	p := new(NatsPublisher)
+	p.addr = u.Host
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		p.addr = net.JoinHostPort(u.Host, "4222")
+	}
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	p.timeout = timeout
+	return p, nil
+}
+
+func (p *NatsPublisher) Publish(subject string, data []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return fmt.Errorf("nats publisher is closed")
+	}
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0, len(subject)+len(data)+32)
+	buf = append(buf, fmt.Sprintf("PUB %s %d\r\n", subject, len(data))...)
+	buf = append(buf, data...)
+	buf = append(buf, "\r\n"...)
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	if _, err := p.conn.Write(buf); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *NatsPublisher) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
+
+//read the INFO of server, send CONNECT and wait for the PONG of PING, so
+//the errors of auth are returned
+func (p *NatsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("invalid nats info %s", strings.TrimSpace(line))
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err = json.Unmarshal([]byte(line[5:]), &info); err != nil {
+		conn.Close()
+		return err
+	}
+	if info.TLSRequired {
+		conn.Close()
+		return fmt.Errorf("nats %s requires tls", p.addr)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "kingshard",
+	}
+	if len(p.user) != 0 {
+		options["user"] = p.user
+		options["pass"] = p.password
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err = conn.Write([]byte(fmt.Sprintf("CONNECT %s\r\nPING\r\n", data))); err != nil {
+		conn.Close()
+		return err
+	}
+	if line, err = r.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	}
+	if line = strings.TrimSpace(line); line != "PONG" {
+		conn.Close()
+		return fmt.Errorf("nats connect error %s", line)
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.conn = conn
+	go p.readLoop(conn, r)
+	return nil
+}
+
+//answer the PING of server, and log the errors such as a message too
+//large, the server closes the conn after an error
+func (p *NatsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.Lock()
+			if p.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(p.timeout))
+				conn.Write([]byte("PONG\r\n"))
+			}
+			p.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			golog.Error("NatsPublisher", "readLoop", line, 0, "addr", p.addr)
+		}
+	}
+
+	p.Lock()
+	if p.conn == conn {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.Unlock()
+}
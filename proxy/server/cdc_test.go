@@ -0,0 +1,201 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+type cdcMessage struct {
+	subject string
+	data    []byte
+}
+
+type chanPublisher chan cdcMessage
+
+func (p chanPublisher) Publish(subject string, data []byte) error {
+	p <- cdcMessage{subject, data}
+	return nil
+}
+
+func (p chanPublisher) Close() error {
+	return nil
+}
+
+func TestCDC(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[1].Handle(`^show master status`, &mysqltest.Response{
+		Names: []string{"File", "Position"},
+		Rows:  [][]interface{}{{"mysql-bin.000001", 4}},
+	})
+	backends[1].Handle(`from information_schema.columns`, &mysqltest.Response{
+		Names: []string{"column_name", "column_type"},
+		Rows:  [][]interface{}{{"id", "int(10) unsigned"}, {"name", "text"}},
+	})
+
+	//insert into t_0001 values (1, 'a'), and a table not sharded
+	tableMap := func(tableId byte, table string) []byte {
+		body := []byte{tableId, 0, 0, 0, 0, 0, 1, 0, 9}
+		body = append(body, "kingshard"...)
+		body = append(body, 0, byte(len(table)))
+		body = append(body, table...)
+		return append(body, 0, 2, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_BLOB, 1, 1, 0)
+	}
+	writeRows := func(tableId byte) []byte {
+		return []byte{tableId, 0, 0, 0, 0, 0, 1, 0, 2, 0, 2, 0x03, 0x00, 1, 0, 0, 0, 1, 'a'}
+	}
+	rotate := append([]byte{4, 0, 0, 0, 0, 0, 0, 0}, "mysql-bin.000001"...)
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.ROTATE_EVENT, 0, rotate))
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.TABLE_MAP_EVENT, 100, tableMap(1, "t_0001")))
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.WRITE_ROWS_EVENTv2, 150, writeRows(1)))
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.TABLE_MAP_EVENT, 200, tableMap(2, "t2")))
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.WRITE_ROWS_EVENTv2, 250, writeRows(2)))
+	backends[1].AddBinlogEvent(mysqltest.BinlogEvent(mysql.XID_EVENT, 300, make([]byte, 8)))
+
+	p := make(chanPublisher, 10)
+	s.SetChangePublisher(p)
+	s.startCDC()
+
+	var msg cdcMessage
+	select {
+	case msg = <-p:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change event")
+	}
+	if msg.subject != "kingshard.kingshard.t" {
+		t.Fatal(msg.subject)
+	}
+	var event ChangeEvent
+	if err := json.Unmarshal(msg.data, &event); err != nil {
+		t.Fatal(err)
+	}
+	expect := ChangeEvent{DB: "kingshard", Table: "t", SubTable: "t_0001", Node: "node2",
+		Type: ChangeInsert, Time: event.Time, Binlog: "mysql-bin.000001:112",
+		After: map[string]interface{}{"id": float64(1), "name": "a"}}
+	if !reflect.DeepEqual(event, expect) {
+		t.Fatal(event)
+	}
+
+	//the position is after the transaction
+	for i := 0; ; i++ {
+		status := s.GetCDCStatus()
+		if len(status) == 2 && status[1].Binlog == "mysql-bin.000001:300" && status[1].Events == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal(status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case msg = <-p:
+		t.Fatal(string(msg.data))
+	default:
+	}
+}
+
+//a fake nats server, the messages published are sent to msgs
+func newFakeNats(t *testing.T, msgs chan cdcMessage) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				fmt.Fprintf(c, "INFO {\"server_id\":\"fake\"}\r\n")
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					var subject string
+					var size int
+					switch {
+					case strings.HasPrefix(line, "PING"):
+						fmt.Fprintf(c, "PONG\r\n")
+					case strings.HasPrefix(line, "PUB "):
+						fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+						data := make([]byte, size+2)
+						if _, err = io.ReadFull(r, data); err != nil {
+							return
+						}
+						msgs <- cdcMessage{subject, data[:size]}
+					}
+				}
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestNatsPublisher(t *testing.T) {
+	msgs := make(chan cdcMessage, 10)
+	l := newFakeNats(t, msgs)
+	defer l.Close()
+
+	if _, err := NewNatsPublisher("http://"+l.Addr().String(), time.Second); err == nil {
+		t.Fatal("invalid nats addr")
+	}
+	p, err := NewNatsPublisher("nats://"+l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err = p.Publish("kingshard.db.t", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	if msg.subject != "kingshard.db.t" || string(msg.data) != "hello" {
+		t.Fatal(msg)
+	}
+
+	//connect again after the conn is broken
+	p.Lock()
+	p.conn.Close()
+	p.Unlock()
+	p.Publish("kingshard.db.t", []byte("lost"))
+	if err = p.Publish("kingshard.db.t", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	for msg = range msgs {
+		if string(msg.data) == "again" {
+			break
+		}
+	}
+
+	p.Close()
+	if err = p.Publish("kingshard.db.t", []byte("closed")); err == nil {
+		t.Fatal("the publisher is closed")
+	}
+}
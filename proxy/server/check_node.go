@@ -0,0 +1,197 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	DefaultHeartbeatTable = "kingshard.heartbeat"
+	//the heartbeat is the unix nano of the write
+	HeartbeatTableSql = "create table if not exists %s " +
+		"(node varchar(64) not null primary key, heartbeat varchar(32) not null)"
+
+	//the max time waiting for the heartbeat to be replicated to a slave,
+	//and the interval of the reads of slaves
+	CheckReplicationTimeout  = 5 * time.Second
+	CheckReplicationInterval = 10 * time.Millisecond
+)
+
+//DBCheck is the result of the end-to-end check of a db, the times are
+//zero for the steps not done
+type DBCheck struct {
+	Node  string
+	Addr  string
+	Type  string
+	State string
+	//the time to dial and authenticate, and the round trip of select 1
+	Connect   time.Duration
+	RoundTrip time.Duration
+	//the time of the heartbeat write in master
+	Write time.Duration
+	//the time from the write in master until the heartbeat is read in
+	//the slave or backup
+	Replication time.Duration
+	Err         error
+}
+
+//CheckNode dials the master, slaves and backups of node with new conns,
+//writes a heartbeat into the master and waits for it in the others, for
+//the triage of the node
+func (s *Server) CheckNode(name string) ([]*DBCheck, error) {
+	n := s.GetNode(name)
+	if n == nil {
+		return nil, fmt.Errorf("invalid node %s", name)
+	}
+	table := s.heartbeatTable()
+
+	n.RLock()
+	master := n.Master
+	replicas := make([]*DBCheck, 0, len(n.Slave)+len(n.Backup))
+	dbs := make([]*backend.DB, 0, cap(replicas))
+	for _, db := range n.Slave {
+		replicas = append(replicas, &DBCheck{Type: Slave})
+		dbs = append(dbs, db)
+	}
+	for _, db := range n.Backup {
+		replicas = append(replicas, &DBCheck{Type: "backup"})
+		dbs = append(dbs, db)
+	}
+	n.RUnlock()
+
+	//the heartbeat is the time of the write in nanoseconds, so each check
+	//has its own value
+	heartbeat := strconv.FormatInt(time.Now().UnixNano(), 10)
+	checks := make([]*DBCheck, 0, len(replicas)+1)
+	var written time.Time
+	if master != nil {
+		check := &DBCheck{Type: Master}
+		co := dialCheck(name, master, check)
+		if co != nil {
+			written = writeHeartbeat(co, check, table, name, heartbeat)
+			co.Close()
+		}
+		checks = append(checks, check)
+	}
+
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		check := replicas[i]
+		co := dialCheck(name, db, check)
+		if co == nil {
+			continue
+		}
+		if written.IsZero() {
+			check.Err = fmt.Errorf("no heartbeat written in master")
+			co.Close()
+			continue
+		}
+		wg.Add(1)
+		go func(co *backend.Conn) {
+			waitHeartbeat(co, check, table, name, heartbeat, written)
+			co.Close()
+			wg.Done()
+		}(co)
+	}
+	wg.Wait()
+	return append(checks, replicas...), nil
+}
+
+func (s *Server) heartbeatTable() string {
+	cfg := s.getState().cfg
+	if len(cfg.HeartbeatTable) == 0 {
+		return DefaultHeartbeatTable
+	}
+	return cfg.HeartbeatTable
+}
+
+//dial db and run select 1, the conn is nil if any step fails
+func dialCheck(node string, db *backend.DB, check *DBCheck) *backend.Conn {
+	check.Node = node
+	check.Addr = db.Addr()
+	check.State = db.State()
+
+	start := time.Now()
+	co, err := db.Dial()
+	if err != nil {
+		check.Err = err
+		return nil
+	}
+	check.Connect = time.Since(start)
+
+	start = time.Now()
+	if _, err = co.Execute("select 1"); err != nil {
+		check.Err = err
+		co.Close()
+		return nil
+	}
+	check.RoundTrip = time.Since(start)
+	return co
+}
+
+//write the heartbeat of node into table, the time written is zero if it
+//fails
+func writeHeartbeat(co *backend.Conn, check *DBCheck, table string, node string, heartbeat string) time.Time {
+	_, err := co.Execute(fmt.Sprintf(HeartbeatTableSql, table))
+	if err != nil {
+		check.Err = err
+		return time.Time{}
+	}
+
+	start := time.Now()
+	_, err = co.Execute(fmt.Sprintf("replace into %s (node, heartbeat) values ('%s', '%s')",
+		table, mysql.Escape(node), heartbeat))
+	if err != nil {
+		check.Err = err
+		return time.Time{}
+	}
+	written := time.Now()
+	check.Write = written.Sub(start)
+	return written
+}
+
+//read the heartbeat of node in table until it is replicated
+func waitHeartbeat(co *backend.Conn, check *DBCheck, table string, node string, heartbeat string, written time.Time) {
+	sql := fmt.Sprintf("select heartbeat from %s where node = '%s'", table, mysql.Escape(node))
+	for {
+		r, err := co.Execute(sql)
+		//the table created in master may be not replicated yet
+		if e, ok := err.(*mysql.SqlError); ok && e.Code == mysql.ER_NO_SUCH_TABLE {
+			r, err = nil, nil
+		}
+		if err != nil {
+			check.Err = err
+			return
+		}
+		if r != nil && r.Resultset != nil && 0 < r.RowNumber() {
+			if v, _ := r.GetString(0, 0); v == heartbeat {
+				check.Replication = time.Since(written)
+				return
+			}
+		}
+		if CheckReplicationTimeout < time.Since(written) {
+			check.Err = fmt.Errorf("heartbeat not replicated in %v", CheckReplicationTimeout)
+			return
+		}
+		time.Sleep(CheckReplicationInterval)
+	}
+}
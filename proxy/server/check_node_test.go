@@ -0,0 +1,80 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestCheckNode(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, readAfterWriteConfig)
+	defer close()
+
+	//the slave replicates the heartbeat written in master
+	done := make(chan struct{}, 1)
+	defer func() {
+		done <- struct{}{}
+	}()
+	go func() {
+		re := regexp.MustCompile(`^replace into kingshard.heartbeat .* values \('node1', '(\d+)'\)`)
+		for {
+			for _, q := range backends[0].Queries() {
+				if m := re.FindStringSubmatch(q); m != nil {
+					backends[1].Handle(`^select heartbeat from kingshard.heartbeat`, &mysqltest.Response{
+						Names: []string{"heartbeat"},
+						Rows:  [][]interface{}{{m[1]}},
+					})
+					return
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	r, err := c.Execute("admin server(opt,k,v) values('check','node','node1')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Values) != 2 {
+		t.Fatal(r.Values)
+	}
+	get := func(row int, name string) string {
+		v, _ := r.GetStringByName(row, name)
+		return v
+	}
+	for i, typ := range []string{"master", "slave"} {
+		if get(i, "Type") != typ || get(i, "Error") != "" {
+			t.Fatal(i, r.Values[i])
+		}
+		if get(i, "Connect_ms") == "" || get(i, "RoundTrip_ms") == "" {
+			t.Fatal(i, r.Values[i])
+		}
+	}
+	if get(0, "Write_ms") == "" || get(1, "Replication_ms") == "" {
+		t.Fatal(r.Values)
+	}
+
+	if _, err = c.Execute("admin server(opt,k,v) values('check','node','node9')"); err == nil {
+		t.Fatal("expect error of invalid node")
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+)
+
+const (
+	DefaultClusterPrefix   = "/kingshard"
+	DefaultClusterInterval = 3 //seconds
+
+	//an instance not synced in ClusterExpireIntervals intervals is ignored
+	ClusterExpireIntervals = 3
+)
+
+//InstanceState is the state an instance shares with the cluster
+type InstanceState struct {
+	Name string
+	Time int64 //the unix time of the last sync
+	//the version of the cluster rules in use, 0 means the rules of its
+	//config file
+	RuleVersion int64
+	DownSlaves  []string         //node/addr of the slaves down in the instance
+	Conns       map[string]int64 //the new conns of client ips in their window
+	BannedIPs   []BannedIP
+	Self        bool `json:"-"`
+}
+
+//ClusterRules are the rules published by the last instance reloaded, the
+//other instances reload them in their next sync
+type ClusterRules struct {
+	Version  int64 //the unix nano time of the publish
+	Instance string
+	Schema   config.SchemaConfig
+}
+
+//Cluster syncs the state of the instance with the other instances by etcd
+//every interval. A slave down in any instance is not read by the others,
+//the new conns of a client ip in all the instances are limited by
+//conn_limit, and the rules of the instance reloaded are reloaded by the
+//others, so they route the same.
+type Cluster struct {
+	sync.Mutex
+
+	proxy    *Server
+	store    *EtcdStore
+	name     string
+	prefix   string
+	interval time.Duration
+
+	ruleVersion   int64 //the version of the cluster rules in use
+	failedVersion int64 //the version of the cluster rules failed to reload
+	instances     []InstanceState
+	lastErr       string
+}
+
+func NewCluster(s *Server, cfg config.ClusterConfig) (*Cluster, error) {
+	store, err := NewEtcdStore(cfg.EtcdAddrs, DefaultEtcdTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Cluster)
+	c.proxy = s
+	c.store = store
+	c.name = cfg.Name
+	if len(c.name) == 0 {
+		c.name = defaultInstanceName(s.getState().cfg.Addr)
+	}
+	c.prefix = strings.TrimRight(cfg.Prefix, "/")
+	if len(c.prefix) == 0 {
+		c.prefix = DefaultClusterPrefix
+	}
+	c.interval = time.Duration(cfg.Interval) * time.Second
+	if cfg.Interval <= 0 {
+		c.interval = DefaultClusterInterval * time.Second
+	}
+	return c, nil
+}
+
+//the host name and the port of proxy
+func defaultInstanceName(addr string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return host + ":" + port
+	}
+	return host
+}
+
+func (c *Cluster) instanceKey(name string) string {
+	return c.prefix + "/instances/" + name
+}
+
+func (c *Cluster) rulesKey() string {
+	return c.prefix + "/rules"
+}
+
+//run syncs until the server is closed, the instance is removed from the
+//cluster at last
+func (c *Cluster) run(done <-chan struct{}) {
+	for {
+		if err := c.sync(); err != nil {
+			golog.Error("Cluster", "sync", err.Error(), 0, "instance", c.name)
+		}
+		select {
+		case <-done:
+			if err := c.store.Delete(c.instanceKey(c.name)); err != nil {
+				golog.Error("Cluster", "run", err.Error(), 0, "instance", c.name)
+			}
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+//sync publishes the state of the instance, and applies the states of the
+//other instances and the cluster rules
+func (c *Cluster) sync() error {
+	err := c.doSync()
+	c.Lock()
+	c.lastErr = ""
+	if err != nil {
+		c.lastErr = err.Error()
+	}
+	c.Unlock()
+	return err
+}
+
+func (c *Cluster) doSync() error {
+	now := time.Now()
+	state := c.localState(now.Unix())
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = c.store.Put(c.instanceKey(c.name), data); err != nil {
+		return err
+	}
+
+	values, err := c.store.List(c.prefix + "/")
+	if err != nil {
+		return err
+	}
+	var rules *ClusterRules
+	state.Self = true
+	instances := []InstanceState{state}
+	expire := now.Add(-ClusterExpireIntervals * c.interval).Unix()
+	for key, value := range values {
+		if key == c.rulesKey() {
+			rules = new(ClusterRules)
+			if err = json.Unmarshal(value, rules); err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, c.instanceKey("")) || key == c.instanceKey(c.name) {
+			continue
+		}
+		var peer InstanceState
+		if err = json.Unmarshal(value, &peer); err != nil {
+			golog.Warn("Cluster", "sync", "invalid instance state", 0, "key", key)
+			continue
+		}
+		if peer.Time < expire {
+			continue
+		}
+		instances = append(instances, peer)
+	}
+	sort.Sort(instancesByName(instances))
+
+	c.applyPeers(instances)
+	c.Lock()
+	c.instances = instances
+	c.Unlock()
+	if rules != nil {
+		c.applyRules(rules)
+	}
+	return nil
+}
+
+type instancesByName []InstanceState
+
+func (p instancesByName) Len() int           { return len(p) }
+func (p instancesByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p instancesByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (c *Cluster) localState(now int64) InstanceState {
+	state := InstanceState{
+		Name:      c.name,
+		Time:      now,
+		Conns:     c.proxy.connLimit.Rates(),
+		BannedIPs: c.proxy.GetBannedIPs(),
+	}
+	c.Lock()
+	state.RuleVersion = c.ruleVersion
+	c.Unlock()
+	for name, n := range c.proxy.GetAllNodes() {
+		for _, addr := range n.DownSlaves() {
+			state.DownSlaves = append(state.DownSlaves, name+"/"+addr)
+		}
+	}
+	sort.Strings(state.DownSlaves)
+	return state
+}
+
+//the slaves down in the peers are not read, and the new conns and bans
+//of the peers count in conn limit
+func (c *Cluster) applyPeers(instances []InstanceState) {
+	downs := make(map[string]map[string]bool)
+	conns := make(map[string]int64)
+	var banned []BannedIP
+	for _, peer := range instances {
+		if peer.Name == c.name {
+			continue
+		}
+		for _, slave := range peer.DownSlaves {
+			i := strings.Index(slave, "/")
+			if i < 0 {
+				continue
+			}
+			node := slave[:i]
+			if downs[node] == nil {
+				downs[node] = make(map[string]bool)
+			}
+			downs[node][slave[i+1:]] = true
+		}
+		for ip, n := range peer.Conns {
+			conns[ip] += n
+		}
+		banned = append(banned, peer.BannedIPs...)
+	}
+
+	for name, n := range c.proxy.GetAllNodes() {
+		n.SetClusterDown(downs[name])
+	}
+	c.proxy.connLimit.SetRemote(conns, banned)
+}
+
+//reload the cluster rules published by another instance, or by this
+//instance before a restart
+func (c *Cluster) applyRules(rules *ClusterRules) {
+	c.Lock()
+	skip := rules.Version <= c.ruleVersion || rules.Version == c.failedVersion
+	c.Unlock()
+	if skip {
+		return
+	}
+
+	s := c.proxy
+	s.reloadLock.Lock()
+	cfg := *s.getState().cfg
+	cfg.Schema = rules.Schema
+	err := s.reload(&cfg)
+	s.reloadLock.Unlock()
+
+	c.Lock()
+	defer c.Unlock()
+	if err != nil {
+		c.failedVersion = rules.Version
+		golog.Error("Cluster", "applyRules", err.Error(), 0,
+			"version", rules.Version,
+			"instance", rules.Instance)
+		return
+	}
+	c.ruleVersion = rules.Version
+	golog.Info("Cluster", "applyRules", "cluster rules reloaded", 0,
+		"version", rules.Version,
+		"instance", rules.Instance)
+}
+
+//PublishRules publishes the rules of the instance reloaded as the cluster
+//rules
+func (c *Cluster) PublishRules(schema config.SchemaConfig) error {
+	rules := ClusterRules{
+		Version:  time.Now().UnixNano(),
+		Instance: c.name,
+		Schema:   schema,
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	if err = c.store.Put(c.rulesKey(), data); err != nil {
+		return err
+	}
+	c.Lock()
+	c.ruleVersion = rules.Version
+	c.Unlock()
+	return nil
+}
+
+//the instances of the last sync in the order of names, and the error of
+//the last sync
+func (c *Cluster) Status() ([]InstanceState, string) {
+	c.Lock()
+	defer c.Unlock()
+	return c.instances, c.lastErr
+}
+
+//GetClusterStatus returns nil if the server is not in a cluster
+func (s *Server) GetClusterStatus() ([]InstanceState, string) {
+	if s.cluster == nil {
+		return nil, ""
+	}
+	return s.cluster.Status()
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultEtcdTimeout = 5 * time.Second
+
+//EtcdStore reads and writes the keys of etcd by the json gateway of etcd
+//v3, see https://etcd.io/docs/v3.5/dev-guide/api_grpc_gateway. The addrs
+//are tried in turn until one succeeds. Auth and TLS client certs are not
+//supported.
+type EtcdStore struct {
+	sync.Mutex
+
+	addrs  []string
+	last   int //the index of the addr succeeded last
+	client *http.Client
+}
+
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+func NewEtcdStore(addrs string, timeout time.Duration) (*EtcdStore, error) {
+	e := new(EtcdStore)
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimRight(strings.TrimSpace(addr), "/")
+		if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			return nil, fmt.Errorf("invalid etcd addr %s", addr)
+		}
+		e.addrs = append(e.addrs, addr)
+	}
+	e.client = &http.Client{Timeout: timeout}
+	return e, nil
+}
+
+func (e *EtcdStore) Put(key string, value []byte) error {
+	return e.post("/v3/kv/put", etcdKeyValue{
+		Key:   base64.StdEncoding.EncodeToString([]byte(key)),
+		Value: base64.StdEncoding.EncodeToString(value),
+	}, nil)
+}
+
+func (e *EtcdStore) Delete(key string) error {
+	return e.post("/v3/kv/deleterange", etcdRangeRequest{
+		Key: base64.StdEncoding.EncodeToString([]byte(key)),
+	}, nil)
+}
+
+//List returns the values of the keys with prefix
+func (e *EtcdStore) List(prefix string) (map[string][]byte, error) {
+	var resp etcdRangeResponse
+	err := e.post("/v3/kv/range", etcdRangeRequest{
+		Key:      base64.StdEncoding.EncodeToString([]byte(prefix)),
+		RangeEnd: base64.StdEncoding.EncodeToString(prefixEnd(prefix)),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		values[string(key)] = value
+	}
+	return values, nil
+}
+
+//the end of the range of the keys with prefix, which is prefix with the
+//last byte increased
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; 0 <= i; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	//all the keys
+	return []byte{0}
+}
+
+func (e *EtcdStore) post(path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	start := e.last
+	e.Unlock()
+	for i := 0; i < len(e.addrs); i++ {
+		index := (start + i) % len(e.addrs)
+		if err = e.postAddr(e.addrs[index]+path, body, resp); err == nil {
+			e.Lock()
+			e.last = index
+			e.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+func (e *EtcdStore) postAddr(url string, body []byte, resp interface{}) error {
+	r, err := e.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s returns %s", url, r.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+)
+
+//a fake json gateway of etcd v3 with the keys in memory
+type fakeEtcd struct {
+	sync.Mutex
+	kvs map[string]string
+}
+
+func newFakeEtcd() (*fakeEtcd, *httptest.Server) {
+	e := &fakeEtcd{kvs: make(map[string]string)}
+	return e, httptest.NewServer(e)
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key      string `json:"key"`
+		Value    string `json:"value"`
+		RangeEnd string `json:"range_end"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, _ := base64.StdEncoding.DecodeString(req.Key)
+	end, _ := base64.StdEncoding.DecodeString(req.RangeEnd)
+
+	e.Lock()
+	defer e.Unlock()
+	switch r.URL.Path {
+	case "/v3/kv/put":
+		e.kvs[string(key)] = req.Value
+		w.Write([]byte(`{}`))
+	case "/v3/kv/deleterange":
+		delete(e.kvs, string(key))
+		w.Write([]byte(`{}`))
+	case "/v3/kv/range":
+		var kvs []etcdKeyValue
+		for k, v := range e.kvs {
+			if string(key) <= k && k < string(end) {
+				kvs = append(kvs, etcdKeyValue{base64.StdEncoding.EncodeToString([]byte(k)), v})
+			}
+		}
+		json.NewEncoder(w).Encode(etcdRangeResponse{Kvs: kvs})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *fakeEtcd) get(key string) []byte {
+	e.Lock()
+	defer e.Unlock()
+	value, _ := base64.StdEncoding.DecodeString(e.kvs[key])
+	return value
+}
+
+func TestEtcdStore(t *testing.T) {
+	_, ts := newFakeEtcd()
+	defer ts.Close()
+
+	//the unreachable addr is skipped
+	store, err := NewEtcdStore("http://127.0.0.1:1,"+ts.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/ks/a", "/ks/b", "/ksx"} {
+		if err := store.Put(key, []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, err := store.List("/ks/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || string(values["/ks/a"]) != "v/ks/a" || string(values["/ks/b"]) != "v/ks/b" {
+		t.Fatal(values)
+	}
+	if err := store.Delete("/ks/a"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ = store.List("/ks/"); len(values) != 1 {
+		t.Fatal(values)
+	}
+
+	if _, err := NewEtcdStore("127.0.0.1:2379", time.Second); err == nil {
+		t.Fatal("addr without scheme")
+	}
+}
+
+var clusterConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+conn_limit :
+    window : 60
+    max_conns : 5
+
+cluster :
+    etcd_addrs : %s
+    name : ks1
+    interval : 3600
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %%[1]s
+    slave : %%[2]s
+-
+    name : node2
+    user : root
+    master : %%[2]s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [1,1]
+`
+
+//the down slaves, conns and rules of the other instances are applied
+func TestCluster(t *testing.T) {
+	etcd, ts := newFakeEtcd()
+	defer ts.Close()
+	s, backends, _, close := newFakeProxy(t, fmt.Sprintf(clusterConfig, ts.URL))
+	defer close()
+
+	slave := backends[1].Addr()
+	peer, _ := json.Marshal(InstanceState{
+		Name:       "ks2",
+		Time:       time.Now().Unix(),
+		DownSlaves: []string{"node1/" + slave},
+		Conns:      map[string]int64{"10.0.0.1": 5},
+	})
+	stale, _ := json.Marshal(InstanceState{
+		Name:  "ks3",
+		Time:  time.Now().Unix() - 3*3600 - 1,
+		Conns: map[string]int64{"10.0.0.2": 5},
+	})
+	store := s.cluster.store
+	store.Put("/kingshard/instances/ks2", peer)
+	store.Put("/kingshard/instances/ks3", stale)
+	if err := s.cluster.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the slave down in ks2 is not read, though it is up here
+	if _, err := s.GetNode("node1").GetSlaveConn(); err != errors.ErrSlaveDown {
+		t.Fatal(err)
+	}
+	//the conns of ks2 count, and the stale ks3 is ignored
+	if err := s.connLimit.Allow("10.0.0.1"); err != errors.ErrIPBanned {
+		t.Fatal(err)
+	}
+	if err := s.connLimit.Allow("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	instances, lastErr := s.GetClusterStatus()
+	if len(instances) != 2 || instances[0].Name != "ks1" || !instances[0].Self ||
+		instances[1].Name != "ks2" || len(lastErr) != 0 {
+		t.Fatal(instances, lastErr)
+	}
+
+	//the slave is read again once ks2 has it up
+	peer, _ = json.Marshal(InstanceState{Name: "ks2", Time: time.Now().Unix()})
+	store.Put("/kingshard/instances/ks2", peer)
+	if err := s.cluster.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if co, err := s.GetNode("node1").GetSlaveConn(); err != nil {
+		t.Fatal(err)
+	} else {
+		co.Close()
+	}
+	//the new conns here are shared
+	var self InstanceState
+	if err := json.Unmarshal(etcd.get("/kingshard/instances/ks1"), &self); err != nil {
+		t.Fatal(err)
+	}
+	if self.Conns["10.0.0.2"] != 1 {
+		t.Fatal(self)
+	}
+
+	//the rules published by ks2 are reloaded
+	schema := s.getState().cfg.Schema
+	schema.ShardRule = append([]config.ShardConfig{}, schema.ShardRule...)
+	schema.ShardRule = append(schema.ShardRule, config.ShardConfig{
+		DB: "kingshard", Table: "t2", Key: "id", Nodes: []string{"node1", "node2"},
+		Type: "hash", Locations: []int{2, 2},
+	})
+	rules, _ := json.Marshal(ClusterRules{Version: 1, Instance: "ks2", Schema: schema})
+	store.Put("/kingshard/rules", rules)
+	if err := s.cluster.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if loc := s.Locate("kingshard", "t2", "3"); loc[0].Err != nil || loc[0].SubTable != "t2_0003" {
+		t.Fatal(loc[0])
+	}
+
+	//the rules reloaded here are published
+	cfg := *s.getState().cfg
+	cfg.Schema.ShardRule = cfg.Schema.ShardRule[:1]
+	if err := s.Reload(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	var published ClusterRules
+	if err := json.Unmarshal(etcd.get("/kingshard/rules"), &published); err != nil {
+		t.Fatal(err)
+	}
+	if published.Instance != "ks1" || published.Version <= 1 || len(published.Schema.ShardRule) != 1 {
+		t.Fatal(published)
+	}
+	if err := s.cluster.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if loc := s.Locate("kingshard", "t2", "3"); loc[0].Err != nil || loc[0].SubTable != "t2" {
+		t.Fatal(loc[0])
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+const (
+	MaskKeep = "keep"
+	MaskFull = "full"
+	MaskNull = "null"
+
+	//the value of a column masked by full
+	FullMaskValue = "****"
+)
+
+type columnMask struct {
+	config.ColumnMaskConfig
+	kind string
+	head int //the characters kept by keep(head,tail)
+	tail int
+	//matches the column name in a sql
+	pattern *regexp.Regexp
+}
+
+//ColumnMasker masks the columns in the results of the selects by the users
+//and tables of its masks, so a user such as an analyst does not see the
+//sensitive data, e.g. a phone 13812340000 is 138****0000 by keep(3,4)
+type ColumnMasker struct {
+	masks []*columnMask
+}
+
+func NewColumnMasker(cfgs []config.ColumnMaskConfig) (*ColumnMasker, error) {
+	m := new(ColumnMasker)
+	for i, cfg := range cfgs {
+		cfg.DB = strings.Trim(cfg.DB, "`")
+		cfg.Table = strings.Trim(cfg.Table, "`")
+		cfg.Column = strings.Trim(cfg.Column, "`")
+		if len(cfg.Column) == 0 {
+			return nil, fmt.Errorf("column mask %d has no column", i)
+		}
+		mask, err := parseMask(cfg.Mask)
+		if err != nil {
+			return nil, err
+		}
+		mask.ColumnMaskConfig = cfg
+		mask.pattern = regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(cfg.Column) + `\b`)
+		m.masks = append(m.masks, mask)
+	}
+	return m, nil
+}
+
+//mask is keep(m,n), full or null
+func parseMask(mask string) (*columnMask, error) {
+	s := strings.ToLower(strings.Replace(mask, " ", "", -1))
+	switch s {
+	case MaskFull, MaskNull:
+		return &columnMask{kind: s}, nil
+	}
+	if strings.HasPrefix(s, MaskKeep+"(") && strings.HasSuffix(s, ")") {
+		args := strings.Split(s[len(MaskKeep)+1:len(s)-1], ",")
+		if len(args) == 2 {
+			head, err1 := strconv.Atoi(args[0])
+			tail, err2 := strconv.Atoi(args[1])
+			if err1 == nil && err2 == nil && 0 <= head && 0 <= tail {
+				return &columnMask{kind: MaskKeep, head: head, tail: tail}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid column mask %s", mask)
+}
+
+//HasUser returns true if some masks apply to user
+func (m *ColumnMasker) HasUser(user string) bool {
+	for _, mask := range m.masks {
+		if len(mask.User) == 0 || mask.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+//the mask of column of db.table for user, nil if it is not masked
+func (m *ColumnMasker) Match(user string, db string, table string, column string) *columnMask {
+	for _, mask := range m.masks {
+		if (len(mask.User) == 0 || mask.User == user) &&
+			matchName(mask.DB, db) && matchName(mask.Table, table) &&
+			strings.EqualFold(mask.Column, column) {
+			return mask
+		}
+	}
+	return nil
+}
+
+//the name of the first masked column of user referred in sql, empty if none
+func (m *ColumnMasker) referredColumn(user string, sql string) string {
+	for _, mask := range m.masks {
+		if (len(mask.User) == 0 || mask.User == user) && mask.pattern.MatchString(sql) {
+			return mask.Column
+		}
+	}
+	return ""
+}
+
+func (mask *columnMask) apply(value interface{}) (interface{}, error) {
+	if value == nil || mask.kind == MaskNull {
+		return nil, nil
+	}
+	if mask.kind == MaskFull {
+		return FullMaskValue, nil
+	}
+	b, err := formatValue(value)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(string(b))
+	if len(runes) <= mask.head+mask.tail {
+		return strings.Repeat("*", len(runes)), nil
+	}
+	return string(runes[:mask.head]) +
+		strings.Repeat("*", len(runes)-mask.head-mask.tail) +
+		string(runes[len(runes)-mask.tail:]), nil
+}
+
+//the first keyword of sql in lower case, the hints are skipped
+func sqlKeyword(sql string) string {
+	for _, token := range strings.FieldsFunc(sql, hack.IsSqlSep) {
+		if !strings.HasPrefix(token, "*") {
+			return strings.ToLower(strings.TrimLeft(token, "("))
+		}
+	}
+	return ""
+}
+
+func maskedColumnError(column string, reason string) error {
+	return mysql.NewError(mysql.ER_KS_COLUMN_MASKED,
+		fmt.Sprintf("masked column %s %s", column, reason))
+}
+
+//the sqls returning rows of a user with masks can only read the masked
+//columns as they are, since the result of an expression, a subquery, a
+//derived table or a common table expression referring to a masked
+//column can not be masked
+func (c *ClientConn) checkMaskedSql(sql string) error {
+	masker := c.state.masker
+	if masker == nil || !masker.HasUser(c.user) {
+		return nil
+	}
+	keyword := sqlKeyword(sql)
+	switch keyword {
+	case "select", "with", "values", "table", "handler", "call":
+	default:
+		return nil
+	}
+
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		//the columns of the common table expressions are derived
+		column := masker.referredColumn(c.user, sql)
+		if len(column) == 0 && keyword == "with" && strings.Contains(sql, "*") {
+			column = "*"
+		}
+		if len(column) != 0 {
+			return maskedColumnError(column, "can only be selected in the sql parsed by kingshard")
+		}
+		return nil
+	}
+	return c.checkMaskedStatement(stmt)
+}
+
+func (c *ClientConn) checkMaskedStatement(stmt sqlparser.Statement) error {
+	masker := c.state.masker
+	switch v := stmt.(type) {
+	case *sqlparser.Select:
+		return c.checkMaskedSelect(v)
+	case *sqlparser.Union:
+		if column := masker.referredColumn(c.user, sqlparser.String(v)); len(column) != 0 {
+			return maskedColumnError(column, "can not be selected in union")
+		}
+	case *sqlparser.With:
+		for _, cte := range v.Ctes {
+			s := sqlparser.String(cte.Subquery)
+			column := masker.referredColumn(c.user, s)
+			if len(column) == 0 && strings.Contains(s, "*") {
+				column = "*"
+			}
+			if len(column) != 0 {
+				return maskedColumnError(column, "can not be selected in common table expression")
+			}
+		}
+		return c.checkMaskedStatement(v.Select)
+	}
+	return nil
+}
+
+func (c *ClientConn) checkMaskedSelect(stmt *sqlparser.Select) error {
+	masker := c.state.masker
+	for _, expr := range stmt.SelectExprs {
+		e, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			continue
+		}
+		if _, ok := e.Expr.(*sqlparser.ColName); ok {
+			continue
+		}
+		if column := masker.referredColumn(c.user, sqlparser.String(e.Expr)); len(column) != 0 {
+			return maskedColumnError(column, "can only be selected")
+		}
+	}
+
+	var walk func(expr sqlparser.TableExpr) error
+	walk = func(expr sqlparser.TableExpr) error {
+		switch e := expr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if sub, ok := e.Expr.(*sqlparser.Subquery); ok {
+				s := sqlparser.String(sub)
+				column := masker.referredColumn(c.user, s)
+				if len(column) == 0 && strings.Contains(s, "*") {
+					column = "*"
+				}
+				if len(column) != 0 {
+					return maskedColumnError(column, "can not be selected in derived table")
+				}
+			}
+		case *sqlparser.ParenTableExpr:
+			return walk(e.Expr)
+		case *sqlparser.JoinTableExpr:
+			if err := walk(e.LeftExpr); err != nil {
+				return err
+			}
+			return walk(e.RightExpr)
+		}
+		return nil
+	}
+	for _, expr := range stmt.From {
+		if err := walk(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//the rows of a prepared statement are in the binary protocol, which are
+//not rewritten, so its masked columns are rejected
+func (c *ClientConn) checkMaskedPrepare(stmt *sqlparser.Select, r *mysql.Resultset) error {
+	if c.state.masker == nil || !c.state.masker.HasUser(c.user) {
+		return nil
+	}
+	if err := c.checkMaskedSelect(stmt); err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	if _, masked := c.fieldMasks(r.Fields, fromTables(stmt.From, c.db)); len(masked) != 0 {
+		return maskedColumnError(masked[0], "can not be selected in prepared statement")
+	}
+	return nil
+}
+
+//mask the result of a sql sent to the backend without rewrite
+func (c *ClientConn) maskRawResult(r *mysql.Resultset, sql string) error {
+	if c.state.masker == nil || !c.state.masker.HasUser(c.user) {
+		return nil
+	}
+	var tables []*sqlparser.TableName
+	if stmt, err := sqlparser.Parse(sql); err == nil {
+		if with, ok := stmt.(*sqlparser.With); ok {
+			stmt = with.Select
+		}
+		if sel, ok := stmt.(*sqlparser.Select); ok {
+			tables = fromTables(sel.From, c.db)
+		}
+	}
+	return c.maskResult(r, tables)
+}
+
+//the logical db of the db of a field, which may be renamed in a node
+func (c *ClientConn) logicalDB(db string) string {
+	for _, node := range c.state.cfg.Nodes {
+		for from, to := range node.SchemaRewrite {
+			if to == db {
+				return from
+			}
+		}
+	}
+	return db
+}
+
+//the masks of the fields for the user and the names of the masked columns.
+//A field is matched by its db, table and column, the field without table,
+//such as of a fake backend, is matched by its name and the tables of the
+//select. The sub tables are matched as their logical tables.
+func (c *ClientConn) fieldMasks(fields []*mysql.Field, tables []*sqlparser.TableName) ([]*columnMask, []string) {
+	masker := c.state.masker
+	var rule *router.Router
+	if c.schema != nil {
+		rule = c.schema.rule
+	}
+	masks := make([]*columnMask, len(fields))
+	var masked []string
+	for i, f := range fields {
+		column := string(f.OrgName)
+		if len(column) == 0 {
+			column = string(f.Name)
+		}
+		table := string(f.OrgTable)
+		if len(table) == 0 {
+			table = string(f.Table)
+		}
+		if len(table) != 0 {
+			db := c.db
+			if len(f.Schema) != 0 {
+				db = c.logicalDB(string(f.Schema))
+			}
+			masks[i] = masker.Match(c.user, db, logicalTable(rule, table), column)
+		} else {
+			for _, t := range tables {
+				if masks[i] = masker.Match(c.user, string(t.Qualifier), logicalTable(rule, string(t.Name)),
+					column); masks[i] != nil {
+					break
+				}
+			}
+		}
+		if masks[i] != nil {
+			masked = append(masked, column)
+		}
+	}
+	return masks, masked
+}
+
+//mask the columns of r for the user, the masked access is written into
+//the sql log for audit
+func (c *ClientConn) maskResult(r *mysql.Resultset, tables []*sqlparser.TableName) error {
+	if r == nil || c.state.masker == nil || !c.state.masker.HasUser(c.user) {
+		return nil
+	}
+	masks, masked := c.fieldMasks(r.Fields, tables)
+	if len(masked) == 0 {
+		return nil
+	}
+
+	fields := make([]*mysql.Field, len(r.Fields))
+	names := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		fields[i] = f
+		names[i] = string(f.Name)
+		if masks[i] == nil {
+			continue
+		}
+		field := *f
+		field.Data = nil
+		field.Charset = 33
+		field.Type = mysql.MYSQL_TYPE_VAR_STRING
+		field.Flag = 0
+		field.Decimal = 0
+		fields[i] = &field
+	}
+	for _, row := range r.Values {
+		for i, mask := range masks {
+			if mask == nil {
+				continue
+			}
+			v, err := mask.apply(row[i])
+			if err != nil {
+				return err
+			}
+			row[i] = v
+		}
+	}
+
+	mr, err := c.buildResultset(fields, names, r.Values)
+	if err != nil {
+		return err
+	}
+	r.Fields = mr.Fields
+	r.RowDatas = mr.RowDatas
+	golog.OutputSql("Masked", "%s->%s:user=%s db=%s columns=%s rows=%d",
+		c.c.RemoteAddr(), c.proxy.addr, c.user, c.db,
+		strings.Join(masked, ","), len(r.Values))
+	return nil
+}
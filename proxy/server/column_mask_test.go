@@ -0,0 +1,124 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestParseMask(t *testing.T) {
+	phone := "13812340000"
+	tests := []struct {
+		mask  string
+		value interface{}
+		want  interface{}
+	}{
+		{"keep(3,4)", phone, "138****0000"},
+		{"keep(3, 4)", []byte(phone), "138****0000"},
+		{"keep(0,2)", int64(123456), "****56"},
+		{"keep(3,4)", "张三丰", "***"},
+		{"keep(1,0)", "张三丰", "张**"},
+		{"full", phone, FullMaskValue},
+		{"null", phone, nil},
+		{"full", nil, nil},
+	}
+	for _, test := range tests {
+		mask, err := parseMask(test.mask)
+		if err != nil {
+			t.Fatal(test.mask, err)
+		}
+		v, err := mask.apply(test.value)
+		if err != nil || v != test.want {
+			t.Fatal(test.mask, test.value, v, err)
+		}
+	}
+	for _, mask := range []string{"", "keep", "keep(3)", "keep(-1,2)", "keep(a,b)", "hash"} {
+		if _, err := parseMask(mask); err == nil {
+			t.Fatal(mask)
+		}
+	}
+}
+
+func TestColumnMask(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+column_masks :
+-
+    user : root
+    db : kingshard
+    table : t
+    column : phone
+    mask : keep(3,4)
+-
+    table : u
+    column : email
+    mask : full
+-
+    user : reporter
+    column : id
+    mask : "null"
+`)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^(/\*node\d\*/ )?(select|with)`, &mysqltest.Response{
+			Names: []string{"id", "phone", "email"},
+			Rows:  [][]interface{}{{1, "13812340000", "a@b.com"}},
+		})
+	}
+
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"select id, phone, email from t where id = 1", []string{"1", "138****0000", "a@b.com"}},
+		{"select * from t", []string{"1", "138****0000", "a@b.com"}},
+		{"select id, phone, email from u", []string{"1", "13812340000", FullMaskValue}},
+		{"select id, phone, email from v", []string{"1", "13812340000", "a@b.com"}},
+		{"select id, phone, email from t_0001", []string{"1", "138****0000", "a@b.com"}},
+		{"/*node2*/ select id, phone, email from t_0001", []string{"1", "138****0000", "a@b.com"}},
+		{"with a as (select 1) select id, phone, email from t", []string{"1", "138****0000", "a@b.com"}},
+	}
+	for _, test := range tests {
+		r, err := c.Execute(test.sql)
+		if err != nil {
+			t.Fatal(test.sql, err)
+		}
+		for i, want := range test.want {
+			if v, _ := r.GetString(0, i); v != want {
+				t.Fatal(test.sql, i, v)
+			}
+		}
+	}
+
+	for _, sql := range []string{
+		"select concat(phone, '') from t where id = 1",
+		"select (select max(phone) from t) from u",
+		"select a.id from (select phone as id from t) as a",
+		"select a.id from (select * from t) as a",
+		"with a as (select concat(phone, '') as p from t) select p from a",
+		"with a as (select * from t) select * from a",
+	} {
+		_, err := c.Execute(sql)
+		if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_COLUMN_MASKED {
+			t.Fatal(sql, err)
+		}
+	}
+	//the masked columns can be used in the conditions
+	if _, err := c.Execute("select id from t where id = 1 and phone != ''"); err != nil {
+		t.Fatal(err)
+	}
+}
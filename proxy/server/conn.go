@@ -21,11 +21,14 @@ import (
 	"net"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/core/hack"
 	"github.com/flike/kingshard/mysql"
+	"golang.org/x/net/context"
 )
 
 //client <-> proxy
@@ -37,6 +40,10 @@ type ClientConn struct {
 	c net.Conn
 
 	proxy *Server
+	//the state of proxy used by the session, it is held while a statement
+	//or transaction is running, see refreshSchema
+	state     *serverState
+	stateHeld bool
 
 	capability uint32
 
@@ -46,8 +53,9 @@ type ClientConn struct {
 	collation mysql.CollationId
 	charset   string
 
-	user string
-	db   string
+	user   string
+	db     string
+	tenant string //the tenant of tenant tables in the session
 
 	salt []byte
 
@@ -63,11 +71,62 @@ type ClientConn struct {
 	stmtId uint32
 
 	stmts map[uint32]*Stmt //prepare相关,client端到proxy的stmt
+
+	planCache *PlanCache //the plans of the recent sqls
+
+	warnings []*mysql.SqlError //the warnings of kingshard in the last statement
+	//the warnings not kept in warnings, they are counted only
+	droppedWarnings int
+
+	//the sqls of session are sent to the pinned node or shard
+	pinnedNode  *backend.Node
+	pinnedShard int
+	shardPinned bool
+
+	dryRun bool //return the rewritten sqls instead of executing them
+
+	//the session variables set by client, they are set in every backend
+	//conn used by the session, see conn_set.go
+	sessionVars map[string]string
+
+	//the state of the xa transaction, TxAutoCommit if none, see conn_xa.go
+	xa  TxState
+	xid string
+	//the transaction is rolled back for idle_in_tx_timeout, see idle_tx.go
+	idleTxKilled bool
+	//the selects of the transaction replayed if its conn is lost, see
+	//tx_failover.go
+	txLog txReplayLog
+
+	//the process shown by show processlist, see processlist.go
+	process processInfo
+
+	//the context of the statement being executed, it is done if the
+	//client disconnects, see watchClient
+	ctx context.Context
+
+	//the time of the last write, and the consistency of the reads of the
+	//statement, see read_after_write.go and consistency.go. The default of
+	//table overrides consistency only if defaultConsistency
+	lastWrite          time.Time
+	consistency        readConsistency
+	defaultConsistency bool
+
+	//the tag of the statement being executed, see query_tag.go
+	tag string
+	//the table and nodes of the statement being executed, see
+	//stats_history.go
+	history historyTargets
+	//the execution of the select being handled, see exec_strategy.go
+	exec execState
+	//the query attributes of the statement, see query_attrs.go
+	queryAttrs []mysql.QueryAttribute
 }
 
 var DEFAULT_CAPABILITY uint32 = mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_LONG_FLAG |
 	mysql.CLIENT_CONNECT_WITH_DB | mysql.CLIENT_PROTOCOL_41 |
-	mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION
+	mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION |
+	mysql.CLIENT_DEPRECATE_EOF | mysql.CLIENT_SESSION_TRACK | mysql.CLIENT_QUERY_ATTRIBUTES
 
 var baseConnId uint32 = 10000
 
@@ -125,7 +184,17 @@ func (c *ClientConn) Close() error {
 
 	c.c.Close()
 
+	//the transaction of a closed session is rolled back as by mysql, the
+	//prepared xa transaction is kept for its commit or rollback
+	if len(c.txConns) != 0 && c.xa != TxXAPrepared {
+		c.rollback()
+	}
+
 	c.closed = true
+	if c.stateHeld {
+		c.stateHeld = false
+		c.state.release()
+	}
 
 	return nil
 }
@@ -149,18 +218,24 @@ func (c *ClientConn) writeInitialHandshake() error {
 	//filter [00]
 	data = append(data, 0)
 
+	//the auth switch to mysql_clear_password needs plugin auth
+	capability := DEFAULT_CAPABILITY
+	if c.state.authenticator != nil {
+		capability |= mysql.CLIENT_PLUGIN_AUTH
+	}
+
 	//capability flag lower 2 bytes, using default capability here
-	data = append(data, byte(DEFAULT_CAPABILITY), byte(DEFAULT_CAPABILITY>>8))
+	data = append(data, byte(capability), byte(capability>>8))
 
 	//charset, utf-8 default
-	data = append(data, uint8(mysql.DEFAULT_COLLATION_ID))
+	data = append(data, uint8(c.proxy.collation))
 
 	//status
 	data = append(data, byte(c.status), byte(c.status>>8))
 
 	//below 13 byte may not be used
 	//capability flag upper 2 bytes, using default capability here
-	data = append(data, byte(DEFAULT_CAPABILITY>>16), byte(DEFAULT_CAPABILITY>>24))
+	data = append(data, byte(capability>>16), byte(capability>>24))
 
 	//filter [0x15], for wireshark dump, value is 0x15
 	data = append(data, 0x15)
@@ -174,6 +249,12 @@ func (c *ClientConn) writeInitialHandshake() error {
 	//filter [00]
 	data = append(data, 0)
 
+	//auth-plugin name
+	if capability&mysql.CLIENT_PLUGIN_AUTH != 0 {
+		data = append(data, mysql.AUTH_NAME...)
+		data = append(data, 0)
+	}
+
 	return c.writePacket(data)
 }
 
@@ -196,6 +277,12 @@ func (c *ClientConn) readHandshakeResponse() error {
 		return err
 	}
 
+	//the packet of a broken client must not panic
+	badHandshake := mysql.NewDefaultError(mysql.ER_HANDSHAKE_ERROR)
+	if len(data) <= 4+4+1+23 {
+		return badHandshake
+	}
+
 	pos := 0
 
 	//capability
@@ -213,39 +300,46 @@ func (c *ClientConn) readHandshakeResponse() error {
 	pos += 23
 
 	//user name
-	c.user = string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
+	end := bytes.IndexByte(data[pos:], 0)
+	if end == -1 || len(data) <= pos+end+1 {
+		return badHandshake
+	}
+	c.user = string(data[pos : pos+end])
 
 	pos += len(c.user) + 1
 
 	//auth length and auth
 	authLen := int(data[pos])
 	pos++
-	auth := data[pos : pos+authLen]
-
-	checkAuth := mysql.CalcPassword(c.salt, []byte(c.proxy.cfg.Password))
-	if c.user != c.proxy.cfg.User || !bytes.Equal(auth, checkAuth) {
-		golog.Error("ClientConn", "readHandshakeResponse", "error", 0,
-			"auth", auth,
-			"checkAuth", checkAuth,
-			"client_user", c.user,
-			"config_set_user", c.proxy.cfg.User,
-			"passworld", c.proxy.cfg.Password)
-		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, c.user, c.c.RemoteAddr().String(), "Yes")
+	if len(data) < pos+authLen {
+		return badHandshake
 	}
+	auth := data[pos : pos+authLen]
 
 	pos += authLen
 
 	var db string
-	if c.capability&mysql.CLIENT_CONNECT_WITH_DB > 0 {
-		if len(data[pos:]) == 0 {
-			return nil
+	if c.capability&mysql.CLIENT_CONNECT_WITH_DB > 0 && pos < len(data) {
+		if end = bytes.IndexByte(data[pos:], 0); end == -1 {
+			return badHandshake
 		}
+		db = string(data[pos : pos+end])
+		pos += len(db) + 1
+	}
+	c.db = db
 
-		db = string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
-		pos += len(c.db) + 1
+	//the auth plugin of client
+	var plugin string
+	if c.capability&mysql.CLIENT_PLUGIN_AUTH > 0 && pos < len(data) {
+		if i := bytes.IndexByte(data[pos:], 0); i != -1 {
+			plugin = string(data[pos : pos+i])
+		}
+	}
 
+	if err := c.authenticate(auth, plugin); err != nil {
+		return err
 	}
-	c.db = db
+	c.tenant = c.proxy.getTenant(c.user)
 
 	return nil
 }
@@ -267,6 +361,9 @@ func (c *ClientConn) Run() {
 	}()
 
 	for {
+		if err := c.waitIdleTx(); err != nil {
+			return
+		}
 		data, err := c.readPacket()
 
 		if err != nil {
@@ -296,14 +393,45 @@ func (c *ClientConn) dispatch(data []byte) error {
 	c.proxy.counter.IncrClientQPS()
 	cmd := data[0]
 	data = data[1:]
+	c.clearQueryAttributes()
+	if cmd == mysql.COM_QUERY {
+		var err error
+		if data, err = c.readQueryAttributes(data); err != nil {
+			return err
+		}
+	}
+	c.beginProcess(cmd, data)
+	defer c.endProcess()
+
+	c.refreshSchema()
+	defer c.releaseState()
+
+	//the warnings of the last statement are kept for show warnings only,
+	//see handleWarnings
+	if cmd != mysql.COM_QUERY {
+		c.clearWarnings()
+	}
+
+	//the next statement after the transaction is killed gets an error, so
+	//the client knows its transaction is lost
+	if c.idleTxKilled && (cmd == mysql.COM_QUERY || cmd == mysql.COM_STMT_EXECUTE) {
+		c.idleTxKilled = false
+		return errors.ErrIdleTxKilled
+	}
 
 	switch cmd {
 	case mysql.COM_QUIT:
-		c.handleRollback()
+		c.rollback()
 		c.Close()
 		return nil
 	case mysql.COM_QUERY:
-		return c.handleQuery(hack.String(data))
+		defer c.watchClient()()
+		sql := hack.String(data)
+		return c.runTagged(sql, func() error {
+			return c.handleTxFailover(sql, func() error {
+				return c.handleQuery(sql)
+			})
+		})
 	case mysql.COM_PING:
 		return c.writeOK(nil)
 	case mysql.COM_INIT_DB:
@@ -313,7 +441,13 @@ func (c *ClientConn) dispatch(data []byte) error {
 	case mysql.COM_STMT_PREPARE:
 		return c.handleStmtPrepare(hack.String(data))
 	case mysql.COM_STMT_EXECUTE:
-		return c.handleStmtExecute(data)
+		defer c.watchClient()()
+		return c.runTagged(c.stmtSql(data), func() error {
+			//a prepared statement is never replayed
+			return c.handleTxFailover("", func() error {
+				return c.handleStmtExecute(data)
+			})
+		})
 	case mysql.COM_STMT_CLOSE:
 		return c.handleStmtClose(data)
 	case mysql.COM_STMT_SEND_LONG_DATA:
@@ -325,36 +459,68 @@ func (c *ClientConn) dispatch(data []byte) error {
 	default:
 		msg := fmt.Sprintf("command %d not supported now", cmd)
 		golog.Error("ClientConn", "dispatch", msg, 0)
-		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, msg)
+		return mysql.NewError(mysql.ER_KS_CMD_UNSUPPORT, msg)
 	}
 
 	return nil
 }
 
 func (c *ClientConn) writeOK(r *mysql.Result) error {
+	return c.writeOKState(r, nil)
+}
+
+//writeOK with the session state changes, which are sent only if the
+//client negotiates CLIENT_SESSION_TRACK
+func (c *ClientConn) writeOKState(r *mysql.Result, state []byte) error {
 	if r == nil {
-		r = &mysql.Result{Status: c.status}
+		r = &mysql.Result{}
 	}
 	data := make([]byte, 4, 32)
 
 	data = append(data, mysql.OK_HEADER)
+	data = c.appendOK(data, r.AffectedRows, r.InsertId, c.resultStatus(r), r.Info, state)
+
+	return c.writePacket(data)
+}
 
-	data = append(data, mysql.PutLengthEncodedInt(r.AffectedRows)...)
-	data = append(data, mysql.PutLengthEncodedInt(r.InsertId)...)
+//the OK packet after its header, the info is a length encoded string
+//followed by the session state changes with CLIENT_SESSION_TRACK
+func (c *ClientConn) appendOK(data []byte, affectedRows uint64, insertId uint64,
+	status uint16, info string, state []byte) []byte {
+	data = append(data, mysql.PutLengthEncodedInt(affectedRows)...)
+	data = append(data, mysql.PutLengthEncodedInt(insertId)...)
 
+	track := c.capability&mysql.CLIENT_SESSION_TRACK != 0
+	if track && len(state) != 0 {
+		status |= mysql.SERVER_SESSION_STATE_CHANGED
+	}
 	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, byte(r.Status), byte(r.Status>>8))
-		data = append(data, 0, 0)
+		data = append(data, byte(status), byte(status>>8))
+		data = append(data, byte(c.warningCount()), byte(c.warningCount()>>8))
+	}
+	if !track {
+		return append(data, info...)
 	}
+	if len(info) != 0 || len(state) != 0 {
+		data = append(data, mysql.PutLengthEncodedString([]byte(info))...)
+	}
+	if len(state) != 0 {
+		data = append(data, mysql.PutLengthEncodedString(state)...)
+	}
+	return data
+}
 
-	return c.writePacket(data)
+//the session state change of the current database
+func schemaStateChange(db string) []byte {
+	change := mysql.PutLengthEncodedString([]byte(db))
+	return append([]byte{mysql.SESSION_TRACK_SCHEMA}, mysql.PutLengthEncodedString(change)...)
 }
 
 func (c *ClientConn) writeError(e error) error {
 	var m *mysql.SqlError
 	var ok bool
 	if m, ok = e.(*mysql.SqlError); !ok {
-		m = mysql.NewError(mysql.ER_UNKNOWN_ERROR, e.Error())
+		m = mysql.NewProxyError(e)
 	}
 
 	data := make([]byte, 4, 16+len(m.Message))
@@ -373,25 +539,38 @@ func (c *ClientConn) writeError(e error) error {
 }
 
 func (c *ClientConn) writeEOF(status uint16) error {
-	data := make([]byte, 4, 9)
+	return c.writePacket(c.eofPacket(status, c.deprecateEOF()))
+}
 
-	data = append(data, mysql.EOF_HEADER)
-	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, 0, 0)
-		data = append(data, byte(status), byte(status>>8))
+//writes the packet ending the rows
+func (c *ClientConn) writeEOFBatch(total []byte, status uint16, direct bool) ([]byte, error) {
+	return c.writePacketBatch(total, c.eofPacket(status, c.deprecateEOF()), direct)
+}
+
+//writes the EOF packet ending the definitions of fields or params, it is
+//omitted if the client negotiates CLIENT_DEPRECATE_EOF
+func (c *ClientConn) writeFieldsEOFBatch(total []byte, status uint16) ([]byte, error) {
+	if c.deprecateEOF() {
+		return total, nil
 	}
+	return c.writePacketBatch(total, c.eofPacket(status, false), false)
+}
 
-	return c.writePacket(data)
+func (c *ClientConn) deprecateEOF() bool {
+	return c.capability&mysql.CLIENT_DEPRECATE_EOF != 0
 }
 
-func (c *ClientConn) writeEOFBatch(total []byte, status uint16, direct bool) ([]byte, error) {
-	data := make([]byte, 4, 9)
+//the EOF packet, or the OK packet with the EOF header if asOK
+func (c *ClientConn) eofPacket(status uint16, asOK bool) []byte {
+	data := make([]byte, 4, 16)
 
 	data = append(data, mysql.EOF_HEADER)
+	if asOK {
+		return c.appendOK(data, 0, 0, status, "", nil)
+	}
 	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, 0, 0)
+		data = append(data, byte(c.warningCount()), byte(c.warningCount()>>8))
 		data = append(data, byte(status), byte(status>>8))
 	}
-
-	return c.writePacketBatch(total, data, direct)
+	return data
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/core/hack"
 	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
 	"github.com/flike/kingshard/sqlparser"
 )
 
@@ -45,17 +46,46 @@ const (
 	ADMIN_OPT_SHOW    = "show"
 	ADMIN_OPT_CHANGE  = "change"
 	ADMIN_SAVE_CONFIG = "save"
+	ADMIN_OPT_CHECK   = "check"
+	ADMIN_OPT_RETRY   = "retry"
 
 	ADMIN_PROXY         = "proxy"
 	ADMIN_NODE          = "node"
 	ADMIN_SCHEMA        = "schema"
 	ADMIN_LOG_SQL       = "log_sql"
+	ADMIN_LOG_SQL_SCRUB = "log_sql_scrub"
 	ADMIN_SLOW_LOG_TIME = "slow_log_time"
 	ADMIN_ALLOW_IP      = "allow_ip"
 	ADMIN_BLACK_SQL     = "black_sql"
+	ADMIN_SHARD_HEAT    = "shard_heat"
+	ADMIN_HOT_KEY       = "hot_key"
+	ADMIN_REWRITE_RULE  = "rewrite_rule"
+	ADMIN_MOCK_RULE     = "mock_rule"
+	ADMIN_PARSE_FAIL    = "parse_fail"
+	ADMIN_LOG           = "log"
+	ADMIN_BANNED_IP     = "banned_ip"
+	ADMIN_DDL_DRIFT     = "ddl_drift"
+	ADMIN_DEBUG         = "debug"
+	ADMIN_STAGE_TIME    = "stage_time"
+	ADMIN_FAULT         = "fault"
+	ADMIN_SHARD_SPLIT   = "shard_split"
+	ADMIN_DATE_SHARD    = "date_shard"
+	ADMIN_VERSION       = "version"
+	ADMIN_DUMP          = "dump"
+	ADMIN_REBALANCE     = "rebalance"
+	ADMIN_CDC           = "cdc"
+	ADMIN_CLUSTER       = "cluster"
+	ADMIN_LOCATE        = "locate"
+	ADMIN_STAGED_CONFIG = "staged_config"
+	ADMIN_STATS         = "stats"
+	ADMIN_EXEC_STRATEGY = "exec_strategy"
+	ADMIN_WRITE_JOURNAL = "write_journal"
+	ADMIN_HISTORY       = "history"
+	ADMIN_ALL           = "all"
 
 	ADMIN_CONFIG = "config"
 	ADMIN_STATUS = "status"
+	ADMIN_TAG    = "tag"
 )
 
 var cmdServerOrder = []string{"opt", "k", "v"}
@@ -156,6 +186,10 @@ func (c *ClientConn) handleServerCmd(rows sqlparser.InsertRows) (*mysql.Resultse
 		err = c.handleAdminDelete(k, v)
 	case ADMIN_SAVE_CONFIG:
 		err = c.handleAdminSave(k, v)
+	case ADMIN_OPT_CHECK:
+		result, err = c.handleAdminCheck(k, v)
+	case ADMIN_OPT_RETRY:
+		result, err = c.handleAdminRetry(k, v)
 	default:
 		err = errors.ErrCmdUnsupport
 		golog.Error("ClientConn", "handleNodeCmd", err.Error(),
@@ -168,6 +202,25 @@ func (c *ClientConn) handleServerCmd(rows sqlparser.InsertRows) (*mysql.Resultse
 	return result, nil
 }
 
+//the opt and v of admin dump, the quotes in v are kept for the where
+func dumpCmd(rows sqlparser.InsertRows) (string, string, bool) {
+	vals, ok := rows.(sqlparser.Values)
+	if !ok || len(vals) == 0 {
+		return "", "", false
+	}
+	tuple, ok := vals[0].(sqlparser.ValTuple)
+	if !ok || len(tuple) != len(cmdServerOrder) {
+		return "", "", false
+	}
+	opt := strings.ToLower(strings.Trim(sqlparser.String(tuple[0]), "'"))
+	k := strings.ToLower(strings.Trim(sqlparser.String(tuple[1]), "'"))
+	v, ok := tuple[2].(sqlparser.StrVal)
+	if !ok || k != ADMIN_DUMP || (opt != ADMIN_OPT_SHOW && opt != ADMIN_SAVE_CONFIG) {
+		return "", "", false
+	}
+	return opt, string(v), true
+}
+
 func (c *ClientConn) AddDatabase(nodeName string, role string, addr string) error {
 	//can not add a new master database
 	if role != Slave {
@@ -304,6 +357,10 @@ func (c *ClientConn) handleAdmin(admin *sqlparser.Admin) error {
 	case NodeRegion:
 		err = c.handleNodeCmd(admin.Rows)
 	case ServerRegion:
+		//the dump writes its response itself
+		if opt, v, ok := dumpCmd(admin.Rows); ok {
+			return c.handleAdminDump(opt, v)
+		}
 		result, err = c.handleServerCmd(admin.Rows)
 	default:
 		return fmt.Errorf("admin %s not supported now", region)
@@ -350,6 +407,98 @@ func (c *ClientConn) handleAdminShow(k, v string) (*mysql.Resultset, error) {
 		return c.handleShowBlackSqlConfig()
 	}
 
+	if k == ADMIN_HOT_KEY && v == ADMIN_CONFIG {
+		return c.handleShowHotKeyConfig()
+	}
+
+	if k == ADMIN_REWRITE_RULE && v == ADMIN_CONFIG {
+		return c.handleShowRewriteRuleConfig()
+	}
+
+	if k == ADMIN_MOCK_RULE && v == ADMIN_CONFIG {
+		return c.handleShowMockRuleConfig()
+	}
+
+	if k == ADMIN_PARSE_FAIL && v == ADMIN_CONFIG {
+		return c.handleShowParseFailConfig()
+	}
+
+	if k == ADMIN_LOG && v == ADMIN_STATUS {
+		return c.handleShowLogStatus()
+	}
+
+	if k == ADMIN_BANNED_IP && v == ADMIN_CONFIG {
+		return c.handleShowBannedIPConfig()
+	}
+
+	if k == ADMIN_DDL_DRIFT && v == ADMIN_STATUS {
+		return c.handleShowDDLDriftStatus()
+	}
+
+	if k == ADMIN_DEBUG && v == ADMIN_STATUS {
+		return c.handleShowDebugStatus()
+	}
+
+	if k == ADMIN_STAGE_TIME && v == ADMIN_STATUS {
+		return c.handleShowStageTimeStatus()
+	}
+
+	if k == ADMIN_SHARD_SPLIT && v == ADMIN_STATUS {
+		return c.handleShowShardSplitStatus()
+	}
+
+	if k == ADMIN_DATE_SHARD && v == ADMIN_STATUS {
+		return c.handleShowDateShardStatus()
+	}
+
+	if k == ADMIN_VERSION && v == ADMIN_STATUS {
+		return c.handleShowVersionStatus()
+	}
+
+	if k == ADMIN_CDC && v == ADMIN_STATUS {
+		return c.handleShowCDCStatus()
+	}
+
+	if k == ADMIN_CLUSTER && v == ADMIN_STATUS {
+		return c.handleShowClusterStatus()
+	}
+
+	if k == ADMIN_STATS && v == ADMIN_TAG {
+		return c.handleShowTagStats()
+	}
+
+	if k == ADMIN_EXEC_STRATEGY && v == ADMIN_STATUS {
+		return c.handleShowExecStrategyStatus()
+	}
+
+	if k == ADMIN_WRITE_JOURNAL && v == ADMIN_STATUS {
+		return c.handleShowWriteJournalStatus()
+	}
+
+	if k == ADMIN_LOCATE {
+		return c.handleShowLocate(v)
+	}
+
+	if k == ADMIN_STAGED_CONFIG && v == ADMIN_CONFIG {
+		return c.handleShowStagedConfig()
+	}
+
+	if k == ADMIN_FAULT && v == ADMIN_CONFIG {
+		return c.handleShowFaultConfig()
+	}
+
+	if k == ADMIN_SHARD_HEAT {
+		return c.handleShowShardHeat(v)
+	}
+
+	if k == ADMIN_REBALANCE {
+		return c.handleShowRebalance(v)
+	}
+
+	if k == ADMIN_HISTORY {
+		return c.handleShowHistory(v)
+	}
+
 	return nil, errors.ErrCmdUnsupport
 }
 
@@ -361,6 +510,10 @@ func (c *ClientConn) handleAdminChange(k, v string) error {
 		return c.handleChangeLogSql(v)
 	}
 
+	if k == ADMIN_LOG_SQL_SCRUB {
+		return c.proxy.ChangeLogSqlScrub(v)
+	}
+
 	if k == ADMIN_SLOW_LOG_TIME {
 		return c.handleChangeSlowLogTime(v)
 	}
@@ -384,6 +537,14 @@ func (c *ClientConn) handleAdminAdd(k, v string) error {
 		return c.handleAddBlackSql(v)
 	}
 
+	if k == ADMIN_FAULT {
+		return c.handleAddFault(v)
+	}
+
+	if k == ADMIN_STAGED_CONFIG {
+		return c.proxy.StageConfig(strings.TrimSpace(v))
+	}
+
 	return errors.ErrCmdUnsupport
 }
 
@@ -399,9 +560,45 @@ func (c *ClientConn) handleAdminDelete(k, v string) error {
 		return c.handleDelBlackSql(v)
 	}
 
+	if k == ADMIN_BANNED_IP {
+		return c.proxy.UnbanIP(strings.TrimSpace(v))
+	}
+
+	if k == ADMIN_FAULT {
+		return c.handleDelFault(v)
+	}
+
+	if k == ADMIN_STAGED_CONFIG {
+		c.proxy.UnstageConfig()
+		return nil
+	}
+
+	if k == ADMIN_STATS && v == ADMIN_TAG {
+		c.proxy.ResetTagStats()
+		return nil
+	}
+
+	if k == ADMIN_WRITE_JOURNAL {
+		return c.handleDelWriteJournal(v)
+	}
+
 	return errors.ErrCmdUnsupport
 }
 
+func (c *ClientConn) handleAdminCheck(k, v string) (*mysql.Resultset, error) {
+	if k == ADMIN_NODE {
+		return c.handleCheckNode(v)
+	}
+	return nil, errors.ErrCmdUnsupport
+}
+
+func (c *ClientConn) handleAdminRetry(k, v string) (*mysql.Resultset, error) {
+	if k == ADMIN_WRITE_JOURNAL {
+		return c.handleRetryWriteJournal(v)
+	}
+	return nil, errors.ErrCmdUnsupport
+}
+
 func (c *ClientConn) handleShowProxyConfig() (*mysql.Resultset, error) {
 	var names []string = []string{"Key", "Value"}
 	var rows [][]string
@@ -414,18 +611,30 @@ func (c *ClientConn) handleShowProxyConfig() (*mysql.Resultset, error) {
 		nodeNames = append(nodeNames, name)
 	}
 
-	rows = append(rows, []string{"Addr", c.proxy.cfg.Addr})
-	rows = append(rows, []string{"User", c.proxy.cfg.User})
-	rows = append(rows, []string{"LogPath", c.proxy.cfg.LogPath})
-	rows = append(rows, []string{"LogLevel", c.proxy.cfg.LogLevel})
+	rows = append(rows, []string{"Addr", c.state.cfg.Addr})
+	rows = append(rows, []string{"User", c.state.cfg.User})
+	rows = append(rows, []string{"LogPath", c.state.cfg.LogPath})
+	rows = append(rows, []string{"LogLevel", c.state.cfg.LogLevel})
 	rows = append(rows, []string{"LogSql", c.proxy.logSql[c.proxy.logSqlIndex]})
+	rows = append(rows, []string{"LogSqlScrub", strconv.FormatBool(c.proxy.LogSqlScrub())})
 	rows = append(rows, []string{"SlowLogTime", strconv.Itoa(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex])})
-	rows = append(rows, []string{"Nodes_Count", fmt.Sprintf("%d", len(c.proxy.nodes))})
+	rows = append(rows, []string{"Nodes_Count", fmt.Sprintf("%d", len(c.state.nodes))})
 	rows = append(rows, []string{"Nodes_List", strings.Join(nodeNames, ",")})
 	rows = append(rows, []string{"ClientConns", fmt.Sprintf("%d", c.proxy.counter.ClientConns)})
 	rows = append(rows, []string{"ClientQPS", fmt.Sprintf("%d", c.proxy.counter.OldClientQPS)})
+	rows = append(rows, []string{"HandshakeConns", fmt.Sprintf("%d", c.proxy.counter.HandshakeConns)})
+	rows = append(rows, []string{"HandshakeDrops", fmt.Sprintf("%d", c.proxy.counter.HandshakeDrops)})
+	rows = append(rows, []string{"MasterSwitches", fmt.Sprintf("%d", c.proxy.counter.MasterSwitches)})
 	rows = append(rows, []string{"ErrLogTotal", fmt.Sprintf("%d", c.proxy.counter.OldErrLogTotal)})
 	rows = append(rows, []string{"SlowLogTotal", fmt.Sprintf("%d", c.proxy.counter.OldSlowLogTotal)})
+	rows = append(rows, []string{"HotKeyTotal", fmt.Sprintf("%d", c.proxy.hotKey.GetHotKeyTotal())})
+	parseFailPolicy := c.state.cfg.ParseFailPolicy
+	if parseFailPolicy == "" {
+		parseFailPolicy = ParseFailReject
+	}
+	rows = append(rows, []string{"ParseFailPolicy", parseFailPolicy})
+	rows = append(rows, []string{"ParseFailTotal", fmt.Sprintf("%d", c.proxy.parseFails.GetTotal())})
+	rows = append(rows, []string{"UnsupportPolicy", formatUnsupportPolicy(c.state.cfg.UnsupportPolicy)})
 
 	var values [][]interface{} = make([][]interface{}, len(rows))
 	for i := range rows {
@@ -447,11 +656,20 @@ func (c *ClientConn) handleShowNodeConfig() (*mysql.Resultset, error) {
 		"LastPing",
 		"MaxConn",
 		"IdleConn",
+		"Health",
+		"Lag_ms",
 	}
 	var rows [][]string
 	const (
-		Column = 7
+		Column = 9
 	)
+	//the lags are measured only for the slaves, see lag_check.go
+	lagMs := func(lag time.Duration) string {
+		if lag < 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.3f", float64(lag)/float64(time.Millisecond))
+	}
 
 	//var nodeRows [][]string
 	for name, node := range c.schema.nodes {
@@ -466,6 +684,8 @@ func (c *ClientConn) handleShowNodeConfig() (*mysql.Resultset, error) {
 				fmt.Sprintf("%v", time.Unix(node.Master.GetLastPing(), 0)),
 				strconv.Itoa(node.Cfg.MaxConnNum),
 				strconv.Itoa(node.Master.IdleConnCount()),
+				fmt.Sprintf("%.2f", node.Master.Health()),
+				"",
 			})
 		//"slave"
 		for _, slave := range node.Slave {
@@ -480,6 +700,26 @@ func (c *ClientConn) handleShowNodeConfig() (*mysql.Resultset, error) {
 						fmt.Sprintf("%v", time.Unix(slave.GetLastPing(), 0)),
 						strconv.Itoa(node.Cfg.MaxConnNum),
 						strconv.Itoa(slave.IdleConnCount()),
+						fmt.Sprintf("%.2f", slave.Health()),
+						lagMs(slave.Lag()),
+					})
+			}
+		}
+		//"backup"
+		for _, backup := range node.Backup {
+			if backup != nil {
+				rows = append(
+					rows,
+					[]string{
+						name,
+						backup.Addr(),
+						"backup",
+						backup.State(),
+						fmt.Sprintf("%v", time.Unix(backup.GetLastPing(), 0)),
+						strconv.Itoa(node.Cfg.MaxConnNum),
+						strconv.Itoa(backup.IdleConnCount()),
+						fmt.Sprintf("%.2f", backup.Health()),
+						"",
 					})
 			}
 		}
@@ -524,10 +764,14 @@ func (c *ClientConn) handleShowSchemaConfig() (*mysql.Resultset, error) {
 		},
 	)
 
-	schemaConfig := c.proxy.cfg.Schema
+	schemaConfig := c.state.cfg.Schema
 	shardRule := schemaConfig.ShardRule
 
 	for _, r := range shardRule {
+		nodes := r.Nodes
+		if r.Type == router.SingleRuleType {
+			nodes = []string{r.Node}
+		}
 		rows = append(
 			rows,
 			[]string{
@@ -535,7 +779,7 @@ func (c *ClientConn) handleShowSchemaConfig() (*mysql.Resultset, error) {
 				r.Table,
 				r.Type,
 				r.Key,
-				strings.Join(r.Nodes, ", "),
+				strings.Join(nodes, ", "),
 				hack.ArrayToString(r.Locations),
 				strconv.Itoa(r.TableRowLimit),
 			},
@@ -647,6 +891,759 @@ func (c *ClientConn) handleShowBlackSqlConfig() (*mysql.Resultset, error) {
 	return c.buildResultset(nil, names, values)
 }
 
+func (c *ClientConn) handleShowBannedIPConfig() (*mysql.Resultset, error) {
+	var Column = 4
+	var rows [][]string
+	var names []string = []string{
+		"IP",
+		"Conns",
+		"BannedAt",
+		"Until",
+	}
+
+	for _, b := range c.proxy.GetBannedIPs() {
+		rows = append(rows,
+			[]string{
+				b.IP,
+				strconv.FormatInt(b.Conns, 10),
+				fmt.Sprintf("%v", time.Unix(b.BannedAt, 0)),
+				fmt.Sprintf("%v", time.Unix(b.Until, 0)),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//check the ddl drift of the sub tables now, it sends a show create table
+//to the master for each sub table
+func (c *ClientConn) handleShowDDLDriftStatus() (*mysql.Resultset, error) {
+	var Column = 4
+	var rows [][]string
+	var names []string = []string{
+		"Table",
+		"Base",
+		"Shard",
+		"Diff",
+	}
+
+	for _, drift := range c.proxy.CheckDDLDrift() {
+		rows = append(rows,
+			[]string{
+				drift.Table,
+				drift.Base,
+				drift.Shard,
+				drift.Diff,
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//a row for each sub table of range rules, Split is the new sub table
+//proposed after the last one
+func (c *ClientConn) handleShowShardSplitStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Table",
+		"SubTable",
+		"Node",
+		"Range",
+		"Rows",
+		"Split",
+	}
+
+	rows, splits := c.proxy.CheckShardSplit()
+	var values [][]interface{}
+	for i, r := range rows {
+		var split string
+		for _, p := range splits {
+			if p.DB+"."+p.Table == r.Table && (i+1 == len(rows) || rows[i+1].Table != r.Table) {
+				split = fmt.Sprintf("%s [%d,%d) on %s", p.SubTable(), p.Start, p.End, p.Node)
+			}
+		}
+		count := strconv.FormatInt(r.Rows, 10)
+		if r.Rows < 0 {
+			count = r.Err
+		}
+		values = append(values, []interface{}{
+			r.Table,
+			r.SubTable,
+			r.Node,
+			fmt.Sprintf("[%d,%d)", r.Start, r.End),
+			count,
+			split,
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//a row for each sub table of date rules to be created or dropped, the
+//tables are not changed
+func (c *ClientConn) handleShowDateShardStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Table",
+		"SubTable",
+		"Node",
+		"Action",
+		"Error",
+	}
+
+	var values [][]interface{}
+	for _, a := range c.proxy.CheckDateShards(time.Now(), false) {
+		values = append(values, []interface{}{
+			a.Table,
+			a.SubTable,
+			a.Node,
+			a.Action,
+			a.Err,
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//check the dbs of node end-to-end, a row for each db, see check_node.go
+func (c *ClientConn) handleCheckNode(node string) (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Node",
+		"Address",
+		"Type",
+		"State",
+		"Connect_ms",
+		"RoundTrip_ms",
+		"Write_ms",
+		"Replication_ms",
+		"Error",
+	}
+
+	checks, err := c.proxy.CheckNode(node)
+	if err != nil {
+		return nil, err
+	}
+	ms := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+	}
+	var values [][]interface{} = make([][]interface{}, len(checks))
+	for i, check := range checks {
+		var msg string
+		if check.Err != nil {
+			msg = check.Err.Error()
+		}
+		values[i] = []interface{}{
+			check.Node,
+			check.Addr,
+			check.Type,
+			check.State,
+			ms(check.Connect),
+			ms(check.RoundTrip),
+			ms(check.Write),
+			ms(check.Replication),
+			msg,
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//a row for each sql not acked of the pending entries of write journal
+func (c *ClientConn) handleShowWriteJournalStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Id",
+		"Time",
+		"DB",
+		"Node",
+		"Sql",
+		"Error",
+	}
+
+	if c.proxy.journal == nil {
+		return nil, fmt.Errorf("write_journal is not set")
+	}
+	var values [][]interface{}
+	for _, e := range c.proxy.journal.Pending() {
+		for _, sql := range e.Sqls {
+			if sql.Acked {
+				continue
+			}
+			values = append(values, []interface{}{
+				strconv.FormatInt(e.Id, 10),
+				e.Time.Format("2006-01-02 15:04:05"),
+				e.DB,
+				sql.Node,
+				c.proxy.logSqlText(sql.Sql),
+				sql.Err,
+			})
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//retry the entry of id, or all the pending entries for all
+func (c *ClientConn) handleRetryWriteJournal(v string) (*mysql.Resultset, error) {
+	var id int64
+	if v = strings.TrimSpace(v); v != ADMIN_ALL {
+		var err error
+		if id, err = strconv.ParseInt(v, 10, 64); err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid journal entry %s", v)
+		}
+	}
+	retried, pending, err := c.proxy.RetryJournal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{"Retried", "Pending"}
+	values := [][]interface{}{{strconv.Itoa(retried), strconv.Itoa(pending)}}
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleDelWriteJournal(v string) error {
+	if c.proxy.journal == nil {
+		return fmt.Errorf("write_journal is not set")
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid journal entry %s", v)
+	}
+	return c.proxy.journal.Discard(id)
+}
+
+//the versions of the dbs, and a warning for each table whose nodes run
+//different major versions
+func (c *ClientConn) handleShowVersionStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Node",
+		"Address",
+		"Type",
+		"Version",
+		"GTIDMode",
+		"AuthPlugin",
+		"SessionTrack",
+	}
+
+	var values [][]interface{}
+	for _, v := range c.proxy.DBVersions() {
+		if v.Unknown {
+			values = append(values, []interface{}{v.Node, v.Addr, v.Type, "", "", "", ""})
+			continue
+		}
+		values = append(values, []interface{}{
+			v.Node,
+			v.Addr,
+			v.Type,
+			v.Info.Version,
+			strconv.FormatBool(v.Info.GTIDMode),
+			v.Info.AuthPlugin,
+			strconv.FormatBool(v.Info.SupportSessionTrack()),
+		})
+	}
+	for _, w := range c.proxy.VersionMismatches() {
+		c.addWarning(mysql.ER_KS_VERSION_MISMATCH, w)
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the location of a key, v is table and key such as 'orders 12345',
+//a row for the active rules and one for the staged rules if any
+func (c *ClientConn) handleShowLocate(v string) (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Rules",
+		"DB",
+		"Table",
+		"Node",
+		"SubTable",
+		"Error",
+	}
+
+	args := strings.Fields(v)
+	if len(args) != 2 {
+		return nil, fmt.Errorf("invalid locate %s, need table and key", v)
+	}
+	var values [][]interface{}
+	for _, loc := range c.proxy.Locate(c.db, args[0], args[1]) {
+		if loc.Err != nil {
+			values = append(values, []interface{}{loc.Rules, "", "", "", "", loc.Err.Error()})
+			continue
+		}
+		values = append(values, []interface{}{
+			loc.Rules,
+			loc.DB,
+			loc.Table,
+			loc.Node,
+			loc.SubTable,
+			"",
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowStagedConfig() (*mysql.Resultset, error) {
+	var names []string = []string{"File"}
+	var values [][]interface{}
+	if file := c.proxy.GetStagedConfig(); len(file) != 0 {
+		values = append(values, []interface{}{file})
+	}
+	return c.buildResultset(nil, names, values)
+}
+
+//a row for the change stream of each node, Binlog is the position to
+//resume after an error
+func (c *ClientConn) handleShowCDCStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Node",
+		"Addr",
+		"Binlog",
+		"Events",
+		"Error",
+	}
+
+	var values [][]interface{}
+	for _, status := range c.proxy.GetCDCStatus() {
+		values = append(values, []interface{}{
+			status.Node,
+			status.Addr,
+			status.Binlog,
+			status.Events,
+			status.Error,
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowClusterStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Instance",
+		"Self",
+		"SyncTime",
+		"RuleVersion",
+		"DownSlaves",
+		"BannedIPs",
+		"Error",
+	}
+
+	var values [][]interface{}
+	instances, lastErr := c.proxy.GetClusterStatus()
+	for _, state := range instances {
+		self, errMsg := "no", ""
+		if state.Self {
+			self, errMsg = "yes", lastErr
+		}
+		var banned []string
+		for _, b := range state.BannedIPs {
+			banned = append(banned, b.IP)
+		}
+		values = append(values, []interface{}{
+			state.Name,
+			self,
+			time.Unix(state.Time, 0).Format("2006-01-02 15:04:05"),
+			state.RuleVersion,
+			strings.Join(state.DownSlaves, ","),
+			strings.Join(banned, ","),
+			errMsg,
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowDebugStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Name",
+		"Value",
+	}
+
+	stats := c.proxy.DebugStats()
+	var values [][]interface{} = make([][]interface{}, len(stats))
+	for i, stat := range stats {
+		values[i] = []interface{}{stat.Name, stat.Value}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//a row for each stage, with the count of times in each bucket
+func (c *ClientConn) handleShowStageTimeStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Stage",
+		"Count",
+		"Avg_ms",
+	}
+	names = append(names, StageBucketNames()...)
+
+	stats := c.proxy.GetStageTimes()
+	var values [][]interface{} = make([][]interface{}, len(stats))
+	for i, stat := range stats {
+		values[i] = make([]interface{}, 0, len(names))
+		values[i] = append(values[i], stat.Stage, stat.Count, fmt.Sprintf("%.3f", stat.AvgMs))
+		for _, count := range stat.Buckets {
+			values[i] = append(values[i], count)
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the load of the queries by their tags, see query_tag.go
+func (c *ClientConn) handleShowTagStats() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Tag",
+		"Queries",
+		"Errors",
+		"SlowQueries",
+		"Total_ms",
+		"Avg_ms",
+		"Max_ms",
+	}
+
+	stats := c.proxy.GetTagStats()
+	var values [][]interface{} = make([][]interface{}, len(stats))
+	for i, stat := range stats {
+		values[i] = []interface{}{
+			stat.Tag,
+			stat.Queries,
+			stat.Errors,
+			stat.SlowQueries,
+			fmt.Sprintf("%.3f", stat.TotalTime),
+			fmt.Sprintf("%.3f", stat.TotalTime/float64(stat.Queries)),
+			fmt.Sprintf("%.3f", stat.MaxTime),
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the stats of the select fingerprints and their last strategies,
+//see exec_strategy.go
+func (c *ClientConn) handleShowExecStrategyStatus() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Fingerprint",
+		"Count",
+		"AvgRows",
+		"AvgSqlTime_ms",
+		"Strategy",
+	}
+
+	stats := c.proxy.GetExecStats()
+	var values [][]interface{} = make([][]interface{}, len(stats))
+	for i, stat := range stats {
+		values[i] = []interface{}{
+			stat.Fingerprint,
+			stat.Count,
+			fmt.Sprintf("%.1f", stat.AvgRows),
+			fmt.Sprintf("%.3f", stat.AvgSqlTime),
+			stat.Strategy,
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowHotKeyConfig() (*mysql.Resultset, error) {
+	var Column = 5
+	var rows [][]string
+	var names []string = []string{
+		"Table",
+		"Key",
+		"Count",
+		"Throttled",
+		"LastHot",
+	}
+
+	for _, hk := range c.proxy.GetHotKeys() {
+		rows = append(rows,
+			[]string{
+				hk.Table,
+				hk.Key,
+				strconv.FormatInt(hk.Count, 10),
+				strconv.FormatInt(hk.Throttled, 10),
+				fmt.Sprintf("%v", time.Unix(hk.LastHot, 0)),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowRewriteRuleConfig() (*mysql.Resultset, error) {
+	var Column = 5
+	var rows [][]string
+	var names []string = []string{
+		"Name",
+		"Fingerprint",
+		"Pattern",
+		"Rewrite",
+		"Hits",
+	}
+
+	for _, rule := range c.proxy.GetRewriteRules() {
+		rows = append(rows,
+			[]string{
+				rule.Name,
+				rule.Fingerprint,
+				rule.Pattern.String(),
+				rule.Rewrite,
+				strconv.FormatInt(rule.Hits, 10),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowMockRuleConfig() (*mysql.Resultset, error) {
+	var Column = 6
+	var rows [][]string
+	var names []string = []string{
+		"Name",
+		"Fingerprint",
+		"Pattern",
+		"Columns",
+		"Rows",
+		"Hits",
+	}
+
+	for _, rule := range c.proxy.GetMockRules() {
+		var pattern string
+		if rule.Pattern != nil {
+			pattern = rule.Pattern.String()
+		}
+		rows = append(rows,
+			[]string{
+				rule.Name,
+				rule.Fingerprint,
+				pattern,
+				strings.Join(rule.Columns, ","),
+				strconv.Itoa(len(rule.Rows)),
+				strconv.FormatInt(rule.Hits, 10),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowFaultConfig() (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Type",
+		"Value",
+		"Target",
+		"Hits",
+	}
+	var values [][]interface{}
+	for _, f := range c.proxy.GetFaults() {
+		values = append(values, []interface{}{
+			f.Type,
+			strconv.Itoa(f.Value),
+			f.Target,
+			strconv.FormatInt(f.Hits, 10),
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+func (c *ClientConn) handleShowParseFailConfig() (*mysql.Resultset, error) {
+	var Column = 4
+	var rows [][]string
+	var names []string = []string{
+		"Fingerprint",
+		"Count",
+		"LastError",
+		"LastTime",
+	}
+
+	for _, f := range c.proxy.GetParseFails() {
+		rows = append(rows,
+			[]string{
+				f.Fingerprint,
+				strconv.FormatInt(f.Count, 10),
+				f.LastError,
+				fmt.Sprintf("%v", time.Unix(f.LastTime, 0)),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the written and dropped log lines of the sys and sql logger
+func (c *ClientConn) handleShowLogStatus() (*mysql.Resultset, error) {
+	var Column = 4
+	var rows [][]string
+	var names []string = []string{
+		"Logger",
+		"Written",
+		"Dropped",
+		"Buffered",
+	}
+
+	loggers := []struct {
+		name   string
+		logger *golog.Logger
+	}{
+		{"sys", golog.GlobalSysLogger},
+		{"sql", golog.GlobalSqlLogger},
+	}
+	for _, l := range loggers {
+		rows = append(rows,
+			[]string{
+				l.name,
+				strconv.FormatInt(l.logger.Written(), 10),
+				strconv.FormatInt(l.logger.Dropped(), 10),
+				strconv.Itoa(l.logger.Buffered()),
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the heat of every sub table, table is tbl_name or db_name.tbl_name
+func (c *ClientConn) handleShowShardHeat(table string) (*mysql.Resultset, error) {
+	var Column = 8
+	var rows [][]string
+	var names []string = []string{
+		"Table",
+		"Node",
+		"Queries",
+		"QPS",
+		"Rows",
+		"Query_Ratio",
+		"Row_Ratio",
+		"Hot",
+	}
+
+	rule := c.schema.rule.GetRule(c.db, table)
+	if rule.Type == router.DefaultRuleType {
+		return nil, fmt.Errorf("table %s is not a sharding table", table)
+	}
+
+	heat := c.proxy.shardHeat.GetTableHeat(rule)
+	var totalQueries, totalRows int64
+	for _, item := range heat {
+		totalQueries += item.Queries
+		totalRows += item.Rows
+	}
+
+	for _, item := range heat {
+		queryRatio := heatRatio(item.Queries, totalQueries, len(heat))
+		rowRatio := heatRatio(item.Rows, totalRows, len(heat))
+		hot := "no"
+		if HotShardRatio <= queryRatio || HotShardRatio <= rowRatio {
+			hot = "yes"
+		}
+		rows = append(rows,
+			[]string{
+				fmt.Sprintf("%s_%04d", rule.Table, item.TableIndex),
+				item.Node,
+				strconv.FormatInt(item.Queries, 10),
+				strconv.FormatInt(item.OldQPS, 10),
+				strconv.FormatInt(item.Rows, 10),
+				fmt.Sprintf("%.2f", queryRatio),
+				fmt.Sprintf("%.2f", rowRatio),
+				hot,
+			})
+	}
+
+	var values [][]interface{} = make([][]interface{}, len(rows))
+	for i := range rows {
+		values[i] = make([]interface{}, Column)
+		for j := range rows[i] {
+			values[i][j] = rows[i][j]
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
+//show the rebalance plan of table, table is tbl_name or db_name.tbl_name
+func (c *ClientConn) handleShowRebalance(table string) (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Step",
+		"Action",
+		"SubTable",
+		"From",
+		"To",
+		"Detail",
+	}
+
+	plan, err := c.proxy.RebalancePlan(c.db, table)
+	if err != nil {
+		return nil, err
+	}
+	var values [][]interface{}
+	for _, step := range plan.Steps {
+		values = append(values, []interface{}{
+			step.Step,
+			step.Action,
+			step.SubTable,
+			step.From,
+			step.To,
+			step.Detail,
+		})
+	}
+
+	return c.buildResultset(nil, names, values)
+}
+
 func (c *ClientConn) handleChangeProxy(v string) error {
 	return c.proxy.ChangeProxy(v)
 }
@@ -693,3 +1690,38 @@ func (c *ClientConn) handleAdminSave(k string, v string) error {
 
 	return errors.ErrCmdUnsupport
 }
+
+//show the per-minute stats of the nodes, sharding tables or fingerprints,
+//see stats_history.go
+func (c *ClientConn) handleShowHistory(v string) (*mysql.Resultset, error) {
+	var names []string = []string{
+		"Time",
+		"Kind",
+		"Name",
+		"Queries",
+		"QPS",
+		"Errors",
+		"Avg_ms",
+		"Max_ms",
+	}
+
+	stats, err := c.proxy.GetStatsHistory(v)
+	if err != nil {
+		return nil, err
+	}
+	var values [][]interface{} = make([][]interface{}, len(stats))
+	for i, stat := range stats {
+		values[i] = []interface{}{
+			time.Unix(stat.Time, 0).Format("2006-01-02 15:04"),
+			stat.Kind,
+			stat.Name,
+			stat.Queries,
+			fmt.Sprintf("%.2f", float64(stat.Queries)/60),
+			stat.Errors,
+			fmt.Sprintf("%.3f", stat.TotalTime/float64(stat.Queries)),
+			fmt.Sprintf("%.3f", stat.MaxTime),
+		}
+	}
+
+	return c.buildResultset(nil, names, values)
+}
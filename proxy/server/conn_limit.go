@@ -0,0 +1,211 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+)
+
+type BannedIP struct {
+	IP       string
+	Conns    int64 //the conns in the window when it is banned
+	BannedAt int64
+	Until    int64
+}
+
+//the new conns of an ip in the window starting at start
+type ipConnRate struct {
+	start int64
+	conns int64
+}
+
+//ConnLimiter limits the rate of new conns of each client ip in a fixed
+//window, and bans the ip exceeding the limit for a while, so a client
+//reconnecting in a loop can not consume all the conns of kingshard.
+type ConnLimiter struct {
+	sync.Mutex
+
+	window   int64
+	maxConns int64
+	banTime  int64
+
+	rates     map[string]*ipConnRate
+	banned    map[string]*BannedIP
+	lastPrune int64
+
+	//the new conns and bans of ips in the other instances of the cluster,
+	//see SetRemote
+	remoteConns  map[string]int64
+	remoteBanned map[string]BannedIP
+}
+
+func NewConnLimiter(cfg config.ConnLimitConfig) *ConnLimiter {
+	l := new(ConnLimiter)
+	l.window = int64(cfg.Window)
+	l.maxConns = int64(cfg.MaxConns)
+	l.banTime = int64(cfg.BanTime)
+	l.rates = make(map[string]*ipConnRate)
+	l.banned = make(map[string]*BannedIP)
+	return l
+}
+
+func (l *ConnLimiter) Enabled() bool {
+	return 0 < l.window && 0 < l.maxConns
+}
+
+//Allow records a new conn of ip, and returns ErrIPBanned if the ip is
+//banned or its conns exceed the limit in the window.
+func (l *ConnLimiter) Allow(ip string) error {
+	if !l.Enabled() {
+		return nil
+	}
+	now := time.Now().Unix()
+
+	l.Lock()
+	defer l.Unlock()
+	l.prune(now)
+
+	if b, ok := l.banned[ip]; ok {
+		if now < b.Until {
+			return errors.ErrIPBanned
+		}
+		delete(l.banned, ip)
+	}
+	if b, ok := l.remoteBanned[ip]; ok && now < b.Until {
+		return errors.ErrIPBanned
+	}
+
+	r, ok := l.rates[ip]
+	if !ok || l.window <= now-r.start {
+		r = &ipConnRate{start: now}
+		l.rates[ip] = r
+	}
+	r.conns++
+	conns := r.conns + l.remoteConns[ip]
+	if conns <= l.maxConns {
+		return nil
+	}
+
+	if 0 < l.banTime {
+		l.banned[ip] = &BannedIP{
+			IP:       ip,
+			Conns:    conns,
+			BannedAt: now,
+			Until:    now + l.banTime,
+		}
+		delete(l.rates, ip)
+		golog.Warn("ConnLimiter", "Allow", "ip is banned", 0,
+			"ip", ip,
+			"conns", conns,
+			"window", l.window,
+			"ban_time", l.banTime)
+	}
+	return errors.ErrIPBanned
+}
+
+//drop the expired rates and bans once a window, so the maps do not grow
+//without limit
+func (l *ConnLimiter) prune(now int64) {
+	if now-l.lastPrune < l.window {
+		return
+	}
+	l.lastPrune = now
+	for ip, r := range l.rates {
+		if l.window <= now-r.start {
+			delete(l.rates, ip)
+		}
+	}
+	for ip, b := range l.banned {
+		if b.Until <= now {
+			delete(l.banned, ip)
+		}
+	}
+}
+
+//return the ips which are banned now
+func (l *ConnLimiter) GetBannedIPs() []BannedIP {
+	now := time.Now().Unix()
+	l.Lock()
+	defer l.Unlock()
+
+	ips := make([]BannedIP, 0, len(l.banned))
+	for _, b := range l.banned {
+		if now < b.Until {
+			ips = append(ips, *b)
+		}
+	}
+	return ips
+}
+
+//the new conns of each ip in its window here, shared with the cluster
+func (l *ConnLimiter) Rates() map[string]int64 {
+	now := time.Now().Unix()
+	l.Lock()
+	defer l.Unlock()
+
+	rates := make(map[string]int64, len(l.rates))
+	for ip, r := range l.rates {
+		if now-r.start < l.window {
+			rates[ip] = r.conns
+		}
+	}
+	return rates
+}
+
+//SetRemote sets the new conns and bans of ips in the other instances of
+//the cluster, an ip is limited by its conns in all the instances, and
+//banned if it is banned by any of them
+func (l *ConnLimiter) SetRemote(conns map[string]int64, banned []BannedIP) {
+	remoteBanned := make(map[string]BannedIP, len(banned))
+	for _, b := range banned {
+		if old, ok := remoteBanned[b.IP]; !ok || old.Until < b.Until {
+			remoteBanned[b.IP] = b
+		}
+	}
+	l.Lock()
+	l.remoteConns = conns
+	l.remoteBanned = remoteBanned
+	l.Unlock()
+}
+
+//Unban lifts the ban of ip, and resets its conns in the window
+func (l *ConnLimiter) Unban(ip string) error {
+	now := time.Now().Unix()
+	l.Lock()
+	defer l.Unlock()
+
+	b, ok := l.banned[ip]
+	if !ok || b.Until <= now {
+		return errors.ErrIPNotBanned
+	}
+	delete(l.banned, ip)
+	delete(l.rates, ip)
+	return nil
+}
+
+//the ip of client without port
+func (c *ClientConn) remoteIP() string {
+	addr := c.c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := NewConnLimiter(config.ConnLimitConfig{})
+	for i := 0; i < 100; i++ {
+		if err := l.Allow("10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l = NewConnLimiter(config.ConnLimitConfig{Window: 60, MaxConns: 3, BanTime: 60})
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Allow("10.0.0.1"); err != errors.ErrIPBanned {
+		t.Fatal(err)
+	}
+	//the other ips are not limited
+	if err := l.Allow("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ips := l.GetBannedIPs()
+	if len(ips) != 1 || ips[0].IP != "10.0.0.1" || ips[0].Conns != 4 || ips[0].Until-ips[0].BannedAt != 60 {
+		t.Fatal(ips)
+	}
+	//the banned ip is rejected until unbanned
+	if err := l.Allow("10.0.0.1"); err != errors.ErrIPBanned {
+		t.Fatal(err)
+	}
+	if err := l.Unban("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unban("10.0.0.1"); err != errors.ErrIPNotBanned {
+		t.Fatal(err)
+	}
+	if err := l.Allow("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//without ban time only the conns beyond the limit are rejected
+	l = NewConnLimiter(config.ConnLimitConfig{Window: 60, MaxConns: 1})
+	if err := l.Allow("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("10.0.0.1"); err != errors.ErrIPBanned {
+		t.Fatal(err)
+	}
+	if ips := l.GetBannedIPs(); len(ips) != 0 {
+		t.Fatal(ips)
+	}
+}
@@ -31,6 +31,9 @@ type ExecuteDB struct {
 	ExecNode *backend.Node
 	IsSlave  bool
 	sql      string
+	//the sql is sent to a sub table, and the sub tables in the result are
+	//replaced by their logical tables
+	stripSubTables bool
 }
 
 func (c *ClientConn) isBlacklistSql(sql string) bool {
@@ -44,7 +47,6 @@ func (c *ClientConn) isBlacklistSql(sql string) bool {
 
 //preprocessing sql before parse sql
 func (c *ClientConn) preHandleShard(sql string) (bool, error) {
-	var rs []*mysql.Result
 	var err error
 	var executeDB *ExecuteDB
 
@@ -57,9 +59,9 @@ func (c *ClientConn) preHandleShard(sql string) (bool, error) {
 			golog.OutputSql("Forbidden", "%s->%s:%s",
 				c.c.RemoteAddr(),
 				c.proxy.addr,
-				sql,
+				appendLogTag(c.proxy.logSqlText(sql), c.tag),
 			)
-			err := mysql.NewError(mysql.ER_UNKNOWN_ERROR, "sql in blacklist.")
+			err := mysql.NewError(mysql.ER_KS_BLACKLIST_SQL, "sql in blacklist.")
 			return false, err
 		}
 	}
@@ -70,16 +72,27 @@ func (c *ClientConn) preHandleShard(sql string) (bool, error) {
 		return false, errors.ErrCmdUnsupport
 	}
 
-	if c.isInTransaction() {
-		executeDB, err = c.GetTransExecDB(tokens, sql)
-	} else {
-		executeDB, err = c.GetExecDB(tokens, sql)
+	executeDB, err = c.getPinnedExecDB(sql, tokens, len(tokens))
+	if err == nil && executeDB == nil {
+		executeDB, err = c.getAttributeExecDB(sql, tokens)
+	}
+	if err == nil && executeDB == nil {
+		executeDB, err = c.getRawExecDB(sql, tokens, len(tokens))
+	}
+	if err == nil && executeDB == nil {
+		if c.isInTransaction() {
+			executeDB, err = c.GetTransExecDB(tokens, sql)
+		} else {
+			executeDB, err = c.GetExecDB(tokens, sql)
+		}
 	}
 
 	if err != nil {
 		//this SQL doesn't need execute in the backend.
 		if err == errors.ErrIgnoreSQL {
-			err = c.writeOK(nil)
+			err = c.handleUnsupport(UnsupportSet, sql, UnsupportIgnore, err, func() error {
+				return c.executeInDefaultNode(sql, false)
+			})
 			if err != nil {
 				return false, err
 			}
@@ -89,40 +102,147 @@ func (c *ClientConn) preHandleShard(sql string) (bool, error) {
 	}
 	//need shard sql
 	if executeDB == nil {
+		if isExplainStatement(tokens) {
+			return true, c.handleExplain(sql)
+		}
 		return false, nil
 	}
+	if err = c.checkRowFilterRaw(sql); err != nil {
+		return false, err
+	}
+	if c.dryRun {
+		return true, c.writeDryRunExecuteDB(executeDB)
+	}
+	if err = c.handleExecuteDB(executeDB); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//execute the sql of executeDB in its node, and write the result to client
+func (c *ClientConn) handleExecuteDB(executeDB *ExecuteDB) error {
 	//get connection in DB
 	conn, err := c.getBackendConn(executeDB.ExecNode, executeDB.IsSlave)
 	defer c.closeConn(conn, false)
 	if err != nil {
-		return false, err
+		return err
 	}
+	c.setHistoryNode(executeDB.ExecNode.Cfg.Name)
 	//execute.sql may be rewritten in getShowExecDB
-	rs, err = c.executeInNode(conn, executeDB.sql, nil)
+	rs, err := c.executeInNode(conn, executeDB.sql, nil)
+	if err != nil && !executeDB.IsSlave && c.canRetryReadOnly(err) {
+		conn, err = c.getRetryMasterConn(executeDB.ExecNode, conn, c.proxy.readOnlyRetryWait)
+		if err != nil {
+			return err
+		}
+		defer c.closeConn(conn, false)
+		rs, err = c.executeInNode(conn, executeDB.sql, nil)
+	}
 	if err != nil {
-		return false, err
+		if executeDB.stripSubTables {
+			return c.stripSubTablesError(err)
+		}
+		return err
 	}
 
 	if len(rs) == 0 {
 		msg := fmt.Sprintf("result is empty")
-		golog.Error("ClientConn", "handleUnsupport", msg, 0, "sql", sql)
-		return false, mysql.NewError(mysql.ER_UNKNOWN_ERROR, msg)
+		golog.Error("ClientConn", "handleUnsupport", msg, 0, "sql", c.proxy.logSqlText(executeDB.sql))
+		return mysql.NewError(mysql.ER_KS_RESULT_NIL, msg)
 	}
 
-	c.lastInsertId = int64(rs[0].InsertId)
-	c.affectedRows = int64(rs[0].AffectedRows)
+	c.recordExecResult(rs[0])
+	if !executeDB.IsSlave && rs[0].Resultset == nil {
+		c.markWrite()
+	}
 
 	if rs[0].Resultset != nil {
-		err = c.writeResultset(c.status, rs[0].Resultset)
+		r := rs[0].Resultset
+		if executeDB.stripSubTables {
+			if r, err = c.stripSubTablesResultset(r); err != nil {
+				return err
+			}
+		}
+		if err = c.maskRawResult(r, executeDB.sql); err != nil {
+			return err
+		}
+		err = c.writeResultset(c.status, r)
 	} else {
 		err = c.writeOK(rs[0])
 	}
 
-	if err != nil {
-		return false, err
+	return err
+}
+
+//get the execute database for raw sql, which is sent to the backend
+//unmodified without parse, return nil if the sql is not raw.
+//a sql is raw if it starts with /*raw*/, or uses a no_rewrite table:
+//  /*raw*/ select ...: send to the default node
+//  /*raw*/ /*node2*/ select ...: send to node2
+func (c *ClientConn) getRawExecDB(sql string, tokens []string, tokensLen int) (*ExecuteDB, error) {
+	if strings.ToLower(tokens[0]) != mysql.TK_STR_RAW_HINT && !c.hasNoRewriteTable(tokens, tokensLen) {
+		return nil, nil
 	}
 
-	return true, nil
+	executeDB := new(ExecuteDB)
+	executeDB.sql = sql
+
+	//skip the hints before the statement
+	var i int
+	var toMaster bool
+	for ; i < tokensLen && tokens[i][0] == mysql.COMMENT_PREFIX; i++ {
+		if strings.ToLower(tokens[i]) == mysql.TK_STR_MASTER_HINT {
+			toMaster = true
+			continue
+		}
+		nodeName := strings.Trim(tokens[i], mysql.COMMENT_STRING)
+		if c.schema.nodes[nodeName] != nil {
+			executeDB.ExecNode = c.schema.nodes[nodeName]
+		}
+	}
+	if i < tokensLen && strings.ToLower(tokens[i]) == mysql.TK_STR_SELECT {
+		executeDB.IsSlave = !toMaster
+	}
+
+	if executeDB.ExecNode == nil {
+		defaultRule := c.schema.rule.DefaultRule
+		if len(defaultRule.Nodes) == 0 {
+			return nil, errors.ErrNoDefaultNode
+		}
+		executeDB.ExecNode = c.getNode(defaultRule.Nodes[0])
+	}
+
+	if c.isInTransaction() {
+		executeDB.IsSlave = false
+		if err := c.checkTxNodes(executeDB.ExecNode); err != nil {
+			return nil, err
+		}
+	}
+	return executeDB, nil
+}
+
+//return true if a table after from, join, into, update or table is no_rewrite
+func (c *ClientConn) hasNoRewriteTable(tokens []string, tokensLen int) bool {
+	router := c.schema.rule
+	if !router.HasNoRewrite {
+		return false
+	}
+
+	for i := 0; i+1 < tokensLen; i++ {
+		switch strings.ToLower(tokens[i]) {
+		case mysql.TK_STR_FROM, mysql.TK_STR_JOIN, mysql.TK_STR_INTO,
+			mysql.TK_STR_UPDATE, mysql.TK_STR_TABLE:
+			DBName, tableName := sqlparser.GetInsertDBTable(tokens[i+1])
+			if DBName == "" {
+				DBName = c.db
+			}
+			if router.IsNoRewriteTable(DBName, tableName) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (c *ClientConn) GetTransExecDB(tokens []string, sql string) (*ExecuteDB, error) {
@@ -153,8 +273,8 @@ func (c *ClientConn) GetTransExecDB(tokens []string, sql string) (*ExecuteDB, er
 		}
 		return executeDB, nil
 	}
-	if len(c.txConns) == 1 && c.txConns[executeDB.ExecNode] == nil {
-		return nil, errors.ErrTransInMulti
+	if err := c.checkTxNodes(executeDB.ExecNode); err != nil {
+		return nil, err
 	}
 	return executeDB, nil
 }
@@ -180,6 +300,8 @@ func (c *ClientConn) GetExecDB(tokens []string, sql string) (*ExecuteDB, error)
 				return c.getShowExecDB(sql, tokens, tokensLen)
 			case mysql.TK_ID_TRUNCATE:
 				return c.getTruncateExecDB(sql, tokens, tokensLen)
+			case mysql.TK_ID_EXPLAIN:
+				return c.getExplainExecDB(sql, tokens, tokensLen)
 			default:
 				return nil, nil
 			}
@@ -209,17 +331,44 @@ func (c *ClientConn) setExecuteNode(tokens []string, tokensLen int, executeDB *E
 		}
 	}
 
+	//the statements of a single table, such as alter table, go to its node
+	if executeDB.ExecNode == nil {
+		if nodeName := c.singleTableNode(tokens, tokensLen); len(nodeName) != 0 {
+			executeDB.ExecNode = c.schema.nodes[nodeName]
+		}
+	}
+
 	if executeDB.ExecNode == nil {
 		defaultRule := c.schema.rule.DefaultRule
 		if len(defaultRule.Nodes) == 0 {
 			return errors.ErrNoDefaultNode
 		}
-		executeDB.ExecNode = c.proxy.GetNode(defaultRule.Nodes[0])
+		executeDB.ExecNode = c.getNode(defaultRule.Nodes[0])
 	}
 
 	return nil
 }
 
+//return the node of the first single table after from, join, into, update,
+//table, truncate, explain, desc or describe, "" if none
+func (c *ClientConn) singleTableNode(tokens []string, tokensLen int) string {
+	for i := 0; i+1 < tokensLen; i++ {
+		switch strings.ToLower(tokens[i]) {
+		case mysql.TK_STR_FROM, mysql.TK_STR_JOIN, mysql.TK_STR_INTO,
+			mysql.TK_STR_UPDATE, mysql.TK_STR_TABLE, mysql.TK_STR_TRUNCATE,
+			mysql.TK_STR_EXPLAIN, mysql.TK_STR_DESC, mysql.TK_STR_DESCRIBE:
+			DBName, tableName := sqlparser.GetInsertDBTable(tokens[i+1])
+			if DBName == "" {
+				DBName = c.db
+			}
+			if nodeName := c.schema.rule.SingleTableNode(DBName, tableName); len(nodeName) != 0 {
+				return nodeName
+			}
+		}
+	}
+	return ""
+}
+
 //get the execute database for select sql
 func (c *ClientConn) getSelectExecDB(sql string, tokens []string, tokensLen int) (*ExecuteDB, error) {
 	var ruleDB string
@@ -227,7 +376,7 @@ func (c *ClientConn) getSelectExecDB(sql string, tokens []string, tokensLen int)
 	executeDB.sql = sql
 	executeDB.IsSlave = true
 
-	schema := c.proxy.schema
+	schema := c.state.schema
 	router := schema.rule
 	rules := router.Rules
 
@@ -242,11 +391,12 @@ func (c *ClientConn) getSelectExecDB(sql string, tokens []string, tokensLen int)
 					} else {
 						ruleDB = c.db
 					}
-					if router.GetRule(ruleDB, tableName) != router.DefaultRule {
+					if !router.IsUnsharded(ruleDB, tableName) {
 						return nil, nil
 					} else {
 						//if the table is not shard table,send the sql
 						//to default db
+						c.applyTableConsistency(router.GetRule(ruleDB, tableName))
 						break
 					}
 				}
@@ -276,7 +426,7 @@ func (c *ClientConn) getDeleteExecDB(sql string, tokens []string, tokensLen int)
 	var ruleDB string
 	executeDB := new(ExecuteDB)
 	executeDB.sql = sql
-	schema := c.proxy.schema
+	schema := c.state.schema
 	router := schema.rule
 	rules := router.Rules
 
@@ -291,7 +441,7 @@ func (c *ClientConn) getDeleteExecDB(sql string, tokens []string, tokensLen int)
 					} else {
 						ruleDB = c.db
 					}
-					if router.GetRule(ruleDB, tableName) != router.DefaultRule {
+					if !router.IsUnsharded(ruleDB, tableName) {
 						return nil, nil
 					} else {
 						break
@@ -314,7 +464,7 @@ func (c *ClientConn) getInsertOrReplaceExecDB(sql string, tokens []string, token
 	var ruleDB string
 	executeDB := new(ExecuteDB)
 	executeDB.sql = sql
-	schema := c.proxy.schema
+	schema := c.state.schema
 	router := schema.rule
 	rules := router.Rules
 
@@ -329,7 +479,7 @@ func (c *ClientConn) getInsertOrReplaceExecDB(sql string, tokens []string, token
 					} else {
 						ruleDB = c.db
 					}
-					if router.GetRule(ruleDB, tableName) != router.DefaultRule {
+					if !router.IsUnsharded(ruleDB, tableName) {
 						return nil, nil
 					} else {
 						break
@@ -352,7 +502,7 @@ func (c *ClientConn) getUpdateExecDB(sql string, tokens []string, tokensLen int)
 	var ruleDB string
 	executeDB := new(ExecuteDB)
 	executeDB.sql = sql
-	schema := c.proxy.schema
+	schema := c.state.schema
 	router := schema.rule
 	rules := router.Rules
 
@@ -366,7 +516,7 @@ func (c *ClientConn) getUpdateExecDB(sql string, tokens []string, tokensLen int)
 				} else {
 					ruleDB = c.db
 				}
-				if router.GetRule(ruleDB, tableName) != router.DefaultRule {
+				if !router.IsUnsharded(ruleDB, tableName) {
 					return nil, nil
 				} else {
 					break
@@ -411,6 +561,21 @@ func (c *ClientConn) getSetExecDB(sql string, tokens []string, tokensLen int) (*
 		}
 	}
 
+	//SET GLOBAL x = 1 or SET @@global.x = 1 changes the backend for all
+	//the clients
+	for i, token := range tokens {
+		token = strings.ToLower(token)
+		if (i == 1 && token == "global") || strings.HasPrefix(token, "@@global.") {
+			return nil, mysql.NewError(mysql.ER_KS_SET_DENIED, "set global variables is not allowed")
+		}
+	}
+
+	//the set statements parsed are handled by handleSet, the others are
+	//sent as before
+	if _, err := sqlparser.Parse(normalizeSet(sql)); err == nil {
+		return nil, nil
+	}
+
 	err := c.setExecuteNode(tokens, tokensLen, executeDB)
 	if err != nil {
 		return nil, err
@@ -427,6 +592,12 @@ func (c *ClientConn) getShowExecDB(sql string, tokens []string, tokensLen int) (
 	executeDB.IsSlave = true
 	executeDB.sql = sql
 
+	//handle show create table
+	if 3 < tokensLen && strings.ToLower(tokens[1]) == mysql.TK_STR_CREATE &&
+		strings.ToLower(tokens[2]) == mysql.TK_STR_TABLE {
+		c.routeToFirstSubTables(executeDB)
+	}
+
 	//handle show columns/fields
 	err := c.handleShowColumns(sql, tokens, tokensLen, executeDB)
 	if err != nil {
@@ -465,7 +636,7 @@ func (c *ClientConn) handleShowColumns(sql string, tokens []string,
 				//this SHOW is sharding SQL
 				if showRule.Type != router.DefaultRuleType {
 					if 0 < len(showRule.SubTableIndexs) {
-						tableIndex := showRule.SubTableIndexs[0]
+						tableIndex := showRule.FirstSubTable()
 						nodeIndex := showRule.TableToNode[tableIndex]
 						nodeName := showRule.Nodes[nodeIndex]
 						tokens[i+2] = fmt.Sprintf("%s_%04d", tableName, tableIndex)
@@ -480,13 +651,32 @@ func (c *ClientConn) handleShowColumns(sql string, tokens []string,
 	return nil
 }
 
+//get the execute database for explain, desc or describe of a table, the
+//sharding tables are described by their first sub tables. The explain of
+//a statement is routed by handleExplain, nil is returned for it.
+func (c *ClientConn) getExplainExecDB(sql string, tokens []string, tokensLen int) (*ExecuteDB, error) {
+	if isExplainStatement(tokens) {
+		return nil, nil
+	}
+	executeDB := new(ExecuteDB)
+	executeDB.sql = sql
+	c.routeToFirstSubTables(executeDB)
+
+	err := c.setExecuteNode(tokens, tokensLen, executeDB)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeDB, nil
+}
+
 //get the execute database for truncate sql
 //sql: TRUNCATE [TABLE] tbl_name
 func (c *ClientConn) getTruncateExecDB(sql string, tokens []string, tokensLen int) (*ExecuteDB, error) {
 	var ruleDB string
 	executeDB := new(ExecuteDB)
 	executeDB.sql = sql
-	schema := c.proxy.schema
+	schema := c.state.schema
 	router := schema.rule
 	rules := router.Rules
 	if len(rules) != 0 && tokensLen >= 2 {
@@ -497,7 +687,7 @@ func (c *ClientConn) getTruncateExecDB(sql string, tokens []string, tokensLen in
 		} else {
 			ruleDB = c.db
 		}
-		if router.GetRule(ruleDB, tableName) != router.DefaultRule {
+		if !router.IsUnsharded(ruleDB, tableName) {
 			return nil, nil
 		}
 
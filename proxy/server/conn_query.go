@@ -34,7 +34,7 @@ import (
 func (c *ClientConn) handleQuery(sql string) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			golog.OutputSql("Error", "err:%v,sql:%s", e, sql)
+			golog.OutputSqlSampled("Error", mysql.GetFingerprint(sql), "err:%v,sql:%s", e, c.proxy.logSqlText(sql))
 
 			if err, ok := e.(error); ok {
 				const size = 4096
@@ -43,17 +43,49 @@ func (c *ClientConn) handleQuery(sql string) (err error) {
 
 				golog.Error("ClientConn", "handleQuery",
 					err.Error(), 0,
-					"stack", string(buf), "sql", sql)
+					"stack", string(buf), "sql", c.proxy.logSqlText(sql))
 			}
 			return
 		}
 	}()
 
 	sql = strings.TrimRight(sql, ";") //删除sql语句最后的分号
+	if err = c.startReadAfterWrite(sql); err != nil {
+		return err
+	}
+	if hasHandled, err := c.handleWarnings(sql); hasHandled {
+		return err
+	}
+	if isXAStatement(sql) {
+		return c.handleXA(sql)
+	}
+	if err = c.checkXAStatement(); err != nil {
+		return err
+	}
+	if isShowProcesslist(sql) {
+		return c.handleShowProcesslist(sql)
+	}
+	sql = c.state.rewriter.Rewrite(sql)
+	if sql, err = c.rewriteTenantSql(sql); err != nil {
+		return err
+	}
+	if rule := c.state.mocker.Match(sql); rule != nil {
+		return c.writeMockResult(rule)
+	}
+	if err = c.proxy.faults.SqlError(sql); err != nil {
+		return err
+	}
+	if hasHandled, err := c.handleLocalSelect(sql); hasHandled {
+		return err
+	}
+	sql = c.limitSelect(sql)
+	if err = c.checkMaskedSql(sql); err != nil {
+		return err
+	}
 	hasHandled, err := c.preHandleShard(sql)
 	if err != nil {
 		golog.Error("server", "preHandleShard", err.Error(), 0,
-			"sql", sql,
+			"sql", c.proxy.logSqlText(sql),
 			"hasHandled", hasHandled,
 		)
 		return err
@@ -63,23 +95,28 @@ func (c *ClientConn) handleQuery(sql string) (err error) {
 	}
 
 	var stmt sqlparser.Statement
-	stmt, err = sqlparser.Parse(sql) //解析sql语句,得到的stmt是一个interface
+	stmt, err = c.parse(normalizeSet(sql)) //解析sql语句,得到的stmt是一个interface
 	if err != nil {
-		golog.Error("server", "parse", err.Error(), 0, "hasHandled", hasHandled, "sql", sql)
-		return err
+		golog.Error("server", "parse", err.Error(), 0, "hasHandled", hasHandled, "sql", c.proxy.logSqlText(sql))
+		return c.handleParseFail(sql, err)
+	}
+	if c.dryRun {
+		if hasHandled, err := c.handleDryRun(stmt, sql); hasHandled {
+			return err
+		}
 	}
 
 	switch v := stmt.(type) {
 	case *sqlparser.Select:
-		return c.handleSelect(v, nil)
+		return c.handleSelect(v, sql, nil)
 	case *sqlparser.Insert:
-		return c.handleExec(stmt, nil)
+		return c.handleExec(stmt, sql, nil)
 	case *sqlparser.Update:
-		return c.handleExec(stmt, nil)
+		return c.handleExec(stmt, sql, nil)
 	case *sqlparser.Delete:
-		return c.handleExec(stmt, nil)
+		return c.handleExec(stmt, sql, nil)
 	case *sqlparser.Replace:
-		return c.handleExec(stmt, nil)
+		return c.handleExec(stmt, sql, nil)
 	case *sqlparser.Set:
 		return c.handleSet(v, sql)
 	case *sqlparser.Begin:
@@ -97,51 +134,120 @@ func (c *ClientConn) handleQuery(sql string) (err error) {
 	case *sqlparser.SimpleSelect:
 		return c.handleSimpleSelect(v)
 	case *sqlparser.Truncate:
-		return c.handleExec(stmt, nil)
+		return c.handleExec(stmt, sql, nil)
 	default:
-		return fmt.Errorf("statement %T not support now", stmt)
+		err = mysql.NewError(mysql.ER_KS_CMD_UNSUPPORT,
+			fmt.Sprintf("statement %T not support now", stmt))
+		return c.handleUnsupport(UnsupportStatement, sql, UnsupportReject, err, func() error {
+			return c.executeInDefaultNode(sql, false)
+		})
 	}
 
 	return nil
 }
 
 func (c *ClientConn) getBackendConn(n *backend.Node, fromSlave bool) (co *backend.BackendConn, err error) {
+	defer c.stageSince(StagePool, time.Now())
+	var release func()
 	if !c.isInTransaction() {
-		if fromSlave {
-			co, err = n.GetSlaveConn()
+		if release, err = c.state.userQuota.Acquire(c.user, n.Cfg.Name); err != nil {
+			return
+		}
+		if fromSlave && !c.consistency.strong {
+			//the bounded reads go to the master if no slave is fresh enough
+			if 0 < c.consistency.maxLag {
+				co, err = n.GetFreshSlaveConn(c.consistency.maxLag)
+			} else {
+				co, err = n.GetSlaveConn()
+			}
 			if err != nil {
 				co, err = n.GetMasterConn()
 			}
+			//the backups are used only if the master and all the slaves are
+			//down, and their lags are unknown
+			if err != nil && c.consistency.maxLag == 0 && n.HasBackup() {
+				co, err = n.GetBackupConn()
+			}
 		} else {
 			co, err = n.GetMasterConn()
 		}
 		if err != nil {
+			release()
 			golog.Error("server", "getBackendConn", err.Error(), 0)
 			return
 		}
+		co.SetRelease(release)
 	} else {
 		var ok bool
 		co, ok = c.txConns[n]
 
 		if !ok {
+			//the node of an unsharded or single table is checked here
+			if err = c.checkTxNodes(n); err != nil {
+				return
+			}
+			if release, err = c.state.userQuota.Acquire(c.user, n.Cfg.Name); err != nil {
+				return
+			}
 			if co, err = n.GetMasterConn(); err != nil {
+				release()
 				return
 			}
-
-			if !c.isAutoCommit() {
-				if err = co.SetAutoCommit(0); err != nil {
-					return
-				}
-			} else {
-				if err = co.Begin(); err != nil {
-					return
-				}
+			co.SetRelease(release)
+
+			switch {
+			case c.xa == TxXAActive:
+				_, err = co.ExecuteContext(c.ctx, "xa start "+c.xid)
+			case !c.isAutoCommit():
+				err = co.SetAutoCommit(0)
+			default:
+				err = co.Begin()
+			}
+			//the conn not in the transaction is closed with its quota
+			if err != nil {
+				co.Close()
+				return nil, err
 			}
 
 			c.txConns[n] = co
 		}
 	}
 
+	if err = c.initBackendConn(co); err != nil {
+		//the conns of transaction are closed by its rollback
+		if !c.isInTransaction() {
+			co.Close()
+			co = nil
+		}
+		return
+	}
+	c.proxy.faults.InjectConn(n.Cfg.Name, co)
+	return
+}
+
+//get a conn of the backups of node n, for the /*backup*/ hint
+func (c *ClientConn) getBackupConn(n *backend.Node) (*backend.BackendConn, error) {
+	release, err := c.state.userQuota.Acquire(c.user, n.Cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	co, err := n.GetBackupConn()
+	if err != nil {
+		release()
+		golog.Error("server", "getBackupConn", err.Error(), 0, "node", n.Cfg.Name)
+		return nil, err
+	}
+	co.SetRelease(release)
+	if err = c.initBackendConn(co); err != nil {
+		co.Close()
+		return nil, err
+	}
+	return co, nil
+}
+
+//use the db and charset of the client conn, and forward the query
+//attributes of the statement
+func (c *ClientConn) initBackendConn(co *backend.BackendConn) (err error) {
 	if err = co.UseDB(c.db); err != nil {
 		//reset the database to null
 		c.db = ""
@@ -152,30 +258,34 @@ func (c *ClientConn) getBackendConn(n *backend.Node, fromSlave bool) (co *backen
 		return
 	}
 
+	if err = co.SetVariables(c.sessionVars); err != nil {
+		return
+	}
+
+	co.SetQueryAttributes(c.forwardedQueryAttributes())
 	return
 }
 
 //获取shard的conn，第一个参数表示是不是select
 func (c *ClientConn) getShardConns(fromSlave bool, plan *router.Plan) (map[string]*backend.BackendConn, error) {
 	var err error
+	//the sqls of blackhole sub tables are not executed
+	if plan != nil && plan.Blackhole {
+		return nil, nil
+	}
 	if plan == nil || len(plan.RouteNodeIndexs) == 0 {
 		return nil, errors.ErrNoRouteNode
 	}
+	c.setHistoryPlan(plan)
 
 	nodesCount := len(plan.RouteNodeIndexs)
 	nodes := make([]*backend.Node, 0, nodesCount)
 	for i := 0; i < nodesCount; i++ {
 		nodeIndex := plan.RouteNodeIndexs[i]
-		nodes = append(nodes, c.proxy.GetNode(plan.Rule.Nodes[nodeIndex]))
+		nodes = append(nodes, c.getNode(plan.Rule.Nodes[nodeIndex]))
 	}
-	if c.isInTransaction() {
-		if 1 < len(nodes) {
-			return nil, errors.ErrTransInMulti
-		}
-		//exec in multi node
-		if len(c.txConns) == 1 && c.txConns[nodes[0]] == nil {
-			return nil, errors.ErrTransInMulti
-		}
+	if err = c.checkTxNodes(nodes...); err != nil {
+		return nil, err
 	}
 	conns := make(map[string]*backend.BackendConn)
 	var co *backend.BackendConn
@@ -191,10 +301,84 @@ func (c *ClientConn) getShardConns(fromSlave bool, plan *router.Plan) (map[strin
 	return conns, err
 }
 
+//get the conns of the backups of the nodes in plan, for the /*backup*/ hint
+func (c *ClientConn) getBackupShardConns(plan *router.Plan) (map[string]*backend.BackendConn, error) {
+	if plan == nil || len(plan.RouteNodeIndexs) == 0 {
+		return nil, errors.ErrNoRouteNode
+	}
+
+	conns := make(map[string]*backend.BackendConn)
+	for _, nodeIndex := range plan.RouteNodeIndexs {
+		nodeName := plan.Rule.Nodes[nodeIndex]
+		co, err := c.getBackupConn(c.getNode(nodeName))
+		if err != nil {
+			c.closeShardConns(conns, false)
+			return nil, err
+		}
+		conns[nodeName] = co
+	}
+	return conns, nil
+}
+
+//output the sql log, the error sqls of the same fingerprint are sampled.
+//The tag of the client statement is appended, as the comments are dropped
+//from the sqls rewritten.
+func outputSqlLog(state string, execTime float64, from, to interface{}, sql string, tag string) {
+	sql = appendLogTag(sql, tag)
+	if state == "ERROR" {
+		golog.OutputSqlSampled(state, mysql.GetFingerprint(sql), "%.1fms - %s->%s:%s",
+			execTime, from, to, sql)
+		return
+	}
+	golog.OutputSql(state, "%.1fms - %s->%s:%s", execTime, from, to, sql)
+}
+
+//the time of a sql sent to a node or sub table, where is shardError.where
+type shardTime struct {
+	where string
+	time  time.Duration
+}
+
+//the shards touched, the slowest shard and the time of each shard
+func formatShardTimes(times []shardTime) string {
+	slowest := 0
+	parts := make([]string, len(times))
+	for i, t := range times {
+		if times[slowest].time < t.time {
+			slowest = i
+		}
+		parts[i] = fmt.Sprintf("%s:%.1fms", t.where, float64(t.time)/float64(time.Millisecond))
+	}
+	return fmt.Sprintf("shards=%d slowest=%s(%.1fms) times=[%s]", len(times),
+		times[slowest].where, float64(times[slowest].time)/float64(time.Millisecond),
+		strings.Join(parts, " "))
+}
+
+//a statement sent to more than one shard and slower than slow_log_time is
+//logged with the time of each shard, so the skewed shard is found. The
+//slow sqls of the shards are logged by themselves as before.
+func (c *ClientConn) outputShardTimesLog(elapsed time.Duration, times []shardTime) {
+	if len(times) < 2 || c.proxy.logSql[c.proxy.logSqlIndex] == golog.LogSqlOff {
+		return
+	}
+	execTime := float64(elapsed) / float64(time.Millisecond)
+	if execTime <= float64(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex]) {
+		return
+	}
+	c.process.Lock()
+	sql := c.process.info
+	c.process.Unlock()
+	outputSqlLog("SHARDS", execTime, c.c.RemoteAddr(), c.proxy.addr,
+		formatShardTimes(times)+" sql:"+c.proxy.logSqlText(sql), c.tag)
+}
+
 func (c *ClientConn) executeInNode(conn *backend.BackendConn, sql string, args []interface{}) ([]*mysql.Result, error) {
 	var state string
-	startTime := time.Now().UnixNano()
-	r, err := conn.Execute(sql, args...)
+	sql = router.RewriteSchemas(sql, conn.SchemaRewrite())
+	start := time.Now()
+	startTime := start.UnixNano()
+	r, err := conn.ExecuteContext(c.ctx, sql, args...)
+	c.stageSince(StageBackend, start)
 	if err != nil {
 		state = "ERROR"
 	} else {
@@ -204,34 +388,51 @@ func (c *ClientConn) executeInNode(conn *backend.BackendConn, sql string, args [
 	if strings.ToLower(c.proxy.logSql[c.proxy.logSqlIndex]) != golog.LogSqlOff &&
 		execTime > float64(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex]) {
 		c.proxy.counter.IncrSlowLogTotal()
-		golog.OutputSql(state, "%.1fms - %s->%s:%s",
-			execTime,
-			c.c.RemoteAddr(),
-			conn.GetAddr(),
-			sql,
-		)
+		outputSqlLog(state, execTime, c.c.RemoteAddr(), conn.GetAddr(), c.proxy.logSqlText(sql), c.tag)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	c.recordTxSql(conn, sql, args)
+	if 0 < r.Warnings {
+		c.addShardWarnings(r.Warnings, c.getBackendWarnings(conn), shardError{}, 1)
+	}
 
 	return []*mysql.Result{r}, err
 }
 
-func (c *ClientConn) executeInMultiNodes(conns map[string]*backend.BackendConn, sqls map[string][]string, args []interface{}) ([]*mysql.Result, error) {
+//execute the sqls of plan in the nodes, all the sqls are executed even
+//if some of them fail, and the error tells which sub tables failed.
+func (c *ClientConn) executeInMultiNodes(conns map[string]*backend.BackendConn, plan *router.Plan, args []interface{}) ([]*mysql.Result, error) {
+	r, errs, err := c.executeShardSqls(conns, plan, args)
+	if err == nil && len(errs) != 0 {
+		err = newShardSqlError(errs, len(r))
+	}
+	return r, err
+}
+
+//execute the sqls of plan in the nodes, the result of a failed sql is nil
+//and its error is in errs
+func (c *ClientConn) executeShardSqls(conns map[string]*backend.BackendConn, plan *router.Plan, args []interface{}) ([]*mysql.Result, []shardError, error) {
+	sqls := plan.RewrittenSqls
 	if len(conns) != len(sqls) {
 		golog.Error("ClientConn", "executeInMultiNodes", errors.ErrConnNotEqual.Error(), c.connectionId,
 			"conns", conns,
 			"sqls", sqls,
 		)
-		return nil, errors.ErrConnNotEqual
+		return nil, nil, errors.ErrConnNotEqual
+	}
+	for nodeName := range sqls {
+		if _, ok := conns[nodeName]; !ok {
+			return nil, nil, errors.ErrConnNotEqual
+		}
 	}
 
 	var wg sync.WaitGroup
 
 	if len(conns) == 0 {
-		return nil, errors.ErrNoPlan
+		return nil, nil, errors.ErrNoPlan
 	}
 
 	wg.Add(len(conns))
@@ -242,55 +443,137 @@ func (c *ClientConn) executeInMultiNodes(conns map[string]*backend.BackendConn,
 	}
 
 	rs := make([]interface{}, resultCount)
+	//the warnings of each sql, which are got right after it
+	warnings := make([][]*mysql.SqlError, resultCount)
+
+	//the time of each sql
+	times := make([]time.Duration, resultCount)
 
 	f := func(rs []interface{}, i int, execSqls []string, co *backend.BackendConn) {
 		var state string
 		for _, v := range execSqls {
+			v = router.RewriteSchemas(v, co.SchemaRewrite())
 			startTime := time.Now().UnixNano()
-			r, err := co.Execute(v, args...)
+			r, err := co.ExecuteContext(c.ctx, v, args...)
+			times[i] = time.Duration(time.Now().UnixNano() - startTime)
 			if err != nil {
 				state = "ERROR"
 				rs[i] = err
 			} else {
 				state = "OK"
 				rs[i] = r
+				c.recordTxSql(co, v, args)
+				if 0 < r.Warnings {
+					warnings[i] = c.getBackendWarnings(co)
+				}
 			}
 			execTime := float64(time.Now().UnixNano()-startTime) / float64(time.Millisecond)
 			if c.proxy.logSql[c.proxy.logSqlIndex] != golog.LogSqlOff &&
 				execTime > float64(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex]) {
 				c.proxy.counter.IncrSlowLogTotal()
-				golog.OutputSql(state, "%.1fms - %s->%s:%s",
-					execTime,
-					c.c.RemoteAddr(),
-					co.GetAddr(),
-					v,
-				)
+				outputSqlLog(state, execTime, c.c.RemoteAddr(), co.GetAddr(), c.proxy.logSqlText(v), c.tag)
 			}
 			i++
 		}
 		wg.Done()
 	}
 
+	//the order of results is the order of sorted node names,
+	//ShardHeat depends on it.
+	start := time.Now()
 	offsert := 0
-	for nodeName, co := range conns {
+	for _, nodeName := range sortedNodeNames(sqls) {
 		s := sqls[nodeName] //[]string
-		go f(rs, offsert, s, co)
+		if c.exec.strategy.Serial {
+			f(rs, offsert, s, conns[nodeName])
+		} else {
+			go f(rs, offsert, s, conns[nodeName])
+		}
 		offsert += len(s)
 	}
 
 	wg.Wait()
+	elapsed := time.Since(start)
+	c.stageSince(StageBackend, start)
+	for _, d := range times {
+		c.exec.sqlTime += d
+	}
 
-	var err error
+	var errs []shardError
 	r := make([]*mysql.Result, resultCount)
-	for i, v := range rs {
-		if e, ok := v.(error); ok {
-			err = e
-			break
+	wheres := make([]string, resultCount)
+	offsert = 0
+	for _, nodeName := range sortedNodeNames(sqls) {
+		tables := plan.GetSubTables(nodeName)
+		for j := range sqls[nodeName] {
+			i := offsert + j
+			se := shardError{node: nodeName}
+			if j < len(tables) {
+				se.table = tables[j]
+			}
+			wheres[i] = se.where()
+			if e, ok := rs[i].(error); ok {
+				//the sub table is told by where
+				se.err = c.stripSubTablesError(e)
+				errs = append(errs, se)
+				continue
+			}
+			r[i] = rs[i].(*mysql.Result)
+			c.addShardWarnings(r[i].Warnings, warnings[i], se, resultCount)
 		}
-		r[i] = rs[i].(*mysql.Result)
+		offsert += len(sqls[nodeName])
 	}
+	shardTimes := make([]shardTime, resultCount)
+	for i := range shardTimes {
+		shardTimes[i] = shardTime{where: wheres[i], time: times[i]}
+	}
+	c.outputShardTimesLog(elapsed, shardTimes)
+	if err := reconcileShardFields(r, wheres, len(args) != 0); err != nil {
+		golog.Error("ClientConn", "executeShardSqls", err.Error(), c.connectionId)
+		return nil, nil, err
+	}
+	return r, errs, nil
+}
 
-	return r, err
+//the error of a sql sent to a sub table
+type shardError struct {
+	node  string
+	table string
+	err   error
+}
+
+//node.table, or node if the sql is not sent to a sub table
+func (e shardError) where() string {
+	if len(e.table) != 0 {
+		return e.node + "." + e.table
+	}
+	return e.node
+}
+
+func (e shardError) message() string {
+	if m, ok := e.err.(*mysql.SqlError); ok {
+		return m.Message
+	}
+	return e.err.Error()
+}
+
+//merge the errors of the sub tables into one error, with the code and
+//state of the first error, and the node and sub table of every error
+func newShardSqlError(errs []shardError, total int) *mysql.SqlError {
+	first := mysql.NewProxyError(errs[0].err)
+	if total == 1 && len(errs[0].table) == 0 {
+		return first
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, fmt.Sprintf("[%s] %s", e.where(), e.message()))
+	}
+	msg := strings.Join(msgs, "; ")
+	if 1 < total {
+		msg = fmt.Sprintf("%s (%d of %d sqls failed)", msg, len(errs), total)
+	}
+	return &mysql.SqlError{Code: first.Code, State: first.State, Message: msg}
 }
 
 func (c *ClientConn) closeConn(conn *backend.BackendConn, rollback bool) {
@@ -345,11 +628,12 @@ func (c *ClientConn) newEmptyResultset(stmt *sqlparser.Select) *mysql.Resultset
 	return r
 }
 
-func (c *ClientConn) handleExec(stmt sqlparser.Statement, args []interface{}) error {
-	plan, err := c.schema.rule.BuildPlan(c.db, stmt)
+func (c *ClientConn) handleExec(stmt sqlparser.Statement, sql string, args []interface{}) error {
+	plan, err := c.buildPlan(sql, stmt)
 	if err != nil {
 		return err
 	}
+	c.proxy.hotKey.Check(plan, false)
 	conns, err := c.getShardConns(false, plan)
 	defer c.closeShardConns(conns, err != nil)
 	if err != nil {
@@ -361,9 +645,16 @@ func (c *ClientConn) handleExec(stmt sqlparser.Statement, args []interface{}) er
 	}
 
 	var rs []*mysql.Result
+	var entry *JournalEntry
+	if entry, err = c.beginJournal(plan, args); err != nil {
+		return err
+	}
 
-	rs, err = c.executeInMultiNodes(conns, plan.RewrittenSqls, args)
+	rs, err = c.executeWriteInMultiNodes(conns, plan, args)
+	c.ackJournal(entry, rs, err)
+	c.markWrite()
 	if err == nil {
+		c.proxy.shardHeat.Record(plan, rs)
 		err = c.mergeExecResult(rs)
 	}
 
@@ -371,23 +662,7 @@ func (c *ClientConn) handleExec(stmt sqlparser.Statement, args []interface{}) er
 }
 
 func (c *ClientConn) mergeExecResult(rs []*mysql.Result) error {
-	r := new(mysql.Result)
-	for _, v := range rs {
-		r.Status |= v.Status
-		r.AffectedRows += v.AffectedRows
-		if r.InsertId == 0 {
-			r.InsertId = v.InsertId
-		} else if r.InsertId > v.InsertId {
-			//last insert id is first gen id for multi row inserted
-			//see http://dev.mysql.com/doc/refman/5.6/en/information-functions.html#function_last-insert-id
-			r.InsertId = v.InsertId
-		}
-	}
-
-	if r.InsertId > 0 {
-		c.lastInsertId = int64(r.InsertId)
-	}
-	c.affectedRows = int64(r.AffectedRows)
-
+	r := c.mergeExecResults(rs)
+	c.recordExecResult(r)
 	return c.writeOK(r)
 }
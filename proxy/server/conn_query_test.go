@@ -0,0 +1,281 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestShardSqlError(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+	_, plan, err := c.parseAndBuildPlan("select * from test1 where id in (1, 2, 5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := plan.GetSubTables("node1")
+	if len(tables) != 2 || tables[0] != "test1_0001" || tables[1] != "test1_0002" {
+		t.Fatal(tables)
+	}
+	if tables = plan.GetSubTables("node2"); len(tables) != 1 || tables[0] != "test1_0005" {
+		t.Fatal(tables)
+	}
+
+	errs := []shardError{
+		{"node1", "test1_0002", mysql.NewDefaultError(mysql.ER_NO_SUCH_TABLE, "kingshard", "test1_0002")},
+		{"node2", "test1_0005", errors.ErrBadConn},
+	}
+	e := newShardSqlError(errs, 3)
+	expect := "[node1.test1_0002] Table 'kingshard.test1_0002' doesn't exist; " +
+		"[node2.test1_0005] connection was bad (2 of 3 sqls failed)"
+	if e.Code != mysql.ER_NO_SUCH_TABLE || e.Message != expect {
+		t.Fatal(e.Code, e.Message)
+	}
+
+	//the error of an unsharded sql is kept
+	e = newShardSqlError([]shardError{{"node1", "", errors.ErrBadConn}}, 1)
+	if e.Code != mysql.ER_KS_BAD_CONN || e.Message != errors.ErrBadConn.Error() {
+		t.Fatal(e)
+	}
+}
+
+func TestFormatShardTimes(t *testing.T) {
+	s := formatShardTimes([]shardTime{
+		{"node1.test1_0001", 1200 * time.Microsecond},
+		{"node1.test1_0002", 35 * time.Millisecond},
+		{"node2", 3 * time.Millisecond},
+	})
+	expect := "shards=3 slowest=node1.test1_0002(35.0ms) " +
+		"times=[node1.test1_0001:1.2ms node1.test1_0002:35.0ms node2:3.0ms]"
+	if s != expect {
+		t.Fatal(s)
+	}
+}
+
+func TestBackupShardConns(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+	c.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	c.proxy = newStateServer(new(config.Config))
+	c.state = c.proxy.getState()
+	c.state.userQuota = NewUserQuota(nil)
+	c.state.nodes = map[string]*backend.Node{
+		"node1": {Cfg: config.NodeConfig{Name: "node1"}},
+		"node2": {Cfg: config.NodeConfig{Name: "node2"}},
+	}
+	n := c.proxy.GetNode("node1")
+	if err := n.ParseBackup(" ,"); err != nil || n.HasBackup() {
+		t.Fatal(err, n.Backup)
+	}
+
+	_, plan, err := c.parseAndBuildPlan("select /*backup*/ * from test1 where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.getBackupShardConns(plan); err != errors.ErrNoBackupDB {
+		t.Fatal(err)
+	}
+	//the master error is returned if there is no backup
+	if _, err = c.getBackendConn(n, true); err != errors.ErrNoMasterConn {
+		t.Fatal(err)
+	}
+}
+
+var singleTableConfig = fakeBackendConfig + `
+    -
+        db : kingshard
+        table : sessions
+        type : single
+        node : node2
+`
+
+//the sqls of a single table go to its node instead of the default node
+func TestSingleTable(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, singleTableConfig)
+	defer close()
+
+	sqls := []string{
+		"select * from sessions where id = 1",
+		"insert into sessions values (1, 'a')",
+		"update sessions set a = 'b' where id = 1",
+		"delete from sessions where id = 1",
+		"truncate sessions",
+		"alter table sessions add c int",
+		"desc sessions",
+	}
+	for _, sql := range sqls {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(sql, err)
+		}
+		if !hasQuery(backends[1].Queries(), sql) || hasQuery(backends[0].Queries(), sql) {
+			t.Fatal(sql, backends[0].Queries(), backends[1].Queries())
+		}
+	}
+	if _, err := c.Execute("select * from users"); err != nil {
+		t.Fatal(err)
+	}
+	if !hasQuery(backends[0].Queries(), "select * from users") {
+		t.Fatal(backends[0].Queries())
+	}
+
+	//the transaction on the default node can not use the single table
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into users values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Execute("select * from sessions")
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_TRANS_IN_MULTI {
+		t.Fatal(err)
+	}
+}
+
+var blackholeConfig = fakeBackendConfig + `
+    -
+        db : kingshard
+        table : log
+        key : ctime
+        type : date_year
+        nodes : [blackhole,node2]
+        date_range : [2000-2009,2010-2099]
+`
+
+//the sqls of the sub tables on the blackhole node are not executed
+func TestBlackholeTable(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, blackholeConfig)
+	defer close()
+
+	r, err := c.Execute("select id from log where ctime = '2005-01-02'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Resultset == nil || r.RowNumber() != 0 {
+		t.Fatal(r)
+	}
+	r, err = c.Execute("insert into log(id, ctime) values(1, '2005-01-01')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 0 {
+		t.Fatal(r.AffectedRows)
+	}
+	if _, err = c.Execute("delete from log where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range backends {
+		if hasQuery(b.Queries(), "log_2005") {
+			t.Fatal(b.Queries())
+		}
+	}
+	if !hasQuery(backends[1].Queries(), "delete from log_2099 where id = 1") {
+		t.Fatal(backends[1].Queries())
+	}
+}
+
+var federatedTableConfig = fakeBackendConfig + `
+    -
+        db : kingshard
+        table : orders
+        type : federated
+        nodes : [node1,node2]
+`
+
+//the selects of a federated table are sent to all its nodes and merged,
+//the writes are denied
+func TestFederatedTable(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, federatedTableConfig)
+	defer close()
+	backends[0].Handle(`from orders`, &mysqltest.Response{
+		Names: []string{"id", "region"},
+		Rows:  [][]interface{}{{3, "east"}, {1, "east"}},
+	})
+	backends[1].Handle(`from orders`, &mysqltest.Response{
+		Names: []string{"id", "region"},
+		Rows:  [][]interface{}{{2, "west"}},
+	})
+
+	r, err := c.Execute("select id, region from orders order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 3 {
+		t.Fatal(r.Values)
+	}
+	for i := 0; i < 3; i++ {
+		if id, _ := r.GetInt(i, 0); id != int64(i+1) {
+			t.Fatal(r.Values)
+		}
+	}
+	for _, b := range backends {
+		if !hasQuery(b.Queries(), "select id, region from orders order by id") {
+			t.Fatal(b.Queries())
+		}
+	}
+
+	_, err = c.Execute("insert into orders (id, region) values (4, 'north')")
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_OPERATION_DENIED {
+		t.Fatal(err)
+	}
+}
+
+//the database kingshard is renamed to kingshard_v2 in node1
+var schemaRewriteConfig = strings.Replace(fakeBackendConfig, `
+    master : %s
+-`, `
+    master : %s
+    schema_rewrite :
+        kingshard : kingshard_v2
+-`, 1)
+
+func TestSchemaRewrite(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, schemaRewriteConfig)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`from t_`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{2}},
+		})
+	}
+
+	for _, sql := range []string{
+		"select * from t where id in (2, 3)",
+		"select * from kingshard.users",
+	} {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(sql, err)
+		}
+	}
+	if !hasQuery(backends[0].Queries(), "from kingshard_v2.users") {
+		t.Fatal(backends[0].Queries())
+	}
+	for i, db := range []string{"kingshard_v2", "kingshard"} {
+		dbs := backends[i].InitDBs()
+		if len(dbs) == 0 {
+			t.Fatal(i, dbs)
+		}
+		for _, v := range dbs {
+			if v != db {
+				t.Fatal(i, dbs)
+			}
+		}
+	}
+}
@@ -17,6 +17,7 @@ package server
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/hack"
@@ -56,13 +57,15 @@ func formatValue(value interface{}) ([]byte, error) {
 		return v, nil
 	case string:
 		return hack.Slice(v), nil
+	case mysql.Decimal:
+		return []byte(v), nil
 	default:
 		return nil, fmt.Errorf("invalid type %T", value)
 	}
 }
 
 func formatField(field *mysql.Field, value interface{}) error {
-	switch value.(type) {
+	switch v := value.(type) {
 	case int8, int16, int32, int64, int:
 		field.Charset = 63
 		field.Type = mysql.MYSQL_TYPE_LONGLONG
@@ -75,9 +78,20 @@ func formatField(field *mysql.Field, value interface{}) error {
 		field.Charset = 63
 		field.Type = mysql.MYSQL_TYPE_DOUBLE
 		field.Flag = mysql.BINARY_FLAG | mysql.NOT_NULL_FLAG
+	case mysql.Decimal:
+		field.Charset = 63
+		field.Type = mysql.MYSQL_TYPE_NEWDECIMAL
+		field.Flag = mysql.BINARY_FLAG | mysql.NOT_NULL_FLAG
+		if _, scale, err := v.Unscaled(); err == nil {
+			field.Decimal = uint8(scale)
+		}
 	case string, []byte:
 		field.Charset = 33
 		field.Type = mysql.MYSQL_TYPE_VAR_STRING
+	case nil:
+		field.Charset = 63
+		field.Type = mysql.MYSQL_TYPE_NULL
+		field.Flag = mysql.BINARY_FLAG
 	default:
 		return fmt.Errorf("unsupport type %T for resultset", value)
 	}
@@ -122,6 +136,11 @@ func (c *ClientConn) buildResultset(fields []*mysql.Field, names []string, value
 				}
 
 			}
+			//NULL is 0xfb in the text protocol
+			if value == nil {
+				row = append(row, 0xfb)
+				continue
+			}
 			b, err = formatValue(value)
 			if err != nil {
 				return nil, err
@@ -132,13 +151,30 @@ func (c *ClientConn) buildResultset(fields []*mysql.Field, names []string, value
 
 		r.RowDatas = append(r.RowDatas, row)
 	}
+	//no rows, the column definition is string
+	if len(values) == 0 {
+		for j := range r.Fields {
+			if ExistFields {
+				r.Fields[j] = fields[j]
+			} else {
+				r.Fields[j] = &mysql.Field{Name: hack.Slice(names[j])}
+				formatField(r.Fields[j], "")
+			}
+		}
+	}
 	//assign the values to the result
 	r.Values = values
+	//the names are used to sort the result by order by
+	r.FieldNames = make(map[string]int, len(r.Fields))
+	for i, f := range r.Fields {
+		r.FieldNames[string(f.Name)] = i
+	}
 
 	return r, nil
 }
 
 func (c *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
+	defer c.stageSince(StageWrite, time.Now())
 	c.affectedRows = int64(-1)
 	total := make([]byte, 0, 4096)
 	data := make([]byte, 4, 512)
@@ -161,7 +197,7 @@ func (c *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
 		}
 	}
 
-	total, err = c.writeEOFBatch(total, status, false)
+	total, err = c.writeFieldsEOFBatch(total, status)
 	if err != nil {
 		return err
 	}
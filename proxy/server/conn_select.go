@@ -17,18 +17,24 @@ package server
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/flike/kingshard/backend"
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/core/hack"
 	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
 	"github.com/flike/kingshard/sqlparser"
 )
 
 const (
 	MasterComment    = "/*master*/"
+	BackupComment    = "/*backup*/"
 	SumFunc          = "sum"
 	CountFunc        = "count"
 	MaxFunc          = "max"
@@ -56,7 +62,7 @@ func (c *ClientConn) handleFieldList(data []byte) error {
 
 	nodeName := c.schema.rule.GetRule(c.db, table).Nodes[0]
 
-	n := c.proxy.GetNode(nodeName)
+	n := c.getNode(nodeName)
 
 	co, err := n.GetMasterConn()
 	defer c.closeConn(co, false)
@@ -97,20 +103,53 @@ func (c *ClientConn) writeFieldList(status uint16, fs []*mysql.Field) error {
 }
 
 //处理select语句
-func (c *ClientConn) handleSelect(stmt *sqlparser.Select, args []interface{}) error {
+func (c *ClientConn) handleSelect(stmt *sqlparser.Select, sql string, args []interface{}) error {
 	var fromSlave bool = true
-	plan, err := c.schema.rule.BuildPlan(c.db, stmt)
+	plan, err := c.buildPlan(sql, stmt)
 	if err != nil {
 		return err
 	}
-	if 0 < len(stmt.Comments) {
-		comment := string(stmt.Comments[0])
-		if 0 < len(comment) && strings.ToLower(comment) == MasterComment {
-			fromSlave = false
+	if err := c.proxy.hotKey.Check(plan, true); err != nil {
+		return err
+	}
+	if hasComment(stmt, MasterComment) {
+		fromSlave = false
+	}
+	c.applyTableConsistency(plan.Rule)
+	if plan.Blackhole {
+		return c.writeResultset(c.status, c.newEmptyResultset(stmt))
+	}
+
+	//the backup hint is used to verify the data of the backups, it is
+	//ignored in transaction
+	toBackup := hasComment(stmt, BackupComment) && !c.isInTransaction()
+
+	//the stats of fingerprints are kept only if a threshold is set
+	var fingerprint string
+	if cfg := c.state.cfg.ExecStrategy; 0 < cfg.SerialMaxTime || 0 < cfg.StreamMinRows {
+		fingerprint = mysql.GetFingerprint(sql)
+	}
+	c.exec = execState{strategy: c.chooseExecStrategy(stmt, plan, fingerprint, args)}
+	defer func() {
+		c.exec = execState{}
+	}()
+
+	var rs []*mysql.Result
+	if !toBackup && c.isPartialResult(stmt) {
+		rs, err = c.executeSelectPartial(fromSlave, plan, args)
+		if err != nil {
+			golog.Error("ClientConn", "handleSelect", err.Error(), c.connectionId)
+			return err
 		}
+		return c.mergeSelectResult(rs, stmt, plan)
 	}
 
-	conns, err := c.getShardConns(fromSlave, plan)
+	var conns map[string]*backend.BackendConn
+	if toBackup {
+		conns, err = c.getBackupShardConns(plan)
+	} else {
+		conns, err = c.getShardConns(fromSlave, plan)
+	}
 	if err != nil {
 		golog.Error("ClientConn", "handleSelect", err.Error(), c.connectionId)
 		return err
@@ -120,15 +159,32 @@ func (c *ClientConn) handleSelect(stmt *sqlparser.Select, args []interface{}) er
 		return c.writeResultset(c.status, r)
 	}
 
-	var rs []*mysql.Result
-	rs, err = c.executeInMultiNodes(conns, plan.RewrittenSqls, args)
+	if c.exec.strategy.Stream {
+		rows, err := c.streamSelect(stmt, conns, plan)
+		c.closeShardConns(conns, false)
+		c.recordExec(fingerprint, plan, rows)
+		if err != nil {
+			golog.Error("ClientConn", "handleSelect", err.Error(), c.connectionId)
+		}
+		return err
+	}
+
+	rs, err = c.executeInMultiNodes(conns, plan, args)
 	c.closeShardConns(conns, false)
 	if err != nil {
 		golog.Error("ClientConn", "handleSelect", err.Error(), c.connectionId)
 		return err
 	}
+	c.proxy.shardHeat.Record(plan, rs)
+	var rows int64
+	for _, r := range rs {
+		if r != nil && r.Resultset != nil {
+			rows += int64(len(r.Values))
+		}
+	}
+	c.recordExec(fingerprint, plan, rows)
 
-	err = c.mergeSelectResult(rs, stmt)
+	err = c.mergeSelectResult(rs, stmt, plan)
 	if err != nil {
 		golog.Error("ClientConn", "handleSelect", err.Error(), c.connectionId)
 	}
@@ -136,9 +192,11 @@ func (c *ClientConn) handleSelect(stmt *sqlparser.Select, args []interface{}) er
 	return err
 }
 
-func (c *ClientConn) mergeSelectResult(rs []*mysql.Result, stmt *sqlparser.Select) error {
+func (c *ClientConn) mergeSelectResult(rs []*mysql.Result, stmt *sqlparser.Select,
+	plan *router.Plan) error {
 	var r *mysql.Result
 	var err error
+	start := time.Now()
 
 	if len(stmt.GroupBy) == 0 {
 		r, err = c.buildSelectOnlyResult(rs, stmt)
@@ -149,6 +207,11 @@ func (c *ClientConn) mergeSelectResult(rs []*mysql.Result, stmt *sqlparser.Selec
 	if err != nil {
 		return err
 	}
+	if plan.PostHaving {
+		if err := filterHaving(r.Resultset, stmt); err != nil {
+			return err
+		}
+	}
 
 	c.sortSelectResult(r.Resultset, stmt)
 	//to do, add log here, sort may error because order by key not exist in resultset fields
@@ -157,6 +220,11 @@ func (c *ClientConn) mergeSelectResult(rs []*mysql.Result, stmt *sqlparser.Selec
 		return err
 	}
 
+	r.Fields = c.stripFieldTables(r.Fields)
+	if err := c.maskResult(r.Resultset, fromTables(stmt.From, c.db)); err != nil {
+		return err
+	}
+	c.stageSince(StageMerge, start)
 	return c.writeResultset(r.Status, r.Resultset)
 }
 
@@ -225,6 +293,7 @@ func (c *ClientConn) buildSelectGroupByResult(rs []*mysql.Result,
 		for i := 0; i < len(r.Values); i++ {
 			r.Values[i] = r.Values[i][:groupByIndexs[0]]
 		}
+		r.Fields = widenSumFields(r.Fields, r.Values, funcExprs)
 		r.Resultset, err = c.buildResultset(r.Fields, names, r.Values)
 		if err != nil {
 			return nil, err
@@ -390,12 +459,11 @@ func (c *ClientConn) buildSelectOnlyResult(rs []*mysql.Result,
 	stmt *sqlparser.Select) (*mysql.Result, error) {
 	var err error
 	r := rs[0].Resultset
-	status := c.status | rs[0].Status
+	status := c.resultStatus(rs...)
 
 	funcExprs := c.getFuncExprs(stmt)
 	if len(funcExprs) == 0 {
 		for i := 1; i < len(rs); i++ {
-			status |= rs[i].Status
 			for j := range rs[i].Values {
 				r.Values = append(r.Values, rs[i].Values[j])
 				r.RowDatas = append(r.RowDatas, rs[i].RowDatas[j])
@@ -431,6 +499,11 @@ func (c *ClientConn) sortSelectResult(r *mysql.Resultset, stmt *sqlparser.Select
 
 func (c *ClientConn) limitSelectResult(r *mysql.Resultset, stmt *sqlparser.Select) error {
 	if stmt.Limit == nil {
+		//each node returns sql_select_limit rows at most
+		if limit, ok := c.selectLimit(); ok && limit < len(r.Values) {
+			r.Values = r.Values[:limit]
+			r.RowDatas = r.RowDatas[:limit]
+		}
 		return nil
 	}
 
@@ -499,7 +572,8 @@ func (c *ClientConn) buildFuncExprResult(stmt *sqlparser.Select,
 		for _, field := range rs[0].Fields {
 			names = append(names, string(field.Name))
 		}
-		r, err = c.buildResultset(rs[0].Fields, names, r.Values)
+		fields := widenSumFields(rs[0].Fields, r.Values, funcExprs)
+		r, err = c.buildResultset(fields, names, r.Values)
 		if err != nil {
 			return nil, err
 		}
@@ -536,14 +610,38 @@ func (c *ClientConn) getFuncExprs(stmt *sqlparser.Select) map[int]string {
 	return funcExprs
 }
 
+//sum the values exactly, the integers and decimals are added as decimals
+//without the loss of float64. The sum of integers overflowing bigint or
+//bigint unsigned is widened to decimal, and the sum is float64 only if
+//some value is float.
 func (c *ClientConn) getSumFuncExprValue(rs []*mysql.Result,
 	index int) (interface{}, error) {
 	var sumf float64
-	var sumi int64
-	var IsInt bool
+	var IsFloat, IsInt, IsUint, IsDecimal bool
 	var err error
 	var result interface{}
 
+	//the exact sum is sum/10^scale
+	sum := new(big.Int)
+	scale := 0
+	addDecimal := func(d mysql.Decimal) error {
+		v, s, err := d.Unscaled()
+		if err != nil {
+			return err
+		}
+		if scale < s {
+			sum = mysql.Rescale(sum, scale, s)
+			scale = s
+		}
+		sum.Add(sum, mysql.Rescale(v, s, scale))
+		IsDecimal = true
+		return nil
+	}
+	addInt := func(v int64) {
+		sum.Add(sum, mysql.Rescale(big.NewInt(v), 0, scale))
+		IsInt = true
+	}
+
 	for _, r := range rs {
 		for k := range r.Values {
 			result, err = r.GetValue(k, index)
@@ -556,35 +654,113 @@ func (c *ClientConn) getSumFuncExprValue(rs []*mysql.Result,
 
 			switch v := result.(type) {
 			case int:
-				sumi = sumi + int64(v)
-				IsInt = true
+				addInt(int64(v))
 			case int32:
-				sumi = sumi + int64(v)
-				IsInt = true
+				addInt(int64(v))
 			case int64:
-				sumi = sumi + v
-				IsInt = true
+				addInt(v)
+			case uint64:
+				sum.Add(sum, mysql.Rescale(new(big.Int).SetUint64(v), 0, scale))
+				IsUint = true
 			case float32:
 				sumf = sumf + float64(v)
+				IsFloat = true
 			case float64:
 				sumf = sumf + v
+				IsFloat = true
+			case mysql.Decimal:
+				if err = addDecimal(v); err != nil {
+					return nil, err
+				}
 			case []byte:
+				if addDecimal(mysql.Decimal(v)) == nil {
+					continue
+				}
 				tmp, err := strconv.ParseFloat(string(v), 64)
 				if err != nil {
 					return nil, err
 				}
 
 				sumf = sumf + tmp
+				IsFloat = true
 			default:
 				return nil, errors.ErrSumColumnType
 			}
 		}
 	}
-	if IsInt {
-		return sumi, nil
-	} else {
-		return sumf, nil
+
+	switch {
+	case IsFloat:
+		f, err := mysql.NewDecimal(sum, scale).Float64()
+		if err != nil {
+			return nil, err
+		}
+		return sumf + f, nil
+	case IsDecimal:
+		return mysql.NewDecimal(sum, scale), nil
+	case IsUint && !IsInt:
+		if sum.Sign() < 0 || 64 < sum.BitLen() {
+			return mysql.NewDecimal(sum, 0), nil
+		}
+		return sum.Uint64(), nil
+	case IsInt || IsUint:
+		if sum.Cmp(minInt64) < 0 || 0 < sum.Cmp(maxInt64) {
+			return mysql.NewDecimal(sum, 0), nil
+		}
+		return sum.Int64(), nil
+	}
+	return sumf, nil
+}
+
+var (
+	minInt64 = big.NewInt(math.MinInt64)
+	maxInt64 = big.NewInt(math.MaxInt64)
+)
+
+//the sum widened to decimal needs a decimal column, and a column whose
+//length is less than the sum is widened too
+func widenSumFields(fields []*mysql.Field, values [][]interface{},
+	funcExprs map[int]string) []*mysql.Field {
+	for index, funcName := range funcExprs {
+		if funcName != SumFunc || len(fields) <= index {
+			continue
+		}
+		field := fields[index]
+		for _, row := range values {
+			if len(row) <= index {
+				continue
+			}
+			d, ok := row[index].(mysql.Decimal)
+			if !ok {
+				continue
+			}
+			_, scale, err := d.Unscaled()
+			if err != nil {
+				continue
+			}
+			isDecimal := field.Type == mysql.MYSQL_TYPE_NEWDECIMAL ||
+				field.Type == mysql.MYSQL_TYPE_DECIMAL
+			if isDecimal && uint32(len(d)) <= field.ColumnLength && uint8(scale) <= field.Decimal {
+				continue
+			}
+			if field == fields[index] {
+				copied := *field
+				field = &copied
+				//the field is dumped from the changed definition
+				field.Data = nil
+			}
+			field.Type = mysql.MYSQL_TYPE_NEWDECIMAL
+			field.Flag &^= mysql.UNSIGNED_FLAG
+			if field.ColumnLength < uint32(len(d)) {
+				field.ColumnLength = uint32(len(d))
+			}
+			if field.Decimal < uint8(scale) {
+				field.Decimal = uint8(scale)
+			}
+		}
+		fields[index] = field
 	}
+	return fields
 }
 
 func (c *ClientConn) getMaxFuncExprValue(rs []*mysql.Result,
@@ -633,6 +809,10 @@ func (c *ClientConn) getMaxFuncExprValue(rs []*mysql.Result,
 				if max.(float64) < result.(float64) {
 					max = result
 				}
+			case mysql.Decimal:
+				if max.(mysql.Decimal).Cmp(result.(mysql.Decimal)) < 0 {
+					max = result
+				}
 			case string:
 				if max.(string) < result.(string) {
 					max = result
@@ -689,6 +869,10 @@ func (c *ClientConn) getMinFuncExprValue(
 				if min.(float64) > result.(float64) {
 					min = result
 				}
+			case mysql.Decimal:
+				if 0 < min.(mysql.Decimal).Cmp(result.(mysql.Decimal)) {
+					min = result
+				}
 			case string:
 				if min.(string) > result.(string) {
 					min = result
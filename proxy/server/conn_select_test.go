@@ -0,0 +1,72 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func newSumResults(fieldType uint8, flag uint16, values ...interface{}) []*mysql.Result {
+	var rs []*mysql.Result
+	for _, v := range values {
+		rs = append(rs, &mysql.Result{Resultset: &mysql.Resultset{
+			Fields: []*mysql.Field{{Name: []byte("sum(a)"), Type: fieldType, Flag: flag, ColumnLength: 20}},
+			Values: [][]interface{}{{v}},
+		}})
+	}
+	return rs
+}
+
+func TestSumFuncExprValue(t *testing.T) {
+	c := new(ClientConn)
+	tests := []struct {
+		rs     []*mysql.Result
+		expect interface{}
+	}{
+		//decimals are added exactly
+		{newSumResults(mysql.MYSQL_TYPE_NEWDECIMAL, 0, mysql.Decimal("0.10"), mysql.Decimal("0.20"), nil), mysql.Decimal("0.30")},
+		{newSumResults(mysql.MYSQL_TYPE_NEWDECIMAL, 0, mysql.Decimal("12345678901234567.89"), mysql.Decimal("0.01")),
+			mysql.Decimal("12345678901234567.90")},
+		{newSumResults(mysql.MYSQL_TYPE_LONGLONG, 0, int64(1), int64(-3)), int64(-2)},
+		{newSumResults(mysql.MYSQL_TYPE_LONGLONG, mysql.UNSIGNED_FLAG, uint64(1), uint64(2)), uint64(3)},
+		//the overflow of bigint unsigned is widened to decimal
+		{newSumResults(mysql.MYSQL_TYPE_LONGLONG, mysql.UNSIGNED_FLAG, uint64(18446744073709551615), uint64(2)),
+			mysql.Decimal("18446744073709551617")},
+		{newSumResults(mysql.MYSQL_TYPE_DOUBLE, 0, float64(0.5), float64(0.25)), float64(0.75)},
+	}
+	for i, test := range tests {
+		v, err := c.getSumFuncExprValue(test.rs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != test.expect {
+			t.Fatalf("%d expect %v(%T), got %v(%T)", i, test.expect, test.expect, v, v)
+		}
+	}
+
+	//the widened sum has a decimal column
+	rs := tests[4].rs
+	fields := widenSumFields(rs[0].Fields, [][]interface{}{{tests[4].expect}}, map[int]string{0: SumFunc})
+	f := fields[0]
+	if f.Type != mysql.MYSQL_TYPE_NEWDECIMAL || f.Flag&mysql.UNSIGNED_FLAG != 0 || f.ColumnLength != 20 || f.Decimal != 0 {
+		t.Fatal(f.Type, f.Flag, f.ColumnLength, f.Decimal)
+	}
+	fields = widenSumFields(rs[0].Fields, [][]interface{}{{mysql.Decimal("123456789012345678901.5")}}, map[int]string{0: SumFunc})
+	if fields[0].ColumnLength != 23 || fields[0].Decimal != 1 {
+		t.Fatal(fields[0].ColumnLength, fields[0].Decimal)
+	}
+}
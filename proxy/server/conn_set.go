@@ -16,6 +16,8 @@ package server
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,11 +29,90 @@ import (
 
 var nstring = sqlparser.String
 
-func (c *ClientConn) handleSet(stmt *sqlparser.Set, sql string) (err error) {
-	if len(stmt.Exprs) != 1 && len(stmt.Exprs) != 2 {
-		return fmt.Errorf("must set one item once, not %s", nstring(stmt))
+//the session variables tracked by kingshard, they are set in every backend
+//conn used by the session, so they take effect whichever conn executes the
+//statements. The variables in session_variables allow are tracked too.
+var SessionVariables = map[string]bool{
+	"sql_mode":                 true,
+	"time_zone":                true,
+	"sql_select_limit":         true,
+	"sql_safe_updates":         true,
+	"sql_auto_is_null":         true,
+	"sql_big_selects":          true,
+	"sql_notes":                true,
+	"sql_warnings":             true,
+	"foreign_key_checks":       true,
+	"unique_checks":            true,
+	"group_concat_max_len":     true,
+	"max_execution_time":       true,
+	"lock_wait_timeout":        true,
+	"innodb_lock_wait_timeout": true,
+	"net_read_timeout":         true,
+	"net_write_timeout":        true,
+	"wait_timeout":             true,
+	"transaction_isolation":    true,
+	"tx_isolation":             true,
+	"transaction_read_only":    true,
+	"tx_read_only":             true,
+	"div_precision_increment":  true,
+	"lc_time_names":            true,
+}
+
+var (
+	setScopeRegexp   = regexp.MustCompile(`(?i)^(\s*set\s+)(session|local)\s+`)
+	setDefaultRegexp = regexp.MustCompile(`(?i)=\s*default\s*(,|$)`)
+)
+
+//the sqlparser does not support the scope keywords and the value default
+//of set, so set session x = default is parsed as set x = 'DEFAULT'
+func normalizeSet(sql string) string {
+	if len(sql) < 3 || !strings.EqualFold(sql[:3], mysql.TK_STR_SET) {
+		return sql
+	}
+	sql = setScopeRegexp.ReplaceAllString(sql, "$1")
+	return setDefaultRegexp.ReplaceAllString(sql, "= 'DEFAULT'$1")
+}
+
+//the name of variable in lower case without @@ and the scope, global is
+//true for @@global.x
+func setVariableName(col *sqlparser.ColName) (string, bool) {
+	name := strings.TrimPrefix(strings.ToLower(string(col.Name)), "@@")
+	return name, strings.ToLower(string(col.Qualifier)) == "@@global"
+}
+
+//the user variable set to null and the session variable set to default
+//are not tracked any more
+func isDefaultValue(name string, val sqlparser.ValExpr) bool {
+	if strings.HasPrefix(name, "@") {
+		_, ok := val.(*sqlparser.NullVal)
+		return ok
+	}
+	v, ok := val.(sqlparser.StrVal)
+	return ok && strings.EqualFold(string(v), "default")
+}
+
+func (s *Server) isSessionVariable(name string) bool {
+	if SessionVariables[name] {
+		return true
+	}
+	for _, v := range s.getState().cfg.SessionVariables.Allow {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) isSetDenied(name string) bool {
+	for _, v := range s.getState().cfg.SessionVariables.Deny {
+		if strings.EqualFold(v, name) {
+			return true
+		}
 	}
+	return false
+}
 
+func (c *ClientConn) handleSet(stmt *sqlparser.Set, sql string) (err error) {
 	//log the SQL
 	startTime := time.Now().UnixNano()
 	defer func() {
@@ -45,37 +126,108 @@ func (c *ClientConn) handleSet(stmt *sqlparser.Set, sql string) (err error) {
 		if c.proxy.logSql[c.proxy.logSqlIndex] != golog.LogSqlOff &&
 			execTime > float64(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex]) {
 			c.proxy.counter.IncrSlowLogTotal()
-			golog.OutputSql(state, "%.1fms - %s->%s:%s",
-				execTime,
-				c.c.RemoteAddr(),
-				c.proxy.addr,
-				sql,
-			)
+			outputSqlLog(state, execTime, c.c.RemoteAddr(), c.proxy.addr, c.proxy.logSqlText(sql), c.tag)
 		}
 
 	}()
 
-	k := string(stmt.Exprs[0].Name.Name)
-	switch strings.ToUpper(k) {
-	case `AUTOCOMMIT`, `@@AUTOCOMMIT`, `@@SESSION.AUTOCOMMIT`:
-		return c.handleSetAutoCommit(stmt.Exprs[0].Expr)
-	case `NAMES`,
-		`CHARACTER_SET_RESULTS`, `@@CHARACTER_SET_RESULTS`, `@@SESSION.CHARACTER_SET_RESULTS`,
-		`CHARACTER_SET_CLIENT`, `@@CHARACTER_SET_CLIENT`, `@@SESSION.CHARACTER_SET_CLIENT`,
-		`CHARACTER_SET_CONNECTION`, `@@CHARACTER_SET_CONNECTION`, `@@SESSION.CHARACTER_SET_CONNECTION`:
-		if len(stmt.Exprs) == 2 {
+	if len(stmt.Exprs) == 1 {
+		k := string(stmt.Exprs[0].Name.Name)
+		switch strings.ToUpper(k) {
+		case TenantVariable:
+			return c.handleSetTenant(stmt.Exprs[0].Expr)
+		case ShardPinVariable:
+			return c.handleSetShardPin(stmt.Exprs[0].Expr)
+		case NodePinVariable:
+			return c.handleSetNodePin(stmt.Exprs[0].Expr)
+		case DryRunVariable:
+			return c.handleSetDryRun(stmt.Exprs[0].Expr)
+		}
+	}
+	return c.handleSetVariables(stmt, sql)
+}
+
+//the items of set are checked before any of them is applied:
+//  global variables and the ones in deny are rejected
+//  autocommit, names and character_set_* are handled by kingshard
+//  the user variables and the tracked session variables are recorded
+//  the others are handled by the unsupport policy of set, forward default
+func (c *ClientConn) handleSetVariables(stmt *sqlparser.Set, sql string) error {
+	var autocommit, charset, collate sqlparser.ValExpr
+	vars := make(map[string]sqlparser.ValExpr)
+	for i := 0; i < len(stmt.Exprs); i++ {
+		name, global := setVariableName(stmt.Exprs[i].Name)
+		val := stmt.Exprs[i].Expr
+		if global {
+			return mysql.NewError(mysql.ER_KS_SET_DENIED, "set global variables is not allowed")
+		}
+		if c.proxy.isSetDenied(name) {
+			return mysql.NewError(mysql.ER_KS_SET_DENIED, fmt.Sprintf("set %s is not allowed", name))
+		}
+
+		switch name {
+		case "autocommit":
+			autocommit = val
+			continue
+		case "names":
 			//SET NAMES 'charset_name' COLLATE 'collation_name'
-			return c.handleSetNames(stmt.Exprs[0].Expr, stmt.Exprs[1].Expr)
+			charset, collate = val, nil
+			if i+1 < len(stmt.Exprs) && strings.EqualFold(string(stmt.Exprs[i+1].Name.Name), "collate") {
+				collate = stmt.Exprs[i+1].Expr
+				i++
+			}
+			continue
+		case "character_set_results", "character_set_client", "character_set_connection":
+			charset, collate = val, nil
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, "@"), c.proxy.isSessionVariable(name):
+			vars[name] = val
+		case name == strings.ToLower(TenantVariable), name == strings.ToLower(ShardPinVariable),
+			name == strings.ToLower(NodePinVariable), name == strings.ToLower(DryRunVariable):
+			return fmt.Errorf("set %s must be alone", name)
+		default:
+			err := fmt.Errorf("set %s not support now", name)
+			return c.handleUnsupport(UnsupportSet, sql, UnsupportForward, err, func() error {
+				return c.executeInDefaultNode(sql, false)
+			})
+		}
+	}
+
+	if autocommit != nil {
+		if err := c.setAutoCommit(autocommit); err != nil {
+			return err
+		}
+	}
+	if charset != nil {
+		if err := c.setNames(charset, collate); err != nil {
+			return err
 		}
-		return c.handleSetNames(stmt.Exprs[0].Expr, nil)
-	default:
-		golog.Error("ClientConn", "handleSet", "command not supported",
-			c.connectionId, "sql", sql)
-		return c.writeOK(nil)
 	}
+	for name, val := range vars {
+		if isDefaultValue(name, val) {
+			delete(c.sessionVars, name)
+			continue
+		}
+		if c.sessionVars == nil {
+			c.sessionVars = make(map[string]string)
+		}
+		c.sessionVars[name] = sqlparser.String(val)
+	}
+	return c.writeOK(nil)
+}
+
+//the sql_select_limit of session, false if it is not set
+func (c *ClientConn) selectLimit() (int, bool) {
+	limit, err := strconv.Atoi(c.sessionVars["sql_select_limit"])
+	return limit, err == nil && 0 <= limit
 }
 
-func (c *ClientConn) handleSetAutoCommit(val sqlparser.ValExpr) error {
+func (c *ClientConn) setAutoCommit(val sqlparser.ValExpr) error {
+	if err := c.checkNoXA(); err != nil {
+		return err
+	}
 	flag := sqlparser.String(val)
 	flag = strings.Trim(flag, "'`\"")
 	// autocommit允许为 0, 1, ON, OFF, "ON", "OFF", 不允许"0", "1"
@@ -105,11 +257,10 @@ func (c *ClientConn) handleSetAutoCommit(val sqlparser.ValExpr) error {
 	default:
 		return fmt.Errorf("invalid autocommit flag %s", flag)
 	}
-
-	return c.writeOK(nil)
+	return nil
 }
 
-func (c *ClientConn) handleSetNames(ch, ci sqlparser.ValExpr) error {
+func (c *ClientConn) setNames(ch, ci sqlparser.ValExpr) error {
 	var cid mysql.CollationId
 	var ok bool
 
@@ -118,11 +269,11 @@ func (c *ClientConn) handleSetNames(ch, ci sqlparser.ValExpr) error {
 
 	charset := strings.ToLower(value)
 	if charset == "null" {
-		return c.writeOK(nil)
+		return nil
 	}
 	if ci == nil {
 		if charset == "default" {
-			charset = mysql.DEFAULT_CHARSET
+			charset = c.proxy.charset
 		}
 		cid, ok = mysql.CharsetIds[charset]
 		if !ok {
@@ -139,6 +290,5 @@ func (c *ClientConn) handleSetNames(ch, ci sqlparser.ValExpr) error {
 	}
 	c.charset = charset
 	c.collation = cid
-
-	return c.writeOK(nil)
+	return nil
 }
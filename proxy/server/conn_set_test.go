@@ -0,0 +1,102 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestSetVariables(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+session_variables :
+    deny : [sql_log_bin]
+`)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`from t`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}, {2}},
+		})
+	}
+
+	//the session variables are set in the backend conn, and reset when
+	//the conn is put back to pool
+	if _, err := c.Execute("set session time_zone = '+08:00', @a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("select * from t where id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	queries := backends[0].Queries()
+	if !hasQuery(queries, "SET @a = 1, time_zone = '+08:00'") ||
+		!hasQuery(queries, "SET @a = NULL, time_zone = DEFAULT") {
+		t.Fatal(queries)
+	}
+	if _, err := c.Execute("set time_zone = default, @a = null"); err != nil {
+		t.Fatal(err)
+	}
+	backends[0].ClearQueries()
+	if _, err := c.Execute("select * from t where id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if queries = backends[0].Queries(); hasQuery(queries, "SET") {
+		t.Fatal(queries)
+	}
+
+	for _, sql := range []string{
+		"set global sql_mode = ''",
+		"set @@global.sql_mode = ''",
+		"set sql_log_bin = 0",
+	} {
+		_, err := c.Execute(sql)
+		expectSqlError(t, err, mysql.ER_KS_SET_DENIED, "is not allowed")
+	}
+
+	//the unknown variables are sent to the default node
+	if _, err := c.Execute("set innodb_strict_mode = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if queries = backends[0].Queries(); !hasQuery(queries, "set innodb_strict_mode = 0") {
+		t.Fatal(queries)
+	}
+
+	if _, err := c.Execute("set autocommit = 0, character_set_client = utf8"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Execute("select @@autocommit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.GetInt(0, 0); n != 0 {
+		t.Fatal(n)
+	}
+	if _, err = c.Execute("set autocommit = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the merged result of nodes is limited by sql_select_limit
+	if _, err = c.Execute("set sql_select_limit = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = c.Execute("select id from t"); err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 3 {
+		t.Fatal(r.RowNumber())
+	}
+}
@@ -21,9 +21,11 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/flike/kingshard/backend"
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
 	"github.com/flike/kingshard/sqlparser"
 )
 
@@ -64,17 +66,26 @@ func (c *ClientConn) handleStmtPrepare(sql string) error {
 
 	sql = strings.TrimRight(sql, ";")
 
-	var err error
+	sql, err := c.rewriteTenantSql(sql)
+	if err != nil {
+		return err
+	}
 	s.s, err = sqlparser.Parse(sql)
 	if err != nil {
-		return fmt.Errorf(`parse sql "%s" error`, sql)
+		c.proxy.parseFails.Record(sql, err)
+		//the stmt is executed in the master of default node without parse
+		policy := c.state.cfg.ParseFailPolicy
+		if policy == "" || policy == ParseFailReject {
+			return fmt.Errorf(`parse sql "%s" error`, sql)
+		}
 	}
 
 	s.sql = sql
 
-	defaultRule := c.schema.rule.DefaultRule
-
-	n := c.proxy.GetNode(defaultRule.Nodes[0])
+	n, err := c.getStmtNode(sql)
+	if err != nil {
+		return err
+	}
 
 	co, err := n.GetMasterConn()
 	defer c.closeConn(co, false)
@@ -89,7 +100,7 @@ func (c *ClientConn) handleStmtPrepare(sql string) error {
 		return fmt.Errorf("prepare error %s", err)
 	}
 
-	t, err := co.Prepare(sql)
+	t, err := co.Prepare(router.RewriteSchemas(sql, co.SchemaRewrite()))
 	if err != nil {
 		return fmt.Errorf("prepare error %s", err)
 	}
@@ -147,7 +158,7 @@ func (c *ClientConn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		total, err = c.writeEOFBatch(total, c.status, false)
+		total, err = c.writeFieldsEOFBatch(total, c.status)
 		if err != nil {
 			return err
 		}
@@ -164,7 +175,7 @@ func (c *ClientConn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		total, err = c.writeEOFBatch(total, c.status, false)
+		total, err = c.writeFieldsEOFBatch(total, c.status)
 		if err != nil {
 			return err
 		}
@@ -178,10 +189,24 @@ func (c *ClientConn) writePrepare(s *Stmt) error {
 	return nil
 }
 
+//the sql of the stmt executed by data, empty if the stmt does not exist
+func (c *ClientConn) stmtSql(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	if s, ok := c.stmts[binary.LittleEndian.Uint32(data[0:4])]; ok {
+		return s.sql
+	}
+	return ""
+}
+
 func (c *ClientConn) handleStmtExecute(data []byte) error {
 	if len(data) < 9 {
 		return mysql.ErrMalformPacket
 	}
+	if err := c.checkXAStatement(); err != nil {
+		return err
+	}
 
 	pos := 0
 	id := binary.LittleEndian.Uint32(data[0:4])
@@ -195,9 +220,15 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 
 	flag := data[pos]
 	pos++
+	//the query attributes follow the params, see query_attrs.go
+	withAttrs := c.capability&mysql.CLIENT_QUERY_ATTRIBUTES != 0
+	countAvailable := withAttrs && flag&mysql.PARAMETER_COUNT_AVAILABLE != 0
+	if withAttrs {
+		flag &^= mysql.PARAMETER_COUNT_AVAILABLE
+	}
 	//now we only support CURSOR_TYPE_NO_CURSOR flag
 	if flag != 0 {
-		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("unsupported flag %d", flag))
+		return mysql.NewError(mysql.ER_KS_CMD_UNSUPPORT, fmt.Sprintf("unsupported flag %d", flag))
 	}
 
 	//skip iteration-count, always 1
@@ -208,9 +239,20 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 	var paramValues []byte
 
 	paramNum := s.params
+	if countAvailable {
+		count, n, err := mysql.ReadLengthEncodedInt(data, pos)
+		if err != nil {
+			return err
+		}
+		if count < uint64(s.params) || uint64(len(data)) < count {
+			return mysql.ErrMalformPacket
+		}
+		paramNum = int(count)
+		pos += n
+	}
 
 	if paramNum > 0 {
-		nullBitmapLen := (s.params + 7) >> 3
+		nullBitmapLen := (paramNum + 7) >> 3
 		if len(data) < (pos + nullBitmapLen + 1) {
 			return mysql.ErrMalformPacket
 		}
@@ -220,14 +262,17 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 		//new param bound flag
 		if data[pos] == 1 {
 			pos++
-			if len(data) < (pos + (paramNum << 1)) {
-				return mysql.ErrMalformPacket
+			params, _, err := mysql.ReadParams(data[pos:], paramNum, nullBitmaps, withAttrs)
+			if err != nil {
+				return err
+			}
+			for _, p := range params[:s.params] {
+				paramTypes = append(paramTypes, p.Type, p.Flag)
+				paramValues = append(paramValues, p.Value...)
+			}
+			if err = c.setQueryAttributes(params[s.params:]); err != nil {
+				return err
 			}
-
-			paramTypes = data[pos : pos+(paramNum<<1)]
-			pos += (paramNum << 1)
-
-			paramValues = data[pos:]
 		}
 
 		if err := c.bindStmtArgs(s, nullBitmaps, paramTypes, paramValues); err != nil {
@@ -235,8 +280,10 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 		}
 	}
 
-	var err error
-
+	err := c.startReadAfterWrite(s.sql)
+	if err != nil {
+		return err
+	}
 	switch stmt := s.s.(type) {
 	case *sqlparser.Select:
 		err = c.handlePrepareSelect(stmt, s.sql, s.args)
@@ -248,8 +295,15 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 		err = c.handlePrepareExec(s.s, s.sql, s.args)
 	case *sqlparser.Replace:
 		err = c.handlePrepareExec(s.s, s.sql, s.args)
+	case *sqlparser.With:
+		err = c.handlePrepareExec(s.s, s.sql, s.args)
+	case nil:
+		err = c.handlePrepareExec(s.s, s.sql, s.args)
 	default:
 		err = fmt.Errorf("command %T not supported now", stmt)
+		err = c.handleUnsupport(UnsupportPrepare, s.sql, UnsupportReject, err, func() error {
+			return c.handlePrepareExec(s.s, s.sql, s.args)
+		})
 	}
 
 	s.ResetParams()
@@ -257,15 +311,31 @@ func (c *ClientConn) handleStmtExecute(data []byte) error {
 	return err
 }
 
-func (c *ClientConn) handlePrepareSelect(stmt *sqlparser.Select, sql string, args []interface{}) error {
+//the prepared statements are executed in the node of the single table in
+//sql if any, or in the default node
+func (c *ClientConn) getStmtNode(sql string) (*backend.Node, error) {
+	tokens := strings.Fields(sql)
+	if nodeName := c.singleTableNode(tokens, len(tokens)); len(nodeName) != 0 {
+		return c.schema.nodes[nodeName], nil
+	}
 	defaultRule := c.schema.rule.DefaultRule
 	if len(defaultRule.Nodes) == 0 {
-		return errors.ErrNoDefaultNode
+		return nil, errors.ErrNoDefaultNode
+	}
+	return c.getNode(defaultRule.Nodes[0]), nil
+}
+
+func (c *ClientConn) handlePrepareSelect(stmt *sqlparser.Select, sql string, args []interface{}) error {
+	if err := c.checkRowFilterRaw(sql); err != nil {
+		return err
+	}
+	n, err := c.getStmtNode(sql)
+	if err != nil {
+		return err
 	}
-	defaultNode := c.proxy.GetNode(defaultRule.Nodes[0])
 
 	//choose connection in slave DB first
-	conn, err := c.getBackendConn(defaultNode, true)
+	conn, err := c.getBackendConn(n, true)
 	defer c.closeConn(conn, false)
 	if err != nil {
 		return err
@@ -283,7 +353,10 @@ func (c *ClientConn) handlePrepareSelect(stmt *sqlparser.Select, sql string, arg
 		return err
 	}
 
-	status := c.status | rs[0].Status
+	if err = c.checkMaskedPrepare(stmt, rs[0].Resultset); err != nil {
+		return err
+	}
+	status := c.resultStatus(rs[0])
 	if rs[0].Resultset != nil {
 		err = c.writeResultset(status, rs[0].Resultset)
 	} else {
@@ -295,14 +368,16 @@ func (c *ClientConn) handlePrepareSelect(stmt *sqlparser.Select, sql string, arg
 }
 
 func (c *ClientConn) handlePrepareExec(stmt sqlparser.Statement, sql string, args []interface{}) error {
-	defaultRule := c.schema.rule.DefaultRule
-	if len(defaultRule.Nodes) == 0 {
-		return errors.ErrNoDefaultNode
+	if err := c.checkRowFilterRaw(sql); err != nil {
+		return err
+	}
+	n, err := c.getStmtNode(sql)
+	if err != nil {
+		return err
 	}
-	defaultNode := c.proxy.GetNode(defaultRule.Nodes[0])
 
 	//execute in Master DB
-	conn, err := c.getBackendConn(defaultNode, false)
+	conn, err := c.getBackendConn(n, false)
 	defer c.closeConn(conn, false)
 	if err != nil {
 		return err
@@ -320,11 +395,15 @@ func (c *ClientConn) handlePrepareExec(stmt sqlparser.Statement, sql string, arg
 		golog.Error("ClientConn", "handlePrepareExec", err.Error(), c.connectionId)
 		return err
 	}
+	if rs[0].Resultset == nil {
+		c.markWrite()
+	}
 
-	status := c.status | rs[0].Status
+	status := c.resultStatus(rs[0])
 	if rs[0].Resultset != nil {
 		err = c.writeResultset(status, rs[0].Resultset)
 	} else {
+		c.recordExecResult(rs[0])
 		err = c.writeOK(rs[0])
 	}
 
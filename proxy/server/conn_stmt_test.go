@@ -20,7 +20,7 @@ import (
 
 func TestStmt_DropTable(t *testing.T) {
 	server := newTestServer(t)
-	n := server.nodes["node1"]
+	n := server.getState().nodes["node1"]
 	c, err := n.GetMasterConn()
 	if err != nil {
 		t.Fatal(err)
@@ -44,7 +44,7 @@ func TestStmt_CreateTable(t *testing.T) {
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8`
 
 	server := newTestServer(t)
-	n := server.nodes["node1"]
+	n := server.getState().nodes["node1"]
 	c, err := n.GetMasterConn()
 	if err != nil {
 		t.Fatal(err)
@@ -32,7 +32,7 @@ func TestConn_Handshake(t *testing.T) {
 
 func TestConn_DeleteTable(t *testing.T) {
 	server := newTestServer(t)
-	n := server.nodes["node1"]
+	n := server.getState().nodes["node1"]
 	c, err := n.GetMasterConn()
 	if err != nil {
 		t.Fatal(err)
@@ -58,7 +58,7 @@ func TestConn_CreateTable(t *testing.T) {
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8`
 
 	server := newTestServer(t)
-	n := server.nodes["node1"]
+	n := server.getState().nodes["node1"]
 	c, err := n.GetMasterConn()
 	if err != nil {
 		t.Fatal(err)
@@ -298,7 +298,7 @@ func TestConn_LastInsertId(t *testing.T) {
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8`
 
 	server := newTestServer(t)
-	n := server.nodes["node1"]
+	n := server.getState().nodes["node1"]
 
 	c1, err := n.GetMasterConn()
 	if err != nil {
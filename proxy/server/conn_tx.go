@@ -15,10 +15,84 @@
 package server
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/flike/kingshard/backend"
 	"github.com/flike/kingshard/mysql"
 )
 
+//TxState is the transaction state of a client conn. A transaction is bound
+//to the node of its first statement, the statements on other nodes are
+//rejected until it ends.
+type TxState int32
+
+const (
+	TxAutoCommit TxState = iota //each statement is committed by itself
+	TxImplicit                  //autocommit is off, the statements are in a transaction until commit
+	TxExplicit                  //begin or start transaction
+	TxXAActive                  //xa start, the statements are in the xa branch
+	TxXAIdle                    //xa end, only xa prepare, commit one phase and rollback are allowed
+	TxXAPrepared                //xa prepare, only xa commit and rollback are allowed
+)
+
+var txStateNames = []string{"autocommit", "implicit", "explicit", "xa_active", "xa_idle", "xa_prepared"}
+
+func (s TxState) String() string {
+	if s < 0 || int(s) >= len(txStateNames) {
+		return fmt.Sprintf("TxState(%d)", s)
+	}
+	return txStateNames[s]
+}
+
+func (c *ClientConn) txState() TxState {
+	switch {
+	case c.xa != TxAutoCommit:
+		return c.xa
+	case c.status&mysql.SERVER_STATUS_IN_TRANS > 0:
+		return TxExplicit
+	case !c.isAutoCommit():
+		return TxImplicit
+	}
+	return TxAutoCommit
+}
+
+//the node which the transaction is bound to, nil if none
+func (c *ClientConn) txNode() *backend.Node {
+	for n := range c.txConns {
+		return n
+	}
+	return nil
+}
+
+//check the nodes of a statement in transaction, it is rejected if it is
+//sent to more than one node, or to a node other than the bound one
+func (c *ClientConn) checkTxNodes(nodes ...*backend.Node) error {
+	if !c.isInTransaction() || len(nodes) == 0 {
+		return nil
+	}
+	if 1 < len(nodes) {
+		names := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			names = append(names, n.Cfg.Name)
+		}
+		sort.Strings(names)
+		return c.rejectTx(fmt.Sprintf("transaction in multi node, the %s transaction can not run a statement on nodes %s",
+			c.txState(), strings.Join(names, ",")))
+	}
+	if bound := c.txNode(); bound != nil && bound != nodes[0] {
+		return c.rejectTx(fmt.Sprintf("transaction in multi node, the %s transaction is bound to node %s, the statement is on node %s",
+			c.txState(), bound.Cfg.Name, nodes[0].Cfg.Name))
+	}
+	return nil
+}
+
+func (c *ClientConn) rejectTx(msg string) error {
+	c.proxy.counter.IncrTxRejects()
+	return mysql.NewError(mysql.ER_KS_TRANS_IN_MULTI, msg)
+}
+
 func (c *ClientConn) isInTransaction() bool {
 	return c.status&mysql.SERVER_STATUS_IN_TRANS > 0 ||
 		!c.isAutoCommit()
@@ -29,6 +103,9 @@ func (c *ClientConn) isAutoCommit() bool {
 }
 
 func (c *ClientConn) handleBegin() error {
+	if err := c.checkNoXA(); err != nil {
+		return err
+	}
 	for _, co := range c.txConns {
 		if err := co.Begin(); err != nil {
 			return err
@@ -39,6 +116,9 @@ func (c *ClientConn) handleBegin() error {
 }
 
 func (c *ClientConn) handleCommit() (err error) {
+	if err := c.checkNoXA(); err != nil {
+		return err
+	}
 	if err := c.commit(); err != nil {
 		return err
 	} else {
@@ -47,6 +127,9 @@ func (c *ClientConn) handleCommit() (err error) {
 }
 
 func (c *ClientConn) handleRollback() (err error) {
+	if err := c.checkNoXA(); err != nil {
+		return err
+	}
 	if err := c.rollback(); err != nil {
 		return err
 	} else {
@@ -56,6 +139,10 @@ func (c *ClientConn) handleRollback() (err error) {
 
 func (c *ClientConn) commit() (err error) {
 	c.status &= ^mysql.SERVER_STATUS_IN_TRANS
+	//the writes of the transaction are visible from now on
+	if len(c.txConns) != 0 {
+		c.markWrite()
+	}
 
 	for _, co := range c.txConns {
 		if e := co.Commit(); e != nil {
@@ -69,6 +156,9 @@ func (c *ClientConn) commit() (err error) {
 }
 
 func (c *ClientConn) rollback() (err error) {
+	if c.xa != TxAutoCommit {
+		return c.rollbackXA()
+	}
 	c.status &= ^mysql.SERVER_STATUS_IN_TRANS
 
 	for _, co := range c.txConns {
@@ -0,0 +1,146 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func expectSqlError(t *testing.T, err error, code uint16, msg string) {
+	e, ok := err.(*mysql.SqlError)
+	if !ok || e.Code != code || !strings.Contains(e.Message, msg) {
+		t.Fatalf("expect error %d %s, got %v", code, msg, err)
+	}
+}
+
+func TestTxNodes(t *testing.T) {
+	s, _, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (2)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Execute("insert into t(id) values (3)")
+	expectSqlError(t, err, mysql.ER_KS_TRANS_IN_MULTI,
+		"the explicit transaction is bound to node node1, the statement is on node node2")
+	_, err = c.Execute("select * from t where id in (2, 3)")
+	expectSqlError(t, err, mysql.ER_KS_TRANS_IN_MULTI, "can not run a statement on nodes node1,node2")
+
+	//the conn in transaction is shown by show processlist of other conns
+	c2 := new(backend.Conn)
+	if err := c2.Connect(s.Addr().String(), "root", "", "kingshard"); err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	r, err := c2.Execute("show processlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 2 {
+		t.Fatal(r.RowNumber())
+	}
+	tx, _ := r.GetString(0, 8)
+	txNode, _ := r.GetString(0, 9)
+	command, _ := r.GetString(1, 4)
+	if tx != "explicit" || txNode != "node1" || command != "Query" {
+		t.Fatal(tx, txNode, command)
+	}
+
+	stats := make(map[string]string)
+	for _, stat := range s.DebugStats() {
+		stats[stat.Name] = stat.Value
+	}
+	if stats["ClientConns.explicit"] != "1" || stats["ClientConns.autocommit"] != "1" || stats["TxRejects"] != "2" {
+		t.Fatal(stats)
+	}
+
+	if _, err := c.Execute("rollback"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXA(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	if _, err := c.Execute("xa start 'x1'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Execute("begin")
+	expectSqlError(t, err, mysql.ER_XAER_RMFAIL, "ACTIVE")
+	_, err = c.Execute("xa end 'x2'")
+	expectSqlError(t, err, mysql.ER_XAER_NOTA, "Unknown XID")
+	_, err = c.Execute("xa prepare 'x1'")
+	expectSqlError(t, err, mysql.ER_XAER_RMFAIL, "ACTIVE")
+
+	if _, err := c.Execute("xa end 'x1'"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Execute("select * from t where id = 3")
+	expectSqlError(t, err, mysql.ER_XAER_RMFAIL, "IDLE")
+	if _, err := c.Execute("xa prepare 'x1'"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Execute("commit")
+	expectSqlError(t, err, mysql.ER_XAER_RMFAIL, "PREPARED")
+	if _, err := c.Execute("xa commit 'x1'"); err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{"xa start 'x1'", "xa end 'x1'", "xa prepare 'x1'", "xa commit 'x1'"} {
+		if !hasQuery(backends[1].Queries(), sql) || hasQuery(backends[0].Queries(), sql) {
+			t.Fatal(sql, backends[1].Queries())
+		}
+	}
+
+	//a branch prepared by other session is committed in the node knowing it
+	backends[0].Handle(`xa commit 'x2'`, &mysqltest.Response{Err: mysql.NewDefaultError(mysql.ER_XAER_NOTA)})
+	if _, err := c.Execute("xa commit 'x2'"); err != nil {
+		t.Fatal(err)
+	}
+	backends[1].Handle(`xa commit 'x2'`, &mysqltest.Response{Err: mysql.NewDefaultError(mysql.ER_XAER_NOTA)})
+	_, err = c.Execute("xa commit 'x2'")
+	expectSqlError(t, err, mysql.ER_XAER_NOTA, "Unknown XID")
+
+	//xa start is not allowed in a transaction
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Execute("xa start 'x3'")
+	expectSqlError(t, err, mysql.ER_XAER_OUTSIDE, "outside")
+}
+
+func TestParseXid(t *testing.T) {
+	xid, opts := parseXid(strings.Fields("'a', 'b', 1 one phase"))
+	if xid != "'a', 'b', 1" || len(opts) != 2 || opts[0] != "one" {
+		t.Fatal(xid, opts)
+	}
+	if !sameXid("'a','b'", "'a', 'b'") {
+		t.Fatal("the spaces are ignored")
+	}
+}
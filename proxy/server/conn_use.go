@@ -34,7 +34,7 @@ func (c *ClientConn) handleUseDB(dbName string) error {
 
 	nodeName := c.schema.rule.DefaultRule.Nodes[0]
 
-	n := c.proxy.GetNode(nodeName)
+	n := c.getNode(nodeName)
 	//get the connection from slave preferentially
 	co, err = n.GetSlaveConn()
 	if err != nil {
@@ -51,5 +51,5 @@ func (c *ClientConn) handleUseDB(dbName string) error {
 		return err
 	}
 	c.db = dbName
-	return c.writeOK(nil)
+	return c.writeOKState(nil, schemaStateChange(dbName))
 }
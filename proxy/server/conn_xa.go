@@ -0,0 +1,264 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the names of xa states in the errors of mysql
+var xaStateNames = map[TxState]string{
+	TxAutoCommit: "NON-EXISTING",
+	TxXAActive:   "ACTIVE",
+	TxXAIdle:     "IDLE",
+	TxXAPrepared: "PREPARED",
+}
+
+//the options after the xid of xa statements
+var xaOptions = map[string]bool{
+	"join": true, "resume": true, "suspend": true, "for": true,
+	"migrate": true, "one": true, "phase": true,
+}
+
+func isXAStatement(sql string) bool {
+	tokens := strings.Fields(sql)
+	return 0 < len(tokens) && strings.ToLower(tokens[0]) == "xa"
+}
+
+//the xid and options of xa statement, such as 'gtrid','bqual' and one phase
+func parseXid(tokens []string) (string, []string) {
+	i := len(tokens)
+	for 0 < i && xaOptions[strings.ToLower(tokens[i-1])] {
+		i--
+	}
+	opts := make([]string, 0, len(tokens)-i)
+	for _, opt := range tokens[i:] {
+		opts = append(opts, strings.ToLower(opt))
+	}
+	return strings.Join(tokens[:i], " "), opts
+}
+
+//the xids are compared without the spaces
+func sameXid(a string, b string) bool {
+	return strings.Replace(a, " ", "", -1) == strings.Replace(b, " ", "", -1)
+}
+
+func (c *ClientConn) xaStateError() error {
+	c.proxy.counter.IncrTxRejects()
+	return mysql.NewDefaultError(mysql.ER_XAER_RMFAIL, xaStateNames[c.xa])
+}
+
+//begin, commit, rollback and set autocommit are not allowed in xa
+func (c *ClientConn) checkNoXA() error {
+	if c.xa != TxAutoCommit {
+		return c.xaStateError()
+	}
+	return nil
+}
+
+//the statements other than xa are not allowed after xa end
+func (c *ClientConn) checkXAStatement() error {
+	if c.xa == TxXAIdle || c.xa == TxXAPrepared {
+		return c.xaStateError()
+	}
+	return nil
+}
+
+//handle the xa statements. The xa branch is bound to the node of its first
+//statement like a transaction, xa start is sent to the node before the
+//statement, and the other xa statements are sent to it.
+func (c *ClientConn) handleXA(sql string) error {
+	tokens := strings.Fields(sql)
+	if len(tokens) < 2 {
+		return mysql.NewDefaultError(mysql.ER_XAER_INVAL)
+	}
+	verb := strings.ToLower(tokens[1])
+	if verb == "recover" {
+		return c.handleXARecover(sql)
+	}
+	xid, opts := parseXid(tokens[2:])
+	if len(xid) == 0 {
+		return mysql.NewDefaultError(mysql.ER_XAER_INVAL)
+	}
+	if c.xa != TxAutoCommit && !sameXid(xid, c.xid) {
+		return mysql.NewDefaultError(mysql.ER_XAER_NOTA)
+	}
+
+	var err error
+	switch verb {
+	case "start", "begin":
+		if c.xa != TxAutoCommit {
+			return c.xaStateError()
+		}
+		if c.status&mysql.SERVER_STATUS_IN_TRANS > 0 || len(c.txConns) != 0 {
+			c.proxy.counter.IncrTxRejects()
+			return mysql.NewDefaultError(mysql.ER_XAER_OUTSIDE)
+		}
+		c.xa = TxXAActive
+		c.xid = xid
+		c.status |= mysql.SERVER_STATUS_IN_TRANS
+	case "end":
+		if c.xa != TxXAActive {
+			return c.xaStateError()
+		}
+		if err = c.sendXA("xa end " + c.xid); err == nil {
+			c.xa = TxXAIdle
+		}
+	case "prepare":
+		if c.xa != TxXAIdle {
+			return c.xaStateError()
+		}
+		if err = c.sendXA("xa prepare " + c.xid); err == nil {
+			c.xa = TxXAPrepared
+		}
+	case "commit":
+		onePhase := 0 < len(opts) && opts[0] == "one"
+		switch {
+		case c.xa == TxAutoCommit:
+			return c.recoverXA("xa commit " + xid)
+		case onePhase && c.xa != TxXAIdle, !onePhase && c.xa != TxXAPrepared:
+			return c.xaStateError()
+		}
+		if onePhase {
+			err = c.sendXA("xa commit " + c.xid + " one phase")
+		} else {
+			err = c.sendXA("xa commit " + c.xid)
+		}
+		c.endXA()
+	case "rollback":
+		switch c.xa {
+		case TxAutoCommit:
+			return c.recoverXA("xa rollback " + xid)
+		case TxXAActive:
+			return c.xaStateError()
+		}
+		err = c.sendXA("xa rollback " + c.xid)
+		c.endXA()
+	default:
+		return mysql.NewDefaultError(mysql.ER_XAER_INVAL)
+	}
+	if err != nil {
+		return err
+	}
+	return c.writeOK(nil)
+}
+
+//send the xa statement to the node of the xa branch, nothing is sent if
+//the branch has no statement
+func (c *ClientConn) sendXA(sql string) error {
+	for _, co := range c.txConns {
+		if _, err := co.ExecuteContext(c.ctx, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//release the conn of the xa branch, the state is ended even if the xa
+//commit or rollback fails, the branch prepared can be committed or rolled
+//back by its xid later
+func (c *ClientConn) endXA() {
+	for _, co := range c.txConns {
+		co.Close()
+	}
+	c.txConns = make(map[*backend.Node]*backend.BackendConn)
+	c.xa = TxAutoCommit
+	c.xid = ""
+	c.status &= ^mysql.SERVER_STATUS_IN_TRANS
+}
+
+//rollback the xa branch when the client quits
+func (c *ClientConn) rollbackXA() error {
+	var err error
+	if c.xa == TxXAActive {
+		err = c.sendXA("xa end " + c.xid)
+	}
+	if err == nil {
+		err = c.sendXA("xa rollback " + c.xid)
+	}
+	c.endXA()
+	return err
+}
+
+func (c *ClientConn) sortedNodes() []*backend.Node {
+	names := make([]string, 0, len(c.schema.nodes))
+	for name := range c.schema.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nodes := make([]*backend.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, c.schema.nodes[name])
+	}
+	return nodes
+}
+
+//xa commit or rollback of a prepared branch out of its session, such as
+//the branch of a client crashed. The node of the branch is unknown, so it
+//is sent to all the nodes, and fails only if no node knows the xid.
+func (c *ClientConn) recoverXA(sql string) error {
+	//the error of a node other than XAER_NOTA is returned if any
+	var lastErr error = mysql.NewDefaultError(mysql.ER_XAER_NOTA)
+	for _, n := range c.sortedNodes() {
+		co, err := n.GetMasterConn()
+		if err == nil {
+			_, err = co.ExecuteContext(c.ctx, sql)
+			co.Close()
+			if err == nil {
+				return c.writeOK(nil)
+			}
+		}
+		if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_XAER_NOTA {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//the prepared branches of all the nodes
+func (c *ClientConn) handleXARecover(sql string) error {
+	var result *mysql.Resultset
+	for _, n := range c.sortedNodes() {
+		co, err := n.GetMasterConn()
+		if err != nil {
+			return err
+		}
+		r, err := co.ExecuteContext(c.ctx, sql)
+		co.Close()
+		if err != nil {
+			golog.Error("ClientConn", "handleXARecover", err.Error(), c.connectionId,
+				"node", n.Cfg.Name)
+			return err
+		}
+		if r.Resultset == nil {
+			continue
+		}
+		if result == nil {
+			result = r.Resultset
+			continue
+		}
+		result.Values = append(result.Values, r.Values...)
+		result.RowDatas = append(result.RowDatas, r.RowDatas...)
+	}
+	if result == nil {
+		return c.writeOK(nil)
+	}
+	return c.writeResultset(c.status, result)
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the reads of a statement with the hint go to a slave whose lag is within
+//the duration, as ks_consistency bounded(500ms)
+const BoundedCommentPrefix = "/*bounded("
+
+//the consistency of the reads of a statement: strong reads from the
+//master, eventual reads from any slave, and bounded reads from a slave
+//whose lag measured by the heartbeat is within maxLag, or from the master
+//if none
+type readConsistency struct {
+	strong bool
+	maxLag time.Duration //0 if not bounded
+}
+
+//parse strong, eventual or bounded(500ms)
+func parseConsistency(value string) (readConsistency, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	switch v {
+	case StrongConsistency:
+		return readConsistency{strong: true}, nil
+	case EventualConsistency:
+		return readConsistency{}, nil
+	}
+	if strings.HasPrefix(v, BoundedConsistency+"(") && strings.HasSuffix(v, ")") {
+		lag, err := time.ParseDuration(v[len(BoundedConsistency)+1 : len(v)-1])
+		if err == nil && 0 < lag {
+			return readConsistency{maxLag: lag}, nil
+		}
+	}
+	return readConsistency{}, fmt.Errorf("invalid consistency %s", value)
+}
+
+func checkConsistencies(cfg *config.Config) error {
+	var values []string
+	for _, u := range cfg.Users {
+		values = append(values, u.Consistency)
+	}
+	for _, shard := range cfg.Schema.ShardRule {
+		values = append(values, shard.Consistency)
+	}
+	for _, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		if _, err := parseConsistency(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//the default consistency of the reads of user, eventual if not set
+func (s *Server) userConsistency(user string) readConsistency {
+	for _, u := range s.getState().cfg.Users {
+		if u.User == user && len(u.Consistency) != 0 {
+			//checked by checkConsistencies
+			consistency, _ := parseConsistency(u.Consistency)
+			return consistency
+		}
+	}
+	return readConsistency{}
+}
+
+//the /*slave*/ and /*bounded(500ms)*/ hints of sql override the read after
+//write window and the defaults. The /*master*/ hint of a select is checked
+//in handleSelect.
+func (c *ClientConn) applyConsistencyHint(sql string) error {
+	if strings.Contains(sql, SlaveComment) {
+		c.consistency, c.defaultConsistency = readConsistency{}, false
+	}
+	start := strings.Index(sql, BoundedCommentPrefix)
+	if start < 0 {
+		return nil
+	}
+	end := strings.Index(sql[start:], ")*/")
+	if end < 0 {
+		return nil
+	}
+	consistency, err := parseConsistency(sql[start+2 : start+end+1])
+	if err != nil {
+		return err
+	}
+	c.consistency, c.defaultConsistency = consistency, false
+	return nil
+}
+
+//the default consistency of the table overrides the one of user, but not
+//the one set by the statement or the read after write window
+func (c *ClientConn) applyTableConsistency(rule *router.Rule) {
+	if !c.defaultConsistency || rule == nil || len(rule.Consistency) == 0 {
+		return
+	}
+	//checked by checkConsistencies
+	c.consistency, _ = parseConsistency(rule.Consistency)
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+//backends[0] is the master of node1 and backends[1] is its slave
+var consistencyConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+lag_check_interval : 20
+
+users :
+-
+    user : app
+    password :
+    consistency : strong
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+    slave : %s
+
+schema :
+    default : node1
+    nodes : [node1]
+    shard :
+    -
+        db : kingshard
+        table : t_bounded
+        type : single
+        node : node1
+        consistency : bounded(1s)
+`
+
+func TestParseConsistency(t *testing.T) {
+	tests := []struct {
+		value       string
+		consistency readConsistency
+	}{
+		{"strong", readConsistency{strong: true}},
+		{"Eventual", readConsistency{}},
+		{"bounded(500ms)", readConsistency{maxLag: 500 * time.Millisecond}},
+		{" bounded(2s) ", readConsistency{maxLag: 2 * time.Second}},
+	}
+	for _, tt := range tests {
+		consistency, err := parseConsistency(tt.value)
+		if err != nil || consistency != tt.consistency {
+			t.Fatal(tt.value, consistency, err)
+		}
+	}
+	for _, value := range []string{"", "bounded", "bounded()", "bounded(500)", "bounded(-1s)", "weak"} {
+		if _, err := parseConsistency(value); err == nil {
+			t.Fatal(value)
+		}
+	}
+}
+
+func TestConsistencyBounded(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, consistencyConfig)
+	defer close()
+	master, slave := backends[0], backends[1]
+	for _, b := range backends {
+		b.Handle(`^select \*`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+	setHeartbeat := func(written time.Time, lag func(time.Duration) bool) {
+		slave.Handle(`^select heartbeat from kingshard.heartbeat`, &mysqltest.Response{
+			Names: []string{"heartbeat"},
+			Rows:  [][]interface{}{{strconv.FormatInt(written.UnixNano(), 10)}},
+		})
+		db := s.GetNode("node1").Slave[0]
+		for i := 0; !lag(db.Lag()); i++ {
+			if 100 < i {
+				t.Fatal(db.Lag())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	read := func(c *backend.Conn, sql string, consistency string, fromMaster bool) {
+		master.ClearQueries()
+		slave.ClearQueries()
+		var attrs []mysql.QueryAttribute
+		if len(consistency) != 0 {
+			attrs = []mysql.QueryAttribute{stringAttribute(ConsistencyAttribute, consistency)}
+		}
+		c.SetQueryAttributes(attrs)
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+		if hasQuery(master.Queries(), sql) != fromMaster || hasQuery(slave.Queries(), sql) == fromMaster {
+			t.Fatal(sql, consistency, master.Queries(), slave.Queries())
+		}
+	}
+
+	//the slave lags 5s behind the master
+	setHeartbeat(time.Now().Add(-5*time.Second), func(lag time.Duration) bool {
+		return 5*time.Second <= lag
+	})
+	r, err := c.Execute("admin server(opt,k,v) values('show','node','config')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range r.Values {
+		typ, _ := r.GetStringByName(i, "Type")
+		lag, _ := r.GetStringByName(i, "Lag_ms")
+		if ms, _ := strconv.ParseFloat(lag, 64); (typ == "slave") != (5000 <= ms) {
+			t.Fatal(r.Values[i])
+		}
+	}
+	read(c, "select * from u", "", false)
+	read(c, "select /*bounded(1s)*/ * from u", "", true)
+	read(c, "select /*bounded(10s)*/ * from u where id = 1", "", false)
+	read(c, "select * from u where id = 2", "bounded(1s)", true)
+	read(c, "select * from t_bounded", "", true)
+	read(c, "select * from t_bounded where id = 1", EventualConsistency, false)
+	if _, err := c.Execute("select /*bounded(1)*/ * from u"); err == nil {
+		t.Fatal("expect error of invalid hint")
+	}
+
+	//the slave catches up
+	setHeartbeat(time.Now().Add(time.Hour), func(lag time.Duration) bool {
+		return lag == 0
+	})
+	read(c, "select /*bounded(1s)*/ * from u where id = 3", "", false)
+	read(c, "select * from t_bounded where id = 2", "", false)
+
+	//the reads of app are strong by default, except the tables of bounded
+	//and the reads with hints
+	app := new(backend.Conn)
+	if err := app.Connect(s.Addr().String(), "app", "", "kingshard"); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	read(app, "select * from u where id = 4", "", true)
+	read(app, "select /*slave*/ * from u where id = 4", "", false)
+	read(app, "select * from t_bounded where id = 3", "", false)
+}
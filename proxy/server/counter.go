@@ -27,6 +27,22 @@ type Counter struct {
 	ClientQPS    int64
 	ErrLogTotal  int64
 	SlowLogTotal int64
+
+	//the client conns in handshake, and the total conns dropped for
+	//the handshake timeout or too many conns in handshake
+	HandshakeConns int64
+	HandshakeDrops int64
+
+	//the masters switched by the api of failover tools
+	MasterSwitches int64
+
+	//the statements rejected by the transaction state of client conns
+	TxRejects int64
+	//the transactions rolled back for idle_in_tx_timeout
+	IdleTxKills int64
+	//the transactions whose backend conn is lost, and those replayed
+	TxConnLosts int64
+	TxReplays   int64
 }
 
 func (counter *Counter) IncrClientConns() {
@@ -37,6 +53,38 @@ func (counter *Counter) DecrClientConns() {
 	atomic.AddInt64(&counter.ClientConns, -1)
 }
 
+func (counter *Counter) IncrTxRejects() {
+	atomic.AddInt64(&counter.TxRejects, 1)
+}
+
+func (counter *Counter) IncrIdleTxKills() {
+	atomic.AddInt64(&counter.IdleTxKills, 1)
+}
+
+func (counter *Counter) IncrTxConnLosts() {
+	atomic.AddInt64(&counter.TxConnLosts, 1)
+}
+
+func (counter *Counter) IncrTxReplays() {
+	atomic.AddInt64(&counter.TxReplays, 1)
+}
+
+func (counter *Counter) IncrHandshakeConns() int64 {
+	return atomic.AddInt64(&counter.HandshakeConns, 1)
+}
+
+func (counter *Counter) DecrHandshakeConns() {
+	atomic.AddInt64(&counter.HandshakeConns, -1)
+}
+
+func (counter *Counter) IncrHandshakeDrops() {
+	atomic.AddInt64(&counter.HandshakeDrops, 1)
+}
+
+func (counter *Counter) IncrMasterSwitches() {
+	atomic.AddInt64(&counter.MasterSwitches, 1)
+}
+
 func (counter *Counter) IncrClientQPS() {
 	atomic.AddInt64(&counter.ClientQPS, 1)
 }
@@ -51,9 +99,7 @@ func (counter *Counter) IncrSlowLogTotal() {
 
 //flush the count per second
 func (counter *Counter) FlushCounter() {
-	atomic.StoreInt64(&counter.OldClientQPS, counter.ClientQPS)
-	atomic.StoreInt64(&counter.OldErrLogTotal, counter.ErrLogTotal)
-	atomic.StoreInt64(&counter.OldSlowLogTotal, counter.SlowLogTotal)
-
-	atomic.StoreInt64(&counter.ClientQPS, 0)
+	atomic.StoreInt64(&counter.OldClientQPS, atomic.SwapInt64(&counter.ClientQPS, 0))
+	atomic.StoreInt64(&counter.OldErrLogTotal, atomic.LoadInt64(&counter.ErrLogTotal))
+	atomic.StoreInt64(&counter.OldSlowLogTotal, atomic.LoadInt64(&counter.SlowLogTotal))
 }
@@ -0,0 +1,238 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const (
+	DateShardCreate = "create" //the sub table of a coming period is created
+	DateShardDrop   = "drop"   //the sub table out of retain_shards is dropped
+)
+
+//DateShardAction is a sub table of a date rule which is created before
+//its period comes, or dropped out of retain_shards
+type DateShardAction struct {
+	Table    string //db.table
+	SubTable string
+	Node     string
+	Action   string
+	Err      string //the check or the action fails
+
+	db           string
+	template     string //the sub table whose definition is used by create
+	templateNode string
+}
+
+func (a *DateShardAction) String() string {
+	return fmt.Sprintf("%s %s.%s on %s", a.Action, a.db, a.SubTable, a.Node)
+}
+
+func (s *Server) dateShardPrecreate() int {
+	if n := s.getState().cfg.DateShard.Precreate; 0 < n {
+		return n
+	}
+	return 1
+}
+
+//CheckDateShards finds the missing sub tables of every date rule for now
+//and the next periods, and the sub tables before the latest retain_shards
+//ones. The actions are executed if apply is true.
+func (s *Server) CheckDateShards(now time.Time, apply bool) []DateShardAction {
+	schema := s.GetSchema()
+	if schema == nil {
+		return nil
+	}
+
+	var dbs []string
+	for db := range schema.rule.Rules {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	var actions []DateShardAction
+	for _, db := range dbs {
+		var tables []string
+		for table := range schema.rule.Rules[db] {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			rule := schema.rule.Rules[db][table]
+			if !rule.IsDateShard() {
+				continue
+			}
+			for _, a := range s.checkRuleDateShards(schema, rule, now) {
+				if apply && len(a.Err) == 0 {
+					if err := s.applyDateShard(schema, &a); err != nil {
+						a.Err = err.Error()
+					}
+				}
+				actions = append(actions, a)
+			}
+		}
+	}
+	return actions
+}
+
+func (s *Server) checkRuleDateShards(schema *Schema, rule *router.Rule, now time.Time) []DateShardAction {
+	var actions []DateShardAction
+	newAction := func(tableIndex int, action string) DateShardAction {
+		return DateShardAction{
+			Table:    rule.DB + "." + rule.Table,
+			SubTable: fmt.Sprintf("%s_%04d", rule.Table, tableIndex),
+			Node:     rule.Nodes[rule.TableToNode[tableIndex]],
+			Action:   action,
+			db:       rule.DB,
+		}
+	}
+
+	//the sub tables on the blackhole node are never created or dropped
+	var indexs []int
+	for _, tableIndex := range rule.SubTableIndexs {
+		if !rule.IsBlackhole(tableIndex) {
+			indexs = append(indexs, tableIndex)
+		}
+	}
+	sort.Ints(indexs)
+	for i := 0; i <= s.dateShardPrecreate(); i++ {
+		tableIndex, err := rule.PeriodIndex(now, i)
+		if err != nil {
+			continue
+		}
+		if _, ok := rule.TableToNode[tableIndex]; !ok {
+			if i != 0 {
+				golog.Warn("Server", "checkDateShards", "date_range ends", 0,
+					"table", rule.DB+"."+rule.Table, "period", tableIndex)
+			}
+			continue
+		}
+		if rule.IsBlackhole(tableIndex) {
+			continue
+		}
+		a := newAction(tableIndex, DateShardCreate)
+		exists, err := s.subTableExists(schema, a.Node, rule.DB, a.SubTable)
+		if exists {
+			continue
+		}
+		if err != nil {
+			a.Err = err.Error()
+		} else if a.template, a.templateNode = s.dateShardTemplate(schema, rule, indexs, tableIndex); len(a.template) == 0 {
+			a.Err = "no sub table before it"
+		}
+		actions = append(actions, a)
+	}
+
+	if rule.RetainShards == 0 {
+		return actions
+	}
+	current, err := rule.PeriodIndex(now, 0)
+	if err != nil {
+		return actions
+	}
+	var past []int
+	for _, tableIndex := range indexs {
+		if tableIndex <= current {
+			past = append(past, tableIndex)
+		}
+	}
+	for i := 0; i < len(past)-rule.RetainShards; i++ {
+		a := newAction(past[i], DateShardDrop)
+		exists, err := s.subTableExists(schema, a.Node, rule.DB, a.SubTable)
+		if !exists && err == nil {
+			continue
+		}
+		if err != nil {
+			a.Err = err.Error()
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+//return false if the table is missing, or an error if the check fails
+func (s *Server) subTableExists(schema *Schema, nodeName string, db string, table string) (bool, error) {
+	_, err := s.showCreateTable(schema, nodeName, db, table)
+	if err == nil {
+		return true, nil
+	}
+	if e, ok := err.(*mysql.SqlError); ok && e.Code == mysql.ER_NO_SUCH_TABLE {
+		return false, nil
+	}
+	return false, err
+}
+
+//the latest existing sub table before tableIndex and its node, "" if none
+func (s *Server) dateShardTemplate(schema *Schema, rule *router.Rule, indexs []int, tableIndex int) (string, string) {
+	for i := len(indexs) - 1; 0 <= i; i-- {
+		if tableIndex <= indexs[i] {
+			continue
+		}
+		table := fmt.Sprintf("%s_%04d", rule.Table, indexs[i])
+		nodeName := rule.Nodes[rule.TableToNode[indexs[i]]]
+		if exists, _ := s.subTableExists(schema, nodeName, rule.DB, table); exists {
+			return table, nodeName
+		}
+	}
+	return "", ""
+}
+
+//create the sub table by the show create table of the template, or drop it
+func (s *Server) applyDateShard(schema *Schema, a *DateShardAction) error {
+	var createSql string
+	if a.Action == DateShardCreate {
+		var err error
+		if createSql, err = s.showCreateTable(schema, a.templateNode, a.db, a.template); err != nil {
+			return err
+		}
+	}
+
+	n := schema.nodes[a.Node]
+	if n == nil {
+		return fmt.Errorf("invalid node %s", a.Node)
+	}
+	conn, err := n.GetMasterConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sql := fmt.Sprintf("drop table if exists `%s`.`%s`", conn.RewriteDB(a.db), a.SubTable)
+	if a.Action == DateShardCreate {
+		sql = newSubTableSql(createSql, a.template, conn.RewriteDB(a.db), a.SubTable)
+	}
+	_, err = conn.Execute(sql)
+	return err
+}
+
+func (s *Server) checkDateShardsLoop(interval time.Duration) {
+	for s.isRunning() {
+		for _, a := range s.CheckDateShards(time.Now(), true) {
+			if len(a.Err) != 0 {
+				golog.Error("Server", "checkDateShards", a.Err, 0, "action", a.String())
+				continue
+			}
+			golog.Warn("Server", "checkDateShards", "date shard changed", 0, "action", a.String())
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+var dateShardConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : log
+        key : ctime
+        nodes : [node1,node2]
+        type : date_month
+        date_range : [201601-201606,201607-201612]
+        retain_shards : 3
+`
+
+func TestDateShards(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, dateShardConfig)
+	defer close()
+	backends[0].Handle("^show create table", &mysqltest.Response{
+		Err: mysql.NewError(mysql.ER_NO_SUCH_TABLE, "Table doesn't exist"),
+	})
+	backends[0].Handle("^show create table `kingshard`.`log_20160[1-5]`", &mysqltest.Response{
+		Names: []string{"Table", "Create Table"},
+		Rows: [][]interface{}{{"log_201605", "CREATE TABLE `log_201605` (\n" +
+			"  `id` int(11) NOT NULL AUTO_INCREMENT,\n  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=151 DEFAULT CHARSET=utf8"}},
+	})
+
+	now := time.Date(2016, 5, 20, 12, 0, 0, 0, time.Local)
+	var actions []string
+	for _, a := range s.CheckDateShards(now, false) {
+		if len(a.Err) != 0 {
+			t.Fatal(a.String(), a.Err)
+		}
+		actions = append(actions, a.String())
+	}
+	expect := []string{
+		"create kingshard.log_201606 on node1",
+		"drop kingshard.log_201601 on node1",
+		"drop kingshard.log_201602 on node1",
+	}
+	if !reflect.DeepEqual(actions, expect) {
+		t.Fatal(actions)
+	}
+	if hasQuery(backends[0].Queries(), "CREATE TABLE") || hasQuery(backends[0].Queries(), "drop table") {
+		t.Fatal("the check must not change the tables")
+	}
+
+	for _, a := range s.CheckDateShards(now, true) {
+		if len(a.Err) != 0 {
+			t.Fatal(a.String(), a.Err)
+		}
+	}
+	queries := backends[0].Queries()
+	for _, sql := range []string{
+		"CREATE TABLE IF NOT EXISTS `kingshard`.`log_201606` (\n  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+			"  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		"drop table if exists `kingshard`.`log_201601`",
+		"drop table if exists `kingshard`.`log_201602`",
+	} {
+		if !hasQuery(queries, sql) {
+			t.Fatal(sql, queries)
+		}
+	}
+	if hasQuery(queries, "drop table if exists `kingshard`.`log_201603`") {
+		t.Fatal(queries)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the auto increment of each sub table differs, it is not a drift
+var autoIncrementRegexp = regexp.MustCompile(`\s*AUTO_INCREMENT=\d+`)
+
+//DDLDrift is a difference of the sub table Shard from the sub table Base
+//of the sharding table Table
+type DDLDrift struct {
+	Table string //db.table
+	Base  string //node.sub_table
+	Shard string //node.sub_table
+	Diff  string
+}
+
+//the definitions of show create table, the key of a column is
+//"column `name`", and the key of an index is "index name"
+type tableDefinition struct {
+	keys    []string
+	defs    map[string]string
+	columns []string
+	options string
+}
+
+func parseCreateTable(sql string) *tableDefinition {
+	d := &tableDefinition{defs: make(map[string]string)}
+	lines := strings.Split(sql, "\n")
+	//skip CREATE TABLE `name` (
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, ")") {
+			options := strings.Join(lines[i:], " ")
+			options = autoIncrementRegexp.ReplaceAllString(options, "")
+			d.options = strings.Join(strings.Fields(strings.TrimPrefix(options, ")")), " ")
+			break
+		}
+		line = strings.TrimSuffix(line, ",")
+
+		var key, def string
+		if end := strings.Index(line[1:], "`"); strings.HasPrefix(line, "`") && end != -1 {
+			name := line[:end+2]
+			key = "column " + name
+			def = strings.TrimSpace(line[end+2:])
+			d.columns = append(d.columns, name)
+		} else {
+			key = "index " + indexName(line)
+			def = line
+		}
+		d.keys = append(d.keys, key)
+		d.defs[key] = def
+	}
+	return d
+}
+
+//PRIMARY for the primary key, the quoted name for the others
+func indexName(def string) string {
+	if strings.HasPrefix(def, "PRIMARY KEY") {
+		return "PRIMARY"
+	}
+	start := strings.Index(def, "`")
+	if start == -1 {
+		return def
+	}
+	end := strings.Index(def[start+1:], "`")
+	if end == -1 {
+		return def
+	}
+	return def[start : start+end+2]
+}
+
+//the differences of other from base, such as a missing column or a
+//different index
+func diffTableDefinitions(base *tableDefinition, other *tableDefinition) []string {
+	var diffs []string
+	for _, key := range base.keys {
+		def, ok := other.defs[key]
+		if !ok {
+			diffs = append(diffs, "missing "+key)
+		} else if def != base.defs[key] {
+			diffs = append(diffs, fmt.Sprintf("%s is %s instead of %s", key, def, base.defs[key]))
+		}
+	}
+	for _, key := range other.keys {
+		if _, ok := base.defs[key]; !ok {
+			diffs = append(diffs, "extra "+key)
+		}
+	}
+	//select * merges the rows by position
+	if len(diffs) == 0 && strings.Join(base.columns, ",") != strings.Join(other.columns, ",") {
+		diffs = append(diffs, fmt.Sprintf("column order is %s instead of %s",
+			strings.Join(other.columns, ","), strings.Join(base.columns, ",")))
+	}
+	if base.options != other.options {
+		diffs = append(diffs, fmt.Sprintf("table options are %s instead of %s", other.options, base.options))
+	}
+	return diffs
+}
+
+func (s *Server) showCreateTable(schema *Schema, nodeName string, db string, table string) (string, error) {
+	n := schema.nodes[nodeName]
+	if n == nil {
+		return "", fmt.Errorf("invalid node %s", nodeName)
+	}
+	conn, err := n.GetMasterConn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	r, err := conn.Execute(fmt.Sprintf("show create table `%s`.`%s`", conn.RewriteDB(db), table))
+	if err != nil {
+		return "", err
+	}
+	return r.GetString(0, 1)
+}
+
+//compare the show create table of every sub table with the first one in
+//the master of its node
+func (s *Server) checkRuleDDLDrift(schema *Schema, rule *router.Rule) []DDLDrift {
+	var drifts []DDLDrift
+	var base *tableDefinition
+	var baseWhere string
+	for _, tableIndex := range rule.SubTableIndexs {
+		if rule.IsBlackhole(tableIndex) {
+			continue
+		}
+		nodeName := rule.Nodes[rule.TableToNode[tableIndex]]
+		table := fmt.Sprintf("%s_%04d", rule.Table, tableIndex)
+		where := nodeName + "." + table
+
+		sql, err := s.showCreateTable(schema, nodeName, rule.DB, table)
+		if err != nil {
+			diff := "check failed: " + err.Error()
+			if e, ok := err.(*mysql.SqlError); ok && e.Code == mysql.ER_NO_SUCH_TABLE {
+				diff = "missing table"
+			}
+			drifts = append(drifts, DDLDrift{Shard: where, Diff: diff})
+			continue
+		}
+		d := parseCreateTable(sql)
+		if base == nil {
+			base = d
+			baseWhere = where
+			continue
+		}
+		for _, diff := range diffTableDefinitions(base, d) {
+			drifts = append(drifts, DDLDrift{Base: baseWhere, Shard: where, Diff: diff})
+		}
+	}
+	for i := range drifts {
+		drifts[i].Table = rule.DB + "." + rule.Table
+		drifts[i].Base = baseWhere
+	}
+	return drifts
+}
+
+//CheckDDLDrift compares the sub tables of every sharding table, the
+//drift breaks the queries and merges across the sub tables silently
+func (s *Server) CheckDDLDrift() []DDLDrift {
+	schema := s.GetSchema()
+	if schema == nil {
+		return nil
+	}
+
+	var dbs []string
+	for db := range schema.rule.Rules {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	var drifts []DDLDrift
+	for _, db := range dbs {
+		var tables []string
+		for table := range schema.rule.Rules[db] {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			drifts = append(drifts, s.checkRuleDDLDrift(schema, schema.rule.Rules[db][table])...)
+		}
+	}
+	return drifts
+}
+
+func (s *Server) checkDDLDriftLoop(interval time.Duration) {
+	for s.isRunning() {
+		time.Sleep(interval)
+		for _, drift := range s.CheckDDLDrift() {
+			golog.Warn("Server", "checkDDLDrift", drift.Diff, 0,
+				"table", drift.Table,
+				"base", drift.Base,
+				"shard", drift.Shard)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+const createOrders = "CREATE TABLE `orders_0000` (\n" +
+	"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+	"  `user_id` int(11) NOT NULL,\n" +
+	"  `amount` decimal(10,2) NOT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `idx_user` (`user_id`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=120 DEFAULT CHARSET=utf8"
+
+func TestParseCreateTable(t *testing.T) {
+	d := parseCreateTable(createOrders)
+	expect := []string{"column `id`", "column `user_id`", "column `amount`", "index PRIMARY", "index `idx_user`"}
+	if !reflect.DeepEqual(d.keys, expect) {
+		t.Fatalf("expect %v, got %v", expect, d.keys)
+	}
+	if d.defs["column `amount`"] != "decimal(10,2) NOT NULL" || d.defs["index `idx_user`"] != "KEY `idx_user` (`user_id`)" {
+		t.Fatalf("bad defs %v", d.defs)
+	}
+	if d.options != "ENGINE=InnoDB DEFAULT CHARSET=utf8" {
+		t.Fatalf("bad options %s", d.options)
+	}
+}
+
+func TestDiffTableDefinitions(t *testing.T) {
+	base := parseCreateTable(createOrders)
+	tests := []struct {
+		sql    string
+		expect []string
+	}{
+		//the auto increment is ignored
+		{"CREATE TABLE `orders_0001` (\n" +
+			"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+			"  `user_id` int(11) NOT NULL,\n" +
+			"  `amount` decimal(10,2) NOT NULL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  KEY `idx_user` (`user_id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=7 DEFAULT CHARSET=utf8", nil},
+		{"CREATE TABLE `orders_0002` (\n" +
+			"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+			"  `user_id` int(11) NOT NULL,\n" +
+			"  `amount` decimal(12,2) NOT NULL,\n" +
+			"  `coupon_id` int(11) DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`)\n" +
+			") ENGINE=MyISAM DEFAULT CHARSET=utf8", []string{
+			"column `amount` is decimal(12,2) NOT NULL instead of decimal(10,2) NOT NULL",
+			"missing index `idx_user`",
+			"extra column `coupon_id`",
+			"table options are ENGINE=MyISAM DEFAULT CHARSET=utf8 instead of ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		}},
+		{"CREATE TABLE `orders_0003` (\n" +
+			"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+			"  `amount` decimal(10,2) NOT NULL,\n" +
+			"  `user_id` int(11) NOT NULL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  KEY `idx_user` (`user_id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8", []string{
+			"column order is `id`,`amount`,`user_id` instead of `id`,`user_id`,`amount`",
+		}},
+	}
+	for i, test := range tests {
+		diffs := diffTableDefinitions(base, parseCreateTable(test.sql))
+		if !reflect.DeepEqual(diffs, test.expect) {
+			t.Fatalf("%d expect %v, got %v", i, test.expect, diffs)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/backend"
+)
+
+// DebugStat is a runtime statistic for diagnostics, shown by the admin
+// command show debug status and published by expvar
+type DebugStat struct {
+	Name  string
+	Value string
+}
+
+// the statistics of go runtime, client conns and backend conn pools
+func (s *Server) DebugStats() []DebugStat {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := []DebugStat{
+		{"Goroutines", fmt.Sprintf("%d", runtime.NumGoroutine())},
+		{"HeapAlloc", fmt.Sprintf("%d", m.HeapAlloc)},
+		{"HeapSys", fmt.Sprintf("%d", m.HeapSys)},
+		{"HeapObjects", fmt.Sprintf("%d", m.HeapObjects)},
+		{"NumGC", fmt.Sprintf("%d", m.NumGC)},
+		{"GCPauseTotal", fmt.Sprintf("%dms", m.PauseTotalNs/1e6)},
+		{"ClientConns", fmt.Sprintf("%d", s.counter.ClientConns)},
+		{"HandshakeConns", fmt.Sprintf("%d", s.counter.HandshakeConns)},
+		{"TxRejects", fmt.Sprintf("%d", atomic.LoadInt64(&s.counter.TxRejects))},
+		{"IdleTxKills", fmt.Sprintf("%d", atomic.LoadInt64(&s.counter.IdleTxKills))},
+		{"TxConnLosts", fmt.Sprintf("%d", atomic.LoadInt64(&s.counter.TxConnLosts))},
+		{"TxReplays", fmt.Sprintf("%d", atomic.LoadInt64(&s.counter.TxReplays))},
+	}
+	//the client conns in each transaction state
+	counts := s.TxStateCounts()
+	for state := TxAutoCommit; state <= TxXAPrepared; state++ {
+		stats = append(stats, DebugStat{"ClientConns." + state.String(), fmt.Sprintf("%d", counts[state])})
+	}
+
+	nodes := s.getState().nodes
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := nodes[name]
+		stats = appendDBStat(stats, name, backend.Master, n.Master)
+		n.RLock()
+		slaves := append([]*backend.DB(nil), n.Slave...)
+		n.RUnlock()
+		for _, slave := range slaves {
+			stats = appendDBStat(stats, name, backend.Slave, slave)
+		}
+	}
+	return stats
+}
+
+// the conns of a db, in_use is the conns used by the clients, idle is the
+// conns connected in the pool and rejects is the new conns rejected by the
+// db for too many connections. backoff is appended if no new conn is
+// opened to the db for now.
+func appendDBStat(stats []DebugStat, node string, role string, db *backend.DB) []DebugStat {
+	if db == nil {
+		return stats
+	}
+	value := fmt.Sprintf("state=%s in_use=%d idle=%d rejects=%d",
+		db.State(), db.InUseConnCount(), db.IdleConnCount(), db.ConnRejects())
+	if db.IsBackingOff() {
+		value += " backoff"
+	}
+	return append(stats, DebugStat{
+		Name:  fmt.Sprintf("%s.%s.%s", node, role, db.Addr()),
+		Value: value,
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+)
+
+func TestDebugStats(t *testing.T) {
+	node := new(backend.Node)
+	node.ParseMaster("127.0.0.1:1")
+	s := newStateServer(new(config.Config))
+	s.counter = new(Counter)
+	s.getState().nodes = map[string]*backend.Node{"node1": node}
+
+	stats := s.DebugStats()
+	if stats[0].Name != "Goroutines" {
+		t.Fatal(stats[0])
+	}
+	last := stats[len(stats)-1]
+	if last.Name != "node1.master.127.0.0.1:1" || last.Value != "state=down in_use=0 idle=0 rejects=0" {
+		t.Fatal(last)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//set ks_dry_run = 1 returns the rewritten sqls instead of executing them
+const DryRunVariable = "KS_DRY_RUN"
+
+func (c *ClientConn) handleSetDryRun(val sqlparser.ValExpr) error {
+	flag := strings.Trim(sqlparser.String(val), "'`\"")
+	switch strings.ToUpper(flag) {
+	case `1`, `ON`:
+		c.dryRun = true
+	case `0`, `OFF`:
+		c.dryRun = false
+	default:
+		return fmt.Errorf("invalid ks_dry_run flag %s", flag)
+	}
+	return c.writeOK(nil)
+}
+
+//in dry run mode, the sqls which are sent to backend are routed and
+//rewritten, and the sqls of each node are returned without execution.
+//The statements handled by kingshard itself, such as set, use and
+//begin, are executed as usual.
+func (c *ClientConn) handleDryRun(stmt sqlparser.Statement, sql string) (bool, error) {
+	sqls, err := c.getDryRunSqls(stmt, sql)
+	if err != nil {
+		return true, err
+	}
+	if sqls == nil {
+		return false, nil
+	}
+	return true, c.writeDryRunResult(sqls)
+}
+
+//the rewritten sqls of each node, nil if stmt is handled by kingshard
+func (c *ClientConn) getDryRunSqls(stmt sqlparser.Statement, sql string) (map[string][]string, error) {
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Insert, *sqlparser.Update,
+		*sqlparser.Delete, *sqlparser.Replace, *sqlparser.Truncate:
+	default:
+		return nil, nil
+	}
+
+	plan, err := c.buildPlan(sql, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Blackhole {
+		return map[string][]string{}, nil
+	}
+	return plan.RewrittenSqls, nil
+}
+
+//the result has a row for each sql, in the order of node names
+func (c *ClientConn) writeDryRunResult(sqls map[string][]string) error {
+	names := []string{"Node", "Sql"}
+	var values [][]interface{}
+	for _, nodeName := range sortedNodeNames(sqls) {
+		for _, sql := range sqls[nodeName] {
+			values = append(values, []interface{}{nodeName, sql})
+		}
+	}
+
+	r, err := c.buildResultset(nil, names, values)
+	if err != nil {
+		return err
+	}
+	return c.writeResultset(c.status, r)
+}
+
+//the sql of executeDB is sent to the node without rewrite
+func (c *ClientConn) writeDryRunExecuteDB(executeDB *ExecuteDB) error {
+	if executeDB.ExecNode == nil {
+		return errors.ErrNoRouteNode
+	}
+	return c.writeDryRunResult(map[string][]string{
+		executeDB.ExecNode.Cfg.Name: {executeDB.sql},
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDryRunSqls(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+
+	tests := []struct {
+		sql    string
+		expect map[string][]string
+	}{
+		{"select * from test1 where id in (1, 5)", map[string][]string{
+			"node1": {"select * from test1_0001 where id in (1)"},
+			"node2": {"select * from test1_0005 where id in (5)"},
+		}},
+		{"insert into test1 (id) values (2)", map[string][]string{
+			"node1": {"insert into test1_0002(id) values (2)"},
+		}},
+		{"delete from test1 where id = 5", map[string][]string{
+			"node2": {"delete from test1_0005 where id = 5"},
+		}},
+		{"begin", nil},
+		{"set ks_dry_run = 0", nil},
+	}
+	for _, test := range tests {
+		stmt, err := c.parse(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sqls, err := c.getDryRunSqls(stmt, test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sqls, test.expect) {
+			t.Fatalf("sql %s, expect %v, got %v", test.sql, test.expect, sqls)
+		}
+	}
+}
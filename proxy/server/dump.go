@@ -0,0 +1,393 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the rows of an insert in dump
+const DumpInsertRows = 100
+
+var dumpWhereRegexp = regexp.MustCompile(`(?i)\s+where\s+`)
+
+//the table dumped, v of admin dump is such as 'orders where id < 100' or
+//'orders /data/orders.sql where id < 100' with the file to save
+type dumpSpec struct {
+	db    string
+	table string
+	file  string
+	where string
+}
+
+func parseDumpSpec(db string, v string, withFile bool) (*dumpSpec, error) {
+	parts := dumpWhereRegexp.Split(strings.TrimSpace(v), 2)
+	fields := strings.Fields(parts[0])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("dump has no table")
+	}
+	spec := &dumpSpec{db: db, table: strings.Trim(fields[0], "`")}
+	if i := strings.Index(spec.table, "."); i != -1 {
+		spec.db = strings.Trim(spec.table[:i], "`")
+		spec.table = strings.Trim(spec.table[i+1:], "`")
+	}
+	fields = fields[1:]
+	if withFile && 0 < len(fields) {
+		spec.file = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) != 0 || (withFile && len(spec.file) == 0) {
+		return nil, fmt.Errorf("invalid dump %s", v)
+	}
+	if len(spec.db) == 0 {
+		return nil, fmt.Errorf("dump %s has no database", spec.table)
+	}
+
+	if len(parts) == 2 {
+		spec.where = parts[1]
+		//the where is a single condition
+		sql := fmt.Sprintf("select * from `%s` where %s", spec.table, spec.where)
+		if _, err := sqlparser.Parse(sql); err != nil {
+			return nil, fmt.Errorf("invalid dump where %s", spec.where)
+		}
+	}
+	return spec, nil
+}
+
+//a sub table of the table dumped
+type dumpShard struct {
+	node  string
+	table string
+}
+
+//the sub tables of spec in the order of table index, the blackhole ones
+//are skipped
+func dumpShards(rule *router.Rule, table string) []dumpShard {
+	if rule.Type == router.DefaultRuleType {
+		return []dumpShard{{rule.Nodes[0], table}}
+	}
+	if rule.NoRewrite {
+		shards := make([]dumpShard, 0, len(rule.Nodes))
+		for _, node := range rule.Nodes {
+			shards = append(shards, dumpShard{node, table})
+		}
+		return shards
+	}
+	var shards []dumpShard
+	for _, tableIndex := range rule.SubTableIndexs {
+		if rule.IsBlackhole(tableIndex) {
+			continue
+		}
+		shards = append(shards, dumpShard{
+			node:  rule.Nodes[rule.TableToNode[tableIndex]],
+			table: fmt.Sprintf("%s_%04d", table, tableIndex),
+		})
+	}
+	return shards
+}
+
+//dumpTarget receives the sqls of a dump, close is called with the error
+//of dump if it fails
+type dumpTarget interface {
+	writeSql(sql string) error
+	close(err error) error
+}
+
+//stream the sqls to client as a resultset with a column Sql
+type clientDumpTarget struct {
+	c       *ClientConn
+	total   []byte
+	started bool
+}
+
+func (t *clientDumpTarget) start() error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	r, err := t.c.buildResultset(nil, []string{"Sql"}, nil)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 4, 64)
+	data = append(data, mysql.PutLengthEncodedInt(1)...)
+	if t.total, err = t.c.writePacketBatch(t.total, data, false); err != nil {
+		return err
+	}
+	data = append(data[:4], r.Fields[0].Dump()...)
+	if t.total, err = t.c.writePacketBatch(t.total, data, false); err != nil {
+		return err
+	}
+	t.total, err = t.c.writeFieldsEOFBatch(t.total, t.c.status)
+	return err
+}
+
+func (t *clientDumpTarget) writeSql(sql string) error {
+	if err := t.start(); err != nil {
+		return err
+	}
+	data := make([]byte, 4, 4+len(sql)+9)
+	data = append(data, mysql.PutLengthEncodedString([]byte(sql))...)
+	//the packets are sent once they exceed mysql.BatchFlushSize
+	var err error
+	t.total, err = t.c.writePacketBatch(t.total, data, false)
+	return err
+}
+
+//the packets written are flushed if dump fails, so the error packet
+//follows them
+func (t *clientDumpTarget) close(err error) error {
+	if err != nil {
+		_, e := t.c.writePacketBatch(t.total, nil, true)
+		return e
+	}
+	if err = t.start(); err != nil {
+		return err
+	}
+	_, err = t.c.writeEOFBatch(t.total, t.c.status, true)
+	return err
+}
+
+//write the sqls to a new file in kingshard host, a line for each sql
+type fileDumpTarget struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newFileDumpTarget(path string) (*fileDumpTarget, error) {
+	//an existing file is never overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDumpTarget{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (t *fileDumpTarget) writeSql(sql string) error {
+	if _, err := t.w.WriteString(sql); err != nil {
+		return err
+	}
+	return t.w.WriteByte('\n')
+}
+
+//the file of a failed dump is removed
+func (t *fileDumpTarget) close(err error) error {
+	if err == nil {
+		err = t.w.Flush()
+	}
+	if e := t.f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(t.f.Name())
+	}
+	return err
+}
+
+//admin server(opt,k,v) values('show','dump','orders where id < 100')
+//streams the dump of logical table orders to client, and
+//values('save','dump','orders /data/orders.sql where id < 100') saves it
+//to a new file in kingshard host. The sub tables of a node are read in a
+//consistent snapshot, the snapshots of nodes are started one by one, so
+//the dump is not consistent across nodes.
+func (c *ClientConn) handleAdminDump(opt string, v string) error {
+	spec, err := parseDumpSpec(c.db, v, opt == ADMIN_SAVE_CONFIG)
+	if err != nil {
+		return err
+	}
+	if len(spec.file) == 0 {
+		_, err = c.dump(spec, &clientDumpTarget{c: c})
+		return err
+	}
+
+	target, err := newFileDumpTarget(spec.file)
+	if err != nil {
+		return err
+	}
+	rows, err := c.dump(spec, target)
+	if err != nil {
+		return err
+	}
+	return c.writeOK(&mysql.Result{Status: c.status, AffectedRows: uint64(rows)})
+}
+
+//write the dump of spec to target, return the rows dumped
+func (c *ClientConn) dump(spec *dumpSpec, target dumpTarget) (rows int64, err error) {
+	defer func() {
+		if e := target.close(err); err == nil {
+			err = e
+		}
+	}()
+
+	shards := dumpShards(c.schema.rule.GetRule(spec.db, spec.table), spec.table)
+	conns := make(map[string]*backend.BackendConn)
+	defer func() {
+		for _, co := range conns {
+			co.Execute("rollback")
+			co.Close()
+		}
+	}()
+	for _, shard := range shards {
+		if conns[shard.node] != nil {
+			continue
+		}
+		n := c.schema.nodes[shard.node]
+		if n == nil {
+			return 0, fmt.Errorf("invalid node %s", shard.node)
+		}
+		co, err := n.GetMasterConn()
+		if err != nil {
+			return 0, err
+		}
+		conns[shard.node] = co
+		if _, err = co.Execute("start transaction with consistent snapshot"); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = dumpHeader(spec, shards, conns, target); err != nil {
+		return 0, err
+	}
+	for _, shard := range shards {
+		n, err := dumpSubTable(spec, shard, conns[shard.node], target)
+		if err != nil {
+			return 0, err
+		}
+		rows += n
+	}
+	return rows, target.writeSql(fmt.Sprintf("-- dump completed, %d rows", rows))
+}
+
+//the create table of the logical table is got from the first sub table
+func dumpHeader(spec *dumpSpec, shards []dumpShard, conns map[string]*backend.BackendConn,
+	target dumpTarget) error {
+	if err := target.writeSql(fmt.Sprintf("-- kingshard dump of `%s`.`%s`", spec.db, spec.table)); err != nil {
+		return err
+	}
+	if 0 < len(spec.where) {
+		if err := target.writeSql("-- where " + spec.where); err != nil {
+			return err
+		}
+	}
+	if len(shards) == 0 {
+		return nil
+	}
+
+	co := conns[shards[0].node]
+	r, err := co.Execute(fmt.Sprintf("show create table `%s`.`%s`", co.RewriteDB(spec.db), shards[0].table))
+	if err != nil {
+		return err
+	}
+	create, err := r.GetString(0, 1)
+	if err != nil {
+		return err
+	}
+	create = strings.Replace(create, "`"+shards[0].table+"`", "`"+spec.table+"`", 1)
+	if err = target.writeSql(fmt.Sprintf("DROP TABLE IF EXISTS `%s`;", spec.table)); err != nil {
+		return err
+	}
+	return target.writeSql(create + ";")
+}
+
+//the rows of a sub table are written as the inserts of the logical table
+func dumpSubTable(spec *dumpSpec, shard dumpShard, co *backend.BackendConn, target dumpTarget) (int64, error) {
+	sql := fmt.Sprintf("select * from `%s`.`%s`", co.RewriteDB(spec.db), shard.table)
+	if 0 < len(spec.where) {
+		sql += " where " + spec.where
+	}
+
+	var rows int64
+	var insert string
+	var values []string
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		err := target.writeSql(insert + strings.Join(values, ",") + ";")
+		values = values[:0]
+		return err
+	}
+	err := co.ExecuteRows(sql, func(fields []*mysql.Field, row []interface{}) error {
+		if len(insert) == 0 {
+			names := make([]string, len(fields))
+			for i, f := range fields {
+				names[i] = "`" + string(f.Name) + "`"
+			}
+			insert = fmt.Sprintf("INSERT INTO `%s` (%s) VALUES ", spec.table, strings.Join(names, ","))
+		}
+		values = append(values, dumpRow(fields, row))
+		rows++
+		if len(values) == DumpInsertRows {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return rows, err
+}
+
+func dumpRow(fields []*mysql.Field, row []interface{}) string {
+	values := make([]string, len(row))
+	for i, value := range row {
+		values[i] = dumpValue(fields[i], value)
+	}
+	return "(" + strings.Join(values, ",") + ")"
+}
+
+//the value in sql, the binary strings are in hex
+func dumpValue(f *mysql.Field, value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if isBinaryField(f) && 0 < len(v) {
+			return "0x" + hex.EncodeToString(v)
+		}
+		return "'" + mysql.Escape(string(v)) + "'"
+	}
+	return "'" + mysql.Escape(fmt.Sprintf("%v", value)) + "'"
+}
+
+func isBinaryField(f *mysql.Field) bool {
+	if f.Charset != uint16(mysql.CollationNames["binary"]) {
+		return false
+	}
+	switch f.Type {
+	case mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB,
+		mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_STRING,
+		mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_BIT:
+		return true
+	}
+	return false
+}
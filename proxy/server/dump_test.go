@@ -0,0 +1,99 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestAdminDump(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle("show create table `kingshard`.`t_0000`", &mysqltest.Response{
+		Names: []string{"Table", "Create Table"},
+		Rows:  [][]interface{}{{"t_0000", "CREATE TABLE `t_0000` (\n  `id` int NOT NULL\n)"}},
+	})
+	backends[0].Handle("from `kingshard`.`t_0000`", &mysqltest.Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{1, "a'b"}, {3, nil}},
+	})
+	backends[1].Handle("from `kingshard`.`t_0001`", &mysqltest.Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{2, "c"}},
+	})
+
+	r, err := c.Execute("admin server(opt,k,v) values('show','dump','t where id > 0')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-- kingshard dump of `kingshard`.`t`",
+		"-- where id > 0",
+		"DROP TABLE IF EXISTS `t`;",
+		"CREATE TABLE `t` (\n  `id` int NOT NULL\n);",
+		"INSERT INTO `t` (`id`,`name`) VALUES (1,'a\\'b'),(3,NULL);",
+		"INSERT INTO `t` (`id`,`name`) VALUES (2,'c');",
+		"-- dump completed, 3 rows",
+	}
+	if r.RowNumber() != len(expected) {
+		t.Fatal(r.RowNumber())
+	}
+	for i, sql := range expected {
+		if s, _ := r.GetString(i, 0); s != sql {
+			t.Fatal(i, s)
+		}
+	}
+	for _, b := range backends {
+		if !hasQuery(b.Queries(), "start transaction with consistent snapshot") ||
+			!hasQuery(b.Queries(), "where id > 0") {
+			t.Fatal(b.Queries())
+		}
+	}
+
+	//the dump is saved to a new file
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "t.sql")
+	sql := "admin server(opt,k,v) values('save','dump','kingshard.t " + file + "')"
+	if r, err = c.Execute(sql); err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 3 {
+		t.Fatal(r.AffectedRows)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "VALUES (2,'c');\n-- dump completed, 3 rows\n") {
+		t.Fatal(string(data))
+	}
+	if _, err = c.Execute(sql); err == nil {
+		t.Fatal("the existing file is overwritten")
+	}
+
+	if _, err = c.Execute("admin server(opt,k,v) values('show','dump','t where id >')"); err == nil {
+		t.Fatal("invalid where expected")
+	}
+}
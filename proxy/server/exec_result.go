@@ -0,0 +1,76 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"github.com/flike/kingshard/mysql"
+)
+
+//the status flags of a statement, which are merged from the results of
+//backends. The other flags, such as in transaction and autocommit, are the
+//state of the client session, the conns of backends may differ from it.
+const stmtStatusFlags = mysql.SERVER_STATUS_NO_GOOD_INDEX_USED |
+	mysql.SERVER_STATUS_NO_INDEX_USED |
+	mysql.SERVER_STATUS_DB_DROPPED |
+	mysql.SERVER_STATUS_METADATA_CHANGED |
+	mysql.SERVER_QUERY_WAS_SLOW
+
+//the status flags sent to client, the session flags of the client conn
+//and the statement flags of any result
+func (c *ClientConn) resultStatus(rs ...*mysql.Result) uint16 {
+	status := c.status &^ stmtStatusFlags
+	for _, r := range rs {
+		if r != nil {
+			status |= r.Status & stmtStatusFlags
+		}
+	}
+	return status
+}
+
+//mergeExecResults synthesizes the ok packet of a write sent to several
+//sub tables or nodes:
+//the affected rows and warnings are summed;
+//the insert id is the smallest one not zero, as mysql returns the first
+//id generated by a multi rows insert, see
+//http://dev.mysql.com/doc/refman/5.6/en/information-functions.html#function_last-insert-id
+//the status flags are merged by resultStatus;
+//the infos are merged by mergeResultInfo.
+func (c *ClientConn) mergeExecResults(rs []*mysql.Result) *mysql.Result {
+	r := new(mysql.Result)
+	for _, v := range rs {
+		r.AffectedRows += v.AffectedRows
+		if r.InsertId == 0 || (v.InsertId != 0 && v.InsertId < r.InsertId) {
+			r.InsertId = v.InsertId
+		}
+		if 0xffff-r.Warnings < v.Warnings {
+			r.Warnings = 0xffff
+		} else {
+			r.Warnings += v.Warnings
+		}
+	}
+	r.Status = c.resultStatus(rs...)
+	r.Info = mergeResultInfo(rs)
+	return r
+}
+
+//keep the insert id and affected rows of the ok result for
+//last_insert_id() and row_count(), the insert id is not changed if no id
+//is generated
+func (c *ClientConn) recordExecResult(r *mysql.Result) {
+	if r.InsertId > 0 {
+		c.lastInsertId = int64(r.InsertId)
+	}
+	c.affectedRows = int64(r.AffectedRows)
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestMergeExecResults(t *testing.T) {
+	//the client is in transaction with autocommit off, and the conns of
+	//backends tell autocommit on
+	c := &ClientConn{status: mysql.SERVER_STATUS_IN_TRANS}
+	rs := []*mysql.Result{
+		{Status: mysql.SERVER_STATUS_AUTOCOMMIT, AffectedRows: 2, InsertId: 0, Warnings: 1,
+			Info: "Records: 2  Duplicates: 0  Warnings: 1"},
+		{Status: mysql.SERVER_STATUS_AUTOCOMMIT | mysql.SERVER_STATUS_NO_INDEX_USED,
+			AffectedRows: 1, InsertId: 12, Info: "Records: 1  Duplicates: 0  Warnings: 0"},
+		{Status: mysql.SERVER_MORE_RESULTS_EXISTS, AffectedRows: 3, InsertId: 7, Warnings: 2,
+			Info: "Records: 3  Duplicates: 0  Warnings: 2"},
+	}
+	r := c.mergeExecResults(rs)
+	if r.AffectedRows != 6 || r.InsertId != 7 || r.Warnings != 3 {
+		t.Fatal(r.AffectedRows, r.InsertId, r.Warnings)
+	}
+	if r.Status != mysql.SERVER_STATUS_IN_TRANS|mysql.SERVER_STATUS_NO_INDEX_USED {
+		t.Fatal(r.Status)
+	}
+	if r.Info != "Records: 6  Duplicates: 0  Warnings: 3" {
+		t.Fatal(r.Info)
+	}
+
+	//the insert id is not changed if no id is generated
+	c.lastInsertId = 7
+	c.recordExecResult(&mysql.Result{AffectedRows: 1})
+	if c.lastInsertId != 7 || c.affectedRows != 1 {
+		t.Fatal(c.lastInsertId, c.affectedRows)
+	}
+	c.recordExecResult(&mysql.Result{AffectedRows: 2, InsertId: 9})
+	if c.lastInsertId != 9 || c.affectedRows != 2 {
+		t.Fatal(c.lastInsertId, c.affectedRows)
+	}
+
+	if c.resultStatus() != mysql.SERVER_STATUS_IN_TRANS {
+		t.Fatal(c.resultStatus())
+	}
+	c.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	if c.resultStatus(&mysql.Result{Status: mysql.SERVER_STATUS_IN_TRANS}) != mysql.SERVER_STATUS_AUTOCOMMIT {
+		t.Fatal("the in transaction flag of backend is not the session state")
+	}
+}
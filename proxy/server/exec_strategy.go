@@ -0,0 +1,309 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the hints overriding the execution strategy of a select
+const (
+	SerialComment   = "/*serial*/"
+	ParallelComment = "/*parallel*/"
+	StreamComment   = "/*stream*/"
+	BufferComment   = "/*buffer*/"
+)
+
+const (
+	//the max count of fingerprints in ExecStats
+	MaxExecStatsCount = 4096
+	//the weight of the last execution in the moving averages of ExecStats
+	execStatsWeight = 0.2
+)
+
+//ExecStrategy is how the sqls of a select are executed, the zero value is
+//the sqls of the nodes executed in parallel and the results buffered to
+//be merged
+type ExecStrategy struct {
+	//the sqls of the nodes are executed one by one in the client goroutine,
+	//which saves the goroutines of a tiny fan-out
+	Serial bool
+	//the rows are written to client as they are read from the shards one
+	//by one, so a big result is not kept in memory
+	Stream bool
+}
+
+func (s ExecStrategy) String() string {
+	fanout, result := "parallel", "buffer"
+	if s.Serial {
+		fanout = "serial"
+	}
+	if s.Stream {
+		result = "stream"
+	}
+	return fanout + "," + result
+}
+
+//ExecStat is the moving averages of the selects of a fingerprint
+type ExecStat struct {
+	Fingerprint string
+	Count       int64
+	AvgRows     float64
+	AvgSqlTime  float64 //ms of a sql in a node
+	Strategy    string  //the strategy of the last execution
+}
+
+type execStatList []ExecStat
+
+func (l execStatList) Len() int           { return len(l) }
+func (l execStatList) Less(i, j int) bool { return l[i].Count > l[j].Count }
+func (l execStatList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+//ExecStats records the rows and times of the selects by fingerprint, to
+//choose the strategy of their next executions
+type ExecStats struct {
+	sync.Mutex
+	stats map[string]*ExecStat
+}
+
+func NewExecStats() *ExecStats {
+	s := new(ExecStats)
+	s.stats = make(map[string]*ExecStat)
+	return s
+}
+
+func (s *ExecStats) Get(fingerprint string) (ExecStat, bool) {
+	s.Lock()
+	defer s.Unlock()
+	stat, ok := s.stats[fingerprint]
+	if !ok {
+		return ExecStat{}, false
+	}
+	return *stat, true
+}
+
+//record a select of fingerprint executed by strategy, which returns rows
+//from sqls taking sqlTime in total
+func (s *ExecStats) Record(fingerprint string, strategy ExecStrategy, rows int64, sqls int, sqlTime time.Duration) {
+	if sqls == 0 {
+		return
+	}
+	sqlMs := float64(sqlTime) / float64(time.Millisecond) / float64(sqls)
+
+	s.Lock()
+	defer s.Unlock()
+	stat, ok := s.stats[fingerprint]
+	if !ok {
+		if MaxExecStatsCount <= len(s.stats) {
+			return
+		}
+		stat = &ExecStat{Fingerprint: fingerprint, AvgRows: float64(rows), AvgSqlTime: sqlMs}
+		s.stats[fingerprint] = stat
+	}
+	stat.Count++
+	stat.AvgRows += execStatsWeight * (float64(rows) - stat.AvgRows)
+	stat.AvgSqlTime += execStatsWeight * (sqlMs - stat.AvgSqlTime)
+	stat.Strategy = strategy.String()
+}
+
+func (s *ExecStats) Stats() []ExecStat {
+	s.Lock()
+	stats := make([]ExecStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		stats = append(stats, *stat)
+	}
+	s.Unlock()
+	sort.Sort(execStatList(stats))
+	return stats
+}
+
+func (s *Server) GetExecStats() []ExecStat {
+	return s.execStats.Stats()
+}
+
+//the execution of the select being handled
+type execState struct {
+	strategy ExecStrategy
+	//the time of the sqls executed, added by executeShardSqls
+	sqlTime time.Duration
+}
+
+//choose the strategy of stmt by its hints, the nodes of plan and the past
+//stats of its fingerprint. The select to a single node is always serial,
+//and only the select whose rows need no merge can be streamed.
+func (c *ClientConn) chooseExecStrategy(stmt *sqlparser.Select, plan *router.Plan,
+	fingerprint string, args []interface{}) ExecStrategy {
+	var s ExecStrategy
+	cfg := c.state.cfg.ExecStrategy
+	stat, ok := c.proxy.execStats.Get(fingerprint)
+	sqls := 0
+	for _, v := range plan.RewrittenSqls {
+		sqls += len(v)
+	}
+
+	switch {
+	case hasComment(stmt, SerialComment):
+		s.Serial = true
+	case hasComment(stmt, ParallelComment):
+	case len(plan.RewrittenSqls) <= 1:
+		s.Serial = true
+	case ok && 0 < cfg.SerialMaxTime:
+		s.Serial = stat.AvgSqlTime*float64(sqls) <= float64(cfg.SerialMaxTime)
+	}
+
+	if len(args) != 0 || !c.isStreamable(stmt) {
+		return s
+	}
+	switch {
+	case hasComment(stmt, StreamComment):
+		s.Stream = true
+	case hasComment(stmt, BufferComment):
+	case ok && 0 < cfg.StreamMinRows:
+		s.Stream = float64(cfg.StreamMinRows) <= stat.AvgRows
+	}
+	return s
+}
+
+//record the select of fingerprint just executed, which returns rows
+func (c *ClientConn) recordExec(fingerprint string, plan *router.Plan, rows int64) {
+	if len(fingerprint) == 0 {
+		return
+	}
+	sqls := 0
+	for _, v := range plan.RewrittenSqls {
+		sqls += len(v)
+	}
+	c.proxy.execStats.Record(fingerprint, c.exec.strategy, rows, sqls, c.exec.sqlTime)
+}
+
+//the rows of the select are the rows of the shards in any order, which
+//are not grouped, sorted, limited or aggregated
+func (c *ClientConn) isStreamable(stmt *sqlparser.Select) bool {
+	if len(stmt.GroupBy) != 0 || len(stmt.OrderBy) != 0 || stmt.Limit != nil ||
+		stmt.Having != nil || len(stmt.Distinct) != 0 || len(c.getFuncExprs(stmt)) != 0 {
+		return false
+	}
+	//the masked columns are rewritten in the merged result
+	if c.state.masker != nil && c.state.masker.HasUser(c.user) {
+		return false
+	}
+	_, limited := c.selectLimit()
+	return !limited
+}
+
+//write the rows of the sqls of plan to client as they are read from the
+//nodes one by one, the fields are of the first sql, the fields of the
+//other sqls are checked against them. If a sql fails after some rows are
+//written, the rows are flushed so the error follows them. It returns the
+//rows written.
+func (c *ClientConn) streamSelect(stmt *sqlparser.Select, conns map[string]*backend.BackendConn,
+	plan *router.Plan) (int64, error) {
+	c.affectedRows = int64(-1)
+	var total []byte
+	var rows int64
+	started := false
+	//the fields written and the shard of them, and the shard executing
+	var first []*mysql.Field
+	var firstWhere, where string
+	writeFields := func(fields []*mysql.Field) error {
+		if started {
+			return checkStreamFields(first, fields, firstWhere, where)
+		}
+		started = true
+		first, firstWhere = fields, where
+		fields = c.stripFieldTables(fields)
+		data := make([]byte, 4, 512)
+		data = append(data, mysql.PutLengthEncodedInt(uint64(len(fields)))...)
+		var err error
+		if total, err = c.writePacketBatch(total, data, false); err != nil {
+			return err
+		}
+		for _, f := range fields {
+			data = append(data[:4], f.Dump()...)
+			if total, err = c.writePacketBatch(total, data, false); err != nil {
+				return err
+			}
+		}
+		total, err = c.writeFieldsEOFBatch(total, c.status)
+		return err
+	}
+	writeRow := func(row mysql.RowData) error {
+		data := make([]byte, 4, 4+len(row))
+		data = append(data, row...)
+		rows++
+		var err error
+		total, err = c.writePacketBatch(total, data, false)
+		return err
+	}
+
+	var times []shardTime
+	begin := time.Now()
+	defer func() {
+		c.outputShardTimesLog(time.Since(begin), times)
+	}()
+	for _, nodeName := range sortedNodeNames(plan.RewrittenSqls) {
+		co := conns[nodeName]
+		tables := plan.GetSubTables(nodeName)
+		for i, sql := range plan.RewrittenSqls[nodeName] {
+			sql = router.RewriteSchemas(sql, co.SchemaRewrite())
+			se := shardError{node: nodeName}
+			if i < len(tables) {
+				se.table = tables[i]
+			}
+			where = se.where()
+			start := time.Now()
+			err := co.ExecuteRowDatas(sql, writeFields, writeRow)
+			d := time.Since(start)
+			c.exec.sqlTime += d
+			times = append(times, shardTime{where: where, time: d})
+			c.outputStreamSqlLog(co, sql, d, err)
+			if err != nil {
+				if started {
+					c.writePacketBatch(total, nil, true)
+				}
+				return rows, err
+			}
+			c.recordTxSql(co, sql, nil)
+		}
+	}
+	if !started {
+		return 0, c.writeResultset(c.status, c.newEmptyResultset(stmt))
+	}
+	_, err := c.writeEOFBatch(total, c.status, true)
+	return rows, err
+}
+
+//the slow sql streamed is logged as the sqls of executeShardSqls
+func (c *ClientConn) outputStreamSqlLog(co *backend.BackendConn, sql string, d time.Duration, err error) {
+	execTime := float64(d) / float64(time.Millisecond)
+	if c.proxy.logSql[c.proxy.logSqlIndex] == golog.LogSqlOff ||
+		execTime <= float64(c.proxy.slowLogTime[c.proxy.slowLogTimeIndex]) {
+		return
+	}
+	state := "OK"
+	if err != nil {
+		state = "ERROR"
+	}
+	c.proxy.counter.IncrSlowLogTotal()
+	outputSqlLog(state, execTime, c.c.RemoteAddr(), co.GetAddr(), c.proxy.logSqlText(sql), c.tag)
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestStreamSelect(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{2, "b"}, {4, "d"}},
+	})
+	backends[1].Handle(`from t_0001`, &mysqltest.Response{
+		Names: []string{"id", "name"},
+		Rows:  [][]interface{}{{1, "a"}},
+	})
+
+	r, err := c.Execute("select /*stream*/ id, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 3 {
+		t.Fatal(r.Values)
+	}
+	if name, _ := r.GetString(2, 1); name != "a" {
+		t.Fatal(r.Values)
+	}
+
+	//the fields are written without rows
+	backends[1].Handle(`from t_0001 where`, &mysqltest.Response{
+		Names: []string{"id", "name"},
+	})
+	r, err = c.Execute("select /*stream*/ id, name from t where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 0 || len(r.Fields) != 2 {
+		t.Fatal(r.Fields, r.Values)
+	}
+
+	//the drifted columns of a later shard are not written under the fields
+	//of the first shard
+	backends[1].Handle(`from t_0001`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{1}},
+	})
+	_, err = c.Execute("select /*stream*/ id, name from t")
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_SCHEMA_DRIFT {
+		t.Fatal(err)
+	}
+	if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the error of a shard follows the rows written
+	backends[1].Handle(`from t_0001`, &mysqltest.Response{
+		Err: mysql.NewError(mysql.ER_UNKNOWN_ERROR, "shard failed"),
+	})
+	if _, err = c.Execute("select /*stream*/ id, name from t"); err == nil {
+		t.Fatal("the error of shard is dropped")
+	}
+	if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var execStrategyConfig = fakeBackendConfig + `
+exec_strategy :
+    serial_max_time : 1000
+    stream_min_rows : 3
+`
+
+func TestChooseExecStrategy(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, execStrategyConfig)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}, {2}},
+		})
+	}
+
+	strategy := func(sql string) string {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+		stats := s.GetExecStats()
+		fingerprint := mysql.GetFingerprint(sql)
+		for _, stat := range stats {
+			if stat.Fingerprint == fingerprint {
+				return stat.Strategy
+			}
+		}
+		t.Fatal(sql, stats)
+		return ""
+	}
+
+	//no stats at the first time
+	if v := strategy("select id from t"); v != "parallel,buffer" {
+		t.Fatal(v)
+	}
+	if v := strategy("select id from t"); v != "serial,stream" {
+		t.Fatal(v)
+	}
+	if v := strategy("select /*parallel*/ /*buffer*/ id from t"); v != "parallel,buffer" {
+		t.Fatal(v)
+	}
+	//the rows to be sorted are not streamed
+	strategy("select id from t order by id")
+	if v := strategy("select id from t order by id"); v != "serial,buffer" {
+		t.Fatal(v)
+	}
+	//a single node is always serial
+	if v := strategy("select id from t where id = 1"); v != "serial,buffer" {
+		t.Fatal(v)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//explain all shards select ... returns the plans of all the sub tables
+var explainRegexp = regexp.MustCompile(`(?is)^\s*explain\s+(all\s+shards\s+)?`)
+
+//explain [all shards] select, insert, update, delete or replace
+func isExplainStatement(tokens []string) bool {
+	if len(tokens) < 2 || strings.ToLower(tokens[0]) != "explain" {
+		return false
+	}
+	switch mysql.PARSE_TOKEN_MAP[strings.ToLower(tokens[1])] {
+	case mysql.TK_ID_SELECT, mysql.TK_ID_INSERT, mysql.TK_ID_UPDATE,
+		mysql.TK_ID_DELETE, mysql.TK_ID_REPLACE:
+		return true
+	}
+	return 2 < len(tokens) && strings.ToLower(tokens[1]) == "all" &&
+		strings.ToLower(tokens[2]) == "shards"
+}
+
+//the statement explained, and whether all the shards are explained
+func trimExplain(sql string) (string, bool) {
+	m := explainRegexp.FindStringSubmatch(sql)
+	if m == nil {
+		return sql, false
+	}
+	return sql[len(m[0]):], len(m[1]) != 0
+}
+
+//the sql of the first routed sub table, which represents the sub tables
+func representativeShard(plan *router.Plan) (string, int) {
+	if plan.Rule != nil && len(plan.RouteTableIndexs) != 0 {
+		tableIndex := plan.RouteTableIndexs[0]
+		nodeName := plan.Rule.Nodes[plan.Rule.TableToNode[tableIndex]]
+		table := fmt.Sprintf("%s_%04d", plan.Rule.Table, tableIndex)
+		for i, t := range plan.GetSubTables(nodeName) {
+			if t == table {
+				return nodeName, i
+			}
+		}
+	}
+	return sortedNodeNames(plan.RewrittenSqls)[0], 0
+}
+
+//the statement is routed and rewritten as it is executed, and explained in
+//the first routed sub table. explain all shards explains it in all the
+//routed sub tables, and the plans are returned with a Shard column.
+func (c *ClientConn) handleExplain(sql string) error {
+	sql, allShards := trimExplain(sql)
+	stmt, err := c.parse(sql)
+	if err != nil {
+		return err
+	}
+	var fromSlave bool
+	switch v := stmt.(type) {
+	case *sqlparser.Select:
+		fromSlave = !hasComment(v, MasterComment)
+	case *sqlparser.Insert, *sqlparser.Update, *sqlparser.Delete, *sqlparser.Replace:
+	default:
+		return mysql.NewError(mysql.ER_KS_CMD_UNSUPPORT,
+			fmt.Sprintf("explain of statement %T not support now", stmt))
+	}
+
+	plan, err := c.buildPlan(sql, stmt)
+	if err != nil {
+		return err
+	}
+	if len(plan.RewrittenSqls) == 0 {
+		return errors.ErrNoPlan
+	}
+
+	//the plan may be cached, it is copied
+	p := *plan
+	p.RewrittenSqls = make(map[string][]string)
+	if allShards {
+		for nodeName, sqls := range plan.RewrittenSqls {
+			for _, s := range sqls {
+				p.RewrittenSqls[nodeName] = append(p.RewrittenSqls[nodeName], "explain "+s)
+			}
+		}
+	} else {
+		nodeName, i := representativeShard(plan)
+		p.RewrittenSqls[nodeName] = []string{"explain " + plan.RewrittenSqls[nodeName][i]}
+		if len(plan.RouteTableIndexs) != 0 {
+			p.RouteTableIndexs = plan.RouteTableIndexs[:1]
+		}
+	}
+
+	conns, err := c.getShardConns(fromSlave, &p)
+	if err != nil {
+		return err
+	}
+	rs, err := c.executeInMultiNodes(conns, &p, nil)
+	c.closeShardConns(conns, false)
+	if err != nil {
+		return err
+	}
+
+	if !allShards {
+		r, err := c.stripSubTablesResultset(rs[0].Resultset)
+		if err != nil {
+			return err
+		}
+		return c.writeResultset(c.status, r)
+	}
+	r, err := c.buildExplainAllShardsResult(&p, rs)
+	if err != nil {
+		return err
+	}
+	return c.writeResultset(c.status, r)
+}
+
+//the plans of the sub tables in the order of rs, each row starts with the
+//node and sub table
+func (c *ClientConn) buildExplainAllShardsResult(plan *router.Plan, rs []*mysql.Result) (*mysql.Resultset, error) {
+	shardField := &mysql.Field{Name: hack.Slice("Shard")}
+	formatField(shardField, "")
+	fields := append([]*mysql.Field{shardField}, c.stripFieldTables(rs[0].Fields)...)
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = string(f.Name)
+	}
+
+	var values [][]interface{}
+	i := 0
+	for _, nodeName := range sortedNodeNames(plan.RewrittenSqls) {
+		tables := plan.GetSubTables(nodeName)
+		for j := range plan.RewrittenSqls[nodeName] {
+			se := shardError{node: nodeName}
+			if j < len(tables) {
+				se.table = tables[j]
+			}
+			stripped, err := c.stripSubTablesResultset(rs[i].Resultset)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range stripped.Values {
+				values = append(values, append([]interface{}{se.where()}, row...))
+			}
+			i++
+		}
+	}
+	return c.buildResultset(fields, names, values)
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestTrimExplain(t *testing.T) {
+	tests := []struct {
+		sql       string
+		statement bool
+		expect    string
+		allShards bool
+	}{
+		{"explain select * from test1", true, "select * from test1", false},
+		{"EXPLAIN  ALL SHARDS\nselect * from test1", true, "select * from test1", true},
+		{"explain update test1 set a = 1", true, "update test1 set a = 1", false},
+		{"explain test1", false, "", false},
+		{"desc select * from test1", false, "", false},
+	}
+	for _, test := range tests {
+		tokens := strings.FieldsFunc(test.sql, hack.IsSqlSep)
+		if isExplainStatement(tokens) != test.statement {
+			t.Fatalf("%s: expect explain statement %v", test.sql, test.statement)
+		}
+		if !test.statement {
+			continue
+		}
+		sql, allShards := trimExplain(test.sql)
+		if sql != test.expect || allShards != test.allShards {
+			t.Fatalf("%s: expect %s %v, got %s %v", test.sql, test.expect, test.allShards, sql, allShards)
+		}
+	}
+}
+
+func TestExplainShards(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+	_, plan, err := c.parseAndBuildPlan("select * from test1 where id in (6, 7, 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeName, i := representativeShard(plan); nodeName != "node1" || plan.RewrittenSqls[nodeName][i] != "select * from test1_0001 where id in (1)" {
+		t.Fatal(nodeName, i, plan.RewrittenSqls)
+	}
+	_, plan, err = c.parseAndBuildPlan("select * from test1 where id = 6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeName, i := representativeShard(plan); nodeName != "node2" || plan.RewrittenSqls[nodeName][i] != "select * from test1_0006 where id = 6" {
+		t.Fatal(nodeName, i, plan.RewrittenSqls)
+	}
+
+	//a row of explain in each sub table
+	_, plan, err = c.parseAndBuildPlan("select * from test1 where id in (1, 6)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"id", "table", "type"}
+	var rs []*mysql.Result
+	for _, table := range []string{"test1_0001", "test1_0006"} {
+		r, err := c.buildResultset(nil, names, [][]interface{}{{int64(1), table, "const"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, &mysql.Result{Resultset: r})
+	}
+	r, err := c.buildExplainAllShardsResult(plan, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Fields) != 4 || string(r.Fields[0].Name) != "Shard" || len(r.Values) != 2 {
+		t.Fatalf("bad result %v %v", r.Fields, r.Values)
+	}
+	for i, where := range []string{"node1.test1_0001", "node2.test1_0006"} {
+		if shard, _ := r.GetString(i, 0); shard != where {
+			t.Fatalf("expect %s, got %s", where, shard)
+		}
+		if table, _ := r.GetString(i, 2); table != "test1" {
+			t.Fatalf("expect test1, got %s", table)
+		}
+	}
+}
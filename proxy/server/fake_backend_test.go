@@ -0,0 +1,175 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql/mysqltest"
+	"golang.org/x/net/context"
+)
+
+var fakeBackendConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [1,1]
+`
+
+//a proxy with two fake mysql servers as the masters of node1 and node2,
+//and a client conn to the proxy. close stops all of them.
+func newFakeProxy(t *testing.T, cfgData string) (s *Server, backends []*mysqltest.Server,
+	c *backend.Conn, close func()) {
+	return newFakeProxyWith(t, cfgData, nil)
+}
+
+//newFakeProxy with the backends set up by setup before the proxy starts
+func newFakeProxyWith(t *testing.T, cfgData string, setup func(b *mysqltest.Server)) (s *Server,
+	backends []*mysqltest.Server, c *backend.Conn, close func()) {
+	for i := 0; i < 2; i++ {
+		b, err := mysqltest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setup != nil {
+			setup(b)
+		}
+		backends = append(backends, b)
+	}
+
+	cfg, err := config.ParseConfigData([]byte(fmt.Sprintf(cfgData,
+		backends[0].Addr(), backends[1].Addr())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.RunContext(ctx)
+
+	c = new(backend.Conn)
+	if err = c.Connect(s.Addr().String(), "root", "", "kingshard"); err != nil {
+		t.Fatal(err)
+	}
+	close = func() {
+		c.Close()
+		cancel()
+		for _, b := range backends {
+			b.Close()
+		}
+	}
+	return
+}
+
+//Close ends the sessions of the clients, rolling back their transactions
+func TestCloseClients(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("update t set v = 1 where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	for i := 0; !hasQuery(backends[1].Queries(), "rollback"); i++ {
+		if 100 < i {
+			t.Fatal(backends[1].Queries())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.Execute("select 1"); err == nil {
+		t.Fatal("the client conn is not closed")
+	}
+}
+
+//the proxy routes and merges the sqls of sharded table with the fake
+//mysql servers as backends
+func TestFakeBackends(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{2}, {4}},
+	})
+	backends[1].Handle(`from t_0001`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{3}},
+	})
+
+	r, err := c.Execute("select id from t order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for i := 0; i < r.RowNumber(); i++ {
+		id, _ := r.GetInt(i, 0)
+		ids = append(ids, id)
+	}
+	if fmt.Sprint(ids) != "[2 3 4]" {
+		t.Fatal(ids)
+	}
+
+	//the sql is only sent to the node of the shard key
+	for _, b := range backends {
+		b.ClearQueries()
+	}
+	if _, err := c.Execute("select id from t where id = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if hasQuery(backends[0].Queries(), "from t_") {
+		t.Fatal(backends[0].Queries())
+	}
+	if !hasQuery(backends[1].Queries(), "from t_0001") {
+		t.Fatal(backends[1].Queries())
+	}
+}
+
+func hasQuery(queries []string, s string) bool {
+	for _, q := range queries {
+		if strings.Contains(q, s) {
+			return true
+		}
+	}
+	return false
+}
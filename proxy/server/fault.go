@@ -0,0 +1,218 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the types of faults, a fault is added by the admin command such as
+//admin server(opt,k,v) values('add','fault','delay 100 node2')
+const (
+	//delay the sqls sent to a node for N milliseconds
+	FaultDelay = "delay"
+	//drop N percent of the backend conns of a node
+	FaultDrop = "drop"
+	//return the mysql error N for the sqls of a fingerprint
+	FaultError = "error"
+)
+
+type Fault struct {
+	Type   string
+	Value  int
+	Target string //node name, or the fingerprint of sql
+
+	Hits int64
+}
+
+func (f *Fault) String() string {
+	return fmt.Sprintf("%s %d %s", f.Type, f.Value, f.Target)
+}
+
+//parse the fault "type value target", the target of error fault is a
+//sql, and the faults of a same sql fingerprint are the same fault.
+func ParseFault(spec string) (*Fault, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid fault %s", spec)
+	}
+	f := &Fault{Type: strings.ToLower(fields[0])}
+	value, err := strconv.Atoi(fields[1])
+	if err != nil || value < 0 {
+		return nil, fmt.Errorf("invalid fault value %s", fields[1])
+	}
+	f.Value = value
+
+	switch f.Type {
+	case FaultDelay:
+		f.Target = fields[2]
+	case FaultDrop:
+		if 100 < value {
+			return nil, fmt.Errorf("invalid fault percent %d", value)
+		}
+		f.Target = fields[2]
+	case FaultError:
+		if value == 0 || 0xffff < value {
+			return nil, fmt.Errorf("invalid fault error code %d", value)
+		}
+		f.Target = mysql.GetFingerprint(strings.Join(fields[2:], " "))
+	default:
+		return nil, fmt.Errorf("invalid fault type %s", f.Type)
+	}
+	if f.Type != FaultError && 3 < len(fields) {
+		return nil, fmt.Errorf("invalid fault %s", spec)
+	}
+	return f, nil
+}
+
+//FaultInjector keeps the faults injected at runtime, they are not saved
+//into the config file.
+type FaultInjector struct {
+	sync.RWMutex
+	faults []*Fault
+	count  int32
+}
+
+//add the fault, which replaces the fault of the same type and target
+func (fi *FaultInjector) Add(f *Fault) {
+	fi.Lock()
+	defer fi.Unlock()
+	for i, old := range fi.faults {
+		if old.Type == f.Type && old.Target == f.Target {
+			fi.faults[i] = f
+			return
+		}
+	}
+	fi.faults = append(fi.faults, f)
+	atomic.StoreInt32(&fi.count, int32(len(fi.faults)))
+}
+
+//delete the fault of the same type and target of f
+func (fi *FaultInjector) Delete(f *Fault) error {
+	fi.Lock()
+	defer fi.Unlock()
+	for i, old := range fi.faults {
+		if old.Type == f.Type && old.Target == f.Target {
+			fi.faults = append(fi.faults[:i], fi.faults[i+1:]...)
+			atomic.StoreInt32(&fi.count, int32(len(fi.faults)))
+			return nil
+		}
+	}
+	return errors.ErrFaultNotExist
+}
+
+func (fi *FaultInjector) Clear() {
+	fi.Lock()
+	fi.faults = nil
+	atomic.StoreInt32(&fi.count, 0)
+	fi.Unlock()
+}
+
+func (fi *FaultInjector) GetFaults() []Fault {
+	fi.RLock()
+	defer fi.RUnlock()
+	faults := make([]Fault, 0, len(fi.faults))
+	for _, f := range fi.faults {
+		faults = append(faults, Fault{
+			Type:   f.Type,
+			Value:  f.Value,
+			Target: f.Target,
+			Hits:   atomic.LoadInt64(&f.Hits),
+		})
+	}
+	return faults
+}
+
+//the fault of type and target, nil if not injected
+func (fi *FaultInjector) match(typ string, target string) *Fault {
+	//most of the time no fault is injected
+	if atomic.LoadInt32(&fi.count) == 0 {
+		return nil
+	}
+	fi.RLock()
+	defer fi.RUnlock()
+	for _, f := range fi.faults {
+		if f.Type == typ && f.Target == target {
+			return f
+		}
+	}
+	return nil
+}
+
+//the error injected for sql, nil if not injected
+func (fi *FaultInjector) SqlError(sql string) error {
+	if atomic.LoadInt32(&fi.count) == 0 {
+		return nil
+	}
+	f := fi.match(FaultError, mysql.GetFingerprint(sql))
+	if f == nil {
+		return nil
+	}
+	atomic.AddInt64(&f.Hits, 1)
+	return mysql.NewDefaultError(uint16(f.Value))
+}
+
+//delay or drop the conn of node, the dropped conn is closed so the sql
+//sent on it fails as a broken connection
+func (fi *FaultInjector) InjectConn(node string, co *backend.BackendConn) {
+	if f := fi.match(FaultDelay, node); f != nil {
+		atomic.AddInt64(&f.Hits, 1)
+		time.Sleep(time.Duration(f.Value) * time.Millisecond)
+	}
+	if f := fi.match(FaultDrop, node); f != nil && rand.Intn(100) < f.Value {
+		atomic.AddInt64(&f.Hits, 1)
+		golog.Warn("FaultInjector", "InjectConn", "drop backend conn", 0,
+			"node", node, "addr", co.GetAddr())
+		co.Conn.Close()
+	}
+}
+
+func (c *ClientConn) handleAddFault(v string) error {
+	if !c.state.cfg.FaultInjection {
+		return errors.ErrFaultDisabled
+	}
+	f, err := ParseFault(v)
+	if err != nil {
+		return err
+	}
+	c.proxy.faults.Add(f)
+	golog.Warn("ClientConn", "handleAddFault", "fault injected", c.connectionId,
+		"fault", f.String())
+	return nil
+}
+
+//del the fault, or all the faults if v is all
+func (c *ClientConn) handleDelFault(v string) error {
+	if strings.ToLower(strings.TrimSpace(v)) == ADMIN_ALL {
+		c.proxy.faults.Clear()
+		return nil
+	}
+	f, err := ParseFault(v)
+	if err != nil {
+		return err
+	}
+	return c.proxy.faults.Delete(f)
+}
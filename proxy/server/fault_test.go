@@ -0,0 +1,107 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestParseFault(t *testing.T) {
+	f, err := ParseFault("delay 100 node2")
+	if err != nil || f.Type != FaultDelay || f.Value != 100 || f.Target != "node2" {
+		t.Fatal(f, err)
+	}
+	f, err = ParseFault("ERROR 1205 select * from t where id = 1")
+	if err != nil || f.Type != FaultError || f.Value != 1205 ||
+		f.Target != mysql.GetFingerprint("select * from t where id = 2") {
+		t.Fatal(f, err)
+	}
+	for _, spec := range []string{
+		"delay 100",
+		"delay -1 node2",
+		"delay 100 node2 node3",
+		"drop 101 node2",
+		"error 0 select 1",
+		"crash 1 node2",
+	} {
+		if _, err = ParseFault(spec); err == nil {
+			t.Fatal(spec)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	fi := new(FaultInjector)
+	if err := fi.SqlError("select * from t where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := ParseFault("error 1205 select * from t where id = 1")
+	fi.Add(f)
+	f, _ = ParseFault("error 1213 select * from t where id = 1")
+	fi.Add(f)
+	err := fi.SqlError("select * from t where id = 3")
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_LOCK_DEADLOCK {
+		t.Fatal(err)
+	}
+	if faults := fi.GetFaults(); len(faults) != 1 || faults[0].Hits != 1 {
+		t.Fatal(faults)
+	}
+	if err = fi.Delete(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = fi.Delete(f); err != errors.ErrFaultNotExist {
+		t.Fatal(err)
+	}
+	if err = fi.SqlError("select * from t where id = 3"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//the faults of nodes with the fake mysql servers as backends, the select
+//without from is answered by kingshard, so a table is read
+func TestInjectConn(t *testing.T) {
+	s, _, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	const sql = "select * from u"
+
+	f, _ := ParseFault("delay 50 node1")
+	s.faults.Add(f)
+	start := time.Now()
+	if _, err := c.Execute(sql); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("the sql is not delayed")
+	}
+	s.faults.Clear()
+
+	f, _ = ParseFault("drop 100 node1")
+	s.faults.Add(f)
+	if _, err := c.Execute(sql); err == nil {
+		t.Fatal("the backend conn is not dropped")
+	}
+	//the client conn is closed with the broken backend conn
+	s.faults.Delete(f)
+	if err := c.ReConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute(sql); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+const (
+	DefaultHandshakeTimeout   = 10 //seconds
+	DefaultMaxHandshakeConns  = 1024
+	DefaultClientWriteTimeout = 60 //seconds
+)
+
+//0 means the default and a negative value means no limit
+func (s *Server) parseHandshakeLimits() {
+	cfg := s.getState().cfg
+	s.handshakeTimeout = 0
+	s.clientWriteTimeout = 0
+	s.maxHandshakeConns = 0
+	switch {
+	case cfg.HandshakeTimeout == 0:
+		s.handshakeTimeout = DefaultHandshakeTimeout * time.Second
+	case 0 < cfg.HandshakeTimeout:
+		s.handshakeTimeout = time.Duration(cfg.HandshakeTimeout) * time.Second
+	}
+	switch {
+	case cfg.ClientWriteTimeout == 0:
+		s.clientWriteTimeout = DefaultClientWriteTimeout * time.Second
+	case 0 < cfg.ClientWriteTimeout:
+		s.clientWriteTimeout = time.Duration(cfg.ClientWriteTimeout) * time.Second
+	}
+	switch {
+	case cfg.MaxHandshakeConns == 0:
+		s.maxHandshakeConns = DefaultMaxHandshakeConns
+	case 0 < cfg.MaxHandshakeConns:
+		s.maxHandshakeConns = int64(cfg.MaxHandshakeConns)
+	}
+}
+
+//the client which does not complete the handshake and auth in
+//handshakeTimeout is dropped, and so are the clients beyond
+//maxHandshakeConns in handshake at the same time, so the port scanners
+//and broken clients can not exhaust the conns of kingshard.
+func (s *Server) handshake(c *ClientConn) error {
+	var deadline time.Time
+	if 0 < s.handshakeTimeout {
+		deadline = time.Now().Add(s.handshakeTimeout)
+		c.c.SetDeadline(deadline)
+	}
+
+	conns := s.counter.IncrHandshakeConns()
+	defer s.counter.DecrHandshakeConns()
+	if 0 < s.maxHandshakeConns && s.maxHandshakeConns < conns {
+		s.counter.IncrHandshakeDrops()
+		golog.Warn("server", "handshake", "too many conns in handshake", c.connectionId,
+			"remoteAddr", c.c.RemoteAddr().String(),
+			"max_handshake_conns", s.maxHandshakeConns)
+		return mysql.NewDefaultError(mysql.ER_CON_COUNT_ERROR)
+	}
+
+	if err := c.Handshake(); err != nil {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			s.counter.IncrHandshakeDrops()
+			golog.Warn("server", "handshake", "handshake timeout", c.connectionId,
+				"remoteAddr", c.c.RemoteAddr().String(),
+				"handshake_timeout", s.handshakeTimeout.String())
+		}
+		return err
+	}
+
+	c.c.SetDeadline(time.Time{})
+	//the writes after handshake can stall for clientWriteTimeout at most
+	c.pkg.WriteTimeout = s.clientWriteTimeout
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+//a client conn to kingshard whose client never sends the handshake response
+func newSilentClientConn(t *testing.T, s *Server) (*ClientConn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	co, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.newClientConn(co), client
+}
+
+func TestHandshakeLimits(t *testing.T) {
+	s := newStateServer(&config.Config{})
+	s.counter = new(Counter)
+	s.parseHandshakeLimits()
+	if s.handshakeTimeout != DefaultHandshakeTimeout*time.Second || s.maxHandshakeConns != DefaultMaxHandshakeConns ||
+		s.clientWriteTimeout != DefaultClientWriteTimeout*time.Second {
+		t.Fatal(s.handshakeTimeout, s.maxHandshakeConns, s.clientWriteTimeout)
+	}
+	s.getState().cfg.HandshakeTimeout = -1
+	s.getState().cfg.MaxHandshakeConns = 1
+	s.getState().cfg.ClientWriteTimeout = -1
+	s.parseHandshakeLimits()
+	if s.handshakeTimeout != 0 || s.maxHandshakeConns != 1 || s.clientWriteTimeout != 0 {
+		t.Fatal(s.handshakeTimeout, s.maxHandshakeConns, s.clientWriteTimeout)
+	}
+
+	//the client never completing the handshake is dropped
+	s.handshakeTimeout = 100 * time.Millisecond
+	c, client := newSilentClientConn(t, s)
+	defer client.Close()
+	defer c.Close()
+	start := time.Now()
+	if err := s.handshake(c); err == nil {
+		t.Fatal("handshake must time out")
+	}
+	if time.Second < time.Since(start) {
+		t.Fatal(time.Since(start))
+	}
+	if s.counter.HandshakeConns != 0 || s.counter.HandshakeDrops != 1 {
+		t.Fatal(s.counter.HandshakeConns, s.counter.HandshakeDrops)
+	}
+
+	//the conns beyond max_handshake_conns are dropped at once
+	s.counter.HandshakeConns = 1
+	c2, client2 := newSilentClientConn(t, s)
+	defer client2.Close()
+	defer c2.Close()
+	err := s.handshake(c2)
+	if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_CON_COUNT_ERROR {
+		t.Fatal(err)
+	}
+	if s.counter.HandshakeConns != 1 || s.counter.HandshakeDrops != 2 {
+		t.Fatal(s.counter.HandshakeConns, s.counter.HandshakeDrops)
+	}
+}
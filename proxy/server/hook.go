@@ -0,0 +1,83 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+)
+
+//Hook is notified of the statements of clients, it is registered by
+//RegisterHook, such as by a service embedding kingshard to audit or
+//reject statements.
+type Hook interface {
+	//BeforeQuery is called before the statement is handled, the
+	//statement is rejected with the error if it is not nil
+	BeforeQuery(q *QueryInfo) error
+	//AfterQuery is called after the statement is handled, err is the
+	//error returned to the client
+	AfterQuery(q *QueryInfo, err error)
+}
+
+//QueryInfo is the statement passed to the hooks, Sql is the sql of
+//COM_QUERY or the prepared sql of COM_STMT_EXECUTE
+type QueryInfo struct {
+	ConnectionId uint32
+	User         string
+	DB           string
+	ClientAddr   string
+	Sql          string
+	Tag          string //see parseQueryTag
+	Start        time.Time
+}
+
+//RegisterHook adds h to the hooks called for every statement, it must be
+//called before Run
+func (s *Server) RegisterHook(h Hook) {
+	s.hooks = append(s.hooks, h)
+}
+
+//call handle between the hooks of the statement sql, AfterQuery of all
+//the hooks is called even if the statement is rejected
+func (c *ClientConn) runHooks(sql string, handle func() error) error {
+	hooks := c.proxy.hooks
+	if len(hooks) == 0 {
+		return handle()
+	}
+
+	q := &QueryInfo{
+		ConnectionId: c.connectionId,
+		User:         c.user,
+		DB:           c.db,
+		Sql:          sql,
+		Tag:          c.tag,
+		Start:        time.Now(),
+	}
+	if c.c != nil {
+		q.ClientAddr = c.c.RemoteAddr().String()
+	}
+	var err error
+	for _, h := range hooks {
+		if err = h.BeforeQuery(q); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = handle()
+	}
+	for _, h := range hooks {
+		h.AfterQuery(q, err)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+type testHook struct {
+	reject error
+	sqls   []string
+	errs   []error
+}
+
+func (h *testHook) BeforeQuery(q *QueryInfo) error {
+	h.sqls = append(h.sqls, q.Sql)
+	return h.reject
+}
+
+func (h *testHook) AfterQuery(q *QueryInfo, err error) {
+	h.errs = append(h.errs, err)
+}
+
+func TestHooks(t *testing.T) {
+	s := newStateServer(new(config.Config))
+	c := &ClientConn{proxy: s, state: s.getState(), user: "root"}
+	handled := 0
+	handle := func() error {
+		handled++
+		return nil
+	}
+	if err := c.runHooks("select 1", handle); err != nil || handled != 1 {
+		t.Fatal(err, handled)
+	}
+
+	h1 := new(testHook)
+	h2 := &testHook{reject: errors.New("rejected")}
+	s.RegisterHook(h1)
+	s.RegisterHook(h2)
+	if err := c.runHooks("delete from t", handle); err != h2.reject || handled != 1 {
+		t.Fatal(err, handled)
+	}
+	h2.reject = nil
+	if err := c.runHooks("select 2", handle); err != nil || handled != 2 {
+		t.Fatal(err, handled)
+	}
+	if len(h1.sqls) != 2 || h1.sqls[1] != "select 2" || len(h2.sqls) != 2 {
+		t.Fatal(h1.sqls, h2.sqls)
+	}
+	//the hooks are told the statement is rejected
+	if len(h1.errs) != 2 || h1.errs[0] == nil || h1.errs[1] != nil {
+		t.Fatal(h1.errs)
+	}
+}
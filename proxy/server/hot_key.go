@@ -0,0 +1,156 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+type HotKey struct {
+	Table     string
+	Key       string
+	Count     int64 //queries in the window
+	Throttled int64
+	LastHot   int64
+}
+
+//HotKeyDetector counts the queries of every shard key in a sliding window,
+//the window is split into buckets of one second.
+type HotKeyDetector struct {
+	sync.Mutex
+
+	window    int64
+	threshold int64
+	readLimit int64
+
+	buckets     []map[string]int64
+	bucketTimes []int64
+	hotKeys     map[string]*HotKey
+
+	//the count of keys which became hot
+	HotKeyTotal int64
+}
+
+func NewHotKeyDetector(cfg config.HotKeyConfig) *HotKeyDetector {
+	d := new(HotKeyDetector)
+	d.window = int64(cfg.Window)
+	d.threshold = int64(cfg.Threshold)
+	d.readLimit = int64(cfg.ReadLimit)
+	d.hotKeys = make(map[string]*HotKey)
+	if 0 < d.window {
+		d.buckets = make([]map[string]int64, d.window)
+		d.bucketTimes = make([]int64, d.window)
+	}
+	return d
+}
+
+func (d *HotKeyDetector) Enabled() bool {
+	return 0 < d.window && 0 < d.threshold
+}
+
+//Check records the shard key values of plan, and return
+//ErrHotKeyThrottled if a read of hot key exceeds the read limit.
+func (d *HotKeyDetector) Check(plan *router.Plan, isRead bool) error {
+	if !d.Enabled() || plan == nil || plan.Rule == nil ||
+		plan.Rule.Type == router.DefaultRuleType || len(plan.KeyValues) == 0 {
+		return nil
+	}
+
+	table := fmt.Sprintf("%s.%s", plan.Rule.DB, plan.Rule.Table)
+	now := time.Now().Unix()
+
+	var err error
+	for _, v := range plan.KeyValues {
+		if e := d.record(table, fmt.Sprintf("%v", v), isRead, now); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (d *HotKeyDetector) record(table string, key string, isRead bool, now int64) error {
+	id := table + ":" + key
+
+	d.Lock()
+	defer d.Unlock()
+
+	i := now % d.window
+	if d.bucketTimes[i] != now || d.buckets[i] == nil {
+		d.buckets[i] = make(map[string]int64)
+		d.bucketTimes[i] = now
+	}
+	d.buckets[i][id]++
+	current := d.buckets[i][id]
+
+	var count int64
+	for j := range d.buckets {
+		if d.buckets[j] != nil && now-d.bucketTimes[j] < d.window {
+			count += d.buckets[j][id]
+		}
+	}
+	if count < d.threshold {
+		return nil
+	}
+
+	hk, ok := d.hotKeys[id]
+	if !ok || d.window <= now-hk.LastHot {
+		hk = &HotKey{Table: table, Key: key}
+		d.hotKeys[id] = hk
+		d.HotKeyTotal++
+		golog.Warn("HotKeyDetector", "record", "hot key found", 0,
+			"table", table,
+			"key", key,
+			"count", count,
+			"window", d.window)
+	}
+	hk.Count = count
+	hk.LastHot = now
+
+	if isRead && 0 < d.readLimit && d.readLimit < current {
+		hk.Throttled++
+		return errors.ErrHotKeyThrottled
+	}
+	return nil
+}
+
+func (d *HotKeyDetector) GetHotKeyTotal() int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.HotKeyTotal
+}
+
+//return the keys which are hot in the last window
+func (d *HotKeyDetector) GetHotKeys() []HotKey {
+	now := time.Now().Unix()
+	d.Lock()
+	defer d.Unlock()
+
+	keys := make([]HotKey, 0, len(d.hotKeys))
+	for id, hk := range d.hotKeys {
+		if d.window <= now-hk.LastHot {
+			delete(d.hotKeys, id)
+			continue
+		}
+		keys = append(keys, *hk)
+	}
+	return keys
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+)
+
+func TestHotKeyRecord(t *testing.T) {
+	d := NewHotKeyDetector(config.HotKeyConfig{Window: 3, Threshold: 4, ReadLimit: 2})
+	if !d.Enabled() {
+		t.Fatal("detector should be enabled")
+	}
+
+	var now int64 = 1000
+	for i := 0; i < 3; i++ {
+		if err := d.record("kingshard.orders", "1", false, now+int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.hotKeys) != 0 {
+		t.Fatal(len(d.hotKeys))
+	}
+
+	//the first bucket is out of the window
+	if err := d.record("kingshard.orders", "1", false, now+3); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.hotKeys) != 0 {
+		t.Fatal(len(d.hotKeys))
+	}
+
+	//4 queries in [now+1, now+3]
+	if err := d.record("kingshard.orders", "1", true, now+3); err != nil {
+		t.Fatal(err)
+	}
+	if d.GetHotKeyTotal() != 1 {
+		t.Fatal(d.GetHotKeyTotal())
+	}
+	if err := d.record("kingshard.orders", "1", true, now+3); err != errors.ErrHotKeyThrottled {
+		t.Fatal(err)
+	}
+	hk := d.hotKeys["kingshard.orders:1"]
+	if hk.Count != 5 || hk.Throttled != 1 || hk.LastHot != now+3 {
+		t.Fatal(hk.Count, hk.Throttled, hk.LastHot)
+	}
+
+	//writes are never throttled
+	if err := d.record("kingshard.orders", "1", false, now+3); err != nil {
+		t.Fatal(err)
+	}
+	if d.GetHotKeyTotal() != 1 {
+		t.Fatal(d.GetHotKeyTotal())
+	}
+
+	if NewHotKeyDetector(config.HotKeyConfig{}).Enabled() {
+		t.Fatal("detector should be disabled")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+)
+
+//waitIdleTx waits for the next command of client in a transaction which
+//holds backend conns. If the client sends nothing for idle_in_tx_timeout,
+//the transaction is rolled back to release its locks in backend, and the
+//session goes on out of transaction. The data peeked is kept for
+//readPacket. A prepared xa branch is never rolled back, its outcome is
+//decided by the coordinator.
+func (c *ClientConn) waitIdleTx() error {
+	timeout := c.state.cfg.IdleInTxTimeout
+	if timeout <= 0 || len(c.txConns) == 0 || c.xa == TxXAPrepared {
+		return nil
+	}
+
+	c.c.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	err := c.pkg.Peek()
+	c.c.SetReadDeadline(time.Time{})
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		return err
+	}
+
+	var nodeName string
+	if n := c.txNode(); n != nil {
+		nodeName = n.Cfg.Name
+	}
+	golog.Warn("ClientConn", "waitIdleTx", "rollback idle transaction", c.connectionId,
+		"state", c.txState().String(),
+		"node", nodeName,
+		"timeout", timeout)
+	c.proxy.counter.IncrIdleTxKills()
+	if err := c.rollback(); err != nil {
+		golog.Error("ClientConn", "waitIdleTx", err.Error(), c.connectionId)
+	}
+	c.idleTxKilled = true
+	return nil
+}
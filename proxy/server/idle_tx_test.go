@@ -0,0 +1,66 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestIdleTxTimeout(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, "idle_in_tx_timeout : 1\n"+fakeBackendConfig)
+	defer close()
+
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (2)"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if !hasQuery(backends[0].Queries(), "rollback") {
+		t.Fatal(backends[0].Queries())
+	}
+	if n := atomic.LoadInt64(&s.counter.IdleTxKills); n != 1 {
+		t.Fatal(n)
+	}
+
+	_, err := c.Execute("insert into t(id) values (3)")
+	expectSqlError(t, err, mysql.ER_KS_IDLE_TX_KILLED, "idle in transaction timeout")
+	//the session is out of transaction, the statements on any node are allowed
+	if _, err := c.Execute("insert into t(id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	//a prepared xa branch is kept
+	for _, sql := range []string{"xa start 'x1'", "insert into t(id) values (2)", "xa end 'x1'", "xa prepare 'x1'"} {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := c.Execute("xa commit 'x1'"); err != nil {
+		t.Fatal(err)
+	}
+	if hasQuery(backends[0].Queries(), "xa rollback") {
+		t.Fatal(backends[0].Queries())
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+)
+
+const DefaultClientKeepAlive = 15 //seconds
+
+//the tcp keepalive of client conns, 0 idle means disabled
+type clientKeepAlive struct {
+	idle     time.Duration
+	interval time.Duration
+	count    int
+}
+
+//0 means the default and a negative client_keepalive disables it
+func (s *Server) parseClientKeepAlive() error {
+	cfg := s.getState().cfg
+	s.clientKeepAlive = clientKeepAlive{}
+	if cfg.ClientKeepAlive < 0 {
+		return nil
+	}
+	if cfg.ClientKeepAliveInterval < 0 {
+		return fmt.Errorf("invalid client_keepalive_interval %d", cfg.ClientKeepAliveInterval)
+	}
+	if cfg.ClientKeepAliveCount < 0 {
+		return fmt.Errorf("invalid client_keepalive_count %d", cfg.ClientKeepAliveCount)
+	}
+	if !keepAliveProbesSupported && (cfg.ClientKeepAliveInterval != 0 || cfg.ClientKeepAliveCount != 0) {
+		return fmt.Errorf("client_keepalive_interval and client_keepalive_count are not supported on this os")
+	}
+
+	ka := clientKeepAlive{
+		idle:  DefaultClientKeepAlive * time.Second,
+		count: cfg.ClientKeepAliveCount,
+	}
+	if 0 < cfg.ClientKeepAlive {
+		ka.idle = time.Duration(cfg.ClientKeepAlive) * time.Second
+	}
+	ka.interval = ka.idle
+	if 0 < cfg.ClientKeepAliveInterval {
+		ka.interval = time.Duration(cfg.ClientKeepAliveInterval) * time.Second
+	}
+	s.clientKeepAlive = ka
+	return nil
+}
+
+//a client whose host is down or unreachable sends no FIN, it fails the
+//keepalive probes, then the peek of watchClient returns an error and the
+//statement running is killed, instead of running until the result is
+//written to the dead client.
+func (s *Server) setClientKeepAlive(conn *net.TCPConn) {
+	ka := s.clientKeepAlive
+	if ka.idle == 0 {
+		conn.SetKeepAlive(false)
+		return
+	}
+	err := conn.SetKeepAlive(true)
+	if err == nil {
+		err = conn.SetKeepAlivePeriod(ka.idle)
+	}
+	if err == nil {
+		err = setKeepAliveProbes(conn, ka.interval, ka.count)
+	}
+	if err != nil {
+		golog.Warn("server", "setClientKeepAlive", err.Error(), 0,
+			"remoteAddr", conn.RemoteAddr().String())
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+const keepAliveProbesSupported = true
+
+//set the interval between the keepalive probes, and the probes unanswered
+//before the conn is dropped if count is not 0. The options are set on the
+//fd duplicated by File, which shares the socket with conn.
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	f, err := conn.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	//File puts the shared socket into blocking mode before go 1.11
+	defer syscall.SetNonblock(fd, true)
+
+	secs := int((interval + time.Second - 1) / time.Second)
+	if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); err != nil {
+		return err
+	}
+	if count != 0 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
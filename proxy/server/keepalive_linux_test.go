@@ -0,0 +1,75 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+func getTCPOpt(t *testing.T, conn *net.TCPConn, level int, opt int) int {
+	f, err := conn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	defer syscall.SetNonblock(fd, true)
+	v, err := syscall.GetsockoptInt(fd, level, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	s := newStateServer(&config.Config{
+		ClientKeepAlive:         5,
+		ClientKeepAliveInterval: 2,
+		ClientKeepAliveCount:    3,
+	})
+	if err := s.parseClientKeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	c, client := newSilentClientConn(t, s)
+	defer client.Close()
+	defer c.Close()
+	conn := c.c.(*net.TCPConn)
+	if v := getTCPOpt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v == 0 {
+		t.Fatal("keepalive must be on")
+	}
+	if v := getTCPOpt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); v != 5 {
+		t.Fatal(v)
+	}
+	if v := getTCPOpt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); v != 2 {
+		t.Fatal(v)
+	}
+	if v := getTCPOpt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT); v != 3 {
+		t.Fatal(v)
+	}
+
+	//the keepalive is off if disabled
+	s.getState().cfg.ClientKeepAlive = -1
+	s.parseClientKeepAlive()
+	c2, client2 := newSilentClientConn(t, s)
+	defer client2.Close()
+	defer c2.Close()
+	if v := getTCPOpt(t, c2.c.(*net.TCPConn), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v != 0 {
+		t.Fatal(v)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+)
+
+func TestParseClientKeepAlive(t *testing.T) {
+	s := newStateServer(&config.Config{})
+	if err := s.parseClientKeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	ka := s.clientKeepAlive
+	if ka.idle != DefaultClientKeepAlive*time.Second || ka.interval != ka.idle || ka.count != 0 {
+		t.Fatal(ka)
+	}
+
+	s.getState().cfg.ClientKeepAlive = 30
+	if err := s.parseClientKeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	if ka = s.clientKeepAlive; ka.idle != 30*time.Second || ka.interval != ka.idle {
+		t.Fatal(ka)
+	}
+
+	s.getState().cfg.ClientKeepAliveInterval = -1
+	if err := s.parseClientKeepAlive(); err == nil {
+		t.Fatal("negative client_keepalive_interval must fail")
+	}
+	s.getState().cfg.ClientKeepAliveInterval = 0
+	s.getState().cfg.ClientKeepAliveCount = -1
+	if err := s.parseClientKeepAlive(); err == nil {
+		t.Fatal("negative client_keepalive_count must fail")
+	}
+
+	//a negative client_keepalive disables it
+	s.getState().cfg.ClientKeepAlive = -1
+	if err := s.parseClientKeepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	if s.clientKeepAlive.idle != 0 {
+		t.Fatal(s.clientKeepAlive)
+	}
+}
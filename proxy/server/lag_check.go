@@ -0,0 +1,124 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//checkLagLoop writes the heartbeats into the masters every interval, and
+//reads them in the slaves to measure their lags for the bounded reads. The
+//heartbeat read may be written one interval ago, so the lag measured is
+//at most one interval more than the real one.
+func (s *Server) checkLagLoop(interval time.Duration) {
+	table := s.heartbeatTable()
+	for s.isRunning() {
+		var wg sync.WaitGroup
+		for name, n := range s.GetAllNodes() {
+			wg.Add(1)
+			go func(name string, n *backend.Node) {
+				checkNodeLag(name, n, table)
+				wg.Done()
+			}(name, n)
+		}
+		wg.Wait()
+		time.Sleep(interval)
+	}
+}
+
+//write the heartbeat of node n into its master, and set the lags of its
+//slaves by the heartbeats read. The lag of a slave is unknown if the read
+//fails, and grows if the master is not written.
+func checkNodeLag(name string, n *backend.Node, table string) {
+	n.RLock()
+	master := n.Master
+	slaves := make([]*backend.DB, len(n.Slave))
+	copy(slaves, n.Slave)
+	n.RUnlock()
+
+	if master != nil {
+		if err := writeLagHeartbeat(master, table, name); err != nil {
+			golog.Warn("Server", "checkNodeLag", err.Error(), 0,
+				"node", name,
+				"db.Addr", master.Addr())
+		}
+	}
+	for _, db := range slaves {
+		if db == nil {
+			continue
+		}
+		lag, err := readLag(db, table, name)
+		if err != nil {
+			lag = -1
+		}
+		db.SetLag(lag)
+	}
+}
+
+//the table is created only if it does not exist, since the create is
+//replicated even if the table exists
+func writeLagHeartbeat(db *backend.DB, table string, node string) error {
+	co, err := db.GetConn()
+	if err != nil {
+		return err
+	}
+	defer co.Close()
+
+	sql := fmt.Sprintf("replace into %s (node, heartbeat) values ('%s', '%d')",
+		table, mysql.Escape(node), time.Now().UnixNano())
+	_, err = co.Execute(sql)
+	if e, ok := err.(*mysql.SqlError); ok && e.Code == mysql.ER_NO_SUCH_TABLE {
+		if _, err = co.Execute(fmt.Sprintf(HeartbeatTableSql, table)); err != nil {
+			return err
+		}
+		_, err = co.Execute(sql)
+	}
+	return err
+}
+
+//the lag of db is the time since the heartbeat of node was written
+func readLag(db *backend.DB, table string, node string) (time.Duration, error) {
+	co, err := db.GetConn()
+	if err != nil {
+		return 0, err
+	}
+	defer co.Close()
+
+	r, err := co.Execute(fmt.Sprintf("select heartbeat from %s where node = '%s'",
+		table, mysql.Escape(node)))
+	if err != nil {
+		return 0, err
+	}
+	if r.Resultset == nil || r.RowNumber() == 0 {
+		return 0, fmt.Errorf("no heartbeat of node %s", node)
+	}
+	v, _ := r.GetString(0, 0)
+	heartbeat, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	lag := time.Since(time.Unix(0, heartbeat))
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+)
+
+//listen on addr, with n listeners sharing addr by SO_REUSEPORT if n is
+//more than 1. Each listener is served by its own accept loop and the
+//kernel balances the new conns among them, so a single accept loop is
+//not the bottleneck when the conns are created and closed frequently.
+func newListeners(netProto string, addr string, n int) ([]net.Listener, error) {
+	if n <= 1 {
+		l, err := net.Listen(netProto, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := listenReusePort(netProto, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		//the port of addr may be 0, the others listen on the port of
+		//the first listener
+		if i == 0 {
+			addr = l.Addr().String()
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
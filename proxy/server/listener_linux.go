@@ -0,0 +1,68 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+//SO_REUSEPORT of linux 3.9+, which is not defined in syscall
+const soReusePort = 0xf
+
+func listenReusePort(netProto string, addr string) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr(netProto, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var family int
+	var sa syscall.Sockaddr
+	if ip := tcpAddr.IP.To4(); ip != nil || tcpAddr.IP == nil {
+		sa4 := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip)
+		family, sa = syscall.AF_INET, sa4
+	} else {
+		sa6 := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], tcpAddr.IP.To16())
+		family, sa = syscall.AF_INET6, sa6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err == nil {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1)
+	}
+	if err == nil {
+		err = syscall.Bind(fd, sa)
+	}
+	if err == nil {
+		err = syscall.Listen(fd, syscall.SOMAXCONN)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("listen %s with SO_REUSEPORT: %s", addr, err.Error())
+	}
+
+	//FileListener dups the fd, so the file is closed anyway
+	f := os.NewFile(uintptr(fd), addr)
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+//accept and close the conns until the listeners are closed
+func serveClose(listeners []net.Listener) {
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			for {
+				co, err := l.Accept()
+				if err != nil {
+					return
+				}
+				co.Close()
+			}
+		}(l)
+	}
+}
+
+func TestNewListeners(t *testing.T) {
+	listeners, err := newListeners("tcp", "127.0.0.1:0", 4)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if len(listeners) != 4 {
+		t.Fatal(len(listeners))
+	}
+	for _, l := range listeners[1:] {
+		if l.Addr().String() != listeners[0].Addr().String() {
+			t.Fatal(l.Addr(), listeners[0].Addr())
+		}
+	}
+
+	serveClose(listeners)
+	for i := 0; i < 16; i++ {
+		co, err := net.Dial("tcp", listeners[0].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		co.Close()
+	}
+}
+
+func benchmarkAccept(b *testing.B, acceptors int) {
+	listeners, err := newListeners("tcp", "127.0.0.1:0", acceptors)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	serveClose(listeners)
+	addr := listeners[0].Addr().String()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			co, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			co.Close()
+		}
+	})
+}
+
+func BenchmarkAccept1(b *testing.B) {
+	benchmarkAccept(b, 1)
+}
+
+func BenchmarkAccept4(b *testing.B) {
+	benchmarkAccept(b, 4)
+}
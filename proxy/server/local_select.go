@@ -0,0 +1,158 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the comment of version returned by @@version_comment
+const VersionComment = "kingshard"
+
+//the select without from, such as select 1, select @@version and select
+//database(), is answered by kingshard without a backend conn. The drivers
+//send them when connecting. It is sent to the default node as before if
+//any column can not be answered, such as select now().
+func (c *ClientConn) handleLocalSelect(sql string) (bool, error) {
+	sql = strings.TrimSpace(sql)
+	if len(sql) < 6 || !strings.EqualFold(sql[:6], "select") ||
+		strings.Contains(strings.ToLower(sql), "from") {
+		return false, nil
+	}
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return false, nil
+	}
+	sel, ok := stmt.(*sqlparser.SimpleSelect)
+	if !ok || sel.Distinct != "" {
+		return false, nil
+	}
+
+	names := make([]string, 0, len(sel.SelectExprs))
+	row := make([]interface{}, 0, len(sel.SelectExprs))
+	for _, e := range sel.SelectExprs {
+		expr, ok := e.(*sqlparser.NonStarExpr)
+		if !ok {
+			return false, nil
+		}
+		name, value, ok := c.localValue(expr.Expr)
+		if !ok {
+			return false, nil
+		}
+		if expr.As != nil {
+			name = hack.String(expr.As)
+		}
+		names = append(names, name)
+		row = append(row, value)
+	}
+
+	r, err := c.buildResultset(nil, names, [][]interface{}{row})
+	if err != nil {
+		return true, err
+	}
+	return true, c.writeResultset(c.status, r)
+}
+
+//the column name and value of expr, false if it is not answered locally
+func (c *ClientConn) localValue(expr sqlparser.Expr) (string, interface{}, bool) {
+	switch e := expr.(type) {
+	case sqlparser.NumVal:
+		s := string(e)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return s, n, true
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil && strings.IndexAny(s, "eExX") == -1 {
+			return s, mysql.Decimal(s), true
+		}
+	case sqlparser.StrVal:
+		return string(e), string(e), true
+	case *sqlparser.NullVal:
+		return "NULL", nil, true
+	case *sqlparser.ColName:
+		name := string(e.Name)
+		if e.Qualifier != nil {
+			name = string(e.Qualifier) + "." + name
+		}
+		if value, ok := c.localVariable(name); ok {
+			return name, value, true
+		}
+	case *sqlparser.FuncExpr:
+		if len(e.Exprs) != 0 || e.Distinct {
+			return "", nil, false
+		}
+		name := string(e.Name) + "()"
+		if value, ok := c.localFunc(strings.ToLower(string(e.Name))); ok {
+			return name, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func (c *ClientConn) localFunc(name string) (interface{}, bool) {
+	switch name {
+	case "database", "schema":
+		if len(c.db) == 0 {
+			return nil, true
+		}
+		return c.db, true
+	case "connection_id":
+		return int64(c.connectionId), true
+	case "version":
+		return mysql.ServerVersion, true
+	case "user", "session_user", "system_user":
+		host, _, err := net.SplitHostPort(c.c.RemoteAddr().String())
+		if err != nil {
+			host = c.c.RemoteAddr().String()
+		}
+		return c.user + "@" + host, true
+	case LastInsertIdFunc:
+		return c.lastInsertId, true
+	case "row_count":
+		return c.affectedRows, true
+	}
+	return nil, false
+}
+
+//the session variables of kingshard, such as @@autocommit and
+//@@session.autocommit. The other variables are got from the backend.
+func (c *ClientConn) localVariable(name string) (interface{}, bool) {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "@@") {
+		return nil, false
+	}
+	name = strings.TrimPrefix(name[2:], "session.")
+	switch name {
+	case "version":
+		return mysql.ServerVersion, true
+	case "version_comment":
+		return VersionComment, true
+	case "autocommit":
+		if c.status&mysql.SERVER_STATUS_AUTOCOMMIT != 0 {
+			return int64(1), true
+		}
+		return int64(0), true
+	case "character_set_client", "character_set_connection", "character_set_results":
+		return c.charset, true
+	case "collation_connection":
+		return mysql.Collations[c.collation], true
+	}
+	return nil, false
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestLocalSelect(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	r, err := c.Execute("select 1, 'a', null, @@version_comment, database() as db, @@session.autocommit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.GetInt(0, 0); n != 1 {
+		t.Fatal(n)
+	}
+	if s, _ := r.GetString(0, 1); s != "a" {
+		t.Fatal(s)
+	}
+	if null, _ := r.IsNull(0, 2); !null {
+		t.Fatal("null expected")
+	}
+	if s, _ := r.GetString(0, 3); s != VersionComment {
+		t.Fatal(s)
+	}
+	if s, _ := r.GetStringByName(0, "db"); s != "kingshard" {
+		t.Fatal(s)
+	}
+	if n, _ := r.GetIntByName(0, "@@session.autocommit"); n != 1 {
+		t.Fatal(n)
+	}
+	if r, err = c.Execute("SELECT CONNECTION_ID(), @@version"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.GetUint(0, 0); n == 0 {
+		t.Fatal(n)
+	}
+	if s, _ := r.GetString(0, 1); s != mysql.ServerVersion {
+		t.Fatal(s)
+	}
+
+	//the others are sent to the default node
+	if _, err = c.Execute("select now(), 1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range backends {
+		if hasQuery(b.Queries(), "select 1") || hasQuery(b.Queries(), "CONNECTION_ID") {
+			t.Fatal(b.Queries())
+		}
+	}
+	if !hasQuery(backends[0].Queries(), "select now(), 1") {
+		t.Fatal(backends[0].Queries())
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const (
+	ActiveRules = "active"
+	StagedRules = "staged"
+)
+
+//the rules of a config file not reloaded yet, see StageConfig
+type stagedRules struct {
+	file string
+	rule *router.Router
+}
+
+//KeyLocation is the location of a key under the active or staged rules
+type KeyLocation struct {
+	Rules string
+	*router.Location
+	Err error
+}
+
+//StageConfig parses the schema of the config file as the staged rules,
+//so the locations of keys under them can be compared with the active
+//ones by Locate before the config is reloaded
+func (s *Server) StageConfig(file string) error {
+	cfg, err := config.ParseConfigFile(file)
+	if err != nil {
+		return err
+	}
+	rule, err := router.NewRouter(&cfg.Schema)
+	if err != nil {
+		return err
+	}
+	s.stagedLock.Lock()
+	s.staged = &stagedRules{file: file, rule: rule}
+	s.stagedLock.Unlock()
+	return nil
+}
+
+func (s *Server) UnstageConfig() {
+	s.stagedLock.Lock()
+	s.staged = nil
+	s.stagedLock.Unlock()
+}
+
+//GetStagedConfig returns the config file staged, empty if none
+func (s *Server) GetStagedConfig() string {
+	s.stagedLock.Lock()
+	defer s.stagedLock.Unlock()
+	if s.staged == nil {
+		return ""
+	}
+	return s.staged.file
+}
+
+//Locate returns the location of key in db.table under the active rules,
+//and under the staged rules if a config is staged
+func (s *Server) Locate(db string, table string, key string) []KeyLocation {
+	loc, err := s.GetSchema().rule.Locate(db, table, key)
+	locs := []KeyLocation{{Rules: ActiveRules, Location: loc, Err: err}}
+
+	s.stagedLock.Lock()
+	staged := s.staged
+	s.stagedLock.Unlock()
+	if staged != nil {
+		loc, err = staged.rule.Locate(db, table, key)
+		locs = append(locs, KeyLocation{Rules: StagedRules, Location: loc, Err: err})
+	}
+	return locs
+}
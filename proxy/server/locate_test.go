@@ -0,0 +1,99 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+var locateConfigData = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : 127.0.0.1:1
+-
+    name : node2
+    user : root
+    master : 127.0.0.1:2
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [LOCATIONS]
+`
+
+func TestLocate(t *testing.T) {
+	cfg, err := config.ParseConfigData([]byte(strings.Replace(locateConfigData, "LOCATIONS", "1,1", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	locs := s.Locate("kingshard", "t", "3")
+	if len(locs) != 1 || locs[0].Rules != ActiveRules || locs[0].Err != nil {
+		t.Fatal(locs)
+	}
+	if locs[0].Node != "node2" || locs[0].SubTable != "t_0001" {
+		t.Fatal(locs[0].Location)
+	}
+
+	f, err := ioutil.TempFile("", "ks_locate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(strings.Replace(locateConfigData, "LOCATIONS", "2,2", 1))
+	f.Close()
+	if err := s.StageConfig(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetStagedConfig() != f.Name() {
+		t.Fatal(s.GetStagedConfig())
+	}
+
+	locs = s.Locate("kingshard", "t", "3")
+	if len(locs) != 2 || locs[1].Rules != StagedRules || locs[1].Err != nil {
+		t.Fatal(locs)
+	}
+	if locs[0].SubTable != "t_0001" || locs[1].Node != "node2" || locs[1].SubTable != "t_0003" {
+		t.Fatal(locs[0].Location, locs[1].Location)
+	}
+
+	s.UnstageConfig()
+	if locs = s.Locate("kingshard", "t", "3"); len(locs) != 1 {
+		t.Fatal(locs)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the types of a column which are reconciled if the shards disagree, the
+//values of them are parsed into the same go type in text protocol. The
+//type of a later position is wider.
+var compatibleTypes = [][]uint8{
+	{mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24,
+		mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG},
+	{mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_DOUBLE},
+	{mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL},
+	{mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_VAR_STRING},
+	{mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_BLOB,
+		mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB},
+}
+
+//the class and the position in class of a type, -1 if it is not in any
+func typeRank(t uint8) (int, int) {
+	for class, types := range compatibleTypes {
+		for rank, v := range types {
+			if v == t {
+				return class, rank
+			}
+		}
+	}
+	return -1, -1
+}
+
+//the wider type of a and b, false if they can not be reconciled
+func widerType(a uint8, b uint8) (uint8, bool) {
+	if a == b {
+		return a, true
+	}
+	classA, rankA := typeRank(a)
+	classB, rankB := typeRank(b)
+	if classA == -1 || classA != classB {
+		return 0, false
+	}
+	if rankA < rankB {
+		return b, true
+	}
+	return a, true
+}
+
+func newSchemaDriftError(a string, b string, format string, args ...interface{}) *mysql.SqlError {
+	return mysql.NewError(mysql.ER_KS_SCHEMA_DRIFT, fmt.Sprintf("%s between shard %s and %s: %s",
+		errors.ErrSchemaDrift.Error(), a, b, fmt.Sprintf(format, args...)))
+}
+
+//the columns of the shards may differ after a ddl is applied to a part of
+//them. The lengths, decimals and types of the same class are reconciled
+//into the widest, so the merged packets describe all the rows. The
+//different columns, types or signedness are an error, because the rows can
+//not be merged. The binary rows of prepared statements are encoded by type,
+//so binary requires the same types. wheres are the shards of rs.
+func reconcileShardFields(rs []*mysql.Result, wheres []string, binary bool) error {
+	base := -1
+	var fields []*mysql.Field
+	for i, r := range rs {
+		if r == nil || r.Resultset == nil {
+			continue
+		}
+		if base == -1 {
+			base = i
+			fields = r.Fields
+			continue
+		}
+		if len(r.Fields) != len(fields) {
+			return newSchemaDriftError(wheres[base], wheres[i],
+				"%d columns and %d columns", len(fields), len(r.Fields))
+		}
+
+		var changed []*mysql.Field
+		for j, f := range r.Fields {
+			merged, err := reconcileField(fields[j], f, binary)
+			if err != nil {
+				return newSchemaDriftError(wheres[base], wheres[i], "column %s %s", f.Name, err.Error())
+			}
+			if merged != fields[j] {
+				if changed == nil {
+					changed = make([]*mysql.Field, len(fields))
+					copy(changed, fields)
+				}
+				changed[j] = merged
+			}
+		}
+		if changed != nil {
+			fields = changed
+		}
+	}
+	if base != -1 {
+		rs[base].Fields = fields
+	}
+	return nil
+}
+
+//check the fields of a shard streamed after the fields of the first shard
+//are written, which can not be widened any more. The lengths, decimals
+//and nullability may differ, the types must be the same.
+func checkStreamFields(first []*mysql.Field, fields []*mysql.Field, a string, b string) error {
+	if len(fields) != len(first) {
+		return newSchemaDriftError(a, b, "%d columns and %d columns", len(first), len(fields))
+	}
+	for j, f := range fields {
+		merged, err := reconcileField(first[j], f, false)
+		if err == nil && merged.Type != first[j].Type {
+			err = fmt.Errorf("has type %d and %d", first[j].Type, f.Type)
+		}
+		if err != nil {
+			return newSchemaDriftError(a, b, "column %s %s", f.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+//reconcile the column f of a shard into base, base is returned if f is
+//described by it, or a copy of base is changed and returned
+func reconcileField(base *mysql.Field, f *mysql.Field, binary bool) (*mysql.Field, error) {
+	if !bytes.Equal(base.Name, f.Name) {
+		return nil, fmt.Errorf("is named %s in the other shard", base.Name)
+	}
+	if base.Flag&mysql.UNSIGNED_FLAG != f.Flag&mysql.UNSIGNED_FLAG {
+		return nil, fmt.Errorf("has different signedness")
+	}
+	t, ok := widerType(base.Type, f.Type)
+	if !ok || (binary && base.Type != f.Type) {
+		return nil, fmt.Errorf("has type %d and %d", base.Type, f.Type)
+	}
+
+	flag := base.Flag
+	if f.Flag&mysql.NOT_NULL_FLAG == 0 {
+		flag &^= mysql.NOT_NULL_FLAG
+	}
+	if t == base.Type && flag == base.Flag &&
+		base.ColumnLength >= f.ColumnLength && base.Decimal >= f.Decimal {
+		return base, nil
+	}
+
+	merged := *base
+	merged.Data = nil
+	merged.Type = t
+	merged.Flag = flag
+	if merged.ColumnLength < f.ColumnLength {
+		merged.ColumnLength = f.ColumnLength
+	}
+	if merged.Decimal < f.Decimal {
+		merged.Decimal = f.Decimal
+	}
+	return &merged, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func newShardResult(fields ...*mysql.Field) *mysql.Result {
+	return &mysql.Result{Resultset: &mysql.Resultset{Fields: fields}}
+}
+
+func TestReconcileShardFields(t *testing.T) {
+	wheres := []string{"node1.t_0000", "node2.t_0001"}
+	id := &mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONG, Flag: mysql.NOT_NULL_FLAG,
+		ColumnLength: 11, Data: []byte("raw")}
+	name := &mysql.Field{Name: []byte("name"), Type: mysql.MYSQL_TYPE_VAR_STRING, ColumnLength: 64}
+
+	//the widened column of a shard
+	rs := []*mysql.Result{
+		newShardResult(id, name),
+		newShardResult(
+			&mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONGLONG, ColumnLength: 20},
+			&mysql.Field{Name: []byte("name"), Type: mysql.MYSQL_TYPE_VAR_STRING, ColumnLength: 255}),
+	}
+	if err := reconcileShardFields(rs, wheres, false); err != nil {
+		t.Fatal(err)
+	}
+	f := rs[0].Fields[0]
+	if f.Type != mysql.MYSQL_TYPE_LONGLONG || f.ColumnLength != 20 || f.Flag&mysql.NOT_NULL_FLAG != 0 || f.Data != nil {
+		t.Fatalf("bad reconciled field %+v", f)
+	}
+	if rs[0].Fields[1].ColumnLength != 255 {
+		t.Fatalf("bad reconciled length %d", rs[0].Fields[1].ColumnLength)
+	}
+	if id.Type != mysql.MYSQL_TYPE_LONG || string(id.Data) != "raw" {
+		t.Fatal("the field of shard is changed")
+	}
+
+	//the same columns are kept
+	rs = []*mysql.Result{nil, newShardResult(name), newShardResult(name)}
+	if err := reconcileShardFields(rs, []string{"a", "b", "c"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if rs[1].Fields[0] != name {
+		t.Fatal("the same field is copied")
+	}
+
+	tests := []struct {
+		rs     []*mysql.Result
+		binary bool
+		expect string
+	}{
+		{[]*mysql.Result{newShardResult(id, name), newShardResult(id)}, false, "2 columns and 1 columns"},
+		{[]*mysql.Result{newShardResult(id), newShardResult(name)}, false, "column name is named id"},
+		{[]*mysql.Result{newShardResult(id),
+			newShardResult(&mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONG, Flag: mysql.UNSIGNED_FLAG})},
+			false, "signedness"},
+		{[]*mysql.Result{newShardResult(id),
+			newShardResult(&mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_VAR_STRING})},
+			false, "has type 3 and 253"},
+		//the binary rows are encoded by type
+		{[]*mysql.Result{newShardResult(id),
+			newShardResult(&mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONGLONG})},
+			true, "has type 3 and 8"},
+	}
+	for i, test := range tests {
+		err := reconcileShardFields(test.rs, wheres, test.binary)
+		e, ok := err.(*mysql.SqlError)
+		if !ok || e.Code != mysql.ER_KS_SCHEMA_DRIFT {
+			t.Fatalf("%d expect schema drift, got %v", i, err)
+		}
+		if !strings.Contains(e.Message, "between shard node1.t_0000 and node2.t_0001") ||
+			!strings.Contains(e.Message, test.expect) {
+			t.Fatalf("%d bad message %s", i, e.Message)
+		}
+	}
+}
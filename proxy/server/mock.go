@@ -0,0 +1,138 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+type MockRule struct {
+	Name        string
+	Fingerprint string
+	Pattern     *regexp.Regexp
+	Columns     []string
+	Rows        [][]string
+
+	Hits int64
+}
+
+//SqlMocker answers the sqls matching the mock rules in kingshard,
+//without sending them to the backend.
+type SqlMocker struct {
+	rules []*MockRule
+}
+
+func NewSqlMocker(cfgs []config.MockRuleConfig) (*SqlMocker, error) {
+	m := new(SqlMocker)
+	m.rules = make([]*MockRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		if len(cfg.Fingerprint) == 0 && len(cfg.Pattern) == 0 {
+			return nil, fmt.Errorf("mock rule %d has no fingerprint or pattern", i)
+		}
+
+		rule := &MockRule{
+			Name:    cfg.Name,
+			Columns: cfg.Columns,
+			Rows:    cfg.Rows,
+		}
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule_%d", i)
+		}
+		for j, row := range rule.Rows {
+			if len(row) != len(rule.Columns) {
+				return nil, fmt.Errorf("mock rule %s: row %d has %d column not equal %d",
+					rule.Name, j, len(row), len(rule.Columns))
+			}
+		}
+		if len(cfg.Fingerprint) != 0 {
+			rule.Fingerprint = mysql.GetFingerprint(cfg.Fingerprint)
+		}
+		if len(cfg.Pattern) != 0 {
+			re, err := regexp.Compile(cfg.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("mock rule %s: %v", rule.Name, err)
+			}
+			rule.Pattern = re
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+//return the first rule matching sql, nil if no rule matches
+func (m *SqlMocker) Match(sql string) *MockRule {
+	if len(m.rules) == 0 {
+		return nil
+	}
+
+	var fingerprint string
+	for _, rule := range m.rules {
+		if len(rule.Fingerprint) != 0 {
+			if len(fingerprint) == 0 {
+				fingerprint = mysql.GetFingerprint(sql)
+			}
+			if fingerprint != rule.Fingerprint {
+				continue
+			}
+		}
+		if rule.Pattern != nil && !rule.Pattern.MatchString(sql) {
+			continue
+		}
+		atomic.AddInt64(&rule.Hits, 1)
+		return rule
+	}
+	return nil
+}
+
+func (m *SqlMocker) GetRules() []MockRule {
+	rules := make([]MockRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		rules = append(rules, MockRule{
+			Name:        rule.Name,
+			Fingerprint: rule.Fingerprint,
+			Pattern:     rule.Pattern,
+			Columns:     rule.Columns,
+			Rows:        rule.Rows,
+			Hits:        atomic.LoadInt64(&rule.Hits),
+		})
+	}
+	return rules
+}
+
+//answer the sql with the result of mock rule
+func (c *ClientConn) writeMockResult(rule *MockRule) error {
+	if len(rule.Columns) == 0 {
+		return c.writeOK(nil)
+	}
+
+	values := make([][]interface{}, len(rule.Rows))
+	for i := range rule.Rows {
+		values[i] = make([]interface{}, len(rule.Columns))
+		for j := range rule.Rows[i] {
+			values[i][j] = rule.Rows[i][j]
+		}
+	}
+
+	r, err := c.buildResultset(nil, rule.Columns, values)
+	if err != nil {
+		return err
+	}
+	return c.writeResultset(c.status, r)
+}
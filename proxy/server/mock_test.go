@@ -0,0 +1,82 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+func TestSqlMock(t *testing.T) {
+	cfgs := []config.MockRuleConfig{
+		{
+			Name:        "version_comment",
+			Fingerprint: "select @@version_comment limit 1",
+			Columns:     []string{"@@version_comment"},
+			Rows:        [][]string{{"kingshard"}},
+		},
+		{
+			Name:    "health_check",
+			Pattern: `(?i)^select\s+1$`,
+			Columns: []string{"1"},
+			Rows:    [][]string{{"1"}},
+		},
+		{
+			Pattern: `(?i)^set\s+session\s+tx_read_only`,
+		},
+	}
+	m, err := NewSqlMocker(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql  string
+		name string
+	}{
+		{"SELECT @@version_comment LIMIT 1", "version_comment"},
+		{"select 1", "health_check"},
+		{"select 1 from t", ""},
+		{"set session tx_read_only = 0", "rule_2"},
+	}
+	for _, test := range tests {
+		rule := m.Match(test.sql)
+		if test.name == "" {
+			if rule != nil {
+				t.Fatal(test.sql, rule.Name)
+			}
+			continue
+		}
+		if rule == nil || rule.Name != test.name {
+			t.Fatal(test.sql, rule)
+		}
+	}
+
+	for _, rule := range m.GetRules() {
+		if rule.Hits != 1 {
+			t.Fatal(rule.Name, rule.Hits)
+		}
+	}
+
+	_, err = NewSqlMocker([]config.MockRuleConfig{{
+		Pattern: "select 1",
+		Columns: []string{"1"},
+		Rows:    [][]string{{"1", "2"}},
+	}})
+	if err == nil {
+		t.Fatal("row with wrong columns should fail")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the policy of the sql which can not be parsed
+const (
+	ParseFailReject  = "reject"  //return the parse error to client
+	ParseFailDefault = "default" //send to the default node, select to slave
+	ParseFailMaster  = "master"  //send to the master of default node
+)
+
+const (
+	//the max count of parse failure fingerprints recorded
+	MaxParseFailCount = 1024
+)
+
+type ParseFail struct {
+	Fingerprint string
+	Count       int64
+	LastError   string
+	LastTime    int64
+}
+
+type parseFailList []ParseFail
+
+func (l parseFailList) Len() int           { return len(l) }
+func (l parseFailList) Less(i, j int) bool { return l[i].Count > l[j].Count }
+func (l parseFailList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+//ParseFailStats records the sqls which can not be parsed by fingerprint
+type ParseFailStats struct {
+	sync.Mutex
+	fails map[string]*ParseFail
+	total int64
+}
+
+func NewParseFailStats() *ParseFailStats {
+	s := new(ParseFailStats)
+	s.fails = make(map[string]*ParseFail)
+	return s
+}
+
+func (s *ParseFailStats) Record(sql string, err error) {
+	fingerprint := mysql.GetFingerprint(sql)
+	now := time.Now().Unix()
+
+	s.Lock()
+	defer s.Unlock()
+	s.total++
+	f, ok := s.fails[fingerprint]
+	if !ok {
+		if MaxParseFailCount <= len(s.fails) {
+			return
+		}
+		f = &ParseFail{Fingerprint: fingerprint}
+		s.fails[fingerprint] = f
+	}
+	f.Count++
+	f.LastError = err.Error()
+	f.LastTime = now
+}
+
+func (s *ParseFailStats) GetTotal() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.total
+}
+
+//return the parse failures, the most frequent first
+func (s *ParseFailStats) GetParseFails() []ParseFail {
+	s.Lock()
+	fails := make([]ParseFail, 0, len(s.fails))
+	for _, f := range s.fails {
+		fails = append(fails, *f)
+	}
+	s.Unlock()
+
+	sort.Sort(parseFailList(fails))
+	return fails
+}
+
+func checkParseFailPolicy(policy string) error {
+	switch policy {
+	case "", ParseFailReject, ParseFailDefault, ParseFailMaster:
+		return nil
+	}
+	return fmt.Errorf("invalid parse_fail_policy %s", policy)
+}
+
+//handle the sql which can not be parsed according to parse_fail_policy
+func (c *ClientConn) handleParseFail(sql string, parseErr error) error {
+	c.proxy.parseFails.Record(sql, parseErr)
+
+	policy := c.state.cfg.ParseFailPolicy
+	if policy == "" || policy == ParseFailReject {
+		return mysql.NewError(mysql.ER_PARSE_ERROR, parseErr.Error())
+	}
+
+	tokens := strings.FieldsFunc(sql, hack.IsSqlSep)
+	fromSlave := policy == ParseFailDefault && !c.isInTransaction() &&
+		0 < len(tokens) && strings.ToLower(tokens[0]) == mysql.TK_STR_SELECT
+	return c.executeInDefaultNode(sql, fromSlave)
+}
+
+//execute sql verbatim in the default node
+func (c *ClientConn) executeInDefaultNode(sql string, fromSlave bool) error {
+	if err := c.checkRowFilterRaw(sql); err != nil {
+		return err
+	}
+	executeDB := new(ExecuteDB)
+	executeDB.sql = sql
+	executeDB.IsSlave = fromSlave
+
+	defaultRule := c.schema.rule.DefaultRule
+	if len(defaultRule.Nodes) == 0 {
+		return errors.ErrNoDefaultNode
+	}
+	executeDB.ExecNode = c.getNode(defaultRule.Nodes[0])
+	if err := c.checkTxNodes(executeDB.ExecNode); err != nil {
+		return err
+	}
+
+	return c.handleExecuteDB(executeDB)
+}
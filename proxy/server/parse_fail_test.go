@@ -0,0 +1,47 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFailStats(t *testing.T) {
+	s := NewParseFailStats()
+	s.Record("select * from t lateral (select 1) where id = 1", errors.New("syntax error"))
+	s.Record("select * from t lateral (select 1) where id = 2", errors.New("syntax error 2"))
+	s.Record("load xml infile 'a.xml' into table t", errors.New("syntax error"))
+
+	if s.GetTotal() != 3 {
+		t.Fatal(s.GetTotal())
+	}
+	fails := s.GetParseFails()
+	if len(fails) != 2 {
+		t.Fatal(len(fails))
+	}
+	if fails[0].Count != 2 || fails[0].LastError != "syntax error 2" {
+		t.Fatal(fails[0])
+	}
+
+	for _, policy := range []string{"", ParseFailReject, ParseFailDefault, ParseFailMaster} {
+		if err := checkParseFailPolicy(policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkParseFailPolicy("slave"); err == nil {
+		t.Fatal("must err")
+	}
+}
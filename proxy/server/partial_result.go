@@ -0,0 +1,192 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+const (
+	PartialComment = "/*partial*/"
+
+	//the max count of warnings kept for show warnings
+	maxWarningCount = 64
+)
+
+//whether the select has the comment, such as /*master*/
+func hasComment(stmt *sqlparser.Select, comment string) bool {
+	for _, v := range stmt.Comments {
+		if strings.ToLower(string(v)) == comment {
+			return true
+		}
+	}
+	return false
+}
+
+//a select is in partial result mode if partial_result is set in config
+//or it has the /*partial*/ hint, the shards which fail are skipped with
+//a warning. It is not allowed in transaction.
+func (c *ClientConn) isPartialResult(stmt *sqlparser.Select) bool {
+	if c.isInTransaction() {
+		return false
+	}
+	return c.state.cfg.PartialResult || hasComment(stmt, PartialComment)
+}
+
+//get the conns of the nodes in plan, the nodes which can not be connected
+//are skipped, and the plan returned only has the sqls of the other nodes.
+func (c *ClientConn) getPartialShardConns(fromSlave bool, plan *router.Plan) (map[string]*backend.BackendConn, *router.Plan, []shardError, error) {
+	if plan == nil || len(plan.RouteNodeIndexs) == 0 {
+		return nil, nil, nil, errors.ErrNoRouteNode
+	}
+	c.setHistoryPlan(plan)
+
+	var skipped []shardError
+	conns := make(map[string]*backend.BackendConn)
+	for _, nodeName := range sortedNodeNames(plan.RewrittenSqls) {
+		co, err := c.getBackendConn(c.getNode(nodeName), fromSlave)
+		if err != nil {
+			skipped = append(skipped, newNodeErrors(plan, nodeName, err)...)
+			continue
+		}
+		conns[nodeName] = co
+	}
+	if len(skipped) == 0 {
+		return conns, plan, nil, nil
+	}
+
+	//the plan may be cached, so copy it
+	p := *plan
+	p.RewrittenSqls = make(map[string][]string, len(conns))
+	for nodeName := range conns {
+		p.RewrittenSqls[nodeName] = plan.RewrittenSqls[nodeName]
+	}
+	return conns, &p, skipped, nil
+}
+
+//the errors of all the sqls sent to a node
+func newNodeErrors(plan *router.Plan, nodeName string, err error) []shardError {
+	tables := plan.GetSubTables(nodeName)
+	if len(tables) == 0 {
+		return []shardError{{node: nodeName, err: err}}
+	}
+	errs := make([]shardError, 0, len(tables))
+	for _, table := range tables {
+		errs = append(errs, shardError{node: nodeName, table: table, err: err})
+	}
+	return errs
+}
+
+//execute the select in the healthy shards, the results of the shards which
+//fail are skipped and a warning is added for each of them. It fails only if
+//all the shards fail.
+func (c *ClientConn) executeSelectPartial(fromSlave bool, plan *router.Plan, args []interface{}) ([]*mysql.Result, error) {
+	conns, p, skipped, err := c.getPartialShardConns(fromSlave, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*mysql.Result
+	if len(conns) != 0 {
+		var errs []shardError
+		rs, errs, err = c.executeShardSqls(conns, p, args)
+		c.closeShardConns(conns, false)
+		if err != nil {
+			return nil, err
+		}
+		skipped = append(skipped, errs...)
+	}
+	if len(skipped) == 0 {
+		c.proxy.shardHeat.Record(p, rs)
+		return rs, nil
+	}
+
+	total := 0
+	for _, sqls := range plan.RewrittenSqls {
+		total += len(sqls)
+	}
+	results := make([]*mysql.Result, 0, len(rs))
+	for _, r := range rs {
+		if r != nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) == 0 {
+		return nil, newShardSqlError(skipped, total)
+	}
+
+	for _, e := range skipped {
+		c.addWarning(mysql.NewProxyError(e.err).Code,
+			fmt.Sprintf("[%s] %s, the shard is skipped", e.where(), e.message()))
+	}
+	golog.Warn("ClientConn", "executeSelectPartial", "skip the failed shards", c.connectionId,
+		"error", newShardSqlError(skipped, total).Message)
+	return results, nil
+}
+
+func (c *ClientConn) addWarning(code uint16, message string) {
+	if len(c.warnings) < maxWarningCount {
+		c.warnings = append(c.warnings, mysql.NewError(code, message))
+	} else {
+		c.droppedWarnings++
+	}
+}
+
+func (c *ClientConn) clearWarnings() {
+	c.warnings = nil
+	c.droppedWarnings = 0
+}
+
+//the warning count in the OK and EOF packets
+func (c *ClientConn) warningCount() uint16 {
+	count := len(c.warnings) + c.droppedWarnings
+	if 0xffff < count {
+		return 0xffff
+	}
+	return uint16(count)
+}
+
+func isShowWarnings(sql string) bool {
+	tokens := strings.Fields(strings.ToLower(sql))
+	return len(tokens) == 2 && tokens[0] == "show" && tokens[1] == "warnings"
+}
+
+//the warnings of kingshard are returned by show warnings right after the
+//statement, and cleared by any other statement.
+func (c *ClientConn) handleWarnings(sql string) (bool, error) {
+	if len(c.warnings) == 0 || !isShowWarnings(sql) {
+		c.clearWarnings()
+		return false, nil
+	}
+
+	names := []string{"Level", "Code", "Message"}
+	values := make([][]interface{}, 0, len(c.warnings))
+	for _, w := range c.warnings {
+		values = append(values, []interface{}{"Warning", uint64(w.Code), w.Message})
+	}
+	r, err := c.buildResultset(nil, names, values)
+	if err != nil {
+		return true, err
+	}
+	return true, c.writeResultset(c.status, r)
+}
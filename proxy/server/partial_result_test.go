@@ -0,0 +1,77 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+func TestPartialResult(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+	c.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	c.proxy = newStateServer(new(config.Config))
+	c.state = c.proxy.getState()
+	c.state.userQuota = NewUserQuota(nil)
+	c.state.nodes = map[string]*backend.Node{
+		"node1": {Cfg: config.NodeConfig{Name: "node1"}},
+		"node2": {Cfg: config.NodeConfig{Name: "node2"}},
+	}
+
+	sql := "select /*partial*/ * from test1 where id in (1, 2, 5)"
+	stmt, plan, err := c.parseAndBuildPlan(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.isPartialResult(stmt.(*sqlparser.Select)) {
+		t.Fatal("the hint should enable partial result")
+	}
+	stmt, _, _ = c.parseAndBuildPlan("select * from test1 where id = 1")
+	if c.isPartialResult(stmt.(*sqlparser.Select)) {
+		t.Fatal("partial result is not enabled")
+	}
+	c.state.cfg.PartialResult = true
+	if !c.isPartialResult(stmt.(*sqlparser.Select)) {
+		t.Fatal("the config should enable partial result")
+	}
+
+	//the nodes without master are skipped, and the cached plan is not changed
+	conns, p, skipped, err := c.getPartialShardConns(false, plan)
+	if err != nil || len(conns) != 0 || len(p.RewrittenSqls) != 0 || len(plan.RewrittenSqls) != 2 {
+		t.Fatal(err, conns, p.RewrittenSqls, plan.RewrittenSqls)
+	}
+	if len(skipped) != 3 || skipped[0].where() != "node1.test1_0001" || skipped[2].where() != "node2.test1_0005" {
+		t.Fatal(skipped)
+	}
+
+	//all the shards fail
+	_, err = c.executeSelectPartial(false, plan, nil)
+	e, ok := err.(*mysql.SqlError)
+	if !ok || e.Code != mysql.ER_KS_NO_MASTER_CONN || len(c.warnings) != 0 {
+		t.Fatal(err, c.warnings)
+	}
+
+	c.addWarning(mysql.ER_KS_NO_MASTER_CONN, "[node2.test1_0005] no master connection, the shard is skipped")
+	if c.warningCount() != 1 || !isShowWarnings("SHOW  Warnings") || isShowWarnings("show errors") {
+		t.Fatal(c.warnings)
+	}
+	if hasHandled, _ := c.handleWarnings("select 1"); hasHandled || c.warnings != nil {
+		t.Fatal("the warnings should be cleared by the next statement")
+	}
+}
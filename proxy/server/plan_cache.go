@@ -0,0 +1,148 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+type planCacheEntry struct {
+	stmt sqlparser.Statement
+	plan *router.Plan
+}
+
+//PlanCache caches the statements and plans of the recent sqls in a
+//session, so a repeated sql is not parsed and planned again. The cache
+//is cleared if the router, the db or the charset of session changes.
+type PlanCache struct {
+	size  int
+	plans map[string]*planCacheEntry
+	sqls  []string //in the order of adding, the oldest is evicted first
+
+	rule    *router.Router
+	db      string
+	charset string
+
+	Hits   int64
+	Misses int64
+}
+
+//size is the max count of cached sqls, 0 means no cache
+func NewPlanCache(size int) *PlanCache {
+	pc := new(PlanCache)
+	pc.size = size
+	pc.plans = make(map[string]*planCacheEntry)
+	return pc
+}
+
+func (pc *PlanCache) Reset(rule *router.Router, db, charset string) {
+	pc.rule = rule
+	pc.db = db
+	pc.charset = charset
+	if len(pc.sqls) != 0 {
+		pc.plans = make(map[string]*planCacheEntry)
+		pc.sqls = pc.sqls[:0]
+	}
+}
+
+func (pc *PlanCache) get(rule *router.Router, db, charset, sql string) *planCacheEntry {
+	if pc.size <= 0 {
+		return nil
+	}
+	if pc.rule != rule || pc.db != db || pc.charset != charset {
+		pc.Reset(rule, db, charset)
+		return nil
+	}
+	return pc.plans[sql]
+}
+
+//GetStmt return the cached statement of sql, nil if not cached
+func (pc *PlanCache) GetStmt(rule *router.Router, db, charset, sql string) sqlparser.Statement {
+	if e := pc.get(rule, db, charset, sql); e != nil {
+		return e.stmt
+	}
+	return nil
+}
+
+//GetPlan return the cached plan of sql, nil if not cached or the
+//statement is not the cached one
+func (pc *PlanCache) GetPlan(rule *router.Router, db, charset, sql string,
+	stmt sqlparser.Statement) *router.Plan {
+	e := pc.get(rule, db, charset, sql)
+	if e == nil || e.stmt != stmt {
+		pc.Misses++
+		return nil
+	}
+	pc.Hits++
+	return e.plan
+}
+
+func (pc *PlanCache) Put(rule *router.Router, db, charset, sql string,
+	stmt sqlparser.Statement, plan *router.Plan) {
+	if pc.size <= 0 {
+		return
+	}
+	if pc.rule != rule || pc.db != db || pc.charset != charset {
+		pc.Reset(rule, db, charset)
+	}
+	if _, ok := pc.plans[sql]; !ok {
+		if pc.size <= len(pc.sqls) {
+			delete(pc.plans, pc.sqls[0])
+			pc.sqls = pc.sqls[1:]
+		}
+		pc.sqls = append(pc.sqls, sql)
+	}
+	pc.plans[sql] = &planCacheEntry{stmt: stmt, plan: plan}
+}
+
+//parse the sql, the cached statement is returned if the sql is cached
+func (c *ClientConn) parse(sql string) (sqlparser.Statement, error) {
+	defer c.stageSince(StageParse, time.Now())
+	if c.schema == nil {
+		return sqlparser.Parse(sql)
+	}
+	if stmt := c.planCache.GetStmt(c.schema.rule, c.db, c.charset, sql); stmt != nil {
+		return stmt, nil
+	}
+	return sqlparser.Parse(sql)
+}
+
+//build the plan of stmt parsed from sql, reusing the cached plan
+func (c *ClientConn) buildPlan(sql string, stmt sqlparser.Statement) (*router.Plan, error) {
+	defer c.stageSince(StageRoute, time.Now())
+	rule := c.schema.rule
+	plan := c.planCache.GetPlan(rule, c.db, c.charset, sql, stmt)
+	if plan == nil {
+		if err := c.filterRows(stmt); err != nil {
+			return nil, err
+		}
+		var err error
+		plan, err = rule.BuildPlanWithCharset(c.db, c.charset, stmt)
+		if err != nil {
+			return nil, err
+		}
+		if !plan.Uncacheable {
+			c.planCache.Put(rule, c.db, c.charset, sql, stmt, plan)
+		}
+	}
+	//the cached plan is not pinned, PinPlan returns a copy
+	if c.shardPinned {
+		return rule.PinPlan(plan, stmt, c.pinnedShard)
+	}
+	return plan, nil
+}
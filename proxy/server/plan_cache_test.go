@@ -0,0 +1,125 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+func newPlanCacheConn(t testing.TB, size int) *ClientConn {
+	schema := config.SchemaConfig{
+		Nodes:   []string{"node1", "node2"},
+		Default: "node1",
+		ShardRule: []config.ShardConfig{{
+			DB:        "kingshard",
+			Table:     "test1",
+			Key:       "id",
+			Nodes:     []string{"node1", "node2"},
+			Locations: []int{4, 4},
+			Type:      "hash",
+		}},
+	}
+	rule, err := router.NewRouter(&schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(ClientConn)
+	c.schema = &Schema{rule: rule}
+	c.db = "kingshard"
+	c.charset = "utf8"
+	c.planCache = NewPlanCache(size)
+	return c
+}
+
+func (c *ClientConn) parseAndBuildPlan(sql string) (sqlparser.Statement, *router.Plan, error) {
+	stmt, err := c.parse(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := c.buildPlan(sql, stmt)
+	return stmt, plan, err
+}
+
+func TestPlanCache(t *testing.T) {
+	c := newPlanCacheConn(t, 2)
+
+	sql := "select * from test1 where id in (1, 5, 6)"
+	stmt, plan, err := c.parseAndBuildPlan(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt2, plan2, err := c.parseAndBuildPlan(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt2 != stmt || plan2 != plan || c.planCache.Hits != 1 {
+		t.Fatal("the plan should be cached", c.planCache.Hits)
+	}
+	if len(plan2.RewrittenSqls) != 2 {
+		t.Fatal(plan2.RewrittenSqls)
+	}
+
+	//the oldest sql is evicted
+	c.parseAndBuildPlan("select * from test1 where id = 1")
+	c.parseAndBuildPlan("select * from test1 where id = 2")
+	if _, plan2, _ = c.parseAndBuildPlan(sql); plan2 == plan {
+		t.Fatal("the plan should be evicted")
+	}
+
+	//the cache is cleared if the db changes
+	_, plan, _ = c.parseAndBuildPlan(sql)
+	c.db = "other"
+	if _, plan2, _ = c.parseAndBuildPlan(sql); plan2 == plan {
+		t.Fatal("the plan of another db should not be reused")
+	}
+
+	//or the router is reloaded
+	c.db = "kingshard"
+	_, plan, _ = c.parseAndBuildPlan(sql)
+	c.schema = &Schema{rule: newPlanCacheConn(t, 0).schema.rule}
+	if _, plan2, _ = c.parseAndBuildPlan(sql); plan2 == plan {
+		t.Fatal("the plan of old router should not be reused")
+	}
+
+	c = newPlanCacheConn(t, 0)
+	_, plan, _ = c.parseAndBuildPlan(sql)
+	if _, plan2, _ = c.parseAndBuildPlan(sql); plan2 == plan {
+		t.Fatal("the plan cache is disabled")
+	}
+}
+
+func benchmarkPlanCache(b *testing.B, size int) {
+	c := newPlanCacheConn(b, size)
+	sql := "select id, name from test1 where id in (1, 2, 3) and name = 'abc' order by id limit 10"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := c.parseAndBuildPlan(sql); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPlanCacheOff(b *testing.B) {
+	benchmarkPlanCache(b, 0)
+}
+
+func BenchmarkPlanCacheOn(b *testing.B) {
+	benchmarkPlanCache(b, 64)
+}
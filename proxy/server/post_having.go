@@ -0,0 +1,378 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//filterHaving drops the rows of the merged result which do not match the
+//having of stmt, such as having sum(amount) > 100. The columns and
+//aggregates in having must be in the select list, by the same expression
+//or its alias. The numbers are compared exactly, a NULL never matches.
+func filterHaving(r *mysql.Resultset, stmt *sqlparser.Select) error {
+	if stmt.Having == nil {
+		return nil
+	}
+	h := &havingFilter{stmt: stmt, r: r}
+	values := r.Values[:0]
+	rowDatas := r.RowDatas[:0]
+	for i, row := range r.Values {
+		h.row = row
+		v, err := h.evalBool(stmt.Having.Expr)
+		if err != nil {
+			return err
+		}
+		if v == true {
+			values = append(values, row)
+			if i < len(r.RowDatas) {
+				rowDatas = append(rowDatas, r.RowDatas[i])
+			}
+		}
+	}
+	r.Values = values
+	if r.RowDatas != nil {
+		r.RowDatas = rowDatas
+	}
+	return nil
+}
+
+//havingFilter evaluates the having to true, false or nil for NULL, the
+//values are nil, string or *big.Rat for numbers
+type havingFilter struct {
+	stmt *sqlparser.Select
+	r    *mysql.Resultset
+	row  []interface{}
+}
+
+func (h *havingFilter) evalBool(expr sqlparser.BoolExpr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *sqlparser.ParenBoolExpr:
+		return h.evalBool(e.Expr)
+	case *sqlparser.NotExpr:
+		v, err := h.evalBool(e.Expr)
+		if v == nil || err != nil {
+			return nil, err
+		}
+		return !v.(bool), nil
+	case *sqlparser.AndExpr:
+		left, err := h.evalBool(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		if left == false {
+			return false, nil
+		}
+		right, err := h.evalBool(e.Right)
+		if err != nil || right == false {
+			return right, err
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return true, nil
+	case *sqlparser.OrExpr:
+		left, err := h.evalBool(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		if left == true {
+			return true, nil
+		}
+		right, err := h.evalBool(e.Right)
+		if err != nil || right == true {
+			return right, err
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return false, nil
+	case *sqlparser.NullCheck:
+		v, err := h.eval(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) == (e.Operator == sqlparser.AST_IS_NULL), nil
+	case *sqlparser.RangeCond:
+		v, err := h.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		from, err := h.eval(e.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := h.eval(e.To)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil || from == nil || to == nil {
+			return nil, nil
+		}
+		in := 0 <= compareHavingValue(v, from) && compareHavingValue(v, to) <= 0
+		return in == (e.Operator == sqlparser.AST_BETWEEN), nil
+	case *sqlparser.ComparisonExpr:
+		return h.evalComparison(e)
+	}
+	return nil, fmt.Errorf("having %s is not supported across shards", sqlparser.String(expr))
+}
+
+func (h *havingFilter) evalComparison(e *sqlparser.ComparisonExpr) (interface{}, error) {
+	left, err := h.eval(e.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Operator {
+	case sqlparser.AST_IN, sqlparser.AST_NOT_IN:
+		tuple, ok := e.Right.(sqlparser.ValTuple)
+		if !ok {
+			break
+		}
+		if left == nil {
+			return nil, nil
+		}
+		var result interface{} = false
+		for _, expr := range tuple {
+			v, err := h.eval(expr)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				result = nil
+			} else if compareHavingValue(left, v) == 0 {
+				result = true
+				break
+			}
+		}
+		if result == nil || e.Operator == sqlparser.AST_IN {
+			return result, nil
+		}
+		return !result.(bool), nil
+	case sqlparser.AST_EQ, sqlparser.AST_NE, sqlparser.AST_NSE,
+		sqlparser.AST_LT, sqlparser.AST_LE, sqlparser.AST_GT, sqlparser.AST_GE:
+		right, err := h.eval(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if e.Operator == sqlparser.AST_NSE {
+			if left == nil || right == nil {
+				return left == nil && right == nil, nil
+			}
+			return compareHavingValue(left, right) == 0, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		n := compareHavingValue(left, right)
+		switch e.Operator {
+		case sqlparser.AST_EQ:
+			return n == 0, nil
+		case sqlparser.AST_NE:
+			return n != 0, nil
+		case sqlparser.AST_LT:
+			return n < 0, nil
+		case sqlparser.AST_LE:
+			return n <= 0, nil
+		case sqlparser.AST_GT:
+			return 0 < n, nil
+		default:
+			return 0 <= n, nil
+		}
+	}
+	return nil, fmt.Errorf("having %s is not supported across shards", sqlparser.String(e))
+}
+
+func (h *havingFilter) eval(expr sqlparser.ValExpr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *sqlparser.NullVal:
+		return nil, nil
+	case sqlparser.StrVal:
+		return string(e), nil
+	case sqlparser.NumVal:
+		n, ok := new(big.Rat).SetString(string(e))
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s in having", string(e))
+		}
+		return n, nil
+	case sqlparser.ValTuple:
+		if len(e) == 1 {
+			return h.eval(e[0])
+		}
+	case *sqlparser.UnaryExpr:
+		if i := h.columnIndex(e); i != -1 {
+			return havingValue(h.row[i]), nil
+		}
+		v, err := h.evalExpr(e.Expr)
+		if v == nil || err != nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case sqlparser.AST_UPLUS:
+			return v, nil
+		case sqlparser.AST_UMINUS:
+			return new(big.Rat).Neg(havingNumber(v)), nil
+		}
+	case *sqlparser.BinaryExpr:
+		if i := h.columnIndex(e); i != -1 {
+			return havingValue(h.row[i]), nil
+		}
+		left, err := h.evalExpr(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := h.evalExpr(e.Right)
+		if left == nil || right == nil || err != nil {
+			return nil, err
+		}
+		l, r := havingNumber(left), havingNumber(right)
+		switch e.Operator {
+		case sqlparser.AST_PLUS:
+			return new(big.Rat).Add(l, r), nil
+		case sqlparser.AST_MINUS:
+			return new(big.Rat).Sub(l, r), nil
+		case sqlparser.AST_MULT:
+			return new(big.Rat).Mul(l, r), nil
+		case sqlparser.AST_DIV:
+			//the division by zero is NULL in mysql
+			if r.Sign() == 0 {
+				return nil, nil
+			}
+			return new(big.Rat).Quo(l, r), nil
+		}
+	default:
+		if i := h.columnIndex(expr); i != -1 {
+			return havingValue(h.row[i]), nil
+		}
+		return nil, fmt.Errorf("having %s is not in the select list, which is needed across shards",
+			sqlparser.String(expr))
+	}
+	return nil, fmt.Errorf("having %s is not supported across shards", sqlparser.String(expr))
+}
+
+func (h *havingFilter) evalExpr(expr sqlparser.Expr) (interface{}, error) {
+	if e, ok := expr.(sqlparser.ValExpr); ok {
+		return h.eval(e)
+	}
+	return nil, fmt.Errorf("having %s is not supported across shards", sqlparser.String(expr))
+}
+
+//the index of expr in the select list, by the expression or its alias,
+//-1 if not found
+func (h *havingFilter) columnIndex(expr sqlparser.ValExpr) int {
+	name := sqlparser.String(expr)
+	hasStar := false
+	for i, e := range h.stmt.SelectExprs {
+		nonStar, ok := e.(*sqlparser.NonStarExpr)
+		if !ok {
+			hasStar = true
+			break
+		}
+		if strings.EqualFold(name, sqlparser.String(nonStar.Expr)) ||
+			(nonStar.As != nil && strings.EqualFold(name, hack.String(nonStar.As))) {
+			if i < len(h.row) {
+				return i
+			}
+		}
+	}
+	//the columns of select * are found by their names
+	if col, ok := expr.(*sqlparser.ColName); ok && hasStar {
+		name = string(col.Name)
+	}
+	for i, f := range h.r.Fields {
+		if strings.EqualFold(name, hack.String(f.Name)) && i < len(h.row) {
+			return i
+		}
+	}
+	return -1
+}
+
+//the value of a column, as a string or *big.Rat
+func havingValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v)
+	case uint64:
+		return new(big.Rat).SetUint64(v)
+	case float64:
+		return new(big.Rat).SetFloat64(v)
+	case mysql.Decimal:
+		if n, ok := new(big.Rat).SetString(strings.TrimSpace(string(v))); ok {
+			return n
+		}
+		return string(v)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+//the number of a value, the string is converted by its leading number as
+//mysql does, and 0 if it has none
+func havingNumber(v interface{}) *big.Rat {
+	switch v := v.(type) {
+	case *big.Rat:
+		return v
+	case string:
+		if n, ok := new(big.Rat).SetString(numberPrefix(strings.TrimSpace(v))); ok {
+			return n
+		}
+	}
+	return new(big.Rat)
+}
+
+//the leading number of s, such as 12.5 of 12.5abc
+func numberPrefix(s string) string {
+	digits := func(i int) int {
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	i = digits(i)
+	if i < len(s) && s[i] == '.' {
+		i = digits(i + 1)
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if k := digits(j); j < k {
+			i = k
+		}
+	}
+	return s[:i]
+}
+
+//compare the not NULL values, by number if any of them is a number
+func compareHavingValue(v1 interface{}, v2 interface{}) int {
+	s1, ok1 := v1.(string)
+	s2, ok2 := v2.(string)
+	if ok1 && ok2 {
+		return strings.Compare(s1, s2)
+	}
+	return havingNumber(v1).Cmp(havingNumber(v2))
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+func TestFilterHaving(t *testing.T) {
+	tests := []struct {
+		having string
+		expect []string //the uids kept
+	}{
+		{"total > 100", []string{"a", "c"}},
+		{"sum(amount) >= 90 and cnt < 2", []string{"c"}},
+		{"total between 90 and 110 or uid = 'c'", []string{"b", "c"}},
+		{"total / cnt > 50", []string{"a", "c"}},
+		{"uid in ('a', 'd') or not total <> 120", []string{"a", "c", "d"}},
+		{"cnt is null", []string{"d"}},
+		{"total + 0.5 > 110.25", []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse("select uid, sum(amount) as total, count(*) as cnt from t group by uid having " + tt.having)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := (&ClientConn{}).buildResultset(nil, []string{"uid", "total", "count(*)"}, [][]interface{}{
+			{"a", mysql.Decimal("110.50"), int64(2)},
+			{"b", mysql.Decimal("90.00"), int64(2)},
+			{"c", float64(120), int64(1)},
+			{"d", nil, nil},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = filterHaving(r, stmt.(*sqlparser.Select)); err != nil {
+			t.Fatal(tt.having, err)
+		}
+		var uids []string
+		for i := range r.Values {
+			uid, _ := r.GetString(i, 0)
+			uids = append(uids, uid)
+		}
+		if strings.Join(uids, ",") != strings.Join(tt.expect, ",") || len(r.RowDatas) != len(r.Values) {
+			t.Fatal(tt.having, uids)
+		}
+	}
+
+	stmt, _ := sqlparser.Parse("select uid from t group by uid having sum(amount) > 1")
+	r, _ := (&ClientConn{}).buildResultset(nil, []string{"uid"}, [][]interface{}{{"a"}})
+	if err := filterHaving(r, stmt.(*sqlparser.Select)); err == nil {
+		t.Fatal("the aggregate not in select list is filtered")
+	}
+}
+
+//the groups are merged from both shards before the having and limit
+func TestPostHavingMerge(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`^select`, &mysqltest.Response{
+		Names: []string{"uid", "total", "uid"},
+		Rows:  [][]interface{}{{"a", 60, "a"}, {"b", 80, "b"}, {"c", 120, "c"}},
+	})
+	backends[1].Handle(`^select`, &mysqltest.Response{
+		Names: []string{"uid", "total", "uid"},
+		Rows:  [][]interface{}{{"a", 50, "a"}, {"b", 10, "b"}},
+	})
+
+	r, err := c.Execute("select uid, sum(amount) as total from t group by uid having sum(amount) > 100 order by total limit 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, _ := r.GetString(0, 0)
+	total, _ := r.GetInt(0, 1)
+	if r.RowNumber() != 1 || uid != "a" || total != 110 {
+		t.Fatal(r.Values)
+	}
+	for _, b := range backends {
+		for _, q := range b.Queries() {
+			if strings.Contains(q, "having") || strings.Contains(q, "limit") {
+				t.Fatal(q)
+			}
+		}
+	}
+}
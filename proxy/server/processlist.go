@@ -0,0 +1,213 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+//the info of show processlist is truncated without full
+const processInfoSize = 100
+
+//processInfo is the process of a client conn shown by show processlist,
+//it is written by the conn and read by the others
+type processInfo struct {
+	sync.Mutex
+
+	db      string
+	command string
+	since   time.Time
+	info    string
+	tx      TxState
+	txNode  string
+}
+
+//Process is a client conn in show processlist
+type Process struct {
+	Id      uint32
+	User    string
+	Host    string
+	DB      string
+	Command string
+	Time    int64 //seconds in the command
+	Info    string
+	Tx      TxState
+	TxNode  string //the node which the transaction is bound to
+}
+
+var processCommands = map[byte]string{
+	mysql.COM_QUIT:         "Quit",
+	mysql.COM_QUERY:        "Query",
+	mysql.COM_PING:         "Ping",
+	mysql.COM_INIT_DB:      "Init DB",
+	mysql.COM_FIELD_LIST:   "Field List",
+	mysql.COM_STMT_PREPARE: "Prepare",
+	mysql.COM_STMT_EXECUTE: "Execute",
+	mysql.COM_STMT_CLOSE:   "Close stmt",
+	mysql.COM_STMT_RESET:   "Reset stmt",
+}
+
+//update the process at the start of a command
+func (c *ClientConn) beginProcess(cmd byte, data []byte) {
+	command, ok := processCommands[cmd]
+	if !ok {
+		command = "Unknown"
+	}
+	var info string
+	switch cmd {
+	case mysql.COM_QUERY, mysql.COM_STMT_PREPARE:
+		info = string(data)
+	case mysql.COM_STMT_EXECUTE:
+		info = c.stmtSql(data)
+	}
+	c.setProcess(command, info)
+}
+
+//update the process at the end of a command, with its transaction state
+func (c *ClientConn) endProcess() {
+	c.setProcess("Sleep", "")
+}
+
+func (c *ClientConn) setProcess(command string, info string) {
+	var txNode string
+	if n := c.txNode(); n != nil {
+		txNode = n.Cfg.Name
+	}
+	p := &c.process
+	p.Lock()
+	p.db = c.db
+	p.command = command
+	p.since = time.Now()
+	p.info = info
+	p.tx = c.txState()
+	p.txNode = txNode
+	p.Unlock()
+}
+
+func (s *Server) addClient(c *ClientConn) {
+	s.clientsLock.Lock()
+	s.clients[c.connectionId] = c
+	s.clientsLock.Unlock()
+}
+
+func (s *Server) removeClient(c *ClientConn) {
+	s.clientsLock.Lock()
+	delete(s.clients, c.connectionId)
+	s.clientsLock.Unlock()
+}
+
+//close the conns of the clients, their sessions end as the reads fail
+func (s *Server) closeClients() {
+	s.clientsLock.Lock()
+	for _, c := range s.clients {
+		c.c.Close()
+	}
+	s.clientsLock.Unlock()
+}
+
+//Processes returns the client conns in the order of id
+func (s *Server) Processes() []Process {
+	s.clientsLock.Lock()
+	clients := make([]*ClientConn, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.clientsLock.Unlock()
+
+	now := time.Now()
+	processes := make([]Process, 0, len(clients))
+	for _, c := range clients {
+		p := &c.process
+		p.Lock()
+		processes = append(processes, Process{
+			Id:      c.connectionId,
+			User:    c.user,
+			Host:    c.c.RemoteAddr().String(),
+			DB:      p.db,
+			Command: p.command,
+			Time:    int64(now.Sub(p.since) / time.Second),
+			Info:    p.info,
+			Tx:      p.tx,
+			TxNode:  p.txNode,
+		})
+		p.Unlock()
+	}
+	sort.Sort(processesById(processes))
+	return processes
+}
+
+type processesById []Process
+
+func (p processesById) Len() int           { return len(p) }
+func (p processesById) Less(i, j int) bool { return p[i].Id < p[j].Id }
+func (p processesById) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+//the count of client conns in each transaction state
+func (s *Server) TxStateCounts() map[TxState]int {
+	counts := make(map[TxState]int)
+	for _, p := range s.Processes() {
+		counts[p.Tx]++
+	}
+	return counts
+}
+
+func isShowProcesslist(sql string) bool {
+	tokens := strings.Fields(strings.ToLower(sql))
+	switch len(tokens) {
+	case 2:
+		return tokens[0] == "show" && tokens[1] == "processlist"
+	case 3:
+		return tokens[0] == "show" && tokens[1] == "full" && tokens[2] == "processlist"
+	}
+	return false
+}
+
+//show processlist returns the client conns of kingshard of the same user,
+//with the transaction state and the node bound to of each conn
+func (c *ClientConn) handleShowProcesslist(sql string) error {
+	full := len(strings.Fields(sql)) == 3
+	names := []string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info", "Tx", "TxNode"}
+	var values [][]interface{}
+	for _, p := range c.proxy.Processes() {
+		if p.User != c.user {
+			continue
+		}
+		//the process of the conn itself is not updated yet
+		command, info, state := p.Command, p.Info, ""
+		if p.Id == c.connectionId {
+			command, info = "Query", sql
+		}
+		if command != "Sleep" {
+			state = "executing"
+		}
+		if !full && processInfoSize < len(info) {
+			info = info[:processInfoSize]
+		}
+		values = append(values, []interface{}{
+			uint64(p.Id), p.User, p.Host, p.DB, command, p.Time, state, info,
+			p.Tx.String(), p.TxNode,
+		})
+	}
+	r, err := c.buildResultset(nil, names, values)
+	if err != nil {
+		return err
+	}
+	return c.writeResultset(c.status, r)
+}
@@ -0,0 +1,501 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+//the protocol conformance of the client conns, by the packets a client
+//sends and receives
+
+//rawClient speaks the protocol to kingshard packet by packet
+type rawClient struct {
+	conn       net.Conn
+	pkg        *mysql.PacketIO
+	capability uint32 //of the server
+	salt       []byte
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &rawClient{conn: conn, pkg: mysql.NewPacketIO(conn)}
+	data := c.readPacket(t)
+	if data[0] != 10 {
+		t.Fatalf("protocol version %d", data[0])
+	}
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1 + 4
+	c.salt = append(c.salt, data[pos:pos+8]...)
+	pos += 8 + 1
+	c.capability = uint32(binary.LittleEndian.Uint16(data[pos:]))
+	pos += 2 + 1 + 2
+	c.capability |= uint32(binary.LittleEndian.Uint16(data[pos:])) << 16
+	pos += 2 + 1 + 10
+	c.salt = append(c.salt, data[pos:pos+12]...)
+	return c
+}
+
+func (c *rawClient) readPacket(t *testing.T) []byte {
+	data, err := c.pkg.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (c *rawClient) writePacket(t *testing.T, payload []byte) {
+	if err := c.pkg.WritePacket(append(make([]byte, 4), payload...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *rawClient) handshakeResponse(capability uint32, user string, auth []byte, db string, plugin string) []byte {
+	data := make([]byte, 4+4+1+23)
+	binary.LittleEndian.PutUint32(data, capability)
+	data[8] = byte(mysql.DEFAULT_COLLATION_ID)
+	data = append(data, user...)
+	data = append(data, 0, byte(len(auth)))
+	data = append(data, auth...)
+	if capability&mysql.CLIENT_CONNECT_WITH_DB != 0 {
+		data = append(data, db...)
+		data = append(data, 0)
+	}
+	if capability&mysql.CLIENT_PLUGIN_AUTH != 0 {
+		data = append(data, plugin...)
+		data = append(data, 0)
+	}
+	return data
+}
+
+//the code of an ERR packet, 0 for the others
+func errCode(data []byte) uint16 {
+	if len(data) < 3 || data[0] != mysql.ERR_HEADER {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(data[1:])
+}
+
+//the user ks has a password
+var passwordUserConfig = strings.Replace(fakeBackendConfig, "password :\n",
+	"password :\nusers :\n-\n    user : ks\n    password : secret\n", 1)
+
+const protocolClientCapability = mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
+	mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS
+
+func TestProtocolGreeting(t *testing.T) {
+	s, _, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	c := dialRaw(t, s.Addr().String())
+	defer c.conn.Close()
+
+	if c.capability&mysql.CLIENT_PROTOCOL_41 == 0 || c.capability&mysql.CLIENT_SECURE_CONNECTION == 0 {
+		t.Fatalf("%x", c.capability)
+	}
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF == 0 || c.capability&mysql.CLIENT_SESSION_TRACK == 0 ||
+		c.capability&mysql.CLIENT_QUERY_ATTRIBUTES == 0 {
+		t.Fatalf("%x", c.capability)
+	}
+	//kingshard has no tls
+	if c.capability&mysql.CLIENT_SSL != 0 {
+		t.Fatalf("%x", c.capability)
+	}
+	if len(c.salt) != 20 {
+		t.Fatal(c.salt)
+	}
+}
+
+func TestProtocolHandshake(t *testing.T) {
+	s, _, _, close := newFakeProxy(t, passwordUserConfig)
+	defer close()
+
+	native := func(c *rawClient) []byte {
+		return mysql.CalcPassword(c.salt, []byte("secret"))
+	}
+	tests := []struct {
+		name       string
+		capability uint32
+		auth       func(c *rawClient) []byte
+		db         string
+		plugin     string
+		code       uint16 //the error code, 0 for OK
+	}{
+		{"protocol 41", protocolClientCapability, native, "", "", 0},
+		{"with db", protocolClientCapability | mysql.CLIENT_CONNECT_WITH_DB, native, "kingshard", "", 0},
+		{"plugin auth", protocolClientCapability | mysql.CLIENT_PLUGIN_AUTH, native, "", mysql.AUTH_NAME, 0},
+		{"deprecate eof", protocolClientCapability | mysql.CLIENT_DEPRECATE_EOF, native, "", "", 0},
+		{"wrong password", protocolClientCapability, func(c *rawClient) []byte {
+			return mysql.CalcPassword(c.salt, []byte("wrong"))
+		}, "", "", mysql.ER_ACCESS_DENIED_ERROR},
+		{"no password", protocolClientCapability, func(c *rawClient) []byte {
+			return nil
+		}, "", "", mysql.ER_ACCESS_DENIED_ERROR},
+	}
+	for _, test := range tests {
+		c := dialRaw(t, s.Addr().String())
+		c.writePacket(t, c.handshakeResponse(test.capability, "ks", test.auth(c), test.db, test.plugin))
+		data := c.readPacket(t)
+		if code := errCode(data); code != test.code || (code == 0 && data[0] != mysql.OK_HEADER) {
+			t.Fatalf("%s: %x", test.name, data)
+		}
+		c.conn.Close()
+	}
+
+	//the truncated responses get an error instead of a dropped conn
+	for _, n := range []int{10, 4 + 4 + 1 + 23 + 2, 4 + 4 + 1 + 23 + 6} {
+		c := dialRaw(t, s.Addr().String())
+		response := c.handshakeResponse(protocolClientCapability, "ks", native(c), "", "")
+		c.writePacket(t, response[:n])
+		if data := c.readPacket(t); errCode(data) != mysql.ER_HANDSHAKE_ERROR {
+			t.Fatalf("%d: %x", n, data)
+		}
+		c.conn.Close()
+	}
+}
+
+//the client of other auth plugin is switched to mysql_native_password
+func TestProtocolAuthSwitch(t *testing.T) {
+	s, _, _, close := newFakeProxy(t, passwordUserConfig)
+	defer close()
+
+	for _, password := range []string{"secret", "wrong"} {
+		c := dialRaw(t, s.Addr().String())
+		c.writePacket(t, c.handshakeResponse(protocolClientCapability|mysql.CLIENT_PLUGIN_AUTH, "ks",
+			mysql.CalcCachingSha2Password(c.salt, []byte(password)), "", mysql.CACHING_SHA2_AUTH_NAME))
+
+		data := c.readPacket(t)
+		expect := append([]byte{mysql.EOF_HEADER}, mysql.AUTH_NAME+"\x00"...)
+		expect = append(append(expect, c.salt...), 0)
+		if !bytes.Equal(data, expect) {
+			t.Fatalf("%x", data)
+		}
+		c.writePacket(t, mysql.CalcPassword(c.salt, []byte(password)))
+		data = c.readPacket(t)
+		if password == "secret" && data[0] != mysql.OK_HEADER {
+			t.Fatalf("%x", data)
+		}
+		if password == "wrong" && errCode(data) != mysql.ER_ACCESS_DENIED_ERROR {
+			t.Fatalf("%x", data)
+		}
+		c.conn.Close()
+	}
+}
+
+//dial and log in with capability, the packets of the conn start from
+//the command
+func dialCapability(t *testing.T, addr string, capability uint32) *rawClient {
+	c := dialRaw(t, addr)
+	c.writePacket(t, c.handshakeResponse(capability|mysql.CLIENT_CONNECT_WITH_DB, "root", nil, "kingshard", ""))
+	if data := c.readPacket(t); data[0] != mysql.OK_HEADER {
+		t.Fatalf("%x", data)
+	}
+	return c
+}
+
+func (c *rawClient) writeCommand(t *testing.T, cmd byte, data []byte) {
+	c.pkg.Sequence = 0
+	c.writePacket(t, append([]byte{cmd}, data...))
+}
+
+func isEOF(data []byte) bool {
+	return data[0] == mysql.EOF_HEADER && len(data) == 5
+}
+
+//the OK packet with the EOF header, with CLIENT_DEPRECATE_EOF
+func isEOFOK(data []byte) bool {
+	return data[0] == mysql.EOF_HEADER && 7 <= len(data) && data[1] == 0 && data[2] == 0
+}
+
+//the result sets end with EOF, or without the EOF of columns and with OK
+//of the rows if the client asks for CLIENT_DEPRECATE_EOF
+func TestProtocolEOF(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{2}},
+	})
+
+	for _, deprecateEOF := range []bool{false, true} {
+		capability := protocolClientCapability
+		if deprecateEOF {
+			capability |= mysql.CLIENT_DEPRECATE_EOF
+		}
+		c := dialCapability(t, s.Addr().String(), capability)
+		c.writeCommand(t, mysql.COM_QUERY, []byte("select id from t where id = 2"))
+		if data := c.readPacket(t); !bytes.Equal(data, []byte{1}) {
+			t.Fatalf("column count %x", data)
+		}
+		if data := c.readPacket(t); isEOF(data) || !bytes.Contains(data, []byte("id")) {
+			t.Fatalf("column %x", data)
+		}
+		if !deprecateEOF {
+			if data := c.readPacket(t); !isEOF(data) {
+				t.Fatalf("eof of columns %x", data)
+			}
+		}
+		if data := c.readPacket(t); !bytes.Equal(data, []byte{1, '2'}) {
+			t.Fatalf("row %x", data)
+		}
+		data := c.readPacket(t)
+		if deprecateEOF && !isEOFOK(data) || !deprecateEOF && !isEOF(data) {
+			t.Fatalf("end of rows %x", data)
+		}
+
+		//COM_SET_OPTION is answered by EOF too
+		c.writeCommand(t, mysql.COM_SET_OPTION, []byte{0, 0})
+		data = c.readPacket(t)
+		if deprecateEOF && !isEOFOK(data) || !deprecateEOF && !isEOF(data) {
+			t.Fatalf("set option %x", data)
+		}
+		c.conn.Close()
+	}
+}
+
+//the change of database is tracked in the OK packet with
+//CLIENT_SESSION_TRACK
+func TestProtocolSessionTrack(t *testing.T) {
+	s, _, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	for _, track := range []bool{false, true} {
+		capability := protocolClientCapability
+		if track {
+			capability |= mysql.CLIENT_SESSION_TRACK
+		}
+		c := dialCapability(t, s.Addr().String(), capability)
+		c.writeCommand(t, mysql.COM_INIT_DB, []byte("kingshard"))
+		data := c.readPacket(t)
+		if data[0] != mysql.OK_HEADER {
+			t.Fatalf("%x", data)
+		}
+		status := binary.LittleEndian.Uint16(data[3:])
+		//affected rows, insert id, status, warnings, info
+		state := append([]byte{mysql.SESSION_TRACK_SCHEMA, 10, 9}, "kingshard"...)
+		expect := append([]byte{0, 0, 0, 0, 0, 0, byte(len(state))}, state...)
+		if !track {
+			expect = []byte{0, 0, 0, 0, 0}
+		}
+		if track != (status&mysql.SERVER_SESSION_STATE_CHANGED != 0) ||
+			!bytes.Equal(append(data[:3:3], data[5:]...), expect) {
+			t.Fatalf("%x", data)
+		}
+		c.conn.Close()
+	}
+}
+
+//the query attributes are read before the sql of COM_QUERY and after the
+//params of COM_STMT_EXECUTE, and dropped for the backends without query
+//attributes
+func TestProtocolQueryAttributes(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{2}},
+	})
+	c := dialCapability(t, s.Addr().String(), protocolClientCapability|
+		mysql.CLIENT_DEPRECATE_EOF|mysql.CLIENT_QUERY_ATTRIBUTES)
+	defer c.conn.Close()
+
+	//a long, a null and a string attribute
+	attrs := []byte{3, 1, 0x02, 1}
+	attrs = append(attrs, mysql.MYSQL_TYPE_LONG, 0, 1, 'a')
+	attrs = append(attrs, mysql.MYSQL_TYPE_NULL, 0, 1, 'b')
+	attrs = append(attrs, mysql.MYSQL_TYPE_VAR_STRING, 0, 5)
+	attrs = append(attrs, "trace"...)
+	attrs = append(attrs, 7, 0, 0, 0, 3)
+	attrs = append(attrs, "abc"...)
+	for _, prefix := range [][]byte{{0, 1}, attrs} {
+		backends[0].ClearQueries()
+		c.writeCommand(t, mysql.COM_QUERY, append(prefix, "select id from t where id = 2"...))
+		if data := c.readPacket(t); !bytes.Equal(data, []byte{1}) {
+			t.Fatalf("column count %x", data)
+		}
+		c.readPacket(t)
+		if data := c.readPacket(t); !bytes.Equal(data, []byte{1, '2'}) {
+			t.Fatalf("row %x", data)
+		}
+		if data := c.readPacket(t); !isEOFOK(data) {
+			t.Fatalf("end of rows %x", data)
+		}
+		queries := backends[0].Queries()
+		if len(queries) != 1 || !strings.HasPrefix(queries[0], "select id from t_0000") {
+			t.Fatal(queries)
+		}
+	}
+
+	c.writeCommand(t, mysql.COM_STMT_PREPARE, []byte("select id from t where id = ?"))
+	data := c.readPacket(t)
+	if data[0] != mysql.OK_HEADER {
+		t.Fatalf("%x", data)
+	}
+	id := binary.LittleEndian.Uint32(data[1:])
+	//the params and columns without EOF
+	columns, params := binary.LittleEndian.Uint16(data[5:]), binary.LittleEndian.Uint16(data[7:])
+	if params != 1 {
+		t.Fatalf("%x", data)
+	}
+	for i := 0; i < int(params+columns); i++ {
+		if data := c.readPacket(t); isEOF(data) {
+			t.Fatalf("%x", data)
+		}
+	}
+
+	backends[0].ClearQueries()
+	backends[1].ClearQueries()
+	execute := make([]byte, 4, 64)
+	binary.LittleEndian.PutUint32(execute, id)
+	execute = append(execute, mysql.PARAMETER_COUNT_AVAILABLE, 1, 0, 0, 0)
+	//the param id = 2 and the attribute trace = 'abc'
+	execute = append(execute, 2, 0, 1)
+	execute = append(execute, mysql.MYSQL_TYPE_LONGLONG, 0, 0)
+	execute = append(execute, mysql.MYSQL_TYPE_VAR_STRING, 0, 5)
+	execute = append(execute, "trace"...)
+	execute = append(execute, 2, 0, 0, 0, 0, 0, 0, 0, 3)
+	execute = append(execute, "abc"...)
+	c.writeCommand(t, mysql.COM_STMT_EXECUTE, execute)
+	if data := c.readPacket(t); data[0] == mysql.ERR_HEADER {
+		t.Fatalf("%x", data)
+	}
+	//routed by the param
+	if queries := backends[0].Queries(); len(queries) != 1 || len(backends[1].Queries()) != 0 {
+		t.Fatal(queries)
+	}
+}
+
+//the packets of 16M bytes or more are split into packets, and a packet of
+//exactly 16M-1 bytes is followed by an empty packet
+func TestProtocolBigPacket(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	value := strings.Repeat("b", mysql.MaxPayloadLen+10)
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"v"},
+		Rows:  [][]interface{}{{value}},
+	})
+
+	//the payload is the COM_QUERY byte and the query
+	const format = "select '%s' as v from t where id = 2"
+	for _, n := range []int{mysql.MaxPayloadLen, mysql.MaxPayloadLen + 10} {
+		literal := strings.Repeat("a", n-1-len(format)+2)
+		backends[0].ClearQueries()
+		r, err := c.Execute(fmt.Sprintf(format, literal))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := r.GetString(0, 0); v != value {
+			t.Fatal(len(v))
+		}
+		queries := backends[0].Queries()
+		if len(queries) == 0 || !strings.Contains(queries[len(queries)-1], "'"+literal+"'") {
+			t.Fatal(len(queries))
+		}
+	}
+}
+
+//the packets recorded are replayed with the same results
+func TestProtocolRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kingshard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "record")
+	cfg := strings.Replace(fakeBackendConfig, "password :\n", "password :\nprotocol_record : "+file+"\n", 1)
+	s, backends, c, close := newFakeProxy(t, cfg)
+	defer close()
+	backends[0].Handle(`from t_0000`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{2}, {4}},
+	})
+	backends[1].Handle(`from t_0001`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{3}},
+	})
+	if _, err := c.Execute("select id from t order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("select id from t where id = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns, err := mysql.ReadRecordedConns(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client *mysql.RecordedConn
+	var backendQueries int
+	for _, rc := range conns {
+		if !rc.Client && strings.HasPrefix(rc.Label, "client ") {
+			client = rc
+		}
+		if rc.Client && strings.HasPrefix(rc.Label, "backend ") {
+			for _, p := range rc.Packets {
+				if p.FromClient && bytes.Contains(p.Data, []byte("from t_000")) {
+					backendQueries++
+				}
+			}
+		}
+	}
+	if client == nil || len(client.Packets) < 10 || backendQueries != 3 {
+		t.Fatal(client, backendQueries)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := client.Replay(conn, true); err != nil {
+		t.Fatal(err)
+	}
+
+	//a different result is told
+	backends[0].Handle(`where id = 2`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{5}},
+	})
+	conn, err = net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := client.Replay(conn, true); err == nil || !strings.Contains(err.Error(), "expect") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+//the query attributes are sent by the clients with CLIENT_QUERY_ATTRIBUTES,
+//before the sql of COM_QUERY and after the params of COM_STMT_EXECUTE. The
+//attributes of ks_ prefix are the routing directives of kingshard, the
+//others are forwarded to the backends supporting query attributes.
+const (
+	QueryAttributePrefix = "ks_"
+
+	//the sql is sent to the node unmodified, as the /*node*/ hint
+	NodeAttribute = "ks_node"
+	//strong reads from the master, as the /*master*/ hint, eventual
+	//reads from the slaves even in the read after write window, and
+	//bounded(500ms) reads from a slave lagging at most 500ms, see
+	//consistency.go
+	ConsistencyAttribute = "ks_consistency"
+	StrongConsistency    = "strong"
+	EventualConsistency  = "eventual"
+	BoundedConsistency   = "bounded"
+)
+
+//read the query attributes of COM_QUERY, and return the sql
+func (c *ClientConn) readQueryAttributes(data []byte) ([]byte, error) {
+	if c.capability&mysql.CLIENT_QUERY_ATTRIBUTES == 0 {
+		return data, nil
+	}
+	attrs, sql, err := mysql.ReadQueryAttributes(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.setQueryAttributes(attrs); err != nil {
+		return nil, err
+	}
+	return sql, nil
+}
+
+//set the query attributes of the statement, the values of the routing
+//directives are checked, except the node checked when it is used
+func (c *ClientConn) setQueryAttributes(attrs []mysql.QueryAttribute) error {
+	for i := range attrs {
+		name, value := strings.ToLower(attrs[i].Name), attrs[i].String()
+		switch name {
+		case NodeAttribute:
+		case ConsistencyAttribute:
+			if _, err := parseConsistency(value); err != nil {
+				return fmt.Errorf("invalid %s %s", ConsistencyAttribute, value)
+			}
+		default:
+			if strings.HasPrefix(name, QueryAttributePrefix) {
+				return fmt.Errorf("unknown query attribute %s", attrs[i].Name)
+			}
+		}
+	}
+	c.queryAttrs = attrs
+	return nil
+}
+
+//the value of the query attribute name, empty if none
+func (c *ClientConn) queryAttribute(name string) string {
+	for i := range c.queryAttrs {
+		if strings.EqualFold(c.queryAttrs[i].Name, name) {
+			return c.queryAttrs[i].String()
+		}
+	}
+	return ""
+}
+
+//the query attributes forwarded to the backends, without the routing
+//directives
+func (c *ClientConn) forwardedQueryAttributes() []mysql.QueryAttribute {
+	var attrs []mysql.QueryAttribute
+	for _, attr := range c.queryAttrs {
+		if !strings.HasPrefix(strings.ToLower(attr.Name), QueryAttributePrefix) {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+//the backend conns of the transaction send no query attribute of the
+//last statement
+func (c *ClientConn) clearQueryAttributes() {
+	c.queryAttrs = nil
+	for _, co := range c.txConns {
+		co.SetQueryAttributes(nil)
+	}
+}
+
+//apply ks_consistency to the reads of the statement, after the read after
+//write window is checked
+func (c *ClientConn) applyConsistencyAttribute() {
+	value := c.queryAttribute(ConsistencyAttribute)
+	if len(value) == 0 {
+		return
+	}
+	//checked by setQueryAttributes
+	c.consistency, _ = parseConsistency(value)
+	c.defaultConsistency = false
+}
+
+//the sql with ks_node is sent to the node unmodified, nil if none
+func (c *ClientConn) getAttributeExecDB(sql string, tokens []string) (*ExecuteDB, error) {
+	name := c.queryAttribute(NodeAttribute)
+	if len(name) == 0 {
+		return nil, nil
+	}
+	executeDB := &ExecuteDB{ExecNode: c.schema.nodes[name], sql: sql}
+	if executeDB.ExecNode == nil {
+		return nil, fmt.Errorf("invalid %s %s", NodeAttribute, name)
+	}
+	if c.isInTransaction() {
+		if err := c.checkTxNodes(executeDB.ExecNode); err != nil {
+			return nil, err
+		}
+		return executeDB, nil
+	}
+	for _, token := range tokens {
+		if token[0] != mysql.COMMENT_PREFIX {
+			executeDB.IsSlave = strings.ToLower(token) == mysql.TK_STR_SELECT
+			break
+		}
+	}
+	return executeDB, nil
+}
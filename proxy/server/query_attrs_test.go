@@ -0,0 +1,131 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func stringAttribute(name string, value string) mysql.QueryAttribute {
+	return mysql.QueryAttribute{
+		Name:  name,
+		Type:  mysql.MYSQL_TYPE_VAR_STRING,
+		Value: mysql.PutLengthEncodedString([]byte(value)),
+	}
+}
+
+//the query attributes are forwarded to the backends except the routing
+//directives
+func TestQueryAttributesForward(t *testing.T) {
+	_, backends, c, close := newFakeProxyWith(t, fakeBackendConfig, func(b *mysqltest.Server) {
+		b.Capability = mysql.CLIENT_QUERY_ATTRIBUTES
+	})
+	defer close()
+	for _, b := range backends {
+		b.Handle(`from t_000`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+
+	c.SetQueryAttributes([]mysql.QueryAttribute{
+		stringAttribute("trace", "abc"),
+		stringAttribute(ConsistencyAttribute, StrongConsistency),
+		{Name: "empty", Type: mysql.MYSQL_TYPE_NULL},
+	})
+	if _, err := c.Execute("select id from t"); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range backends {
+		attrs := b.QueryAttributes()
+		last := attrs[len(attrs)-1]
+		if len(last) != 2 || last[0].Name != "trace" || last[0].String() != "abc" ||
+			last[1].Name != "empty" || last[1].Value != nil {
+			t.Fatal(last)
+		}
+	}
+
+	//the attributes are of the statement only
+	c.SetQueryAttributes(nil)
+	if _, err := c.Execute("select id from t where id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	attrs := backends[0].QueryAttributes()
+	if last := attrs[len(attrs)-1]; len(last) != 0 {
+		t.Fatal(last)
+	}
+}
+
+func TestQueryAttributesNode(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	c.SetQueryAttributes([]mysql.QueryAttribute{stringAttribute(NodeAttribute, "node2")})
+	if _, err := c.Execute("select * from t_0000"); err != nil {
+		t.Fatal(err)
+	}
+	if !hasQuery(backends[1].Queries(), "select * from t_0000") {
+		t.Fatal(backends[1].Queries())
+	}
+
+	for _, attr := range []mysql.QueryAttribute{
+		stringAttribute(NodeAttribute, "node3"),
+		stringAttribute(ConsistencyAttribute, "weak"),
+		stringAttribute("ks_unknown", "1"),
+	} {
+		c.SetQueryAttributes([]mysql.QueryAttribute{attr})
+		_, err := c.Execute("select * from t_0000")
+		if err == nil || !strings.Contains(err.Error(), attr.Name) {
+			t.Fatal(attr.Name, err)
+		}
+	}
+}
+
+//ks_consistency overrides the read after write window
+func TestQueryAttributesConsistency(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, readAfterWriteConfig)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+	master, slave := backends[0], backends[1]
+	read := func(sql string, consistency string, fromMaster bool) {
+		master.ClearQueries()
+		slave.ClearQueries()
+		c.SetQueryAttributes([]mysql.QueryAttribute{stringAttribute(ConsistencyAttribute, consistency)})
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+		if hasQuery(master.Queries(), sql) != fromMaster || hasQuery(slave.Queries(), sql) == fromMaster {
+			t.Fatal(sql, consistency, master.Queries(), slave.Queries())
+		}
+	}
+
+	read("select * from u", StrongConsistency, true)
+	read("select * from u", EventualConsistency, false)
+	c.SetQueryAttributes(nil)
+	if _, err := c.Execute("insert into u(id) values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	read("select * from u where id = 1", EventualConsistency, false)
+	read("select * from u where id = 1", StrongConsistency, true)
+}
@@ -0,0 +1,219 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//the max distinct tags in the stats, the queries of the other tags
+	//are counted in OtherQueryTag
+	MaxQueryTags  = 1000
+	OtherQueryTag = "other"
+	//the max length of a tag, the longer one is cut
+	MaxQueryTagLen = 256
+)
+
+//parseQueryTag returns the tag of sql, which is the key=value pairs in
+//the comments at the head or end of sql, such as
+//
+//	/* app=checkout team=payments */ select ...
+//	select ... /*app='checkout',team='payments'*/
+//
+//The pairs are sorted by key and separated by space, the comments without
+//a pair such as /*master*/ are ignored. Empty if sql has no tag.
+func parseQueryTag(sql string) string {
+	var pairs []string
+	pairs = appendTagPairs(pairs, leadingComments(sql))
+	pairs = appendTagPairs(pairs, trailingComments(sql))
+	if len(pairs) == 0 {
+		return ""
+	}
+	sort.Strings(pairs)
+	tag := strings.Join(pairs, " ")
+	if MaxQueryTagLen < len(tag) {
+		tag = tag[:MaxQueryTagLen]
+	}
+	return tag
+}
+
+//the comments at the head of sql
+func leadingComments(sql string) []string {
+	var comments []string
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		if !strings.HasPrefix(sql, "/*") {
+			return comments
+		}
+		end := strings.Index(sql, "*/")
+		if end == -1 {
+			return comments
+		}
+		comments = append(comments, sql[2:end])
+		sql = sql[end+2:]
+	}
+}
+
+//the comments at the end of sql
+func trailingComments(sql string) []string {
+	var comments []string
+	for {
+		sql = strings.TrimRight(sql, " \t\r\n;")
+		if !strings.HasSuffix(sql, "*/") {
+			return comments
+		}
+		start := strings.LastIndex(sql, "/*")
+		if start == -1 {
+			return comments
+		}
+		comments = append(comments, sql[start+2:len(sql)-2])
+		sql = sql[:start]
+	}
+}
+
+//the key=value pairs in the comments, separated by space or comma, the
+//quotes of value are dropped
+func appendTagPairs(pairs []string, comments []string) []string {
+	for _, comment := range comments {
+		fields := strings.FieldsFunc(comment, func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t' || r == '\r' || r == '\n'
+		})
+		for _, field := range fields {
+			i := strings.Index(field, "=")
+			if i <= 0 {
+				continue
+			}
+			key := field[:i]
+			value := strings.Trim(field[i+1:], `'"`)
+			if len(value) == 0 {
+				continue
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return pairs
+}
+
+//TagStat is the load of the queries of a tag, the times are in ms
+type TagStat struct {
+	Tag         string  `json:"tag"`
+	Queries     int64   `json:"queries"`
+	Errors      int64   `json:"errors"`
+	SlowQueries int64   `json:"slow_queries"`
+	TotalTime   float64 `json:"total_time"`
+	MaxTime     float64 `json:"max_time"`
+}
+
+//TagStats is the load of queries by their tags since the start or the
+//last reset, the queries without a tag are counted in the empty tag
+type TagStats struct {
+	sync.Mutex
+
+	stats map[string]*TagStat
+}
+
+func NewTagStats() *TagStats {
+	return &TagStats{stats: make(map[string]*TagStat)}
+}
+
+func (s *TagStats) Record(tag string, d time.Duration, failed bool, slow bool) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.Lock()
+	defer s.Unlock()
+	stat, ok := s.stats[tag]
+	if !ok {
+		if MaxQueryTags <= len(s.stats) {
+			tag = OtherQueryTag
+		}
+		if stat, ok = s.stats[tag]; !ok {
+			stat = &TagStat{Tag: tag}
+			s.stats[tag] = stat
+		}
+	}
+	stat.Queries++
+	if failed {
+		stat.Errors++
+	}
+	if slow {
+		stat.SlowQueries++
+	}
+	stat.TotalTime += ms
+	if stat.MaxTime < ms {
+		stat.MaxTime = ms
+	}
+}
+
+//Stats returns the stats of tags in the descending order of total time
+func (s *TagStats) Stats() []TagStat {
+	s.Lock()
+	stats := make([]TagStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		stats = append(stats, *stat)
+	}
+	s.Unlock()
+	sort.Sort(tagStatsByTime(stats))
+	return stats
+}
+
+func (s *TagStats) Reset() {
+	s.Lock()
+	s.stats = make(map[string]*TagStat)
+	s.Unlock()
+}
+
+type tagStatsByTime []TagStat
+
+func (p tagStatsByTime) Len() int { return len(p) }
+func (p tagStatsByTime) Less(i, j int) bool {
+	if p[i].TotalTime != p[j].TotalTime {
+		return p[j].TotalTime < p[i].TotalTime
+	}
+	return p[i].Tag < p[j].Tag
+}
+func (p tagStatsByTime) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+//handle the statement sql with the hooks, and record its time and error
+//in the stats of its tag
+func (c *ClientConn) runTagged(sql string, handle func() error) error {
+	c.tag = parseQueryTag(sql)
+	start := time.Now()
+	err := c.runHooks(sql, handle)
+	d := time.Since(start)
+	slowLogTime := c.proxy.GetSlowLogTime()
+	c.proxy.tagStats.Record(c.tag, d, err != nil,
+		0 < slowLogTime && time.Duration(slowLogTime)*time.Millisecond < d)
+	c.recordHistory(sql, d, err)
+	return err
+}
+
+//the tag is appended to the sql in the sql log
+func appendLogTag(sql string, tag string) string {
+	if len(tag) == 0 {
+		return sql
+	}
+	return sql + " tag:[" + tag + "]"
+}
+
+func (s *Server) GetTagStats() []TagStat {
+	return s.tagStats.Stats()
+}
+
+func (s *Server) ResetTagStats() {
+	s.tagStats.Reset()
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestParseQueryTag(t *testing.T) {
+	tests := []struct {
+		sql string
+		tag string
+	}{
+		{"select 1", ""},
+		{"/*master*/ select 1", ""},
+		{"/* team=payments app=checkout */ select 1", "app=checkout team=payments"},
+		{"select 1 /*app='checkout',team=\"payments\"*/;", "app=checkout team=payments"},
+		{"/*app=a*/ /*master*/ select 1 /*team=b*/", "app=a team=b"},
+		{"select '/*app=a*/' from t", ""},
+		{"/*app=*/ select 1", ""},
+	}
+	for _, test := range tests {
+		if tag := parseQueryTag(test.sql); tag != test.tag {
+			t.Fatalf("%s: want %q, got %q", test.sql, test.tag, tag)
+		}
+	}
+}
+
+func TestTagStatsOther(t *testing.T) {
+	s := NewTagStats()
+	for i := 0; i < MaxQueryTags+10; i++ {
+		s.Record(string(rune('a'+i%26))+string(rune(i)), time.Millisecond, false, false)
+	}
+	stats := s.Stats()
+	if len(stats) != MaxQueryTags+1 {
+		t.Fatal(len(stats))
+	}
+	for _, stat := range stats {
+		if stat.Tag == OtherQueryTag && stat.Queries != 10 {
+			t.Fatal(stat)
+		}
+	}
+}
+
+var queryTagConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+    slave : %s
+
+schema :
+    default : node1
+    nodes : [node1]
+`
+
+func TestQueryTagStats(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, queryTagConfig)
+	defer close()
+	backends[0].Handle(`^select`, &mysqltest.Response{
+		Names: []string{"id"},
+		Rows:  [][]interface{}{{1}},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Execute("/*app=checkout*/ select /*master*/ id from t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Execute("select id from t"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Execute("admin server(opt,k,v) values('show','stats','tag')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := make(map[string]int64)
+	for i := range r.Values {
+		tag, _ := r.GetString(i, 0)
+		n, _ := r.GetInt(i, 1)
+		queries[tag] = n
+	}
+	if queries["app=checkout"] != 3 || queries[""] != 1 {
+		t.Fatal(queries)
+	}
+
+	if _, err = c.Execute("admin server(opt,k,v) values('del','stats','tag')"); err != nil {
+		t.Fatal(err)
+	}
+	r, err = c.Execute("admin server(opt,k,v) values('show','stats','tag')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//only the del itself is counted after the reset
+	if len(r.Values) != 1 {
+		t.Fatal(r.Values)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+)
+
+//the reads of a statement with the hint go to the slaves even in the
+//read after write window
+const SlaveComment = "/*slave*/"
+
+//the read after write window of user, 0 if none
+func (s *Server) readAfterWrite(user string) time.Duration {
+	cfg := s.getState().cfg
+	window := cfg.ReadAfterWrite
+	for _, u := range cfg.Users {
+		if u.User == user && u.ReadAfterWrite != 0 {
+			window = u.ReadAfterWrite
+			break
+		}
+	}
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(window) * time.Millisecond
+}
+
+func (c *ClientConn) markWrite() {
+	c.lastWrite = time.Now()
+}
+
+//the reads of sql are sent to the master if the session wrote in the read
+//after write window, a simpler alternative to waiting the gtid of the
+//write in slaves. It overrides the default consistency of user, and the
+//hints and ks_consistency override it.
+func (c *ClientConn) startReadAfterWrite(sql string) error {
+	c.consistency = c.proxy.userConsistency(c.user)
+	c.defaultConsistency = true
+	if !c.lastWrite.IsZero() {
+		window := c.proxy.readAfterWrite(c.user)
+		if 0 < window && time.Since(c.lastWrite) < window {
+			c.consistency, c.defaultConsistency = readConsistency{strong: true}, false
+		}
+	}
+	if err := c.applyConsistencyHint(sql); err != nil {
+		return err
+	}
+	c.applyConsistencyAttribute()
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+//backends[0] is the master of node1 and backends[1] is its slave
+var readAfterWriteConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+read_after_write : 200
+
+users :
+-
+    user : app
+    password :
+    read_after_write : -1
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+    slave : %s
+
+schema :
+    default : node1
+    nodes : [node1]
+`
+
+func TestReadAfterWrite(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, readAfterWriteConfig)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+	master, slave := backends[0], backends[1]
+	read := func(c *backend.Conn, sql string, fromMaster bool) {
+		master.ClearQueries()
+		slave.ClearQueries()
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+		if hasQuery(master.Queries(), sql) != fromMaster || hasQuery(slave.Queries(), sql) == fromMaster {
+			t.Fatal(sql, fromMaster, master.Queries(), slave.Queries())
+		}
+	}
+
+	read(c, "select * from u", false)
+	if _, err := c.Execute("insert into u(id) values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	read(c, "select * from u where id = 1", true)
+	read(c, "select /*slave*/ * from u where id = 1", false)
+	time.Sleep(250 * time.Millisecond)
+	read(c, "select * from u where id = 1", false)
+
+	//the window starts at the commit of a transaction
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("update u set id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := c.Execute("commit"); err != nil {
+		t.Fatal(err)
+	}
+	read(c, "select * from u where id = 2", true)
+
+	//the user app has no window
+	app := new(backend.Conn)
+	if err := app.Connect(s.Addr().String(), "app", "", "kingshard"); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	if _, err := app.Execute("insert into u(id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+	read(app, "select * from u where id = 3", false)
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const DefaultReadOnlyRetryWait = 1000 //milliseconds
+
+func (s *Server) parseReadOnlyRetryWait() {
+	cfg := s.getState().cfg
+	s.readOnlyRetryWait = 0
+	switch {
+	case cfg.ReadOnlyRetryWait == 0:
+		s.readOnlyRetryWait = DefaultReadOnlyRetryWait * time.Millisecond
+	case 0 < cfg.ReadOnlyRetryWait:
+		s.readOnlyRetryWait = time.Duration(cfg.ReadOnlyRetryWait) * time.Millisecond
+	}
+}
+
+//the write is denied because the master is read only or super read only,
+//such as the old master after a failover
+func isReadOnlyError(err error) bool {
+	e, ok := err.(*mysql.SqlError)
+	if !ok {
+		return false
+	}
+	return e.Code == mysql.ER_OPTION_PREVENTS_STATEMENT || e.Code == mysql.ER_READ_ONLY_MODE
+}
+
+//the write denied by the read only master is not executed, it is retried
+//once out of transaction. The statements before it in a transaction are
+//lost with the old master, so it can not be retried.
+func (c *ClientConn) canRetryReadOnly(err error) bool {
+	return 0 < c.proxy.readOnlyRetryWait && !c.isInTransaction() && isReadOnlyError(err)
+}
+
+//wait for the failover of node, and get a new conn of its master. The conn
+//to the read only master is discarded, the new conn may reach a new server
+//even if the master is not changed, such as a virtual ip.
+func (c *ClientConn) getRetryMasterConn(n *backend.Node, co *backend.BackendConn, wait time.Duration) (*backend.BackendConn, error) {
+	addr := co.GetAddr()
+	co.Discard()
+	changed := n.WaitMasterChange(addr, wait)
+	golog.Warn("ClientConn", "getRetryMasterConn", "retry the write denied by read only master", c.connectionId,
+		"node", n.Cfg.Name,
+		"addr", addr,
+		"master_changed", changed)
+	return c.getBackendConn(n, false)
+}
+
+//execute the sqls of plan, the sqls of a node which are all denied by the
+//read only master are retried once in the current master of the node
+func (c *ClientConn) executeWriteInMultiNodes(conns map[string]*backend.BackendConn, plan *router.Plan, args []interface{}) ([]*mysql.Result, error) {
+	rs, errs, err := c.executeShardSqls(conns, plan, args)
+	if err != nil || len(errs) == 0 {
+		return rs, err
+	}
+
+	//the nodes whose sqls all fail for read only
+	failed := make(map[string]int)
+	for _, e := range errs {
+		if !c.canRetryReadOnly(e.err) {
+			return rs, newShardSqlError(errs, len(rs))
+		}
+		failed[e.node]++
+	}
+	p := *plan
+	p.RewrittenSqls = make(map[string][]string)
+	retryConns := make(map[string]*backend.BackendConn)
+	deadline := time.Now().Add(c.proxy.readOnlyRetryWait)
+	for nodeName, count := range failed {
+		if count != len(plan.RewrittenSqls[nodeName]) {
+			return rs, newShardSqlError(errs, len(rs))
+		}
+		co, err := c.getRetryMasterConn(c.schema.nodes[nodeName], conns[nodeName], deadline.Sub(time.Now()))
+		if err != nil {
+			return rs, err
+		}
+		//closed with conns by the caller
+		conns[nodeName] = co
+		retryConns[nodeName] = co
+		p.RewrittenSqls[nodeName] = plan.RewrittenSqls[nodeName]
+	}
+
+	retried, retryErrs, err := c.executeShardSqls(retryConns, &p, args)
+	if err != nil {
+		return rs, err
+	}
+	//copy the results of the retried nodes in the order of node names
+	offset, retryOffset := 0, 0
+	for _, nodeName := range sortedNodeNames(plan.RewrittenSqls) {
+		count := len(plan.RewrittenSqls[nodeName])
+		if _, ok := failed[nodeName]; ok {
+			copy(rs[offset:offset+count], retried[retryOffset:retryOffset+count])
+			retryOffset += count
+		}
+		offset += count
+	}
+	if len(retryErrs) != 0 {
+		return rs, newShardSqlError(retryErrs, len(rs))
+	}
+	return rs, nil
+}
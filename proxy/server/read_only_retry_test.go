@@ -0,0 +1,55 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestReadOnlyRetry(t *testing.T) {
+	s := newStateServer(new(config.Config))
+	s.parseReadOnlyRetryWait()
+	if s.readOnlyRetryWait != DefaultReadOnlyRetryWait*time.Millisecond {
+		t.Fatal(s.readOnlyRetryWait)
+	}
+
+	c := &ClientConn{proxy: s, state: s.getState(), status: mysql.SERVER_STATUS_AUTOCOMMIT}
+	readOnly := mysql.NewDefaultError(mysql.ER_OPTION_PREVENTS_STATEMENT, "--super-read-only")
+	if !c.canRetryReadOnly(readOnly) {
+		t.Fatal("the read only error is retried")
+	}
+	if c.canRetryReadOnly(mysql.NewDefaultError(mysql.ER_DUP_ENTRY, "1", "PRIMARY")) ||
+		c.canRetryReadOnly(fmt.Errorf("connection refused")) {
+		t.Fatal("the other errors are not retried")
+	}
+
+	//the statements before it in transaction are lost
+	c.status |= mysql.SERVER_STATUS_IN_TRANS
+	if c.canRetryReadOnly(readOnly) {
+		t.Fatal("the read only error in transaction is not retried")
+	}
+
+	s.getState().cfg.ReadOnlyRetryWait = -1
+	s.parseReadOnlyRetryWait()
+	c.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	if s.readOnlyRetryWait != 0 || c.canRetryReadOnly(readOnly) {
+		t.Fatal("the retry is disabled")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/flike/kingshard/backend"
+)
+
+//the nodes checked by Ready, the default node of schema if not configured
+func (st *serverState) parseReadiness() error {
+	nodes := st.cfg.Readiness.Nodes
+	if len(nodes) == 0 && len(st.cfg.Schema.Default) != 0 {
+		nodes = []string{st.cfg.Schema.Default}
+	}
+	for _, node := range nodes {
+		if st.nodes[node] == nil {
+			return fmt.Errorf("readiness node %s is not exists", node)
+		}
+	}
+	st.readyNodes = nodes
+	return nil
+}
+
+//Ready returns the reasons why the proxy is not ready to serve, nil if
+//it is ready. It is not ready before running, after closed or offline.
+func (s *Server) Ready() []string {
+	var reasons []string
+	if !s.isRunning() {
+		reasons = append(reasons, "proxy is not running")
+	}
+	if s.Status() != "online" {
+		reasons = append(reasons, "proxy is "+s.Status())
+	}
+	st := s.getState()
+	for _, name := range st.readyNodes {
+		n := st.nodes[name]
+		if !isReachable(n.Master) {
+			reasons = append(reasons, fmt.Sprintf("master of %s is down", name))
+		}
+		if st.cfg.Readiness.Slave && !hasReachableSlave(n) {
+			reasons = append(reasons, fmt.Sprintf("no slave of %s is up", name))
+		}
+	}
+	return reasons
+}
+
+//the db opened is reachable before the first check of node
+func isReachable(db *backend.DB) bool {
+	return db != nil && db.State() != "down"
+}
+
+func hasReachableSlave(n *backend.Node) bool {
+	n.RLock()
+	defer n.RUnlock()
+	for _, slave := range n.Slave {
+		if isReachable(slave) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+)
+
+func TestReadiness(t *testing.T) {
+	node := new(backend.Node)
+	node.ParseMaster("127.0.0.1:1")
+	s := newStateServer(new(config.Config))
+	s.getState().nodes = map[string]*backend.Node{"node1": node}
+	s.status[s.statusIndex] = Online
+
+	s.getState().cfg.Readiness.Nodes = []string{"node2"}
+	if err := s.getState().parseReadiness(); err == nil {
+		t.Fatal("node2 is not exists")
+	}
+	s.getState().cfg.Readiness.Nodes = nil
+	if err := s.getState().parseReadiness(); err != nil || len(s.getState().readyNodes) != 0 {
+		t.Fatal(err, s.getState().readyNodes)
+	}
+	if reasons := s.Ready(); !reflect.DeepEqual(reasons, []string{"proxy is not running"}) {
+		t.Fatal(reasons)
+	}
+	s.running = 1
+	if reasons := s.Ready(); len(reasons) != 0 {
+		t.Fatal(reasons)
+	}
+
+	//the default node of schema is checked by default
+	s.getState().cfg.Schema.Default = "node1"
+	s.getState().cfg.Readiness.Slave = true
+	if err := s.getState().parseReadiness(); err != nil {
+		t.Fatal(err)
+	}
+	s.status[s.statusIndex] = Offline
+	expect := []string{"proxy is offline", "master of node1 is down", "no slave of node1 is up"}
+	if reasons := s.Ready(); !reflect.DeepEqual(reasons, expect) {
+		t.Fatal(reasons)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+)
+
+//Reload applies cfg to the running server, such as the nodes, schema,
+//users, rules, allow ips, black sqls, column masks and row filters. The
+//nodes whose config is not changed are kept with their conns, the others
+//are opened again and the old ones are closed once no session uses them.
+//The sessions use the new schema from their next statement out of
+//transaction. The settings of
+//listeners, client keepalive, charset, hot key, conn limit, cluster,
+//protocol record and write journal are not reloaded, they need a restart.
+//In a cluster, the rules are published to the other instances.
+func (s *Server) Reload(cfg *config.Config) error {
+	s.reloadLock.Lock()
+	err := s.reload(cfg)
+	s.reloadLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if s.cluster != nil {
+		if err = s.cluster.PublishRules(cfg.Schema); err != nil {
+			golog.Error("Server", "Reload", "publish rules to cluster", 0, "error", err.Error())
+		}
+	}
+	return nil
+}
+
+//reload with reloadLock held
+func (s *Server) reload(cfg *config.Config) error {
+	//the allow ips and black sqls are parsed by a scratch server, then
+	//switched to by the double buffers
+	next := &Server{recorder: s.recorder, charset: s.charset, collation: s.collation}
+	st := newServerState(cfg)
+	next.state.Store(st)
+	old := s.getState()
+	if err := next.reloadNodes(st, old.nodes); err != nil {
+		return err
+	}
+	if err := next.parseReload(st); err != nil {
+		closeNodes(st.nodes, old.nodes)
+		return err
+	}
+	st.userQuota = NewUserQuota(cfg.Users)
+	s.publishState(st)
+	if !cfg.FaultInjection {
+		s.faults.Clear()
+	}
+
+	//the double buffers are switched to the new values
+	i := 1 - atomic.LoadInt32(&s.allowipsIndex)
+	s.allowips[i] = next.allowips[0]
+	atomic.StoreInt32(&s.allowipsIndex, i)
+	i = 1 - atomic.LoadInt32(&s.blacklistSqlsIndex)
+	s.blacklistSqls[i] = next.blacklistSqls[0]
+	atomic.StoreInt32(&s.blacklistSqlsIndex, i)
+	if len(cfg.LogSql) != 0 {
+		s.switchLogSql(cfg.LogSql)
+	}
+	s.setLogSqlScrub(cfg.LogSqlScrub)
+	i = 1 - atomic.LoadInt32(&s.slowLogTimeIndex)
+	s.slowLogTime[i] = cfg.SlowLogTime
+	atomic.StoreInt32(&s.slowLogTimeIndex, i)
+
+	logConfigWarnings(cfg)
+	golog.Info("Server", "Reload", "config reloaded", 0)
+	return nil
+}
+
+//use the state reloaded at the start of a statement out of transaction,
+//the node pinned is replaced by the node of the same name, or unpinned if
+//the node is removed. The state is held until the statement or the
+//transaction ends, see releaseState.
+func (c *ClientConn) refreshSchema() {
+	if c.stateHeld {
+		return
+	}
+	st := c.proxy.acquireState()
+	c.stateHeld = true
+	if st == c.state {
+		return
+	}
+	c.state = st
+	c.schema = st.schema
+	if c.pinnedNode != nil {
+		c.pinnedNode = st.schema.nodes[c.pinnedNode.Cfg.Name]
+	}
+}
+
+//the node of name in the state of session instead of the newest state,
+//so a reload during a statement or transaction does not mix the configs
+func (c *ClientConn) getNode(name string) *backend.Node {
+	return c.state.nodes[name]
+}
+
+//release the state held after a statement out of transaction, so the
+//idle sessions do not keep the nodes replaced open
+func (c *ClientConn) releaseState() {
+	if c.stateHeld && !c.isInTransaction() {
+		c.stateHeld = false
+		c.state.release()
+	}
+}
+
+//the nodes of cfg, the unchanged nodes in old are reused
+func (s *Server) reloadNodes(st *serverState, old map[string]*backend.Node) error {
+	st.nodes = make(map[string]*backend.Node, len(st.cfg.Nodes))
+	for _, v := range st.cfg.Nodes {
+		if _, ok := st.nodes[v.Name]; ok {
+			closeNodes(st.nodes, old)
+			return fmt.Errorf("duplicate node [%s]", v.Name)
+		}
+		if n := old[v.Name]; n != nil && reflect.DeepEqual(n.Cfg, v) {
+			st.nodes[v.Name] = n
+			continue
+		}
+		n, err := s.parseNode(v)
+		if err != nil {
+			closeNodes(st.nodes, old)
+			return err
+		}
+		st.nodes[v.Name] = n
+	}
+	return nil
+}
+
+//close the nodes not in keep
+func closeNodes(nodes map[string]*backend.Node, keep map[string]*backend.Node) {
+	for name, n := range nodes {
+		if keep[name] != n {
+			n.Close()
+		}
+	}
+}
+
+//parse the config reloaded except the nodes
+func (s *Server) parseReload(st *serverState) error {
+	if err := s.parseBlackListSqls(); err != nil {
+		return err
+	}
+	if err := s.parseAllowIps(); err != nil {
+		return err
+	}
+	if err := checkParseFailPolicy(st.cfg.ParseFailPolicy); err != nil {
+		return err
+	}
+	if err := checkTxFailoverPolicy(st.cfg.TxFailoverPolicy); err != nil {
+		return err
+	}
+	if err := checkUnsupportPolicy(st.cfg.UnsupportPolicy); err != nil {
+		return err
+	}
+	if err := checkPasswordExpires(st.cfg); err != nil {
+		return err
+	}
+	if err := checkConsistencies(st.cfg); err != nil {
+		return err
+	}
+	if err := st.parseRewriteRules(); err != nil {
+		return err
+	}
+	if err := st.parseMockRules(); err != nil {
+		return err
+	}
+	if err := st.parseSelectLimits(); err != nil {
+		return err
+	}
+	if err := st.parseColumnMasks(); err != nil {
+		return err
+	}
+	if err := st.parseSchema(); err != nil {
+		return err
+	}
+	if err := st.parseReadiness(); err != nil {
+		return err
+	}
+	var err error
+	st.authenticator, err = NewAuthenticator(&st.cfg.Auth)
+	return err
+}
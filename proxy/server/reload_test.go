@@ -0,0 +1,120 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"golang.org/x/net/context"
+)
+
+var reloadConfigData = `
+addr : 127.0.0.1:0
+user : root
+password :
+slow_log_time : 100
+
+nodes :
+-
+    name : node1
+    user : root
+    master : 127.0.0.1:1
+-
+    name : node2
+    user : root
+    master : 127.0.0.1:2
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+`
+
+func TestReload(t *testing.T) {
+	cfg, err := config.ParseConfigData([]byte(reloadConfigData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- s.RunContext(ctx)
+	}()
+	node1, node2 := s.GetNode("node1"), s.GetNode("node2")
+
+	//the schema is kept if the config is invalid
+	cfg2, _ := config.ParseConfigData([]byte(reloadConfigData))
+	cfg2.Schema.Nodes = []string{"node1", "node3"}
+	if err := s.Reload(cfg2); err == nil {
+		t.Fatal("node3 is not exists")
+	}
+	if s.GetNode("node1") != node1 || s.GetSchema().nodes["node2"] != node2 {
+		t.Fatal("the nodes are changed")
+	}
+
+	//the session holds the state in its statement
+	held := &ClientConn{proxy: s, schema: &Schema{}, status: mysql.SERVER_STATUS_AUTOCOMMIT}
+	held.refreshSchema()
+
+	cfg2, _ = config.ParseConfigData([]byte(reloadConfigData))
+	cfg2.Nodes[1].Master = "127.0.0.1:3"
+	cfg2.SlowLogTime = 200
+	if err := s.Reload(cfg2); err != nil {
+		t.Fatal(err)
+	}
+	//the old node2 is closed after the session releases the old state
+	select {
+	case <-s.retired:
+		t.Fatal("the old nodes are closed before drained")
+	case <-time.After(50 * time.Millisecond):
+	}
+	held.releaseState()
+	select {
+	case <-s.retired:
+	case <-time.After(time.Second):
+		t.Fatal("the old nodes are not closed")
+	}
+	if s.GetNode("node1") != node1 || s.GetSchema().nodes["node1"] != node1 {
+		t.Fatal("the unchanged node is reused")
+	}
+	if n := s.GetNode("node2"); n == node2 || n.Master.Addr() != "127.0.0.1:3" {
+		t.Fatal("node2 is reloaded")
+	}
+	if s.GetSlowLogTime() != 200 {
+		t.Fatal(s.GetSlowLogTime())
+	}
+
+	//the session uses the reloaded schema and node
+	c := &ClientConn{proxy: s, schema: &Schema{}, pinnedNode: node2,
+		status: mysql.SERVER_STATUS_AUTOCOMMIT}
+	c.refreshSchema()
+	if c.schema != s.GetSchema() || c.pinnedNode != s.GetNode("node2") {
+		t.Fatal("the schema is not refreshed")
+	}
+	c.releaseState()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the server is not closed")
+	}
+}
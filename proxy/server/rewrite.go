@@ -0,0 +1,115 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the pattern of a fingerprint rule, which matches the whole sql as $0
+var wholeSqlPattern = regexp.MustCompile(`(?s)^.*$`)
+
+type RewriteRule struct {
+	Name        string
+	Fingerprint string
+	Pattern     *regexp.Regexp
+	Rewrite     string
+
+	Hits int64
+}
+
+//SqlRewriter rewrites the sql by the rules in order before routing,
+//the output of a rule is the input of the next one.
+type SqlRewriter struct {
+	rules []*RewriteRule
+}
+
+func NewSqlRewriter(cfgs []config.RewriteRuleConfig) (*SqlRewriter, error) {
+	r := new(SqlRewriter)
+	r.rules = make([]*RewriteRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		if len(cfg.Fingerprint) == 0 && len(cfg.Pattern) == 0 {
+			return nil, fmt.Errorf("rewrite rule %d has no fingerprint or pattern", i)
+		}
+
+		rule := &RewriteRule{
+			Name:    cfg.Name,
+			Rewrite: cfg.Rewrite,
+		}
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule_%d", i)
+		}
+		if len(cfg.Fingerprint) != 0 {
+			rule.Fingerprint = mysql.GetFingerprint(cfg.Fingerprint)
+		}
+		if len(cfg.Pattern) != 0 {
+			re, err := regexp.Compile(cfg.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %s: %v", rule.Name, err)
+			}
+			rule.Pattern = re
+		} else {
+			rule.Pattern = wholeSqlPattern
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+//return the rewritten sql, and sql itself if no rule matches
+func (r *SqlRewriter) Rewrite(sql string) string {
+	if len(r.rules) == 0 {
+		return sql
+	}
+
+	var fingerprint string
+	for _, rule := range r.rules {
+		if len(rule.Fingerprint) != 0 {
+			if len(fingerprint) == 0 {
+				fingerprint = mysql.GetFingerprint(sql)
+			}
+			if fingerprint != rule.Fingerprint {
+				continue
+			}
+		}
+		if !rule.Pattern.MatchString(sql) {
+			continue
+		}
+		sql = rule.Pattern.ReplaceAllString(sql, rule.Rewrite)
+		//the fingerprint of the new sql
+		fingerprint = ""
+		atomic.AddInt64(&rule.Hits, 1)
+	}
+	return sql
+}
+
+func (r *SqlRewriter) GetRules() []RewriteRule {
+	rules := make([]RewriteRule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, RewriteRule{
+			Name:        rule.Name,
+			Fingerprint: rule.Fingerprint,
+			Pattern:     rule.Pattern,
+			Rewrite:     rule.Rewrite,
+			Hits:        atomic.LoadInt64(&rule.Hits),
+		})
+	}
+	return rules
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+func TestSqlRewrite(t *testing.T) {
+	cfgs := []config.RewriteRuleConfig{
+		{
+			Name:        "force_limit",
+			Fingerprint: "select * from orders where user_id = 1",
+			Rewrite:     "$0 limit 1000",
+		},
+		{
+			Name:    "rename_table",
+			Pattern: `(?i)\bfrom\s+old_orders\b`,
+			Rewrite: "from orders",
+		},
+		{
+			Pattern: `(?i)^select (.*) from users where`,
+			Rewrite: "select $1 from users force index(idx_name) where",
+		},
+	}
+	r, err := NewSqlRewriter(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql    string
+		expect string
+	}{
+		{"select * from orders where user_id = 12", "select * from orders where user_id = 12 limit 1000"},
+		{"select * from orders where id = 12", "select * from orders where id = 12"},
+		{"select * from old_orders where id = 12", "select * from orders where id = 12"},
+		{"select id,name from users where name = 'a'", "select id,name from users force index(idx_name) where name = 'a'"},
+	}
+	for _, test := range tests {
+		if sql := r.Rewrite(test.sql); sql != test.expect {
+			t.Fatalf("sql %s, expect %s, got %s", test.sql, test.expect, sql)
+		}
+	}
+
+	rules := r.GetRules()
+	if len(rules) != 3 || rules[2].Name != "rule_2" {
+		t.Fatal(len(rules))
+	}
+	for _, rule := range rules {
+		if rule.Hits != 1 {
+			t.Fatal(rule.Name, rule.Hits)
+		}
+	}
+
+	if _, err := NewSqlRewriter([]config.RewriteRuleConfig{{Rewrite: "x"}}); err == nil {
+		t.Fatal("rule without fingerprint or pattern should fail")
+	}
+	if _, err := NewSqlRewriter([]config.RewriteRuleConfig{{Pattern: "("}}); err == nil {
+		t.Fatal("invalid pattern should fail")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//the identifiers in a sql, the table names are among them
+var identifierRegexp = regexp.MustCompile(`[0-9A-Za-z_$]+`)
+
+type rowFilter struct {
+	config.RowFilterConfig
+}
+
+//RowFilter appends the filters of the users and tables to the where of
+//their selects, updates and deletes, so the users sharing a table only
+//read and change their own rows, such as region = 'EU' for a team
+type RowFilter struct {
+	filters []*rowFilter
+	//maps the sub tables to their logical tables, so the sub tables
+	//are filtered as the logical tables
+	rule *router.Router
+}
+
+//the table of a filter must be a sharded table whose sqls are parsed,
+//rule is the router of schema
+func NewRowFilter(cfgs []config.RowFilterConfig, schema *config.SchemaConfig,
+	rule *router.Router) (*RowFilter, error) {
+	f := new(RowFilter)
+	f.rule = rule
+	for i, cfg := range cfgs {
+		cfg.DB = strings.Trim(cfg.DB, "`")
+		cfg.Table = strings.Trim(cfg.Table, "`")
+		if len(cfg.Table) == 0 {
+			return nil, fmt.Errorf("row filter %d has no table", i)
+		}
+		if _, err := router.ParsePredicate(cfg.Filter, ""); err != nil {
+			return nil, fmt.Errorf("invalid row filter %s of table %s", cfg.Filter, cfg.Table)
+		}
+		sharded := false
+		for _, rule := range schema.ShardRule {
+			if !matchName(cfg.DB, rule.DB) || !strings.EqualFold(cfg.Table, rule.Table) {
+				continue
+			}
+			if rule.NoRewrite || rule.Type == router.SingleRuleType {
+				return nil, fmt.Errorf("row filter of table %s is not allowed for no_rewrite or single table", cfg.Table)
+			}
+			sharded = true
+		}
+		if !sharded {
+			return nil, fmt.Errorf("row filter of table %s is not allowed for unsharded table", cfg.Table)
+		}
+		f.filters = append(f.filters, &rowFilter{RowFilterConfig: cfg})
+	}
+	return f, nil
+}
+
+//HasUser returns true if some filters apply to user
+func (f *RowFilter) HasUser(user string) bool {
+	for _, filter := range f.filters {
+		if len(filter.User) == 0 || filter.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+//the filters of table for user, the columns are qualified by alias
+func (f *RowFilter) tableFilter(user string, db string, table *sqlparser.TableName,
+	alias string) sqlparser.BoolExpr {
+	if len(table.Qualifier) != 0 {
+		db = string(table.Qualifier)
+	}
+	name := logicalTable(f.rule, string(table.Name))
+	var expr sqlparser.BoolExpr
+	for _, filter := range f.filters {
+		if (len(filter.User) != 0 && filter.User != user) ||
+			!matchName(filter.DB, db) || !strings.EqualFold(filter.Table, name) {
+			continue
+		}
+		//checked in NewRowFilter
+		e, _ := router.ParsePredicate(filter.Filter, alias)
+		expr = andBoolExpr(expr, e)
+	}
+	return expr
+}
+
+//the filters of the tables in expr, the filters of the tables on the
+//nullable side of an outer join are added to its on condition instead
+func (f *RowFilter) tableExprFilter(user string, db string, expr sqlparser.TableExpr) sqlparser.BoolExpr {
+	switch e := expr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		if t, ok := e.Expr.(*sqlparser.TableName); ok {
+			return f.tableFilter(user, db, t, string(e.As))
+		}
+	case *sqlparser.ParenTableExpr:
+		return f.tableExprFilter(user, db, e.Expr)
+	case *sqlparser.JoinTableExpr:
+		left := f.tableExprFilter(user, db, e.LeftExpr)
+		right := f.tableExprFilter(user, db, e.RightExpr)
+		switch e.Join {
+		case sqlparser.AST_LEFT_JOIN:
+			if right != nil {
+				e.On = andBoolExpr(e.On, right)
+				right = nil
+			}
+		case sqlparser.AST_RIGHT_JOIN:
+			if left != nil {
+				e.On = andBoolExpr(e.On, left)
+				left = nil
+			}
+		}
+		return andBoolExpr(left, right)
+	}
+	return nil
+}
+
+//the first filtered table of user referred in sql by itself or by its
+//sub tables, empty if none
+func (f *RowFilter) referredTable(user string, sql string) string {
+	for _, name := range identifierRegexp.FindAllString(sql, -1) {
+		name = logicalTable(f.rule, name)
+		for _, filter := range f.filters {
+			if (len(filter.User) == 0 || filter.User == user) && strings.EqualFold(filter.Table, name) {
+				return filter.Table
+			}
+		}
+	}
+	return ""
+}
+
+func andBoolExpr(left sqlparser.BoolExpr, right sqlparser.BoolExpr) sqlparser.BoolExpr {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	return &sqlparser.AndExpr{
+		Left:  &sqlparser.ParenBoolExpr{Expr: left},
+		Right: &sqlparser.ParenBoolExpr{Expr: right},
+	}
+}
+
+func rowFilterError(table string, reason string) error {
+	return mysql.NewError(mysql.ER_KS_OPERATION_DENIED,
+		fmt.Sprintf("row filter of table %s can not be applied to %s", table, reason))
+}
+
+//append the row filters of the user to the where of stmt before it is
+//planned. The filtered tables in the subqueries and in the selects of
+//union and insert are rejected, since they are not filtered.
+func (c *ClientConn) filterRows(stmt sqlparser.Statement) error {
+	if c.schema == nil || c.schema.rowFilter == nil {
+		return nil
+	}
+	f := c.schema.rowFilter
+	if !f.HasUser(c.user) {
+		return nil
+	}
+
+	var selects string
+	switch v := stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Update, *sqlparser.Delete:
+		s := strings.ToLower(sqlparser.String(v))
+		if i := strings.Index(s, "(select"); i != -1 {
+			if table := f.referredTable(c.user, s[i:]); len(table) != 0 {
+				return rowFilterError(table, "subquery")
+			}
+		}
+	case *sqlparser.Union:
+		selects = sqlparser.String(v)
+	case *sqlparser.Insert:
+		if rows, ok := v.Rows.(sqlparser.SelectStatement); ok {
+			selects = sqlparser.String(rows)
+		}
+	case *sqlparser.Replace:
+		if rows, ok := v.Rows.(sqlparser.SelectStatement); ok {
+			selects = sqlparser.String(rows)
+		}
+	}
+	if table := f.referredTable(c.user, selects); len(table) != 0 {
+		return rowFilterError(table, "union or insert select")
+	}
+
+	switch v := stmt.(type) {
+	case *sqlparser.Select:
+		var expr sqlparser.BoolExpr
+		for _, e := range v.From {
+			expr = andBoolExpr(expr, f.tableExprFilter(c.user, c.db, e))
+		}
+		if expr != nil {
+			v.Where = router.AndWhere(v.Where, expr)
+		}
+	case *sqlparser.Update:
+		if expr := f.tableFilter(c.user, c.db, v.Table, ""); expr != nil {
+			v.Where = router.AndWhere(v.Where, expr)
+		}
+	case *sqlparser.Delete:
+		if expr := f.tableFilter(c.user, c.db, v.Table, ""); expr != nil {
+			v.Where = router.AndWhere(v.Where, expr)
+		}
+	}
+	return nil
+}
+
+//the sqls sent to the backend without plan, such as the raw sqls, the
+//sqls with node hint, the sqls not parsed and the prepared statements,
+//can not refer to the filtered tables of the user or their sub tables
+func (c *ClientConn) checkRowFilterRaw(sql string) error {
+	if c.schema == nil || c.schema.rowFilter == nil {
+		return nil
+	}
+	f := c.schema.rowFilter
+	if !f.HasUser(c.user) {
+		return nil
+	}
+	if table := f.referredTable(c.user, sql); len(table) != 0 {
+		return rowFilterError(table, "the sql without route")
+	}
+	return nil
+}
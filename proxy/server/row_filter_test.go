@@ -0,0 +1,104 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestNewRowFilter(t *testing.T) {
+	schema := &config.SchemaConfig{
+		ShardRule: []config.ShardConfig{
+			{DB: "kingshard", Table: "t", Type: "hash"},
+			{DB: "kingshard", Table: "s", Type: "single"},
+		},
+	}
+	for _, cfg := range []config.RowFilterConfig{
+		{Table: "u", Filter: "region = 'EU'"},
+		{Table: "s", Filter: "region = 'EU'"},
+		{Table: "t", Filter: "region ="},
+		{Filter: "region = 'EU'"},
+	} {
+		if _, err := NewRowFilter([]config.RowFilterConfig{cfg}, schema, nil); err == nil {
+			t.Fatal(cfg)
+		}
+	}
+	if _, err := NewRowFilter([]config.RowFilterConfig{{DB: "kingshard", Table: "t", Filter: "region = 'EU'"}}, schema, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+row_filters :
+-
+    user : root
+    table : t
+    filter : region = 'EU'
+-
+    user : reporter
+    table : t
+    filter : region = 'US'
+`)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+
+	tests := []struct {
+		sql     string
+		backend int
+		query   string
+	}{
+		{"select * from t where id = 1", 1, "select * from t_0001 where (id = 1) and (region = 'EU')"},
+		{"select * from t", 0, "select * from t_0000 where (region = 'EU')"},
+		{"select a.id from t as a where a.id = 1", 1, "where (a.id = 1) and (a.region = 'EU')"},
+		{"update t set v = 1 where id = 1", 1, "update t_0001 set v = 1 where (id = 1) and (region = 'EU')"},
+		{"delete from t where id = 1", 1, "delete from t_0001 where (id = 1) and (region = 'EU')"},
+		{"select * from u", 0, "select * from u"},
+	}
+	for _, test := range tests {
+		backends[test.backend].ClearQueries()
+		if _, err := c.Execute(test.sql); err != nil {
+			t.Fatal(test.sql, err)
+		}
+		if !hasQuery(backends[test.backend].Queries(), test.query) {
+			t.Fatal(test.sql, backends[test.backend].Queries())
+		}
+	}
+
+	for _, sql := range []string{
+		"select * from t where id in (select id from t where id = 1)",
+		"/*raw*/ select * from t",
+		"select * from t_0001 where id = 1",
+		"/*raw*/ select * from t_0000",
+		"/*node2*/ select * from t_0001",
+		"with a as (select * from t) select * from a",
+		"select * from u union select * from t",
+		"insert into u select * from t",
+	} {
+		_, err := c.Execute(sql)
+		if e, ok := err.(*mysql.SqlError); !ok || e.Code != mysql.ER_KS_OPERATION_DENIED {
+			t.Fatal(sql, err)
+		}
+	}
+}
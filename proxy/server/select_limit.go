@@ -0,0 +1,140 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//SelectLimiter limits the rows of the selects by the users and tables of
+//its limits, so an interactive client does not pull a whole table by
+//accident
+type SelectLimiter struct {
+	limits []config.SelectLimitConfig
+}
+
+func NewSelectLimiter(cfgs []config.SelectLimitConfig) (*SelectLimiter, error) {
+	l := new(SelectLimiter)
+	for i, cfg := range cfgs {
+		if cfg.MaxRows <= 0 {
+			return nil, fmt.Errorf("select limit %d has no max_rows", i)
+		}
+		cfg.DB = strings.Trim(cfg.DB, "`")
+		cfg.Table = strings.Trim(cfg.Table, "`")
+		l.limits = append(l.limits, cfg)
+	}
+	return l, nil
+}
+
+//the max rows of a select of user reading tables, 0 if no limit matches
+func (l *SelectLimiter) MaxRows(user string, tables []*sqlparser.TableName) int {
+	maxRows := 0
+	for _, limit := range l.limits {
+		if len(limit.User) != 0 && limit.User != user {
+			continue
+		}
+		matched := false
+		for _, t := range tables {
+			if matchName(limit.DB, string(t.Qualifier)) && matchName(limit.Table, string(t.Name)) {
+				matched = true
+				break
+			}
+		}
+		if matched && (maxRows == 0 || limit.MaxRows < maxRows) {
+			maxRows = limit.MaxRows
+		}
+	}
+	return maxRows
+}
+
+func matchName(pattern string, name string) bool {
+	return len(pattern) == 0 || strings.EqualFold(pattern, strings.Trim(name, "`"))
+}
+
+//the tables of from, the db of the tables without db is db
+func fromTables(exprs sqlparser.TableExprs, db string) []*sqlparser.TableName {
+	var tables []*sqlparser.TableName
+	var walk func(expr sqlparser.TableExpr)
+	walk = func(expr sqlparser.TableExpr) {
+		switch e := expr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if t, ok := e.Expr.(*sqlparser.TableName); ok {
+				qualifier := t.Qualifier
+				if len(qualifier) == 0 {
+					qualifier = []byte(db)
+				}
+				tables = append(tables, &sqlparser.TableName{Name: t.Name, Qualifier: qualifier})
+			}
+		case *sqlparser.ParenTableExpr:
+			walk(e.Expr)
+		case *sqlparser.JoinTableExpr:
+			walk(e.LeftExpr)
+			walk(e.RightExpr)
+		}
+	}
+	for _, expr := range exprs {
+		walk(expr)
+	}
+	return tables
+}
+
+//add a LIMIT to the select matching the select limits, or tighten its
+//LIMIT, and add a warning. The other sqls are returned unchanged.
+func (c *ClientConn) limitSelect(sql string) string {
+	limiter := c.state.limiter
+	if limiter == nil || len(limiter.limits) == 0 {
+		return sql
+	}
+	tokens := strings.FieldsFunc(sql, hack.IsSqlSep)
+	if len(tokens) == 0 || strings.ToLower(tokens[0]) != "select" {
+		return sql
+	}
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return sql
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return sql
+	}
+	maxRows := limiter.MaxRows(c.user, fromTables(sel.From, c.db))
+	if maxRows == 0 {
+		return sql
+	}
+
+	rowcount := sqlparser.NumVal(strconv.Itoa(maxRows))
+	if sel.Limit == nil {
+		sel.Limit = &sqlparser.Limit{Rowcount: rowcount}
+	} else {
+		v, ok := sel.Limit.Rowcount.(sqlparser.NumVal)
+		if !ok {
+			return sql
+		}
+		if n, err := strconv.ParseInt(string(v), 10, 64); err != nil || n <= int64(maxRows) {
+			return sql
+		}
+		sel.Limit = &sqlparser.Limit{Offset: sel.Limit.Offset, Rowcount: rowcount}
+	}
+	c.addWarning(mysql.ER_KS_SELECT_LIMITED,
+		fmt.Sprintf("select is limited to %d rows", maxRows))
+	return sqlparser.String(sel)
+}
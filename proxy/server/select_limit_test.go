@@ -0,0 +1,85 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestSelectLimit(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+select_limits :
+-
+    user : root
+    table : t
+    max_rows : 10
+-
+    db : kingshard
+    table : u
+    max_rows : 5
+-
+    user : reporter
+    max_rows : 1
+`)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+
+	tests := []struct {
+		sql     string
+		backend int
+		query   string
+		limited bool
+	}{
+		{"select * from t where id = 1", 1, "from t_0001 where id = 1 limit 10", true},
+		{"select * from t where id = 1 limit 2, 100", 1, "where id = 1 limit 12", true},
+		{"select * from t where id = 1 limit 3", 1, "where id = 1 limit 3", false},
+		{"select * from u", 0, "select * from u limit 5", true},
+		{"select * from kingshard.u as a join v on a.id = v.id", 0, "limit 5", true},
+		{"select * from v", 0, "select * from v", false},
+	}
+	for _, test := range tests {
+		backends[test.backend].ClearQueries()
+		if _, err := c.Execute(test.sql); err != nil {
+			t.Fatal(test.sql, err)
+		}
+		if !hasQuery(backends[test.backend].Queries(), test.query) {
+			t.Fatal(test.sql, backends[test.backend].Queries())
+		}
+		r, err := c.Execute("show warnings")
+		if err != nil {
+			t.Fatal(err)
+		}
+		//show warnings is sent to the backend if kingshard has no warning
+		limited := false
+		if r.Resultset != nil && r.RowNumber() == 1 {
+			code, _ := r.GetUint(0, 1)
+			limited = code == uint64(mysql.ER_KS_SELECT_LIMITED)
+		}
+		if limited != test.limited {
+			t.Fatal(test.sql, limited)
+		}
+	}
+	if hasQuery(backends[0].Queries(), "select * from v limit") {
+		t.Fatal(backends[0].Queries())
+	}
+}
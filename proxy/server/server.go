@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -33,11 +34,13 @@ import (
 	"github.com/flike/kingshard/core/errors"
 	"github.com/flike/kingshard/core/golog"
 	"github.com/flike/kingshard/proxy/router"
+	"golang.org/x/net/context"
 )
 
 type Schema struct {
-	nodes map[string]*backend.Node
-	rule  *router.Router
+	nodes     map[string]*backend.Node
+	rule      *router.Router
+	rowFilter *RowFilter
 }
 
 type BlacklistSqls struct {
@@ -52,7 +55,8 @@ const (
 )
 
 type Server struct {
-	cfg      *config.Config
+	//the *serverState published by Reload, see getState
+	state    atomic.Value
 	addr     string
 	user     string
 	password string
@@ -62,6 +66,7 @@ type Server struct {
 	status             [2]int32
 	logSqlIndex        int32
 	logSql             [2]string
+	logSqlScrub        int32 //1 if the literals of logged sqls are scrubbed
 	slowLogTimeIndex   int32
 	slowLogTime        [2]int
 	blacklistSqlsIndex int32
@@ -69,12 +74,55 @@ type Server struct {
 	allowipsIndex      int32
 	allowips           [2][]net.IP
 
-	counter *Counter
-	nodes   map[string]*backend.Node
-	schema  *Schema
-
-	listener net.Listener
-	running  bool
+	counter    *Counter
+	stageTimes *StageTimes
+	shardHeat  *ShardHeat
+	hotKey     *HotKeyDetector
+	connLimit  *ConnLimiter
+	faults     *FaultInjector
+	parseFails *ParseFailStats
+	tagStats   *TagStats
+	execStats  *ExecStats
+
+	//the default charset of the client conns and backend conns
+	charset   string
+	collation mysql.CollationId
+	//0 means no limit
+	handshakeTimeout   time.Duration
+	maxHandshakeConns  int64
+	clientWriteTimeout time.Duration
+	clientKeepAlive    clientKeepAlive
+	//0 means no retry
+	readOnlyRetryWait time.Duration
+
+	//the hooks of statements, see RegisterHook
+	hooks []Hook
+	//the change stream of sharding tables, nil if not set
+	cdcPublisher ChangePublisher
+	cdcStreams   []*binlogStream
+	//the state shared with other instances, nil if not in a cluster
+	cluster *Cluster
+	//the packets of the conns are recorded if it is not nil
+	recorder *mysql.Recorder
+	//the multi-node writes are journaled if it is not nil
+	journal *WriteJournal
+	//the stats are rolled up per minute if it is not nil
+	statsHistory *StatsHistory
+	//only one reload at a time
+	reloadLock sync.Mutex
+	//closed when the nodes of the last state replaced are closed, see publishState
+	retired chan struct{}
+	//the rules staged for comparison, see StageConfig
+	staged     *stagedRules
+	stagedLock sync.Mutex
+	//the client conns by connection id, for show processlist
+	clients     map[uint32]*ClientConn
+	clientsLock sync.Mutex
+
+	listeners []net.Listener
+	running   int32         //1 if running, see isRunning
+	done      chan struct{} //closed by Close
+	closeOnce sync.Once
 }
 
 func (s *Server) Status() string {
@@ -95,7 +143,7 @@ func (s *Server) Status() string {
 //TODO
 func (s *Server) parseAllowIps() error {
 	atomic.StoreInt32(&s.allowipsIndex, 0)
-	cfg := s.cfg
+	cfg := s.getState().cfg
 	if len(cfg.AllowIps) == 0 {
 		return nil
 	}
@@ -110,10 +158,11 @@ func (s *Server) parseAllowIps() error {
 
 //TODO parse the blacklist sql file
 func (s *Server) parseBlackListSqls() error {
+	cfg := s.getState().cfg
 	bs := new(BlacklistSqls)
 	bs.sqls = make(map[string]string)
-	if len(s.cfg.BlsFile) != 0 {
-		file, err := os.Open(s.cfg.BlsFile)
+	if len(cfg.BlsFile) != 0 {
+		file, err := os.Open(cfg.BlsFile)
 		if err != nil {
 			return err
 		}
@@ -145,12 +194,62 @@ func (s *Server) parseBlackListSqls() error {
 	return nil
 }
 
+//the packets of the client and backend conns are appended to the file of
+//protocol_record
+func (s *Server) parseRecorder() error {
+	cfg := s.getState().cfg
+	if len(cfg.ProtocolRecord) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(cfg.ProtocolRecord, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.recorder = mysql.NewRecorder(f)
+	golog.Warn("server", "parseRecorder", "the packets are recorded", 0,
+		"protocol_record", cfg.ProtocolRecord)
+	return nil
+}
+
+//the deprecated fields upgraded in cfg are warned, see config/upgrade.go
+func logConfigWarnings(cfg *config.Config) {
+	for _, w := range cfg.Warnings {
+		golog.Warn("server", "config", w, 0, "version", cfg.Version)
+	}
+}
+
+func (s *Server) parseWriteJournal() error {
+	cfg := s.getState().cfg
+	if len(cfg.WriteJournal) == 0 {
+		return nil
+	}
+	var err error
+	if s.journal, err = OpenWriteJournal(cfg.WriteJournal); err != nil {
+		return err
+	}
+	golog.Info("server", "parseWriteJournal", "the multi-node writes are journaled", 0,
+		"write_journal", cfg.WriteJournal,
+		"pending", len(s.journal.Pending()))
+	return nil
+}
+
 func (s *Server) parseNode(cfg config.NodeConfig) (*backend.Node, error) {
 	var err error
 	n := new(backend.Node)
 	n.Cfg = cfg
+	for from, to := range cfg.SchemaRewrite {
+		if len(from) == 0 || len(to) == 0 {
+			return nil, fmt.Errorf("invalid schema_rewrite %s: %s of node %s", from, to, cfg.Name)
+		}
+	}
 
 	n.DownAfterNoAlive = time.Duration(cfg.DownAfterNoAlive) * time.Second
+	n.Timeouts = backend.ParseTimeouts(cfg)
+	if n.Transport, err = backend.ParseTransport(cfg); err != nil {
+		return nil, err
+	}
+	n.Transport.Recorder = s.recorder
+	n.Charset, n.Collation = s.charset, s.collation
 	err = n.ParseMaster(cfg.Master)
 	if err != nil {
 		return nil, err
@@ -159,18 +258,22 @@ func (s *Server) parseNode(cfg config.NodeConfig) (*backend.Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = n.ParseBackup(cfg.Backup)
+	if err != nil {
+		return nil, err
+	}
 
 	go n.CheckNode()
 
 	return n, nil
 }
 
-func (s *Server) parseNodes() error {
-	cfg := s.cfg
-	s.nodes = make(map[string]*backend.Node, len(cfg.Nodes))
+func (s *Server) parseNodes(st *serverState) error {
+	cfg := st.cfg
+	st.nodes = make(map[string]*backend.Node, len(cfg.Nodes))
 
 	for _, v := range cfg.Nodes {
-		if _, ok := s.nodes[v.Name]; ok {
+		if _, ok := st.nodes[v.Name]; ok {
 			return fmt.Errorf("duplicate node [%s]", v.Name)
 		}
 
@@ -179,21 +282,21 @@ func (s *Server) parseNodes() error {
 			return err
 		}
 
-		s.nodes[v.Name] = n
+		st.nodes[v.Name] = n
 	}
 
 	return nil
 }
 
-func (s *Server) parseSchema() error {
-	schemaCfg := s.cfg.Schema
+func (st *serverState) parseSchema() error {
+	schemaCfg := st.cfg.Schema
 	if len(schemaCfg.Nodes) == 0 {
 		return fmt.Errorf("schema must have a node")
 	}
 
 	nodes := make(map[string]*backend.Node)
 	for _, n := range schemaCfg.Nodes {
-		if s.GetNode(n) == nil {
+		if st.nodes[n] == nil {
 			return fmt.Errorf("schema node [%s] config is not exists", n)
 		}
 
@@ -201,27 +304,82 @@ func (s *Server) parseSchema() error {
 			return fmt.Errorf("schema node [%s] duplicate", n)
 		}
 
-		nodes[n] = s.GetNode(n)
+		nodes[n] = st.nodes[n]
 	}
 
 	rule, err := router.NewRouter(&schemaCfg)
 	if err != nil {
 		return err
 	}
+	rowFilter, err := NewRowFilter(st.cfg.RowFilters, &schemaCfg, rule)
+	if err != nil {
+		return err
+	}
+
+	st.schema = &Schema{
+		nodes:     nodes,
+		rule:      rule,
+		rowFilter: rowFilter,
+	}
+
+	return nil
+}
+
+func (st *serverState) parseRewriteRules() error {
+	rewriter, err := NewSqlRewriter(st.cfg.RewriteRules)
+	if err != nil {
+		return err
+	}
+	st.rewriter = rewriter
+	return nil
+}
 
-	s.schema = &Schema{
-		nodes: nodes,
-		rule:  rule,
+func (st *serverState) parseMockRules() error {
+	mocker, err := NewSqlMocker(st.cfg.MockRules)
+	if err != nil {
+		return err
 	}
+	st.mocker = mocker
+	return nil
+}
 
+func (st *serverState) parseSelectLimits() error {
+	limiter, err := NewSelectLimiter(st.cfg.SelectLimits)
+	if err != nil {
+		return err
+	}
+	st.limiter = limiter
+	return nil
+}
+
+func (st *serverState) parseColumnMasks() error {
+	masker, err := NewColumnMasker(st.cfg.ColumnMasks)
+	if err != nil {
+		return err
+	}
+	st.masker = masker
 	return nil
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
 	s := new(Server)
 
-	s.cfg = cfg
+	//the state is filled below before the server is returned
+	st := newServerState(cfg)
+	s.state.Store(st)
+	logConfigWarnings(cfg)
+	s.done = make(chan struct{})
 	s.counter = new(Counter)
+	s.stageTimes = NewStageTimes()
+	s.shardHeat = NewShardHeat()
+	s.hotKey = NewHotKeyDetector(cfg.HotKey)
+	s.connLimit = NewConnLimiter(cfg.ConnLimit)
+	s.parseFails = NewParseFailStats()
+	s.tagStats = NewTagStats()
+	s.execStats = NewExecStats()
+	st.userQuota = NewUserQuota(cfg.Users)
+	s.faults = new(FaultInjector)
+	s.clients = make(map[uint32]*ClientConn)
 	s.addr = cfg.Addr
 	s.user = cfg.User
 	s.password = cfg.Password
@@ -229,6 +387,7 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	s.status[s.statusIndex] = Online
 	atomic.StoreInt32(&s.logSqlIndex, 0)
 	s.logSql[s.logSqlIndex] = cfg.LogSql
+	s.setLogSqlScrub(cfg.LogSqlScrub)
 	atomic.StoreInt32(&s.slowLogTimeIndex, 0)
 	s.slowLogTime[s.slowLogTimeIndex] = cfg.SlowLogTime
 
@@ -239,10 +398,8 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	if !ok {
 		return nil, errors.ErrInvalidCharset
 	}
-	//change the default charset
-	mysql.DEFAULT_CHARSET = cfg.Charset
-	mysql.DEFAULT_COLLATION_ID = cid
-	mysql.DEFAULT_COLLATION_NAME = mysql.Collations[cid]
+	s.charset = cfg.Charset
+	s.collation = cid
 
 	if err := s.parseBlackListSqls(); err != nil {
 		return nil, err
@@ -252,18 +409,91 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
-	if err := s.parseNodes(); err != nil {
+	if err := checkParseFailPolicy(cfg.ParseFailPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := checkTxFailoverPolicy(cfg.TxFailoverPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := checkUnsupportPolicy(cfg.UnsupportPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := checkPasswordExpires(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := checkConsistencies(cfg); err != nil {
+		return nil, err
+	}
+	s.parseHandshakeLimits()
+	s.parseReadOnlyRetryWait()
+	if err := s.parseClientKeepAlive(); err != nil {
+		return nil, err
+	}
+
+	if err := st.parseRewriteRules(); err != nil {
+		return nil, err
+	}
+
+	if err := st.parseMockRules(); err != nil {
+		return nil, err
+	}
+
+	if err := st.parseSelectLimits(); err != nil {
+		return nil, err
+	}
+
+	if err := st.parseColumnMasks(); err != nil {
+		return nil, err
+	}
+
+	if err := s.parseRecorder(); err != nil {
+		return nil, err
+	}
+
+	if err := s.parseWriteJournal(); err != nil {
+		return nil, err
+	}
+
+	if err := s.parseStatsHistory(); err != nil {
+		return nil, err
+	}
+
+	if err := s.parseNodes(st); err != nil {
+		return nil, err
+	}
+
+	if err := st.parseSchema(); err != nil {
 		return nil, err
 	}
 
-	if err := s.parseSchema(); err != nil {
+	if err := st.parseReadiness(); err != nil {
 		return nil, err
 	}
 
 	var err error
+	if st.authenticator, err = NewAuthenticator(&cfg.Auth); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.CDC.NatsAddr) != 0 {
+		if s.cdcPublisher, err = NewNatsPublisher(cfg.CDC.NatsAddr, DefaultNatsTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Cluster.EtcdAddrs) != 0 {
+		if s.cluster, err = NewCluster(s, cfg.Cluster); err != nil {
+			return nil, err
+		}
+	}
+
 	netProto := "tcp"
 
-	s.listener, err = net.Listen(netProto, s.addr)
+	s.listeners, err = newListeners(netProto, s.addr, cfg.Acceptors)
 
 	if err != nil {
 		return nil, err
@@ -273,13 +503,19 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		"netProto",
 		netProto,
 		"address",
-		s.addr)
+		s.addr,
+		"acceptors",
+		len(s.listeners))
 	return s, nil
 }
 
 func (s *Server) flushCounter() {
-	for {
+	for s.isRunning() {
 		s.counter.FlushCounter()
+		s.shardHeat.Flush()
+		if s.statsHistory != nil {
+			s.statsHistory.Flush(time.Now())
+		}
 		time.Sleep(1 * time.Second)
 	}
 }
@@ -294,11 +530,16 @@ func (s *Server) newClientConn(co net.Conn) *ClientConn {
 	// meaning that data is sent as soon as possible after a Write.
 	//I set this option false.
 	tcpConn.SetNoDelay(false)
+	s.setClientKeepAlive(tcpConn)
 	c.c = tcpConn
 
-	c.schema = s.GetSchema()
+	c.state = s.getState()
+	c.schema = c.state.schema
 
 	c.pkg = mysql.NewPacketIO(tcpConn)
+	if s.recorder != nil {
+		c.pkg.Recorder = s.recorder.NewConn("client "+tcpConn.RemoteAddr().String(), false)
+	}
 	c.proxy = s
 
 	c.pkg.Sequence = 0
@@ -313,11 +554,13 @@ func (s *Server) newClientConn(co net.Conn) *ClientConn {
 
 	c.closed = false
 
-	c.charset = mysql.DEFAULT_CHARSET
-	c.collation = mysql.DEFAULT_COLLATION_ID
+	c.charset = s.charset
+	c.collation = s.collation
 
 	c.stmtId = 0
 	c.stmts = make(map[uint32]*Stmt)
+	c.planCache = NewPlanCache(c.state.cfg.PlanCacheSize)
+	c.ctx = context.Background()
 
 	return c
 }
@@ -348,13 +591,24 @@ func (s *Server) onConn(c net.Conn) {
 		conn.Close()
 		return
 	}
-	if err := conn.Handshake(); err != nil {
+	if err := s.connLimit.Allow(conn.remoteIP()); err != nil {
+		conn.writeError(err)
+		conn.Close()
+		return
+	}
+	if err := s.handshake(conn); err != nil {
 		golog.Error("server", "onConn", err.Error(), 0)
 		conn.writeError(err)
 		conn.Close()
 		return
 	}
 
+	s.addClient(conn)
+	defer s.removeClient(conn)
+	//the client added after Close is not closed by it
+	if !s.isRunning() {
+		return
+	}
 	conn.Run()
 }
 
@@ -388,6 +642,15 @@ func (s *Server) ChangeLogSql(v string) error {
 	if v != golog.LogSqlOn && v != golog.LogSqlOff {
 		return errors.ErrCmdUnsupport
 	}
+	s.switchLogSql(v)
+	s.updateConfig(func(cfg *config.Config) {
+		cfg.LogSql = v
+	})
+
+	return nil
+}
+
+func (s *Server) switchLogSql(v string) {
 	if s.logSqlIndex == 0 {
 		s.logSql[1] = v
 		atomic.StoreInt32(&s.logSqlIndex, 1)
@@ -395,9 +658,6 @@ func (s *Server) ChangeLogSql(v string) error {
 		s.logSql[0] = v
 		atomic.StoreInt32(&s.logSqlIndex, 0)
 	}
-	s.cfg.LogSql = v
-
-	return nil
 }
 
 func (s *Server) ChangeSlowLogTime(v string) error {
@@ -413,7 +673,9 @@ func (s *Server) ChangeSlowLogTime(v string) error {
 		s.slowLogTime[0] = tmp
 		atomic.StoreInt32(&s.slowLogTimeIndex, 0)
 	}
-	s.cfg.SlowLogTime = tmp
+	s.updateConfig(func(cfg *config.Config) {
+		cfg.SlowLogTime = tmp
+	})
 
 	return err
 }
@@ -437,11 +699,13 @@ func (s *Server) AddAllowIP(v string) error {
 		atomic.StoreInt32(&s.allowipsIndex, 0)
 	}
 
-	if s.cfg.AllowIps == "" {
-		s.cfg.AllowIps = strings.Join([]string{s.cfg.AllowIps, v}, "")
-	} else {
-		s.cfg.AllowIps = strings.Join([]string{s.cfg.AllowIps, v}, ",")
-	}
+	s.updateConfig(func(cfg *config.Config) {
+		if cfg.AllowIps == "" {
+			cfg.AllowIps = strings.Join([]string{cfg.AllowIps, v}, "")
+		} else {
+			cfg.AllowIps = strings.Join([]string{cfg.AllowIps, v}, ",")
+		}
+	})
 
 	return nil
 }
@@ -451,35 +715,21 @@ func (s *Server) DelAllowIP(v string) error {
 
 	if s.allowipsIndex == 0 {
 		s.allowips[1] = s.allowips[0]
-		ipVec2 := strings.Split(s.cfg.AllowIps, ",")
 		for i, ip := range s.allowips[1] {
 			if ip.Equal(clientIP) {
 				s.allowips[1] = append(s.allowips[1][:i], s.allowips[1][i+1:]...)
 				atomic.StoreInt32(&s.allowipsIndex, 1)
-				for i, ip := range ipVec2 {
-					if ip == v {
-						ipVec2 = append(ipVec2[:i], ipVec2[i+1:]...)
-						s.cfg.AllowIps = strings.Trim(strings.Join(ipVec2, ","), ",")
-						return nil
-					}
-				}
+				s.delConfigAllowIP(v)
 				return nil
 			}
 		}
 	} else {
 		s.allowips[0] = s.allowips[1]
-		ipVec2 := strings.Split(s.cfg.AllowIps, ",")
 		for i, ip := range s.allowips[0] {
 			if ip.Equal(clientIP) {
 				s.allowips[0] = append(s.allowips[0][:i], s.allowips[0][i+1:]...)
 				atomic.StoreInt32(&s.allowipsIndex, 0)
-				for i, ip := range ipVec2 {
-					if ip == v {
-						ipVec2 = append(ipVec2[:i], ipVec2[i+1:]...)
-						s.cfg.AllowIps = strings.Trim(strings.Join(ipVec2, ","), ",")
-						return nil
-					}
-				}
+				s.delConfigAllowIP(v)
 				return nil
 			}
 		}
@@ -488,6 +738,20 @@ func (s *Server) DelAllowIP(v string) error {
 	return nil
 }
 
+//sync the allow ip deleted to global config
+func (s *Server) delConfigAllowIP(v string) {
+	s.updateConfig(func(cfg *config.Config) {
+		ipVec2 := strings.Split(cfg.AllowIps, ",")
+		for i, ip := range ipVec2 {
+			if ip == v {
+				ipVec2 = append(ipVec2[:i], ipVec2[i+1:]...)
+				cfg.AllowIps = strings.Trim(strings.Join(ipVec2, ","), ",")
+				return
+			}
+		}
+	})
+}
+
 func (s *Server) GetAllBlackSqls() []string {
 	blackSQLs := make([]string, 0, 10)
 	for _, SQL := range s.blacklistSqls[s.blacklistSqlsIndex].sqls {
@@ -550,14 +814,15 @@ func (s *Server) DelBlackSql(v string) error {
 }
 
 func (s *Server) saveBlackSql() error {
-	if len(s.cfg.BlsFile) == 0 {
+	cfg := s.getState().cfg
+	if len(cfg.BlsFile) == 0 {
 		return nil
 	}
-	f, err := os.Create(s.cfg.BlsFile)
+	f, err := os.Create(cfg.BlsFile)
 	if err != nil {
 		golog.Error("Server", "saveBlackSql", "create file error", 0,
 			"err", err.Error(),
-			"blacklist_sql_file", s.cfg.BlsFile,
+			"blacklist_sql_file", cfg.BlsFile,
 		)
 		return err
 	}
@@ -574,7 +839,7 @@ func (s *Server) saveBlackSql() error {
 }
 
 func (s *Server) SaveProxyConfig() error {
-	err := config.WriteConfigFile(s.cfg)
+	err := config.WriteConfigFile(s.getState().cfg)
 	if err != nil {
 		return err
 	}
@@ -588,13 +853,63 @@ func (s *Server) SaveProxyConfig() error {
 }
 
 func (s *Server) Run() error {
-	s.running = true
+	return s.RunContext(context.Background())
+}
+
+//RunContext serves the clients until Close is called or ctx is done, such
+//as the server embedded in other services or tests
+func (s *Server) RunContext(ctx context.Context) error {
+	cfg := s.getState().cfg
+	atomic.StoreInt32(&s.running, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
 
 	// flush counter
 	go s.flushCounter()
+	if 0 < cfg.LagCheckInterval {
+		go s.checkLagLoop(time.Duration(cfg.LagCheckInterval) * time.Millisecond)
+	}
+	if 0 < cfg.DDLCheckInterval {
+		go s.checkDDLDriftLoop(time.Duration(cfg.DDLCheckInterval) * time.Second)
+	}
+	if 0 < cfg.ShardSplit.CheckInterval {
+		go s.checkShardSplitLoop(time.Duration(cfg.ShardSplit.CheckInterval) * time.Second)
+	}
+	if 0 < cfg.DateShard.CheckInterval {
+		go s.checkDateShardsLoop(time.Duration(cfg.DateShard.CheckInterval) * time.Second)
+	}
+	go s.checkVersionsLoop(VersionCheckInterval)
+	if s.cdcPublisher != nil {
+		s.startCDC()
+	}
+	if s.cluster != nil {
+		go s.cluster.run(s.done)
+	}
+	if s.journal != nil {
+		go s.recoverJournal()
+	}
+
+	for _, l := range s.listeners[1:] {
+		go s.serve(l)
+	}
+	s.serve(s.listeners[0])
+
+	return nil
+}
+
+func (s *Server) isRunning() bool {
+	return atomic.LoadInt32(&s.running) == 1
+}
 
-	for s.running {
-		conn, err := s.listener.Accept()
+//the accept loop of a listener
+func (s *Server) serve(l net.Listener) {
+	for s.isRunning() {
+		conn, err := l.Accept()
 		if err != nil {
 			golog.Error("server", "Run", err.Error(), 0)
 			continue
@@ -602,15 +917,41 @@ func (s *Server) Run() error {
 
 		go s.onConn(conn)
 	}
-
-	return nil
 }
 
+//Close stops the listeners, closes the client conns and the nodes
 func (s *Server) Close() {
-	s.running = false
-	if s.listener != nil {
-		s.listener.Close()
-	}
+	s.closeOnce.Do(func() {
+		atomic.StoreInt32(&s.running, 0)
+		for _, l := range s.listeners {
+			l.Close()
+		}
+		s.closeClients()
+		for _, n := range s.getState().nodes {
+			n.Close()
+		}
+		if s.cdcPublisher != nil {
+			s.cdcPublisher.Close()
+		}
+		if s.recorder != nil {
+			s.recorder.Close()
+		}
+		if s.journal != nil {
+			s.journal.Close()
+		}
+		if s.statsHistory != nil {
+			s.statsHistory.Close()
+		}
+		if s.done != nil {
+			close(s.done)
+		}
+	})
+}
+
+//Addr returns the address of listeners, such as the port chosen for the
+//addr 127.0.0.1:0
+func (s *Server) Addr() net.Addr {
+	return s.listeners[0].Addr()
 }
 
 func (s *Server) DeleteSlave(node string, addr string) error {
@@ -625,19 +966,21 @@ func (s *Server) DeleteSlave(node string, addr string) error {
 	}
 
 	//sync node slave to global config
-	for i, v1 := range s.cfg.Nodes {
-		if node == v1.Name {
-			s1 := strings.Split(v1.Slave, backend.SlaveSplit)
-			s2 := make([]string, 0, len(s1)-1)
-			for _, v2 := range s1 {
-				hostPort := strings.Split(v2, backend.WeightSplit)[0]
-				if addr != hostPort {
-					s2 = append(s2, v2)
+	s.updateConfig(func(cfg *config.Config) {
+		for i, v1 := range cfg.Nodes {
+			if node == v1.Name {
+				s1 := strings.Split(v1.Slave, backend.SlaveSplit)
+				s2 := make([]string, 0, len(s1)-1)
+				for _, v2 := range s1 {
+					hostPort := strings.Split(v2, backend.WeightSplit)[0]
+					if addr != hostPort {
+						s2 = append(s2, v2)
+					}
 				}
+				cfg.Nodes[i].Slave = strings.Join(s2, backend.SlaveSplit)
 			}
-			s.cfg.Nodes[i].Slave = strings.Join(s2, backend.SlaveSplit)
 		}
-	}
+	})
 
 	return nil
 }
@@ -653,13 +996,15 @@ func (s *Server) AddSlave(node string, addr string) error {
 	}
 
 	//sync node slave to global config
-	for i, v1 := range s.cfg.Nodes {
-		if v1.Name == node {
-			s1 := strings.Split(v1.Slave, backend.SlaveSplit)
-			s1 = append(s1, addr)
-			s.cfg.Nodes[i].Slave = strings.Join(s1, backend.SlaveSplit)
+	s.updateConfig(func(cfg *config.Config) {
+		for i, v1 := range cfg.Nodes {
+			if v1.Name == node {
+				s1 := strings.Split(v1.Slave, backend.SlaveSplit)
+				s1 = append(s1, addr)
+				cfg.Nodes[i].Slave = strings.Join(s1, backend.SlaveSplit)
+			}
 		}
-	}
+	})
 
 	return nil
 }
@@ -673,6 +1018,37 @@ func (s *Server) UpMaster(node string, addr string) error {
 	return n.UpMaster(addr)
 }
 
+//SwitchMaster is called by the external failover tools to declare the new
+//master of node, the master in config is changed too, so it is kept by
+//saving the config.
+func (s *Server) SwitchMaster(node string, addr string) error {
+	n := s.GetNode(node)
+	if n == nil {
+		return fmt.Errorf("invalid node %s", node)
+	}
+
+	old, err := n.SwitchMaster(addr)
+	if err != nil {
+		return err
+	}
+	if old == addr {
+		return nil
+	}
+	s.updateConfig(func(cfg *config.Config) {
+		for i, v := range cfg.Nodes {
+			if v.Name == node {
+				cfg.Nodes[i].Master = addr
+			}
+		}
+	})
+	s.counter.IncrMasterSwitches()
+	golog.Warn("Server", "SwitchMaster", "master switched", 0,
+		"node", node,
+		"old_master", old,
+		"new_master", addr)
+	return nil
+}
+
 func (s *Server) UpSlave(node string, addr string) error {
 	n := s.GetNode(node)
 	if n == nil {
@@ -698,16 +1074,90 @@ func (s *Server) DownSlave(node, slaveAddr string) error {
 	return n.DownSlave(slaveAddr, backend.ManualDown)
 }
 
+//the passwords accepted for the proxy user, the secondary password is
+//accepted until it expires, false if the user does not exist
+func (s *Server) getPasswords(user string) ([]string, bool) {
+	cfg := s.getState().cfg
+	var password, secondaryPassword, expire string
+	if user == cfg.User {
+		password = cfg.Password
+		secondaryPassword = cfg.SecondaryPassword
+		expire = cfg.SecondaryPasswordExpire
+	} else {
+		found := false
+		for _, u := range cfg.Users {
+			if u.User == user {
+				password = u.Password
+				secondaryPassword = u.SecondaryPassword
+				expire = u.SecondaryPasswordExpire
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	passwords := []string{password}
+	if len(secondaryPassword) != 0 && !isPasswordExpired(expire, time.Now()) {
+		passwords = append(passwords, secondaryPassword)
+	}
+	return passwords, true
+}
+
+//the default tenant of the proxy user
+func (s *Server) getTenant(user string) string {
+	for _, u := range s.getState().cfg.Users {
+		if u.User == user {
+			return u.Tenant
+		}
+	}
+	return ""
+}
+
 func (s *Server) GetNode(name string) *backend.Node {
-	return s.nodes[name]
+	return s.getState().nodes[name]
 }
 
 func (s *Server) GetAllNodes() map[string]*backend.Node {
-	return s.nodes
+	return s.getState().nodes
+}
+
+func (s *Server) GetBannedIPs() []BannedIP {
+	return s.connLimit.GetBannedIPs()
+}
+
+func (s *Server) UnbanIP(ip string) error {
+	return s.connLimit.Unban(ip)
+}
+
+func (s *Server) GetRewriteRules() []RewriteRule {
+	return s.getState().rewriter.GetRules()
+}
+
+func (s *Server) GetMockRules() []MockRule {
+	return s.getState().mocker.GetRules()
+}
+
+func (s *Server) GetFaults() []Fault {
+	return s.faults.GetFaults()
+}
+
+func (s *Server) GetParseFails() []ParseFail {
+	return s.parseFails.GetParseFails()
+}
+
+func (s *Server) GetHotKeys() []HotKey {
+	return s.hotKey.GetHotKeys()
 }
 
 func (s *Server) GetSchema() *Schema {
-	return s.schema
+	return s.getState().schema
+}
+
+func (s *Server) GetStageTimes() []StageTime {
+	return s.stageTimes.Stats()
 }
 
 func (s *Server) GetSlowLogTime() int {
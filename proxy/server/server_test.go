@@ -51,6 +51,13 @@ schema :
             -
 `)
 
+//a server with the state of cfg only, for the tests without NewServer
+func newStateServer(cfg *config.Config) *Server {
+	s := new(Server)
+	s.state.Store(newServerState(cfg))
+	return s
+}
+
 func newTestServer(t *testing.T) *Server {
 	f := func() {
 		cfg, err := config.ParseConfigData(testConfigData)
@@ -0,0 +1,100 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+const (
+	//set ks_shard = 5 pins the session to the sub table 5 of sharded tables
+	ShardPinVariable = "KS_SHARD"
+	//set ks_node = 'node2' pins the session to node2
+	NodePinVariable = "KS_NODE"
+)
+
+func pinValue(val sqlparser.ValExpr) string {
+	return strings.Trim(sqlparser.String(val), "'`\"")
+}
+
+//the sqls of sharded tables are only sent to the pinned sub table until
+//set ks_shard = ''. It clears the node pinned.
+func (c *ClientConn) handleSetShardPin(val sqlparser.ValExpr) error {
+	value := pinValue(val)
+	if len(value) == 0 {
+		c.shardPinned = false
+		return c.writeOK(nil)
+	}
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		return fmt.Errorf("invalid shard %s", value)
+	}
+	c.pinnedShard = index
+	c.shardPinned = true
+	c.pinnedNode = nil
+	return c.writeOK(nil)
+}
+
+//all the sqls are sent to the master of the pinned node without parse
+//until set ks_node = ''. It clears the shard pinned.
+func (c *ClientConn) handleSetNodePin(val sqlparser.ValExpr) error {
+	value := pinValue(val)
+	if len(value) == 0 {
+		c.pinnedNode = nil
+		return c.writeOK(nil)
+	}
+	if c.schema == nil || c.schema.nodes[value] == nil {
+		return fmt.Errorf("node %s is not in schema", value)
+	}
+	c.pinnedNode = c.schema.nodes[value]
+	c.shardPinned = false
+	return c.writeOK(nil)
+}
+
+//get the execute database for the session pinned to a node, nil if the
+//session is not pinned or the sql is handled by kingshard, such as set,
+//use and the transaction statements.
+func (c *ClientConn) getPinnedExecDB(sql string, tokens []string, tokensLen int) (*ExecuteDB, error) {
+	if c.pinnedNode == nil {
+		return nil, nil
+	}
+
+	//skip the hints before the statement
+	var i int
+	for i < tokensLen && tokens[i][0] == mysql.COMMENT_PREFIX {
+		i++
+	}
+	if tokensLen <= i {
+		return nil, nil
+	}
+	switch mysql.PARSE_TOKEN_MAP[strings.ToLower(tokens[i])] {
+	case mysql.TK_ID_SET, mysql.TK_ID_USE, mysql.TK_ID_ADMIN,
+		mysql.TK_ID_BEGIN, mysql.TK_ID_START, mysql.TK_ID_COMMIT, mysql.TK_ID_ROLLBACK:
+		return nil, nil
+	}
+
+	if err := c.checkTxNodes(c.pinnedNode); err != nil {
+		return nil, err
+	}
+	executeDB := new(ExecuteDB)
+	executeDB.sql = sql
+	executeDB.ExecNode = c.pinnedNode
+	return executeDB, nil
+}
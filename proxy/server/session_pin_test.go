@@ -0,0 +1,96 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/core/hack"
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestShardPin(t *testing.T) {
+	c := newPlanCacheConn(t, 4)
+	c.pinnedShard = 5
+	c.shardPinned = true
+
+	_, plan, err := c.parseAndBuildPlan("select * from test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqls := plan.RewrittenSqls["node2"]; len(plan.RewrittenSqls) != 1 ||
+		len(sqls) != 1 || sqls[0] != "select * from test1_0005" {
+		t.Fatal(plan.RewrittenSqls)
+	}
+	if _, _, err = c.parseAndBuildPlan("update test1 set a = 1 where id = 1"); err != errors.ErrNotPinnedShard {
+		t.Fatal(err)
+	}
+	if _, _, err = c.parseAndBuildPlan("insert into test1 (id) values (5), (1)"); err != errors.ErrNotPinnedShard {
+		t.Fatal(err)
+	}
+	if _, _, err = c.parseAndBuildPlan("insert into test1 (id) values (5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the cached plan is not changed by pin
+	c.shardPinned = false
+	if _, plan, err = c.parseAndBuildPlan("select * from test1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.RewrittenSqls["node1"]) != 4 || len(plan.RewrittenSqls["node2"]) != 4 {
+		t.Fatal(plan.RewrittenSqls)
+	}
+
+	c.pinnedShard = 8
+	c.shardPinned = true
+	if _, _, err = c.parseAndBuildPlan("select * from test1"); err == nil {
+		t.Fatal("shard 8 does not exist")
+	}
+}
+
+func TestNodePin(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+	c.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	node2 := new(backend.Node)
+	c.schema.nodes = map[string]*backend.Node{"node2": node2}
+
+	getExecDB := func(sql string) *ExecuteDB {
+		tokens := strings.FieldsFunc(sql, hack.IsSqlSep)
+		executeDB, err := c.getPinnedExecDB(sql, tokens, len(tokens))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeDB
+	}
+	if getExecDB("select * from test1") != nil {
+		t.Fatal("session is not pinned")
+	}
+
+	c.pinnedNode = node2
+	for _, sql := range []string{"select * from test1", "/*master*/ delete from t", "show tables"} {
+		executeDB := getExecDB(sql)
+		if executeDB == nil || executeDB.ExecNode != node2 || executeDB.IsSlave || executeDB.sql != sql {
+			t.Fatal(sql, executeDB)
+		}
+	}
+	for _, sql := range []string{"set ks_node = ''", "use kingshard", "begin", "commit", "rollback"} {
+		if getExecDB(sql) != nil {
+			t.Fatal(sql)
+		}
+	}
+}
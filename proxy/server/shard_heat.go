@@ -0,0 +1,179 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const (
+	//a sub table is hot if its queries or rows is HotShardRatio times the mean
+	HotShardRatio = 2.0
+)
+
+//the heat of one sub table
+type HeatItem struct {
+	TableIndex int
+	Node       string
+
+	Queries int64
+	Rows    int64
+	QPS     int64
+	OldQPS  int64
+}
+
+//ShardHeat records the query and row distribution of every sharding table,
+//key is db.table, and value is the heat of every sub table.
+type ShardHeat struct {
+	sync.RWMutex
+	tables map[string]map[int]*HeatItem
+}
+
+func NewShardHeat() *ShardHeat {
+	h := new(ShardHeat)
+	h.tables = make(map[string]map[int]*HeatItem)
+	return h
+}
+
+func heatKey(db, table string) string {
+	return fmt.Sprintf("%s.%s", db, table)
+}
+
+func (h *ShardHeat) getItem(rule *router.Rule, tableIndex int) *HeatItem {
+	key := heatKey(rule.DB, rule.Table)
+
+	h.RLock()
+	item, ok := h.tables[key][tableIndex]
+	h.RUnlock()
+	if ok {
+		return item
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	items, ok := h.tables[key]
+	if !ok {
+		items = make(map[int]*HeatItem)
+		h.tables[key] = items
+	}
+	if item, ok = items[tableIndex]; !ok {
+		item = &HeatItem{
+			TableIndex: tableIndex,
+			Node:       rule.Nodes[rule.TableToNode[tableIndex]],
+		}
+		items[tableIndex] = item
+	}
+	return item
+}
+
+//Record the result of a sharding plan, rs must be in the order of
+//sortedNodeNames(plan.RewrittenSqls), see executeInMultiNodes.
+func (h *ShardHeat) Record(plan *router.Plan, rs []*mysql.Result) {
+	if plan == nil || plan.Rule == nil || plan.Rule.Type == router.DefaultRuleType {
+		return
+	}
+
+	offset := 0
+	for _, nodeName := range sortedNodeNames(plan.RewrittenSqls) {
+		sqlCount := len(plan.RewrittenSqls[nodeName])
+		tableIndexs := make([]int, 0, sqlCount)
+		for _, tableIndex := range plan.RouteTableIndexs {
+			if plan.Rule.Nodes[plan.Rule.TableToNode[tableIndex]] == nodeName {
+				tableIndexs = append(tableIndexs, tableIndex)
+			}
+		}
+		//the sqls can not map to the sub tables
+		if len(tableIndexs) != sqlCount {
+			offset += sqlCount
+			continue
+		}
+
+		for i, tableIndex := range tableIndexs {
+			if len(rs) <= offset+i || rs[offset+i] == nil {
+				continue
+			}
+			var rows int64
+			if rs[offset+i].Resultset != nil {
+				rows = int64(len(rs[offset+i].Values))
+			} else {
+				rows = int64(rs[offset+i].AffectedRows)
+			}
+			item := h.getItem(plan.Rule, tableIndex)
+			atomic.AddInt64(&item.Queries, 1)
+			atomic.AddInt64(&item.QPS, 1)
+			atomic.AddInt64(&item.Rows, rows)
+		}
+		offset += sqlCount
+	}
+}
+
+//flush the qps per second
+func (h *ShardHeat) Flush() {
+	h.RLock()
+	defer h.RUnlock()
+	for _, items := range h.tables {
+		for _, item := range items {
+			atomic.StoreInt64(&item.OldQPS, atomic.SwapInt64(&item.QPS, 0))
+		}
+	}
+}
+
+//return the heat of all sub tables in rule, in the order of rule.SubTableIndexs
+func (h *ShardHeat) GetTableHeat(rule *router.Rule) []HeatItem {
+	key := heatKey(rule.DB, rule.Table)
+	heat := make([]HeatItem, 0, len(rule.SubTableIndexs))
+
+	h.RLock()
+	items := h.tables[key]
+	for _, tableIndex := range rule.SubTableIndexs {
+		item := HeatItem{
+			TableIndex: tableIndex,
+			Node:       rule.Nodes[rule.TableToNode[tableIndex]],
+		}
+		if v, ok := items[tableIndex]; ok {
+			item.Queries = atomic.LoadInt64(&v.Queries)
+			item.Rows = atomic.LoadInt64(&v.Rows)
+			item.OldQPS = atomic.LoadInt64(&v.OldQPS)
+		}
+		heat = append(heat, item)
+	}
+	h.RUnlock()
+
+	return heat
+}
+
+//the ratio of v versus the mean, 0 if the mean is 0
+func heatRatio(v int64, total int64, count int) float64 {
+	if total == 0 || count == 0 {
+		return 0
+	}
+	mean := float64(total) / float64(count)
+	return float64(v) / mean
+}
+
+func sortedNodeNames(sqls map[string][]string) []string {
+	names := make([]string, 0, len(sqls))
+	for name := range sqls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
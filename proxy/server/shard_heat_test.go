@@ -0,0 +1,75 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+func TestShardHeatRecord(t *testing.T) {
+	rule := &router.Rule{
+		DB:             "kingshard",
+		Table:          "orders",
+		Type:           router.HashRuleType,
+		Nodes:          []string{"node1", "node2"},
+		SubTableIndexs: []int{0, 1, 2, 3},
+		TableToNode:    map[int]int{0: 0, 1: 0, 2: 1, 3: 1},
+	}
+	plan := &router.Plan{
+		Rule:             rule,
+		RouteTableIndexs: []int{1, 2, 3},
+		RewrittenSqls: map[string][]string{
+			"node2": {"select * from orders_0002", "select * from orders_0003"},
+			"node1": {"select * from orders_0001"},
+		},
+	}
+	rs := []*mysql.Result{
+		{Resultset: &mysql.Resultset{Values: make([][]interface{}, 5)}},
+		{AffectedRows: 2},
+		{AffectedRows: 3},
+	}
+
+	h := NewShardHeat()
+	h.Record(plan, rs)
+	h.Record(plan, rs)
+
+	heat := h.GetTableHeat(rule)
+	if len(heat) != 4 {
+		t.Fatal(len(heat))
+	}
+	expectRows := []int64{0, 10, 4, 6}
+	expectQueries := []int64{0, 2, 2, 2}
+	for i, item := range heat {
+		if item.TableIndex != i {
+			t.Fatal(item.TableIndex)
+		}
+		if item.Rows != expectRows[i] || item.Queries != expectQueries[i] {
+			t.Fatalf("table %d rows %d queries %d", i, item.Rows, item.Queries)
+		}
+	}
+	if heat[1].Node != "node1" || heat[3].Node != "node2" {
+		t.Fatal(heat[1].Node, heat[3].Node)
+	}
+
+	if r := heatRatio(10, 20, 4); r != 2 {
+		t.Fatal(r)
+	}
+	if r := heatRatio(10, 0, 4); r != 0 {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the actions of a rebalance step
+const (
+	RebalanceCreate = "create"
+	RebalanceCopy   = "copy"
+	RebalanceSwitch = "switch"
+	RebalanceDrop   = "drop"
+)
+
+//the load of a sub table, Weight is the mean of its share of the size
+//and its share of the queries in the table
+type SubTableLoad struct {
+	TableIndex int     `json:"table_index"`
+	Node       string  `json:"node"`
+	Size       int64   `json:"size"`
+	Queries    int64   `json:"queries"`
+	Weight     float64 `json:"weight"`
+}
+
+//RebalanceStep is a step of the plan, the steps are executed in order
+type RebalanceStep struct {
+	Step     int    `json:"step"`
+	Action   string `json:"action"`
+	SubTable string `json:"sub_table"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Detail   string `json:"detail"`
+}
+
+//RebalancePlan moves the sub tables of a hash or range rule between its
+//nodes, so the weight of every node is near the mean. The sub tables of
+//a node are continuous in the rule, so the plan changes the locations of
+//the nodes only. The plan is not executed by kingshard, the rows are
+//copied by the migration tool before the config is switched.
+type RebalancePlan struct {
+	DB           string          `json:"db"`
+	Table        string          `json:"table"`
+	Nodes        []string        `json:"nodes"`
+	Locations    []int           `json:"locations"`
+	NewLocations []int           `json:"new_locations"`
+	MaxWeight    float64         `json:"max_weight"` //the max weight of a node
+	NewMaxWeight float64         `json:"new_max_weight"`
+	Loads        []SubTableLoad  `json:"loads"`
+	Steps        []RebalanceStep `json:"steps"`
+}
+
+//the data and index size of a sub table in the master of node
+func (s *Server) subTableSize(schema *Schema, nodeName string, db string, table string) (int64, error) {
+	n := schema.nodes[nodeName]
+	if n == nil {
+		return 0, fmt.Errorf("invalid node %s", nodeName)
+	}
+	conn, err := n.GetMasterConn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	r, err := conn.Execute(fmt.Sprintf("select data_length + index_length from information_schema.tables"+
+		" where table_schema = '%s' and table_name = '%s'", mysql.Escape(db), mysql.Escape(table)))
+	if err != nil {
+		return 0, err
+	}
+	if r.RowNumber() == 0 {
+		return 0, fmt.Errorf("missing table")
+	}
+	return r.GetInt(0, 0)
+}
+
+//RebalancePlan gets the size of the sub tables of db.table from their
+//nodes and the queries from the shard heat, and plans the moves of sub
+//tables. The plan has no steps if the table is balanced.
+func (s *Server) RebalancePlan(db string, table string) (*RebalancePlan, error) {
+	schema := s.GetSchema()
+	rule := schema.rule.GetRule(db, table)
+	if rule.Type != router.HashRuleType && rule.Type != router.RangeRuleType {
+		return nil, fmt.Errorf("table %s is not a hash or range sharding table", table)
+	}
+
+	heat := s.shardHeat.GetTableHeat(rule)
+	loads := make([]SubTableLoad, 0, len(heat))
+	for _, item := range heat {
+		size, err := s.subTableSize(schema, item.Node, rule.DB,
+			fmt.Sprintf("%s_%04d", rule.Table, item.TableIndex))
+		if err != nil {
+			return nil, fmt.Errorf("%s_%04d in %s: %s", rule.Table, item.TableIndex, item.Node, err.Error())
+		}
+		loads = append(loads, SubTableLoad{
+			TableIndex: item.TableIndex,
+			Node:       item.Node,
+			Size:       size,
+			Queries:    item.Queries,
+		})
+	}
+	return newRebalancePlan(rule, loads), nil
+}
+
+func newRebalancePlan(rule *router.Rule, loads []SubTableLoad) *RebalancePlan {
+	setLoadWeights(loads)
+	p := &RebalancePlan{
+		DB:    rule.DB,
+		Table: rule.Table,
+		Nodes: rule.Nodes,
+		Loads: loads,
+	}
+
+	p.Locations = make([]int, len(rule.Nodes))
+	current := make([]int, len(loads))
+	for i, load := range loads {
+		current[i] = rule.TableToNode[load.TableIndex]
+		p.Locations[current[i]]++
+	}
+	p.NewLocations = partitionLoads(loads, current, len(rule.Nodes))
+	p.MaxWeight = maxNodeWeight(loads, p.Locations)
+	p.NewMaxWeight = maxNodeWeight(loads, p.NewLocations)
+
+	var moves []SubTableLoad
+	var to []string
+	i := 0
+	for node, count := range p.NewLocations {
+		for j := 0; j < count; j++ {
+			if current[i] != node {
+				moves = append(moves, loads[i])
+				to = append(to, rule.Nodes[node])
+			}
+			i++
+		}
+	}
+	if len(moves) == 0 {
+		return p
+	}
+
+	//the sub tables are created and copied first, then the config is
+	//switched, and the old sub tables are dropped at last
+	addStep := func(action string, load SubTableLoad, to string, detail string) {
+		p.Steps = append(p.Steps, RebalanceStep{
+			Step:     len(p.Steps) + 1,
+			Action:   action,
+			SubTable: fmt.Sprintf("%s.%s_%04d", rule.DB, rule.Table, load.TableIndex),
+			From:     load.Node,
+			To:       to,
+			Detail:   detail,
+		})
+	}
+	for i, load := range moves {
+		addStep(RebalanceCreate, load, to[i], "show create table in from, and create it in to")
+	}
+	for i, load := range moves {
+		addStep(RebalanceCopy, load, to[i], fmt.Sprintf("copy %d bytes", load.Size))
+	}
+	p.Steps = append(p.Steps, RebalanceStep{
+		Step:   len(p.Steps) + 1,
+		Action: RebalanceSwitch,
+		Detail: fmt.Sprintf("locations %v -> %v, max node weight %.2f -> %.2f",
+			p.Locations, p.NewLocations, p.MaxWeight, p.NewMaxWeight),
+	})
+	for i, load := range moves {
+		addStep(RebalanceDrop, load, to[i], "drop the sub table in from")
+	}
+	return p
+}
+
+//the weight of a sub table is the mean of its shares of size and queries,
+//the share whose total is 0 is ignored, and all sub tables have the same
+//weight if both totals are 0
+func setLoadWeights(loads []SubTableLoad) {
+	var totalSize, totalQueries int64
+	for _, load := range loads {
+		totalSize += load.Size
+		totalQueries += load.Queries
+	}
+	for i := range loads {
+		var weight float64
+		var count int
+		if 0 < totalSize {
+			weight += float64(loads[i].Size) / float64(totalSize)
+			count++
+		}
+		if 0 < totalQueries {
+			weight += float64(loads[i].Queries) / float64(totalQueries)
+			count++
+		}
+		if count == 0 {
+			loads[i].Weight = 1 / float64(len(loads))
+		} else {
+			loads[i].Weight = weight / float64(count)
+		}
+	}
+}
+
+func maxNodeWeight(loads []SubTableLoad, locations []int) float64 {
+	var max float64
+	i := 0
+	for _, count := range locations {
+		var weight float64
+		for j := 0; j < count; j++ {
+			weight += loads[i].Weight
+			i++
+		}
+		if max < weight {
+			max = weight
+		}
+	}
+	return max
+}
+
+//the weights within rebalanceEpsilon are equal, the one with fewer moves
+//is preferred
+const rebalanceEpsilon = 1e-9
+
+type partition struct {
+	max   float64
+	moves int
+	prev  int //the end of the previous node
+}
+
+func (p *partition) less(o *partition) bool {
+	if p.max < o.max-rebalanceEpsilon {
+		return true
+	}
+	return p.max <= o.max+rebalanceEpsilon && p.moves < o.moves
+}
+
+//partitionLoads splits loads into nodeCount continuous parts in order, and
+//minimizes the max weight of the parts and then the sub tables moved from
+//their current node. Every node has a sub table at least if possible.
+func partitionLoads(loads []SubTableLoad, current []int, nodeCount int) []int {
+	n := len(loads)
+	minCount := 0
+	if nodeCount <= n {
+		minCount = 1
+	}
+
+	prefix := make([]float64, n+1)
+	for i, load := range loads {
+		prefix[i+1] = prefix[i] + load.Weight
+	}
+	//stay[node][i] is the count of the first i sub tables in node
+	stay := make([][]int, nodeCount)
+	for node := range stay {
+		stay[node] = make([]int, n+1)
+		for i := 0; i < n; i++ {
+			stay[node][i+1] = stay[node][i]
+			if current[i] == node {
+				stay[node][i+1]++
+			}
+		}
+	}
+
+	//best[node][i] is the partition of the first i sub tables in the
+	//nodes before and including node
+	best := make([][]*partition, nodeCount)
+	for node := range best {
+		best[node] = make([]*partition, n+1)
+		for i := minCount * (node + 1); i <= n-minCount*(nodeCount-node-1); i++ {
+			for prev := minCount * node; prev <= i-minCount; prev++ {
+				var last *partition
+				if node == 0 {
+					if prev != 0 {
+						continue
+					}
+					last = &partition{}
+				} else if last = best[node-1][prev]; last == nil {
+					continue
+				}
+				p := &partition{
+					max:   last.max,
+					moves: last.moves + (i - prev) - (stay[node][i] - stay[node][prev]),
+					prev:  prev,
+				}
+				if w := prefix[i] - prefix[prev]; p.max < w {
+					p.max = w
+				}
+				if best[node][i] == nil || p.less(best[node][i]) {
+					best[node][i] = p
+				}
+			}
+		}
+	}
+
+	locations := make([]int, nodeCount)
+	end := n
+	for node := nodeCount - 1; 0 <= node; node-- {
+		prev := best[node][end].prev
+		locations[node] = end - prev
+		end = prev
+	}
+	return locations
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flike/kingshard/mysql/mysqltest"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+func TestPartitionLoads(t *testing.T) {
+	loads := func(weights ...float64) []SubTableLoad {
+		var l []SubTableLoad
+		for _, w := range weights {
+			l = append(l, SubTableLoad{Weight: w})
+		}
+		return l
+	}
+
+	tests := []struct {
+		weights   []float64
+		current   []int
+		nodeCount int
+		expect    []int
+	}{
+		{[]float64{1, 1, 1, 1}, []int{0, 0, 1, 1}, 2, []int{2, 2}},
+		{[]float64{4, 1, 1, 1, 1}, []int{0, 0, 0, 1, 1}, 2, []int{1, 4}},
+		//the same max weight with fewer moves
+		{[]float64{2, 1, 2}, []int{0, 0, 1}, 2, []int{2, 1}},
+		{[]float64{2, 1, 2}, []int{0, 1, 1}, 2, []int{1, 2}},
+		{[]float64{1, 1, 1, 1, 1, 1}, []int{0, 0, 0, 0, 0, 0}, 3, []int{2, 2, 2}},
+		//a new node without sub tables
+		{[]float64{1, 1, 1, 1}, []int{0, 0, 1, 1}, 3, []int{2, 1, 1}},
+		{[]float64{1}, []int{0}, 2, []int{1, 0}},
+	}
+	for _, test := range tests {
+		locations := partitionLoads(loads(test.weights...), test.current, test.nodeCount)
+		if !reflect.DeepEqual(locations, test.expect) {
+			t.Fatal(test.weights, test.current, locations)
+		}
+	}
+}
+
+func TestNewRebalancePlan(t *testing.T) {
+	rule := &router.Rule{
+		DB:             "kingshard",
+		Table:          "t",
+		Nodes:          []string{"node1", "node2"},
+		SubTableIndexs: []int{0, 1, 2, 3},
+		TableToNode:    map[int]int{0: 0, 1: 0, 2: 0, 3: 1},
+	}
+	loads := []SubTableLoad{
+		{TableIndex: 0, Node: "node1", Size: 100, Queries: 10},
+		{TableIndex: 1, Node: "node1", Size: 100, Queries: 10},
+		{TableIndex: 2, Node: "node1", Size: 100, Queries: 10},
+		{TableIndex: 3, Node: "node2", Size: 100, Queries: 10},
+	}
+	p := newRebalancePlan(rule, loads)
+	if !reflect.DeepEqual(p.Locations, []int{3, 1}) || !reflect.DeepEqual(p.NewLocations, []int{2, 2}) {
+		t.Fatal(p.Locations, p.NewLocations)
+	}
+	if p.MaxWeight != 0.75 || p.NewMaxWeight != 0.5 {
+		t.Fatal(p.MaxWeight, p.NewMaxWeight)
+	}
+	var actions []string
+	for i, step := range p.Steps {
+		if step.Step != i+1 {
+			t.Fatal(step)
+		}
+		actions = append(actions, step.Action)
+	}
+	if !reflect.DeepEqual(actions, []string{RebalanceCreate, RebalanceCopy, RebalanceSwitch, RebalanceDrop}) {
+		t.Fatal(p.Steps)
+	}
+	expect := RebalanceStep{Step: 1, Action: RebalanceCreate, SubTable: "kingshard.t_0002",
+		From: "node1", To: "node2", Detail: "show create table in from, and create it in to"}
+	if p.Steps[0] != expect {
+		t.Fatal(p.Steps[0])
+	}
+
+	//balanced
+	rule.TableToNode[2] = 1
+	loads[2].Node = "node2"
+	if p = newRebalancePlan(rule, loads); len(p.Steps) != 0 {
+		t.Fatal(p.Steps)
+	}
+}
+
+var rebalanceConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [2,2]
+`
+
+func TestRebalancePlan(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, rebalanceConfig)
+	defer close()
+	tableSize := func(size int) *mysqltest.Response {
+		return &mysqltest.Response{
+			Names: []string{"data_length + index_length"},
+			Rows:  [][]interface{}{{size}},
+		}
+	}
+	backends[0].Handle(`table_name = 't_0000'`, tableSize(300))
+	backends[0].Handle(`table_name = 't_0001'`, tableSize(300))
+	backends[1].Handle(`table_name = 't_0002'`, tableSize(100))
+	backends[1].Handle(`table_name = 't_0003'`, tableSize(100))
+
+	p, err := s.RebalancePlan("kingshard", "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.NewLocations, []int{1, 3}) || len(p.Steps) != 4 {
+		t.Fatal(p.NewLocations, p.Steps)
+	}
+	if p.Steps[1].SubTable != "kingshard.t_0001" || p.Steps[1].Detail != "copy 300 bytes" {
+		t.Fatal(p.Steps[1])
+	}
+
+	if _, err = s.RebalancePlan("kingshard", "t1"); err == nil {
+		t.Fatal("t1 is not a sharding table")
+	}
+	backends[1].SetDown(true)
+	if _, err = s.RebalancePlan("kingshard", "t"); err == nil {
+		t.Fatal("node2 is down")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const DefaultShardSplitThreshold = 0.8
+
+//SubTableRows is the rows of a sub table of range rule, estimated by
+//information_schema.tables
+type SubTableRows struct {
+	Table    string //db.table
+	SubTable string
+	Node     string
+	Start    int64
+	End      int64
+	Rows     int64 //-1 if the check failed
+	Err      string
+}
+
+//ShardSplit is a new sub table of a range rule, for the keys after the
+//last sub table. The rows of the existing sub tables are not moved.
+type ShardSplit struct {
+	DB     string
+	Table  string
+	Index  int
+	Node   string
+	Start  int64
+	End    int64
+	Reason string
+}
+
+func (p *ShardSplit) SubTable() string {
+	return fmt.Sprintf("%s_%04d", p.Table, p.Index)
+}
+
+func (p *ShardSplit) String() string {
+	return fmt.Sprintf("%s.%s [%d,%d) on %s", p.DB, p.SubTable(), p.Start, p.End, p.Node)
+}
+
+func (s *Server) shardSplitThreshold() float64 {
+	if t := s.getState().cfg.ShardSplit.Threshold; 0 < t {
+		return t
+	}
+	return DefaultShardSplitThreshold
+}
+
+//the estimated rows of a sub table in the master of node
+func (s *Server) subTableRows(schema *Schema, nodeName string, db string, table string) (int64, error) {
+	n := schema.nodes[nodeName]
+	if n == nil {
+		return 0, fmt.Errorf("invalid node %s", nodeName)
+	}
+	conn, err := n.GetMasterConn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	r, err := conn.Execute(fmt.Sprintf("select table_rows from information_schema.tables"+
+		" where table_schema = '%s' and table_name = '%s'", mysql.Escape(conn.RewriteDB(db)), mysql.Escape(table)))
+	if err != nil {
+		return 0, err
+	}
+	if r.RowNumber() == 0 {
+		return 0, fmt.Errorf("missing table")
+	}
+	rows, err := r.GetInt(0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+//CheckShardSplit gets the rows of the sub tables of every range rule, and
+//proposes a new sub table for the rule whose last sub table is nearly
+//full, new keys beyond it are out of range otherwise.
+func (s *Server) CheckShardSplit() ([]SubTableRows, []ShardSplit) {
+	schema := s.GetSchema()
+	if schema == nil {
+		return nil, nil
+	}
+
+	var dbs []string
+	for db := range schema.rule.Rules {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	var rows []SubTableRows
+	var splits []ShardSplit
+	for _, db := range dbs {
+		var tables []string
+		for table := range schema.rule.Rules[db] {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			rule := schema.rule.Rules[db][table]
+			if rule.Type != router.RangeRuleType {
+				continue
+			}
+			ruleRows, split := s.checkRuleSplit(schema, rule)
+			rows = append(rows, ruleRows...)
+			if split != nil {
+				splits = append(splits, *split)
+			}
+		}
+	}
+	return rows, splits
+}
+
+func (s *Server) checkRuleSplit(schema *Schema, rule *router.Rule) ([]SubTableRows, *ShardSplit) {
+	shard, ok := rule.Shard.(*router.NumRangeShard)
+	if !ok || len(rule.SubTableIndexs) == 0 {
+		return nil, nil
+	}
+
+	var rows []SubTableRows
+	nodeRows := make(map[string]int64)
+	for _, tableIndex := range rule.SubTableIndexs {
+		if rule.IsBlackhole(tableIndex) {
+			continue
+		}
+		r := SubTableRows{
+			Table:    rule.DB + "." + rule.Table,
+			SubTable: fmt.Sprintf("%s_%04d", rule.Table, tableIndex),
+			Node:     rule.Nodes[rule.TableToNode[tableIndex]],
+			Start:    shard.Shards[tableIndex].Start,
+			End:      shard.Shards[tableIndex].End,
+		}
+		n, err := s.subTableRows(schema, r.Node, rule.DB, r.SubTable)
+		if err != nil {
+			r.Rows = -1
+			r.Err = err.Error()
+		} else {
+			r.Rows = n
+			nodeRows[r.Node] += n
+		}
+		rows = append(rows, r)
+	}
+
+	//no split after a decommissioned sub table
+	if rule.IsBlackhole(rule.SubTableIndexs[len(rule.SubTableIndexs)-1]) {
+		return rows, nil
+	}
+	last := rows[len(rows)-1]
+	limit := last.End - last.Start
+	if last.Rows < 0 || float64(last.Rows) < s.shardSplitThreshold()*float64(limit) {
+		return rows, nil
+	}
+	split := &ShardSplit{
+		DB:     rule.DB,
+		Table:  rule.Table,
+		Index:  rule.SubTableIndexs[len(rule.SubTableIndexs)-1] + 1,
+		Node:   splitNode(rule, schema.rule.Nodes, nodeRows),
+		Start:  last.End,
+		End:    last.End + limit,
+		Reason: fmt.Sprintf("%s has %d rows of %d", last.SubTable, last.Rows, limit),
+	}
+	return rows, split
+}
+
+//the node of the new sub table, which has the fewest rows of the rule
+//among the last node of the rule and the nodes not in the rule. The sub
+//tables of a node are continuous, so the other nodes of the rule can
+//not be used without moving the sub tables after them.
+func splitNode(rule *router.Rule, schemaNodes []string, nodeRows map[string]int64) string {
+	node := rule.Nodes[len(rule.Nodes)-1]
+	for _, n := range schemaNodes {
+		if includeString(rule.Nodes, n) {
+			continue
+		}
+		if nodeRows[n] < nodeRows[node] {
+			node = n
+		}
+	}
+	return node
+}
+
+func includeString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//the create table of the new sub table, from the show create table of
+//the last sub table
+func newSubTableSql(createSql string, lastTable string, db string, table string) string {
+	createSql = autoIncrementRegexp.ReplaceAllString(createSql, "")
+	return strings.Replace(createSql,
+		fmt.Sprintf("CREATE TABLE `%s`", lastTable),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`.`%s`", db, table), 1)
+}
+
+//the config with the new sub table in the rule of split
+func splitShardConfig(cfg *config.Config, split *ShardSplit) (*config.Config, error) {
+	next := *cfg
+	next.Schema.ShardRule = append([]config.ShardConfig(nil), cfg.Schema.ShardRule...)
+	for i := range next.Schema.ShardRule {
+		r := &next.Schema.ShardRule[i]
+		if r.DB != split.DB || r.Table != split.Table {
+			continue
+		}
+		r.Nodes = append([]string(nil), r.Nodes...)
+		r.Locations = append([]int(nil), r.Locations...)
+		if r.Nodes[len(r.Nodes)-1] == split.Node {
+			r.Locations[len(r.Locations)-1]++
+		} else {
+			r.Nodes = append(r.Nodes, split.Node)
+			r.Locations = append(r.Locations, 1)
+		}
+		return &next, nil
+	}
+	return nil, fmt.Errorf("no range rule of %s.%s", split.DB, split.Table)
+}
+
+//SplitShard creates the new sub table in its node, and reloads the
+//config with the sub table added to the rule
+func (s *Server) SplitShard(split *ShardSplit) error {
+	schema := s.GetSchema()
+	rule := schema.rule.GetRule(split.DB, split.Table)
+	if rule.Type != router.RangeRuleType {
+		return fmt.Errorf("no range rule of %s.%s", split.DB, split.Table)
+	}
+	lastIndex := rule.SubTableIndexs[len(rule.SubTableIndexs)-1]
+	if split.Index != lastIndex+1 {
+		return fmt.Errorf("%s is not after the last sub table", split.SubTable())
+	}
+	lastTable := fmt.Sprintf("%s_%04d", rule.Table, lastIndex)
+	createSql, err := s.showCreateTable(schema, rule.Nodes[rule.TableToNode[lastIndex]], split.DB, lastTable)
+	if err != nil {
+		return err
+	}
+
+	n := schema.nodes[split.Node]
+	if n == nil {
+		return fmt.Errorf("invalid node %s", split.Node)
+	}
+	conn, err := n.GetMasterConn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Execute(newSubTableSql(createSql, lastTable, conn.RewriteDB(split.DB), split.SubTable()))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := splitShardConfig(s.getState().cfg, split)
+	if err != nil {
+		return err
+	}
+	return s.Reload(cfg)
+}
+
+func (s *Server) checkShardSplitLoop(interval time.Duration) {
+	for s.isRunning() {
+		time.Sleep(interval)
+		_, splits := s.CheckShardSplit()
+		for i := range splits {
+			split := &splits[i]
+			if !s.getState().cfg.ShardSplit.Auto {
+				golog.Warn("Server", "checkShardSplit", "shard split proposed", 0,
+					"split", split.String(), "reason", split.Reason)
+				continue
+			}
+			if err := s.SplitShard(split); err != nil {
+				golog.Error("Server", "checkShardSplit", err.Error(), 0,
+					"split", split.String(), "reason", split.Reason)
+				continue
+			}
+			golog.Warn("Server", "checkShardSplit", "shard split", 0,
+				"split", split.String(), "reason", split.Reason)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+var shardSplitConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1]
+        type : range
+        locations : [2]
+        table_row_limit : 100
+`
+
+func TestShardSplit(t *testing.T) {
+	s, backends, _, close := newFakeProxy(t, shardSplitConfig)
+	defer close()
+	tableRows := func(rows int) *mysqltest.Response {
+		return &mysqltest.Response{
+			Names: []string{"table_rows"},
+			Rows:  [][]interface{}{{rows}},
+		}
+	}
+	backends[0].Handle(`table_name = 't_0000'`, tableRows(100))
+	backends[0].Handle(`table_name = 't_0001'`, tableRows(50))
+	backends[0].Handle(`^show create table`, &mysqltest.Response{
+		Names: []string{"Table", "Create Table"},
+		Rows: [][]interface{}{{"t_0001", "CREATE TABLE `t_0001` (\n" +
+			"  `id` int(11) NOT NULL,\n  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=151 DEFAULT CHARSET=utf8"}},
+	})
+
+	rows, splits := s.CheckShardSplit()
+	if len(rows) != 2 || rows[1].Rows != 50 || rows[1].Start != 100 || rows[1].End != 200 {
+		t.Fatal(rows)
+	}
+	if len(splits) != 0 {
+		t.Fatal(splits)
+	}
+
+	//the new sub table is in the node without the rows of table
+	backends[0].Handle(`table_name = 't_0001'`, tableRows(85))
+	_, splits = s.CheckShardSplit()
+	expect := ShardSplit{DB: "kingshard", Table: "t", Index: 2, Node: "node2",
+		Start: 200, End: 300, Reason: "t_0001 has 85 rows of 100"}
+	if len(splits) != 1 || splits[0] != expect {
+		t.Fatal(splits)
+	}
+
+	if err := s.SplitShard(&splits[0]); err != nil {
+		t.Fatal(err)
+	}
+	created := false
+	for _, q := range backends[1].Queries() {
+		if strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS `kingshard`.`t_0002` (") &&
+			!strings.Contains(q, "AUTO_INCREMENT=") {
+			created = true
+		}
+	}
+	if !created {
+		t.Fatal(backends[1].Queries())
+	}
+	rule := s.GetSchema().rule.GetRule("kingshard", "t")
+	if !reflect.DeepEqual(rule.Nodes, []string{"node1", "node2"}) || rule.TableToNode[2] != 1 {
+		t.Fatal(rule.Nodes, rule.TableToNode)
+	}
+	if node, err := rule.FindNode(250); err != nil || node != "node2" {
+		t.Fatal(node, err)
+	}
+	//the split is done
+	if err := s.SplitShard(&splits[0]); err == nil {
+		t.Fatal("t_0002 is not after the last sub table")
+	}
+}
+
+func TestSplitShardConfig(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Schema.ShardRule = []config.ShardConfig{
+		{DB: "kingshard", Table: "t", Nodes: []string{"node1", "node2"}, Locations: []int{2, 2}},
+	}
+	next, err := splitShardConfig(cfg, &ShardSplit{DB: "kingshard", Table: "t", Node: "node2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(next.Schema.ShardRule[0].Locations, []int{2, 3}) {
+		t.Fatal(next.Schema.ShardRule[0])
+	}
+	//the config is not changed
+	if !reflect.DeepEqual(cfg.Schema.ShardRule[0].Locations, []int{2, 2}) {
+		t.Fatal(cfg.Schema.ShardRule[0])
+	}
+	next, _ = splitShardConfig(cfg, &ShardSplit{DB: "kingshard", Table: "t", Node: "node3"})
+	if r := next.Schema.ShardRule[0]; !reflect.DeepEqual(r.Nodes, []string{"node1", "node2", "node3"}) ||
+		!reflect.DeepEqual(r.Locations, []int{2, 2, 1}) {
+		t.Fatal(r)
+	}
+	if _, err = splitShardConfig(cfg, &ShardSplit{DB: "kingshard", Table: "t1"}); err == nil {
+		t.Fatal("no rule of t1")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//get the warnings of the last sql executed in co, nil if it fails
+func (c *ClientConn) getBackendWarnings(co *backend.BackendConn) []*mysql.SqlError {
+	r, err := co.ExecuteContext(c.ctx, "show warnings")
+	if err != nil {
+		golog.Warn("ClientConn", "getBackendWarnings", err.Error(), c.connectionId,
+			"addr", co.GetAddr())
+		return nil
+	}
+	warnings := make([]*mysql.SqlError, 0, r.RowNumber())
+	for i := 0; i < r.RowNumber(); i++ {
+		code, _ := r.GetUint(i, 1)
+		message, _ := r.GetString(i, 2)
+		warnings = append(warnings, mysql.NewError(uint16(code), message))
+	}
+	return warnings
+}
+
+//add the warnings of a sql sent to a sub table, they are told by the node
+//and sub table if the statement is sent to more than one sql. The
+//warnings which are not got are counted only.
+func (c *ClientConn) addShardWarnings(count uint16, warnings []*mysql.SqlError, se shardError, total int) {
+	for _, w := range warnings {
+		if total == 1 && len(se.table) == 0 {
+			c.addWarning(w.Code, w.Message)
+		} else {
+			c.addWarning(w.Code, fmt.Sprintf("[%s] %s", se.where(), w.Message))
+		}
+	}
+	if len(warnings) < int(count) {
+		c.droppedWarnings += int(count) - len(warnings)
+	}
+}
+
+//the numbers in the info of ok packet, such as Rows matched: 1  Changed: 1
+var resultInfoRegexp = regexp.MustCompile(`([A-Za-z][A-Za-z ]*): *(\d+)`)
+
+//merge the infos of the results by summing the numbers of the same names,
+//such as Records: 3  Duplicates: 1  Warnings: 0 of a multi rows insert.
+//It is empty if the infos are not in the same format.
+func mergeResultInfo(rs []*mysql.Result) string {
+	var names []string
+	var sums []uint64
+	for i, r := range rs {
+		matches := resultInfoRegexp.FindAllStringSubmatch(r.Info, -1)
+		if len(matches) == 0 || (i != 0 && len(matches) != len(names)) {
+			return ""
+		}
+		for j, m := range matches {
+			n, err := strconv.ParseUint(m[2], 10, 64)
+			if err != nil {
+				return ""
+			}
+			if i == 0 {
+				names = append(names, m[1])
+				sums = append(sums, n)
+				continue
+			}
+			if names[j] != m[1] {
+				return ""
+			}
+			sums[j] += n
+		}
+	}
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", name, sums[i])
+	}
+	return strings.Join(parts, "  ")
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestMergeResultInfo(t *testing.T) {
+	tests := []struct {
+		infos  []string
+		expect string
+	}{
+		{[]string{"Records: 1  Duplicates: 0  Warnings: 0", "Records: 3  Duplicates: 1  Warnings: 1"},
+			"Records: 4  Duplicates: 1  Warnings: 1"},
+		{[]string{"Rows matched: 1  Changed: 1  Warnings: 0", "Rows matched: 2  Changed: 1  Warnings: 0"},
+			"Rows matched: 3  Changed: 2  Warnings: 0"},
+		{[]string{"Records: 1  Duplicates: 0  Warnings: 0", ""}, ""},
+		{[]string{"Records: 1  Duplicates: 0  Warnings: 0", "Rows matched: 1  Changed: 1  Warnings: 0"}, ""},
+		{[]string{""}, ""},
+	}
+	for _, test := range tests {
+		var rs []*mysql.Result
+		for _, info := range test.infos {
+			rs = append(rs, &mysql.Result{Info: info})
+		}
+		if info := mergeResultInfo(rs); info != test.expect {
+			t.Fatal(test.infos, info)
+		}
+	}
+}
+
+func TestInsertIgnoreWarnings(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+	backends[0].Handle(`insert ignore into t_0000`, &mysqltest.Response{
+		AffectedRows: 1,
+		Info:         "Records: 1  Duplicates: 0  Warnings: 0",
+	})
+	backends[1].Handle(`insert ignore into t_0001`, &mysqltest.Response{
+		AffectedRows: 2,
+		Warnings:     1,
+		Info:         "Records: 3  Duplicates: 1  Warnings: 1",
+	})
+	backends[1].Handle(`show warnings`, &mysqltest.Response{
+		Names: []string{"Level", "Code", "Message"},
+		Rows:  [][]interface{}{{"Warning", 1062, "Duplicate entry '3' for key 'PRIMARY'"}},
+	})
+
+	r, err := c.Execute("insert ignore into t(id) values (1),(2),(3),(5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 3 || r.Warnings != 1 || r.Info != "Records: 4  Duplicates: 1  Warnings: 1" {
+		t.Fatal(r.AffectedRows, r.Warnings, r.Info)
+	}
+
+	r, err = c.Execute("show warnings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := r.GetUint(0, 1)
+	message, _ := r.GetString(0, 2)
+	if r.RowNumber() != 1 || code != 1062 || !strings.HasPrefix(message, "[node2.t_0001] Duplicate entry '3'") {
+		t.Fatal(r.RowNumber(), code, message)
+	}
+}
+
+var sameNodeConfig = `
+addr : 127.0.0.1:0
+user : root
+password :
+
+nodes :
+-
+    name : node1
+    user : root
+    master : %s
+-
+    name : node2
+    user : root
+    master : %s
+
+schema :
+    default : node1
+    nodes : [node1,node2]
+    shard :
+    -
+        db : kingshard
+        table : t
+        key : id
+        nodes : [node1,node2]
+        type : hash
+        locations : [2,1]
+`
+
+//the rows of a replace are split into the sub tables of the same node
+func TestMultiRowReplace(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, sameNodeConfig)
+	defer close()
+	backends[0].Handle(`replace into t_0000`, &mysqltest.Response{
+		AffectedRows: 3,
+		Info:         "Records: 2  Duplicates: 1  Warnings: 0",
+	})
+	backends[0].Handle(`replace into t_0001`, &mysqltest.Response{
+		AffectedRows: 1,
+		Info:         "Records: 1  Duplicates: 0  Warnings: 0",
+	})
+
+	r, err := c.Execute("replace into t(id, name) values (0, 'a'), (1, 'b'), (3, 'c')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 4 || r.Warnings != 0 || r.Info != "Records: 3  Duplicates: 1  Warnings: 0" {
+		t.Fatal(r.AffectedRows, r.Warnings, r.Info)
+	}
+	var sqls []string
+	for _, sql := range backends[0].Queries() {
+		if strings.HasPrefix(sql, "replace") {
+			sqls = append(sqls, sql)
+		}
+	}
+	//the sub tables are written concurrently
+	sort.Strings(sqls)
+	if len(sqls) != 2 || sqls[0] != "replace into t_0000(id, name) values (0, 'a'), (3, 'c')" ||
+		sqls[1] != "replace into t_0001(id, name) values (1, 'b')" {
+		t.Fatal(sqls)
+	}
+}
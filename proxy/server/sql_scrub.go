@@ -0,0 +1,151 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+)
+
+//the hex digits of the hash of a string value in the sql scrubbed
+const scrubHashSize = 12
+
+//scrubSql replaces the literals of sql for the logs, the numbers are
+//replaced by ? and the strings by the prefix of their sha1, such as
+//'#3c9a2e0bd2c1', so the same values can still be matched in the logs.
+//The identifiers, keywords and comments are kept.
+func scrubSql(sql string) string {
+	buf := make([]byte, 0, len(sql))
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			j := closingQuote(sql, i)
+			sum := sha1.Sum([]byte(sql[i+1 : j]))
+			buf = append(buf, '\'', '#')
+			buf = append(buf, hex.EncodeToString(sum[:])[:scrubHashSize]...)
+			buf = append(buf, '\'')
+			i = j + 1
+		case ch == '`':
+			j := closingQuote(sql, i) + 1
+			if len(sql) < j {
+				j = len(sql)
+			}
+			buf = append(buf, sql[i:j]...)
+			i = j
+		case ch == '/' && strings.HasPrefix(sql[i:], "/*"):
+			j := strings.Index(sql[i+2:], "*/")
+			if j == -1 {
+				j = len(sql)
+			} else {
+				j += i + 4
+			}
+			buf = append(buf, sql[i:j]...)
+			i = j
+		case isDigit(ch) && (i == 0 || !isIdentChar(sql[i-1])):
+			//a number such as 12, 1.5e3 or 0x1f, and the sign is kept
+			j := i + 1
+			for j < len(sql) && (isIdentChar(sql[j]) || sql[j] == '.' ||
+				((sql[j] == '+' || sql[j] == '-') && (sql[j-1] == 'e' || sql[j-1] == 'E'))) {
+				j++
+			}
+			buf = append(buf, '?')
+			i = j
+		case isIdentChar(ch):
+			j := i + 1
+			for j < len(sql) && isIdentChar(sql[j]) {
+				j++
+			}
+			buf = append(buf, sql[i:j]...)
+			i = j
+		default:
+			buf = append(buf, ch)
+			i++
+		}
+	}
+	return string(buf)
+}
+
+//the index of the quote closing the string or identifier at sql[start],
+//len(sql) if it is not closed. The quote is escaped by a backslash or
+//doubled.
+func closingQuote(sql string, start int) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\\' && quote != '`':
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(sql)
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || isDigit(ch) ||
+		ch == '_' || ch == '$' || 0x80 <= ch
+}
+
+//the sql written into the logs, which is scrubbed if log_sql_scrub is on
+func (s *Server) logSqlText(sql string) string {
+	if atomic.LoadInt32(&s.logSqlScrub) == 0 {
+		return sql
+	}
+	return scrubSql(sql)
+}
+
+func (s *Server) LogSqlScrub() bool {
+	return atomic.LoadInt32(&s.logSqlScrub) != 0
+}
+
+func (s *Server) setLogSqlScrub(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&s.logSqlScrub, v)
+}
+
+//ChangeLogSqlScrub turns on or off the scrub of the sqls in the logs at
+//runtime, v is on or off
+func (s *Server) ChangeLogSqlScrub(v string) error {
+	switch strings.ToLower(v) {
+	case "on":
+		s.setLogSqlScrub(true)
+	case "off":
+		s.setLogSqlScrub(false)
+	default:
+		return errors.ErrCmdUnsupport
+	}
+	scrub := s.LogSqlScrub()
+	s.updateConfig(func(cfg *config.Config) {
+		cfg.LogSqlScrub = scrub
+	})
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+)
+
+func TestScrubSql(t *testing.T) {
+	tests := []struct {
+		sql    string
+		expect string
+	}{
+		{"select * from t1 where id = 12 and c2 = 1.5e-3",
+			"select * from t1 where id = ? and c2 = ?"},
+		{"select * from t where name = 'bob' or name = \"bob\"",
+			"select * from t where name = '#48181acd22b3' or name = '#48181acd22b3'"},
+		{"insert into `t_0001` (id, name) values (0x1f, 'it''s'), (-3, 'a\\'b')",
+			"insert into `t_0001` (id, name) values (?, '#dc0e8a59a90c'), (-?, '#0ac95bd2399c')"},
+		{"/*node1*/ select c1 from t2 limit 10",
+			"/*node1*/ select c1 from t2 limit ?"},
+		{"update t set c = '' where id in (1,2)",
+			"update t set c = '#da39a3ee5e6b' where id in (?,?)"},
+		{"select 'unterminated", "select '#722bb70fda46'"},
+	}
+	for _, tt := range tests {
+		if got := scrubSql(tt.sql); got != tt.expect {
+			t.Errorf("scrub %q: got %q, expect %q", tt.sql, got, tt.expect)
+		}
+	}
+}
+
+func TestChangeLogSqlScrub(t *testing.T) {
+	s := newStateServer(&config.Config{})
+	sql := "select * from t where id = 1"
+	if got := s.logSqlText(sql); got != sql {
+		t.Fatalf("got %q with scrub off", got)
+	}
+	if err := s.ChangeLogSqlScrub("ON"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.logSqlText(sql); got != "select * from t where id = ?" {
+		t.Fatalf("got %q with scrub on", got)
+	}
+	if !s.getState().cfg.LogSqlScrub {
+		t.Fatal("log_sql_scrub of config is not changed")
+	}
+	if err := s.ChangeLogSqlScrub("yes"); err == nil {
+		t.Fatal("invalid value is accepted")
+	}
+	if err := s.ChangeLogSqlScrub("off"); err != nil || s.LogSqlScrub() {
+		t.Fatalf("scrub is not turned off, err %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//the stages of the query path
+const (
+	StageParse = iota
+	StageRoute
+	StagePool //wait for a backend conn
+	StageBackend
+	StageMerge
+	StageWrite //write the result to client
+	stageCount
+)
+
+var stageNames = [stageCount]string{"parse", "route", "pool", "backend", "merge", "write"}
+
+//the upper bounds of the buckets of stage histograms, the last bucket
+//is for the longer times
+var stageBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type stageHistogram struct {
+	count   int64
+	total   int64 //nanoseconds
+	buckets [len(stageBuckets) + 1]int64
+}
+
+//StageTimes is the histograms of the time spent in each stage of the
+//query path, so the latency can be told from parse, route, conn pool
+//waits, backend, merge or write.
+type StageTimes struct {
+	stages [stageCount]stageHistogram
+}
+
+//StageTime is the histogram of a stage, Buckets[i] is the count of the
+//times in bucket i, see StageBucketNames
+type StageTime struct {
+	Stage   string  `json:"stage"`
+	Count   int64   `json:"count"`
+	AvgMs   float64 `json:"avg_ms"`
+	Buckets []int64 `json:"buckets"`
+}
+
+func NewStageTimes() *StageTimes {
+	return new(StageTimes)
+}
+
+//Since records the time since start in stage, it does nothing for a nil t
+func (t *StageTimes) Since(stage int, start time.Time) {
+	t.Observe(stage, time.Since(start))
+}
+
+func (t *StageTimes) Observe(stage int, d time.Duration) {
+	if t == nil {
+		return
+	}
+	h := &t.stages[stage]
+	i := 0
+	for i < len(stageBuckets) && stageBuckets[i] < d {
+		i++
+	}
+	atomic.AddInt64(&h.buckets[i], 1)
+	atomic.AddInt64(&h.total, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+func (t *StageTimes) Stats() []StageTime {
+	stats := make([]StageTime, stageCount)
+	for i := range stats {
+		h := &t.stages[i]
+		stats[i].Stage = stageNames[i]
+		stats[i].Count = atomic.LoadInt64(&h.count)
+		if stats[i].Count != 0 {
+			stats[i].AvgMs = float64(atomic.LoadInt64(&h.total)) /
+				float64(stats[i].Count) / float64(time.Millisecond)
+		}
+		stats[i].Buckets = make([]int64, len(h.buckets))
+		for j := range h.buckets {
+			stats[i].Buckets[j] = atomic.LoadInt64(&h.buckets[j])
+		}
+	}
+	return stats
+}
+
+//the names of the buckets, such as <=1ms and >1s
+func StageBucketNames() []string {
+	names := make([]string, 0, len(stageBuckets)+1)
+	for _, b := range stageBuckets {
+		names = append(names, "<="+durationName(b))
+	}
+	return append(names, ">"+durationName(stageBuckets[len(stageBuckets)-1]))
+}
+
+func durationName(d time.Duration) string {
+	return strings.Replace(d.String(), "\u00b5", "u", 1)
+}
+
+//record the time since start in stage
+func (c *ClientConn) stageSince(stage int, start time.Time) {
+	if c.proxy != nil {
+		c.proxy.stageTimes.Since(stage, start)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStageTimes(t *testing.T) {
+	names := StageBucketNames()
+	if names[0] != "<=100us" || names[len(names)-1] != ">1s" {
+		t.Fatal(names)
+	}
+
+	times := NewStageTimes()
+	times.Observe(StageParse, 50*time.Microsecond)
+	times.Observe(StageParse, 150*time.Microsecond)
+	times.Observe(StageBackend, time.Millisecond)
+	times.Observe(StageBackend, 2*time.Second)
+
+	stats := times.Stats()
+	if len(stats) != stageCount || stats[StageParse].Stage != "parse" {
+		t.Fatal(stats)
+	}
+	if stats[StageParse].Count != 2 || stats[StageParse].AvgMs != 0.1 {
+		t.Fatal(stats[StageParse])
+	}
+	expect := []int64{1, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !reflect.DeepEqual(stats[StageParse].Buckets, expect) {
+		t.Fatal(stats[StageParse].Buckets)
+	}
+	expect = []int64{0, 0, 1, 0, 0, 0, 0, 0, 0, 1}
+	if !reflect.DeepEqual(stats[StageBackend].Buckets, expect) {
+		t.Fatal(stats[StageBackend].Buckets)
+	}
+	if stats[StageWrite].Count != 0 || stats[StageWrite].AvgMs != 0 {
+		t.Fatal(stats[StageWrite])
+	}
+
+	//nil times records nothing
+	var none *StageTimes
+	none.Since(StageParse, time.Now())
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/config"
+)
+
+//serverState is the config and what is parsed from it, such as the nodes
+//and the schema. It is not changed after published, Reload publishes a new
+//one, so the sessions read it without locks.
+type serverState struct {
+	cfg        *config.Config
+	nodes      map[string]*backend.Node
+	schema     *Schema
+	rewriter   *SqlRewriter
+	mocker     *SqlMocker
+	limiter    *SelectLimiter
+	masker     *ColumnMasker
+	userQuota  *UserQuota
+	readyNodes []string //the nodes checked by Ready
+
+	//nil if only the users in config are allowed
+	authenticator Authenticator
+
+	//the sessions running a statement or transaction with the state
+	refs int32
+	//1 after the state is replaced
+	retired int32
+	//closed when the state is retired and not used by any session
+	drained   chan struct{}
+	drainOnce sync.Once
+}
+
+func newServerState(cfg *config.Config) *serverState {
+	return &serverState{
+		cfg:     cfg,
+		drained: make(chan struct{}),
+	}
+}
+
+//withConfig returns a copy of st with cfg, for the changes of config
+//which are not parsed again, such as log_sql
+func (st *serverState) withConfig(cfg *config.Config) *serverState {
+	next := newServerState(cfg)
+	next.nodes = st.nodes
+	next.schema = st.schema
+	next.rewriter = st.rewriter
+	next.mocker = st.mocker
+	next.limiter = st.limiter
+	next.masker = st.masker
+	next.userQuota = st.userQuota
+	next.readyNodes = st.readyNodes
+	next.authenticator = st.authenticator
+	return next
+}
+
+func (st *serverState) release() {
+	if atomic.AddInt32(&st.refs, -1) == 0 && atomic.LoadInt32(&st.retired) == 1 {
+		st.drainOnce.Do(func() { close(st.drained) })
+	}
+}
+
+func (st *serverState) retire() {
+	atomic.StoreInt32(&st.retired, 1)
+	if atomic.LoadInt32(&st.refs) == 0 {
+		st.drainOnce.Do(func() { close(st.drained) })
+	}
+}
+
+//getState returns the state published, it is only read by the caller
+func (s *Server) getState() *serverState {
+	return s.state.Load().(*serverState)
+}
+
+//acquireState returns the state published and holds it until release, the
+//nodes replaced by Reload are not closed until no session holds the old
+//state
+func (s *Server) acquireState() *serverState {
+	for {
+		st := s.getState()
+		atomic.AddInt32(&st.refs, 1)
+		if s.getState() == st {
+			return st
+		}
+		//replaced after loaded
+		st.release()
+	}
+}
+
+//publishState replaces the state with next, the nodes of the old state
+//not in next are closed after it is drained. Called with reloadLock held.
+func (s *Server) publishState(next *serverState) {
+	old := s.getState()
+	s.state.Store(next)
+	old.retire()
+
+	//the nodes kept by the old state are closed by the next one, so the
+	//states are closed in order
+	prev := s.retired
+	done := make(chan struct{})
+	s.retired = done
+	go func() {
+		<-old.drained
+		if prev != nil {
+			<-prev
+		}
+		closeNodes(old.nodes, next.nodes)
+		close(done)
+	}()
+}
+
+//updateConfig publishes a copy of the config changed by update, the config
+//published is not changed in place since the sessions read it without locks
+func (s *Server) updateConfig(update func(cfg *config.Config)) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	st := s.getState()
+	cfg := *st.cfg
+	cfg.Nodes = append([]config.NodeConfig(nil), cfg.Nodes...)
+	update(&cfg)
+	s.publishState(st.withConfig(&cfg))
+}
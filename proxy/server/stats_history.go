@@ -0,0 +1,412 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+const (
+	HistoryNode        = "node"
+	HistoryTable       = "table"
+	HistoryFingerprint = "fingerprint"
+
+	DefaultStatsHistoryDays = 7
+	//the max distinct fingerprints in a minute, the others are counted
+	//in OtherQueryTag
+	MaxHistoryFingerprints = 1000
+	//the minutes shown by admin show history without from
+	DefaultHistoryMinutes = 60
+
+	//the format of from and to of admin show history, in local time
+	HistoryTimeFormat = "2006-01-02T15:04"
+	//the stats of a day are in the file of its date in the directory
+	historyFileFormat = "2006-01-02"
+	historyFileSuffix = ".stats"
+)
+
+//HistoryStat is the load of a node, a sharding table or a fingerprint in
+//a minute, the times are in ms
+type HistoryStat struct {
+	Time      int64   `json:"time"` //the unix time of the minute
+	Kind      string  `json:"kind"`
+	Name      string  `json:"name"`
+	Queries   int64   `json:"queries"`
+	Errors    int64   `json:"errors"`
+	TotalTime float64 `json:"total_time"`
+	MaxTime   float64 `json:"max_time"`
+}
+
+type historyKey struct {
+	kind string
+	name string
+}
+
+//the sharding table and the nodes of the statement being executed
+type historyTargets struct {
+	table string
+	nodes []string
+}
+
+//StatsHistory rolls up the statements by node, sharding table and
+//fingerprint per minute, and appends the rollups to a file per day in
+//dir. The files older than days are removed, so the load of the past can
+//be compared without an external monitoring.
+type StatsHistory struct {
+	sync.Mutex
+
+	dir  string
+	days int
+
+	f       *os.File
+	day     string
+	minute  int64
+	stats   map[historyKey]*HistoryStat
+	fingers int //the distinct fingerprints of the minute
+}
+
+func OpenStatsHistory(dir string, days int) (*StatsHistory, error) {
+	if days <= 0 {
+		days = DefaultStatsHistoryDays
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	h := new(StatsHistory)
+	h.dir = dir
+	h.days = days
+	h.stats = make(map[historyKey]*HistoryStat)
+	return h, nil
+}
+
+//Record adds a statement which is finished at now
+func (h *StatsHistory) Record(now time.Time, fingerprint string, targets historyTargets,
+	d time.Duration, failed bool) {
+	ms := float64(d) / float64(time.Millisecond)
+	minute := now.Unix() / 60 * 60
+
+	h.Lock()
+	defer h.Unlock()
+	if minute != h.minute {
+		h.flush()
+		h.minute = minute
+	}
+	if _, ok := h.stats[historyKey{HistoryFingerprint, fingerprint}]; !ok {
+		if MaxHistoryFingerprints <= h.fingers {
+			fingerprint = OtherQueryTag
+		} else {
+			h.fingers++
+		}
+	}
+	h.add(HistoryFingerprint, fingerprint, ms, failed)
+	if len(targets.table) != 0 {
+		h.add(HistoryTable, targets.table, ms, failed)
+	}
+	for _, node := range targets.nodes {
+		h.add(HistoryNode, node, ms, failed)
+	}
+}
+
+func (h *StatsHistory) add(kind string, name string, ms float64, failed bool) {
+	key := historyKey{kind, name}
+	stat, ok := h.stats[key]
+	if !ok {
+		stat = &HistoryStat{Time: h.minute, Kind: kind, Name: name}
+		h.stats[key] = stat
+	}
+	stat.Queries++
+	if failed {
+		stat.Errors++
+	}
+	stat.TotalTime += ms
+	if stat.MaxTime < ms {
+		stat.MaxTime = ms
+	}
+}
+
+//Flush writes the stats of the minutes before now, it is called every
+//second so the minutes without statements after are not lost
+func (h *StatsHistory) Flush(now time.Time) {
+	h.Lock()
+	if h.minute < now.Unix()/60*60 {
+		h.flush()
+	}
+	h.Unlock()
+}
+
+//write the stats of the minute with the lock held
+func (h *StatsHistory) flush() {
+	if len(h.stats) == 0 {
+		return
+	}
+	stats := make([]HistoryStat, 0, len(h.stats))
+	for _, stat := range h.stats {
+		stats = append(stats, *stat)
+	}
+	h.stats = make(map[historyKey]*HistoryStat)
+	h.fingers = 0
+	sortHistoryStats(stats)
+
+	if err := h.openDay(time.Unix(h.minute, 0)); err != nil {
+		golog.Error("StatsHistory", "flush", err.Error(), 0, "dir", h.dir)
+		return
+	}
+	w := bufio.NewWriter(h.f)
+	for _, stat := range stats {
+		data, err := json.Marshal(stat)
+		if err != nil {
+			continue
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		golog.Error("StatsHistory", "flush", err.Error(), 0, "dir", h.dir)
+	}
+}
+
+//open the file of the day of t, the files out of the days kept are
+//removed when a new day starts
+func (h *StatsHistory) openDay(t time.Time) error {
+	day := t.Format(historyFileFormat)
+	if day == h.day && h.f != nil {
+		return nil
+	}
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+	f, err := os.OpenFile(filepath.Join(h.dir, day+historyFileSuffix),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	h.f = f
+	h.day = day
+	h.removeExpired(t)
+	return nil
+}
+
+func (h *StatsHistory) removeExpired(now time.Time) {
+	expire := now.AddDate(0, 0, -h.days).Format(historyFileFormat)
+	for _, day := range h.fileDays() {
+		if day <= expire {
+			os.Remove(filepath.Join(h.dir, day+historyFileSuffix))
+		}
+	}
+}
+
+//the days of the files in dir in order
+func (h *StatsHistory) fileDays() []string {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for _, f := range files {
+		day := strings.TrimSuffix(f.Name(), historyFileSuffix)
+		if day == f.Name() {
+			continue
+		}
+		if _, err := time.Parse(historyFileFormat, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+//Query returns the stats of kind of the minutes in [from, to), including
+//the minute not written yet. Empty name means all the names of kind.
+func (h *StatsHistory) Query(kind string, name string, from time.Time, to time.Time) ([]HistoryStat, error) {
+	match := func(stat *HistoryStat) bool {
+		return stat.Kind == kind && (len(name) == 0 || stat.Name == name) &&
+			from.Unix()/60*60 <= stat.Time && stat.Time < to.Unix()
+	}
+
+	var stats []HistoryStat
+	first := from.Format(historyFileFormat)
+	last := to.Format(historyFileFormat)
+	//the files are read without the lock, the last line being written
+	//may be cut and is skipped
+	for _, day := range h.fileDays() {
+		if day < first || last < day {
+			continue
+		}
+		f, err := os.Open(filepath.Join(h.dir, day+historyFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var stat HistoryStat
+			if err := json.Unmarshal(scanner.Bytes(), &stat); err != nil {
+				continue
+			}
+			if match(&stat) {
+				stats = append(stats, stat)
+			}
+		}
+		f.Close()
+	}
+
+	h.Lock()
+	for _, stat := range h.stats {
+		if match(stat) {
+			stats = append(stats, *stat)
+		}
+	}
+	h.Unlock()
+	sortHistoryStats(stats)
+	return stats, nil
+}
+
+func (h *StatsHistory) Close() {
+	h.Lock()
+	h.flush()
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+	h.Unlock()
+}
+
+//ordered by time, kind and name
+type historyStatList []HistoryStat
+
+func (l historyStatList) Len() int      { return len(l) }
+func (l historyStatList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l historyStatList) Less(i, j int) bool {
+	if l[i].Time != l[j].Time {
+		return l[i].Time < l[j].Time
+	}
+	if l[i].Kind != l[j].Kind {
+		return l[i].Kind < l[j].Kind
+	}
+	return l[i].Name < l[j].Name
+}
+
+func sortHistoryStats(stats []HistoryStat) {
+	sort.Sort(historyStatList(stats))
+}
+
+func (s *Server) parseStatsHistory() error {
+	cfg := s.getState().cfg
+	if len(cfg.StatsHistory) == 0 {
+		return nil
+	}
+	var err error
+	if s.statsHistory, err = OpenStatsHistory(cfg.StatsHistory, cfg.StatsHistoryDays); err != nil {
+		return err
+	}
+	golog.Info("server", "parseStatsHistory", "the stats are rolled up per minute", 0,
+		"stats_history", cfg.StatsHistory,
+		"days", s.statsHistory.days)
+	return nil
+}
+
+//the plan is executed by the statement, its sharding table and nodes are
+//recorded in the stats history
+func (c *ClientConn) setHistoryPlan(plan *router.Plan) {
+	if c.proxy.statsHistory == nil {
+		return
+	}
+	if plan.Rule != nil && plan.Rule.Type != router.DefaultRuleType {
+		c.history.table = plan.Rule.DB + "." + plan.Rule.Table
+	}
+	c.history.nodes = sortedNodeNames(plan.RewrittenSqls)
+}
+
+func (c *ClientConn) setHistoryNode(node string) {
+	if c.proxy.statsHistory == nil {
+		return
+	}
+	c.history.nodes = []string{node}
+}
+
+//record the statement sql in the stats history if it is set
+func (c *ClientConn) recordHistory(sql string, d time.Duration, err error) {
+	if c.proxy.statsHistory == nil {
+		return
+	}
+	c.proxy.statsHistory.Record(time.Now(), mysql.GetFingerprint(sql), c.history, d, err != nil)
+	c.history = historyTargets{}
+}
+
+//parse the v of admin show history, which is the kind followed by the
+//options from=2006-01-02T15:04, to=2006-01-02T15:04 and name=..., the name
+//is the rest of v since a fingerprint has spaces
+func parseHistoryQuery(v string, now time.Time) (kind string, name string, from time.Time, to time.Time, err error) {
+	if i := strings.Index(v, "name="); i != -1 {
+		name = strings.TrimSpace(v[i+len("name="):])
+		v = v[:i]
+	}
+	args := strings.Fields(v)
+	if len(args) == 0 {
+		return "", "", from, to, fmt.Errorf("invalid history %s, need node, table or fingerprint", v)
+	}
+	kind = args[0]
+	switch kind {
+	case HistoryNode, HistoryTable, HistoryFingerprint:
+	default:
+		return "", "", from, to, fmt.Errorf("invalid history %s, need node, table or fingerprint", kind)
+	}
+
+	to = now
+	var hasFrom bool
+	for _, arg := range args[1:] {
+		var t *time.Time
+		switch {
+		case strings.HasPrefix(arg, "from="):
+			t, hasFrom = &from, true
+		case strings.HasPrefix(arg, "to="):
+			t = &to
+		default:
+			return "", "", from, to, fmt.Errorf("invalid history option %s", arg)
+		}
+		value := arg[strings.Index(arg, "=")+1:]
+		if *t, err = time.ParseInLocation(HistoryTimeFormat, value, time.Local); err != nil {
+			return "", "", from, to, fmt.Errorf("invalid history time %s, need %s", value, HistoryTimeFormat)
+		}
+	}
+	if !hasFrom {
+		from = to.Add(-DefaultHistoryMinutes * time.Minute)
+	}
+	return kind, name, from, to, nil
+}
+
+func (s *Server) GetStatsHistory(v string) ([]HistoryStat, error) {
+	if s.statsHistory == nil {
+		return nil, fmt.Errorf("stats_history is not set")
+	}
+	kind, name, from, to, err := parseHistoryQuery(v, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.statsHistory.Query(kind, name, from, to)
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+//the stats of a minute are written when the next minute starts, and the
+//files out of the days kept are removed
+func TestStatsHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := OpenStatsHistory(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2016, 5, 1, 3, 0, 10, 0, time.Local)
+	targets := historyTargets{table: "kingshard.t", nodes: []string{"node1", "node2"}}
+	h.Record(day, "select * from t where id = ?", targets, 10*time.Millisecond, false)
+	h.Record(day.Add(20*time.Second), "select * from t where id = ?", targets, 30*time.Millisecond, true)
+	h.Record(day.Add(time.Minute), "select * from t", historyTargets{nodes: []string{"node1"}},
+		5*time.Millisecond, false)
+	h.Flush(day.Add(2 * time.Minute))
+
+	stats, err := h.Query(HistoryNode, "node1", day.Add(-time.Hour), day.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Queries != 2 || stats[0].Errors != 1 ||
+		stats[0].TotalTime != 40 || stats[0].MaxTime != 30 ||
+		stats[1].Queries != 1 || stats[1].Time != day.Unix()/60*60+60 {
+		t.Fatal(stats)
+	}
+	stats, _ = h.Query(HistoryTable, "", day.Add(-time.Hour), day.Add(time.Hour))
+	if len(stats) != 1 || stats[0].Name != "kingshard.t" || stats[0].Queries != 2 {
+		t.Fatal(stats)
+	}
+	stats, _ = h.Query(HistoryFingerprint, "", day.Add(time.Minute), day.Add(time.Hour))
+	if len(stats) != 1 || stats[0].Name != "select * from t" {
+		t.Fatal(stats)
+	}
+
+	//the minute not written yet is queried from memory
+	h.Record(day.AddDate(0, 0, 3), "select 1", historyTargets{}, time.Millisecond, false)
+	stats, _ = h.Query(HistoryFingerprint, "select 1", day, day.AddDate(0, 0, 4))
+	if len(stats) != 1 {
+		t.Fatal(stats)
+	}
+	h.Close()
+	if days := h.fileDays(); len(days) != 1 || days[0] != "2016-05-04" {
+		t.Fatal(days)
+	}
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local)
+	kind, name, from, to, err := parseHistoryQuery(
+		"fingerprint from=2016-05-01T02:30 to=2016-05-01T03:30 name=select * from t where id = ?", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != HistoryFingerprint || name != "select * from t where id = ?" ||
+		from != time.Date(2016, 5, 1, 2, 30, 0, 0, time.Local) ||
+		to != time.Date(2016, 5, 1, 3, 30, 0, 0, time.Local) {
+		t.Fatal(kind, name, from, to)
+	}
+
+	kind, name, from, to, err = parseHistoryQuery("node", now)
+	if err != nil || kind != HistoryNode || len(name) != 0 ||
+		to != now || from != now.Add(-time.Hour) {
+		t.Fatal(kind, name, from, to, err)
+	}
+
+	for _, v := range []string{"", "user", "node from=3am", "node since=2016-05-01T02:30"} {
+		if _, _, _, _, err = parseHistoryQuery(v, now); err == nil {
+			t.Fatal("expect error of invalid history", v)
+		}
+	}
+}
+
+func TestShowHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+stats_history : `+filepath.Join(dir, "stats")+`
+`)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+
+	if _, err = c.Execute("select id from t where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Execute("select id from t"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Execute("admin server(opt,k,v) values('show','history','node')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := make(map[string]int64)
+	for i := range r.Values {
+		name, _ := r.GetString(i, 2)
+		n, _ := r.GetInt(i, 3)
+		queries[name] += n
+	}
+	if queries["node1"] != 1 || queries["node2"] != 2 {
+		t.Fatal(queries)
+	}
+
+	r, err = c.Execute("admin server(opt,k,v) values('show','history','table name=kingshard.t')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	for i := range r.Values {
+		v, _ := r.GetInt(i, 3)
+		n += v
+	}
+	if n != 2 {
+		t.Fatal(r.Values)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"strings"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//the logical table of name if it is a sub table of rule, such as orders
+//of orders_0001, else name itself
+func logicalTable(rule *router.Router, name string) string {
+	name = strings.Trim(name, "`")
+	if rule != nil {
+		if r := rule.SubTableRule(name); r != nil {
+			return r.Table
+		}
+	}
+	return name
+}
+
+//send the sql to the node of the first sub table, with the sharding tables
+//replaced by their first sub tables, such as show create table orders is
+//sent as show create table orders_0000
+func (c *ClientConn) routeToFirstSubTables(executeDB *ExecuteDB) {
+	if c.schema == nil {
+		return
+	}
+	sql, nodeName := c.schema.rule.ReplaceFirstSubTables(executeDB.sql, c.db)
+	if len(nodeName) == 0 {
+		return
+	}
+	executeDB.sql = sql
+	executeDB.ExecNode = c.schema.nodes[nodeName]
+	executeDB.stripSubTables = true
+}
+
+//the error of backend with the sub tables replaced by logical tables
+func (c *ClientConn) stripSubTablesError(err error) error {
+	e, ok := err.(*mysql.SqlError)
+	if !ok || c.schema == nil {
+		return err
+	}
+	return &mysql.SqlError{Code: e.Code, State: e.State, Message: c.schema.rule.StripSubTables(e.Message)}
+}
+
+//the sub tables in the text values of r are replaced by logical tables,
+//such as the table name and the create table statement
+func (c *ClientConn) stripSubTablesResultset(r *mysql.Resultset) (*mysql.Resultset, error) {
+	rule := c.schema.rule
+	names := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		names[i] = string(f.Name)
+	}
+	values := make([][]interface{}, len(r.Values))
+	for i, row := range r.Values {
+		values[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			switch v := v.(type) {
+			case string:
+				values[i][j] = rule.StripSubTables(v)
+			case []byte:
+				values[i][j] = []byte(rule.StripSubTables(string(v)))
+			default:
+				values[i][j] = v
+			}
+		}
+	}
+	return c.buildResultset(c.stripFieldTables(r.Fields), names, values)
+}
+
+//the table and org table of the columns of sub tables are replaced by
+//their logical tables, the fields are copied if changed
+func (c *ClientConn) stripFieldTables(fields []*mysql.Field) []*mysql.Field {
+	if c.schema == nil {
+		return fields
+	}
+	rule := c.schema.rule
+	var stripped []*mysql.Field
+	for i, f := range fields {
+		table := rule.SubTableRule(string(f.Table))
+		orgTable := rule.SubTableRule(string(f.OrgTable))
+		if table == nil && orgTable == nil {
+			continue
+		}
+		if stripped == nil {
+			stripped = make([]*mysql.Field, len(fields))
+			copy(stripped, fields)
+		}
+		field := *f
+		field.Data = nil
+		if table != nil {
+			field.Table = []byte(table.Table)
+		}
+		if orgTable != nil {
+			field.OrgTable = []byte(orgTable.Table)
+		}
+		stripped[i] = &field
+	}
+	if stripped == nil {
+		return fields
+	}
+	return stripped
+}
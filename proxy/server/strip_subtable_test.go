@@ -0,0 +1,60 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestStripSubTables(t *testing.T) {
+	c := newPlanCacheConn(t, 0)
+
+	err := c.stripSubTablesError(mysql.NewDefaultError(mysql.ER_NO_SUCH_TABLE, "kingshard", "test1_0005"))
+	if e := err.(*mysql.SqlError); e.Code != mysql.ER_NO_SUCH_TABLE || e.Message != "Table 'kingshard.test1' doesn't exist" {
+		t.Fatal(e)
+	}
+
+	names := []string{"Table", "Create Table"}
+	r, err := c.buildResultset(nil, names, [][]interface{}{
+		{"test1_0000", "CREATE TABLE `test1_0000` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Fields[0].Table = []byte("test1_0000")
+	r.Fields[1].OrgTable = []byte("test1_0000")
+	if r, err = c.stripSubTablesResultset(r); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := r.GetString(0, 0); v != "test1" {
+		t.Fatal(v)
+	}
+	if v, _ := r.GetString(0, 1); v != "CREATE TABLE `test1` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB" {
+		t.Fatal(v)
+	}
+	if string(r.Fields[0].Table) != "test1" || string(r.Fields[1].OrgTable) != "test1" {
+		t.Fatal("the tables of fields are not stripped")
+	}
+	if _, err := r.RowDatas[0].ParseText(r.Fields); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := []*mysql.Field{{Name: []byte("id"), Table: []byte("t"), OrgTable: []byte("test2_0000")}}
+	if stripped := c.stripFieldTables(fields); stripped[0] != fields[0] {
+		t.Fatal("the field of a non sub table is copied")
+	}
+}
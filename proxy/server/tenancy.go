@@ -0,0 +1,46 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/proxy/router"
+	"github.com/flike/kingshard/sqlparser"
+)
+
+//set kingshard_tenant = 42 sets the tenant of the session
+const TenantVariable = "KINGSHARD_TENANT"
+
+//the tenant is the default tenant of proxy user, and can be changed by
+//set kingshard_tenant, '' means no tenant.
+func (c *ClientConn) handleSetTenant(val sqlparser.ValExpr) error {
+	tenant := strings.Trim(sqlparser.String(val), "'`\"")
+	if len(tenant) != 0 && !router.IsValidTenant(tenant) {
+		return fmt.Errorf("invalid tenant %s", tenant)
+	}
+	c.tenant = tenant
+	return c.writeOK(nil)
+}
+
+//qualify the tenant tables in sql with the schema of the tenant, the
+///*tenant:id*/ hint overrides the tenant of session.
+func (c *ClientConn) rewriteTenantSql(sql string) (string, error) {
+	if c.schema == nil {
+		return sql, nil
+	}
+	return c.schema.rule.RewriteTenantSql(sql, c.db, c.tenant)
+}
@@ -0,0 +1,227 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flike/kingshard/backend"
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+)
+
+//the policy of a transaction whose backend conn is lost
+const (
+	TxFailoverError  = "error"  //roll back the transaction and return ER_KS_TX_CONN_LOST
+	TxFailoverReplay = "replay" //replay a read only transaction in a new conn
+
+	//the max count of the sqls recorded for replay, a longer transaction
+	//is not replayed
+	MaxTxReplaySqls = 256
+)
+
+type txSql struct {
+	sql  string
+	args []interface{}
+}
+
+//the selects sent to the conn of the transaction, they are run again in
+//a new conn if the conn is lost
+type txReplayLog struct {
+	sqls []txSql
+	//the transaction has a statement other than select, or too many
+	//selects, so it is not replayed
+	dirty bool
+}
+
+func checkTxFailoverPolicy(policy string) error {
+	switch policy {
+	case "", TxFailoverError, TxFailoverReplay:
+		return nil
+	}
+	return fmt.Errorf("invalid tx_failover_policy %s", policy)
+}
+
+//a select without lock and into can be run again without side effect
+func isReplayableSql(sql string) bool {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		if !strings.HasPrefix(sql, "/*") {
+			break
+		}
+		end := strings.Index(sql, "*/")
+		if end == -1 {
+			return false
+		}
+		sql = sql[end+2:]
+	}
+	sql = strings.ToLower(sql)
+	if !strings.HasPrefix(sql, mysql.TK_STR_SELECT) {
+		return false
+	}
+	for _, s := range []string{" for update", " for share", " lock in share mode", " into "} {
+		if strings.Contains(sql, s) {
+			return false
+		}
+	}
+	return true
+}
+
+//handle a statement which may run in the conn of a transaction. If the
+//conn is lost, such as the backend is down, the transaction is rolled
+//back and the statement gets ER_KS_TX_CONN_LOST, instead of a broken
+//conn error. With the replay policy, a transaction of selects only is
+//replayed in a new conn and the statement is handled again, if nothing
+//of the statement has been written to client.
+func (c *ClientConn) handleTxFailover(sql string, handle func() error) error {
+	sequence := c.pkg.Sequence
+	err := handle()
+	n := c.lostTxNode(err)
+	if n != nil && c.canReplayTx(sql) && c.pkg.Sequence == sequence {
+		if err = c.replayTx(n); err == nil {
+			err = handle()
+			n = c.lostTxNode(err)
+		}
+	}
+	if n != nil {
+		return c.abortTx(n, err)
+	}
+
+	switch {
+	case len(c.txConns) == 0:
+		c.txLog = txReplayLog{}
+	case !isReplayableSql(sql):
+		c.txLog = txReplayLog{dirty: true}
+	}
+	return err
+}
+
+//the node whose conn of the transaction is lost by err, nil if none. The
+//conn killed for the cancel of the statement is not counted.
+func (c *ClientConn) lostTxNode(err error) *backend.Node {
+	if err == nil || c.xa != TxAutoCommit || c.ctx.Err() != nil {
+		return nil
+	}
+	for n, co := range c.txConns {
+		if co.IsBroken() {
+			return n
+		}
+	}
+	return nil
+}
+
+func (c *ClientConn) canReplayTx(sql string) bool {
+	return c.state.cfg.TxFailoverPolicy == TxFailoverReplay &&
+		!c.txLog.dirty && isReplayableSql(sql)
+}
+
+func (c *ClientConn) isTxConn(co *backend.BackendConn) bool {
+	for _, v := range c.txConns {
+		if v == co {
+			return true
+		}
+	}
+	return false
+}
+
+//record the sql executed successfully in co for replay, if co is a conn
+//of the transaction
+func (c *ClientConn) recordTxSql(co *backend.BackendConn, sql string, args []interface{}) {
+	if c.state.cfg.TxFailoverPolicy != TxFailoverReplay || !c.isTxConn(co) || c.txLog.dirty {
+		return
+	}
+	if !isReplayableSql(sql) || MaxTxReplaySqls <= len(c.txLog.sqls) {
+		c.txLog = txReplayLog{dirty: true}
+		return
+	}
+	c.txLog.sqls = append(c.txLog.sqls, txSql{sql: sql, args: args})
+}
+
+//close the conns of the transaction, the lost ones are dropped and the
+//others are rolled back
+func (c *ClientConn) closeTxConns() {
+	for _, co := range c.txConns {
+		if !co.IsBroken() {
+			co.Rollback()
+		}
+		co.Close()
+	}
+	c.txConns = make(map[*backend.Node]*backend.BackendConn)
+}
+
+//roll back the transaction whose conn of node n is lost by err, the
+//session goes on out of transaction
+func (c *ClientConn) abortTx(n *backend.Node, err error) error {
+	golog.Warn("ClientConn", "abortTx", "rollback transaction for lost conn", c.connectionId,
+		"state", c.txState().String(),
+		"node", n.Cfg.Name,
+		"error", err.Error())
+	c.proxy.counter.IncrTxConnLosts()
+	c.closeTxConns()
+	c.status &= ^mysql.SERVER_STATUS_IN_TRANS
+	c.txLog = txReplayLog{}
+	return mysql.NewError(mysql.ER_KS_TX_CONN_LOST,
+		fmt.Sprintf("transaction is rolled back for the conn of node %s is lost, %s", n.Cfg.Name, err.Error()))
+}
+
+//replay the selects of the transaction in a new conn of node n, the
+//master or a slave if the master fails. The transaction replayed on a
+//slave is read only, so its writes are rejected by the slave.
+func (c *ClientConn) replayTx(n *backend.Node) error {
+	c.closeTxConns()
+	co, err := c.replayTxIn(n, n.GetMasterConn, "begin")
+	if err != nil {
+		golog.Warn("ClientConn", "replayTx", "replay transaction on master", c.connectionId,
+			"node", n.Cfg.Name,
+			"error", err.Error())
+		co, err = c.replayTxIn(n, n.GetSlaveConn, "start transaction read only")
+	}
+	if err != nil {
+		return err
+	}
+	c.txConns[n] = co
+	c.proxy.counter.IncrTxReplays()
+	golog.Warn("ClientConn", "replayTx", "transaction replayed", c.connectionId,
+		"node", n.Cfg.Name,
+		"addr", co.GetAddr(),
+		"sqls", len(c.txLog.sqls))
+	return nil
+}
+
+func (c *ClientConn) replayTxIn(n *backend.Node, getConn func() (*backend.BackendConn, error), begin string) (*backend.BackendConn, error) {
+	release, err := c.state.userQuota.Acquire(c.user, n.Cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	co, err := getConn()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	co.SetRelease(release)
+	if err = c.initBackendConn(co); err == nil {
+		c.proxy.faults.InjectConn(n.Cfg.Name, co)
+		_, err = co.ExecuteContext(c.ctx, begin)
+	}
+	for i := 0; err == nil && i < len(c.txLog.sqls); i++ {
+		_, err = co.ExecuteContext(c.ctx, c.txLog.sqls[i].sql, c.txLog.sqls[i].args...)
+	}
+	if err != nil {
+		co.Close()
+		return nil, err
+	}
+	return co, nil
+}
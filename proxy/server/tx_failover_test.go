@@ -0,0 +1,130 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestTxFailoverError(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	defer close()
+
+	if _, err := c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into t(id) values (2)"); err != nil {
+		t.Fatal(err)
+	}
+	backends[0].SetDown(true)
+	_, err := c.Execute("insert into t(id) values (2)")
+	expectSqlError(t, err, mysql.ER_KS_TX_CONN_LOST, "conn of node node1 is lost")
+	if n := atomic.LoadInt64(&s.counter.TxConnLosts); n != 1 {
+		t.Fatal(n)
+	}
+
+	//the client conn is kept and the session is out of transaction
+	if _, err := c.Execute("insert into t(id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsInTransaction() {
+		t.Fatal("in transaction")
+	}
+}
+
+func TestTxFailoverReplay(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, "tx_failover_policy : replay\n"+readAfterWriteConfig)
+	defer close()
+	for _, b := range backends {
+		b.Handle(`^select`, &mysqltest.Response{
+			Names: []string{"id"},
+			Rows:  [][]interface{}{{1}},
+		})
+	}
+
+	//the transaction of selects goes on in the slave
+	for _, sql := range []string{"begin", "select id from t"} {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backends[0].SetDown(true)
+	r, err := c.Execute("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Values) != 1 {
+		t.Fatal(r.Values)
+	}
+	if n := atomic.LoadInt64(&s.counter.TxReplays); n != 1 {
+		t.Fatal(n)
+	}
+	if _, err := c.Execute("commit"); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := backends[1].Queries()
+	if !hasQuery(queries, "start transaction read only") {
+		t.Fatal(queries)
+	}
+	var selects int
+	for _, q := range queries {
+		if q == "select id from t" {
+			selects++
+		}
+	}
+	if selects != 2 {
+		t.Fatal(queries)
+	}
+}
+
+//a transaction with a write is rolled back
+func TestTxFailoverReplayWrite(t *testing.T) {
+	_, backends, c, close := newFakeProxy(t, "tx_failover_policy : replay\n"+readAfterWriteConfig)
+	defer close()
+
+	for _, sql := range []string{"begin", "insert into t(id) values (1)"} {
+		if _, err := c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backends[0].SetDown(true)
+	_, err := c.Execute("select id from t")
+	expectSqlError(t, err, mysql.ER_KS_TX_CONN_LOST, "conn of node node1 is lost")
+}
+
+func TestIsReplayableSql(t *testing.T) {
+	tests := []struct {
+		sql        string
+		replayable bool
+	}{
+		{"select id from t", true},
+		{"/*master*/ SELECT id from t", true},
+		{"select id from t for update", false},
+		{"select id from t lock in share mode", false},
+		{"select id into @a from t", false},
+		{"insert into t(id) values (1)", false},
+		{"set autocommit = 0", false},
+	}
+	for _, tt := range tests {
+		if got := isReplayableSql(tt.sql); got != tt.replayable {
+			t.Errorf("%s: got %v", tt.sql, got)
+		}
+	}
+}
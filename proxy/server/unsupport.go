@@ -0,0 +1,87 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flike/kingshard/core/golog"
+)
+
+//the classes of the statements which kingshard can not handle
+const (
+	UnsupportSet       = "set"       //set transaction and the set of variables not tracked
+	UnsupportStatement = "statement" //the statements parsed but not supported, such as union
+	UnsupportPrepare   = "prepare"   //the prepared statements not supported
+)
+
+//the responses of the unsupported statements
+const (
+	UnsupportReject  = "reject"  //return the error to client
+	UnsupportIgnore  = "ignore"  //return ok without execution
+	UnsupportForward = "forward" //send the sql verbatim to the default node
+)
+
+func checkUnsupportPolicy(policy map[string]string) error {
+	for class, response := range policy {
+		switch class {
+		case UnsupportSet, UnsupportStatement, UnsupportPrepare:
+		default:
+			return fmt.Errorf("invalid unsupport_policy class %s", class)
+		}
+		switch response {
+		case UnsupportReject, UnsupportIgnore, UnsupportForward:
+		default:
+			return fmt.Errorf("invalid unsupport_policy %s of %s", response, class)
+		}
+	}
+	return nil
+}
+
+//the unsupport_policy shown by admin, such as set:forward,statement:reject
+func formatUnsupportPolicy(policy map[string]string) string {
+	items := make([]string, 0, len(policy))
+	for class, response := range policy {
+		items = append(items, class+":"+response)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+//respond to the sql of class which kingshard can not handle by the
+//unsupport_policy of class, or by def if it is not configured. reject
+//returns err, ignore writes ok and forward calls forward, both of them
+//log a warning so the statements can be fixed before the migration ends.
+func (c *ClientConn) handleUnsupport(class string, sql string, def string,
+	err error, forward func() error) error {
+	response := c.state.cfg.UnsupportPolicy[class]
+	if len(response) == 0 {
+		response = def
+	}
+
+	switch response {
+	case UnsupportIgnore:
+		golog.Warn("ClientConn", "handleUnsupport", "statement ignored", c.connectionId,
+			"class", class, "error", err.Error(), "sql", c.proxy.logSqlText(sql))
+		return c.writeOK(nil)
+	case UnsupportForward:
+		golog.Warn("ClientConn", "handleUnsupport", "statement forwarded", c.connectionId,
+			"class", class, "error", err.Error(), "sql", c.proxy.logSqlText(sql))
+		return forward()
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+)
+
+func TestCheckUnsupportPolicy(t *testing.T) {
+	policy := map[string]string{
+		UnsupportSet:       UnsupportIgnore,
+		UnsupportStatement: UnsupportForward,
+		UnsupportPrepare:   UnsupportReject,
+	}
+	if err := checkUnsupportPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if s := formatUnsupportPolicy(policy); s != "prepare:reject,set:ignore,statement:forward" {
+		t.Fatal(s)
+	}
+	if err := checkUnsupportPolicy(map[string]string{"ddl": UnsupportForward}); err == nil {
+		t.Fatal("must err")
+	}
+	if err := checkUnsupportPolicy(map[string]string{UnsupportSet: "warn"}); err == nil {
+		t.Fatal("must err")
+	}
+}
+
+func TestUnsupportPolicy(t *testing.T) {
+	union := "select * from t union select * from t"
+	setTx := "set session transaction isolation level read committed"
+
+	_, backends, c, close := newFakeProxy(t, fakeBackendConfig)
+	_, err := c.Execute(union)
+	expectSqlError(t, err, mysql.ER_KS_CMD_UNSUPPORT, "statement *sqlparser.Union not support now")
+	if _, err = c.Execute(setTx); err != nil {
+		t.Fatal(err)
+	}
+	if hasQuery(backends[0].Queries(), union) || hasQuery(backends[0].Queries(), setTx) {
+		t.Fatal(backends[0].Queries())
+	}
+	close()
+
+	_, backends, c, close = newFakeProxy(t, fakeBackendConfig+`
+unsupport_policy :
+    set : forward
+    statement : forward
+`)
+	defer close()
+	for _, sql := range []string{union, setTx} {
+		if _, err = c.Execute(sql); err != nil {
+			t.Fatal(err)
+		}
+		if !hasQuery(backends[0].Queries(), sql) || hasQuery(backends[1].Queries(), sql) {
+			t.Fatal(sql, backends[0].Queries(), backends[1].Queries())
+		}
+	}
+}
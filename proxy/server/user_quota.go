@@ -0,0 +1,78 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sync"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+)
+
+//UserQuota partitions the backend conns of each node by proxy user, a user
+//can use at most max_backend_conns conns of a node at the same time, so
+//a user exhausting conns can not starve the other users.
+type UserQuota struct {
+	sync.Mutex
+
+	limits map[string]int //user -> max conns in a node
+	used   map[string]int //user/node -> conns in use
+}
+
+func NewUserQuota(users []config.UserConfig) *UserQuota {
+	q := new(UserQuota)
+	q.limits = make(map[string]int)
+	q.used = make(map[string]int)
+	for _, u := range users {
+		if 0 < u.MaxBackendConns {
+			q.limits[u.User] = u.MaxBackendConns
+		}
+	}
+	return q
+}
+
+//Acquire a backend conn of node for user, the func returned must be called
+//when the conn is closed. It fails if the user has used up its quota.
+func (q *UserQuota) Acquire(user string, node string) (func(), error) {
+	limit, ok := q.limits[user]
+	if !ok {
+		return func() {}, nil
+	}
+
+	key := user + "/" + node
+	q.Lock()
+	if limit <= q.used[key] {
+		q.Unlock()
+		return nil, errors.ErrUserConnQuota
+	}
+	q.used[key]++
+	q.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.Lock()
+			q.used[key]--
+			q.Unlock()
+		})
+	}, nil
+}
+
+//the backend conns of node used by user
+func (q *UserQuota) Used(user string, node string) int {
+	q.Lock()
+	defer q.Unlock()
+	return q.used[user+"/"+node]
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/flike/kingshard/config"
+	"github.com/flike/kingshard/core/errors"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+func TestUserQuota(t *testing.T) {
+	users := []config.UserConfig{
+		{User: "tenant_a", Password: "a", MaxBackendConns: 2},
+		{User: "tenant_b", Password: "b"},
+	}
+	q := NewUserQuota(users)
+
+	r1, err := q.Acquire("tenant_a", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Acquire("tenant_a", "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Acquire("tenant_a", "node1"); err != errors.ErrUserConnQuota {
+		t.Fatal(err)
+	}
+	//the quota is per node, and the other users are not limited
+	if _, err = q.Acquire("tenant_a", "node2"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = q.Acquire("tenant_b", "node1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//release twice only returns one conn
+	r1()
+	r1()
+	if q.Used("tenant_a", "node1") != 1 {
+		t.Fatal(q.Used("tenant_a", "node1"))
+	}
+	if _, err = q.Acquire("tenant_a", "node1"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newStateServer(&config.Config{User: "root", Password: "root", Users: users})
+	if passwords, ok := s.getPasswords("root"); !ok || len(passwords) != 1 || passwords[0] != "root" {
+		t.Fatal(passwords)
+	}
+	if passwords, ok := s.getPasswords("tenant_b"); !ok || len(passwords) != 1 || passwords[0] != "b" {
+		t.Fatal(passwords)
+	}
+	if _, ok := s.getPasswords("tenant_c"); ok {
+		t.Fatal("tenant_c does not exist")
+	}
+}
+
+//the conn failing to begin the transaction is closed with its quota
+func TestUserQuotaBeginFail(t *testing.T) {
+	s, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+users :
+-
+    user : root
+    max_backend_conns : 1
+`)
+	defer close()
+	backends[0].Handle(`^begin`, &mysqltest.Response{Err: mysql.NewDefaultError(mysql.ER_LOCK_WAIT_TIMEOUT)})
+	backends[0].Handle(`^set autocommit = 0`, &mysqltest.Response{Err: mysql.NewDefaultError(mysql.ER_LOCK_WAIT_TIMEOUT)})
+
+	for _, begin := range []string{"begin", "set autocommit = 0"} {
+		if _, err := c.Execute(begin); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Execute("insert into t (id) values (2)"); err == nil {
+			t.Fatal(begin, "must fail")
+		}
+		if used := s.getState().userQuota.Used("root", "node1"); used != 0 {
+			t.Fatal(begin, used)
+		}
+		if _, err := c.Execute("rollback"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Execute("set autocommit = 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flike/kingshard/core/golog"
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/proxy/router"
+)
+
+//JournalEntry is a multi-node write in the journal, with the sqls of each
+//node in the order of node names
+type JournalEntry struct {
+	Id        int64
+	Time      time.Time
+	DB        string
+	Charset   string
+	Collation mysql.CollationId
+	Sqls      []JournalSql
+
+	//the sqls are being executed by the client or a retry
+	running bool
+}
+
+type JournalSql struct {
+	Node  string
+	Sql   string
+	Acked bool
+	Err   string //the error of the last execution, not persisted
+}
+
+//the line of journal file, an entry is written with its sqls before the
+//execution, the acks and done are appended after
+type journalRecord struct {
+	Id        int64             `json:"id"`
+	Time      int64             `json:"time,omitempty"`
+	DB        string            `json:"db,omitempty"`
+	Charset   string            `json:"charset,omitempty"`
+	Collation mysql.CollationId `json:"collation,omitempty"`
+	Nodes     []string          `json:"nodes,omitempty"`
+	Sqls      []string          `json:"sqls,omitempty"`
+	Acks      []int             `json:"acks,omitempty"`
+	Done      bool              `json:"done,omitempty"`
+}
+
+//WriteJournal persists the sqls of the multi-node writes before they are
+//executed, and acks each sql once it succeeds. The sqls not acked, such
+//as the ones on a node which is down, are retried on restart and by admin,
+//so a partial write is recoverable instead of silent. It is at least
+//once, a sql whose ack is lost is executed again.
+type WriteJournal struct {
+	sync.Mutex
+
+	path    string
+	f       *os.File
+	nextId  int64
+	entries map[int64]*JournalEntry
+}
+
+//OpenWriteJournal loads the entries not done in the file of path, and
+//rewrites the file with them only
+func OpenWriteJournal(path string) (*WriteJournal, error) {
+	j := new(WriteJournal)
+	j.path = path
+	j.nextId = 1
+	j.entries = make(map[int64]*JournalEntry)
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *WriteJournal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec journalRecord
+		//the last line may be cut by a crash, its entry is not executed
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			golog.Warn("WriteJournal", "load", "invalid record", 0,
+				"path", j.path, "record", scanner.Text())
+			continue
+		}
+		if j.nextId <= rec.Id {
+			j.nextId = rec.Id + 1
+		}
+		e := j.entries[rec.Id]
+		switch {
+		case len(rec.Sqls) != 0:
+			e = &JournalEntry{
+				Id:        rec.Id,
+				Time:      time.Unix(rec.Time, 0),
+				DB:        rec.DB,
+				Charset:   rec.Charset,
+				Collation: rec.Collation,
+			}
+			for i, sql := range rec.Sqls {
+				e.Sqls = append(e.Sqls, JournalSql{Node: rec.Nodes[i], Sql: sql})
+			}
+			j.entries[rec.Id] = e
+		case e == nil:
+		case rec.Done:
+			delete(j.entries, rec.Id)
+		default:
+			for _, i := range rec.Acks {
+				if i < len(e.Sqls) {
+					e.Sqls[i].Acked = true
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+//write the entries not done into a new file, which replaces the old one
+func (j *WriteJournal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range j.sortedEntries() {
+		if err = writeJournalRecord(w, e.record()); err != nil {
+			break
+		}
+		if acks := e.acks(); len(acks) != 0 {
+			if err = writeJournalRecord(w, journalRecord{Id: e.Id, Acks: acks}); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func writeJournalRecord(w *bufio.Writer, rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+//append rec to the journal file and sync it, with the lock held
+func (j *WriteJournal) append(rec journalRecord) error {
+	w := bufio.NewWriter(j.f)
+	if err := writeJournalRecord(w, rec); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (e *JournalEntry) record() journalRecord {
+	rec := journalRecord{
+		Id:        e.Id,
+		Time:      e.Time.Unix(),
+		DB:        e.DB,
+		Charset:   e.Charset,
+		Collation: e.Collation,
+	}
+	for _, s := range e.Sqls {
+		rec.Nodes = append(rec.Nodes, s.Node)
+		rec.Sqls = append(rec.Sqls, s.Sql)
+	}
+	return rec
+}
+
+func (e *JournalEntry) acks() []int {
+	var acks []int
+	for i, s := range e.Sqls {
+		if s.Acked {
+			acks = append(acks, i)
+		}
+	}
+	return acks
+}
+
+type journalEntryList []*JournalEntry
+
+func (l journalEntryList) Len() int           { return len(l) }
+func (l journalEntryList) Less(i, j int) bool { return l[i].Id < l[j].Id }
+func (l journalEntryList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+func (j *WriteJournal) sortedEntries() []*JournalEntry {
+	entries := make([]*JournalEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	sort.Sort(journalEntryList(entries))
+	return entries
+}
+
+//Begin journals the sqls of each node before they are executed, the
+//entry is running until Ack
+func (j *WriteJournal) Begin(db string, charset string, collation mysql.CollationId,
+	sqls map[string][]string) (*JournalEntry, error) {
+	e := &JournalEntry{
+		Time:      time.Now(),
+		DB:        db,
+		Charset:   charset,
+		Collation: collation,
+		running:   true,
+	}
+	for _, nodeName := range sortedNodeNames(sqls) {
+		for _, sql := range sqls[nodeName] {
+			e.Sqls = append(e.Sqls, JournalSql{Node: nodeName, Sql: sql})
+		}
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	e.Id = j.nextId
+	if err := j.append(e.record()); err != nil {
+		return nil, err
+	}
+	j.nextId++
+	j.entries[e.Id] = e
+	return e, nil
+}
+
+//Ack marks the sqls of e executed, errs are the errors of the sqls in
+//order, nil for the ones succeeded. The entry is done once all its sqls
+//are acked.
+func (j *WriteJournal) Ack(e *JournalEntry, errs []error) error {
+	j.Lock()
+	defer j.Unlock()
+	e.running = false
+	var acks []int
+	for i := range e.Sqls {
+		if e.Sqls[i].Acked || len(errs) <= i {
+			continue
+		}
+		if errs[i] != nil {
+			e.Sqls[i].Err = errs[i].Error()
+			continue
+		}
+		e.Sqls[i].Acked = true
+		e.Sqls[i].Err = ""
+		acks = append(acks, i)
+	}
+	if len(e.acks()) == len(e.Sqls) {
+		return j.done(e.Id)
+	}
+	if len(acks) == 0 {
+		return nil
+	}
+	return j.append(journalRecord{Id: e.Id, Acks: acks})
+}
+
+//Discard drops the entry of id without executing the sqls not acked
+func (j *WriteJournal) Discard(id int64) error {
+	j.Lock()
+	defer j.Unlock()
+	e, ok := j.entries[id]
+	if !ok || e.running {
+		return fmt.Errorf("no pending journal entry %d", id)
+	}
+	return j.done(id)
+}
+
+//with the lock held, the file is truncated once no entry is pending
+func (j *WriteJournal) done(id int64) error {
+	delete(j.entries, id)
+	if len(j.entries) == 0 {
+		return j.f.Truncate(0)
+	}
+	return j.append(journalRecord{Id: id, Done: true})
+}
+
+//Pending returns the copies of the entries not done and not running, in
+//the order of id
+func (j *WriteJournal) Pending() []JournalEntry {
+	j.Lock()
+	defer j.Unlock()
+	var entries []JournalEntry
+	for _, e := range j.sortedEntries() {
+		if e.running {
+			continue
+		}
+		c := *e
+		c.Sqls = append([]JournalSql(nil), e.Sqls...)
+		entries = append(entries, c)
+	}
+	return entries
+}
+
+//acquire the entry of id for a retry, nil if it is done or running
+func (j *WriteJournal) acquire(id int64) *JournalEntry {
+	j.Lock()
+	defer j.Unlock()
+	e, ok := j.entries[id]
+	if !ok || e.running {
+		return nil
+	}
+	e.running = true
+	return e
+}
+
+func (j *WriteJournal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	return j.f.Close()
+}
+
+//the multi-node writes out of transaction are journaled, the prepared
+//statements are not, since their args can not be replayed as the same
+//types
+func (c *ClientConn) beginJournal(plan *router.Plan, args []interface{}) (*JournalEntry, error) {
+	j := c.proxy.journal
+	if j == nil || c.isInTransaction() || len(args) != 0 || len(plan.RewrittenSqls) < 2 {
+		return nil, nil
+	}
+	e, err := j.Begin(c.db, c.charset, c.collation, plan.RewrittenSqls)
+	if err != nil {
+		golog.Error("ClientConn", "beginJournal", err.Error(), c.connectionId)
+	}
+	return e, err
+}
+
+//ack the sqls of e by the results rs, which are nil for the sqls failed.
+//The entry is dropped if no sql is executed.
+func (c *ClientConn) ackJournal(e *JournalEntry, rs []*mysql.Result, err error) {
+	if e == nil {
+		return
+	}
+	errs := make([]error, len(e.Sqls))
+	for i := range errs {
+		if rs == nil {
+			continue
+		}
+		if i < len(rs) && rs[i] == nil {
+			errs[i] = err
+		}
+	}
+	if err = c.proxy.journal.Ack(e, errs); err != nil {
+		golog.Error("ClientConn", "ackJournal", err.Error(), c.connectionId, "id", e.Id)
+	}
+}
+
+//RetryJournal executes the sqls not acked of the entry of id on the
+//masters again, or of all the entries if id is 0. It returns the number
+//of entries retried and the entries still pending.
+func (s *Server) RetryJournal(id int64) (int, int, error) {
+	if s.journal == nil {
+		return 0, 0, fmt.Errorf("write_journal is not set")
+	}
+	var ids []int64
+	for _, e := range s.journal.Pending() {
+		if id == 0 || e.Id == id {
+			ids = append(ids, e.Id)
+		}
+	}
+	if id != 0 && len(ids) == 0 {
+		return 0, 0, fmt.Errorf("no pending journal entry %d", id)
+	}
+
+	retried := 0
+	for _, id := range ids {
+		e := s.journal.acquire(id)
+		if e == nil {
+			continue
+		}
+		retried++
+		errs := make([]error, len(e.Sqls))
+		for i, sql := range e.Sqls {
+			if !sql.Acked {
+				errs[i] = s.retryJournalSql(e, sql)
+			}
+		}
+		if err := s.journal.Ack(e, errs); err != nil {
+			return retried, 0, err
+		}
+	}
+	return retried, len(s.journal.Pending()), nil
+}
+
+func (s *Server) retryJournalSql(e *JournalEntry, sql JournalSql) error {
+	n := s.GetNode(sql.Node)
+	if n == nil {
+		return fmt.Errorf("node %s is not found", sql.Node)
+	}
+	co, err := n.GetMasterConn()
+	if err != nil {
+		return err
+	}
+	defer co.Close()
+	if err = co.UseDB(e.DB); err != nil {
+		return err
+	}
+	if err = co.SetCharset(e.Charset, e.Collation); err != nil {
+		return err
+	}
+	_, err = co.Execute(router.RewriteSchemas(sql.Sql, co.SchemaRewrite()))
+	return err
+}
+
+//the entries left by the last run are retried once on start
+func (s *Server) recoverJournal() {
+	if len(s.journal.Pending()) == 0 {
+		return
+	}
+	retried, pending, err := s.RetryJournal(0)
+	if err != nil {
+		golog.Error("Server", "recoverJournal", err.Error(), 0)
+		return
+	}
+	if pending != 0 {
+		golog.Warn("Server", "recoverJournal", "the journal entries are pending", 0,
+			"retried", retried, "pending", pending)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 The kingshard Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flike/kingshard/mysql"
+	"github.com/flike/kingshard/mysql/mysqltest"
+)
+
+//the entries not done are loaded again after the journal is reopened
+func TestWriteJournalReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "write.journal")
+
+	j, err := OpenWriteJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqls := map[string][]string{
+		"node1": {"delete from t_0000", "delete from t_0002"},
+		"node2": {"delete from t_0001"},
+	}
+	e1, err := j.Begin("kingshard", "utf8", 0, sqls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := j.Begin("kingshard", "utf8", 0, sqls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Pending()) != 0 {
+		t.Fatal("the running entries are not pending")
+	}
+	if err = j.Ack(e1, []error{nil, fmt.Errorf("lost"), nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Ack(e2, make([]error, 3)); err != nil {
+		t.Fatal(err)
+	}
+	//a record cut by a crash is skipped
+	j.f.Write([]byte(`{"id":3,"sqls":["dele`))
+	j.Close()
+
+	if j, err = OpenWriteJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	pending := j.Pending()
+	if len(pending) != 1 || pending[0].Id != e1.Id || pending[0].DB != "kingshard" {
+		t.Fatal(pending)
+	}
+	acked := []bool{true, false, true}
+	for i, sql := range pending[0].Sqls {
+		if sql.Acked != acked[i] {
+			t.Fatal(pending[0].Sqls)
+		}
+	}
+	if pending[0].Sqls[1].Node != "node1" || pending[0].Sqls[1].Sql != "delete from t_0002" {
+		t.Fatal(pending[0].Sqls)
+	}
+	if e, err := j.Begin("kingshard", "utf8", 0, sqls); err != nil || e.Id <= e2.Id {
+		t.Fatal(e, err)
+	}
+
+	if err = j.Discard(e1.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Discard(e1.Id); err == nil {
+		t.Fatal("the entry discarded is not pending")
+	}
+}
+
+//the sql failed of a multi-node write is kept in the journal and
+//executed again by the retry
+func TestWriteJournalRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, backends, c, close := newFakeProxy(t, fakeBackendConfig+`
+write_journal : `+filepath.Join(dir, "write.journal")+`
+`)
+	defer close()
+	backends[1].Handle(`^delete from t_0001`, &mysqltest.Response{
+		Err: mysql.NewError(mysql.ER_LOCK_WAIT_TIMEOUT, "Lock wait timeout exceeded"),
+	})
+
+	if _, err = c.Execute("delete from t where a = 1"); err == nil {
+		t.Fatal("the delete on node2 must fail")
+	}
+	pending := s.journal.Pending()
+	if len(pending) != 1 || len(pending[0].Sqls) != 2 {
+		t.Fatal(pending)
+	}
+	sql := pending[0].Sqls[1]
+	if !pending[0].Sqls[0].Acked || sql.Acked || sql.Node != "node2" ||
+		sql.Sql != "delete from t_0001 where a = 1" || len(sql.Err) == 0 {
+		t.Fatal(pending[0].Sqls)
+	}
+
+	//the writes in transaction are not journaled
+	if _, err = c.Execute("begin"); err != nil {
+		t.Fatal(err)
+	}
+	c.Execute("delete from t where a = 2")
+	if _, err = c.Execute("rollback"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.journal.Pending()); n != 1 {
+		t.Fatal(n)
+	}
+
+	retried, left, err := s.RetryJournal(0)
+	if err != nil || retried != 1 || left != 1 {
+		t.Fatal(retried, left, err)
+	}
+	backends[1].Handle(`^delete from t_0001`, &mysqltest.Response{AffectedRows: 1})
+	backends[0].ClearQueries()
+	retried, left, err = s.RetryJournal(pending[0].Id)
+	if err != nil || retried != 1 || left != 0 {
+		t.Fatal(retried, left, err)
+	}
+	if hasQuery(backends[0].Queries(), "delete") {
+		t.Fatal("the sql acked is not retried", backends[0].Queries())
+	}
+}
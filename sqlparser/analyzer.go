@@ -95,7 +95,7 @@ func IsColName(node ValExpr) bool {
 // NULL is not considered to be a value.
 func IsValue(node ValExpr) bool {
 	switch node.(type) {
-	case StrVal, NumVal, ValArg:
+	case StrVal, NumVal, ValArg, *CharsetStrVal:
 		return true
 	}
 	return false
@@ -146,6 +146,8 @@ func AsInterface(node ValExpr) (interface{}, error) {
 		return string(node), nil
 	case StrVal:
 		return sqltypes.MakeString(node), nil
+	case *CharsetStrVal:
+		return sqltypes.MakeString(node.Val), nil
 	case NumVal:
 		n, err := sqltypes.BuildNumeric(string(node))
 		if err != nil {
@@ -69,6 +69,7 @@ type Statement interface {
 }
 
 func (*Union) IStatement()  {}
+func (*With) IStatement()   {}
 func (*Select) IStatement() {}
 func (*Insert) IStatement() {}
 func (*Update) IStatement() {}
@@ -86,6 +87,7 @@ type SelectStatement interface {
 
 func (*Select) ISelectStatement() {}
 func (*Union) ISelectStatement()  {}
+func (*With) ISelectStatement()   {}
 
 // Select represents a SELECT statement.
 type Select struct {
@@ -139,6 +141,40 @@ func (node *Union) Format(buf *TrackedBuffer) {
 	buf.Fprintf("%v %s %v", node.Left, node.Type, node.Right)
 }
 
+// With represents a SELECT statement with common table expressions.
+type With struct {
+	Recursive string
+	Ctes      []*CommonTableExpr
+	Select    SelectStatement
+}
+
+// With.Recursive
+const (
+	AST_RECURSIVE = "recursive "
+)
+
+func (node *With) Format(buf *TrackedBuffer) {
+	buf.Fprintf("with %s", node.Recursive)
+	var prefix string
+	for _, cte := range node.Ctes {
+		buf.Fprintf("%s%v", prefix, cte)
+		prefix = ", "
+	}
+	buf.Fprintf(" %v", node.Select)
+}
+
+// CommonTableExpr represents a common table expression in WITH.
+type CommonTableExpr struct {
+	Name     []byte
+	Columns  Columns
+	Subquery *Subquery
+}
+
+func (node *CommonTableExpr) Format(buf *TrackedBuffer) {
+	escape(buf, node.Name)
+	buf.Fprintf("%v as %v", node.Columns, node.Subquery)
+}
+
 // Insert represents an INSERT statement.
 type Insert struct {
 	Comments Comments
@@ -150,8 +186,11 @@ type Insert struct {
 }
 
 func (node *Insert) Format(buf *TrackedBuffer) {
-	buf.Fprintf("insert %v%s into %v%v %v%v",
-		node.Comments, node.Ignore,
+	buf.Fprintf("insert %v", node.Comments)
+	if node.Ignore != "" {
+		buf.Fprintf("%s ", node.Ignore)
+	}
+	buf.Fprintf("into %v%v %v%v",
 		node.Table, node.Columns, node.Rows, node.OnDup)
 }
 
@@ -163,6 +202,7 @@ type InsertRows interface {
 
 func (*Select) IInsertRows() {}
 func (*Union) IInsertRows()  {}
+func (*With) IInsertRows()   {}
 func (Values) IInsertRows()  {}
 
 // Update represents an UPDATE statement.
@@ -456,25 +496,28 @@ type Expr interface {
 	SQLNode
 }
 
-func (*AndExpr) IExpr()        {}
-func (*OrExpr) IExpr()         {}
-func (*NotExpr) IExpr()        {}
-func (*ParenBoolExpr) IExpr()  {}
-func (*ComparisonExpr) IExpr() {}
-func (*RangeCond) IExpr()      {}
-func (*NullCheck) IExpr()      {}
-func (*ExistsExpr) IExpr()     {}
-func (StrVal) IExpr()          {}
-func (NumVal) IExpr()          {}
-func (ValArg) IExpr()          {}
-func (*NullVal) IExpr()        {}
-func (*ColName) IExpr()        {}
-func (ValTuple) IExpr()        {}
-func (*Subquery) IExpr()       {}
-func (*BinaryExpr) IExpr()     {}
-func (*UnaryExpr) IExpr()      {}
-func (*FuncExpr) IExpr()       {}
-func (*CaseExpr) IExpr()       {}
+func (*AndExpr) IExpr()         {}
+func (*OrExpr) IExpr()          {}
+func (*NotExpr) IExpr()         {}
+func (*ParenBoolExpr) IExpr()   {}
+func (*ComparisonExpr) IExpr()  {}
+func (*RangeCond) IExpr()       {}
+func (*NullCheck) IExpr()       {}
+func (*ExistsExpr) IExpr()      {}
+func (StrVal) IExpr()           {}
+func (*CharsetStrVal) IExpr()   {}
+func (NumVal) IExpr()           {}
+func (ValArg) IExpr()           {}
+func (*NullVal) IExpr()         {}
+func (*ColName) IExpr()         {}
+func (ValTuple) IExpr()         {}
+func (*Subquery) IExpr()        {}
+func (*BinaryExpr) IExpr()      {}
+func (*UnaryExpr) IExpr()       {}
+func (*FuncExpr) IExpr()        {}
+func (*CaseExpr) IExpr()        {}
+func (*JsonExtractExpr) IExpr() {}
+func (*WindowFuncExpr) IExpr()  {}
 
 // BoolExpr represents a boolean expression.
 type BoolExpr interface {
@@ -600,17 +643,20 @@ type ValExpr interface {
 	Expr
 }
 
-func (StrVal) IValExpr()      {}
-func (NumVal) IValExpr()      {}
-func (ValArg) IValExpr()      {}
-func (*NullVal) IValExpr()    {}
-func (*ColName) IValExpr()    {}
-func (ValTuple) IValExpr()    {}
-func (*Subquery) IValExpr()   {}
-func (*BinaryExpr) IValExpr() {}
-func (*UnaryExpr) IValExpr()  {}
-func (*FuncExpr) IValExpr()   {}
-func (*CaseExpr) IValExpr()   {}
+func (StrVal) IValExpr()           {}
+func (*CharsetStrVal) IValExpr()   {}
+func (NumVal) IValExpr()           {}
+func (ValArg) IValExpr()           {}
+func (*NullVal) IValExpr()         {}
+func (*ColName) IValExpr()         {}
+func (ValTuple) IValExpr()         {}
+func (*Subquery) IValExpr()        {}
+func (*BinaryExpr) IValExpr()      {}
+func (*UnaryExpr) IValExpr()       {}
+func (*FuncExpr) IValExpr()        {}
+func (*CaseExpr) IValExpr()        {}
+func (*JsonExtractExpr) IValExpr() {}
+func (*WindowFuncExpr) IValExpr()  {}
 
 // StrVal represents a string value.
 type StrVal []byte
@@ -620,7 +666,19 @@ func (node StrVal) Format(buf *TrackedBuffer) {
 	s.EncodeSql(buf)
 }
 
-// NumVal represents a number.
+// CharsetStrVal represents a string with charset introducer,
+// like _binary'abc' or _utf8mb4'abc'.
+type CharsetStrVal struct {
+	Charset []byte
+	Val     StrVal
+}
+
+func (node *CharsetStrVal) Format(buf *TrackedBuffer) {
+	buf.Fprintf("%s%v", node.Charset, node.Val)
+}
+
+// NumVal represents a number, including the hexadecimal
+// literal like 0x1F, X'1F' and the bit literal like 0b101, b'101'.
 type NumVal []byte
 
 func (node NumVal) Format(buf *TrackedBuffer) {
@@ -753,6 +811,93 @@ func (node *FuncExpr) Format(buf *TrackedBuffer) {
 	buf.Fprintf("%s(%s%v)", node.Name, distinct, node.Exprs)
 }
 
+// JsonExtractExpr represents a column->path or column->>path expression.
+type JsonExtractExpr struct {
+	Operator string
+	Column   *ColName
+	Path     StrVal
+}
+
+// JsonExtractExpr.Operator
+const (
+	AST_JSON_EXTRACT         = "->"
+	AST_JSON_UNQUOTE_EXTRACT = "->>"
+)
+
+func (node *JsonExtractExpr) Format(buf *TrackedBuffer) {
+	buf.Fprintf("%v%s%v", node.Column, node.Operator, node.Path)
+}
+
+// WindowFuncExpr represents a window function call.
+type WindowFuncExpr struct {
+	Func *FuncExpr
+	Over *WindowSpec
+}
+
+func (node *WindowFuncExpr) Format(buf *TrackedBuffer) {
+	buf.Fprintf("%v over (%v)", node.Func, node.Over)
+}
+
+// WindowSpec represents the window of a window function.
+type WindowSpec struct {
+	PartitionBy ValExprs
+	OrderBy     OrderBy
+	Frame       *WindowFrame
+}
+
+func (node *WindowSpec) Format(buf *TrackedBuffer) {
+	var prefix string
+	if len(node.PartitionBy) != 0 {
+		buf.Fprintf("partition by %v", node.PartitionBy)
+		prefix = " "
+	}
+	if len(node.OrderBy) != 0 {
+		buf.Fprintf("%sorder by ", prefix)
+		for i, n := range node.OrderBy {
+			if i != 0 {
+				buf.Fprintf(", ")
+			}
+			buf.Fprintf("%v", n)
+		}
+		prefix = " "
+	}
+	if node.Frame != nil {
+		buf.Fprintf("%s%v", prefix, node.Frame)
+	}
+}
+
+// WindowFrame represents the frame clause of a window.
+// End is nil if the frame has only the start bound.
+type WindowFrame struct {
+	Unit       string
+	Start, End *FrameBound
+}
+
+// WindowFrame.Unit
+const (
+	AST_ROWS  = "rows"
+	AST_RANGE = "range"
+)
+
+func (node *WindowFrame) Format(buf *TrackedBuffer) {
+	if node.End == nil {
+		buf.Fprintf("%s %v", node.Unit, node.Start)
+		return
+	}
+	buf.Fprintf("%s between %v and %v", node.Unit, node.Start, node.End)
+}
+
+// FrameBound represents a bound of window frame, such as
+// unbounded preceding, current row or 1 following.
+type FrameBound struct {
+	Expr ValExpr
+	Type string
+}
+
+func (node *FrameBound) Format(buf *TrackedBuffer) {
+	buf.Fprintf("%v %s", node.Expr, node.Type)
+}
+
 // CaseExpr represents a CASE expression.
 type CaseExpr struct {
 	Expr  ValExpr
@@ -1021,11 +1166,11 @@ type Truncate struct {
 }
 
 const (
-	AST_TABLE = " table "
+	AST_TABLE = "table "
 )
 
 func (*Truncate) IStatement() {}
 
 func (node *Truncate) Format(buf *TrackedBuffer) {
-	buf.Fprintf("truncate%v%s%v", node.Comments, node.TableOpt, node.Table)
+	buf.Fprintf("truncate %v%s%v", node.Comments, node.TableOpt, node.Table)
 }
@@ -1,9 +1,12 @@
+// Code generated by goyacc -o sqlparser/sql.go ./sqlparser/sql.y. DO NOT EDIT.
+
 //line ./sqlparser/sql.y:20
 package sqlparser
 
 import __yyfmt__ "fmt"
 
 //line ./sqlparser/sql.y:20
+
 import "bytes"
 
 func SetParseTree(yylex interface{}, stmt Statement) {
@@ -23,9 +26,13 @@ var (
 	MODE         = []byte("mode")
 	IF_BYTES     = []byte("if")
 	VALUES_BYTES = []byte("values")
+	CURRENT      = []byte("current")
+	ROW          = []byte("row")
+	PRECEDING    = []byte("preceding")
+	FOLLOWING    = []byte("following")
 )
 
-//line ./sqlparser/sql.y:45
+//line ./sqlparser/sql.y:49
 type yySymType struct {
 	yys         int
 	empty       struct{}
@@ -60,6 +67,12 @@ type yySymType struct {
 	insRows     InsertRows
 	updateExprs UpdateExprs
 	updateExpr  *UpdateExpr
+	funcExpr    *FuncExpr
+	windowSpec  *WindowSpec
+	windowFrame *WindowFrame
+	frameBound  *FrameBound
+	ctes        []*CommonTableExpr
+	cte         *CommonTableExpr
 }
 
 const LEX_ERROR = 57346
@@ -94,62 +107,71 @@ const STRING = 57374
 const NUMBER = 57375
 const VALUE_ARG = 57376
 const COMMENT = 57377
-const UNION = 57378
-const MINUS = 57379
-const EXCEPT = 57380
-const INTERSECT = 57381
-const JOIN = 57382
-const STRAIGHT_JOIN = 57383
-const LEFT = 57384
-const RIGHT = 57385
-const INNER = 57386
-const OUTER = 57387
-const CROSS = 57388
-const NATURAL = 57389
-const USE = 57390
-const FORCE = 57391
-const ON = 57392
-const OR = 57393
-const AND = 57394
-const NOT = 57395
-const BETWEEN = 57396
-const CASE = 57397
-const WHEN = 57398
-const THEN = 57399
-const ELSE = 57400
-const LE = 57401
-const GE = 57402
-const NE = 57403
-const NULL_SAFE_EQUAL = 57404
-const IS = 57405
-const LIKE = 57406
-const IN = 57407
-const UNARY = 57408
-const END = 57409
-const BEGIN = 57410
-const START = 57411
-const TRANSACTION = 57412
-const COMMIT = 57413
-const ROLLBACK = 57414
-const NAMES = 57415
-const REPLACE = 57416
-const ADMIN = 57417
-const HELP = 57418
-const OFFSET = 57419
-const COLLATE = 57420
-const CREATE = 57421
-const ALTER = 57422
-const DROP = 57423
-const RENAME = 57424
-const TABLE = 57425
-const INDEX = 57426
-const VIEW = 57427
-const TO = 57428
-const IGNORE = 57429
-const IF = 57430
-const UNIQUE = 57431
-const USING = 57432
-const TRUNCATE = 57433
+const INTRODUCER = 57378
+const WITH = 57379
+const UNION = 57380
+const MINUS = 57381
+const EXCEPT = 57382
+const INTERSECT = 57383
+const JOIN = 57384
+const STRAIGHT_JOIN = 57385
+const LEFT = 57386
+const RIGHT = 57387
+const INNER = 57388
+const OUTER = 57389
+const CROSS = 57390
+const NATURAL = 57391
+const USE = 57392
+const FORCE = 57393
+const ON = 57394
+const OR = 57395
+const AND = 57396
+const NOT = 57397
+const BETWEEN = 57398
+const CASE = 57399
+const WHEN = 57400
+const THEN = 57401
+const ELSE = 57402
+const LE = 57403
+const GE = 57404
+const NE = 57405
+const NULL_SAFE_EQUAL = 57406
+const IS = 57407
+const LIKE = 57408
+const IN = 57409
+const JSON_EXTRACT_OP = 57410
+const JSON_UNQUOTE_EXTRACT_OP = 57411
+const UNARY = 57412
+const END = 57413
+const BEGIN = 57414
+const START = 57415
+const TRANSACTION = 57416
+const COMMIT = 57417
+const ROLLBACK = 57418
+const NAMES = 57419
+const REPLACE = 57420
+const ADMIN = 57421
+const HELP = 57422
+const OFFSET = 57423
+const COLLATE = 57424
+const CREATE = 57425
+const ALTER = 57426
+const DROP = 57427
+const RENAME = 57428
+const TABLE = 57429
+const INDEX = 57430
+const VIEW = 57431
+const TO = 57432
+const IGNORE = 57433
+const IF = 57434
+const UNIQUE = 57435
+const USING = 57436
+const TRUNCATE = 57437
+const RECURSIVE = 57438
+const OVER = 57439
+const PARTITION = 57440
+const ROWS = 57441
+const RANGE = 57442
 
 var yyToknames = [...]string{
 	"$end",
@@ -187,8 +209,10 @@ var yyToknames = [...]string{
 	"NUMBER",
 	"VALUE_ARG",
 	"COMMENT",
+	"INTRODUCER",
 	"'('",
 	"'~'",
+	"WITH",
 	"UNION",
 	"MINUS",
 	"EXCEPT",
@@ -231,6 +255,8 @@ var yyToknames = [...]string{
 	"'/'",
 	"'%'",
 	"'^'",
+	"JSON_EXTRACT_OP",
+	"JSON_UNQUOTE_EXTRACT_OP",
 	"'.'",
 	"UNARY",
 	"END",
@@ -258,8 +284,14 @@ var yyToknames = [...]string{
 	"UNIQUE",
 	"USING",
 	"TRUNCATE",
+	"RECURSIVE",
+	"OVER",
+	"PARTITION",
+	"ROWS",
+	"RANGE",
 	"')'",
 }
+
 var yyStatenames = [...]string{}
 
 const yyEofCode = 1
@@ -267,307 +299,333 @@ const yyErrCode = 2
 const yyInitialStackSize = 16
 
 //line yacctab:1
-var yyExca = [...]int{
+var yyExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
 }
 
-const yyNprod = 218
 const yyPrivate = 57344
 
-var yyTokenNames []string
-var yyStates []string
-
-const yyLast = 642
-
-var yyAct = [...]int{
-
-	111, 108, 313, 349, 187, 382, 74, 269, 343, 264,
-	119, 102, 228, 185, 188, 3, 148, 109, 139, 199,
-	391, 92, 97, 60, 210, 76, 114, 118, 391, 88,
-	124, 162, 161, 391, 98, 63, 81, 79, 115, 116,
-	117, 156, 106, 122, 53, 156, 156, 78, 77, 257,
-	83, 66, 52, 85, 53, 277, 226, 89, 37, 38,
-	39, 40, 105, 142, 125, 55, 56, 57, 72, 285,
-	286, 287, 288, 289, 360, 290, 291, 62, 359, 358,
-	120, 121, 103, 138, 255, 393, 47, 96, 49, 132,
-	84, 146, 50, 392, 78, 152, 82, 129, 390, 331,
-	333, 158, 79, 54, 141, 296, 339, 150, 58, 123,
-	305, 303, 340, 160, 256, 147, 135, 184, 186, 154,
-	189, 225, 94, 171, 190, 193, 216, 258, 396, 78,
-	77, 78, 77, 335, 64, 198, 206, 61, 134, 196,
-	242, 214, 137, 265, 217, 308, 265, 203, 204, 87,
-	332, 197, 161, 241, 240, 207, 201, 245, 355, 75,
-	234, 206, 344, 364, 130, 220, 232, 224, 273, 221,
-	103, 162, 161, 236, 237, 344, 145, 238, 357, 235,
-	243, 244, 239, 247, 248, 249, 250, 251, 252, 253,
-	254, 233, 246, 170, 169, 172, 173, 174, 175, 176,
-	171, 174, 175, 176, 171, 103, 103, 90, 213, 215,
-	212, 274, 271, 365, 260, 262, 272, 325, 268, 266,
-	162, 161, 326, 78, 77, 356, 342, 78, 281, 279,
-	275, 329, 323, 328, 51, 327, 150, 324, 278, 130,
-	257, 232, 366, 295, 280, 376, 155, 282, 261, 375,
-	114, 118, 231, 200, 124, 298, 299, 230, 297, 200,
-	374, 101, 115, 116, 117, 267, 106, 122, 78, 77,
-	126, 302, 93, 307, 311, 103, 312, 309, 71, 156,
-	222, 150, 133, 310, 304, 283, 105, 93, 125, 368,
-	369, 130, 194, 192, 232, 232, 321, 322, 318, 37,
-	38, 39, 40, 64, 120, 121, 99, 169, 172, 173,
-	174, 175, 176, 171, 338, 346, 191, 93, 294, 159,
-	345, 79, 341, 19, 20, 21, 22, 19, 347, 350,
-	293, 64, 336, 123, 334, 351, 388, 259, 317, 170,
-	169, 172, 173, 174, 175, 176, 171, 23, 316, 301,
-	389, 361, 219, 231, 218, 62, 362, 153, 230, 372,
-	370, 143, 41, 140, 136, 371, 86, 373, 203, 34,
-	380, 128, 377, 381, 363, 383, 383, 383, 378, 379,
-	350, 384, 385, 43, 44, 45, 46, 78, 77, 127,
-	19, 91, 397, 394, 208, 59, 144, 398, 65, 399,
-	69, 28, 29, 67, 30, 31, 314, 32, 33, 149,
-	353, 354, 24, 25, 27, 26, 114, 118, 315, 270,
-	124, 320, 200, 19, 35, 73, 395, 101, 115, 116,
-	117, 19, 106, 122, 170, 169, 172, 173, 174, 175,
-	176, 171, 386, 42, 18, 17, 114, 118, 16, 15,
-	124, 14, 105, 13, 125, 202, 12, 79, 115, 116,
-	117, 95, 106, 122, 209, 118, 48, 276, 124, 337,
-	120, 121, 99, 211, 80, 79, 115, 116, 117, 19,
-	133, 122, 105, 151, 125, 387, 170, 169, 172, 173,
-	174, 175, 176, 171, 367, 118, 348, 205, 124, 123,
-	120, 121, 125, 352, 319, 79, 115, 116, 117, 306,
-	133, 122, 172, 173, 174, 175, 176, 171, 120, 121,
-	195, 263, 113, 110, 112, 223, 107, 118, 163, 123,
-	124, 104, 125, 330, 131, 229, 284, 79, 115, 116,
-	117, 118, 133, 122, 124, 227, 100, 123, 120, 121,
-	300, 79, 115, 116, 117, 292, 133, 122, 157, 285,
-	286, 287, 288, 289, 125, 290, 291, 170, 169, 172,
-	173, 174, 175, 176, 171, 68, 36, 123, 125, 70,
-	120, 121, 170, 169, 172, 173, 174, 175, 176, 171,
-	11, 10, 9, 8, 120, 121, 7, 6, 5, 4,
-	2, 1, 0, 0, 0, 0, 0, 0, 0, 123,
-	0, 0, 0, 0, 0, 0, 0, 0, 165, 167,
-	0, 0, 0, 123, 177, 178, 179, 180, 181, 182,
-	183, 168, 166, 164, 170, 169, 172, 173, 174, 175,
-	176, 171,
+const yyLast = 763
+
+var yyAct = [...]int16{
+	197, 125, 114, 410, 338, 377, 199, 80, 418, 291,
+	371, 285, 160, 126, 82, 246, 103, 200, 3, 151,
+	217, 75, 108, 104, 429, 63, 174, 173, 115, 364,
+	38, 39, 40, 41, 327, 98, 205, 66, 307, 308,
+	309, 310, 311, 429, 312, 313, 429, 392, 393, 168,
+	168, 84, 83, 168, 89, 69, 275, 91, 244, 45,
+	50, 95, 52, 228, 124, 56, 53, 133, 94, 87,
+	299, 143, 109, 78, 85, 120, 122, 123, 154, 121,
+	145, 129, 144, 356, 358, 55, 273, 56, 388, 387,
+	150, 386, 136, 58, 59, 60, 431, 88, 158, 102,
+	90, 84, 164, 131, 61, 141, 276, 65, 170, 360,
+	57, 153, 138, 201, 85, 430, 162, 204, 428, 127,
+	128, 367, 330, 159, 368, 328, 318, 166, 274, 172,
+	243, 147, 212, 206, 196, 198, 357, 234, 186, 187,
+	188, 183, 84, 83, 84, 83, 221, 222, 216, 224,
+	132, 100, 232, 202, 203, 235, 183, 219, 435, 213,
+	67, 174, 173, 146, 286, 215, 333, 370, 225, 109,
+	286, 64, 263, 252, 224, 81, 256, 93, 238, 261,
+	262, 250, 265, 266, 267, 268, 269, 270, 271, 272,
+	242, 149, 251, 174, 173, 239, 254, 255, 181, 184,
+	185, 186, 187, 188, 183, 257, 432, 253, 383, 264,
+	109, 109, 182, 181, 184, 185, 186, 187, 188, 183,
+	260, 294, 173, 231, 233, 230, 281, 283, 287, 142,
+	296, 42, 372, 259, 258, 220, 295, 290, 96, 157,
+	372, 293, 84, 83, 385, 350, 84, 303, 301, 297,
+	351, 288, 300, 46, 47, 48, 49, 162, 401, 402,
+	320, 321, 250, 384, 317, 62, 304, 354, 68, 124,
+	348, 54, 133, 353, 352, 349, 324, 142, 275, 85,
+	120, 122, 123, 109, 121, 145, 129, 319, 399, 302,
+	289, 84, 83, 409, 240, 408, 332, 336, 99, 329,
+	337, 335, 99, 218, 134, 218, 162, 411, 131, 334,
+	182, 181, 184, 185, 186, 187, 188, 183, 77, 250,
+	250, 346, 347, 363, 127, 128, 307, 308, 309, 310,
+	311, 343, 312, 313, 369, 167, 365, 305, 19, 142,
+	375, 378, 374, 407, 397, 145, 207, 373, 184, 185,
+	186, 187, 188, 183, 19, 132, 38, 39, 40, 41,
+	249, 379, 279, 389, 210, 209, 248, 221, 19, 19,
+	168, 395, 20, 394, 182, 181, 184, 185, 186, 187,
+	188, 183, 99, 221, 278, 135, 277, 161, 20, 405,
+	403, 208, 412, 137, 140, 249, 398, 67, 414, 415,
+	378, 248, 20, 20, 404, 416, 406, 85, 417, 419,
+	419, 419, 412, 361, 423, 422, 316, 420, 421, 67,
+	426, 359, 19, 21, 22, 23, 84, 83, 315, 342,
+	341, 436, 433, 412, 427, 171, 437, 438, 237, 282,
+	439, 119, 124, 236, 65, 133, 24, 67, 396, 76,
+	165, 155, 107, 120, 122, 123, 20, 121, 112, 129,
+	152, 148, 182, 181, 184, 185, 186, 187, 188, 183,
+	35, 362, 92, 413, 139, 97, 323, 226, 156, 111,
+	214, 131, 72, 70, 339, 382, 366, 340, 182, 181,
+	184, 185, 186, 187, 188, 183, 292, 127, 128, 105,
+	381, 345, 322, 218, 29, 30, 79, 31, 32, 434,
+	33, 34, 424, 43, 19, 25, 26, 28, 27, 182,
+	181, 184, 185, 186, 187, 188, 183, 36, 132, 119,
+	124, 391, 390, 133, 326, 325, 117, 280, 74, 44,
+	85, 120, 122, 123, 18, 121, 112, 129, 20, 182,
+	181, 184, 185, 186, 187, 188, 183, 17, 16, 15,
+	14, 119, 124, 13, 12, 133, 101, 111, 227, 131,
+	51, 298, 107, 120, 122, 123, 229, 121, 112, 129,
+	86, 163, 425, 400, 376, 127, 128, 380, 344, 331,
+	211, 284, 118, 119, 124, 116, 19, 133, 130, 111,
+	241, 131, 113, 175, 85, 120, 122, 123, 110, 121,
+	112, 129, 124, 355, 247, 133, 132, 127, 128, 105,
+	306, 245, 85, 120, 122, 123, 106, 121, 145, 129,
+	20, 111, 314, 131, 169, 71, 37, 73, 11, 10,
+	9, 8, 7, 6, 124, 5, 4, 133, 132, 127,
+	128, 131, 2, 1, 85, 120, 122, 123, 0, 121,
+	145, 129, 0, 0, 0, 0, 0, 127, 128, 0,
+	0, 0, 0, 0, 0, 0, 124, 0, 223, 133,
+	132, 0, 0, 131, 0, 0, 85, 120, 122, 123,
+	0, 121, 145, 129, 0, 0, 0, 0, 132, 127,
+	128, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 131, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 177, 179, 0,
+	132, 127, 128, 189, 190, 191, 192, 193, 194, 195,
+	180, 178, 176, 182, 181, 184, 185, 186, 187, 188,
+	183, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 132,
 }
-var yyPact = [...]int{
 
-	318, -1000, -1000, 261, -1000, -1000, -1000, -1000, -1000, -1000,
+var yyPact = [...]int16{
+	417, -1000, -1000, 316, -1000, -1000, -1000, -1000, -1000, -1000,
 	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -12, -48, 5, -33, -1000, 23,
-	-1000, -1000, -1000, 46, 272, -1000, 418, 386, -1000, -1000,
-	-1000, 382, -1000, -58, 324, 416, 71, -67, -3, 272,
-	-1000, -8, 272, -1000, 335, -74, 272, -74, -1000, 366,
-	281, -1000, 42, -1000, -1000, -11, -1000, -1000, 396, -1000,
-	235, 364, 342, 324, 197, 506, -1000, 76, -1000, 36,
-	333, 86, 272, -1000, 332, -1000, -38, 330, 376, 123,
-	272, 324, 385, 290, 326, 324, -1000, 237, -1000, -1000,
-	300, 33, 117, 562, -1000, 6, 426, -1000, -1000, -1000,
-	520, 280, 257, -1000, 256, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -1000, 520, -1000, 324, 290, 412,
-	290, -1000, 362, 474, 444, 272, -1000, 374, -81, -1000,
-	113, -1000, 323, -1000, -1000, 321, -1000, 251, -1000, 246,
-	261, 14, -1000, -1000, -1000, 221, 396, -1000, -1000, 272,
-	103, 6, 6, 520, 246, 83, 520, 520, 136, 520,
-	520, 520, 520, 520, 520, 520, 520, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -1000, 562, -23, 7, 20, 562,
-	-1000, 230, 396, -1000, 418, 87, 510, 236, 249, 406,
-	6, -1000, 520, 510, 510, -1000, -1000, -1000, -1000, 115,
-	272, -1000, -46, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, 385, 290, 202, -1000, -1000, 290, 243, 516, 299,
-	322, 25, -1000, -1000, -1000, -1000, -1000, 97, 510, -1000,
-	246, 520, 520, 510, 495, -1000, 328, 438, 234, -1000,
-	125, 125, 44, 44, 44, -1000, -1000, 520, -1000, -1000,
-	4, 396, 3, 84, -1000, 6, 385, 290, 406, 391,
-	404, 117, 510, 317, -1000, -1000, 307, -1000, -1000, 197,
-	246, -1000, 410, 221, 221, -1000, -1000, 189, 174, 192,
-	190, 188, 48, -1000, 303, 26, 301, -1000, 510, 414,
-	520, -1000, 510, -1000, -1, -1000, 30, -1000, 520, 166,
-	109, 122, 391, -1000, 520, 520, -1000, -1000, -1000, 398,
-	397, 516, 105, -1000, 182, -1000, 135, -1000, -1000, -1000,
-	-1000, -20, -21, -25, -1000, -1000, -1000, 520, 510, -1000,
-	-1000, 510, 520, -1000, 348, -1000, -1000, 121, 200, -1000,
-	267, -1000, 406, 6, 520, 6, -1000, -1000, 224, 213,
-	209, 510, 510, 345, 520, 520, 520, -1000, -1000, -1000,
-	391, 117, 198, 117, 272, 272, 272, 435, 510, 510,
-	-1000, 320, -9, -1000, -14, -22, 290, -1000, 419, 57,
-	-1000, 272, -1000, -1000, 197, -1000, 272, -1000, 272, -1000,
+	-52, -1000, -1000, -1000, -1000, -42, -19, 8, -9, -1000,
+	15, -1000, -1000, -1000, 76, 366, -1000, 333, 466, -1000,
+	-1000, -1000, 464, -1000, 418, -1000, -41, 413, 497, 83,
+	-38, -6, 366, -1000, -2, 366, -1000, 441, -39, 366,
+	-39, -1000, 450, 345, -1000, 67, -1000, -1000, -3, -1000,
+	-1000, 541, -1000, 350, 349, -1000, 345, 449, 365, 413,
+	233, 43, -1000, 99, -1000, 47, 430, 133, 366, -1000,
+	429, -1000, -27, 420, 458, 184, 366, 413, 363, 376,
+	419, 413, -1000, 326, -1000, -1000, 416, 45, 137, 669,
+	-1000, 573, 509, -1000, 71, -1000, 655, -76, -1000, 309,
+	-1000, 359, -1000, -1000, -1000, 328, -1000, -1000, -1000, -1000,
+	327, 655, -1000, -1000, -1000, -1000, 316, 418, 461, 413,
+	376, 493, 376, -1000, 138, 591, 623, 366, -1000, 457,
+	-46, -1000, 124, -1000, 412, -1000, -1000, 407, -1000, 265,
+	-1000, 308, 316, 14, -1000, -1000, -1000, 329, 541, -1000,
+	-1000, 366, 129, 573, 573, 655, 308, 161, 655, 655,
+	151, 655, 655, 655, 655, 655, 655, 655, 655, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, 669, -30, 12,
+	-10, 669, 354, 352, -1000, 325, -1000, 333, -1000, 421,
+	541, 109, 475, -1000, 309, 261, 295, 483, 573, -1000,
+	655, 475, 475, -1000, -1000, -1000, -1000, 181, 366, -1000,
+	-35, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, 363,
+	376, 245, -1000, -1000, 376, 293, 281, 397, 364, 42,
+	-1000, -1000, -1000, -1000, -1000, 165, 475, -1000, 308, 655,
+	655, 475, 445, -1000, 455, 272, 123, -1000, 60, 60,
+	75, 75, 75, -1000, -1000, 655, -1000, -1000, -1000, -79,
+	-1000, 9, 541, 6, 103, -1000, 573, -1000, 363, 376,
+	483, 469, 473, 137, 475, 399, -1000, -1000, 398, -1000,
+	-1000, 233, 308, -1000, 490, 329, 329, -1000, -1000, 225,
+	200, 229, 228, 222, 30, -1000, 390, -7, 382, -1000,
+	475, 414, 655, -1000, 475, -87, 483, 472, -1000, 5,
+	-1000, 38, -1000, 655, 105, 177, 185, 469, -1000, 655,
+	655, -1000, -1000, -1000, 488, 471, 281, 153, -1000, 218,
+	-1000, 199, -1000, -1000, -1000, -1000, -12, -14, -15, -1000,
+	-1000, -1000, 655, 475, -1000, -67, 655, -1000, -1000, 475,
+	655, -1000, 422, -1000, -1000, 300, 244, -1000, 236, -1000,
+	483, 573, 655, 573, -1000, -1000, 306, 258, 256, 475,
+	-1000, 248, -1000, -1000, 234, 475, 446, 655, 655, 655,
+	-1000, -1000, -1000, 469, 137, 234, 137, 366, 366, 366,
+	-1000, 655, 388, 505, 475, 475, -1000, 404, 2, -1000,
+	-1, -20, 149, -1000, 376, -1000, 502, 85, -1000, 366,
+	-1000, -1000, 655, 233, -1000, 366, -1000, -1000, 366, -1000,
 }
-var yyPgo = [...]int{
-
-	0, 601, 600, 14, 599, 598, 597, 596, 593, 592,
-	591, 590, 362, 579, 576, 575, 234, 22, 34, 558,
-	555, 546, 545, 12, 536, 535, 23, 533, 5, 19,
-	11, 531, 528, 16, 526, 13, 17, 4, 525, 524,
-	10, 523, 1, 522, 521, 9, 520, 509, 504, 503,
-	7, 496, 3, 494, 2, 485, 21, 483, 8, 6,
-	25, 149, 474, 473, 467, 466, 464, 0, 18, 461,
-	456, 453, 451, 449, 448, 445, 444, 443,
+
+var yyPgo = [...]int16{
+	0, 653, 652, 17, 646, 645, 643, 642, 641, 640,
+	639, 638, 231, 637, 636, 635, 271, 16, 23, 634,
+	632, 626, 621, 15, 620, 614, 25, 613, 8, 20,
+	22, 608, 603, 12, 602, 0, 28, 6, 600, 598,
+	13, 595, 2, 592, 591, 11, 590, 589, 588, 587,
+	9, 584, 5, 583, 4, 582, 35, 581, 10, 7,
+	14, 177, 580, 576, 571, 570, 568, 1, 19, 566,
+	564, 563, 560, 559, 558, 557, 544, 539, 538, 21,
+	536, 535, 534, 532, 531, 3, 513,
 }
-var yyR1 = [...]int{
 
+var yyR1 = [...]int8{
 	0, 1, 2, 2, 2, 2, 2, 2, 2, 2,
 	2, 2, 2, 2, 2, 2, 2, 2, 3, 3,
-	3, 4, 4, 73, 73, 5, 6, 7, 7, 7,
-	7, 70, 70, 71, 72, 74, 74, 75, 76, 8,
-	8, 8, 9, 9, 9, 10, 11, 11, 11, 77,
-	12, 13, 13, 14, 14, 14, 14, 14, 15, 15,
-	17, 17, 18, 18, 18, 21, 21, 19, 19, 19,
-	22, 22, 23, 23, 23, 23, 20, 20, 20, 24,
-	24, 24, 24, 24, 24, 24, 24, 24, 25, 25,
-	25, 26, 26, 27, 27, 27, 27, 28, 28, 29,
-	29, 30, 30, 30, 30, 30, 31, 31, 31, 31,
-	31, 31, 31, 31, 31, 31, 32, 32, 32, 32,
-	32, 32, 32, 33, 33, 38, 38, 36, 36, 40,
-	37, 37, 35, 35, 35, 35, 35, 35, 35, 35,
-	35, 35, 35, 35, 35, 35, 35, 35, 35, 39,
-	39, 41, 41, 41, 43, 46, 46, 44, 44, 45,
-	47, 47, 42, 42, 34, 34, 34, 34, 48, 48,
+	3, 3, 77, 77, 78, 78, 79, 4, 4, 73,
+	73, 5, 6, 7, 7, 7, 7, 70, 70, 71,
+	72, 74, 74, 75, 76, 8, 8, 8, 9, 9,
+	9, 10, 11, 11, 11, 86, 12, 13, 13, 14,
+	14, 14, 14, 14, 15, 15, 17, 17, 18, 18,
+	18, 21, 21, 19, 19, 19, 22, 22, 23, 23,
+	23, 23, 20, 20, 20, 24, 24, 24, 24, 24,
+	24, 24, 24, 24, 25, 25, 25, 26, 26, 27,
+	27, 27, 27, 28, 28, 29, 29, 30, 30, 30,
+	30, 30, 31, 31, 31, 31, 31, 31, 31, 31,
+	31, 31, 32, 32, 32, 32, 32, 32, 32, 33,
+	33, 38, 38, 36, 36, 40, 37, 37, 35, 35,
+	35, 35, 35, 35, 35, 35, 35, 35, 35, 35,
+	35, 35, 35, 35, 35, 80, 80, 80, 80, 81,
+	82, 82, 83, 83, 83, 84, 84, 85, 39, 39,
+	41, 41, 41, 43, 46, 46, 44, 44, 45, 47,
+	47, 42, 42, 34, 34, 34, 34, 34, 48, 48,
 	49, 49, 50, 50, 51, 51, 52, 53, 53, 53,
 	54, 54, 54, 54, 55, 55, 55, 56, 56, 57,
 	57, 58, 58, 59, 59, 60, 60, 61, 61, 62,
 	62, 16, 16, 63, 63, 63, 63, 63, 64, 64,
 	65, 65, 66, 66, 67, 68, 69, 69,
 }
-var yyR2 = [...]int{
 
+var yyR2 = [...]int8{
 	0, 1, 1, 1, 1, 1, 1, 1, 1, 1,
 	1, 1, 1, 1, 1, 1, 1, 1, 4, 12,
-	3, 8, 8, 6, 6, 8, 7, 3, 4, 4,
-	6, 1, 2, 1, 1, 4, 2, 2, 4, 5,
-	8, 4, 6, 7, 4, 5, 4, 5, 5, 0,
-	2, 0, 2, 1, 2, 1, 1, 1, 0, 1,
-	1, 3, 1, 2, 3, 1, 1, 0, 1, 2,
-	1, 3, 3, 3, 3, 5, 0, 1, 2, 1,
-	1, 2, 3, 2, 3, 2, 2, 2, 1, 3,
-	1, 1, 3, 0, 5, 5, 5, 1, 3, 0,
-	2, 1, 3, 3, 2, 3, 3, 3, 4, 3,
-	4, 5, 6, 3, 4, 2, 1, 1, 1, 1,
-	1, 1, 1, 2, 1, 1, 3, 3, 1, 3,
-	1, 3, 1, 1, 1, 3, 3, 3, 3, 3,
-	3, 3, 3, 2, 3, 4, 5, 4, 1, 1,
-	1, 1, 1, 1, 5, 0, 1, 1, 2, 4,
-	0, 2, 1, 3, 1, 1, 1, 1, 0, 3,
+	3, 4, 0, 1, 1, 3, 4, 8, 8, 6,
+	6, 8, 7, 3, 4, 4, 6, 1, 2, 1,
+	1, 4, 2, 2, 4, 5, 8, 4, 6, 7,
+	4, 5, 4, 5, 5, 0, 2, 0, 2, 1,
+	2, 1, 1, 1, 0, 2, 1, 3, 1, 2,
+	3, 1, 1, 0, 1, 2, 1, 3, 3, 3,
+	3, 5, 0, 1, 2, 1, 1, 2, 3, 2,
+	3, 2, 2, 2, 1, 3, 1, 1, 3, 0,
+	5, 5, 5, 1, 3, 0, 2, 1, 3, 3,
+	2, 3, 3, 3, 4, 3, 4, 5, 6, 3,
+	4, 2, 1, 1, 1, 1, 1, 1, 1, 2,
+	1, 1, 3, 3, 1, 3, 1, 3, 1, 1,
+	1, 3, 3, 3, 3, 3, 3, 3, 3, 2,
+	3, 3, 1, 5, 1, 3, 4, 5, 4, 3,
+	0, 3, 0, 2, 5, 1, 1, 2, 1, 1,
+	1, 1, 1, 5, 0, 1, 1, 2, 4, 0,
+	2, 1, 3, 1, 2, 1, 1, 1, 0, 3,
 	0, 2, 0, 3, 1, 3, 2, 0, 1, 1,
 	0, 2, 4, 4, 0, 2, 4, 0, 3, 1,
 	3, 0, 5, 1, 3, 3, 3, 0, 2, 0,
 	3, 0, 1, 1, 1, 1, 1, 1, 0, 1,
 	0, 1, 0, 2, 1, 0, 0, 1,
 }
-var yyChk = [...]int{
 
+var yyChk = [...]int16{
 	-1000, -1, -2, -3, -4, -5, -6, -7, -8, -9,
 	-10, -11, -70, -71, -72, -73, -74, -75, -76, 5,
-	6, 7, 8, 29, 94, 95, 97, 96, 83, 84,
-	86, 87, 89, 90, 51, 106, -14, 38, 39, 40,
-	41, -12, -77, -12, -12, -12, -12, 98, -65, 100,
-	104, -16, 100, 102, 98, 98, 99, 100, 85, -12,
-	-26, 91, 31, -67, 31, -12, -3, 17, -15, 18,
-	-13, -16, -26, 9, -59, 88, -60, -42, -67, 31,
-	-62, 103, 99, -67, 98, -67, 31, -61, 103, -67,
-	-61, 25, -56, 36, 80, -69, 98, -17, -18, 76,
-	-21, 31, -30, -35, -31, 56, 36, -34, -42, -36,
-	-41, -67, -39, -43, 20, 32, 33, 34, 21, -40,
-	74, 75, 37, 103, 24, 58, 35, 25, 29, -26,
-	42, 28, -35, 36, 62, 80, 31, 56, -67, -68,
-	31, -68, 101, 31, 20, 53, -67, -26, -33, 24,
-	-3, -57, -42, 31, -26, 9, 42, -19, -67, 19,
-	80, 55, 54, -32, 71, 56, 70, 57, 69, 73,
-	72, 79, 74, 75, 76, 77, 78, 62, 63, 64,
-	65, 66, 67, 68, -30, -35, -30, -37, -3, -35,
-	-35, 36, 36, -40, 36, -46, -35, -26, -59, -29,
-	10, -60, 93, -35, -35, 53, -67, -68, 20, -66,
-	105, -63, 97, 95, 28, 96, 13, 31, 31, 31,
-	-68, -56, 29, -38, -36, 107, 42, -22, -23, -25,
-	36, 31, -40, -18, -67, 76, -30, -30, -35, -36,
-	71, 70, 57, -35, -35, 21, 56, -35, -35, -35,
-	-35, -35, -35, -35, -35, 107, 107, 42, 107, 107,
-	-17, 18, -17, -44, -45, 59, -56, 29, -29, -50,
-	13, -30, -35, 53, -67, -68, -64, 101, -33, -59,
-	42, -42, -29, 42, -24, 43, 44, 45, 46, 47,
-	49, 50, -20, 31, 19, -23, 80, -36, -35, -35,
-	55, 21, -35, 107, -17, 107, -47, -45, 61, -30,
-	-33, -59, -50, -54, 15, 14, 31, 31, -36, -48,
-	11, -23, -23, 43, 48, 43, 48, 43, 43, 43,
-	-27, 51, 102, 52, 31, 107, 31, 55, -35, 107,
-	82, -35, 60, -58, 53, -58, -54, -35, -51, -52,
-	-35, -68, -49, 12, 14, 53, 43, 43, 99, 99,
-	99, -35, -35, 26, 42, 92, 42, -53, 22, 23,
-	-50, -30, -37, -30, 36, 36, 36, 27, -35, -35,
-	-52, -54, -28, -67, -28, -28, 7, -55, 16, 30,
-	107, 42, 107, 107, -59, 7, 71, -67, -67, -67,
+	39, 6, 7, 8, 29, 98, 99, 101, 100, 87,
+	88, 90, 91, 93, 94, 53, 110, -14, 40, 41,
+	42, 43, -12, -86, -77, 111, -12, -12, -12, -12,
+	102, -65, 104, 108, -16, 104, 106, 102, 102, 103,
+	104, 89, -12, -26, 95, 31, -67, 31, -12, -3,
+	17, -15, 18, -13, -78, -79, 31, -16, -26, 9,
+	-59, 92, -60, -42, -67, 31, -62, 107, 103, -67,
+	102, -67, 31, -61, 107, -67, -61, 25, -56, 37,
+	84, -69, 102, -17, -18, 78, -21, 31, -30, -35,
+	-31, 58, 37, -34, -42, -36, -41, -80, -43, 20,
+	32, 36, 33, 34, 21, -67, -40, 76, 77, 38,
+	-39, 60, 107, 24, -12, 35, -3, 44, -56, 25,
+	29, -26, 44, 28, -35, 37, 64, 84, 31, 58,
+	-67, -68, 31, -68, 105, 31, 20, 55, -67, -26,
+	-33, 24, -3, -57, -42, 31, -26, 9, 44, -19,
+	-67, 19, 84, 57, 56, -32, 73, 58, 72, 59,
+	71, 75, 74, 81, 76, 77, 78, 79, 80, 64,
+	65, 66, 67, 68, 69, 70, -30, -35, -30, -37,
+	-3, -35, 82, 83, -35, 112, -40, 37, 32, 37,
+	37, -46, -35, -79, 19, -26, -59, -29, 10, -60,
+	97, -35, -35, 55, -67, -68, 20, -66, 109, -63,
+	101, 99, 28, 100, 13, 31, 31, 31, -68, -56,
+	29, -38, -36, 116, 44, -22, -23, -25, 37, 31,
+	-40, -18, -67, 78, -30, -30, -35, -36, 73, 72,
+	59, -35, -35, 21, 58, -35, -35, -35, -35, -35,
+	-35, -35, -35, 116, 116, 44, 116, 32, 32, 37,
+	116, -17, 18, -17, -44, -45, 61, -40, -56, 29,
+	-29, -50, 13, -30, -35, 55, -67, -68, -64, 105,
+	-33, -59, 44, -42, -29, 44, -24, 45, 46, 47,
+	48, 49, 51, 52, -20, 31, 19, -23, 84, -36,
+	-35, -35, 57, 21, -35, -81, -82, 113, 116, -17,
+	116, -47, -45, 63, -30, -33, -59, -50, -54, 15,
+	14, 31, 31, -36, -48, 11, -23, -23, 45, 50,
+	45, 50, 45, 45, 45, -27, 53, 106, 54, 31,
+	116, 31, 57, -35, 116, -50, 14, 116, 86, -35,
+	62, -58, 55, -58, -54, -35, -51, -52, -35, -68,
+	-49, 12, 14, 55, 45, 45, 103, 103, 103, -35,
+	-83, -84, 114, 115, -37, -35, 26, 44, 96, 44,
+	-53, 22, 23, -50, -30, -37, -30, 37, 37, 37,
+	-85, 59, -35, 27, -35, -35, -52, -54, -28, -67,
+	-28, -28, -85, -67, 7, -55, 16, 30, 116, 44,
+	116, 116, 57, -59, 7, 73, -67, -85, -67, -67,
 }
-var yyDef = [...]int{
 
+var yyDef = [...]int16{
 	0, -2, 1, 2, 3, 4, 5, 6, 7, 8,
-	9, 10, 11, 12, 13, 14, 15, 16, 17, 49,
-	49, 49, 49, 49, 210, 201, 0, 0, 31, 0,
-	33, 34, 49, 0, 0, 49, 0, 53, 55, 56,
-	57, 58, 51, 201, 0, 0, 0, 199, 0, 0,
-	211, 0, 0, 202, 0, 197, 0, 197, 32, 0,
-	187, 36, 91, 37, 214, 216, 20, 54, 0, 59,
-	50, 0, 0, 0, 27, 0, 193, 0, 162, 214,
-	0, 0, 0, 215, 0, 215, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 217, 18, 60, 62,
-	67, 214, 65, 66, 101, 0, 0, 132, 133, 134,
-	0, 162, 0, 148, 0, 164, 165, 166, 167, 128,
-	151, 152, 153, 149, 150, 155, 52, 0, 0, 99,
-	0, 28, 29, 0, 0, 0, 215, 0, 212, 41,
-	0, 44, 0, 46, 198, 0, 215, 187, 35, 0,
-	124, 0, 189, 92, 38, 0, 0, 63, 68, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 116, 117, 118,
-	119, 120, 121, 122, 104, 0, 0, 0, 0, 130,
-	143, 0, 0, 115, 0, 0, 156, 187, 99, 172,
-	0, 194, 0, 130, 195, 196, 163, 39, 200, 0,
-	0, 215, 208, 203, 204, 205, 206, 207, 45, 47,
-	48, 0, 0, 123, 125, 188, 0, 99, 70, 76,
-	0, 88, 90, 61, 69, 64, 102, 103, 106, 107,
-	0, 0, 0, 109, 0, 113, 0, 135, 136, 137,
-	138, 139, 140, 141, 142, 105, 127, 0, 129, 144,
-	0, 0, 0, 160, 157, 0, 0, 0, 172, 180,
-	0, 100, 30, 0, 213, 42, 0, 209, 23, 24,
-	0, 190, 168, 0, 0, 79, 80, 0, 0, 0,
-	0, 0, 93, 77, 0, 0, 0, 108, 110, 0,
-	0, 114, 131, 145, 0, 147, 0, 158, 0, 0,
-	191, 191, 180, 26, 0, 0, 215, 43, 126, 170,
-	0, 71, 74, 81, 0, 83, 0, 85, 86, 87,
-	72, 0, 0, 0, 78, 73, 89, 0, 111, 146,
-	154, 161, 0, 21, 0, 22, 25, 181, 173, 174,
-	177, 40, 172, 0, 0, 0, 82, 84, 0, 0,
-	0, 112, 159, 0, 0, 0, 0, 176, 178, 179,
-	180, 171, 169, 75, 0, 0, 0, 0, 182, 183,
-	175, 184, 0, 97, 0, 0, 0, 19, 0, 0,
-	94, 0, 95, 96, 192, 185, 0, 98, 0, 186,
+	9, 10, 11, 12, 13, 14, 15, 16, 17, 55,
+	22, 55, 55, 55, 55, 230, 221, 0, 0, 37,
+	0, 39, 40, 55, 0, 0, 55, 0, 59, 61,
+	62, 63, 64, 57, 0, 23, 221, 0, 0, 0,
+	219, 0, 0, 231, 0, 0, 222, 0, 217, 0,
+	217, 38, 0, 207, 42, 97, 43, 234, 236, 20,
+	60, 0, 55, 56, 0, 24, 207, 0, 0, 0,
+	33, 0, 213, 0, 181, 234, 0, 0, 0, 235,
+	0, 235, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 237, 18, 66, 68, 73, 234, 71, 72,
+	107, 0, 0, 138, 139, 140, 0, 152, 154, 0,
+	183, 0, 185, 186, 187, 181, 134, 170, 171, 172,
+	0, 174, 168, 169, 65, 58, 21, 0, 0, 0,
+	0, 105, 0, 34, 35, 0, 0, 0, 235, 0,
+	232, 47, 0, 50, 0, 52, 218, 0, 235, 207,
+	41, 0, 130, 0, 209, 98, 44, 0, 0, 69,
+	74, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 122,
+	123, 124, 125, 126, 127, 128, 110, 0, 0, 0,
+	0, 136, 0, 0, 149, 0, 121, 0, 184, 0,
+	0, 0, 175, 25, 0, 207, 105, 192, 0, 214,
+	0, 136, 215, 216, 182, 45, 220, 0, 0, 235,
+	228, 223, 224, 225, 226, 227, 51, 53, 54, 0,
+	0, 129, 131, 208, 0, 105, 76, 82, 0, 94,
+	96, 67, 75, 70, 108, 109, 112, 113, 0, 0,
+	0, 115, 0, 119, 0, 141, 142, 143, 144, 145,
+	146, 147, 148, 111, 133, 0, 135, 150, 151, 160,
+	155, 0, 0, 0, 179, 176, 0, 26, 0, 0,
+	192, 200, 0, 106, 36, 0, 233, 48, 0, 229,
+	29, 30, 0, 210, 188, 0, 0, 85, 86, 0,
+	0, 0, 0, 0, 99, 83, 0, 0, 0, 114,
+	116, 0, 0, 120, 137, 0, 192, 0, 156, 0,
+	158, 0, 177, 0, 0, 211, 211, 200, 32, 0,
+	0, 235, 49, 132, 190, 0, 77, 80, 87, 0,
+	89, 0, 91, 92, 93, 78, 0, 0, 0, 84,
+	79, 95, 0, 117, 153, 162, 0, 157, 173, 180,
+	0, 27, 0, 28, 31, 201, 193, 194, 197, 46,
+	192, 0, 0, 0, 88, 90, 0, 0, 0, 118,
+	159, 0, 165, 166, 161, 178, 0, 0, 0, 0,
+	196, 198, 199, 200, 191, 189, 81, 0, 0, 0,
+	163, 0, 0, 0, 202, 203, 195, 204, 0, 103,
+	0, 0, 0, 167, 0, 19, 0, 0, 100, 0,
+	101, 102, 0, 212, 205, 0, 104, 164, 0, 206,
 }
-var yyTok1 = [...]int{
 
+var yyTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 78, 73, 3,
-	36, 107, 76, 74, 42, 75, 80, 77, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 80, 75, 3,
+	37, 116, 78, 76, 44, 77, 84, 79, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	63, 62, 64, 3, 3, 3, 3, 3, 3, 3,
+	65, 64, 66, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 79, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 81, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 72, 3, 37,
+	3, 3, 3, 3, 74, 3, 38,
 }
-var yyTok2 = [...]int{
 
+var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
-	32, 33, 34, 35, 38, 39, 40, 41, 43, 44,
+	32, 33, 34, 35, 36, 39, 40, 41, 42, 43,
 	45, 46, 47, 48, 49, 50, 51, 52, 53, 54,
-	55, 56, 57, 58, 59, 60, 61, 65, 66, 67,
-	68, 69, 70, 71, 81, 82, 83, 84, 85, 86,
+	55, 56, 57, 58, 59, 60, 61, 62, 63, 67,
+	68, 69, 70, 71, 72, 73, 82, 83, 85, 86,
 	87, 88, 89, 90, 91, 92, 93, 94, 95, 96,
 	97, 98, 99, 100, 101, 102, 103, 104, 105, 106,
+	107, 108, 109, 110, 111, 112, 113, 114, 115,
 }
-var yyTok3 = [...]int{
+
+var yyTok3 = [...]int8{
 	0,
 }
 
@@ -649,9 +707,9 @@ func yyErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := yyPact[state]
+	base := int(yyPact[state])
 	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -661,13 +719,13 @@ func yyErrorMessage(state, lookAhead int) string {
 
 	if yyDef[state] == -2 {
 		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
+			tok := int(yyExca[i])
 			if tok < TOKSTART || yyExca[i+1] == 0 {
 				continue
 			}
@@ -698,30 +756,30 @@ func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		token = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
+		token = int(yyTok3[i+0])
 		if token == char {
-			token = yyTok3[i+1]
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
@@ -776,7 +834,7 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
@@ -787,8 +845,8 @@ yynewstate:
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
 		yyrcvr.char = -1
 		yytoken = -1
 		yyVAL = yyrcvr.lval
@@ -801,7 +859,7 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
 		if yyrcvr.char < 0 {
 			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
@@ -810,18 +868,18 @@ yydefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
+			yyn = int(yyExca[xi+0])
 			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -843,10 +901,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -882,7 +940,7 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
 	// yyp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if yyp+1 >= len(yyS) {
@@ -893,16 +951,16 @@ yydefault:
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -910,43 +968,79 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:193
+//line ./sqlparser/sql.y:217
 		{
 			SetParseTree(yylex, yyDollar[1].statement)
 		}
 	case 2:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:199
+//line ./sqlparser/sql.y:223
 		{
 			yyVAL.statement = yyDollar[1].selStmt
 		}
 	case 18:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:220
+//line ./sqlparser/sql.y:244
 		{
 			yyVAL.selStmt = &SimpleSelect{Comments: Comments(yyDollar[2].bytes2), Distinct: yyDollar[3].str, SelectExprs: yyDollar[4].selectExprs}
 		}
 	case 19:
 		yyDollar = yyS[yypt-12 : yypt+1]
-		//line ./sqlparser/sql.y:224
+//line ./sqlparser/sql.y:248
 		{
 			yyVAL.selStmt = &Select{Comments: Comments(yyDollar[2].bytes2), Distinct: yyDollar[3].str, SelectExprs: yyDollar[4].selectExprs, From: yyDollar[6].tableExprs, Where: NewWhere(AST_WHERE, yyDollar[7].boolExpr), GroupBy: GroupBy(yyDollar[8].valExprs), Having: NewWhere(AST_HAVING, yyDollar[9].boolExpr), OrderBy: yyDollar[10].orderBy, Limit: yyDollar[11].limit, Lock: yyDollar[12].str}
 		}
 	case 20:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:228
+//line ./sqlparser/sql.y:252
 		{
 			yyVAL.selStmt = &Union{Type: yyDollar[2].str, Left: yyDollar[1].selStmt, Right: yyDollar[3].selStmt}
 		}
 	case 21:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line ./sqlparser/sql.y:256
+		{
+			yyVAL.selStmt = &With{Recursive: yyDollar[2].str, Ctes: yyDollar[3].ctes, Select: yyDollar[4].selStmt}
+		}
+	case 22:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line ./sqlparser/sql.y:261
+		{
+			yyVAL.str = ""
+		}
+	case 23:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line ./sqlparser/sql.y:265
+		{
+			yyVAL.str = AST_RECURSIVE
+		}
+	case 24:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line ./sqlparser/sql.y:271
+		{
+			yyVAL.ctes = []*CommonTableExpr{yyDollar[1].cte}
+		}
+	case 25:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line ./sqlparser/sql.y:275
+		{
+			yyVAL.ctes = append(yyDollar[1].ctes, yyDollar[3].cte)
+		}
+	case 26:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line ./sqlparser/sql.y:281
+		{
+			yyVAL.cte = &CommonTableExpr{Name: yyDollar[1].bytes, Columns: yyDollar[2].columns, Subquery: yyDollar[4].subquery}
+		}
+	case 27:
 		yyDollar = yyS[yypt-8 : yypt+1]
-		//line ./sqlparser/sql.y:235
+//line ./sqlparser/sql.y:288
 		{
 			yyVAL.statement = &Insert{Comments: Comments(yyDollar[2].bytes2), Ignore: yyDollar[3].str, Table: yyDollar[5].tableName, Columns: yyDollar[6].columns, Rows: yyDollar[7].insRows, OnDup: OnDup(yyDollar[8].updateExprs)}
 		}
-	case 22:
+	case 28:
 		yyDollar = yyS[yypt-8 : yypt+1]
-		//line ./sqlparser/sql.y:239
+//line ./sqlparser/sql.y:292
 		{
 			cols := make(Columns, 0, len(yyDollar[7].updateExprs))
 			vals := make(ValTuple, 0, len(yyDollar[7].updateExprs))
@@ -956,15 +1050,15 @@ yydefault:
 			}
 			yyVAL.statement = &Insert{Comments: Comments(yyDollar[2].bytes2), Ignore: yyDollar[3].str, Table: yyDollar[5].tableName, Columns: cols, Rows: Values{vals}, OnDup: OnDup(yyDollar[8].updateExprs)}
 		}
-	case 23:
+	case 29:
 		yyDollar = yyS[yypt-6 : yypt+1]
-		//line ./sqlparser/sql.y:251
+//line ./sqlparser/sql.y:304
 		{
 			yyVAL.statement = &Replace{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Columns: yyDollar[5].columns, Rows: yyDollar[6].insRows}
 		}
-	case 24:
+	case 30:
 		yyDollar = yyS[yypt-6 : yypt+1]
-		//line ./sqlparser/sql.y:255
+//line ./sqlparser/sql.y:308
 		{
 			cols := make(Columns, 0, len(yyDollar[6].updateExprs))
 			vals := make(ValTuple, 0, len(yyDollar[6].updateExprs))
@@ -974,39 +1068,39 @@ yydefault:
 			}
 			yyVAL.statement = &Replace{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Columns: cols, Rows: Values{vals}}
 		}
-	case 25:
+	case 31:
 		yyDollar = yyS[yypt-8 : yypt+1]
-		//line ./sqlparser/sql.y:268
+//line ./sqlparser/sql.y:321
 		{
 			yyVAL.statement = &Update{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[3].tableName, Exprs: yyDollar[5].updateExprs, Where: NewWhere(AST_WHERE, yyDollar[6].boolExpr), OrderBy: yyDollar[7].orderBy, Limit: yyDollar[8].limit}
 		}
-	case 26:
+	case 32:
 		yyDollar = yyS[yypt-7 : yypt+1]
-		//line ./sqlparser/sql.y:274
+//line ./sqlparser/sql.y:327
 		{
 			yyVAL.statement = &Delete{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Where: NewWhere(AST_WHERE, yyDollar[5].boolExpr), OrderBy: yyDollar[6].orderBy, Limit: yyDollar[7].limit}
 		}
-	case 27:
+	case 33:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:280
+//line ./sqlparser/sql.y:333
 		{
 			yyVAL.statement = &Set{Comments: Comments(yyDollar[2].bytes2), Exprs: yyDollar[3].updateExprs}
 		}
-	case 28:
+	case 34:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:284
+//line ./sqlparser/sql.y:337
 		{
 			yyVAL.statement = &Set{Comments: Comments(yyDollar[2].bytes2), Exprs: UpdateExprs{&UpdateExpr{Name: &ColName{Name: []byte("names")}, Expr: StrVal("default")}}}
 		}
-	case 29:
+	case 35:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:288
+//line ./sqlparser/sql.y:341
 		{
 			yyVAL.statement = &Set{Comments: Comments(yyDollar[2].bytes2), Exprs: UpdateExprs{&UpdateExpr{Name: &ColName{Name: []byte("names")}, Expr: yyDollar[4].valExpr}}}
 		}
-	case 30:
+	case 36:
 		yyDollar = yyS[yypt-6 : yypt+1]
-		//line ./sqlparser/sql.y:292
+//line ./sqlparser/sql.y:345
 		{
 			yyVAL.statement = &Set{
 				Comments: Comments(yyDollar[2].bytes2),
@@ -1020,684 +1114,688 @@ yydefault:
 				},
 			}
 		}
-	case 31:
+	case 37:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:308
+//line ./sqlparser/sql.y:361
 		{
 			yyVAL.statement = &Begin{}
 		}
-	case 32:
+	case 38:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:312
+//line ./sqlparser/sql.y:365
 		{
 			yyVAL.statement = &Begin{}
 		}
-	case 33:
+	case 39:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:319
+//line ./sqlparser/sql.y:372
 		{
 			yyVAL.statement = &Commit{}
 		}
-	case 34:
+	case 40:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:325
+//line ./sqlparser/sql.y:378
 		{
 			yyVAL.statement = &Rollback{}
 		}
-	case 35:
+	case 41:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:331
+//line ./sqlparser/sql.y:384
 		{
 			yyVAL.statement = &Admin{Region: yyDollar[2].tableName, Columns: yyDollar[3].columns, Rows: yyDollar[4].insRows}
 		}
-	case 36:
+	case 42:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:335
+//line ./sqlparser/sql.y:388
 		{
 			yyVAL.statement = &AdminHelp{}
 		}
-	case 37:
+	case 43:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:341
+//line ./sqlparser/sql.y:394
 		{
 			yyVAL.statement = &UseDB{DB: string(yyDollar[2].bytes)}
 		}
-	case 38:
+	case 44:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:347
+//line ./sqlparser/sql.y:400
 		{
 			yyVAL.statement = &Truncate{Comments: Comments(yyDollar[2].bytes2), TableOpt: yyDollar[3].str, Table: yyDollar[4].tableName}
 		}
-	case 39:
+	case 45:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:353
+//line ./sqlparser/sql.y:406
 		{
 			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyDollar[4].bytes}
 		}
-	case 40:
+	case 46:
 		yyDollar = yyS[yypt-8 : yypt+1]
-		//line ./sqlparser/sql.y:357
+//line ./sqlparser/sql.y:410
 		{
 			// Change this to an alter statement
 			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[7].bytes, NewName: yyDollar[7].bytes}
 		}
-	case 41:
+	case 47:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:362
+//line ./sqlparser/sql.y:415
 		{
 			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyDollar[3].bytes}
 		}
-	case 42:
+	case 48:
 		yyDollar = yyS[yypt-6 : yypt+1]
-		//line ./sqlparser/sql.y:368
+//line ./sqlparser/sql.y:421
 		{
 			yyVAL.statement = &DDL{Action: AST_ALTER, Ignore: yyDollar[2].str, Table: yyDollar[4].bytes, NewName: yyDollar[4].bytes}
 		}
-	case 43:
+	case 49:
 		yyDollar = yyS[yypt-7 : yypt+1]
-		//line ./sqlparser/sql.y:372
+//line ./sqlparser/sql.y:425
 		{
 			// Change this to a rename statement
 			yyVAL.statement = &DDL{Action: AST_RENAME, Ignore: yyDollar[2].str, Table: yyDollar[4].bytes, NewName: yyDollar[7].bytes}
 		}
-	case 44:
+	case 50:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:377
+//line ./sqlparser/sql.y:430
 		{
 			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[3].bytes, NewName: yyDollar[3].bytes}
 		}
-	case 45:
+	case 51:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:383
+//line ./sqlparser/sql.y:436
 		{
 			yyVAL.statement = &DDL{Action: AST_RENAME, Table: yyDollar[3].bytes, NewName: yyDollar[5].bytes}
 		}
-	case 46:
+	case 52:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:389
+//line ./sqlparser/sql.y:442
 		{
 			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyDollar[4].bytes}
 		}
-	case 47:
+	case 53:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:393
+//line ./sqlparser/sql.y:446
 		{
 			// Change this to an alter statement
 			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[5].bytes, NewName: yyDollar[5].bytes}
 		}
-	case 48:
+	case 54:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:398
+//line ./sqlparser/sql.y:451
 		{
 			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyDollar[4].bytes}
 		}
-	case 49:
+	case 55:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:403
+//line ./sqlparser/sql.y:456
 		{
 			SetAllowComments(yylex, true)
 		}
-	case 50:
+	case 56:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:407
+//line ./sqlparser/sql.y:460
 		{
 			yyVAL.bytes2 = yyDollar[2].bytes2
 			SetAllowComments(yylex, false)
 		}
-	case 51:
+	case 57:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:413
+//line ./sqlparser/sql.y:466
 		{
 			yyVAL.bytes2 = nil
 		}
-	case 52:
+	case 58:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:417
+//line ./sqlparser/sql.y:470
 		{
 			yyVAL.bytes2 = append(yyDollar[1].bytes2, yyDollar[2].bytes)
 		}
-	case 53:
+	case 59:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:423
+//line ./sqlparser/sql.y:476
 		{
 			yyVAL.str = AST_UNION
 		}
-	case 54:
+	case 60:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:427
+//line ./sqlparser/sql.y:480
 		{
 			yyVAL.str = AST_UNION_ALL
 		}
-	case 55:
+	case 61:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:431
+//line ./sqlparser/sql.y:484
 		{
 			yyVAL.str = AST_SET_MINUS
 		}
-	case 56:
+	case 62:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:435
+//line ./sqlparser/sql.y:488
 		{
 			yyVAL.str = AST_EXCEPT
 		}
-	case 57:
+	case 63:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:439
+//line ./sqlparser/sql.y:492
 		{
 			yyVAL.str = AST_INTERSECT
 		}
-	case 58:
+	case 64:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:444
+//line ./sqlparser/sql.y:497
 		{
 			yyVAL.str = ""
 		}
-	case 59:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:448
+	case 65:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line ./sqlparser/sql.y:501
 		{
-			yyVAL.str = AST_DISTINCT
+			yyVAL.str = AST_DISTINCT + String(Comments(yyDollar[2].bytes2))
 		}
-	case 60:
+	case 66:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:454
+//line ./sqlparser/sql.y:507
 		{
 			yyVAL.selectExprs = SelectExprs{yyDollar[1].selectExpr}
 		}
-	case 61:
+	case 67:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:458
+//line ./sqlparser/sql.y:511
 		{
 			yyVAL.selectExprs = append(yyVAL.selectExprs, yyDollar[3].selectExpr)
 		}
-	case 62:
+	case 68:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:464
+//line ./sqlparser/sql.y:517
 		{
 			yyVAL.selectExpr = &StarExpr{}
 		}
-	case 63:
+	case 69:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:468
+//line ./sqlparser/sql.y:521
 		{
 			yyVAL.selectExpr = &NonStarExpr{Expr: yyDollar[1].expr, As: yyDollar[2].bytes}
 		}
-	case 64:
+	case 70:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:472
+//line ./sqlparser/sql.y:525
 		{
 			yyVAL.selectExpr = &StarExpr{TableName: yyDollar[1].bytes}
 		}
-	case 65:
+	case 71:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:478
+//line ./sqlparser/sql.y:531
 		{
 			yyVAL.expr = yyDollar[1].boolExpr
 		}
-	case 66:
+	case 72:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:482
+//line ./sqlparser/sql.y:535
 		{
 			yyVAL.expr = yyDollar[1].valExpr
 		}
-	case 67:
+	case 73:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:487
+//line ./sqlparser/sql.y:540
 		{
 			yyVAL.bytes = nil
 		}
-	case 68:
+	case 74:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:491
+//line ./sqlparser/sql.y:544
 		{
 			yyVAL.bytes = yyDollar[1].bytes
 		}
-	case 69:
+	case 75:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:495
+//line ./sqlparser/sql.y:548
 		{
 			yyVAL.bytes = yyDollar[2].bytes
 		}
-	case 70:
+	case 76:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:501
+//line ./sqlparser/sql.y:554
 		{
 			yyVAL.tableExprs = TableExprs{yyDollar[1].tableExpr}
 		}
-	case 71:
+	case 77:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:505
+//line ./sqlparser/sql.y:558
 		{
 			yyVAL.tableExprs = append(yyVAL.tableExprs, yyDollar[3].tableExpr)
 		}
-	case 72:
+	case 78:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:511
+//line ./sqlparser/sql.y:564
 		{
 			yyVAL.tableExpr = &AliasedTableExpr{Expr: yyDollar[1].smTableExpr, As: yyDollar[2].bytes, Hints: yyDollar[3].indexHints}
 		}
-	case 73:
+	case 79:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:515
+//line ./sqlparser/sql.y:568
 		{
 			yyVAL.tableExpr = &ParenTableExpr{Expr: yyDollar[2].tableExpr}
 		}
-	case 74:
+	case 80:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:519
+//line ./sqlparser/sql.y:572
 		{
 			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyDollar[1].tableExpr, Join: yyDollar[2].str, RightExpr: yyDollar[3].tableExpr}
 		}
-	case 75:
+	case 81:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:523
+//line ./sqlparser/sql.y:576
 		{
 			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyDollar[1].tableExpr, Join: yyDollar[2].str, RightExpr: yyDollar[3].tableExpr, On: yyDollar[5].boolExpr}
 		}
-	case 76:
+	case 82:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:528
+//line ./sqlparser/sql.y:581
 		{
 			yyVAL.bytes = nil
 		}
-	case 77:
+	case 83:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:532
+//line ./sqlparser/sql.y:585
 		{
 			yyVAL.bytes = yyDollar[1].bytes
 		}
-	case 78:
+	case 84:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:536
+//line ./sqlparser/sql.y:589
 		{
 			yyVAL.bytes = yyDollar[2].bytes
 		}
-	case 79:
+	case 85:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:542
+//line ./sqlparser/sql.y:595
 		{
 			yyVAL.str = AST_JOIN
 		}
-	case 80:
+	case 86:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:546
+//line ./sqlparser/sql.y:599
 		{
 			yyVAL.str = AST_STRAIGHT_JOIN
 		}
-	case 81:
+	case 87:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:550
+//line ./sqlparser/sql.y:603
 		{
 			yyVAL.str = AST_LEFT_JOIN
 		}
-	case 82:
+	case 88:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:554
+//line ./sqlparser/sql.y:607
 		{
 			yyVAL.str = AST_LEFT_JOIN
 		}
-	case 83:
+	case 89:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:558
+//line ./sqlparser/sql.y:611
 		{
 			yyVAL.str = AST_RIGHT_JOIN
 		}
-	case 84:
+	case 90:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:562
+//line ./sqlparser/sql.y:615
 		{
 			yyVAL.str = AST_RIGHT_JOIN
 		}
-	case 85:
+	case 91:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:566
+//line ./sqlparser/sql.y:619
 		{
 			yyVAL.str = AST_JOIN
 		}
-	case 86:
+	case 92:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:570
+//line ./sqlparser/sql.y:623
 		{
 			yyVAL.str = AST_CROSS_JOIN
 		}
-	case 87:
+	case 93:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:574
+//line ./sqlparser/sql.y:627
 		{
 			yyVAL.str = AST_NATURAL_JOIN
 		}
-	case 88:
+	case 94:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:580
+//line ./sqlparser/sql.y:633
 		{
 			yyVAL.smTableExpr = &TableName{Name: yyDollar[1].bytes}
 		}
-	case 89:
+	case 95:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:584
+//line ./sqlparser/sql.y:637
 		{
 			yyVAL.smTableExpr = &TableName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
-	case 90:
+	case 96:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:588
+//line ./sqlparser/sql.y:641
 		{
 			yyVAL.smTableExpr = yyDollar[1].subquery
 		}
-	case 91:
+	case 97:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:594
+//line ./sqlparser/sql.y:647
 		{
 			yyVAL.tableName = &TableName{Name: yyDollar[1].bytes}
 		}
-	case 92:
+	case 98:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:598
+//line ./sqlparser/sql.y:651
 		{
 			yyVAL.tableName = &TableName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
-	case 93:
+	case 99:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:603
+//line ./sqlparser/sql.y:656
 		{
 			yyVAL.indexHints = nil
 		}
-	case 94:
+	case 100:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:607
+//line ./sqlparser/sql.y:660
 		{
 			yyVAL.indexHints = &IndexHints{Type: AST_USE, Indexes: yyDollar[4].bytes2}
 		}
-	case 95:
+	case 101:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:611
+//line ./sqlparser/sql.y:664
 		{
 			yyVAL.indexHints = &IndexHints{Type: AST_IGNORE, Indexes: yyDollar[4].bytes2}
 		}
-	case 96:
+	case 102:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:615
+//line ./sqlparser/sql.y:668
 		{
 			yyVAL.indexHints = &IndexHints{Type: AST_FORCE, Indexes: yyDollar[4].bytes2}
 		}
-	case 97:
+	case 103:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:621
+//line ./sqlparser/sql.y:674
 		{
 			yyVAL.bytes2 = [][]byte{yyDollar[1].bytes}
 		}
-	case 98:
+	case 104:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:625
+//line ./sqlparser/sql.y:678
 		{
 			yyVAL.bytes2 = append(yyDollar[1].bytes2, yyDollar[3].bytes)
 		}
-	case 99:
+	case 105:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:630
+//line ./sqlparser/sql.y:683
 		{
 			yyVAL.boolExpr = nil
 		}
-	case 100:
+	case 106:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:634
+//line ./sqlparser/sql.y:687
 		{
 			yyVAL.boolExpr = yyDollar[2].boolExpr
 		}
-	case 102:
+	case 108:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:641
+//line ./sqlparser/sql.y:694
 		{
 			yyVAL.boolExpr = &AndExpr{Left: yyDollar[1].boolExpr, Right: yyDollar[3].boolExpr}
 		}
-	case 103:
+	case 109:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:645
+//line ./sqlparser/sql.y:698
 		{
 			yyVAL.boolExpr = &OrExpr{Left: yyDollar[1].boolExpr, Right: yyDollar[3].boolExpr}
 		}
-	case 104:
+	case 110:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:649
+//line ./sqlparser/sql.y:702
 		{
 			yyVAL.boolExpr = &NotExpr{Expr: yyDollar[2].boolExpr}
 		}
-	case 105:
+	case 111:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:653
+//line ./sqlparser/sql.y:706
 		{
 			yyVAL.boolExpr = &ParenBoolExpr{Expr: yyDollar[2].boolExpr}
 		}
-	case 106:
+	case 112:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:659
+//line ./sqlparser/sql.y:712
 		{
 			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: yyDollar[2].str, Right: yyDollar[3].valExpr}
 		}
-	case 107:
+	case 113:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:663
+//line ./sqlparser/sql.y:716
 		{
 			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_IN, Right: yyDollar[3].tuple}
 		}
-	case 108:
+	case 114:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:667
+//line ./sqlparser/sql.y:720
 		{
 			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_NOT_IN, Right: yyDollar[4].tuple}
 		}
-	case 109:
+	case 115:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:671
+//line ./sqlparser/sql.y:724
 		{
 			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_LIKE, Right: yyDollar[3].valExpr}
 		}
-	case 110:
+	case 116:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:675
+//line ./sqlparser/sql.y:728
 		{
 			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_NOT_LIKE, Right: yyDollar[4].valExpr}
 		}
-	case 111:
+	case 117:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:679
+//line ./sqlparser/sql.y:732
 		{
 			yyVAL.boolExpr = &RangeCond{Left: yyDollar[1].valExpr, Operator: AST_BETWEEN, From: yyDollar[3].valExpr, To: yyDollar[5].valExpr}
 		}
-	case 112:
+	case 118:
 		yyDollar = yyS[yypt-6 : yypt+1]
-		//line ./sqlparser/sql.y:683
+//line ./sqlparser/sql.y:736
 		{
 			yyVAL.boolExpr = &RangeCond{Left: yyDollar[1].valExpr, Operator: AST_NOT_BETWEEN, From: yyDollar[4].valExpr, To: yyDollar[6].valExpr}
 		}
-	case 113:
+	case 119:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:687
+//line ./sqlparser/sql.y:740
 		{
 			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NULL, Expr: yyDollar[1].valExpr}
 		}
-	case 114:
+	case 120:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:691
+//line ./sqlparser/sql.y:744
 		{
 			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NOT_NULL, Expr: yyDollar[1].valExpr}
 		}
-	case 115:
+	case 121:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:695
+//line ./sqlparser/sql.y:748
 		{
 			yyVAL.boolExpr = &ExistsExpr{Subquery: yyDollar[2].subquery}
 		}
-	case 116:
+	case 122:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:701
+//line ./sqlparser/sql.y:754
 		{
 			yyVAL.str = AST_EQ
 		}
-	case 117:
+	case 123:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:705
+//line ./sqlparser/sql.y:758
 		{
 			yyVAL.str = AST_LT
 		}
-	case 118:
+	case 124:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:709
+//line ./sqlparser/sql.y:762
 		{
 			yyVAL.str = AST_GT
 		}
-	case 119:
+	case 125:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:713
+//line ./sqlparser/sql.y:766
 		{
 			yyVAL.str = AST_LE
 		}
-	case 120:
+	case 126:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:717
+//line ./sqlparser/sql.y:770
 		{
 			yyVAL.str = AST_GE
 		}
-	case 121:
+	case 127:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:721
+//line ./sqlparser/sql.y:774
 		{
 			yyVAL.str = AST_NE
 		}
-	case 122:
+	case 128:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:725
+//line ./sqlparser/sql.y:778
 		{
 			yyVAL.str = AST_NSE
 		}
-	case 123:
+	case 129:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:731
+//line ./sqlparser/sql.y:784
 		{
 			yyVAL.insRows = yyDollar[2].values
 		}
-	case 124:
+	case 130:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:735
+//line ./sqlparser/sql.y:788
 		{
 			yyVAL.insRows = yyDollar[1].selStmt
 		}
-	case 125:
+	case 131:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:741
+//line ./sqlparser/sql.y:794
 		{
 			yyVAL.values = Values{yyDollar[1].tuple}
 		}
-	case 126:
+	case 132:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:745
+//line ./sqlparser/sql.y:798
 		{
 			yyVAL.values = append(yyDollar[1].values, yyDollar[3].tuple)
 		}
-	case 127:
+	case 133:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:751
+//line ./sqlparser/sql.y:804
 		{
 			yyVAL.tuple = ValTuple(yyDollar[2].valExprs)
 		}
-	case 128:
+	case 134:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:755
+//line ./sqlparser/sql.y:808
 		{
 			yyVAL.tuple = yyDollar[1].subquery
 		}
-	case 129:
+	case 135:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:761
+//line ./sqlparser/sql.y:814
 		{
 			yyVAL.subquery = &Subquery{yyDollar[2].selStmt}
 		}
-	case 130:
+	case 136:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:767
+//line ./sqlparser/sql.y:820
 		{
 			yyVAL.valExprs = ValExprs{yyDollar[1].valExpr}
 		}
-	case 131:
+	case 137:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:771
+//line ./sqlparser/sql.y:824
 		{
 			yyVAL.valExprs = append(yyDollar[1].valExprs, yyDollar[3].valExpr)
 		}
-	case 132:
+	case 138:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:777
+//line ./sqlparser/sql.y:830
 		{
 			yyVAL.valExpr = yyDollar[1].valExpr
 		}
-	case 133:
+	case 139:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:781
+//line ./sqlparser/sql.y:834
 		{
 			yyVAL.valExpr = yyDollar[1].colName
 		}
-	case 134:
+	case 140:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:785
+//line ./sqlparser/sql.y:838
 		{
 			yyVAL.valExpr = yyDollar[1].tuple
 		}
-	case 135:
+	case 141:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:789
+//line ./sqlparser/sql.y:842
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITAND, Right: yyDollar[3].valExpr}
 		}
-	case 136:
+	case 142:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:793
+//line ./sqlparser/sql.y:846
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITOR, Right: yyDollar[3].valExpr}
 		}
-	case 137:
+	case 143:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:797
+//line ./sqlparser/sql.y:850
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITXOR, Right: yyDollar[3].valExpr}
 		}
-	case 138:
+	case 144:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:801
+//line ./sqlparser/sql.y:854
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_PLUS, Right: yyDollar[3].valExpr}
 		}
-	case 139:
+	case 145:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:805
+//line ./sqlparser/sql.y:858
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MINUS, Right: yyDollar[3].valExpr}
 		}
-	case 140:
+	case 146:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:809
+//line ./sqlparser/sql.y:862
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MULT, Right: yyDollar[3].valExpr}
 		}
-	case 141:
+	case 147:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:813
+//line ./sqlparser/sql.y:866
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_DIV, Right: yyDollar[3].valExpr}
 		}
-	case 142:
+	case 148:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:817
+//line ./sqlparser/sql.y:870
 		{
 			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MOD, Right: yyDollar[3].valExpr}
 		}
-	case 143:
+	case 149:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:821
+//line ./sqlparser/sql.y:874
 		{
 			if num, ok := yyDollar[2].valExpr.(NumVal); ok {
 				switch yyDollar[1].byt {
 				case '-':
-					yyVAL.valExpr = append(NumVal("-"), num...)
+					if 0 < len(num) && num[0] == '-' {
+						yyVAL.valExpr = num[1:]
+					} else {
+						yyVAL.valExpr = append(NumVal("-"), num...)
+					}
 				case '+':
 					yyVAL.valExpr = num
 				default:
@@ -1707,261 +1805,354 @@ yydefault:
 				yyVAL.valExpr = &UnaryExpr{Operator: yyDollar[1].byt, Expr: yyDollar[2].valExpr}
 			}
 		}
-	case 144:
+	case 150:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:836
+//line ./sqlparser/sql.y:893
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes}
+			yyVAL.valExpr = &JsonExtractExpr{Operator: AST_JSON_EXTRACT, Column: yyDollar[1].colName, Path: StrVal(yyDollar[3].bytes)}
 		}
-	case 145:
+	case 151:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line ./sqlparser/sql.y:897
+		{
+			yyVAL.valExpr = &JsonExtractExpr{Operator: AST_JSON_UNQUOTE_EXTRACT, Column: yyDollar[1].colName, Path: StrVal(yyDollar[3].bytes)}
+		}
+	case 152:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line ./sqlparser/sql.y:901
+		{
+			yyVAL.valExpr = yyDollar[1].funcExpr
+		}
+	case 153:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line ./sqlparser/sql.y:905
+		{
+			yyVAL.valExpr = &WindowFuncExpr{Func: yyDollar[1].funcExpr, Over: yyDollar[4].windowSpec}
+		}
+	case 154:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line ./sqlparser/sql.y:909
+		{
+			yyVAL.valExpr = yyDollar[1].caseExpr
+		}
+	case 155:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line ./sqlparser/sql.y:915
+		{
+			yyVAL.funcExpr = &FuncExpr{Name: yyDollar[1].bytes}
+		}
+	case 156:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:840
+//line ./sqlparser/sql.y:919
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
+			yyVAL.funcExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
 		}
-	case 146:
+	case 157:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:844
+//line ./sqlparser/sql.y:923
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Distinct: true, Exprs: yyDollar[4].selectExprs}
+			yyVAL.funcExpr = &FuncExpr{Name: yyDollar[1].bytes, Distinct: true, Exprs: yyDollar[4].selectExprs}
 		}
-	case 147:
+	case 158:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:848
+//line ./sqlparser/sql.y:927
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
+			yyVAL.funcExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
 		}
-	case 148:
+	case 159:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line ./sqlparser/sql.y:933
+		{
+			yyVAL.windowSpec = &WindowSpec{PartitionBy: yyDollar[1].valExprs, OrderBy: yyDollar[2].orderBy, Frame: yyDollar[3].windowFrame}
+		}
+	case 160:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line ./sqlparser/sql.y:938
+		{
+			yyVAL.valExprs = nil
+		}
+	case 161:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line ./sqlparser/sql.y:942
+		{
+			yyVAL.valExprs = yyDollar[3].valExprs
+		}
+	case 162:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line ./sqlparser/sql.y:947
+		{
+			yyVAL.windowFrame = nil
+		}
+	case 163:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line ./sqlparser/sql.y:951
+		{
+			yyVAL.windowFrame = &WindowFrame{Unit: yyDollar[1].str, Start: yyDollar[2].frameBound}
+		}
+	case 164:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line ./sqlparser/sql.y:955
+		{
+			yyVAL.windowFrame = &WindowFrame{Unit: yyDollar[1].str, Start: yyDollar[3].frameBound, End: yyDollar[5].frameBound}
+		}
+	case 165:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:852
+//line ./sqlparser/sql.y:961
 		{
-			yyVAL.valExpr = yyDollar[1].caseExpr
+			yyVAL.str = AST_ROWS
 		}
-	case 149:
+	case 166:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:858
+//line ./sqlparser/sql.y:965
+		{
+			yyVAL.str = AST_RANGE
+		}
+	case 167:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line ./sqlparser/sql.y:971
+		{
+			if bytes.Equal(yyDollar[2].bytes, ROW) {
+				if col, ok := yyDollar[1].valExpr.(*ColName); !ok || col.Qualifier != nil || !bytes.Equal(col.Name, CURRENT) {
+					yylex.Error("expecting current row")
+					return 1
+				}
+			} else if !bytes.Equal(yyDollar[2].bytes, PRECEDING) && !bytes.Equal(yyDollar[2].bytes, FOLLOWING) {
+				yylex.Error("expecting preceding or following")
+				return 1
+			}
+			yyVAL.frameBound = &FrameBound{Expr: yyDollar[1].valExpr, Type: string(yyDollar[2].bytes)}
+		}
+	case 168:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line ./sqlparser/sql.y:986
 		{
 			yyVAL.bytes = IF_BYTES
 		}
-	case 150:
+	case 169:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:862
+//line ./sqlparser/sql.y:990
 		{
 			yyVAL.bytes = VALUES_BYTES
 		}
-	case 151:
+	case 170:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:868
+//line ./sqlparser/sql.y:996
 		{
 			yyVAL.byt = AST_UPLUS
 		}
-	case 152:
+	case 171:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:872
+//line ./sqlparser/sql.y:1000
 		{
 			yyVAL.byt = AST_UMINUS
 		}
-	case 153:
+	case 172:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:876
+//line ./sqlparser/sql.y:1004
 		{
 			yyVAL.byt = AST_TILDA
 		}
-	case 154:
+	case 173:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:882
+//line ./sqlparser/sql.y:1010
 		{
 			yyVAL.caseExpr = &CaseExpr{Expr: yyDollar[2].valExpr, Whens: yyDollar[3].whens, Else: yyDollar[4].valExpr}
 		}
-	case 155:
+	case 174:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:887
+//line ./sqlparser/sql.y:1015
 		{
 			yyVAL.valExpr = nil
 		}
-	case 156:
+	case 175:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:891
+//line ./sqlparser/sql.y:1019
 		{
 			yyVAL.valExpr = yyDollar[1].valExpr
 		}
-	case 157:
+	case 176:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:897
+//line ./sqlparser/sql.y:1025
 		{
 			yyVAL.whens = []*When{yyDollar[1].when}
 		}
-	case 158:
+	case 177:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:901
+//line ./sqlparser/sql.y:1029
 		{
 			yyVAL.whens = append(yyDollar[1].whens, yyDollar[2].when)
 		}
-	case 159:
+	case 178:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:907
+//line ./sqlparser/sql.y:1035
 		{
 			yyVAL.when = &When{Cond: yyDollar[2].boolExpr, Val: yyDollar[4].valExpr}
 		}
-	case 160:
+	case 179:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:912
+//line ./sqlparser/sql.y:1040
 		{
 			yyVAL.valExpr = nil
 		}
-	case 161:
+	case 180:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:916
+//line ./sqlparser/sql.y:1044
 		{
 			yyVAL.valExpr = yyDollar[2].valExpr
 		}
-	case 162:
+	case 181:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:922
+//line ./sqlparser/sql.y:1050
 		{
 			yyVAL.colName = &ColName{Name: yyDollar[1].bytes}
 		}
-	case 163:
+	case 182:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:926
+//line ./sqlparser/sql.y:1054
 		{
 			yyVAL.colName = &ColName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
-	case 164:
+	case 183:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:932
+//line ./sqlparser/sql.y:1060
 		{
 			yyVAL.valExpr = StrVal(yyDollar[1].bytes)
 		}
-	case 165:
+	case 184:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line ./sqlparser/sql.y:1064
+		{
+			yyVAL.valExpr = &CharsetStrVal{Charset: yyDollar[1].bytes, Val: StrVal(yyDollar[2].bytes)}
+		}
+	case 185:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:936
+//line ./sqlparser/sql.y:1068
 		{
 			yyVAL.valExpr = NumVal(yyDollar[1].bytes)
 		}
-	case 166:
+	case 186:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:940
+//line ./sqlparser/sql.y:1072
 		{
 			yyVAL.valExpr = ValArg(yyDollar[1].bytes)
 		}
-	case 167:
+	case 187:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:944
+//line ./sqlparser/sql.y:1076
 		{
 			yyVAL.valExpr = &NullVal{}
 		}
-	case 168:
+	case 188:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:949
+//line ./sqlparser/sql.y:1081
 		{
 			yyVAL.valExprs = nil
 		}
-	case 169:
+	case 189:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:953
+//line ./sqlparser/sql.y:1085
 		{
 			yyVAL.valExprs = yyDollar[3].valExprs
 		}
-	case 170:
+	case 190:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:958
+//line ./sqlparser/sql.y:1090
 		{
 			yyVAL.boolExpr = nil
 		}
-	case 171:
+	case 191:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:962
+//line ./sqlparser/sql.y:1094
 		{
 			yyVAL.boolExpr = yyDollar[2].boolExpr
 		}
-	case 172:
+	case 192:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:967
+//line ./sqlparser/sql.y:1099
 		{
 			yyVAL.orderBy = nil
 		}
-	case 173:
+	case 193:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:971
+//line ./sqlparser/sql.y:1103
 		{
 			yyVAL.orderBy = yyDollar[3].orderBy
 		}
-	case 174:
+	case 194:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:977
+//line ./sqlparser/sql.y:1109
 		{
 			yyVAL.orderBy = OrderBy{yyDollar[1].order}
 		}
-	case 175:
+	case 195:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:981
+//line ./sqlparser/sql.y:1113
 		{
 			yyVAL.orderBy = append(yyDollar[1].orderBy, yyDollar[3].order)
 		}
-	case 176:
+	case 196:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:987
+//line ./sqlparser/sql.y:1119
 		{
 			yyVAL.order = &Order{Expr: yyDollar[1].valExpr, Direction: yyDollar[2].str}
 		}
-	case 177:
+	case 197:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:992
+//line ./sqlparser/sql.y:1124
 		{
 			yyVAL.str = AST_ASC
 		}
-	case 178:
+	case 198:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:996
+//line ./sqlparser/sql.y:1128
 		{
 			yyVAL.str = AST_ASC
 		}
-	case 179:
+	case 199:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1000
+//line ./sqlparser/sql.y:1132
 		{
 			yyVAL.str = AST_DESC
 		}
-	case 180:
+	case 200:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1005
+//line ./sqlparser/sql.y:1137
 		{
 			yyVAL.limit = nil
 		}
-	case 181:
+	case 201:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:1009
+//line ./sqlparser/sql.y:1141
 		{
 			yyVAL.limit = &Limit{Rowcount: yyDollar[2].valExpr}
 		}
-	case 182:
+	case 202:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:1013
+//line ./sqlparser/sql.y:1145
 		{
 			yyVAL.limit = &Limit{Offset: yyDollar[2].valExpr, Rowcount: yyDollar[4].valExpr}
 		}
-	case 183:
+	case 203:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:1017
+//line ./sqlparser/sql.y:1149
 		{
 			yyVAL.limit = &Limit{Offset: yyDollar[4].valExpr, Rowcount: yyDollar[2].valExpr}
 		}
-	case 184:
+	case 204:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1022
+//line ./sqlparser/sql.y:1154
 		{
 			yyVAL.str = ""
 		}
-	case 185:
+	case 205:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:1026
+//line ./sqlparser/sql.y:1158
 		{
 			yyVAL.str = AST_FOR_UPDATE
 		}
-	case 186:
+	case 206:
 		yyDollar = yyS[yypt-4 : yypt+1]
-		//line ./sqlparser/sql.y:1030
+//line ./sqlparser/sql.y:1162
 		{
 			if !bytes.Equal(yyDollar[3].bytes, SHARE) {
 				yylex.Error("expecting share")
@@ -1973,189 +2164,189 @@ yydefault:
 			}
 			yyVAL.str = AST_SHARE_MODE
 		}
-	case 187:
+	case 207:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1043
+//line ./sqlparser/sql.y:1175
 		{
 			yyVAL.columns = nil
 		}
-	case 188:
+	case 208:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1047
+//line ./sqlparser/sql.y:1179
 		{
 			yyVAL.columns = yyDollar[2].columns
 		}
-	case 189:
+	case 209:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1053
+//line ./sqlparser/sql.y:1185
 		{
 			yyVAL.columns = Columns{&NonStarExpr{Expr: yyDollar[1].colName}}
 		}
-	case 190:
+	case 210:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1057
+//line ./sqlparser/sql.y:1189
 		{
 			yyVAL.columns = append(yyVAL.columns, &NonStarExpr{Expr: yyDollar[3].colName})
 		}
-	case 191:
+	case 211:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1062
+//line ./sqlparser/sql.y:1194
 		{
 			yyVAL.updateExprs = nil
 		}
-	case 192:
+	case 212:
 		yyDollar = yyS[yypt-5 : yypt+1]
-		//line ./sqlparser/sql.y:1066
+//line ./sqlparser/sql.y:1198
 		{
 			yyVAL.updateExprs = yyDollar[5].updateExprs
 		}
-	case 193:
+	case 213:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1072
+//line ./sqlparser/sql.y:1204
 		{
 			yyVAL.updateExprs = UpdateExprs{yyDollar[1].updateExpr}
 		}
-	case 194:
+	case 214:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1076
+//line ./sqlparser/sql.y:1208
 		{
 			yyVAL.updateExprs = append(yyDollar[1].updateExprs, yyDollar[3].updateExpr)
 		}
-	case 195:
+	case 215:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1082
+//line ./sqlparser/sql.y:1214
 		{
 			yyVAL.updateExpr = &UpdateExpr{Name: yyDollar[1].colName, Expr: yyDollar[3].valExpr}
 		}
-	case 196:
+	case 216:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1086
+//line ./sqlparser/sql.y:1218
 		{
 			yyVAL.updateExpr = &UpdateExpr{Name: yyDollar[1].colName, Expr: StrVal("ON")}
 		}
-	case 197:
+	case 217:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1091
+//line ./sqlparser/sql.y:1223
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 198:
+	case 218:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:1093
+//line ./sqlparser/sql.y:1225
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 199:
+	case 219:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1096
+//line ./sqlparser/sql.y:1228
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 200:
+	case 220:
 		yyDollar = yyS[yypt-3 : yypt+1]
-		//line ./sqlparser/sql.y:1098
+//line ./sqlparser/sql.y:1230
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 201:
+	case 221:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1101
+//line ./sqlparser/sql.y:1233
 		{
 			yyVAL.str = ""
 		}
-	case 202:
+	case 222:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1103
+//line ./sqlparser/sql.y:1235
 		{
 			yyVAL.str = AST_IGNORE
 		}
-	case 203:
+	case 223:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1107
+//line ./sqlparser/sql.y:1239
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 204:
+	case 224:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1109
+//line ./sqlparser/sql.y:1241
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 205:
+	case 225:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1111
+//line ./sqlparser/sql.y:1243
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 206:
+	case 226:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1113
+//line ./sqlparser/sql.y:1245
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 207:
+	case 227:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1115
+//line ./sqlparser/sql.y:1247
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 208:
+	case 228:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1118
+//line ./sqlparser/sql.y:1250
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 209:
+	case 229:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1120
+//line ./sqlparser/sql.y:1252
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 210:
+	case 230:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1123
+//line ./sqlparser/sql.y:1255
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 211:
+	case 231:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1125
+//line ./sqlparser/sql.y:1257
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 212:
+	case 232:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1128
+//line ./sqlparser/sql.y:1260
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 213:
+	case 233:
 		yyDollar = yyS[yypt-2 : yypt+1]
-		//line ./sqlparser/sql.y:1130
+//line ./sqlparser/sql.y:1262
 		{
 			yyVAL.empty = struct{}{}
 		}
-	case 214:
+	case 234:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1134
+//line ./sqlparser/sql.y:1266
 		{
 			yyVAL.bytes = bytes.ToLower(yyDollar[1].bytes)
 		}
-	case 215:
+	case 235:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1139
+//line ./sqlparser/sql.y:1271
 		{
 			ForceEOF(yylex)
 		}
-	case 216:
+	case 236:
 		yyDollar = yyS[yypt-0 : yypt+1]
-		//line ./sqlparser/sql.y:1144
+//line ./sqlparser/sql.y:1276
 		{
 			yyVAL.str = ""
 		}
-	case 217:
+	case 237:
 		yyDollar = yyS[yypt-1 : yypt+1]
-		//line ./sqlparser/sql.y:1148
+//line ./sqlparser/sql.y:1280
 		{
 			yyVAL.str = AST_TABLE
 		}
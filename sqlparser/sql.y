@@ -38,6 +38,10 @@ var (
   MODE  =        []byte("mode")
   IF_BYTES =     []byte("if")
   VALUES_BYTES = []byte("values")
+  CURRENT =      []byte("current")
+  ROW =          []byte("row")
+  PRECEDING =    []byte("preceding")
+  FOLLOWING =    []byte("following")
 )
 
 %}
@@ -75,14 +79,21 @@ var (
   insRows     InsertRows
   updateExprs UpdateExprs
   updateExpr  *UpdateExpr
+  funcExpr    *FuncExpr
+  windowSpec  *WindowSpec
+  windowFrame *WindowFrame
+  frameBound  *FrameBound
+  ctes        []*CommonTableExpr
+  cte         *CommonTableExpr
 }
 
 %token LEX_ERROR
 %token <empty> SELECT INSERT UPDATE DELETE FROM WHERE GROUP HAVING ORDER BY LIMIT FOR
 %token <empty> ALL DISTINCT AS EXISTS NULL ASC DESC VALUES INTO DUPLICATE KEY DEFAULT SET LOCK
-%token <bytes> ID STRING NUMBER VALUE_ARG COMMENT
+%token <bytes> ID STRING NUMBER VALUE_ARG COMMENT INTRODUCER
 %token <empty> '(' '~'
 
+%left <empty> WITH
 %left <empty> UNION MINUS EXCEPT INTERSECT
 %left <empty> ','
 %left <empty> JOIN STRAIGHT_JOIN LEFT RIGHT INNER OUTER CROSS NATURAL USE FORCE
@@ -99,6 +110,7 @@ var (
 %left <empty> '+' '-'
 %left <empty> '*' '/' '%'
 %left <empty> '^'
+%left <empty> JSON_EXTRACT_OP JSON_UNQUOTE_EXTRACT_OP
 %nonassoc <empty> '.'
 %left <empty> UNARY
 
@@ -127,6 +139,9 @@ var (
 // truncate 
 %token <empty> TRUNCATE
 
+//MySQL 8
+%token <empty> RECURSIVE OVER PARTITION ROWS RANGE
+
 %start any_command
 
 %type <statement> command
@@ -185,6 +200,15 @@ var (
 %type <statement> admin_statement
 %type <statement> use_statement
 %type <statement> truncate_statement
+%type <str> recursive_opt
+%type <ctes> cte_list
+%type <cte> cte
+%type <funcExpr> function_expression
+%type <windowSpec> window_spec
+%type <valExprs> partition_by_opt
+%type <windowFrame> window_frame_opt
+%type <str> window_frame_unit
+%type <frameBound> window_frame_bound
 
 %%
 
@@ -228,6 +252,35 @@ select_statement:
   {
     $$ = &Union{Type: $2, Left: $1, Right: $3}
   }
+| WITH recursive_opt cte_list select_statement %prec WITH
+  {
+    $$ = &With{Recursive: $2, Ctes: $3, Select: $4}
+  }
+
+recursive_opt:
+  {
+    $$ = ""
+  }
+| RECURSIVE
+  {
+    $$ = AST_RECURSIVE
+  }
+
+cte_list:
+  cte
+  {
+    $$ = []*CommonTableExpr{$1}
+  }
+| cte_list ',' cte
+  {
+    $$ = append($1, $3)
+  }
+
+cte:
+  ID column_list_opt AS subquery
+  {
+    $$ = &CommonTableExpr{Name: $1, Columns: $2, Subquery: $4}
+  }
 
 
 insert_statement:
@@ -444,9 +497,9 @@ distinct_opt:
   {
     $$ = ""
   }
-| DISTINCT
+| DISTINCT comment_opt
   {
-    $$ = AST_DISTINCT
+    $$ = AST_DISTINCT + String(Comments($2))
   }
 
 select_expression_list:
@@ -822,7 +875,11 @@ value_expression:
     if num, ok := $2.(NumVal); ok {
       switch $1 {
       case '-':
-        $$ = append(NumVal("-"), num...)
+        if 0 < len(num) && num[0] == '-' {
+          $$ = num[1:]
+        } else {
+          $$ = append(NumVal("-"), num...)
+        }
       case '+':
         $$ = num
       default:
@@ -832,7 +889,29 @@ value_expression:
       $$ = &UnaryExpr{Operator: $1, Expr: $2}
     }
   }
-| sql_id '(' ')'
+| column_name JSON_EXTRACT_OP STRING
+  {
+    $$ = &JsonExtractExpr{Operator: AST_JSON_EXTRACT, Column: $1, Path: StrVal($3)}
+  }
+| column_name JSON_UNQUOTE_EXTRACT_OP STRING
+  {
+    $$ = &JsonExtractExpr{Operator: AST_JSON_UNQUOTE_EXTRACT, Column: $1, Path: StrVal($3)}
+  }
+| function_expression
+  {
+    $$ = $1
+  }
+| function_expression OVER '(' window_spec ')'
+  {
+    $$ = &WindowFuncExpr{Func: $1, Over: $4}
+  }
+| case_expression
+  {
+    $$ = $1
+  }
+
+function_expression:
+  sql_id '(' ')'
   {
     $$ = &FuncExpr{Name: $1}
   }
@@ -848,9 +927,58 @@ value_expression:
   {
     $$ = &FuncExpr{Name: $1, Exprs: $3}
   }
-| case_expression
+
+window_spec:
+  partition_by_opt order_by_opt window_frame_opt
   {
-    $$ = $1
+    $$ = &WindowSpec{PartitionBy: $1, OrderBy: $2, Frame: $3}
+  }
+
+partition_by_opt:
+  {
+    $$ = nil
+  }
+| PARTITION BY value_expression_list
+  {
+    $$ = $3
+  }
+
+window_frame_opt:
+  {
+    $$ = nil
+  }
+| window_frame_unit window_frame_bound
+  {
+    $$ = &WindowFrame{Unit: $1, Start: $2}
+  }
+| window_frame_unit BETWEEN window_frame_bound AND window_frame_bound
+  {
+    $$ = &WindowFrame{Unit: $1, Start: $3, End: $5}
+  }
+
+window_frame_unit:
+  ROWS
+  {
+    $$ = AST_ROWS
+  }
+| RANGE
+  {
+    $$ = AST_RANGE
+  }
+
+window_frame_bound:
+  value_expression sql_id
+  {
+    if bytes.Equal($2, ROW) {
+      if col, ok := $1.(*ColName); !ok || col.Qualifier != nil || !bytes.Equal(col.Name, CURRENT) {
+        yylex.Error("expecting current row")
+        return 1
+      }
+    } else if !bytes.Equal($2, PRECEDING) && !bytes.Equal($2, FOLLOWING) {
+      yylex.Error("expecting preceding or following")
+      return 1
+    }
+    $$ = &FrameBound{Expr: $1, Type: string($2)}
   }
 
 keyword_as_func:
@@ -932,6 +1060,10 @@ value:
   {
     $$ = StrVal($1)
   }
+| INTRODUCER STRING
+  {
+    $$ = &CharsetStrVal{Charset: $1, Val: StrVal($2)}
+  }
 | NUMBER
   {
     $$ = NumVal($1)
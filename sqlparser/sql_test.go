@@ -55,3 +55,101 @@ func TestMixer(t *testing.T) {
 	sql = "show proxy abc"
 	testParse(t, sql)
 }
+
+func testFormat(t *testing.T, sql string, expect string) {
+	stmt, err := Parse(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := String(stmt); s != expect {
+		t.Fatalf("sql %s, expect %s, got %s", sql, expect, s)
+	}
+}
+
+func TestWith(t *testing.T) {
+	testFormat(t, "with t as (select id from a) select * from t",
+		"with t as (select id from a) select * from t")
+	testFormat(t, "WITH RECURSIVE t(n) AS (select 1 union all select n+1 from t where n < 5) select n from t",
+		"with recursive t(n) as (select 1 union all select n+1 from t where n < 5) select n from t")
+	testFormat(t, "with t1 as (select 1), t2 as (select 2) select * from t1 union select * from t2",
+		"with t1 as (select 1), t2 as (select 2) select * from t1 union select * from t2")
+
+	stmt, err := Parse("with t as (select id from a) select * from t union select * from b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stmt.(*With).Select.(*Union); !ok {
+		t.Fatal("with must apply to the whole union")
+	}
+}
+
+func TestWindowFunc(t *testing.T) {
+	testFormat(t, "select id, row_number() over (partition by uid order by ctime desc) from t",
+		"select id, row_number() over (partition by uid order by ctime desc) from t")
+	testFormat(t, "select sum(n) over () from t",
+		"select sum(n) over () from t")
+	testFormat(t, "select sum(n) over (order by id rows between unbounded preceding and current row) from t",
+		"select sum(n) over (order by id asc rows between unbounded preceding and current row) from t")
+	testFormat(t, "select avg(n) over (range 1 preceding) from t",
+		"select avg(n) over (range 1 preceding) from t")
+
+	if _, err := Parse("select sum(n) over (rows 1 after) from t"); err == nil {
+		t.Fatal("must err")
+	}
+	if _, err := Parse("select sum(n) over (rows unbounded row) from t"); err == nil {
+		t.Fatal("must err")
+	}
+}
+
+func TestJsonExtract(t *testing.T) {
+	testFormat(t, "select info->'$.name', t.info->>'$.age' from t where info->>'$.name' = 'a'",
+		"select info->'$.name', t.info->>'$.age' from t where info->>'$.name' = 'a'")
+	testFormat(t, "select a-b from t",
+		"select a-b from t")
+}
+
+func TestGeneratedColumn(t *testing.T) {
+	sql := "create table t (a int, b int as (a + 1) stored)"
+	testParse(t, sql)
+
+	sql = "alter table t add column c int generated always as (a * 2) virtual"
+	testParse(t, sql)
+}
+
+func TestComments(t *testing.T) {
+	testFormat(t, "select /*+ MAX_EXECUTION_TIME(1000) */ /*! STRAIGHT_JOIN */ a from t",
+		"select /*+ MAX_EXECUTION_TIME(1000) */ /*! STRAIGHT_JOIN */ a from t")
+	testFormat(t, "select distinct /*! SQL_NO_CACHE */ a from t",
+		"select distinct /*! SQL_NO_CACHE */ a from t")
+	testFormat(t, "insert /*+ SET_VAR(sort_buffer_size = 16M) */ into t(a) values (1)",
+		"insert /*+ SET_VAR(sort_buffer_size = 16M) */ into t(a) values (1)")
+	testFormat(t, "update /*+ NO_INDEX_MERGE(t) */ t set a = 1",
+		"update /*+ NO_INDEX_MERGE(t) */ t set a = 1")
+	testFormat(t, "delete /*+ BKA(t) */ from t where a = 1",
+		"delete /*+ BKA(t) */ from t where a = 1")
+	testFormat(t, "replace /*! LOW_PRIORITY */ into t(a) values (1)",
+		"replace /*! LOW_PRIORITY */ into t(a) values (1)")
+	testFormat(t, "truncate /*+ x */ table t",
+		"truncate /*+ x */ table t")
+	testFormat(t, "truncate t",
+		"truncate t")
+
+	//versioned comment out of the comment position is scanned as sql
+	testFormat(t, "select a from t /*!40000 force index(idx) */ where a = 1",
+		"select a from t force index (idx) where a = 1")
+	testFormat(t, "select a from t where a = 1 /*!50000 for update */",
+		"select a from t where a = 1 for update")
+}
+
+func TestLiterals(t *testing.T) {
+	testFormat(t, "select * from t where a = 0x1F and b = X'1f' and c = b'101' and d = 0b101",
+		"select * from t where a = 0x1F and b = X'1f' and c = b'101' and d = 0b101")
+	testFormat(t, "select * from t where a = _binary'abc' and b = _utf8mb4'x'",
+		"select * from t where a = _binary'abc' and b = _utf8mb4'x'")
+	testFormat(t, "select * from t where a = 1.5E+3 and b = - -5 and c = +5",
+		"select * from t where a = 1.5E+3 and b = 5 and c = 5")
+
+	if _, err := Parse("select X'1G'"); err == nil {
+		t.Fatal("invalid hexadecimal literal should fail")
+	}
+}
@@ -40,6 +40,9 @@ type Tokenizer struct {
 	LastError     string
 	posVarIndex   int
 	ParseTree     Statement
+
+	//the tokenizer of the versioned comment being scanned
+	specialComment *Tokenizer
 }
 
 // NewStringTokenizer creates a new Tokenizer for the
@@ -134,6 +137,14 @@ var keywords = map[string]int{
 	"collate":     COLLATE,
 	"offset":      OFFSET,
 	"truncate":    TRUNCATE,
+
+	//MySQL 8
+	"with":      WITH,
+	"recursive": RECURSIVE,
+	"over":      OVER,
+	"partition": PARTITION,
+	"rows":      ROWS,
+	"range":     RANGE,
 }
 
 // Lex returns the next token form the Tokenizer.
@@ -144,10 +155,15 @@ func (tkn *Tokenizer) Lex(lval *yySymType) int {
 		if tkn.AllowComments {
 			break
 		}
+		//mysql executes the versioned comment, scan its content
+		//as sql instead of dropping it
+		if tkn.specialComment == nil && isVersionedComment(val) {
+			tkn.specialComment = NewStringTokenizer(versionedCommentContent(val))
+		}
 		typ, val = tkn.Scan()
 	}
 	switch typ {
-	case ID, STRING, NUMBER, VALUE_ARG, COMMENT:
+	case ID, STRING, NUMBER, VALUE_ARG, COMMENT, INTRODUCER:
 		lval.bytes = val
 	}
 	tkn.errorToken = val
@@ -172,6 +188,14 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 		return 0, nil
 	}
 
+	if tkn.specialComment != nil {
+		typ, val := tkn.specialComment.Scan()
+		if typ != 0 {
+			return typ, val
+		}
+		tkn.specialComment = nil
+	}
+
 	if tkn.lastChar == 0 {
 		tkn.next()
 	}
@@ -213,10 +237,18 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 				return int(ch), nil
 			}
 		case '-':
-			if tkn.lastChar == '-' {
+			switch tkn.lastChar {
+			case '-':
 				tkn.next()
 				return tkn.scanCommentType1("--")
-			} else {
+			case '>':
+				tkn.next()
+				if tkn.lastChar == '>' {
+					tkn.next()
+					return JSON_UNQUOTE_EXTRACT_OP, nil
+				}
+				return JSON_EXTRACT_OP, nil
+			default:
 				return int(ch), nil
 			}
 		case '<':
@@ -275,12 +307,35 @@ func (tkn *Tokenizer) scanIdentifier() (int, []byte) {
 		buffer.WriteByte(byte(tkn.lastChar))
 	}
 	lowered := bytes.ToLower(buffer.Bytes())
+	if tkn.lastChar == '\'' || tkn.lastChar == '"' {
+		switch {
+		case string(lowered) == "x":
+			return tkn.scanQuotedNumber(buffer, 16)
+		case string(lowered) == "b":
+			return tkn.scanQuotedNumber(buffer, 2)
+		case 1 < len(lowered) && lowered[0] == '_':
+			//charset introducer, like _binary'abc'
+			return INTRODUCER, buffer.Bytes()
+		}
+	}
 	if keywordId, found := keywords[string(lowered)]; found {
 		return keywordId, lowered
 	}
 	return ID, buffer.Bytes()
 }
 
+//scan the hexadecimal literal X'1F' or the bit literal b'101'
+func (tkn *Tokenizer) scanQuotedNumber(buffer *bytes.Buffer, base int) (int, []byte) {
+	delim := tkn.lastChar
+	tkn.ConsumeNext(buffer)
+	tkn.scanMantissa(base, buffer)
+	if tkn.lastChar != delim {
+		return LEX_ERROR, buffer.Bytes()
+	}
+	tkn.ConsumeNext(buffer)
+	return NUMBER, buffer.Bytes()
+}
+
 func (tkn *Tokenizer) scanBindVar() (int, []byte) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 8))
 	buffer.WriteByte(byte(tkn.lastChar))
@@ -314,6 +369,10 @@ func (tkn *Tokenizer) scanNumber(seenDecimalPoint bool) (int, []byte) {
 			// hexadecimal int
 			tkn.ConsumeNext(buffer)
 			tkn.scanMantissa(16, buffer)
+		} else if tkn.lastChar == 'b' || tkn.lastChar == 'B' {
+			// bit int
+			tkn.ConsumeNext(buffer)
+			tkn.scanMantissa(2, buffer)
 		} else {
 			// octal int or float
 			seenDecimalDigit := false
@@ -419,6 +478,21 @@ func (tkn *Tokenizer) scanCommentType2() (int, []byte) {
 	return COMMENT, buffer.Bytes()
 }
 
+//versioned comment is like /*!50001 ... */
+func isVersionedComment(comment []byte) bool {
+	return bytes.HasPrefix(comment, []byte("/*!")) && bytes.HasSuffix(comment, []byte("*/"))
+}
+
+//return the sql in versioned comment, without the version number
+func versionedCommentContent(comment []byte) string {
+	content := comment[3 : len(comment)-2]
+	i := 0
+	for i < len(content) && isDigit(uint16(content[i])) {
+		i++
+	}
+	return string(content[i:])
+}
+
 func (tkn *Tokenizer) ConsumeNext(buffer *bytes.Buffer) {
 	if tkn.lastChar == EOFCHAR {
 		// This should never happen.
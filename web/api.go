@@ -76,6 +76,8 @@ type DBStatus struct {
 	LastPing string `json:"laste_ping"`
 	MaxConn  int    `json:"max_conn"`
 	IdleConn int    `json:"idle_conn"`
+	//the health of reads in [0.05, 1], see backend.DB.Health
+	Health float64 `json:"health"`
 }
 
 //get nodes status
@@ -94,6 +96,7 @@ func (s *ApiServer) GetNodesStatus(c echo.Context) error {
 		masterStatus.LastPing = fmt.Sprintf("%v", time.Unix(node.Master.GetLastPing(), 0))
 		masterStatus.MaxConn = node.Cfg.MaxConnNum
 		masterStatus.IdleConn = node.Master.IdleConnCount()
+		masterStatus.Health = node.Master.Health()
 		dbStatus = append(dbStatus, masterStatus)
 
 		//get slaves status
@@ -105,6 +108,7 @@ func (s *ApiServer) GetNodesStatus(c echo.Context) error {
 			slaveStatus.LastPing = fmt.Sprintf("%v", time.Unix(slave.GetLastPing(), 0))
 			slaveStatus.MaxConn = node.Cfg.MaxConnNum
 			slaveStatus.IdleConn = slave.IdleConnCount()
+			slaveStatus.Health = slave.Health()
 			dbStatus = append(dbStatus, slaveStatus)
 		}
 	}
@@ -197,6 +201,42 @@ func (s *ApiServer) ChangeMasterStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, "ok")
 }
 
+//switch the master of node to addr, called by the failover tools
+func (s *ApiServer) SwitchMaster(c echo.Context) error {
+	args := struct {
+		Node string `json:"node"`
+		Addr string `json:"addr"`
+	}{}
+
+	err := c.Bind(&args)
+	if err != nil {
+		return err
+	}
+	args.Addr = strings.TrimSpace(args.Addr)
+	if len(args.Addr) == 0 {
+		return errors.New("addr is empty")
+	}
+	err = s.proxy.SwitchMaster(args.Node, args.Addr)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, "ok")
+}
+
+//the process is alive
+func (s *ApiServer) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, "ok")
+}
+
+//200 if the proxy is ready to serve, otherwise 503 with the reasons
+func (s *ApiServer) Readyz(c echo.Context) error {
+	reasons := s.proxy.Ready()
+	if len(reasons) != 0 {
+		return c.JSON(http.StatusServiceUnavailable, reasons)
+	}
+	return c.JSON(http.StatusOK, "ok")
+}
+
 func (s *ApiServer) GetProxyStatus(c echo.Context) error {
 	status := s.proxy.Status()
 	return c.JSON(http.StatusOK, status)
@@ -260,6 +300,70 @@ func (s *ApiServer) GetProxySchema(c echo.Context) error {
 	return c.JSON(http.StatusOK, shardConfig)
 }
 
+type HotKeyStatus struct {
+	Table     string `json:"table"`
+	Key       string `json:"key"`
+	Count     int64  `json:"count"`
+	Throttled int64  `json:"throttled"`
+	LastHot   string `json:"last_hot"`
+}
+
+//get the hot shard keys in the last window
+func (s *ApiServer) GetHotKeys(c echo.Context) error {
+	hotKeys := s.proxy.GetHotKeys()
+	status := make([]HotKeyStatus, 0, len(hotKeys))
+	for _, hk := range hotKeys {
+		status = append(status, HotKeyStatus{
+			Table:     hk.Table,
+			Key:       hk.Key,
+			Count:     hk.Count,
+			Throttled: hk.Throttled,
+			LastHot:   fmt.Sprintf("%v", time.Unix(hk.LastHot, 0)),
+		})
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+//get the rebalance plan of a sharding table, such as
+///api/v1/proxy/rebalance?db=kingshard&table=orders
+func (s *ApiServer) GetRebalancePlan(c echo.Context) error {
+	plan, err := s.proxy.RebalancePlan(c.QueryParam("db"), c.QueryParam("table"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+type KeyLocation struct {
+	Rules      string `json:"rules"`
+	DB         string `json:"db"`
+	Table      string `json:"table"`
+	Node       string `json:"node"`
+	SubTable   string `json:"sub_table"`
+	TableIndex int    `json:"table_index"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (s *ApiServer) GetKeyLocation(c echo.Context) error {
+	locs := s.proxy.Locate(c.QueryParam("db"), c.QueryParam("table"), c.QueryParam("key"))
+	result := make([]KeyLocation, 0, len(locs))
+	for _, loc := range locs {
+		if loc.Err != nil {
+			result = append(result, KeyLocation{Rules: loc.Rules, Error: loc.Err.Error()})
+			continue
+		}
+		result = append(result, KeyLocation{
+			Rules:      loc.Rules,
+			DB:         loc.DB,
+			Table:      loc.Table,
+			Node:       loc.Node,
+			SubTable:   loc.SubTable,
+			TableIndex: loc.TableIndex,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
 func (s *ApiServer) GetAllBlackSQL(c echo.Context) error {
 	sqls := s.proxy.GetAllBlackSqls()
 	return c.JSON(http.StatusOK, sqls)
@@ -322,6 +426,28 @@ func (s *ApiServer) SwitchSlowSQL(c echo.Context) error {
 	return c.JSON(http.StatusOK, "ok")
 }
 
+//the literals of the sqls in the logs are scrubbed if opt is on
+func (s *ApiServer) SwitchSqlScrub(c echo.Context) error {
+	args := struct {
+		Opt string `json:"opt"`
+	}{}
+
+	err := c.Bind(&args)
+	if err != nil {
+		return err
+	}
+	args.Opt = strings.ToLower(args.Opt)
+	if args.Opt != golog.LogSqlOn && args.Opt != golog.LogSqlOff {
+		return errors.New("opt only can be on or off")
+	}
+
+	err = s.proxy.ChangeLogSqlScrub(args.Opt)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, "ok")
+}
+
 func (s *ApiServer) SetSlowLogTime(c echo.Context) error {
 	args := struct {
 		SlowTime int64 `json:"slow_time"`
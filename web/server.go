@@ -14,6 +14,10 @@
 package web
 
 import (
+	"expvar"
+	"net/http"
+	_ "net/http/pprof"
+	"sync"
 	"time"
 
 	"github.com/flike/kingshard/config"
@@ -25,6 +29,43 @@ import (
 	"github.com/tylerb/graceful"
 )
 
+const (
+	//the urls of the probes, which need no auth
+	HealthzURL = "/healthz"
+	ReadyzURL  = "/readyz"
+)
+
+var (
+	//expvar.Publish panics on a published name, so the vars are published
+	//once in a process, and show the last api server created
+	publishOnce sync.Once
+	varsLock    sync.Mutex
+	varsServer  *ApiServer
+)
+
+func publishVars(s *ApiServer) {
+	varsLock.Lock()
+	varsServer = s
+	varsLock.Unlock()
+	publishOnce.Do(func() {
+		expvar.Publish("kingshard", expvar.Func(func() interface{} {
+			return lastVarsServer().debugVars()
+		}))
+		expvar.Publish("kingshard_stage_times", expvar.Func(func() interface{} {
+			return lastVarsServer().proxy.GetStageTimes()
+		}))
+		expvar.Publish("kingshard_tag_stats", expvar.Func(func() interface{} {
+			return lastVarsServer().proxy.GetTagStats()
+		}))
+	})
+}
+
+func lastVarsServer() *ApiServer {
+	varsLock.Lock()
+	defer varsLock.Unlock()
+	return varsServer
+}
+
 type ApiServer struct {
 	cfg         *config.Config
 	proxy       *server.Server
@@ -42,6 +83,7 @@ func NewApiServer(cfg *config.Config, srv *server.Server) (*ApiServer, error) {
 	s.webUser = cfg.WebUser
 	s.webPassword = cfg.WebPassword
 	s.Echo = echo.New()
+	publishVars(s)
 	golog.Info("web", "NewApiServer", "Api Server running", 0,
 		"netProto",
 		"http",
@@ -69,10 +111,16 @@ func (s *ApiServer) RegisterMiddleware() {
 		Output: golog.GlobalSqlLogger,
 	}))
 	s.Use(mw.Recover())
-	s.Use(mw.BasicAuth(s.CheckAuth))
+	s.Use(mw.BasicAuthWithConfig(mw.BasicAuthConfig{
+		Skipper:   isProbe,
+		Validator: s.CheckAuth,
+	}))
 }
 
 func (s *ApiServer) RegisterURL() {
+	s.Get(HealthzURL, s.Healthz)
+	s.Get(ReadyzURL, s.Readyz)
+
 	s.Get("/api/v1/nodes/status", s.GetNodesStatus)
 
 	s.Post("/api/v1/nodes/slaves", s.AddOneSlave)
@@ -80,12 +128,17 @@ func (s *ApiServer) RegisterURL() {
 	s.Put("/api/v1/nodes/slaves/status", s.ChangeSlaveStatus)
 
 	s.Put("/api/v1/nodes/masters/status", s.ChangeMasterStatus)
+	s.Put("/api/v1/nodes/masters", s.SwitchMaster)
 
 	s.Get("/api/v1/proxy/status", s.GetProxyStatus)
 	s.Put("/api/v1/proxy/status", s.ChangeProxyStatus)
 
 	s.Get("/api/v1/proxy/schema", s.GetProxySchema)
 
+	s.Get("/api/v1/proxy/hot_keys", s.GetHotKeys)
+	s.Get("/api/v1/proxy/rebalance", s.GetRebalancePlan)
+	s.Get("/api/v1/proxy/locate", s.GetKeyLocation)
+
 	s.Get("/api/v1/proxy/allow_ips", s.GetAllowIps)
 	s.Post("/api/v1/proxy/allow_ips", s.AddAllowIps)
 	s.Delete("/api/v1/proxy/allow_ips", s.DelAllowIps)
@@ -96,9 +149,14 @@ func (s *ApiServer) RegisterURL() {
 
 	s.Get("/api/v1/proxy/slow_sql/time", s.GetSlowLogTime)
 	s.Put("/api/v1/proxy/slow_sql/status", s.SwitchSlowSQL)
+	s.Put("/api/v1/proxy/slow_sql/scrub", s.SwitchSqlScrub)
 	s.Put("/api/v1/proxy/slow_sql/time", s.SetSlowLogTime)
 
 	s.Put("/api/v1/proxy/config/save", s.SaveProxyConfig)
+
+	//the pprof and expvar handlers registered in http.DefaultServeMux,
+	//such as /debug/pprof/profile and /debug/vars
+	s.Any("/debug/*", standard.WrapHandler(http.DefaultServeMux))
 }
 
 func (s *ApiServer) CheckAuth(username, password string) bool {
@@ -107,3 +165,17 @@ func (s *ApiServer) CheckAuth(username, password string) bool {
 	}
 	return false
 }
+
+//the runtime statistics published in /debug/vars
+func (s *ApiServer) debugVars() interface{} {
+	vars := make(map[string]string)
+	for _, stat := range s.proxy.DebugStats() {
+		vars[stat.Name] = stat.Value
+	}
+	return vars
+}
+
+func isProbe(c echo.Context) bool {
+	path := c.Request().URL().Path()
+	return path == HealthzURL || path == ReadyzURL
+}